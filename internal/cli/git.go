@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/gitcred"
+	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/spf13/cobra"
+)
+
+var (
+	gitLogContainer string
+	gitLogFormat    string
+)
+
+// gitCmd is the parent command for the git credential proxy
+var gitCmd = &cobra.Command{
+	Use:   "git",
+	Short: "Approve git pushes and review git credential use",
+	Long: `Manage the git credential proxy enabled with [git_credentials] (usually in
+the workspace's .coi.toml).
+
+git in the container reaches the allowed https remotes through a proxy on the
+host, which adds your host's git credentials; the credentials never enter the
+container. With approve_push = true, a push waits until you approve it here.
+
+Examples:
+  coi git pending                   # Pushes waiting for approval
+  coi git approve                   # Approve all of them
+  coi git approve 3                 # Approve request 3
+  coi git deny                      # Deny all of them
+  coi git log --container coi-abc12345-1
+`,
+}
+
+// gitPendingCmd lists requests waiting for approval
+var gitPendingCmd = &cobra.Command{
+	Use:   "pending [container]",
+	Short: "List git pushes waiting for approval",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, control, err := gitControlSocket(args)
+		if err != nil {
+			return err
+		}
+		pending, err := gitcred.ListPending(control)
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		if len(pending) == 0 {
+			fmt.Println("No requests waiting for approval")
+			return nil
+		}
+		fmt.Printf("  %-4s %-10s %-8s %s\n", "ID", "OPERATION", "WAITING", "REMOTE")
+		for _, req := range pending {
+			fmt.Printf("  %-4d %-10s %-8s %s\n", req.ID, req.Operation, time.Since(req.Since).Round(time.Second), req.Remote)
+		}
+		return nil
+	},
+}
+
+// gitApproveCmd approves pending requests
+var gitApproveCmd = &cobra.Command{
+	Use:   "approve [container] [id]",
+	Short: "Approve git pushes waiting for approval (all, or one by id)",
+	Args:  cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return decideGitRequests(args, true)
+	},
+}
+
+// gitDenyCmd denies pending requests
+var gitDenyCmd = &cobra.Command{
+	Use:   "deny [container] [id]",
+	Short: "Deny git pushes waiting for approval (all, or one by id)",
+	Args:  cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return decideGitRequests(args, false)
+	},
+}
+
+// gitLogCmd shows the credential log
+var gitLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show every git credential request and its verdict",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if gitLogFormat != "text" && gitLogFormat != "json" {
+			return fmt.Errorf("invalid format '%s': must be 'text' or 'json'", gitLogFormat)
+		}
+		if cfg.Git.Log == "" {
+			return exitError(1, "no credential log path configured ([git_credentials] log)")
+		}
+
+		err := gitcred.ReadLog(cfg.Git.Log, gitLogContainer, func(entry gitcred.LogEntry) {
+			if gitLogFormat == "json" {
+				data, _ := json.Marshal(entry)
+				fmt.Println(string(data))
+				return
+			}
+			fmt.Println(gitcred.FormatLogEntry(entry))
+		})
+		if os.IsNotExist(err) {
+			fmt.Println("No git credential requests logged yet")
+			return nil
+		}
+		return err
+	},
+}
+
+func init() {
+	gitLogCmd.Flags().StringVar(&gitLogContainer, "container", "", "Only show entries of this container")
+	gitLogCmd.Flags().StringVar(&gitLogFormat, "format", "text", "Output format: text or json")
+
+	gitCmd.AddCommand(gitPendingCmd)
+	gitCmd.AddCommand(gitApproveCmd)
+	gitCmd.AddCommand(gitDenyCmd)
+	gitCmd.AddCommand(gitLogCmd)
+}
+
+// decideGitRequests approves or denies pending requests; args are [container] [id]
+// A single numeric argument is taken as an id for the workspace's container
+func decideGitRequests(args []string, approve bool) error {
+	id := 0
+	if len(args) > 0 {
+		if n, err := strconv.Atoi(args[len(args)-1]); err == nil {
+			id = n
+			args = args[:len(args)-1]
+		}
+	}
+
+	name, control, err := gitControlSocket(args)
+	if err != nil {
+		return err
+	}
+	decided, err := gitcred.DecidePending(control, id, approve)
+	if err != nil {
+		return exitError(1, err.Error())
+	}
+
+	verb := "Approved"
+	if !approve {
+		verb = "Denied"
+	}
+	if decided == 0 {
+		fmt.Printf("No matching requests waiting for approval in %s\n", name)
+		return nil
+	}
+	fmt.Printf("%s %d request(s) in %s\n", verb, decided, name)
+	return nil
+}
+
+// gitControlSocket returns the container and the control socket of its credential proxy
+func gitControlSocket(args []string) (string, string, error) {
+	name, err := workspaceContainer(args)
+	if err != nil {
+		return "", "", exitError(1, err.Error())
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	_, control := session.GitCredentialSockets(filepath.Join(homeDir, ".coi", "run"), name)
+	return name, control, nil
+}
@@ -293,16 +293,12 @@ func imageListCommand(cmd *cobra.Command, args []string) error {
 
 // listAllImages lists all local Incus images
 func listAllImages() error {
-	mgr := container.NewManager("temp")
-
-	// Get list of images using incus image list with sg wrapper
-	output, err := mgr.ExecHostCommand("sg incus-admin -c 'incus image list --format=csv -c l,s,u'", true)
+	images, err := container.GetBackend().ListImages()
 	if err != nil {
 		return fmt.Errorf("failed to list images: %w", err)
 	}
 
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	if len(lines) == 0 || (len(lines) == 1 && lines[0] == "") {
+	if len(images) == 0 {
 		fmt.Println("  No local images found")
 		return nil
 	}
@@ -310,22 +306,17 @@ func listAllImages() error {
 	fmt.Printf("  %-30s %-15s %s\n", "ALIAS", "SIZE", "UPLOAD DATE")
 	fmt.Println("  " + strings.Repeat("-", 70))
 
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Split(line, ",")
-		if len(parts) < 3 {
-			continue
+	for _, img := range images {
+		aliasNames := make([]string, 0, len(img.Aliases))
+		for _, a := range img.Aliases {
+			aliasNames = append(aliasNames, a.Name)
 		}
 
-		alias := parts[0]
-		size := parts[1]
-		uploadDate := parts[2]
+		alias := strings.Join(aliasNames, " ")
+		uploadDate := img.UploadedAt.Format("2006/01/02 15:04 MST")
 
 		// Format size (convert bytes to human readable)
-		sizeFormatted := formatSize(size)
+		sizeFormatted := formatSize(fmt.Sprintf("%d", img.Size))
 
 		fmt.Printf("  %-30s %-15s %s\n", alias, sizeFormatted, uploadDate)
 	}
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/mensfeld/code-on-incus/internal/config"
 	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/mensfeld/code-on-incus/internal/tool"
+	"github.com/mensfeld/code-on-incus/internal/usage"
 	"github.com/spf13/cobra"
 )
 
@@ -50,7 +53,7 @@ func infoCommand(cmd *cobra.Command, args []string) error {
 		sessionID = args[0]
 	} else {
 		// Get latest session
-		sessionID, err = session.GetLatestSession(sessionsDir)
+		sessionID, err = session.GetLatestSession(sessionsDir, toolInstance.ConfigDirName())
 		if err != nil {
 			return fmt.Errorf("no sessions found (specify session ID or use 'coi list --all')")
 		}
@@ -74,11 +77,12 @@ func infoCommand(cmd *cobra.Command, args []string) error {
 		fmt.Fprintf(os.Stderr, "Warning: No metadata found\n")
 	}
 
-	// Check if .claude directory exists
-	statePath := filepath.Join(sessionDir, ".claude")
-	claudeExists := false
-	if info, err := os.Stat(statePath); err == nil && info.IsDir() {
-		claudeExists = true
+	// Check if the tool's config directory exists
+	configDirName := toolInstance.ConfigDirName()
+	statePath := filepath.Join(sessionDir, configDirName)
+	stateExists := false
+	if info, err := os.Stat(statePath); configDirName != "" && err == nil && info.IsDir() {
+		stateExists = true
 	}
 
 	// Display information
@@ -86,6 +90,14 @@ func infoCommand(cmd *cobra.Command, args []string) error {
 	fmt.Printf("===================\n\n")
 	fmt.Printf("Session ID:     %s\n", sessionID)
 
+	if metadata.Name != "" {
+		fmt.Printf("Name:           %s\n", metadata.Name)
+	}
+
+	if len(metadata.Labels) > 0 {
+		fmt.Printf("Labels:         %s\n", session.FormatLabels(metadata.Labels))
+	}
+
 	if metadata.ContainerName != "" {
 		fmt.Printf("Container:      %s\n", metadata.ContainerName)
 	}
@@ -94,29 +106,85 @@ func infoCommand(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Saved At:       %s\n", metadata.SavedAt)
 	}
 
+	if metadata.Workspace != "" {
+		fmt.Printf("Workspace:      %s\n", metadata.Workspace)
+	}
+
+	if metadata.GitStart != nil {
+		fmt.Printf("Git Start:      %s\n", formatGitState(metadata.GitStart))
+	}
+
+	if metadata.GitEnd != nil {
+		fmt.Printf("Git End:        %s\n", formatGitState(metadata.GitEnd))
+	}
+
+	if metadata.ImageFingerprint != "" {
+		fmt.Printf("Image:          %s\n", metadata.ImageFingerprint)
+	}
+
+	if metadata.ToolVersion != "" {
+		fmt.Printf("Tool Version:   %s\n", metadata.ToolVersion)
+	}
+
+	if metadata.NetworkMode != "" {
+		fmt.Printf("Network:        %s\n", metadata.NetworkMode)
+	}
+
+	if metadata.ExitStatus != nil {
+		fmt.Printf("Exit Status:    %d\n", *metadata.ExitStatus)
+	}
+
 	fmt.Printf("Session Data:   ")
-	if claudeExists {
-		fmt.Printf("✓ Present (.claude directory)\n")
+	if stateExists {
+		fmt.Printf("✓ Present (%s directory)\n", configDirName)
 	} else {
 		fmt.Printf("✗ Missing\n")
 	}
 
 	// Show directory size
-	if claudeExists {
+	if stateExists {
 		size, err := getDirSize(statePath)
 		if err == nil {
 			fmt.Printf("Data Size:      %s\n", formatBytes(size))
 		}
 	}
 
+	// Show token usage and estimated cost, for tools that record it
+	if reader, ok := toolInstance.(tool.UsageTool); ok && stateExists {
+		if records, err := reader.ReadUsage(statePath); err == nil && len(records) > 0 {
+			totals := usage.Summarize(records, cfg.Usage.Prices)
+			fmt.Printf("Tokens:         %s input, %s output, %s cache write, %s cache read (%d responses)\n",
+				usage.FormatTokens(totals.Input), usage.FormatTokens(totals.Output),
+				usage.FormatTokens(totals.CacheWrite), usage.FormatTokens(totals.CacheRead), totals.Messages)
+			fmt.Printf("Est. Cost:      $%.2f", totals.Cost)
+			if len(totals.Unpriced) > 0 {
+				fmt.Printf(" (no price for %s)", strings.Join(totals.Unpriced, ", "))
+			}
+			fmt.Println()
+		}
+	}
+
 	fmt.Printf("\nSession Path:   %s\n", sessionDir)
 
+	if metadata.NotesFile != "" {
+		fmt.Printf("Notes:          %s\n", filepath.Join(sessionDir, metadata.NotesFile))
+	}
+
 	// Show resumability
 	fmt.Printf("\nResume:         coi shell --resume %s\n", sessionID)
 
 	return nil
 }
 
+// formatGitState formats a checkout as "branch @ short commit"
+func formatGitState(state *session.GitState) string {
+	commit := state.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	return fmt.Sprintf("%s @ %s", state.Branch, commit)
+}
+
 // getDirSize calculates the total size of a directory
 func getDirSize(path string) (int64, error) {
 	var size int64
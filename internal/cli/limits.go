@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/spf13/cobra"
+)
+
+var (
+	limitCPU          string
+	limitMemory       string
+	limitDisk         string
+	limitMaxProcesses int
+)
+
+// limitsCmd shows or changes the resource limits of a running container
+var limitsCmd = &cobra.Command{
+	Use:   "limits <container-name>",
+	Short: "Show or change resource limits of a container",
+	Long: `Show the resource usage and limits of a container, or change its limits live.
+
+CPU, memory and process limits apply immediately. Growing the root disk of a
+running container depends on the storage driver (zfs, btrfs and lvm support it).
+Changes last for the life of the container; new sessions use the [limits]
+config and the --cpu, --memory, --disk and --max-processes flags of shell and run.
+
+Examples:
+  coi limits coi-abc12345-1                      # Show usage and limits
+  coi limits coi-abc12345-1 --memory 8GiB        # Raise the memory limit
+  coi limits coi-abc12345-1 --cpu 4 --max-processes 2000
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		mgr := container.NewManager(name)
+
+		limits := limitFlags(cmd)
+		if !limits.IsZero() {
+			if err := session.ApplyLimits(mgr, limits); err != nil {
+				return exitError(1, fmt.Sprintf("failed to change limits of %s: %v", name, err))
+			}
+			fmt.Printf("Changed limits of %s: %s\n", name, session.FormatLimits(limits))
+		}
+
+		instance, err := container.GetBackend().GetInstance(name)
+		if err != nil {
+			return exitError(1, fmt.Sprintf("container %s not found: %v", name, err))
+		}
+		printResourceUsage(resourceUsageOf(instance), "")
+		return nil
+	},
+}
+
+func init() {
+	addLimitFlags(shellCmd)
+	addLimitFlags(runCmd)
+	addLimitFlags(limitsCmd)
+}
+
+// addLimitFlags adds the resource limit flags to a command
+func addLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&limitCPU, "cpu", "", "CPU limit: number of CPUs or CPU set (e.g., 2 or 0-3)")
+	cmd.Flags().StringVar(&limitMemory, "memory", "", "Memory limit (e.g., 4GiB or 50%)")
+	cmd.Flags().StringVar(&limitDisk, "disk", "", "Root disk size (e.g., 20GiB)")
+	cmd.Flags().IntVar(&limitMaxProcesses, "max-processes", 0, "Maximum number of processes")
+}
+
+// limitFlags returns the limits set with flags on the command
+func limitFlags(cmd *cobra.Command) config.LimitsConfig {
+	var limits config.LimitsConfig
+	if cmd.Flags().Changed("cpu") {
+		limits.CPU = limitCPU
+	}
+	if cmd.Flags().Changed("memory") {
+		limits.Memory = limitMemory
+	}
+	if cmd.Flags().Changed("disk") {
+		limits.Disk = limitDisk
+	}
+	if cmd.Flags().Changed("max-processes") {
+		limits.MaxProcesses = limitMaxProcesses
+	}
+	return limits
+}
+
+// sessionLimits returns the limits for a new session: config (and profile) limits overridden by flags
+func sessionLimits(cmd *cobra.Command) (config.LimitsConfig, error) {
+	limits := cfg.Limits
+	limits.Merge(limitFlags(cmd))
+	if err := session.ValidateLimits(limits); err != nil {
+		return config.LimitsConfig{}, err
+	}
+	return limits, nil
+}
+
+// ResourceUsage is the usage of a container against its limits ("" or 0 = unlimited)
+type ResourceUsage struct {
+	CPULimit       string `json:"cpu_limit,omitempty"`
+	CPUTime        int64  `json:"cpu_time_seconds"`
+	MemoryUsage    int64  `json:"memory_usage"`
+	MemoryLimit    string `json:"memory_limit,omitempty"`
+	DiskUsage      int64  `json:"disk_usage"`
+	DiskLimit      string `json:"disk_limit,omitempty"`
+	Processes      int64  `json:"processes"`
+	ProcessesLimit string `json:"processes_limit,omitempty"`
+}
+
+// resourceUsageOf returns the usage and limits of an instance (limits may come from profiles)
+func resourceUsageOf(instance *container.Instance) ResourceUsage {
+	usage := ResourceUsage{
+		CPULimit:       instance.ConfigValue("limits.cpu"),
+		MemoryLimit:    instance.ConfigValue("limits.memory"),
+		ProcessesLimit: instance.ConfigValue("limits.processes"),
+	}
+	rootName, root := instance.RootDisk()
+	usage.DiskLimit = root["size"]
+
+	if state := instance.State; state != nil {
+		usage.CPUTime = int64(time.Duration(state.CPU.Usage).Seconds())
+		usage.MemoryUsage = state.Memory.Usage
+		usage.DiskUsage = state.Disk[rootName].Usage
+		usage.Processes = state.Processes
+	}
+	return usage
+}
+
+// printResourceUsage prints usage against limits, one resource per line
+func printResourceUsage(usage ResourceUsage, indent string) {
+	cpuLimit := "no limit"
+	if usage.CPULimit != "" {
+		cpuLimit = "limit " + usage.CPULimit
+	}
+	fmt.Printf("%sCPU: %s used (%s)\n", indent, time.Duration(usage.CPUTime)*time.Second, cpuLimit)
+	fmt.Printf("%sMemory: %s\n", indent, formatUsage(formatBytes(usage.MemoryUsage), usage.MemoryLimit))
+	fmt.Printf("%sDisk: %s\n", indent, formatUsage(formatBytes(usage.DiskUsage), usage.DiskLimit))
+	fmt.Printf("%sProcesses: %s\n", indent, formatUsage(fmt.Sprintf("%d", usage.Processes), usage.ProcessesLimit))
+}
+
+// formatUsage describes a usage against its limit, e.g. "812.0 MB / 4GiB"
+func formatUsage(used, limit string) string {
+	if limit == "" {
+		return used + " (no limit)"
+	}
+	return used + " / " + limit
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
+	"strings"
 
 	"github.com/mensfeld/code-on-incus/internal/config"
 	"github.com/mensfeld/code-on-incus/internal/container"
@@ -17,6 +17,8 @@ import (
 var (
 	listAll    bool
 	listFormat string
+	listName   string
+	listLabels []string
 )
 
 var listCmd = &cobra.Command{
@@ -26,9 +28,14 @@ var listCmd = &cobra.Command{
 
 By default, shows only active containers. Use --all to also show saved sessions.
 
+Sessions can be filtered by name and labels (set with coi shell --name/--label
+or coi session label); containers are filtered by their session's.
+
 Examples:
   coi list
   coi list --all
+  coi list --all --label ticket=ENG-123
+  coi list --all --label team --name flaky
 `,
 	RunE: listCommand,
 }
@@ -36,6 +43,8 @@ Examples:
 func init() {
 	listCmd.Flags().BoolVar(&listAll, "all", false, "Show saved sessions in addition to active containers")
 	listCmd.Flags().StringVar(&listFormat, "format", "text", "Output format: text or json")
+	listCmd.Flags().StringVar(&listName, "name", "", "Only sessions whose name contains this text")
+	listCmd.Flags().StringArrayVar(&listLabels, "label", []string{}, "Only sessions with this label (key=value, or key for any value; repeatable)")
 }
 
 func listCommand(cmd *cobra.Command, args []string) error {
@@ -48,6 +57,11 @@ func listCommand(cmd *cobra.Command, args []string) error {
 	if listFormat != "text" && listFormat != "json" {
 		return fmt.Errorf("invalid format '%s': must be 'text' or 'json'", listFormat)
 	}
+	labelFilter, err := session.ParseLabelFilter(listLabels)
+	if err != nil {
+		return err
+	}
+	filtered := listName != "" || len(labelFilter) > 0
 
 	// Get configured tool to determine tool-specific sessions directory
 	toolInstance, err := getConfiguredTool(cfg)
@@ -74,6 +88,7 @@ func listCommand(cmd *cobra.Command, args []string) error {
 	// because metadata is saved early at session start, before .claude directory exists
 	containerWorkspaces := make(map[string]string)
 	containerPersistent := make(map[string]bool)
+	containerMatches := make(map[string]bool)
 	if entries, err := os.ReadDir(sessionsDir); err == nil {
 		for _, entry := range entries {
 			if !entry.IsDir() {
@@ -85,15 +100,27 @@ func listCommand(cmd *cobra.Command, args []string) error {
 				if err := json.Unmarshal(data, &metadata); err == nil && metadata.ContainerName != "" {
 					containerWorkspaces[metadata.ContainerName] = metadata.Workspace
 					containerPersistent[metadata.ContainerName] = metadata.Persistent
+					containerMatches[metadata.ContainerName] = containerMatches[metadata.ContainerName] || metadata.Matches(listName, labelFilter)
 				}
 			}
 		}
 	}
 
+	// Keep the containers whose session matches the filters
+	if filtered {
+		matching := []ContainerInfo{}
+		for _, c := range containers {
+			if containerMatches[c.Name] {
+				matching = append(matching, c)
+			}
+		}
+		containers = matching
+	}
+
 	// Get saved sessions if --all
 	var sessions []SessionInfo
 	if listAll {
-		sessions, err = listSavedSessions(sessionsDir, toolInstance)
+		sessions, err = listSavedSessions(sessionsDir, toolInstance, listName, labelFilter)
 		if err != nil {
 			return fmt.Errorf("failed to list sessions: %w", err)
 		}
@@ -114,6 +141,8 @@ type ContainerInfo struct {
 	CreatedAt string
 	Image     string
 	IPv4      string
+	IPv6      string
+	Usage     ResourceUsage
 }
 
 // SessionInfo holds information about a saved session
@@ -121,57 +150,48 @@ type SessionInfo struct {
 	ID        string
 	SavedAt   string
 	Workspace string
+	Name      string            `json:",omitempty"`
+	Labels    map[string]string `json:",omitempty"`
 }
 
 // listActiveContainers lists all active claude-on-incus containers
 func listActiveContainers() ([]ContainerInfo, error) {
 	// Use the configured container prefix (respects COI_CONTAINER_PREFIX env var)
 	prefix := session.GetContainerPrefix()
-	pattern := fmt.Sprintf("^%s", prefix)
 
-	output, err := container.IncusOutput("list", pattern, "--format=json")
+	instances, err := container.GetBackend().ListInstances()
 	if err != nil {
 		return nil, err
 	}
 
-	var containers []map[string]interface{}
-	if err := json.Unmarshal([]byte(output), &containers); err != nil {
-		return nil, err
-	}
-
 	var result []ContainerInfo
-	for _, c := range containers {
-		name, _ := c["name"].(string)            // Type assertion, default to "" if fails
-		status, _ := c["status"].(string)        // Type assertion, default to "" if fails
-		createdAt, _ := c["created_at"].(string) // Type assertion, default to "" if fails
-
-		// Get image info
-		config, _ := c["config"].(map[string]interface{}) // Type assertion
-		image, _ := config["image.description"].(string)  // Type assertion
+	for _, c := range instances {
+		if !strings.HasPrefix(c.Name, prefix) {
+			continue
+		}
 
-		// Parse created_at time
+		// Format created_at time
 		createdTime := ""
-		if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
-			createdTime = t.Format("2006-01-02 15:04:05")
+		if !c.CreatedAt.IsZero() {
+			createdTime = c.CreatedAt.Format("2006-01-02 15:04:05")
 		}
 
-		// Extract IPv4 address from eth0 interface
-		ipv4 := extractEth0IPv4(c)
-
 		result = append(result, ContainerInfo{
-			Name:      name,
-			Status:    status,
+			Name:      c.Name,
+			Status:    c.Status,
 			CreatedAt: createdTime,
-			Image:     image,
-			IPv4:      ipv4,
+			Image:     c.Config["image.description"],
+			IPv4:      c.IPv4(), // IPv4 address of eth0 interface
+			IPv6:      c.IPv6(), // Global IPv6 address of eth0 interface
+			Usage:     resourceUsageOf(&c),
 		})
 	}
 
 	return result, nil
 }
 
-// listSavedSessions lists all saved sessions
-func listSavedSessions(sessionsDir string, toolInstance tool.Tool) ([]SessionInfo, error) {
+// listSavedSessions lists the saved sessions matching a name and label filter (see SessionMetadata.Matches)
+func listSavedSessions(sessionsDir string, toolInstance tool.Tool, name string, labelFilter map[string]string) ([]SessionInfo, error) {
 	entries, err := os.ReadDir(sessionsDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -208,16 +228,14 @@ func listSavedSessions(sessionsDir string, toolInstance tool.Tool) ([]SessionInf
 
 		// Try to read metadata
 		metadataPath := filepath.Join(sessionsDir, sessionID, "metadata.json")
-		savedAt := ""
-		workspace := ""
-
+		var metadata session.SessionMetadata
 		if data, err := os.ReadFile(metadataPath); err == nil {
-			var metadata session.SessionMetadata
-			if err := json.Unmarshal(data, &metadata); err == nil {
-				savedAt = metadata.SavedAt
-				workspace = metadata.Workspace
-			}
+			_ = json.Unmarshal(data, &metadata) // Sessions without valid metadata are still listed
+		}
+		if !metadata.Matches(name, labelFilter) {
+			continue
 		}
+		savedAt := metadata.SavedAt
 
 		// Get directory modification time as fallback
 		if savedAt == "" {
@@ -229,56 +247,15 @@ func listSavedSessions(sessionsDir string, toolInstance tool.Tool) ([]SessionInf
 		result = append(result, SessionInfo{
 			ID:        sessionID,
 			SavedAt:   savedAt,
-			Workspace: workspace,
+			Workspace: metadata.Workspace,
+			Name:      metadata.Name,
+			Labels:    metadata.Labels,
 		})
 	}
 
 	return result, nil
 }
 
-// extractEth0IPv4 extracts the IPv4 address from the eth0 interface
-func extractEth0IPv4(container map[string]interface{}) string {
-	// Get state object
-	state, ok := container["state"].(map[string]interface{})
-	if !ok {
-		return ""
-	}
-
-	// Get network object - it's null for stopped containers
-	network, ok := state["network"].(map[string]interface{})
-	if !ok || network == nil {
-		return ""
-	}
-
-	// Get eth0 interface
-	eth0, ok := network["eth0"].(map[string]interface{})
-	if !ok {
-		return ""
-	}
-
-	// Get addresses array
-	addresses, ok := eth0["addresses"].([]interface{})
-	if !ok {
-		return ""
-	}
-
-	// Find the first inet (IPv4) address
-	for _, addr := range addresses {
-		addrMap, ok := addr.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		family, _ := addrMap["family"].(string)
-		if family == "inet" {
-			ip, _ := addrMap["address"].(string)
-			return ip
-		}
-	}
-
-	return ""
-}
-
 // outputJSON formats container and session data as JSON
 func outputJSON(containers []ContainerInfo, sessions []SessionInfo,
 	workspaces map[string]string, persistent map[string]bool,
@@ -293,6 +270,8 @@ func outputJSON(containers []ContainerInfo, sessions []SessionInfo,
 			"image":      c.Image,
 			"persistent": persistent[c.Name],
 			"ipv4":       c.IPv4,
+			"ipv6":       c.IPv6,
+			"resources":  c.Usage,
 		}
 		if ws, ok := workspaces[c.Name]; ok {
 			item["workspace"] = ws
@@ -342,10 +321,16 @@ func outputText(containers []ContainerInfo, sessions []SessionInfo,
 			if c.IPv4 != "" {
 				fmt.Printf("    IPv4: %s\n", c.IPv4)
 			}
+			if c.IPv6 != "" {
+				fmt.Printf("    IPv6: %s\n", c.IPv6)
+			}
 			fmt.Printf("    Created: %s\n", c.CreatedAt)
 			if c.Image != "" {
 				fmt.Printf("    Image: %s\n", c.Image)
 			}
+			if c.Status == "Running" {
+				printResourceUsage(c.Usage, "    ")
+			}
 			// Show workspace if we have it from session metadata
 			if workspace, ok := workspaces[c.Name]; ok && workspace != "" {
 				fmt.Printf("    Workspace: %s\n", workspace)
@@ -362,11 +347,18 @@ func outputText(containers []ContainerInfo, sessions []SessionInfo,
 			fmt.Println("  (none)")
 		} else {
 			for _, s := range sessions {
-				fmt.Printf("  %s\n", s.ID)
+				if s.Name != "" {
+					fmt.Printf("  %s (%s)\n", s.ID, s.Name)
+				} else {
+					fmt.Printf("  %s\n", s.ID)
+				}
 				fmt.Printf("    Saved: %s\n", s.SavedAt)
 				if s.Workspace != "" {
 					fmt.Printf("    Workspace: %s\n", s.Workspace)
 				}
+				if len(s.Labels) > 0 {
+					fmt.Printf("    Labels: %s\n", session.FormatLabels(s.Labels))
+				}
 			}
 		}
 	}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -35,6 +36,7 @@ func ParseMountConfig(cfg *config.Config, mountPairs []string) (*session.MountCo
 			HostPath:      absHost,
 			ContainerPath: filepath.Clean(cfgMount.Container),
 			DeviceName:    fmt.Sprintf("mount-%d", deviceNameCounter),
+			ReadOnly:      cfgMount.ReadOnly,
 		})
 		deviceNameCounter++
 	}
@@ -42,13 +44,25 @@ func ParseMountConfig(cfg *config.Config, mountPairs []string) (*session.MountCo
 	// Step 2: Add --mount flags (can override config mounts)
 	for _, pair := range mountPairs {
 		parts := strings.Split(pair, ":")
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("invalid mount format '%s': expected HOST:CONTAINER", pair)
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("invalid mount format '%s': expected HOST:CONTAINER[:ro|rw]", pair)
 		}
 
 		hostPath := strings.TrimSpace(parts[0])
 		containerPath := strings.TrimSpace(parts[1])
 
+		// Optional access mode suffix
+		readOnly := false
+		if len(parts) == 3 {
+			switch strings.TrimSpace(parts[2]) {
+			case "ro":
+				readOnly = true
+			case "rw":
+			default:
+				return nil, fmt.Errorf("invalid mount mode '%s' in '%s': expected ro or rw", parts[2], pair)
+			}
+		}
+
 		// Expand host path
 		hostPath = config.ExpandPath(hostPath)
 		absHost, err := filepath.Abs(hostPath)
@@ -68,6 +82,7 @@ func ParseMountConfig(cfg *config.Config, mountPairs []string) (*session.MountCo
 			if m.ContainerPath == containerPath {
 				// CLI mount overrides config/storage mount
 				mountConfig.Mounts[i].HostPath = absHost
+				mountConfig.Mounts[i].ReadOnly = readOnly
 				mountExists = true
 				break
 			}
@@ -78,6 +93,7 @@ func ParseMountConfig(cfg *config.Config, mountPairs []string) (*session.MountCo
 				HostPath:      absHost,
 				ContainerPath: containerPath,
 				DeviceName:    fmt.Sprintf("mount-%d", deviceNameCounter),
+				ReadOnly:      readOnly,
 			})
 			deviceNameCounter++
 		}
@@ -85,3 +101,39 @@ func ParseMountConfig(cfg *config.Config, mountPairs []string) (*session.MountCo
 
 	return mountConfig, nil
 }
+
+// ParseMaskPatterns combines the [mounts] mask config with --mask flags
+func ParseMaskPatterns(cfg *config.Config, maskFlags []string) ([]string, error) {
+	patterns := make([]string, 0, len(cfg.Mounts.Mask)+len(maskFlags))
+	patterns = append(patterns, cfg.Mounts.Mask...)
+	patterns = append(patterns, maskFlags...)
+	if err := session.ValidateMaskPatterns(patterns); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// checkSensitiveMounts rejects a workspace or mount that would expose a path on the sensitive-path denylist
+// With --allow-sensitive-mount the paths are mounted anyway, with a warning
+func checkSensitiveMounts(cfg *config.Config, workspacePath string, mountConfig *session.MountConfig) error {
+	deny := session.SensitivePaths(cfg.Mounts.Deny)
+
+	hostPaths := []string{workspacePath}
+	if mountConfig != nil {
+		for _, m := range mountConfig.Mounts {
+			hostPaths = append(hostPaths, m.HostPath)
+		}
+	}
+
+	for _, hostPath := range hostPaths {
+		err := session.CheckSensitivePath(hostPath, deny)
+		if err == nil {
+			continue
+		}
+		if !allowSensitive {
+			return fmt.Errorf("%w - use --allow-sensitive-mount if you really mean it", err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v (allowed by --allow-sensitive-mount)\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,507 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/network"
+	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/spf13/cobra"
+)
+
+// policyUpdateTimeout is how long allow/deny/refresh wait for the session to apply an update
+const policyUpdateTimeout = 30 * time.Second
+
+var (
+	networkContainer string
+	networkDryRun    bool
+	networkDenied    bool
+	networkFollow    bool
+	networkLines     int
+	networkLogFormat string
+)
+
+// networkCmd is the parent command for network isolation operations
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Inspect and change network isolation of running sessions",
+	Long: `Inspect and change the network policy of running sessions.
+
+Changes are applied live by the session that owns the container: domains are
+re-resolved and the firewall rules are replaced without restarting anything.
+In restricted mode, allow and deny edit the exceptions; in allowlist and proxy
+modes they edit allowed_domains. Changes last until the session ends.
+
+allow, deny and refresh only work while the 'coi shell' process that started
+the session is still running, since it is the one that applies them. Sessions
+started with --background, or whose 'coi shell' has exited, cannot be changed;
+their rules stay as they were when the process exited.
+
+Examples:
+  coi network status                                  # Policies of all sessions
+  coi network status coi-abc12345-1                   # Mode, resolved IPs and active rules
+  coi network allow registry.npmjs.org --container coi-abc12345-1
+  coi network deny registry.npmjs.org --container coi-abc12345-1
+  coi network refresh --container coi-abc12345-1      # Re-resolve domains now
+  coi network gc --dry-run                            # Show rules left by crashed sessions
+  coi network log --denied --follow                   # Watch blocked lookups, requests and connections
+`,
+}
+
+// networkStatusCmd shows the network policy of sessions
+var networkStatusCmd = &cobra.Command{
+	Use:   "status [container]",
+	Short: "Show the network mode, resolved IPs and active rules",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cacheManager := networkCacheManager()
+
+		if len(args) == 0 {
+			return printPolicies(cacheManager)
+		}
+		return printPolicyStatus(cacheManager, args[0])
+	},
+}
+
+// networkAllowCmd adds an entry to a session's allowlist (or restricted mode exceptions)
+var networkAllowCmd = &cobra.Command{
+	Use:   "allow <domain|ip|cidr>[:port]",
+	Short: "Allow a destination for a running session",
+	Long: `Allow a destination for a running session.
+
+The change is applied by the 'coi shell' process that started the session and
+fails if that process is no longer running.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entry := args[0]
+		return updateNetworkPolicy(func(policy *network.Policy, entries []string) ([]string, error) {
+			if policy.Mode == config.NetworkModeAllowlist && !policy.DNSProxy && network.IsWildcardDomain(entry) {
+				return nil, fmt.Errorf("wildcard entries require dns_proxy = true")
+			}
+			return network.AddEntry(entries, entry)
+		}, "Allowed "+entry)
+	},
+}
+
+// networkDenyCmd removes an entry from a session's allowlist (or restricted mode exceptions)
+var networkDenyCmd = &cobra.Command{
+	Use:   "deny <domain|ip|cidr>[:port]",
+	Short: "Remove an allowed destination from a running session",
+	Long: `Remove an allowed destination from a running session.
+
+Without a port, all entries for the host are removed. Connections that are
+already established are kept. The change is applied by the 'coi shell' process
+that started the session and fails if that process is no longer running.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entry := args[0]
+		return updateNetworkPolicy(func(policy *network.Policy, entries []string) ([]string, error) {
+			return network.RemoveEntry(entries, entry)
+		}, "Denied "+entry)
+	},
+}
+
+// networkRefreshCmd makes a session re-resolve its domains and reapply its rules
+var networkRefreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Re-resolve allowed domains and reapply the rules of a running session",
+	Long: `Re-resolve allowed domains and reapply the rules of a running session.
+
+The rules are reapplied by the 'coi shell' process that started the session and
+the command fails if that process is no longer running.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateNetworkPolicy(nil, "Refreshed network rules")
+	},
+}
+
+// networkGCCmd removes rules, ACLs and policies left behind by sessions that crashed
+var networkGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove firewall rules left behind for containers that no longer exist",
+	Long: `Remove network isolation state left behind by sessions that ended without cleaning up.
+
+Removes firewall rules (firewalld direct rules and nftables chains) for addresses
+no running instance has, network ACLs of deleted containers, and the policies
+and IP caches of sessions that are gone.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instances, err := container.GetBackend().ListInstances()
+		if err != nil {
+			return exitError(1, fmt.Sprintf("failed to list instances: %v", err))
+		}
+
+		active := network.ActiveInstances{
+			Names:     make(map[string]bool),
+			Addresses: make(map[string]bool),
+			ACLPrefix: session.GetContainerPrefix(),
+		}
+		for i := range instances {
+			active.Names[instances[i].Name] = true
+			if !instances[i].Running() {
+				continue
+			}
+			for _, ip := range []string{instances[i].IPv4(), instances[i].IPv6()} {
+				if ip != "" {
+					active.Addresses[ip] = true
+				}
+			}
+		}
+
+		removed, err := network.CollectGarbage(networkCacheManager(), active, networkDryRun)
+		for _, item := range removed {
+			if networkDryRun {
+				fmt.Printf("Would remove %s\n", item)
+			} else {
+				fmt.Printf("Removed %s\n", item)
+			}
+		}
+		if len(removed) == 0 {
+			fmt.Println("Nothing to clean up")
+		}
+		if err != nil {
+			return exitError(1, fmt.Sprintf("some items could not be removed: %v", err))
+		}
+		return nil
+	},
+}
+
+// networkLogCmd shows the network log
+var networkLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show the network log (DNS lookups, proxy requests and connections)",
+	Long: `Show the network log written to the [network.logging] path.
+
+Entries come from the DNS proxy (denied lookups), the egress proxy (proxy mode
+requests) and, with [network.logging] connections = true, the firewall (each
+new connection with its destination, the rule that decided it and a
+reverse-resolved domain).
+
+Examples:
+  coi network log                                   # All entries
+  coi network log --container coi-abc12345-1 --denied
+  coi network log --follow --lines 20               # Last 20 entries, then new ones
+  coi network log --format json                     # Raw JSON lines
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if networkLogFormat != "text" && networkLogFormat != "json" {
+			return fmt.Errorf("invalid format '%s': must be 'text' or 'json'", networkLogFormat)
+		}
+		path := cfg.Network.Logging.Path
+		if path == "" {
+			return exitError(1, "no network log path configured ([network.logging] path)")
+		}
+
+		filter := network.LogFilter{Container: networkContainer, DeniedOnly: networkDenied}
+		print := func(entry network.LogEntry) {
+			if networkLogFormat == "json" {
+				data, _ := json.Marshal(entry)
+				fmt.Println(string(data))
+				return
+			}
+			fmt.Println(network.FormatLogEntry(entry))
+		}
+
+		// Only the last --lines entries of the existing log are printed
+		var entries []network.LogEntry
+		offset, err := network.ReadLog(path, filter, func(entry network.LogEntry) {
+			entries = append(entries, entry)
+			if networkLines > 0 && len(entries) > networkLines {
+				entries = entries[1:]
+			}
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return exitError(1, err.Error())
+		}
+		for _, entry := range entries {
+			print(entry)
+		}
+		if !networkFollow {
+			if errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "No network log at %s yet\n", path)
+			}
+			return nil
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := network.FollowLog(ctx, path, offset, filter, print); err != nil {
+			return exitError(1, err.Error())
+		}
+		return nil
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{networkAllowCmd, networkDenyCmd, networkRefreshCmd} {
+		cmd.Flags().StringVar(&networkContainer, "container", "", "Container of the session (default: the only running session)")
+	}
+	networkGCCmd.Flags().BoolVar(&networkDryRun, "dry-run", false, "Only show what would be removed")
+	networkLogCmd.Flags().StringVar(&networkContainer, "container", "", "Only show entries of this container")
+	networkLogCmd.Flags().BoolVar(&networkDenied, "denied", false, "Only show denied entries")
+	networkLogCmd.Flags().BoolVarP(&networkFollow, "follow", "f", false, "Keep showing new entries")
+	networkLogCmd.Flags().IntVarP(&networkLines, "lines", "n", 0, "Only show the last N existing entries (0 = all)")
+	networkLogCmd.Flags().StringVar(&networkLogFormat, "format", "text", "Output format: text or json")
+
+	networkCmd.AddCommand(networkStatusCmd)
+	networkCmd.AddCommand(networkAllowCmd)
+	networkCmd.AddCommand(networkDenyCmd)
+	networkCmd.AddCommand(networkRefreshCmd)
+	networkCmd.AddCommand(networkGCCmd)
+	networkCmd.AddCommand(networkLogCmd)
+}
+
+// networkCacheManager returns the cache manager holding network policies and IP caches
+func networkCacheManager() *network.CacheManager {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "/tmp"
+	}
+	return network.NewCacheManager(homeDir)
+}
+
+// updateNetworkPolicy asks the session of --container to apply an edited policy and waits for it
+// edit receives the policy's current list; nil keeps the lists (refresh)
+func updateNetworkPolicy(edit func(*network.Policy, []string) ([]string, error), done string) error {
+	cacheManager := networkCacheManager()
+
+	policy, err := sessionPolicy(cacheManager, networkContainer)
+	if err != nil {
+		return exitError(1, err.Error())
+	}
+	if !policy.Editable() {
+		return exitError(1, fmt.Sprintf("container %s uses open network mode, there is nothing to change", policy.Container))
+	}
+
+	pending, err := cacheManager.LoadPolicyUpdate(policy.Container)
+	if err != nil {
+		return exitError(1, err.Error())
+	}
+
+	var editList func([]string) ([]string, error)
+	if edit != nil {
+		editList = func(entries []string) ([]string, error) { return edit(policy, entries) }
+	}
+	update, err := network.NewPolicyUpdate(policy, pending, editList)
+	if err != nil {
+		return exitError(1, err.Error())
+	}
+
+	if err := cacheManager.SavePolicyUpdate(policy.Container, update); err != nil {
+		return exitError(1, err.Error())
+	}
+
+	applied, err := cacheManager.WaitForRevision(policy.Container, update.Revision, policyUpdateTimeout)
+	if err != nil {
+		return exitError(1, err.Error())
+	}
+	if applied.Error != "" {
+		return exitError(1, fmt.Sprintf("the session could not apply the update (previous rules kept): %s", applied.Error))
+	}
+
+	fmt.Fprintf(os.Stderr, "%s for %s\n", done, policy.Container)
+	return nil
+}
+
+// sessionPolicy returns the policy of a running session, or of the only running session if name is ""
+func sessionPolicy(cacheManager *network.CacheManager, name string) (*network.Policy, error) {
+	if name != "" {
+		policy, err := cacheManager.LoadPolicy(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no running session with network isolation for %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !policy.SessionRunning() {
+			return nil, fmt.Errorf("the session for %s is no longer running (use 'coi network gc' to clean up)", name)
+		}
+		return policy, nil
+	}
+
+	policies, err := cacheManager.ListPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	var running []*network.Policy
+	var names []string
+	for _, policy := range policies {
+		if policy.SessionRunning() {
+			running = append(running, policy)
+			names = append(names, policy.Container)
+		}
+	}
+
+	switch len(running) {
+	case 0:
+		return nil, fmt.Errorf("no running session with network isolation")
+	case 1:
+		return running[0], nil
+	default:
+		return nil, fmt.Errorf("several sessions are running, select one with --container: %s", strings.Join(names, ", "))
+	}
+}
+
+// printPolicies prints a summary of all recorded policies
+func printPolicies(cacheManager *network.CacheManager) error {
+	policies, err := cacheManager.ListPolicies()
+	if err != nil {
+		return exitError(1, fmt.Sprintf("failed to list network policies: %v", err))
+	}
+	if len(policies) == 0 {
+		fmt.Println("No network policies recorded (no running sessions)")
+		return nil
+	}
+
+	fmt.Printf("%-30s %-12s %-12s %s\n", "CONTAINER", "MODE", "BACKEND", "SESSION")
+	for _, policy := range policies {
+		fmt.Printf("%-30s %-12s %-12s %s\n", policy.Container, policyModeName(policy), orNone(string(policy.Backend)), sessionState(policy))
+	}
+	return nil
+}
+
+// printPolicyStatus prints a container's policy, resolved IPs and active rules
+// Without a recorded policy, the rules are still looked up by the container's current addresses
+func printPolicyStatus(cacheManager *network.CacheManager, name string) error {
+	policy, err := cacheManager.LoadPolicy(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return exitError(1, err.Error())
+	}
+
+	var backend config.NetworkBackend
+	var addrs network.ContainerAddresses
+
+	fmt.Printf("Container: %s\n", name)
+	if policy != nil {
+		backend = policy.Backend
+		addrs = policy.Addresses
+
+		fmt.Printf("Mode:      %s\n", policyModeName(policy))
+		fmt.Printf("Backend:   %s\n", orNone(string(backend)))
+		fmt.Printf("Addresses: %s\n", formatAddresses(addrs))
+		fmt.Printf("Session:   %s\n", sessionState(policy))
+		fmt.Printf("Applied:   %s (revision %d)\n", policy.AppliedAt.Local().Format("2006-01-02 15:04:05"), policy.Revision)
+		if policy.Error != "" {
+			fmt.Printf("Error:     %s\n", policy.Error)
+		}
+
+		if entries := policy.Entries(); len(entries) > 0 {
+			if policy.Mode == config.NetworkModeRestricted {
+				fmt.Println("\nExceptions:")
+			} else {
+				fmt.Println("\nAllowed:")
+			}
+			for _, entry := range entries {
+				fmt.Printf("  %s\n", entry)
+			}
+		}
+	} else {
+		fmt.Println("Session:   none (no network policy recorded)")
+
+		resolved, err := network.ResolveFirewallBackend(cfg.Network.Backend)
+		if err != nil {
+			return nil // Nothing more to show without a backend (intentional nilerr)
+		}
+		backend = resolved
+		addrs = instanceAddresses(name)
+		fmt.Printf("Backend:   %s\n", backend)
+		fmt.Printf("Addresses: %s\n", formatAddresses(addrs))
+	}
+
+	if cache, err := cacheManager.Load(name); err == nil && len(cache.Domains) > 0 {
+		fmt.Printf("\nResolved IPs (updated %s):\n", cache.LastUpdate.Local().Format("2006-01-02 15:04:05"))
+		domains := make([]string, 0, len(cache.Domains))
+		for domain := range cache.Domains {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+		for _, domain := range domains {
+			fmt.Printf("  %s -> %s\n", domain, strings.Join(cache.Domains[domain], ", "))
+		}
+	}
+
+	if backend == "" || addrs.IPv4 == "" {
+		return nil
+	}
+	firewall, err := network.NewFirewallBackend(backend, name, addrs)
+	if err != nil {
+		return exitError(1, err.Error())
+	}
+	rules, err := firewall.ListRules()
+	if err != nil {
+		return exitError(1, fmt.Sprintf("failed to list rules: %v", err))
+	}
+	fmt.Printf("\nActive rules (%s):\n", backend)
+	if len(rules) == 0 {
+		fmt.Println("  (none)")
+	}
+	for _, rule := range rules {
+		fmt.Printf("  %s\n", rule)
+	}
+	return nil
+}
+
+// instanceAddresses returns the current addresses of an instance (empty if it is not running)
+func instanceAddresses(name string) network.ContainerAddresses {
+	instances, err := container.GetBackend().ListInstances()
+	if err != nil {
+		return network.ContainerAddresses{}
+	}
+	for i := range instances {
+		if instances[i].Name == name {
+			return network.ContainerAddresses{IPv4: instances[i].IPv4(), IPv6: instances[i].IPv6()}
+		}
+	}
+	return network.ContainerAddresses{}
+}
+
+// policyModeName returns the mode of a policy, noting the DNS proxy
+func policyModeName(policy *network.Policy) string {
+	if policy.DNSProxy {
+		return string(policy.Mode) + " (dns_proxy)"
+	}
+	return string(policy.Mode)
+}
+
+// sessionState describes whether the session enforcing a policy is running
+func sessionState(policy *network.Policy) string {
+	if policy.SessionRunning() {
+		return fmt.Sprintf("running (pid %d)", policy.PID)
+	}
+	return "gone (run 'coi network gc' to clean up)"
+}
+
+// formatAddresses formats container addresses for display
+func formatAddresses(addrs network.ContainerAddresses) string {
+	if addrs.IPv4 == "" {
+		return "unknown"
+	}
+	result := addrs.IPv4
+	if addrs.IPv6 != "" {
+		result += ", " + addrs.IPv6
+	}
+	if addrs.GatewayIPv4 != "" {
+		result += " (gateway " + addrs.GatewayIPv4 + ")"
+	}
+	return result
+}
+
+// orNone returns "none" for empty values
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
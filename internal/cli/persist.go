@@ -197,30 +197,10 @@ func findSessionMetadata(sessionsDir, containerName string) (string, error) {
 
 // updatePersistentFlag updates the persistent field in a metadata file
 func updatePersistentFlag(metadataPath string, persistent bool) error {
-	// Load existing metadata
-	metadata, err := session.LoadSessionMetadata(metadataPath)
-	if err != nil {
-		return fmt.Errorf("failed to load metadata: %w", err)
-	}
-
-	// Check if already persistent
-	if metadata.Persistent == persistent {
-		return nil // No change needed
+	if err := session.UpdateMetadata(metadataPath, func(metadata *session.SessionMetadata) {
+		metadata.Persistent = persistent
+	}); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
 	}
-
-	// Update persistent field
-	metadata.Persistent = persistent
-
-	// Write back using same format as cleanup.go:saveMetadata
-	content := fmt.Sprintf(`{
-  "session_id": "%s",
-  "container_name": "%s",
-  "persistent": %t,
-  "workspace": "%s",
-  "saved_at": "%s"
-}
-`, metadata.SessionID, metadata.ContainerName, metadata.Persistent,
-		metadata.Workspace, metadata.SavedAt)
-
-	return os.WriteFile(metadataPath, []byte(content), 0o644)
+	return nil
 }
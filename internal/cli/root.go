@@ -2,8 +2,11 @@ package cli
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/mensfeld/code-on-incus/internal/tool"
 	"github.com/spf13/cobra"
 )
 
@@ -21,6 +24,8 @@ var (
 	profile         string
 	envVars         []string
 	mountPairs      []string // --mount flag for custom mounts
+	maskPatterns    []string // --mask flag for hidden workspace paths
+	allowSensitive  bool     // --allow-sensitive-mount flag to bypass the mount denylist
 	networkMode     string
 
 	// Loaded config
@@ -58,6 +63,16 @@ Examples:
 			return fmt.Errorf("failed to load config: %w", err)
 		}
 
+		// Register user-defined tools from [tools.<name>] tables
+		if err := tool.RegisterDefinitions(cfg.Tools); err != nil {
+			return fmt.Errorf("invalid tool definition: %w", err)
+		}
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			if err := session.CheckToolDefinitions(cfg.Tools, homeDir, session.SensitivePaths(cfg.Mounts.Deny)); err != nil {
+				return fmt.Errorf("invalid tool definition: %w", err)
+			}
+		}
+
 		// Apply profile if specified
 		if profile != "" {
 			if !cfg.ApplyProfile(profile) {
@@ -94,19 +109,31 @@ func init() {
 	rootCmd.PersistentFlags().Lookup("continue").NoOptDefVal = "auto"
 	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Use named profile")
 	rootCmd.PersistentFlags().StringSliceVarP(&envVars, "env", "e", []string{}, "Environment variables (KEY=VALUE)")
-	rootCmd.PersistentFlags().StringArrayVar(&mountPairs, "mount", []string{}, "Mount directory (HOST:CONTAINER, repeatable)")
-	rootCmd.PersistentFlags().StringVar(&networkMode, "network", "", "Network mode: restricted (default), open")
+	rootCmd.PersistentFlags().StringArrayVar(&mountPairs, "mount", []string{}, "Mount directory (HOST:CONTAINER[:ro], repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&allowSensitive, "allow-sensitive-mount", false, "Allow mounting credential directories such as ~/.ssh or the whole home directory (unsafe)")
+	rootCmd.PersistentFlags().StringArrayVar(&maskPatterns, "mask", []string{}, "Hide workspace paths from the agent (e.g., .env, secrets/, *.pem; repeatable)")
+	rootCmd.PersistentFlags().StringVar(&networkMode, "network", "", "Network mode: restricted (default), open, allowlist, proxy")
 
 	// Add subcommands
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(shellCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(infoCmd)
+	rootCmd.AddCommand(usageCmd)
 	rootCmd.AddCommand(buildCmd)
 	rootCmd.AddCommand(imagesCmd)    // Legacy: coi images
 	rootCmd.AddCommand(imageCmd)     // New: coi image <subcommand>
 	rootCmd.AddCommand(containerCmd) // New: coi container <subcommand>
 	rootCmd.AddCommand(fileCmd)      // New: coi file <subcommand>
+	rootCmd.AddCommand(networkCmd)   // New: coi network <subcommand>
+	rootCmd.AddCommand(snapshotCmd)  // New: coi snapshot <subcommand>
+	rootCmd.AddCommand(worktreeCmd)  // New: coi worktree <subcommand>
+	rootCmd.AddCommand(gitCmd)       // New: coi git <subcommand>
+	rootCmd.AddCommand(sessionCmd)   // New: coi session <subcommand>
+	rootCmd.AddCommand(limitsCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(discardCmd)
 	rootCmd.AddCommand(cleanCmd)
 	rootCmd.AddCommand(killCmd)
 	rootCmd.AddCommand(persistCmd)
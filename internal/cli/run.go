@@ -29,6 +29,7 @@ Examples:
   coi run "npm test" --capture
   coi run "pytest" --slot 2
   coi run --workspace ~/project "make build"
+  coi run --memory 2GiB --max-processes 500 "npm test"
 `,
 	Args: cobra.MinimumNArgs(1),
 	RunE: runCommand,
@@ -62,6 +63,11 @@ func runCommand(cmd *cobra.Command, args []string) error {
 		fmt.Fprintf(os.Stderr, "Auto-allocated slot %d\n", slotNum)
 	}
 
+	limits, err := sessionLimits(cmd)
+	if err != nil {
+		return err
+	}
+
 	// Generate container name
 	containerName := session.ContainerName(absWorkspace, slotNum)
 
@@ -91,28 +97,47 @@ func runCommand(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to check if container exists: %w", err)
 	}
 
+	// Limits are set while the container is stopped, so they are in place before anything runs in it
+	applyLimits := func() error {
+		if limits.IsZero() {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "Applying resource limits: %s\n", session.FormatLimits(limits))
+		if err := session.ApplyLimits(mgr, limits); err != nil {
+			return fmt.Errorf("failed to apply resource limits: %w", err)
+		}
+		return nil
+	}
+
 	if containerExists && persistent {
 		// Restart existing persistent container
 		fmt.Fprintf(os.Stderr, "Restarting existing persistent container...\n")
+		if err := applyLimits(); err != nil {
+			return err
+		}
 		if err := mgr.Start(); err != nil {
 			return fmt.Errorf("failed to start container: %w", err)
 		}
-	} else if containerExists {
-		// Ephemeral container with same name exists - delete and recreate
-		fmt.Fprintf(os.Stderr, "Removing existing container...\n")
-		if err := mgr.Delete(true); err != nil {
-			return fmt.Errorf("failed to delete existing container: %w", err)
+	} else {
+		if containerExists {
+			// Ephemeral container with same name exists - delete and recreate
+			fmt.Fprintf(os.Stderr, "Removing existing container...\n")
+			if err := mgr.Delete(true); err != nil {
+				return fmt.Errorf("failed to delete existing container: %w", err)
+			}
 		}
-		// Launch new container
+		// Create new container, then start it once the limits are set
 		ephemeral := !persistent
-		if err := mgr.Launch(img, ephemeral); err != nil {
-			return fmt.Errorf("failed to launch container: %w", err)
+		if err := mgr.Init(img, ephemeral); err != nil {
+			return fmt.Errorf("failed to create container: %w", err)
 		}
-	} else {
-		// Launch new container
-		ephemeral := !persistent
-		if err := mgr.Launch(img, ephemeral); err != nil {
-			return fmt.Errorf("failed to launch container: %w", err)
+		if err := applyLimits(); err != nil {
+			_ = mgr.Delete(true) // Never started, so an ephemeral container is not removed by itself
+			return err
+		}
+		if err := mgr.Start(); err != nil {
+			_ = mgr.Delete(true) // Best effort cleanup
+			return fmt.Errorf("failed to start container: %w", err)
 		}
 	}
 
@@ -140,11 +165,6 @@ func runCommand(cmd *cobra.Command, args []string) error {
 	wasRestarted := containerExists && persistent
 	useShift := !cfg.Incus.DisableShift
 	if !wasRestarted {
-		fmt.Fprintf(os.Stderr, "Mounting workspace %s...\n", absWorkspace)
-		if err := mgr.MountDisk("workspace", absWorkspace, "/workspace", useShift); err != nil {
-			return fmt.Errorf("failed to mount workspace: %w", err)
-		}
-
 		// Parse and validate mount configuration
 		mountConfig, err := ParseMountConfig(cfg, mountPairs)
 		if err != nil {
@@ -156,6 +176,30 @@ func runCommand(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("mount validation failed: %w", err)
 		}
 
+		// Refuse to expose credentials or the whole home directory
+		if err := checkSensitiveMounts(cfg, absWorkspace, mountConfig); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Mounting workspace %s...\n", absWorkspace)
+		if err := mgr.MountDisk("workspace", absWorkspace, "/workspace", useShift); err != nil {
+			return fmt.Errorf("failed to mount workspace: %w", err)
+		}
+
+		// Hide masked workspace paths
+		masks, err := ParseMaskPatterns(cfg, maskPatterns)
+		if err != nil {
+			return fmt.Errorf("invalid mask configuration: %w", err)
+		}
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get home directory: %w", err)
+		}
+		logger := func(msg string) { fmt.Fprintf(os.Stderr, "%s\n", msg) }
+		if err := session.MaskWorkspace(mgr, session.WorkspaceModeDirect, absWorkspace, masks, filepath.Join(homeDir, ".coi", "masks"), useShift, logger); err != nil {
+			return err
+		}
+
 		// Mount all configured directories
 		if mountConfig != nil && len(mountConfig.Mounts) > 0 {
 			for _, mount := range mountConfig.Mounts {
@@ -164,9 +208,14 @@ func runCommand(cmd *cobra.Command, args []string) error {
 					return fmt.Errorf("failed to create mount directory '%s': %w", mount.HostPath, err)
 				}
 
-				fmt.Fprintf(os.Stderr, "Adding mount: %s -> %s\n", mount.HostPath, mount.ContainerPath)
-
-				if err := mgr.MountDisk(mount.DeviceName, mount.HostPath, mount.ContainerPath, useShift); err != nil {
+				if mount.ReadOnly {
+					fmt.Fprintf(os.Stderr, "Adding read-only mount: %s -> %s\n", mount.HostPath, mount.ContainerPath)
+					err = mgr.MountDiskReadOnly(mount.DeviceName, mount.HostPath, mount.ContainerPath, useShift)
+				} else {
+					fmt.Fprintf(os.Stderr, "Adding mount: %s -> %s\n", mount.HostPath, mount.ContainerPath)
+					err = mgr.MountDisk(mount.DeviceName, mount.HostPath, mount.ContainerPath, useShift)
+				}
+				if err != nil {
 					return fmt.Errorf("failed to add mount '%s': %w", mount.DeviceName, err)
 				}
 			}
@@ -178,22 +227,23 @@ func runCommand(cmd *cobra.Command, args []string) error {
 	// Execute command directly (args are already the full command to run)
 	fmt.Fprintf(os.Stderr, "Executing: %s\n", strings.Join(args, " "))
 
-	// Build incus exec command directly with proper args
-	incusArgs := []string{
-		"exec", containerName, "--user", fmt.Sprintf("%d", container.CodeUID),
-		"--group", fmt.Sprintf("%d", container.CodeUID), "--cwd", "/workspace",
+	// Run as the code user in the workspace, with environment variables from -e flags
+	user := container.CodeUID
+	execOpts := container.ExecCommandOptions{
+		User: &user,
+		Cwd:  "/workspace",
+		Env:  make(map[string]string),
 	}
-
-	// Add environment variables from -e flags
 	for _, e := range envVars {
-		incusArgs = append(incusArgs, "--env", e)
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 {
+			execOpts.Env[parts[0]] = parts[1]
+		}
 	}
 
-	incusArgs = append(incusArgs, "--")
-	incusArgs = append(incusArgs, args...)
-
 	// Execute and capture output and exit code
-	output, err := container.IncusOutputWithArgs(incusArgs...)
+	output, err := mgr.ExecArgsCapture(args, execOpts)
+	output = strings.TrimSpace(output)
 
 	// Print output to stdout (not stderr) so it can be captured
 	if output != "" {
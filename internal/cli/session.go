@@ -0,0 +1,519 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/search"
+	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/mensfeld/code-on-incus/internal/tool"
+	"github.com/mensfeld/code-on-incus/internal/transcript"
+	"github.com/mensfeld/code-on-incus/internal/usage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sessionExportOutput     string
+	sessionImportForce      bool
+	sessionTranscriptFormat string
+	sessionTranscriptOutput string
+	sessionSearchSince      string
+	sessionSearchTool       string
+	sessionSearchLimit      int
+	sessionSearchJSON       bool
+	sessionLabelRemove      []string
+	sessionLabelName        string
+)
+
+// sessionCmd is the parent command for moving saved sessions between machines
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Export, import, search, label and review saved sessions",
+	Long: `Move saved sessions between machines as portable archives, find sessions
+by what was discussed in them, label and annotate them, and review what happened.
+
+An archive holds the session's saved tool state and metadata, with a manifest
+(tool, workspace, coi version and file checksums). Credentials are left out;
+fresh ones are injected from the importing machine when the session is resumed.
+
+Examples:
+  coi session export                          # Export the latest session
+  coi session export <session-id> -o s.tar.zst
+  coi session import s.tar.zst                # Keep the original workspace path
+  coi session import s.tar.zst -w ~/src/app   # The workspace is here on this machine
+  coi shell --resume=<session-id> -w ~/src/app
+  coi session transcript                      # Latest session as Markdown
+  coi session transcript <session-id> --format html -o review.html
+  coi session search kafka consumer --since 30d
+  coi session label <session-id> ticket=ENG-123 --name "fix flaky tests"
+  coi session notes <session-id>              # Edit the session's notes
+`,
+}
+
+// sessionExportCmd writes a session archive
+var sessionExportCmd = &cobra.Command{
+	Use:   "export [session-id]",
+	Short: "Export a saved session as an archive (.tar.zst, .tar.gz or .tar)",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		toolInstance, sessionsDir, sessionID, err := resolveSavedSession(args)
+		if err != nil {
+			return err
+		}
+
+		output := sessionExportOutput
+		if output == "" {
+			output = sessionID + session.DefaultArchiveExtension()
+		}
+		manifest, err := exportSessionArchive(sessionsDir, sessionID, toolInstance, output)
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		fmt.Printf("Exported session %s to %s (%d files)\n", sessionID, output, len(manifest.Files))
+		if len(manifest.Stripped) > 0 {
+			fmt.Printf("Left out credentials: %v\n", manifest.Stripped)
+		}
+		return nil
+	},
+}
+
+// sessionImportCmd extracts a session archive
+var sessionImportCmd = &cobra.Command{
+	Use:   "import <archive>",
+	Short: "Import a session archive (its workspace is remapped to --workspace if given)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get home directory: %w", err)
+		}
+
+		opts := session.ImportOptions{Force: sessionImportForce}
+		if cmd.Flags().Changed("workspace") {
+			opts.Workspace, err = filepath.Abs(workspace)
+			if err != nil {
+				return fmt.Errorf("invalid workspace path: %w", err)
+			}
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return exitError(1, fmt.Sprintf("failed to open archive: %v", err))
+		}
+		defer f.Close()
+
+		r, err := session.DecompressArchive(f)
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+		manifest, err := session.ImportSession(r, filepath.Join(homeDir, ".coi"), opts)
+		if closeErr := r.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+		if err != nil {
+			return exitError(1, fmt.Sprintf("import failed: %v", err))
+		}
+
+		target := manifest.Workspace
+		if opts.Workspace != "" {
+			target = opts.Workspace
+		}
+		fmt.Printf("Imported session %s (%s, exported by coi %s)\n", manifest.SessionID, manifest.Tool, manifest.CoiVersion)
+		fmt.Printf("Workspace: %s\n", target)
+		fmt.Printf("\nResume: coi shell --resume=%s -w %s\n", manifest.SessionID, target)
+		return nil
+	},
+}
+
+// sessionTranscriptCmd renders a saved session's conversation
+var sessionTranscriptCmd = &cobra.Command{
+	Use:   "transcript [session-id]",
+	Short: "Render a saved session's conversation (prompts, replies, tool calls and file edits)",
+	Long: `Render the conversation of a saved session: user prompts, assistant messages,
+tool calls with their inputs and outputs, and file edits as diffs.
+
+Long tool outputs are collapsed (<details> blocks in Markdown and HTML).
+Without a session ID, the latest session is rendered.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !slices.Contains(transcript.Formats, sessionTranscriptFormat) {
+			return exitError(2, fmt.Sprintf("invalid format '%s': must be markdown, html or json", sessionTranscriptFormat))
+		}
+		toolInstance, sessionsDir, sessionID, err := resolveSavedSession(args)
+		if err != nil {
+			return err
+		}
+		reader, ok := toolInstance.(tool.TranscriptTool)
+		if !ok {
+			return exitError(1, fmt.Sprintf("transcripts are not supported for %s sessions", toolInstance.Name()))
+		}
+
+		conversations, err := reader.ReadTranscript(filepath.Join(sessionsDir, sessionID, toolInstance.ConfigDirName()))
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+		t := &transcript.Transcript{SessionID: sessionID, Tool: toolInstance.Name(), Conversations: conversations}
+
+		if sessionTranscriptOutput == "" {
+			return transcript.Render(os.Stdout, t, sessionTranscriptFormat)
+		}
+		f, err := os.Create(sessionTranscriptOutput)
+		if err != nil {
+			return exitError(1, fmt.Sprintf("failed to create output file: %v", err))
+		}
+		err = transcript.Render(f, t, sessionTranscriptFormat)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(sessionTranscriptOutput)
+			return exitError(1, err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "Wrote transcript of session %s to %s\n", sessionID, sessionTranscriptOutput)
+		return nil
+	},
+}
+
+// sessionSearchCmd searches the conversations of saved sessions
+var sessionSearchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search the prompts and assistant messages of saved sessions",
+	Long: `Full-text search over the user prompts and assistant messages of saved sessions.
+Results are ranked by relevance (sessions matching more of the words first) and
+shown with a snippet.
+
+The index is kept in ~/.coi/search and updated incrementally: only new and
+changed sessions are read.
+
+Examples:
+  coi session search kafka consumer
+  coi session search "flaky test" --since 30d -w ~/src/app
+  coi session search migration --tool claude --json
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		query := search.Query{Text: strings.Join(args, " "), Tool: sessionSearchTool, Limit: sessionSearchLimit}
+		if sessionSearchSince != "" {
+			since, err := usage.ParseSince(sessionSearchSince, time.Now())
+			if err != nil {
+				return exitError(2, err.Error())
+			}
+			query.Since = since
+		}
+		if sessionSearchTool != "" {
+			if _, err := tool.Get(sessionSearchTool); err != nil {
+				return exitError(2, err.Error())
+			}
+		}
+		if cmd.Flags().Changed("workspace") {
+			absWorkspace, err := filepath.Abs(workspace)
+			if err != nil {
+				return fmt.Errorf("invalid workspace path: %w", err)
+			}
+			query.Workspace = absWorkspace
+		}
+
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get home directory: %w", err)
+		}
+		idx, err := updateSearchIndex(filepath.Join(homeDir, ".coi"))
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+		results := idx.Search(query)
+
+		if sessionSearchJSON {
+			if results == nil {
+				results = []search.Result{}
+			}
+			data, err := json.MarshalIndent(results, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to marshal JSON: %w", err)
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+
+		if len(results) == 0 {
+			fmt.Println("No matching sessions")
+			return nil
+		}
+		for _, r := range results {
+			fmt.Printf("%s  (%s, saved %s)\n", r.SessionID, r.Tool, r.SavedAt.Local().Format("2006-01-02 15:04"))
+			if r.Workspace != "" {
+				fmt.Printf("  Workspace: %s\n", r.Workspace)
+			}
+			fmt.Printf("  %s\n\n", r.Snippet)
+		}
+		fmt.Println("Show a session with: coi session transcript <session-id>")
+		return nil
+	},
+}
+
+// sessionLabelCmd names and labels a session
+var sessionLabelCmd = &cobra.Command{
+	Use:   "label <session-id> [key=value...]",
+	Short: "Set, remove or show a session's labels and name",
+	Long: `Set, remove or show a session's labels and name. Labels can also be set when
+starting a session (coi shell --label key=value --name "...") and filter coi list.
+
+Examples:
+  coi session label <session-id>                          # Show name and labels
+  coi session label <session-id> ticket=ENG-123 team=infra
+  coi session label <session-id> --remove team --name "fix flaky tests"
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		labels, err := session.ParseLabels(args[1:])
+		if err != nil {
+			return exitError(2, err.Error())
+		}
+		metadataPath, err := sessionMetadataPath(args[0])
+		if err != nil {
+			return err
+		}
+
+		if len(labels) > 0 || len(sessionLabelRemove) > 0 || cmd.Flags().Changed("name") {
+			err := session.UpdateMetadata(metadataPath, func(metadata *session.SessionMetadata) {
+				if cmd.Flags().Changed("name") {
+					metadata.Name = sessionLabelName
+				}
+				for _, key := range sessionLabelRemove {
+					delete(metadata.Labels, key)
+				}
+				for key, value := range labels {
+					if metadata.Labels == nil {
+						metadata.Labels = make(map[string]string)
+					}
+					metadata.Labels[key] = value
+				}
+			})
+			if err != nil {
+				return exitError(1, fmt.Sprintf("failed to update session: %v", err))
+			}
+		}
+
+		metadata, err := session.LoadSessionMetadata(metadataPath)
+		if err != nil {
+			return exitError(1, fmt.Sprintf("failed to read session: %v", err))
+		}
+		fmt.Printf("Session: %s\n", metadata.SessionID)
+		if metadata.Name != "" {
+			fmt.Printf("Name:    %s\n", metadata.Name)
+		}
+		if len(metadata.Labels) > 0 {
+			fmt.Printf("Labels:  %s\n", session.FormatLabels(metadata.Labels))
+		} else {
+			fmt.Printf("Labels:  (none)\n")
+		}
+		return nil
+	},
+}
+
+// sessionNotesCmd edits a session's notes file
+var sessionNotesCmd = &cobra.Command{
+	Use:   "notes <session-id>",
+	Short: "Edit a session's notes in $VISUAL or $EDITOR",
+	Long: `Edit a session's notes (notes.md in the session directory) in $VISUAL or
+$EDITOR (default: vi). The notes are kept with the session and exported with it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		metadataPath, err := sessionMetadataPath(args[0])
+		if err != nil {
+			return err
+		}
+		notesPath := filepath.Join(filepath.Dir(metadataPath), session.NotesFileName)
+
+		editor := os.Getenv("VISUAL")
+		if editor == "" {
+			editor = os.Getenv("EDITOR")
+		}
+		if editor == "" {
+			editor = "vi"
+		}
+		// The editor may come with arguments, e.g. "code --wait"
+		editorArgs := append(strings.Fields(editor), notesPath)
+		editorCmd := exec.Command(editorArgs[0], editorArgs[1:]...)
+		editorCmd.Stdin, editorCmd.Stdout, editorCmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := editorCmd.Run(); err != nil {
+			return exitError(1, fmt.Sprintf("editor failed: %v", err))
+		}
+
+		notesFile := ""
+		if info, err := os.Stat(notesPath); err == nil && info.Size() > 0 {
+			notesFile = session.NotesFileName
+		}
+		if err := session.UpdateMetadata(metadataPath, func(metadata *session.SessionMetadata) {
+			metadata.NotesFile = notesFile
+		}); err != nil {
+			return exitError(1, fmt.Sprintf("failed to update session: %v", err))
+		}
+		return nil
+	},
+}
+
+func init() {
+	sessionExportCmd.Flags().StringVarP(&sessionExportOutput, "output", "o", "", "Archive to write (default: <session-id>.tar.zst, or .tar.gz without zstd)")
+	sessionImportCmd.Flags().BoolVar(&sessionImportForce, "force", false, "Replace an existing session with the same ID")
+	sessionTranscriptCmd.Flags().StringVar(&sessionTranscriptFormat, "format", transcript.FormatMarkdown, "Output format: markdown, html or json")
+	sessionTranscriptCmd.Flags().StringVarP(&sessionTranscriptOutput, "output", "o", "", "File to write (default: stdout)")
+	sessionSearchCmd.Flags().StringVar(&sessionSearchSince, "since", "", "Only sessions saved in this period (e.g., 7d, 2w) or since a date (2025-01-02)")
+	sessionSearchCmd.Flags().StringVar(&sessionSearchTool, "tool", "", "Only sessions of this tool (default: all tools)")
+	sessionSearchCmd.Flags().IntVar(&sessionSearchLimit, "limit", 10, "Maximum number of results (0 = all)")
+	sessionSearchCmd.Flags().BoolVar(&sessionSearchJSON, "json", false, "Output as JSON")
+	sessionLabelCmd.Flags().StringArrayVar(&sessionLabelRemove, "remove", []string{}, "Remove a label by key (repeatable)")
+	sessionLabelCmd.Flags().StringVar(&sessionLabelName, "name", "", "Set the session's name (\"\" to clear it)")
+
+	sessionCmd.AddCommand(sessionExportCmd)
+	sessionCmd.AddCommand(sessionImportCmd)
+	sessionCmd.AddCommand(sessionTranscriptCmd)
+	sessionCmd.AddCommand(sessionSearchCmd)
+	sessionCmd.AddCommand(sessionLabelCmd)
+	sessionCmd.AddCommand(sessionNotesCmd)
+}
+
+// sessionMetadataPath returns the metadata file of a session of the configured tool
+// Unlike resolveSavedSession, the session may still be running (not saved yet)
+func sessionMetadataPath(sessionID string) (string, error) {
+	toolInstance, err := getConfiguredTool(cfg)
+	if err != nil {
+		return "", err
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	metadataPath := filepath.Join(session.GetSessionsDir(filepath.Join(homeDir, ".coi"), toolInstance), sessionID, "metadata.json")
+	if _, err := os.Stat(metadataPath); err != nil {
+		return "", exitError(1, fmt.Sprintf("session '%s' not found - check available sessions with: coi list --all", sessionID))
+	}
+	return metadataPath, nil
+}
+
+// updateSearchIndex loads the search index and updates it with the saved sessions of every
+// tool that supports transcripts
+func updateSearchIndex(baseDir string) (*search.Index, error) {
+	var sources []search.Source
+	for _, name := range tool.ListSupported() {
+		t, err := tool.Get(name)
+		if err != nil {
+			continue
+		}
+		reader, ok := t.(tool.TranscriptTool)
+		if !ok {
+			continue
+		}
+
+		sessionsDir := session.GetSessionsDir(baseDir, t)
+		sessionIDs, err := session.ListSavedSessions(sessionsDir, t.ConfigDirName())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s sessions: %w", name, err)
+		}
+		for _, sessionID := range sessionIDs {
+			stateDir := filepath.Join(sessionsDir, sessionID, t.ConfigDirName())
+			fingerprint, err := search.Fingerprint(stateDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping session %s: %v\n", sessionID, err)
+				continue
+			}
+
+			src := search.Source{
+				SessionID:   sessionID,
+				Tool:        name,
+				Fingerprint: fingerprint,
+				Load:        func() ([]transcript.Conversation, error) { return reader.ReadTranscript(stateDir) },
+			}
+			var metadata session.SessionMetadata
+			if data, err := os.ReadFile(filepath.Join(sessionsDir, sessionID, "metadata.json")); err == nil && json.Unmarshal(data, &metadata) == nil {
+				src.Workspace = metadata.Workspace
+				src.SavedAt, _ = time.Parse(time.RFC3339, metadata.SavedAt)
+			}
+			sources = append(sources, src)
+		}
+	}
+
+	indexPath := filepath.Join(baseDir, "search", "index.json")
+	idx, err := search.LoadIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	indexed, changed, failed := idx.Update(sources)
+	for _, err := range failed {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if indexed > 0 {
+		fmt.Fprintf(os.Stderr, "Indexed %d session(s)\n", indexed)
+	}
+	if changed {
+		if err := idx.Save(indexPath); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// resolveSavedSession returns the configured tool, its sessions directory and the
+// session named in args (the latest session if args is empty)
+func resolveSavedSession(args []string) (tool.Tool, string, string, error) {
+	toolInstance, err := getConfiguredTool(cfg)
+	if err != nil {
+		return nil, "", "", err
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	sessionsDir := session.GetSessionsDir(filepath.Join(homeDir, ".coi"), toolInstance)
+
+	if len(args) > 0 {
+		if !session.SessionExists(sessionsDir, args[0], toolInstance.ConfigDirName()) {
+			return nil, "", "", exitError(1, fmt.Sprintf("session '%s' not found - check available sessions with: coi list --all", args[0]))
+		}
+		return toolInstance, sessionsDir, args[0], nil
+	}
+	sessionID, err := session.GetLatestSession(sessionsDir, toolInstance.ConfigDirName())
+	if err != nil {
+		return nil, "", "", exitError(1, "no sessions found (specify session ID or use 'coi list --all')")
+	}
+	return toolInstance, sessionsDir, sessionID, nil
+}
+
+// exportSessionArchive writes a session archive to output, removing it if the export fails
+func exportSessionArchive(sessionsDir, sessionID string, t tool.Tool, output string) (*session.ArchiveManifest, error) {
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	manifest, err := writeSessionArchive(f, sessionsDir, sessionID, t, output)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// writeSessionArchive exports a session to f, compressed by the archive name
+func writeSessionArchive(f *os.File, sessionsDir, sessionID string, t tool.Tool, output string) (*session.ArchiveManifest, error) {
+	w, err := session.CompressArchive(output, f)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := session.ExportSession(sessionsDir, sessionID, t, Version, w)
+	if closeErr := w.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	return manifest, err
+}
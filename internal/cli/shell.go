@@ -11,6 +11,7 @@ import (
 
 	"github.com/mensfeld/code-on-incus/internal/config"
 	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/network"
 	"github.com/mensfeld/code-on-incus/internal/session"
 	"github.com/mensfeld/code-on-incus/internal/terminal"
 	"github.com/mensfeld/code-on-incus/internal/tool"
@@ -18,9 +19,13 @@ import (
 )
 
 var (
-	debugShell bool
-	background bool
-	useTmux    bool
+	debugShell  bool
+	background  bool
+	useTmux     bool
+	useWorktree bool
+	sshAgent    bool
+	sessionName string
+	labelArgs   []string
 )
 
 var shellCmd = &cobra.Command{
@@ -44,6 +49,11 @@ Examples:
   coi shell --continue=<session-id> # Same as --resume (alias)
   coi shell --slot 2                # Use specific slot
   coi shell --debug                 # Launch bash for debugging
+  coi shell --cpu 2 --memory 4GiB   # Limit the container's resources
+  coi shell --workspace-mode=overlay # Keep changes in the container until 'coi apply'
+  coi shell --worktree              # Work on a per-slot git worktree (see 'coi worktree')
+  coi shell --ssh-agent             # Forward the SSH agent keys listed in [ssh] keys
+  coi shell --name "fix flaky tests" --label ticket=ENG-123
 `,
 	RunE: shellCommand,
 }
@@ -52,6 +62,10 @@ func init() {
 	shellCmd.Flags().BoolVar(&debugShell, "debug", false, "Launch interactive bash instead of AI tool (for debugging)")
 	shellCmd.Flags().BoolVar(&background, "background", false, "Run AI tool in background tmux session (detached)")
 	shellCmd.Flags().BoolVar(&useTmux, "tmux", true, "Use tmux for session management (default true)")
+	shellCmd.Flags().BoolVar(&useWorktree, "worktree", false, "Run the slot in its own git worktree (branch coi/<slot>-<session>)")
+	shellCmd.Flags().BoolVar(&sshAgent, "ssh-agent", false, "Forward the host SSH agent, limited to the keys in [ssh] keys")
+	shellCmd.Flags().StringVar(&sessionName, "name", "", "Name the session (shown by coi list and coi info)")
+	shellCmd.Flags().StringArrayVar(&labelArgs, "label", []string{}, "Label the session (key=value, repeatable; filter with coi list --label)")
 }
 
 func shellCommand(cmd *cobra.Command, args []string) error {
@@ -100,14 +114,14 @@ func shellCommand(cmd *cobra.Command, args []string) error {
 	// Auto-detect if flag was set but value is empty or "auto"
 	if resumeFlagSet && (resumeID == "" || resumeID == "auto") {
 		// Auto-detect latest for workspace (only looks at sessions from the same workspace)
-		resumeID, err = session.GetLatestSessionForWorkspace(sessionsDir, absWorkspace)
+		resumeID, err = session.GetLatestSessionForWorkspace(sessionsDir, absWorkspace, toolInstance.ConfigDirName())
 		if err != nil {
 			return fmt.Errorf("no previous session to resume for this workspace: %w", err)
 		}
 		fmt.Fprintf(os.Stderr, "Auto-detected session: %s\n", resumeID)
 	} else if resumeID != "" {
 		// Validate that the explicitly provided session exists
-		if !session.SessionExists(sessionsDir, resumeID) {
+		if !session.SessionExists(sessionsDir, resumeID, toolInstance.ConfigDirName()) {
 			return fmt.Errorf("session '%s' not found - check available sessions with: coi list --all", resumeID)
 		}
 		fmt.Fprintf(os.Stderr, "Resuming session: %s\n", resumeID)
@@ -174,6 +188,47 @@ func shellCommand(cmd *cobra.Command, args []string) error {
 		networkConfig.Mode = config.NetworkMode(networkMode)
 	}
 
+	// The DNS and egress proxies run in this process, which exits as soon as a background session starts
+	if background && network.UsesSessionProxies(&networkConfig) {
+		return fmt.Errorf("--background cannot be used with proxy mode or dns_proxy: their proxies only run while 'coi shell' does")
+	}
+
+	limits, err := sessionLimits(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Flag overrides the [defaults] workspace_mode config
+	mode := cfg.Defaults.WorkspaceMode
+	if workspaceMode != "" {
+		mode = workspaceMode
+	}
+	if err := session.ValidateWorkspaceMode(mode); err != nil {
+		return err
+	}
+	if err := session.ValidateSecrets(cfg.Secrets); err != nil {
+		return fmt.Errorf("invalid secrets configuration: %w", err)
+	}
+	labels, err := session.ParseLabels(labelArgs)
+	if err != nil {
+		return err
+	}
+
+	// Flag enables the [ssh] forward_agent config
+	if sshAgent {
+		cfg.SSH.ForwardAgent = true
+	}
+
+	// Give the slot its own git worktree so parallel slots don't edit the same checkout
+	var worktree *session.Worktree
+	if useWorktree || cfg.Defaults.Worktree {
+		worktree, err = session.PrepareWorktree(cfg.Paths.WorktreesDir, absWorkspace, slotNum, sessionID)
+		if err != nil {
+			return fmt.Errorf("failed to prepare worktree: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Using worktree %s on branch %s\n", worktree.Path, worktree.Branch)
+	}
+
 	// Determine CLI config path based on tool
 	// For ENV-based tools (ConfigDirName returns ""), this will be empty
 	var cliConfigPath string
@@ -184,16 +239,26 @@ func shellCommand(cmd *cobra.Command, args []string) error {
 
 	// Setup session
 	setupOpts := session.SetupOptions{
-		WorkspacePath: absWorkspace,
-		Image:         imageName,
-		Persistent:    persistent,
-		ResumeFromID:  resumeID,
-		Slot:          slotNum,
-		SessionsDir:   sessionsDir,
-		CLIConfigPath: cliConfigPath,
-		Tool:          toolInstance,
-		NetworkConfig: &networkConfig,
-		DisableShift:  cfg.Incus.DisableShift,
+		WorkspacePath:  absWorkspace,
+		SessionID:      sessionID,
+		Image:          imageName,
+		Persistent:     persistent,
+		AutoSnapshot:   cfg.Defaults.AutoSnapshot,
+		WorkspaceMode:  mode,
+		Worktree:       worktree,
+		ResumeFromID:   resumeID,
+		Slot:           slotNum,
+		SessionsDir:    sessionsDir,
+		CLIConfigPath:  cliConfigPath,
+		Tool:           toolInstance,
+		NetworkConfig:  &networkConfig,
+		Limits:         limits,
+		Secrets:        cfg.Secrets,
+		GitCredentials: &cfg.Git,
+		SSH:            &cfg.SSH,
+		RunDir:         filepath.Join(baseDir, "run"),
+		DisableShift:   cfg.Incus.DisableShift,
+		UserEnv:        envVars,
 	}
 
 	// Parse and validate mount configuration
@@ -207,8 +272,21 @@ func shellCommand(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("mount validation failed: %w", err)
 	}
 
+	// Refuse to expose credentials or the whole home directory
+	if err := checkSensitiveMounts(cfg, absWorkspace, mountConfig); err != nil {
+		return err
+	}
+
 	setupOpts.MountConfig = mountConfig
 
+	// Workspace paths hidden from the agent
+	masks, err := ParseMaskPatterns(cfg, maskPatterns)
+	if err != nil {
+		return fmt.Errorf("invalid mask configuration: %w", err)
+	}
+	setupOpts.Masks = masks
+	setupOpts.MasksDir = filepath.Join(baseDir, "masks")
+
 	fmt.Fprintf(os.Stderr, "Setting up session %s...\n", sessionID)
 	result, err := session.Setup(setupOpts)
 	if err != nil {
@@ -219,6 +297,15 @@ func shellCommand(cmd *cobra.Command, args []string) error {
 	if err := session.SaveMetadataEarly(sessionsDir, sessionID, result.ContainerName, absWorkspace, persistent); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Failed to save early metadata: %v\n", err)
 	}
+	if worktree != nil {
+		if err := session.RecordWorktree(sessionsDir, sessionID, worktree); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to record worktree in metadata: %v\n", err)
+		}
+	}
+	start := session.SessionStart{Name: sessionName, Labels: labels, NetworkMode: string(networkConfig.Mode)}
+	if err := session.RecordStart(sessionsDir, sessionID, result.Manager, toolInstance, start); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to record session details in metadata: %v\n", err)
+	}
 
 	// Setup cleanup on exit
 	defer func() {
@@ -232,6 +319,8 @@ func shellCommand(cmd *cobra.Command, args []string) error {
 			Workspace:      absWorkspace,
 			Tool:           toolInstance,
 			NetworkManager: result.NetworkManager,
+			GitCredentials: result.GitCredentials,
+			SSHAgent:       result.SSHAgent,
 		}
 		if err := session.Cleanup(cleanupOpts); err != nil {
 			fmt.Fprintf(os.Stderr, "Cleanup error: %v\n", err)
@@ -376,6 +465,14 @@ func runCLI(result *session.SetupResult, sessionID string, useResumeFlag, restor
 		cmdToRun = strings.Join(cmd, " ")
 	}
 
+	// Export environment variable secrets and tool API keys from their tmpfs file (not passed on the command line)
+	if result.SecretsEnvFile != "" {
+		cmdToRun = fmt.Sprintf(". %s && %s", result.SecretsEnvFile, cmdToRun)
+	}
+
+	// Record the exit status for the session metadata, still exiting with it
+	cmdToRun = fmt.Sprintf("%s; status=$?; echo $status > %s; exit $status", cmdToRun, session.ExitStatusFile)
+
 	// Execute in container
 	user := container.CodeUID
 	if result.RunAsRoot {
@@ -391,6 +488,16 @@ func runCLI(result *session.SetupResult, sessionID string, useResumeFlag, restor
 		"IS_SANDBOX": "1",                                      // Always set sandbox mode
 	}
 
+	// Point the container at the network mode's proxy (proxy mode)
+	for k, v := range result.NetworkEnv {
+		containerEnv[k] = v
+	}
+
+	// Point ssh at the filtering agent proxy (--ssh-agent)
+	if result.SSHAgent != nil {
+		containerEnv["SSH_AUTH_SOCK"] = session.ContainerSSHAgentSocket
+	}
+
 	// Merge user-provided --env vars
 	for _, e := range envVars {
 		parts := strings.SplitN(e, "=", 2)
@@ -456,6 +563,15 @@ func runCLIInTmux(result *session.SetupResult, sessionID string, detached bool,
 		cliCmd = strings.Join(cmd, " ")
 	}
 
+	// Export environment variable secrets and tool API keys from their tmpfs file (not passed on the command line)
+	if result.SecretsEnvFile != "" {
+		cliCmd = fmt.Sprintf(". %s && %s", result.SecretsEnvFile, cliCmd)
+	}
+
+	// Record the exit status for the session metadata when the tool exits in a new tmux session
+	// ($ is escaped for the double quotes around the tmux command)
+	recordingCmd := fmt.Sprintf(`%s; echo \$? > %s`, cliCmd, session.ExitStatusFile)
+
 	// Build environment variables
 	user := container.CodeUID
 	if result.RunAsRoot {
@@ -472,6 +588,16 @@ func runCLIInTmux(result *session.SetupResult, sessionID string, detached bool,
 		"IS_SANDBOX": "1", // Always set sandbox mode
 	}
 
+	// Point the container at the network mode's proxy (proxy mode)
+	for k, v := range result.NetworkEnv {
+		containerEnv[k] = v
+	}
+
+	// Point ssh at the filtering agent proxy (--ssh-agent)
+	if result.SSHAgent != nil {
+		containerEnv["SSH_AUTH_SOCK"] = session.ContainerSSHAgentSocket
+	}
+
 	// Merge user-provided --env vars
 	for _, e := range envVars {
 		parts := strings.SplitN(e, "=", 2)
@@ -556,7 +682,7 @@ func runCLIInTmux(result *session.SetupResult, sessionID string, detached bool,
 			"tmux new-session -d -s %s -c /workspace \"bash -c 'trap : INT; %s %s; exec bash'\"",
 			tmuxSessionName,
 			envExports,
-			cliCmd,
+			recordingCmd,
 		)
 		opts := container.ExecCommandOptions{
 			Capture: true,
@@ -617,7 +743,7 @@ func runCLIInTmux(result *session.SetupResult, sessionID string, detached bool,
 				"tmux new-session -d -s %s -c /workspace \"bash -c 'trap : INT; %s %s; exec bash'\"",
 				tmuxSessionName,
 				envExports,
-				cliCmd,
+				recordingCmd,
 			)
 			createOpts := container.ExecCommandOptions{
 				User:    userPtr,
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/spf13/cobra"
+)
+
+var snapshotName string
+
+// snapshotCmd is the parent command for container snapshots
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Create, list, restore and delete container snapshots",
+	Long: `Manage Incus snapshots of session containers.
+
+Snapshots capture the container's filesystem (installed packages, home directory,
+tool config). The workspace is a mount from the host and is not part of them.
+Without a container name, the container of the current workspace is used
+(--slot selects one when the workspace has several).
+
+With [defaults] auto_snapshot = true, a snapshot named auto-<time> is taken
+before each 'coi shell' reuses a persistent container; the newest 5 are kept.
+
+Examples:
+  coi snapshot create                              # Snapshot the workspace's container
+  coi snapshot create coi-abc12345-1 --name before-upgrade
+  coi snapshot list
+  coi snapshot restore before-upgrade              # Roll back (the container restarts)
+  coi snapshot delete before-upgrade
+`,
+}
+
+// snapshotCreateCmd takes a snapshot
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create [container]",
+	Short: "Take a snapshot of a container",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, err := workspaceContainer(args)
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		snapshot := snapshotName
+		if snapshot == "" {
+			snapshot = session.SnapshotName("snap-", time.Now())
+		}
+		if err := container.NewManager(name).CreateSnapshot(snapshot); err != nil {
+			return exitError(1, fmt.Sprintf("failed to snapshot %s: %v", name, err))
+		}
+		fmt.Printf("Created snapshot %s of %s\n", snapshot, name)
+		return nil
+	},
+}
+
+// snapshotListCmd lists the snapshots of a container
+var snapshotListCmd = &cobra.Command{
+	Use:   "list [container]",
+	Short: "List the snapshots of a container",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, err := workspaceContainer(args)
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		snapshots, err := container.NewManager(name).ListSnapshots()
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		fmt.Printf("Snapshots of %s:\n", name)
+		if len(snapshots) == 0 {
+			fmt.Println("  (none)")
+			return nil
+		}
+		fmt.Printf("  %-30s %-20s %s\n", "NAME", "CREATED", "SIZE")
+		for _, snapshot := range snapshots {
+			size := "-"
+			if snapshot.Size >= 0 {
+				size = formatBytes(snapshot.Size)
+			}
+			fmt.Printf("  %-30s %-20s %s\n", snapshot.Name, snapshot.CreatedAt.Local().Format("2006-01-02 15:04:05"), size)
+		}
+		return nil
+	},
+}
+
+// snapshotRestoreCmd rolls a container back to a snapshot
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore <snapshot> [container]",
+	Short: "Roll a container back to a snapshot",
+	Long: `Roll a container back to a snapshot.
+
+Everything changed in the container since the snapshot is lost, and a running
+container is restarted (attached sessions end). The workspace is not affected.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, err := workspaceContainer(args[1:])
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		if err := container.NewManager(name).RestoreSnapshot(args[0]); err != nil {
+			return exitError(1, fmt.Sprintf("failed to restore %s: %v", name, err))
+		}
+		fmt.Printf("Restored %s to snapshot %s\n", name, args[0])
+		return nil
+	},
+}
+
+// snapshotDeleteCmd deletes a snapshot
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete <snapshot> [container]",
+	Short: "Delete a snapshot of a container",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, err := workspaceContainer(args[1:])
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		if err := container.NewManager(name).DeleteSnapshot(args[0]); err != nil {
+			return exitError(1, fmt.Sprintf("failed to delete snapshot %s of %s: %v", args[0], name, err))
+		}
+		fmt.Printf("Deleted snapshot %s of %s\n", args[0], name)
+		return nil
+	},
+}
+
+func init() {
+	snapshotCreateCmd.Flags().StringVar(&snapshotName, "name", "", "Snapshot name (default: snap-<time>)")
+
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	snapshotCmd.AddCommand(snapshotListCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	snapshotCmd.AddCommand(snapshotDeleteCmd)
+}
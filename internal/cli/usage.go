@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/mensfeld/code-on-incus/internal/tool"
+	"github.com/mensfeld/code-on-incus/internal/usage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	usageSince string
+	usageBy    string
+	usageJSON  bool
+)
+
+// usageCmd reports token usage and estimated cost of saved sessions
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Report token usage and estimated cost of saved sessions",
+	Long: `Report the tokens used by saved sessions, grouped by workspace, session or day,
+with the cost estimated from the price table ([usage.prices] in config).
+
+Usage is read from the tool's saved conversations, so it covers sessions whose
+state was saved (not sessions still running).
+
+Examples:
+  coi usage                        # Everything, by workspace
+  coi usage --since 7d --by day
+  coi usage --since 2025-01-01 --by session --json
+`,
+	Args: cobra.NoArgs,
+	RunE: usageCommand,
+}
+
+func init() {
+	usageCmd.Flags().StringVar(&usageSince, "since", "", "Only count usage in this period (e.g., 7d, 2w, 12h) or since a date (2025-01-02)")
+	usageCmd.Flags().StringVar(&usageBy, "by", "workspace", "Group by: workspace, session or day")
+	usageCmd.Flags().BoolVar(&usageJSON, "json", false, "Output as JSON")
+}
+
+// usageGroup is a row of the usage report
+type usageGroup struct {
+	Key string `json:"key"`
+	usage.Totals
+}
+
+func usageCommand(cmd *cobra.Command, args []string) error {
+	if usageBy != "workspace" && usageBy != "session" && usageBy != "day" {
+		return exitError(2, fmt.Sprintf("invalid --by '%s': must be workspace, session or day", usageBy))
+	}
+	var since time.Time
+	if usageSince != "" {
+		var err error
+		since, err = usage.ParseSince(usageSince, time.Now())
+		if err != nil {
+			return exitError(2, err.Error())
+		}
+	}
+
+	toolInstance, err := getConfiguredTool(cfg)
+	if err != nil {
+		return err
+	}
+	reader, ok := toolInstance.(tool.UsageTool)
+	if !ok {
+		return exitError(1, fmt.Sprintf("usage is not recorded by %s sessions", toolInstance.Name()))
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	sessionsDir := session.GetSessionsDir(filepath.Join(homeDir, ".coi"), toolInstance)
+	sessionIDs, err := session.ListSavedSessions(sessionsDir, toolInstance.ConfigDirName())
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	// Group the records of every session
+	grouped := make(map[string][]usage.Record)
+	var all []usage.Record
+	for _, sessionID := range sessionIDs {
+		records, err := reader.ReadUsage(filepath.Join(sessionsDir, sessionID, toolInstance.ConfigDirName()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping session %s: %v\n", sessionID, err)
+			continue
+		}
+		records = usage.Since(records, since)
+		all = append(all, records...)
+
+		switch usageBy {
+		case "session":
+			grouped[sessionID] = append(grouped[sessionID], records...)
+		case "workspace":
+			key := "(unknown)"
+			var metadata session.SessionMetadata
+			if data, err := os.ReadFile(filepath.Join(sessionsDir, sessionID, "metadata.json")); err == nil {
+				if json.Unmarshal(data, &metadata) == nil && metadata.Workspace != "" {
+					key = metadata.Workspace
+				}
+			}
+			grouped[key] = append(grouped[key], records...)
+		case "day":
+			for _, record := range records {
+				day := record.Time.Local().Format("2006-01-02")
+				grouped[day] = append(grouped[day], record)
+			}
+		}
+	}
+
+	groups := make([]usageGroup, 0, len(grouped))
+	for key, records := range grouped {
+		if len(records) > 0 {
+			groups = append(groups, usageGroup{Key: key, Totals: usage.Summarize(records, cfg.Usage.Prices)})
+		}
+	}
+	// Days in order, everything else by cost (then tokens, for unpriced models)
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if usageBy == "day" {
+			return a.Key < b.Key
+		}
+		if a.Cost != b.Cost {
+			return a.Cost > b.Cost
+		}
+		if a.Total() != b.Total() {
+			return a.Total() > b.Total()
+		}
+		return a.Key < b.Key
+	})
+	total := usage.Summarize(all, cfg.Usage.Prices)
+
+	if usageJSON {
+		output := map[string]interface{}{
+			"by":     usageBy,
+			"groups": groups,
+			"total":  total,
+		}
+		if !since.IsZero() {
+			output["since"] = since.Format(time.RFC3339)
+		}
+		data, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	printUsageReport(groups, total, since)
+	return nil
+}
+
+// printUsageReport prints the usage report as a table
+func printUsageReport(groups []usageGroup, total usage.Totals, since time.Time) {
+	title := "Token Usage"
+	if !since.IsZero() {
+		title += " since " + since.Local().Format("2006-01-02 15:04")
+	}
+	fmt.Println(title)
+	fmt.Println(strings.Repeat("-", len(title)))
+
+	if len(groups) == 0 {
+		fmt.Println("  (none)")
+		return
+	}
+
+	width := len("TOTAL")
+	for _, g := range groups {
+		width = max(width, len(g.Key))
+	}
+	row := func(key, messages, input, output, cacheWrite, cacheRead, cost string) {
+		fmt.Printf("  %-*s  %8s  %8s  %8s  %9s  %9s  %10s\n", width, key, messages, input, output, cacheWrite, cacheRead, cost)
+	}
+	totalsRow := func(key string, t usage.Totals) {
+		row(key, fmt.Sprintf("%d", t.Messages), usage.FormatTokens(t.Input), usage.FormatTokens(t.Output),
+			usage.FormatTokens(t.CacheWrite), usage.FormatTokens(t.CacheRead), fmt.Sprintf("$%.2f", t.Cost))
+	}
+
+	row(strings.ToUpper(usageBy), "MESSAGES", "INPUT", "OUTPUT", "CACHE W", "CACHE R", "COST")
+	for _, g := range groups {
+		totalsRow(g.Key, g.Totals)
+	}
+	if len(groups) > 1 {
+		totalsRow("TOTAL", total)
+	}
+
+	fmt.Println("\nCosts are estimates from the price table ([usage.prices] in config).")
+	if len(total.Unpriced) > 0 {
+		fmt.Printf("No price for %s (not included in costs)\n", strings.Join(total.Unpriced, ", "))
+	}
+}
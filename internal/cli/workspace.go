@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/spf13/cobra"
+)
+
+var (
+	workspaceMode  string
+	diffPatch      bool
+	diffFormat     string
+	changePaths    []string
+	discardConfirm bool
+)
+
+// diffCmd shows the changes a session made to its workspace copy or overlay
+var diffCmd = &cobra.Command{
+	Use:   "diff [container]",
+	Short: "Show pending workspace changes of an overlay or copy session",
+	Long: `Show how the workspace inside a container differs from the host tree.
+
+Sessions started with --workspace-mode=overlay or copy work on their own view of
+the workspace; nothing reaches the host until 'coi apply'. Without a container
+name, the container of the current workspace is used (--slot selects one when
+the workspace has several). A stopped container is started to read its workspace.
+
+Examples:
+  coi diff                          # List added (A), modified (M) and deleted (D) paths
+  coi diff --patch                  # Unified diff of changed files
+  coi diff --path src --patch       # Only changes under src/
+  coi diff --format json
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if diffFormat != "text" && diffFormat != "json" {
+			return exitError(2, fmt.Sprintf("invalid format '%s': must be 'text' or 'json'", diffFormat))
+		}
+
+		mgr, info, changes, err := workspaceChanges(args)
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		if diffFormat == "json" {
+			if changes == nil {
+				changes = []session.WorkspaceChange{}
+			}
+			out, err := json.MarshalIndent(changes, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
+		if len(changes) == 0 {
+			fmt.Printf("No pending changes in %s (%s workspace of %s)\n", mgr.ContainerName, info.Mode, info.HostPath)
+			return nil
+		}
+		if diffPatch {
+			if err := session.WriteWorkspacePatch(mgr, info.HostPath, changes, os.Stdout); err != nil {
+				return exitError(1, err.Error())
+			}
+			return nil
+		}
+
+		fmt.Printf("Pending changes in %s (%s workspace of %s):\n", mgr.ContainerName, info.Mode, info.HostPath)
+		printWorkspaceChanges(changes)
+		fmt.Printf("\n%d change(s) - copy them to the host with 'coi apply', or drop them with 'coi discard'\n", len(changes))
+		return nil
+	},
+}
+
+// applyCmd copies workspace changes from a container to the host
+var applyCmd = &cobra.Command{
+	Use:   "apply [container]",
+	Short: "Copy pending workspace changes of an overlay or copy session to the host",
+	Long: `Copy the changes shown by 'coi diff' from the container's workspace to the host tree.
+
+Added and modified files replace the host's, deleted paths are removed from the host.
+Use --path (repeatable) to apply only some of the changes.
+
+Examples:
+  coi apply                         # Apply all pending changes
+  coi apply --path src/main.go --path docs
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mgr, info, changes, err := workspaceChanges(args)
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+		if len(changes) == 0 {
+			fmt.Println("No pending changes to apply")
+			return nil
+		}
+
+		if err := session.ApplyWorkspaceChanges(mgr, info.HostPath, changes); err != nil {
+			return exitError(1, err.Error())
+		}
+		printWorkspaceChanges(changes)
+		fmt.Printf("\nApplied %d change(s) to %s\n", len(changes), info.HostPath)
+		return nil
+	},
+}
+
+// discardCmd resets a container's workspace to the host tree
+var discardCmd = &cobra.Command{
+	Use:   "discard [container]",
+	Short: "Drop pending workspace changes of an overlay or copy session",
+	Long: `Reset changed paths in the container's workspace to the host tree.
+
+Use --path (repeatable) to discard only some of the changes. The host is not modified.
+
+Examples:
+  coi discard                       # Drop all pending changes (asks for confirmation)
+  coi discard --path build --force
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mgr, info, changes, err := workspaceChanges(args)
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+		if len(changes) == 0 {
+			fmt.Println("No pending changes to discard")
+			return nil
+		}
+
+		printWorkspaceChanges(changes)
+		if !discardConfirm {
+			fmt.Printf("\nDiscard %d change(s)? [y/N]: ", len(changes))
+			var response string
+			_, _ = fmt.Scanln(&response)
+			if response != "y" && response != "Y" {
+				fmt.Println("Cancelled.")
+				return nil
+			}
+		}
+
+		if err := session.DiscardWorkspaceChanges(mgr, info.HostPath, changes); err != nil {
+			return exitError(1, err.Error())
+		}
+		fmt.Printf("\nDiscarded %d change(s) in %s\n", len(changes), mgr.ContainerName)
+		return nil
+	},
+}
+
+func init() {
+	shellCmd.Flags().StringVar(&workspaceMode, "workspace-mode", "", "Workspace mode: direct (default), overlay, copy")
+
+	diffCmd.Flags().BoolVar(&diffPatch, "patch", false, "Show a unified diff of changed files")
+	diffCmd.Flags().StringVar(&diffFormat, "format", "text", "Output format: text or json")
+	for _, cmd := range []*cobra.Command{diffCmd, applyCmd, discardCmd} {
+		cmd.Flags().StringArrayVar(&changePaths, "path", nil, "Only changes at or below this workspace path (repeatable)")
+	}
+	discardCmd.Flags().BoolVar(&discardConfirm, "force", false, "Skip confirmation prompt")
+}
+
+// workspaceChanges opens the workspace of the container named in args (or of the current workspace)
+// and returns its changes, limited to --path
+func workspaceChanges(args []string) (*container.Manager, session.WorkspaceInfo, []session.WorkspaceChange, error) {
+	name, err := workspaceContainer(args)
+	if err != nil {
+		return nil, session.WorkspaceInfo{}, nil, err
+	}
+	mgr := container.NewManager(name)
+
+	info, err := session.OpenWorkspace(mgr)
+	if err != nil {
+		return nil, info, nil, err
+	}
+	changes, err := session.WorkspaceChanges(mgr, info.HostPath)
+	if err != nil {
+		return nil, info, nil, err
+	}
+	return mgr, info, session.FilterWorkspaceChanges(changes, changePaths), nil
+}
+
+// printWorkspaceChanges prints one change per line, e.g. "  M src/main.go"
+func printWorkspaceChanges(changes []session.WorkspaceChange) {
+	for _, change := range changes {
+		p := change.Path
+		if change.Dir {
+			p += "/"
+		}
+		fmt.Printf("  %s %s\n", change.Status, p)
+	}
+}
+
+// workspaceContainer returns the container named in args, or the container of the workspace
+func workspaceContainer(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+
+	absWorkspace, err := filepath.Abs(workspace)
+	if err != nil {
+		return "", fmt.Errorf("invalid workspace path: %w", err)
+	}
+	if slot > 0 {
+		return session.ContainerName(absWorkspace, slot), nil
+	}
+
+	slots, err := session.ListWorkspaceSessions(absWorkspace)
+	if err != nil {
+		return "", fmt.Errorf("failed to list containers: %w", err)
+	}
+	var names []string
+	for _, name := range slots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("no container for workspace %s - pass a container name", absWorkspace)
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("workspace has several containers, pass a name or --slot: %s", strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/session"
+	"github.com/spf13/cobra"
+)
+
+var (
+	worktreePruneForce bool
+	worktreePruneAll   bool
+)
+
+// worktreeCmd is the parent command for per-slot git worktrees
+var worktreeCmd = &cobra.Command{
+	Use:   "worktree",
+	Short: "List, merge and prune per-slot git worktrees",
+	Long: `Manage the git worktrees created by 'coi shell --worktree'.
+
+With --worktree (or [defaults] worktree = true), each slot works in its own
+worktree of the workspace repository, on a branch named coi/<slot>-<session>,
+so parallel agents never edit the same checkout. Worktrees live in
+~/.coi/worktrees and are kept after the session; merge the branch into your
+checkout and prune the worktree when done.
+
+Examples:
+  coi worktree list                 # Worktrees of the current workspace
+  coi worktree merge 2              # Merge slot 2's branch into the current branch
+  coi worktree merge coi/2-1a2b3c4d
+  coi worktree prune                # Remove worktrees whose container is gone
+`,
+}
+
+// worktreeListCmd lists the worktrees of the workspace
+var worktreeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the slot worktrees of the workspace",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		absWorkspace, err := filepath.Abs(workspace)
+		if err != nil {
+			return fmt.Errorf("invalid workspace path: %w", err)
+		}
+		worktrees, err := session.ListWorktrees(absWorkspace)
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		if len(worktrees) == 0 {
+			fmt.Println("No coi worktrees for this workspace")
+			return nil
+		}
+		fmt.Printf("  %-4s %-24s %-10s %-8s %s\n", "SLOT", "BRANCH", "CONTAINER", "AHEAD", "PATH")
+		for _, wt := range worktrees {
+			state := "-"
+			if status, err := session.GetWorktreeStatus(absWorkspace, wt); err == nil {
+				state = fmt.Sprintf("%d", status.Ahead)
+				if status.Dirty {
+					state += " *"
+				}
+			}
+			fmt.Printf("  %-4d %-24s %-10s %-8s %s\n", wt.Slot, wt.Branch, worktreeContainerState(absWorkspace, wt), state, wt.Path)
+		}
+		fmt.Println("\nAHEAD: commits not in the current branch (* = uncommitted changes)")
+		return nil
+	},
+}
+
+// worktreeMergeCmd merges a worktree branch into the workspace
+var worktreeMergeCmd = &cobra.Command{
+	Use:   "merge <slot|branch>",
+	Short: "Merge a worktree's branch into the workspace's current branch",
+	Long: `Merge a worktree's branch into the branch checked out in the workspace.
+
+Only committed work is merged. Conflicts are left for you to resolve in the
+workspace as with any 'git merge'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		absWorkspace, err := filepath.Abs(workspace)
+		if err != nil {
+			return fmt.Errorf("invalid workspace path: %w", err)
+		}
+		wt, err := session.FindWorktree(absWorkspace, args[0])
+		if err != nil {
+			return exitError(1, err.Error())
+		}
+
+		if status, err := session.GetWorktreeStatus(absWorkspace, *wt); err == nil && status.Dirty {
+			fmt.Fprintf(os.Stderr, "Warning: %s has uncommitted changes, they are not merged\n", wt.Path)
+		}
+
+		out, err := session.MergeWorktree(absWorkspace, *wt)
+		if out != "" {
+			fmt.Println(out)
+		}
+		if err != nil {
+			return exitError(1, fmt.Sprintf("failed to merge %s: %v", wt.Branch, err))
+		}
+		fmt.Printf("Merged %s - remove the worktree with 'coi worktree prune' once its session is done\n", wt.Branch)
+		return nil
+	},
+}
+
+// worktreePruneCmd removes worktrees that no longer have a container
+var worktreePruneCmd = &cobra.Command{
+	Use:   "prune [slot|branch...]",
+	Short: "Remove slot worktrees and their branches",
+	Long: `Remove slot worktrees and delete their branches.
+
+Without arguments, worktrees whose container no longer exists are removed (--all
+includes those with a container). Worktrees with uncommitted changes and
+branches that are not merged are kept unless --force is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		absWorkspace, err := filepath.Abs(workspace)
+		if err != nil {
+			return fmt.Errorf("invalid workspace path: %w", err)
+		}
+
+		var worktrees []session.Worktree
+		if len(args) > 0 {
+			for _, arg := range args {
+				wt, err := session.FindWorktree(absWorkspace, arg)
+				if err != nil {
+					return exitError(1, err.Error())
+				}
+				worktrees = append(worktrees, *wt)
+			}
+		} else {
+			all, err := session.ListWorktrees(absWorkspace)
+			if err != nil {
+				return exitError(1, err.Error())
+			}
+			for _, wt := range all {
+				if worktreePruneAll || worktreeContainerState(absWorkspace, wt) == "none" {
+					worktrees = append(worktrees, wt)
+				}
+			}
+		}
+
+		if len(worktrees) == 0 {
+			fmt.Println("No worktrees to prune")
+			return nil
+		}
+
+		failed := 0
+		for _, wt := range worktrees {
+			if err := session.RemoveWorktree(absWorkspace, wt, worktreePruneForce); err != nil {
+				fmt.Fprintf(os.Stderr, "  Warning: %s: %v\n", wt.Branch, err)
+				failed++
+				continue
+			}
+			fmt.Printf("  ✓ Removed %s (%s)\n", wt.Branch, wt.Path)
+		}
+		if failed > 0 {
+			return exitError(1, fmt.Sprintf("%d worktree(s) not removed - use --force to discard uncommitted or unmerged work", failed))
+		}
+		return nil
+	},
+}
+
+func init() {
+	worktreePruneCmd.Flags().BoolVar(&worktreePruneForce, "force", false, "Remove worktrees with uncommitted changes and delete unmerged branches")
+	worktreePruneCmd.Flags().BoolVar(&worktreePruneAll, "all", false, "Also remove worktrees whose container still exists")
+
+	worktreeCmd.AddCommand(worktreeListCmd)
+	worktreeCmd.AddCommand(worktreeMergeCmd)
+	worktreeCmd.AddCommand(worktreePruneCmd)
+}
+
+// worktreeContainerState returns "running", "stopped", "none" or "unknown" (Incus unreachable) for the container of a worktree's slot
+func worktreeContainerState(workspace string, wt session.Worktree) string {
+	if wt.Slot == 0 {
+		return "none"
+	}
+	mgr := container.NewManager(session.ContainerName(workspace, wt.Slot))
+	exists, err := mgr.Exists()
+	if err != nil {
+		return "unknown"
+	}
+	if !exists {
+		return "none"
+	}
+	if running, _ := mgr.Running(); running {
+		return "running"
+	}
+	return "stopped"
+}
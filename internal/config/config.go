@@ -7,27 +7,37 @@ import (
 
 // Config represents the complete configuration
 type Config struct {
-	Defaults DefaultsConfig           `toml:"defaults"`
-	Paths    PathsConfig              `toml:"paths"`
-	Incus    IncusConfig              `toml:"incus"`
-	Network  NetworkConfig            `toml:"network"`
-	Tool     ToolConfig               `toml:"tool"`
-	Mounts   MountsConfig             `toml:"mounts"`
-	Profiles map[string]ProfileConfig `toml:"profiles"`
+	Defaults DefaultsConfig            `toml:"defaults"`
+	Paths    PathsConfig               `toml:"paths"`
+	Incus    IncusConfig               `toml:"incus"`
+	Network  NetworkConfig             `toml:"network"`
+	Tool     ToolConfig                `toml:"tool"`
+	Tools    map[string]ToolDefinition `toml:"tools"`
+	Mounts   MountsConfig              `toml:"mounts"`
+	Limits   LimitsConfig              `toml:"limits"`
+	Secrets  map[string]SecretConfig   `toml:"secrets"`
+	Git      GitCredentialsConfig      `toml:"git_credentials"`
+	SSH      SSHConfig                 `toml:"ssh"`
+	Usage    UsageConfig               `toml:"usage"`
+	Profiles map[string]ProfileConfig  `toml:"profiles"`
 }
 
 // DefaultsConfig contains default settings
 type DefaultsConfig struct {
-	Image      string `toml:"image"`
-	Persistent bool   `toml:"persistent"`
-	Model      string `toml:"model"`
+	Image         string `toml:"image"`
+	Persistent    bool   `toml:"persistent"`
+	Model         string `toml:"model"`
+	AutoSnapshot  bool   `toml:"auto_snapshot"`  // Snapshot persistent containers before each session reuses them
+	WorkspaceMode string `toml:"workspace_mode"` // "direct", "overlay" or "copy"
+	Worktree      bool   `toml:"worktree"`       // Give each slot its own git worktree of the workspace
 }
 
 // PathsConfig contains path settings
 type PathsConfig struct {
-	SessionsDir string `toml:"sessions_dir"`
-	StorageDir  string `toml:"storage_dir"`
-	LogsDir     string `toml:"logs_dir"`
+	SessionsDir  string `toml:"sessions_dir"`
+	StorageDir   string `toml:"storage_dir"`
+	LogsDir      string `toml:"logs_dir"`
+	WorktreesDir string `toml:"worktrees_dir"` // Per-slot git worktrees (shell --worktree)
 }
 
 // IncusConfig contains Incus-specific settings
@@ -49,23 +59,44 @@ const (
 	NetworkModeOpen NetworkMode = "open"
 	// NetworkModeAllowlist allows only specific domains (with RFC1918 always blocked)
 	NetworkModeAllowlist NetworkMode = "allowlist"
+	// NetworkModeProxy sends HTTP(S) through a host-side proxy that allows requests by hostname
+	NetworkModeProxy NetworkMode = "proxy"
+)
+
+// NetworkBackend selects how network isolation rules are enforced on the host
+type NetworkBackend string
+
+const (
+	// NetworkBackendAuto uses firewalld if it is running, otherwise nftables
+	NetworkBackendAuto NetworkBackend = "auto"
+	// NetworkBackendFirewalld uses firewalld direct rules in the FORWARD chain
+	NetworkBackendFirewalld NetworkBackend = "firewalld"
+	// NetworkBackendNFTables uses a dedicated nftables table (inet coi)
+	NetworkBackendNFTables NetworkBackend = "nftables"
+	// NetworkBackendIncusACL uses Incus network ACLs attached to the container's NIC
+	NetworkBackendIncusACL NetworkBackend = "incus-acl"
 )
 
 // NetworkConfig contains network isolation settings
 type NetworkConfig struct {
 	Mode                    NetworkMode          `toml:"mode"`
+	Backend                 NetworkBackend       `toml:"backend"`
 	BlockPrivateNetworks    bool                 `toml:"block_private_networks"`
 	BlockMetadataEndpoint   bool                 `toml:"block_metadata_endpoint"`
 	AllowedDomains          []string             `toml:"allowed_domains"`
 	RefreshIntervalMinutes  int                  `toml:"refresh_interval_minutes"`
 	AllowLocalNetworkAccess bool                 `toml:"allow_local_network_access"` // Allow established connections from entire local network (not just gateway)
+	Exceptions              []string             `toml:"exceptions"`                 // Restricted mode: destinations allowed despite the private network block (e.g., "10.0.5.12:5432/tcp")
+	DNSProxy                bool                 `toml:"dns_proxy"`                  // Allowlist mode: enforce allowed_domains per DNS query instead of polling
+	DNSUpstream             string               `toml:"dns_upstream"`               // Upstream resolver for the DNS proxy (host:port, defaults to the bridge's dnsmasq)
 	Logging                 NetworkLoggingConfig `toml:"logging"`
 }
 
 // NetworkLoggingConfig contains network logging settings
 type NetworkLoggingConfig struct {
-	Enabled bool   `toml:"enabled"`
-	Path    string `toml:"path"`
+	Enabled     bool   `toml:"enabled"`
+	Path        string `toml:"path"`
+	Connections bool   `toml:"connections"` // Log each new connection via firewall log rules (reads the kernel log)
 }
 
 // ProfileConfig represents a named profile
@@ -73,6 +104,15 @@ type ProfileConfig struct {
 	Image       string            `toml:"image"`
 	Environment map[string]string `toml:"environment"`
 	Persistent  bool              `toml:"persistent"`
+	Limits      LimitsConfig      `toml:"limits"`
+}
+
+// LimitsConfig contains resource limits for session containers (empty = unlimited)
+type LimitsConfig struct {
+	CPU          string `toml:"cpu"`           // Number of CPUs ("2") or CPU set ("0-3"), Incus limits.cpu
+	Memory       string `toml:"memory"`        // Size ("4GiB") or share of host memory ("50%"), Incus limits.memory
+	Disk         string `toml:"disk"`          // Root disk size ("20GiB"), needs a storage driver with quotas
+	MaxProcesses int    `toml:"max_processes"` // Maximum number of processes, Incus limits.processes
 }
 
 // ToolConfig represents AI coding tool configuration
@@ -81,15 +121,77 @@ type ToolConfig struct {
 	Binary string `toml:"binary"` // Binary name to execute (if empty, uses tool name)
 }
 
+// ToolDefinition describes a user-defined tool ([tools.<name>] table)
+// Argument templates support {session_id} (COI session ID) and
+// {resume_session_id} (tool session ID discovered from saved state)
+type ToolDefinition struct {
+	Binary          string   `toml:"binary"`            // Binary to execute (defaults to the table name)
+	Args            []string `toml:"args"`              // Arguments for a new session
+	ResumeArgs      []string `toml:"resume_args"`       // Arguments when resuming a discovered session (falls back to args)
+	ConfigDir       string   `toml:"config_dir"`        // Config directory in home (e.g., ".codex"), empty for ENV-only tools
+	ConfigFiles     []string `toml:"config_files"`      // Files inside config_dir to copy from the host
+	HomeConfigFiles []string `toml:"home_config_files"` // Files in the home directory to copy from the host
+	EnvVars         []string `toml:"env_vars"`          // Host environment variables to forward (API keys)
+	SessionGlob     string   `toml:"session_glob"`      // Glob (relative to config_dir) matching session files, file name is the session ID
+	SandboxSettings string   `toml:"sandbox_settings"`  // JSON object merged into <config_dir>/settings.json
+}
+
 // MountEntry represents a single directory mount configuration
 type MountEntry struct {
 	Host      string `toml:"host"`      // Host path (supports ~ expansion)
 	Container string `toml:"container"` // Container path (must be absolute)
+	ReadOnly  bool   `toml:"readonly"`  // Mount read-only
 }
 
 // MountsConfig contains mount-related configuration
 type MountsConfig struct {
 	Default []MountEntry `toml:"default"` // Default mounts for all sessions
+	Mask    []string     `toml:"mask"`    // Workspace paths hidden from the agent (e.g., ".env", "secrets/", "*.pem")
+	Deny    []string     `toml:"deny"`    // Host paths never mounted, in addition to the built-in sensitive paths
+}
+
+// SecretConfig maps a secret delivered to sessions to its host source
+// The secret is exposed as the environment variable named by its key, or as a file at Path
+// Exactly one source (File, Env, Command or SecretService) must be set
+type SecretConfig struct {
+	Path          string            `toml:"path"`           // Deliver as a file at this container path instead of an environment variable
+	File          string            `toml:"file"`           // Read from a host file
+	Env           string            `toml:"env"`            // Read from a host environment variable
+	Command       string            `toml:"command"`        // Output of a host command (e.g., "pass show github/token")
+	SecretService map[string]string `toml:"secret_service"` // Attributes of a freedesktop Secret Service item
+}
+
+// GitCredentialsConfig configures the git credential proxy, usually per workspace in .coi.toml
+// The proxy forwards git's https requests from the container and adds the credentials of the
+// host's git credential helpers on the host, so they never reach the container
+type GitCredentialsConfig struct {
+	Enabled     bool     `toml:"enabled"`
+	Allow       []string `toml:"allow"`        // Remotes served, e.g. "github.com/myorg/myrepo", "github.com/myorg/*" or "gitlab.example.com"
+	ApprovePush bool     `toml:"approve_push"` // Hold pushes until approved with 'coi git approve'
+	Log         string   `toml:"log"`          // Log of every fetch, push and refused request
+}
+
+// SSHConfig configures SSH agent forwarding (shell --ssh-agent)
+// The container gets a filtering proxy of the host's agent, never SSH_AUTH_SOCK itself
+type SSHConfig struct {
+	ForwardAgent  bool     `toml:"forward_agent"`
+	Keys          []string `toml:"keys"`           // Fingerprints of the keys exposed, e.g. "SHA256:..." (ssh-add -l)
+	RestrictHosts bool     `toml:"restrict_hosts"` // Only sign for hosts whose key is in KnownHosts
+	KnownHosts    string   `toml:"known_hosts"`    // Host keys accepted with restrict_hosts
+	Log           string   `toml:"log"`            // Log of every signing request
+}
+
+// UsageConfig configures the cost estimates of 'coi usage' and 'coi info'
+type UsageConfig struct {
+	Prices map[string]PriceConfig `toml:"prices"` // By model ID or family, e.g. "claude-sonnet-4-5"
+}
+
+// PriceConfig is a model's price in USD per million tokens
+type PriceConfig struct {
+	Input      float64 `toml:"input"`
+	Output     float64 `toml:"output"`
+	CacheWrite float64 `toml:"cache_write"` // Prompt cache writes
+	CacheRead  float64 `toml:"cache_read"`  // Prompt cache hits
 }
 
 // GetDefaultConfig returns the default configuration
@@ -102,14 +204,16 @@ func GetDefaultConfig() *Config {
 
 	return &Config{
 		Defaults: DefaultsConfig{
-			Image:      "coi",
-			Persistent: false,
-			Model:      "claude-sonnet-4-5",
+			Image:         "coi",
+			Persistent:    false,
+			Model:         "claude-sonnet-4-5",
+			WorkspaceMode: "direct",
 		},
 		Paths: PathsConfig{
-			SessionsDir: filepath.Join(baseDir, "sessions"),
-			StorageDir:  filepath.Join(baseDir, "storage"),
-			LogsDir:     filepath.Join(baseDir, "logs"),
+			SessionsDir:  filepath.Join(baseDir, "sessions"),
+			StorageDir:   filepath.Join(baseDir, "storage"),
+			LogsDir:      filepath.Join(baseDir, "logs"),
+			WorktreesDir: filepath.Join(baseDir, "worktrees"),
 		},
 		Incus: IncusConfig{
 			Project:  "default",
@@ -119,6 +223,7 @@ func GetDefaultConfig() *Config {
 		},
 		Network: NetworkConfig{
 			Mode:                  NetworkModeRestricted,
+			Backend:               NetworkBackendAuto,
 			BlockPrivateNetworks:  true,
 			BlockMetadataEndpoint: true,
 			AllowedDomains: []string{
@@ -141,9 +246,30 @@ func GetDefaultConfig() *Config {
 			Name:   "claude",
 			Binary: "", // Empty means use tool's default binary name
 		},
+		Tools: make(map[string]ToolDefinition),
 		Mounts: MountsConfig{
 			Default: []MountEntry{},
 		},
+		Git: GitCredentialsConfig{
+			Log: filepath.Join(baseDir, "logs", "git-credentials.log"),
+		},
+		SSH: SSHConfig{
+			KnownHosts: filepath.Join(homeDir, ".ssh", "known_hosts"),
+			Log:        filepath.Join(baseDir, "logs", "ssh-agent.log"),
+		},
+		Usage: UsageConfig{
+			// Anthropic API list prices by model family (model ID without its date); other models are unpriced
+			Prices: map[string]PriceConfig{
+				"claude-opus-4-0":   {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+				"claude-opus-4-1":   {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+				"claude-opus-4-5":   {Input: 5, Output: 25, CacheWrite: 6.25, CacheRead: 0.50},
+				"claude-sonnet-4-0": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+				"claude-sonnet-4-5": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+				"claude-3-7-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+				"claude-haiku-4-5":  {Input: 1, Output: 5, CacheWrite: 1.25, CacheRead: 0.10},
+				"claude-3-5-haiku":  {Input: 0.80, Output: 4, CacheWrite: 1, CacheRead: 0.08},
+			},
+		},
 		Profiles: make(map[string]ProfileConfig),
 	}
 }
@@ -155,15 +281,11 @@ func GetConfigPaths() []string {
 	if err != nil {
 		homeDir = "/tmp"
 	}
-	workDir, err := os.Getwd()
-	if err != nil {
-		workDir = "."
-	}
 
 	paths := []string{
 		"/etc/coi/config.toml",                            // System config
 		filepath.Join(homeDir, ".config/coi/config.toml"), // User config
-		filepath.Join(workDir, ".coi.toml"),               // Project config
+		ProjectConfigPath(),                               // Project config
 	}
 
 	// COI_CONFIG environment variable has highest priority
@@ -174,6 +296,15 @@ func GetConfigPaths() []string {
 	return paths
 }
 
+// ProjectConfigPath returns the path of the project config (./.coi.toml)
+func ProjectConfigPath() string {
+	workDir, err := os.Getwd()
+	if err != nil {
+		workDir = "."
+	}
+	return filepath.Join(workDir, ".coi.toml")
+}
+
 // ExpandPath expands ~ in paths to home directory
 func ExpandPath(path string) string {
 	if len(path) == 0 {
@@ -201,6 +332,9 @@ func (c *Config) Merge(other *Config) {
 	if other.Defaults.Model != "" {
 		c.Defaults.Model = other.Defaults.Model
 	}
+	if other.Defaults.WorkspaceMode != "" {
+		c.Defaults.WorkspaceMode = other.Defaults.WorkspaceMode
+	}
 	// For booleans, we need a way to distinguish "not set" from "false"
 	// In TOML, if a field is not present, it will be false (zero value)
 	// This is a limitation - we'll just override if file exists
@@ -216,6 +350,9 @@ func (c *Config) Merge(other *Config) {
 	if other.Paths.LogsDir != "" {
 		c.Paths.LogsDir = ExpandPath(other.Paths.LogsDir)
 	}
+	if other.Paths.WorktreesDir != "" {
+		c.Paths.WorktreesDir = ExpandPath(other.Paths.WorktreesDir)
+	}
 
 	// Merge Incus settings
 	if other.Incus.Project != "" {
@@ -235,16 +372,26 @@ func (c *Config) Merge(other *Config) {
 	if other.Network.Mode != "" {
 		c.Network.Mode = other.Network.Mode
 	}
+	if other.Network.Backend != "" {
+		c.Network.Backend = other.Network.Backend
+	}
 	// For booleans, we merge if they appear to be explicitly set
 	// This is imperfect in TOML but works for most cases
 	c.Network.BlockPrivateNetworks = other.Network.BlockPrivateNetworks
 	c.Network.BlockMetadataEndpoint = other.Network.BlockMetadataEndpoint
 	c.Network.AllowLocalNetworkAccess = other.Network.AllowLocalNetworkAccess
+	c.Network.DNSProxy = other.Network.DNSProxy
+	if other.Network.DNSUpstream != "" {
+		c.Network.DNSUpstream = other.Network.DNSUpstream
+	}
 
 	// Merge allowed domains (replace entirely if set)
 	if len(other.Network.AllowedDomains) > 0 {
 		c.Network.AllowedDomains = other.Network.AllowedDomains
 	}
+	if len(other.Network.Exceptions) > 0 {
+		c.Network.Exceptions = other.Network.Exceptions
+	}
 
 	// Merge refresh interval
 	if other.Network.RefreshIntervalMinutes != 0 {
@@ -255,6 +402,7 @@ func (c *Config) Merge(other *Config) {
 		c.Network.Logging.Path = ExpandPath(other.Network.Logging.Path)
 	}
 	c.Network.Logging.Enabled = other.Network.Logging.Enabled
+	c.Network.Logging.Connections = other.Network.Logging.Connections
 
 	// Merge Tool settings
 	if other.Tool.Name != "" {
@@ -263,16 +411,88 @@ func (c *Config) Merge(other *Config) {
 	if other.Tool.Binary != "" {
 		c.Tool.Binary = other.Tool.Binary
 	}
+
+	// Merge secrets (same name replaces the whole table)
+	for name, secret := range other.Secrets {
+		if c.Secrets == nil {
+			c.Secrets = make(map[string]SecretConfig)
+		}
+		c.Secrets[name] = secret
+	}
+
+	// Merge tool definitions (same name replaces the whole table)
+	for name, def := range other.Tools {
+		if c.Tools == nil {
+			c.Tools = make(map[string]ToolDefinition)
+		}
+		c.Tools[name] = def
+	}
+
+	// Like DisableShift, auto_snapshot and worktree stay enabled once a config enables them
+	if other.Defaults.AutoSnapshot {
+		c.Defaults.AutoSnapshot = true
+	}
+	if other.Defaults.Worktree {
+		c.Defaults.Worktree = true
+	}
+
 	// For DisableShift, if the other config sets it to true, use it
 	if other.Incus.DisableShift {
 		c.Incus.DisableShift = true
 	}
 
 	// Merge mounts - append from other config
+	if len(other.Mounts.Mask) > 0 {
+		c.Mounts.Mask = append(c.Mounts.Mask, other.Mounts.Mask...)
+	}
+	if len(other.Mounts.Deny) > 0 {
+		c.Mounts.Deny = append(c.Mounts.Deny, other.Mounts.Deny...)
+	}
 	if len(other.Mounts.Default) > 0 {
 		c.Mounts.Default = append(c.Mounts.Default, other.Mounts.Default...)
 	}
 
+	c.Limits.Merge(other.Limits)
+
+	// Merge git credential proxy settings (the allowed remotes are replaced entirely if set)
+	if other.Git.Enabled {
+		c.Git.Enabled = true
+	}
+	if other.Git.ApprovePush {
+		c.Git.ApprovePush = true
+	}
+	if len(other.Git.Allow) > 0 {
+		c.Git.Allow = other.Git.Allow
+	}
+	if other.Git.Log != "" {
+		c.Git.Log = ExpandPath(other.Git.Log)
+	}
+
+	// Merge SSH agent settings (the allowed keys are replaced entirely if set)
+	if other.SSH.ForwardAgent {
+		c.SSH.ForwardAgent = true
+	}
+	if other.SSH.RestrictHosts {
+		c.SSH.RestrictHosts = true
+	}
+	if len(other.SSH.Keys) > 0 {
+		c.SSH.Keys = other.SSH.Keys
+	}
+	if other.SSH.KnownHosts != "" {
+		c.SSH.KnownHosts = ExpandPath(other.SSH.KnownHosts)
+	}
+	if other.SSH.Log != "" {
+		c.SSH.Log = ExpandPath(other.SSH.Log)
+	}
+
+	// Merge model prices (same model replaces its whole price)
+	for model, price := range other.Usage.Prices {
+		if c.Usage.Prices == nil {
+			c.Usage.Prices = make(map[string]PriceConfig)
+		}
+		c.Usage.Prices[model] = price
+	}
+
 	// Merge profiles
 	for name, profile := range other.Profiles {
 		c.Profiles[name] = profile
@@ -298,6 +518,28 @@ func (c *Config) ApplyProfile(name string) bool {
 		c.Defaults.Image = profile.Image
 	}
 	c.Defaults.Persistent = profile.Persistent
+	c.Limits.Merge(profile.Limits)
 
 	return true
 }
+
+// IsZero returns true if no limit is set
+func (l LimitsConfig) IsZero() bool {
+	return l == LimitsConfig{}
+}
+
+// Merge overrides the limits that are set in other
+func (l *LimitsConfig) Merge(other LimitsConfig) {
+	if other.CPU != "" {
+		l.CPU = other.CPU
+	}
+	if other.Memory != "" {
+		l.Memory = other.Memory
+	}
+	if other.Disk != "" {
+		l.Disk = other.Disk
+	}
+	if other.MaxProcesses != 0 {
+		l.MaxProcesses = other.MaxProcesses
+	}
+}
@@ -274,3 +274,228 @@ func TestToolConfigMerge(t *testing.T) {
 		})
 	}
 }
+
+func TestToolDefinitionsMerge(t *testing.T) {
+	base := GetDefaultConfig()
+	base.Tools["codex"] = ToolDefinition{Binary: "codex", Args: []string{"--old"}}
+	base.Tools["gemini"] = ToolDefinition{Binary: "gemini"}
+
+	other := &Config{
+		Tools: map[string]ToolDefinition{
+			"codex":    {Binary: "codex", Args: []string{"--full-auto"}},
+			"opencode": {Binary: "opencode"},
+		},
+	}
+
+	base.Merge(other)
+
+	if len(base.Tools) != 3 {
+		t.Fatalf("Expected 3 tool definitions, got %d", len(base.Tools))
+	}
+
+	// Same name replaces the whole table
+	if args := base.Tools["codex"].Args; len(args) != 1 || args[0] != "--full-auto" {
+		t.Errorf("Expected codex args [--full-auto], got %v", args)
+	}
+
+	if base.Tools["gemini"].Binary != "gemini" {
+		t.Error("Expected gemini definition to be preserved")
+	}
+}
+
+func TestNetworkBackendMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	if cfg.Network.Backend != NetworkBackendAuto {
+		t.Errorf("Expected default network backend 'auto', got '%s'", cfg.Network.Backend)
+	}
+
+	// Unset backend keeps the current value
+	cfg.Merge(&Config{Network: NetworkConfig{Mode: NetworkModeAllowlist}})
+	if cfg.Network.Backend != NetworkBackendAuto {
+		t.Errorf("Expected backend to stay 'auto', got '%s'", cfg.Network.Backend)
+	}
+
+	cfg.Merge(&Config{Network: NetworkConfig{Backend: NetworkBackendNFTables}})
+	if cfg.Network.Backend != NetworkBackendNFTables {
+		t.Errorf("Expected backend 'nftables', got '%s'", cfg.Network.Backend)
+	}
+}
+
+func TestNetworkDNSProxyMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	if cfg.Network.DNSProxy {
+		t.Error("Expected DNS proxy to be disabled by default")
+	}
+
+	cfg.Merge(&Config{Network: NetworkConfig{DNSProxy: true, DNSUpstream: "1.1.1.1:53"}})
+	if !cfg.Network.DNSProxy || cfg.Network.DNSUpstream != "1.1.1.1:53" {
+		t.Errorf("Expected DNS proxy with upstream 1.1.1.1:53, got %v / %q", cfg.Network.DNSProxy, cfg.Network.DNSUpstream)
+	}
+
+	// Unset upstream keeps the current value
+	cfg.Merge(&Config{Network: NetworkConfig{DNSProxy: true}})
+	if cfg.Network.DNSUpstream != "1.1.1.1:53" {
+		t.Errorf("Expected upstream to stay 1.1.1.1:53, got %q", cfg.Network.DNSUpstream)
+	}
+}
+
+func TestNetworkExceptionsMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	if len(cfg.Network.Exceptions) != 0 {
+		t.Errorf("Expected no exceptions by default, got %v", cfg.Network.Exceptions)
+	}
+
+	cfg.Merge(&Config{Network: NetworkConfig{Exceptions: []string{"10.0.5.12:5432/tcp"}}})
+	if len(cfg.Network.Exceptions) != 1 || cfg.Network.Exceptions[0] != "10.0.5.12:5432/tcp" {
+		t.Errorf("Expected exceptions to be merged, got %v", cfg.Network.Exceptions)
+	}
+
+	// An empty list keeps the current exceptions
+	cfg.Merge(&Config{})
+	if len(cfg.Network.Exceptions) != 1 {
+		t.Errorf("Expected exceptions to be kept, got %v", cfg.Network.Exceptions)
+	}
+}
+
+func TestLimitsMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	if !cfg.Limits.IsZero() {
+		t.Errorf("Expected no limits by default, got %+v", cfg.Limits)
+	}
+
+	cfg.Merge(&Config{Limits: LimitsConfig{CPU: "2", Memory: "4GiB"}})
+	cfg.Merge(&Config{Limits: LimitsConfig{Memory: "8GiB", MaxProcesses: 500}})
+	want := LimitsConfig{CPU: "2", Memory: "8GiB", MaxProcesses: 500}
+	if cfg.Limits != want {
+		t.Errorf("Limits = %+v, want %+v", cfg.Limits, want)
+	}
+
+	// Profile limits override the config's limits
+	cfg.Profiles["build"] = ProfileConfig{Limits: LimitsConfig{CPU: "8", Disk: "50GiB"}}
+	if !cfg.ApplyProfile("build") {
+		t.Fatal("Expected ApplyProfile to return true")
+	}
+	want = LimitsConfig{CPU: "8", Memory: "8GiB", Disk: "50GiB", MaxProcesses: 500}
+	if cfg.Limits != want {
+		t.Errorf("Limits after profile = %+v, want %+v", cfg.Limits, want)
+	}
+}
+
+func TestAutoSnapshotMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	if cfg.Defaults.AutoSnapshot {
+		t.Error("Expected auto_snapshot to be disabled by default")
+	}
+
+	cfg.Merge(&Config{Defaults: DefaultsConfig{AutoSnapshot: true}})
+	cfg.Merge(&Config{})
+	if !cfg.Defaults.AutoSnapshot {
+		t.Error("Expected auto_snapshot to stay enabled")
+	}
+}
+
+func TestWorkspaceModeMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	if cfg.Defaults.WorkspaceMode != "direct" {
+		t.Errorf("Expected default workspace mode 'direct', got '%s'", cfg.Defaults.WorkspaceMode)
+	}
+
+	cfg.Merge(&Config{Defaults: DefaultsConfig{WorkspaceMode: "overlay"}})
+	cfg.Merge(&Config{})
+	if cfg.Defaults.WorkspaceMode != "overlay" {
+		t.Errorf("Expected workspace mode 'overlay', got '%s'", cfg.Defaults.WorkspaceMode)
+	}
+}
+
+func TestMountsMaskMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.Merge(&Config{Mounts: MountsConfig{Mask: []string{".env"}}})
+	cfg.Merge(&Config{Mounts: MountsConfig{Mask: []string{"*.pem"}, Default: []MountEntry{{Host: "~/.aws", Container: "/home/code/.aws", ReadOnly: true}}}})
+
+	if len(cfg.Mounts.Mask) != 2 || cfg.Mounts.Mask[0] != ".env" || cfg.Mounts.Mask[1] != "*.pem" {
+		t.Errorf("Expected masks [.env *.pem], got %v", cfg.Mounts.Mask)
+	}
+	if len(cfg.Mounts.Default) != 1 || !cfg.Mounts.Default[0].ReadOnly {
+		t.Errorf("Expected read-only default mount, got %+v", cfg.Mounts.Default)
+	}
+}
+
+func TestSecretsMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.Merge(&Config{Secrets: map[string]SecretConfig{
+		"GITHUB_TOKEN": {Command: "pass show github/token"},
+		"NPM_TOKEN":    {Env: "NPM_TOKEN"},
+	}})
+	cfg.Merge(&Config{Secrets: map[string]SecretConfig{
+		"GITHUB_TOKEN": {SecretService: map[string]string{"service": "github"}},
+	}})
+
+	if len(cfg.Secrets) != 2 {
+		t.Fatalf("Expected 2 secrets, got %d", len(cfg.Secrets))
+	}
+	if github := cfg.Secrets["GITHUB_TOKEN"]; github.Command != "" || github.SecretService["service"] != "github" {
+		t.Errorf("Expected GITHUB_TOKEN replaced by the later config, got %+v", github)
+	}
+	if cfg.Secrets["NPM_TOKEN"].Env != "NPM_TOKEN" {
+		t.Errorf("Expected NPM_TOKEN kept, got %+v", cfg.Secrets["NPM_TOKEN"])
+	}
+}
+
+func TestGitCredentialsMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	if cfg.Git.Enabled || cfg.Git.Log == "" {
+		t.Fatalf("Expected git credentials disabled with a default log, got %+v", cfg.Git)
+	}
+
+	cfg.Merge(&Config{Git: GitCredentialsConfig{Enabled: true, Allow: []string{"github.com/org/*"}}})
+	cfg.Merge(&Config{Git: GitCredentialsConfig{ApprovePush: true, Allow: []string{"github.com/org/repo"}}})
+
+	if !cfg.Git.Enabled || !cfg.Git.ApprovePush {
+		t.Errorf("Expected enabled with push approval, got %+v", cfg.Git)
+	}
+	if len(cfg.Git.Allow) != 1 || cfg.Git.Allow[0] != "github.com/org/repo" {
+		t.Errorf("Expected allow replaced by the later config, got %v", cfg.Git.Allow)
+	}
+}
+
+func TestSSHMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	if cfg.SSH.ForwardAgent || cfg.SSH.KnownHosts == "" || cfg.SSH.Log == "" {
+		t.Fatalf("Expected agent forwarding off with default paths, got %+v", cfg.SSH)
+	}
+
+	cfg.Merge(&Config{SSH: SSHConfig{ForwardAgent: true, Keys: []string{"SHA256:aaa"}}})
+	cfg.Merge(&Config{SSH: SSHConfig{RestrictHosts: true, Keys: []string{"SHA256:bbb"}, KnownHosts: "/etc/ssh/ssh_known_hosts"}})
+
+	if !cfg.SSH.ForwardAgent || !cfg.SSH.RestrictHosts {
+		t.Errorf("Expected forwarding restricted to known hosts, got %+v", cfg.SSH)
+	}
+	if len(cfg.SSH.Keys) != 1 || cfg.SSH.Keys[0] != "SHA256:bbb" {
+		t.Errorf("Expected keys replaced by the later config, got %v", cfg.SSH.Keys)
+	}
+	if cfg.SSH.KnownHosts != "/etc/ssh/ssh_known_hosts" {
+		t.Errorf("Unexpected known_hosts: %s", cfg.SSH.KnownHosts)
+	}
+}
+
+func TestUsagePricesMerge(t *testing.T) {
+	cfg := GetDefaultConfig()
+	if _, ok := cfg.Usage.Prices["claude-sonnet-4-5"]; !ok {
+		t.Fatalf("Expected built-in prices, got %v", cfg.Usage.Prices)
+	}
+
+	cfg.Merge(&Config{Usage: UsageConfig{Prices: map[string]PriceConfig{
+		"claude-sonnet-4-5": {Input: 2, Output: 10},
+		"my-model":          {Input: 1, Output: 1},
+	}}})
+
+	if price := cfg.Usage.Prices["claude-sonnet-4-5"]; price.Input != 2 || price.CacheRead != 0 {
+		t.Errorf("Expected the whole price replaced, got %+v", price)
+	}
+	if _, ok := cfg.Usage.Prices["my-model"]; !ok {
+		t.Error("Expected my-model to be added")
+	}
+	if _, ok := cfg.Usage.Prices["claude-opus-4-1"]; !ok {
+		t.Error("Expected other built-in prices to be kept")
+	}
+}
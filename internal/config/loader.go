@@ -21,8 +21,13 @@ func Load() (*Config, error) {
 
 	// Load from config files (in order)
 	paths := GetConfigPaths()
+	projectConfig := ProjectConfigPath()
 	for _, path := range paths {
-		if err := loadConfigFile(cfg, path); err != nil {
+		load := loadConfigFile
+		if path == projectConfig {
+			load = loadProjectConfigFile
+		}
+		if err := load(cfg, path); err != nil {
 			// Only return error if file exists but can't be parsed
 			if !os.IsNotExist(err) {
 				return nil, fmt.Errorf("failed to load config from %s: %w", path, err)
@@ -44,6 +49,17 @@ func Load() (*Config, error) {
 
 // loadConfigFile loads a TOML config file and merges it into cfg
 func loadConfigFile(cfg *Config, path string) error {
+	return mergeConfigFile(cfg, path, true)
+}
+
+// loadProjectConfigFile loads the project config, which comes with the workspace: its [tools]
+// tables are ignored, since they copy host files and forward host variables into the container
+func loadProjectConfigFile(cfg *Config, path string) error {
+	return mergeConfigFile(cfg, path, false)
+}
+
+// mergeConfigFile loads a TOML config file and merges it into cfg, with or without its [tools] tables
+func mergeConfigFile(cfg *Config, path string, allowTools bool) error {
 	// Check if file exists
 	if _, err := os.Stat(path); err != nil {
 		return err
@@ -55,6 +71,11 @@ func loadConfigFile(cfg *Config, path string) error {
 		return err
 	}
 
+	if !allowTools && len(fileCfg.Tools) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring [tools] in %s - tools can only be defined in the user or system config\n", path)
+		fileCfg.Tools = nil
+	}
+
 	// Merge into main config
 	cfg.Merge(&fileCfg)
 
@@ -111,11 +132,19 @@ image = "coi"
 # Set persistent=true to reuse containers across sessions (keeps installed tools)
 persistent = false
 model = "claude-sonnet-4-5"
+# Snapshot persistent containers before each session reuses them (roll back with 'coi snapshot restore')
+auto_snapshot = false
+# How the workspace is exposed to the agent: "direct" (bind mount, edits land on the host),
+# "overlay" or "copy" (edits stay in the container until 'coi apply')
+workspace_mode = "direct"
+# Give each slot its own git worktree on a coi/<slot>-<session> branch (see 'coi worktree')
+worktree = false
 
 [paths]
 sessions_dir = "~/.coi/sessions"
 storage_dir = "~/.coi/storage"
 logs_dir = "~/.coi/logs"
+worktrees_dir = "~/.coi/worktrees"
 
 [incus]
 project = "default"
@@ -123,30 +152,104 @@ group = "incus-admin"
 code_uid = 1000
 code_user = "code"
 
+[tool]
+name = "claude"
+
+# Example: user-defined tool (select it with [tool] name = "codex")
+# Argument templates support {session_id} and {resume_session_id}
+# [tools.codex]
+# binary = "codex"
+# args = ["--dangerously-bypass-approvals-and-sandbox"]
+# resume_args = ["resume", "{resume_session_id}", "--dangerously-bypass-approvals-and-sandbox"]
+# config_dir = ".codex"
+# config_files = ["auth.json", "config.toml"]
+# env_vars = ["OPENAI_API_KEY"]
+# session_glob = "sessions/*/*/*/*.jsonl"
+
 [mounts]
+# Workspace paths hidden from the agent (gitignore-like: "name" matches at any depth,
+# "dir/" only directories, "a/b" is relative to the workspace root); add more with --mask
+# mask = [".env", "secrets/", "*.pem"]
+
+# Host paths that may never be mounted (or used as the workspace), on top of the
+# built-in list (~/.ssh, ~/.aws, ~/.gnupg, ...); bypass with --allow-sensitive-mount
+# deny = ["~/.config/op", "~/private"]
+
 # Default mounts applied to all sessions
 # These can be overridden by CLI flags
 
-# Example: Mount AWS credentials (read-only recommended)
+# Example: Mount reference datasets read-only
 # [[mounts.default]]
-# host = "~/.aws"
-# container = "/home/user/.aws"
+# host = "~/datasets"
+# container = "/data"
+# readonly = true
 
 # Example: Mount shared data directory
 # [[mounts.default]]
 # host = "~/shared-data"
 # container = "/data"
 
-# Example: Mount Docker socket (advanced users)
+# Example: Mount Docker socket (advanced users, needs --allow-sensitive-mount)
 # [[mounts.default]]
 # host = "/var/run/docker.sock"
 # container = "/var/run/docker.sock"
 
+[limits]
+# Resource limits for session containers (unset = unlimited)
+# Override per session with --cpu, --memory, --disk and --max-processes
+# cpu = "2"              # Number of CPUs, or a CPU set like "0-3"
+# memory = "4GiB"        # Size, or a share of host memory like "50%"
+# disk = "20GiB"         # Root disk size (needs a storage driver with quotas, e.g. zfs, btrfs, lvm)
+# max_processes = 1000
+
+# Secrets delivered to sessions from host secret stores (never passed on the command line)
+# The table name is the environment variable; set path to deliver a file instead.
+# Values live on a tmpfs in the container and are removed when the session ends.
+# [secrets.GITHUB_TOKEN]
+# command = "pass show github/token"         # Output of a host command (e.g., pass, op read ...)
+#
+# [secrets.OPENAI_API_KEY]
+# env = "OPENAI_API_KEY"                     # Host environment variable
+#
+# [secrets.DATABASE_PASSWORD]
+# secret_service = { service = "myapp", account = "db" }  # Secret Service item (GNOME Keyring, KWallet)
+#
+# [secrets.npmrc]
+# file = "~/.config/coi/npmrc"               # Host file
+# path = "/home/code/.npmrc"                 # Delivered as this file in the container
+
+# Git access to https remotes through a host proxy that adds the host's git credentials,
+# for the allowed repositories only (usually set per workspace in .coi.toml)
+# [git_credentials]
+# enabled = true
+# allow = ["github.com/myorg/myrepo"]        # "host", "host/org/*" or "host/org/repo" (no host wildcards)
+# approve_push = true                        # Pushes wait for 'coi git approve' on the host
+# log = "~/.coi/logs/git-credentials.log"    # Every fetch, push and refused request
+
+# SSH agent forwarding (also enabled with coi shell --ssh-agent); the container only
+# sees the listed keys, through a filtering proxy of the host's SSH_AUTH_SOCK
+# [ssh]
+# forward_agent = true
+# keys = ["SHA256:u6fLN4cArdOfGb0dY7P5h+yjGCFCTmd7i0sGabmftJA"]  # Fingerprints from ssh-add -l
+# restrict_hosts = true                      # Only sign for hosts in known_hosts (OpenSSH 8.9+)
+# known_hosts = "~/.ssh/known_hosts"
+# log = "~/.coi/logs/ssh-agent.log"          # Every signing request, with the session ID
+
+# Prices (USD per million tokens) for the cost estimates of coi usage and coi info;
+# keys are model IDs or families (IDs without their date), added to (or replacing) the built-in
+# Anthropic list prices; models without a price are reported as unpriced
+# [usage.prices.claude-sonnet-4-5]
+# input = 3.0
+# output = 15.0
+# cache_write = 3.75
+# cache_read = 0.30
+
 # Example profile for Rust development with persistent container
 # [profiles.rust]
 # image = "coi-rust"
 # environment = { RUST_BACKTRACE = "1" }
 # persistent = true
+# limits = { cpu = "4", memory = "8GiB" }
 
 # Example profile for web development
 # [profiles.web]
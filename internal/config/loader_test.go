@@ -88,6 +88,83 @@ code_uid = 2000
 	}
 }
 
+func TestLoadConfigFileToolDefinitions(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	configContent := `
+[tool]
+name = "codex"
+
+[tools.codex]
+binary = "codex"
+args = ["--full-auto"]
+resume_args = ["resume", "{resume_session_id}"]
+config_dir = ".codex"
+config_files = ["auth.json", "config.toml"]
+env_vars = ["OPENAI_API_KEY"]
+session_glob = "sessions/*.jsonl"
+sandbox_settings = '{"approval_policy": "never"}'
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0o644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	cfg := GetDefaultConfig()
+	if err := loadConfigFile(cfg, configPath); err != nil {
+		t.Fatalf("loadConfigFile() failed: %v", err)
+	}
+
+	def, ok := cfg.Tools["codex"]
+	if !ok {
+		t.Fatal("Expected [tools.codex] to be loaded")
+	}
+
+	if def.ConfigDir != ".codex" {
+		t.Errorf("Expected config_dir '.codex', got '%s'", def.ConfigDir)
+	}
+
+	if len(def.ResumeArgs) != 2 || def.ResumeArgs[1] != "{resume_session_id}" {
+		t.Errorf("Expected resume_args [resume {resume_session_id}], got %v", def.ResumeArgs)
+	}
+
+	if len(def.ConfigFiles) != 2 || def.EnvVars[0] != "OPENAI_API_KEY" {
+		t.Errorf("Unexpected config_files/env_vars: %v / %v", def.ConfigFiles, def.EnvVars)
+	}
+
+	if def.SandboxSettings != `{"approval_policy": "never"}` {
+		t.Errorf("Unexpected sandbox_settings: %s", def.SandboxSettings)
+	}
+}
+
+func TestLoadProjectConfigFileIgnoresTools(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), ".coi.toml")
+	configContent := `
+[tool]
+name = "evil"
+
+[tools.evil]
+config_dir = ".ssh"
+config_files = ["id_rsa"]
+env_vars = ["AWS_SECRET_ACCESS_KEY"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0o644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	cfg := GetDefaultConfig()
+	if err := loadProjectConfigFile(cfg, configPath); err != nil {
+		t.Fatalf("loadProjectConfigFile() failed: %v", err)
+	}
+	if _, ok := cfg.Tools["evil"]; ok {
+		t.Error("Expected [tools] of the project config to be ignored")
+	}
+	if cfg.Tool.Name != "evil" {
+		t.Errorf("Expected the rest of the project config to be loaded, got tool %q", cfg.Tool.Name)
+	}
+}
+
 func TestLoadConfigFileNotExists(t *testing.T) {
 	cfg := GetDefaultConfig()
 	err := loadConfigFile(cfg, "/nonexistent/path/config.toml")
@@ -0,0 +1,314 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Backend performs Incus operations for the configured project
+// Two implementations exist: the REST backend talks to the Incus unix socket directly,
+// the CLI backend shells out to the incus client (via sg on Linux) and is used as a fallback
+type Backend interface {
+	// Name returns the backend name ("rest" or "cli")
+	Name() string
+
+	// ListInstances returns all instances in the project, including their runtime state
+	ListInstances() ([]Instance, error)
+
+	// GetInstance returns a single instance, or an error matching ErrNotFound
+	GetInstance(name string) (*Instance, error)
+
+	// CreateInstance creates (and optionally starts) an instance from a local image alias
+	CreateInstance(name string, opts CreateOptions) error
+
+	// StartInstance starts a stopped instance
+	StartInstance(name string) error
+
+	// StopInstance stops a running instance (force skips the clean shutdown)
+	StopInstance(name string, force bool) error
+
+	// DeleteInstance deletes an instance (force stops it first if running)
+	DeleteInstance(name string, force bool) error
+
+	// SetConfig sets a single instance config key
+	SetConfig(name, key, value string) error
+
+	// AddDevice adds a device to an instance
+	AddDevice(name, device string, config map[string]string) error
+
+	// SetDeviceConfig sets keys of a device defined on the instance (not inherited from a profile)
+	SetDeviceConfig(name, device string, config map[string]string) error
+
+	// Exec runs a command in the instance
+	// With opts.Capture, stdout is returned untrimmed and stderr is discarded,
+	// otherwise output goes to the terminal (stdin too with opts.Interactive)
+	// A non-zero exit status is returned as *ExitError
+	Exec(name string, command []string, opts ExecCommandOptions) (string, error)
+
+	// PushFile copies a local file to an absolute path in the instance
+	PushFile(name, localPath, containerPath string) error
+
+	// PushDirectory copies a local directory recursively into containerParent,
+	// creating containerParent/<base of localPath>
+	PushDirectory(name, localPath, containerParent string) error
+
+	// PullDirectory copies a directory from the instance recursively into localParent,
+	// creating localParent/<base of containerPath>
+	PullDirectory(name, containerPath, localParent string) error
+
+	// PullFile copies a file from the instance to localPath
+	PullFile(name, containerPath, localPath string) error
+
+	// ListImages returns all images in the project
+	ListImages() ([]Image, error)
+
+	// DeleteImage deletes an image by fingerprint or alias
+	DeleteImage(fingerprintOrAlias string) error
+
+	// CreateImageAlias points an alias at an image fingerprint
+	CreateImageAlias(alias, fingerprint string) error
+
+	// DeleteImageAlias removes an image alias (the image itself is kept)
+	DeleteImageAlias(alias string) error
+
+	// PublishInstance creates an image from a stopped instance and returns its fingerprint
+	PublishInstance(name, alias string, properties map[string]string) (string, error)
+
+	// CreateSnapshot takes a snapshot of an instance's filesystem and config
+	CreateSnapshot(name, snapshot string) error
+
+	// ListSnapshots returns the snapshots of an instance, oldest first
+	ListSnapshots(name string) ([]Snapshot, error)
+
+	// RestoreSnapshot rolls an instance back to a snapshot (a running instance is restarted)
+	RestoreSnapshot(name, snapshot string) error
+
+	// DeleteSnapshot deletes a snapshot of an instance
+	DeleteSnapshot(name, snapshot string) error
+}
+
+// CreateOptions holds options for creating an instance
+type CreateOptions struct {
+	Image     string            // Local image alias or fingerprint
+	Ephemeral bool              // Delete the instance when it stops
+	Config    map[string]string // Instance config keys (e.g., security.nesting)
+	Start     bool              // Start the instance after creating it (launch vs init)
+}
+
+// Instance describes an Incus instance (subset of the API instance object)
+// Field names match the API so both the REST response and `incus list --format=json` decode into it
+type Instance struct {
+	Name            string                       `json:"name"`
+	Status          string                       `json:"status"`
+	Ephemeral       bool                         `json:"ephemeral"`
+	CreatedAt       time.Time                    `json:"created_at"`
+	Config          map[string]string            `json:"config"`
+	Devices         map[string]map[string]string `json:"devices"`
+	ExpandedConfig  map[string]string            `json:"expanded_config"`  // Config including profiles
+	ExpandedDevices map[string]map[string]string `json:"expanded_devices"` // Devices including profiles
+	State           *InstanceState               `json:"state"`
+}
+
+// InstanceState is the runtime state of an instance (nil network for stopped instances)
+type InstanceState struct {
+	Status    string                          `json:"status"`
+	Network   map[string]InstanceNetworkState `json:"network"`
+	CPU       InstanceCPUState                `json:"cpu"`
+	Memory    InstanceMemoryState             `json:"memory"`
+	Disk      map[string]InstanceDiskState    `json:"disk"`
+	Processes int64                           `json:"processes"`
+}
+
+// InstanceCPUState is the CPU usage of an instance
+type InstanceCPUState struct {
+	Usage int64 `json:"usage"` // CPU time used, in nanoseconds
+}
+
+// InstanceMemoryState is the memory usage of an instance
+type InstanceMemoryState struct {
+	Usage int64 `json:"usage"` // Bytes
+}
+
+// InstanceDiskState is the usage of a disk device
+type InstanceDiskState struct {
+	Usage int64 `json:"usage"` // Bytes (0 if the storage driver does not report usage)
+}
+
+// InstanceNetworkState is the state of a single network interface
+type InstanceNetworkState struct {
+	Addresses []InstanceAddress `json:"addresses"`
+}
+
+// InstanceAddress is an address assigned to a network interface
+type InstanceAddress struct {
+	Family  string `json:"family"` // "inet" or "inet6"
+	Address string `json:"address"`
+	Scope   string `json:"scope"`
+}
+
+// Running returns true if the instance is running
+func (i *Instance) Running() bool {
+	return i.Status == "Running"
+}
+
+// IPv4 returns the first IPv4 address of eth0, or "" if there is none (e.g., stopped)
+func (i *Instance) IPv4() string {
+	if i.State == nil {
+		return ""
+	}
+	eth0, ok := i.State.Network["eth0"]
+	if !ok {
+		return ""
+	}
+	for _, addr := range eth0.Addresses {
+		if addr.Family == "inet" {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+// IPv6 returns the first global IPv6 address of eth0, or "" if there is none
+// Link-local addresses (fe80::/10) are skipped since they are not routed through the bridge
+func (i *Instance) IPv6() string {
+	if i.State == nil {
+		return ""
+	}
+	eth0, ok := i.State.Network["eth0"]
+	if !ok {
+		return ""
+	}
+	for _, addr := range eth0.Addresses {
+		if addr.Family == "inet6" && addr.Scope == "global" {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+// RootDisk returns the name and config of the root disk device (path "/"), including one inherited
+// from a profile, or "" if the instance has none
+func (i *Instance) RootDisk() (string, map[string]string) {
+	for _, devices := range []map[string]map[string]string{i.Devices, i.ExpandedDevices} {
+		for name, device := range devices {
+			if device["type"] == "disk" && device["path"] == "/" {
+				return name, device
+			}
+		}
+	}
+	return "", nil
+}
+
+// ConfigValue returns a config key, including one inherited from a profile
+func (i *Instance) ConfigValue(key string) string {
+	if value, ok := i.Config[key]; ok {
+		return value
+	}
+	return i.ExpandedConfig[key]
+}
+
+// Snapshot describes an instance snapshot (subset of the API snapshot object)
+type Snapshot struct {
+	Name      string    `json:"name"` // Snapshot name without the instance name
+	CreatedAt time.Time `json:"created_at"`
+	Stateful  bool      `json:"stateful"`
+	Size      int64     `json:"size"` // Bytes (-1 if the storage driver does not report it)
+}
+
+// sortSnapshots sorts snapshots by creation time, oldest first
+func sortSnapshots(snapshots []Snapshot) {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+}
+
+// Image describes an Incus image (subset of the API image object)
+type Image struct {
+	Fingerprint string            `json:"fingerprint"`
+	Aliases     []ImageAlias      `json:"aliases"`
+	Properties  map[string]string `json:"properties"`
+	Size        int64             `json:"size"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UploadedAt  time.Time         `json:"uploaded_at"`
+}
+
+// ImageAlias is a name pointing at an image
+type ImageAlias struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// HasAlias returns true if the image has the given alias
+func (i *Image) HasAlias(name string) bool {
+	for _, alias := range i.Aliases {
+		if alias.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNotFound is returned (wrapped) when an instance or image does not exist
+var ErrNotFound = errors.New("not found")
+
+// APIError is an error response from the Incus REST API
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("incus API error (%d): %s", e.StatusCode, e.Message)
+}
+
+// Is makes errors.Is(err, ErrNotFound) work for 404 responses
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == 404
+}
+
+var (
+	backendMu sync.Mutex
+	backend   Backend
+)
+
+// SetBackend overrides the backend used for all container operations (e.g., a fake in tests)
+// Passing nil restores automatic selection
+func SetBackend(b Backend) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	backend = b
+}
+
+// GetBackend returns the active backend, selecting one on first use
+// COI_INCUS_BACKEND=cli or COI_INCUS_BACKEND=rest forces a backend,
+// otherwise REST is used when the Incus socket is reachable and CLI otherwise
+func GetBackend() Backend {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	if backend == nil {
+		backend = selectBackend()
+	}
+	return backend
+}
+
+// selectBackend picks the REST backend if the Incus socket accepts our requests
+func selectBackend() Backend {
+	cli := NewCLIBackend()
+
+	switch os.Getenv("COI_INCUS_BACKEND") {
+	case "cli":
+		return cli
+	case "rest":
+		return NewRESTBackend(SocketPath(), cli)
+	}
+
+	rest := NewRESTBackend(SocketPath(), cli)
+	if err := rest.Ping(); err != nil {
+		return cli
+	}
+	return rest
+}
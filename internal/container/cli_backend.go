@@ -0,0 +1,262 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CLIBackend implements Backend by running the incus client (via sg on Linux)
+type CLIBackend struct{}
+
+// NewCLIBackend creates a backend that shells out to the incus client
+func NewCLIBackend() *CLIBackend {
+	return &CLIBackend{}
+}
+
+func (c *CLIBackend) Name() string {
+	return "cli"
+}
+
+func (c *CLIBackend) ListInstances() ([]Instance, error) {
+	output, err := IncusOutput("list", "--format=json")
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	if err := json.Unmarshal([]byte(output), &instances); err != nil {
+		return nil, fmt.Errorf("failed to parse instance list: %w", err)
+	}
+	return instances, nil
+}
+
+func (c *CLIBackend) GetInstance(name string) (*Instance, error) {
+	output, err := IncusOutput("list", "^"+regexp.QuoteMeta(name)+"$", "--format=json")
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	if err := json.Unmarshal([]byte(output), &instances); err != nil {
+		return nil, fmt.Errorf("failed to parse instance list: %w", err)
+	}
+
+	for i := range instances {
+		if instances[i].Name == name {
+			return &instances[i], nil
+		}
+	}
+	return nil, fmt.Errorf("instance %s: %w", name, ErrNotFound)
+}
+
+func (c *CLIBackend) CreateInstance(name string, opts CreateOptions) error {
+	args := []string{"init", opts.Image, name}
+	if opts.Start {
+		args[0] = "launch"
+	}
+	if opts.Ephemeral {
+		args = append(args, "--ephemeral")
+	}
+	for _, key := range sortedKeys(opts.Config) {
+		args = append(args, "--config", fmt.Sprintf("%s=%s", key, opts.Config[key]))
+	}
+	return IncusExec(args...)
+}
+
+func (c *CLIBackend) StartInstance(name string) error {
+	return IncusExec("start", name)
+}
+
+func (c *CLIBackend) StopInstance(name string, force bool) error {
+	if force {
+		return IncusExec("stop", name, "--force")
+	}
+	return IncusExec("stop", name)
+}
+
+func (c *CLIBackend) DeleteInstance(name string, force bool) error {
+	if force {
+		return IncusExecQuiet("delete", name, "--force")
+	}
+	return IncusExec("delete", name)
+}
+
+func (c *CLIBackend) SetConfig(name, key, value string) error {
+	return IncusExec("config", "set", name, key, value)
+}
+
+func (c *CLIBackend) AddDevice(name, device string, config map[string]string) error {
+	args := []string{"config", "device", "add", name, device, config["type"]}
+	for _, key := range sortedKeys(config) {
+		if key == "type" {
+			continue
+		}
+		args = append(args, fmt.Sprintf("%s=%s", key, config[key]))
+	}
+	return IncusExec(args...)
+}
+
+func (c *CLIBackend) SetDeviceConfig(name, device string, config map[string]string) error {
+	args := []string{"config", "device", "set", name, device}
+	for _, key := range sortedKeys(config) {
+		args = append(args, fmt.Sprintf("%s=%s", key, config[key]))
+	}
+	return IncusExec(args...)
+}
+
+func (c *CLIBackend) Exec(name string, command []string, opts ExecCommandOptions) (string, error) {
+	args := []string{"exec", name}
+
+	// Add force-interactive flag for interactive sessions (required for tmux attach)
+	if opts.Interactive {
+		args = append(args, "--force-interactive")
+	}
+
+	// Add environment variables
+	for k, v := range opts.Env {
+		args = append(args, "--env", fmt.Sprintf("%s=%s", k, v))
+	}
+
+	// Add working directory
+	if opts.Cwd != "" {
+		args = append(args, "--cwd", opts.Cwd)
+	}
+
+	// Add user/group
+	if opts.User != nil {
+		args = append(args, "--user", fmt.Sprintf("%d", *opts.User))
+		group := opts.User // default to same as user
+		if opts.Group != nil {
+			group = opts.Group
+		}
+		args = append(args, "--group", fmt.Sprintf("%d", *group))
+	}
+
+	// Add command arguments
+	args = append(args, "--")
+	args = append(args, command...)
+
+	if opts.Capture {
+		// Use IncusOutputRaw to preserve whitespace
+		return IncusOutputRaw(args...)
+	}
+
+	var err error
+	if opts.Interactive {
+		err = IncusExecInteractive(args...)
+	} else {
+		err = IncusExec(args...)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", &ExitError{ExitCode: exitErr.ExitCode(), Err: err}
+	}
+	return "", err
+}
+
+func (c *CLIBackend) PushFile(name, localPath, containerPath string) error {
+	return IncusFilePush(localPath, name+containerPath)
+}
+
+func (c *CLIBackend) PushDirectory(name, localPath, containerParent string) error {
+	if !strings.HasSuffix(containerParent, "/") {
+		containerParent += "/"
+	}
+	return IncusExec("file", "push", "-r", localPath, name+containerParent)
+}
+
+func (c *CLIBackend) PullDirectory(name, containerPath, localParent string) error {
+	return IncusExec("file", "pull", "-r", name+containerPath, localParent)
+}
+
+func (c *CLIBackend) PullFile(name, containerPath, localPath string) error {
+	return IncusExec("file", "pull", name+containerPath, localPath)
+}
+
+func (c *CLIBackend) ListImages() ([]Image, error) {
+	output, err := IncusOutput("image", "list", "--format=json")
+	if err != nil {
+		return nil, err
+	}
+
+	var images []Image
+	if err := json.Unmarshal([]byte(output), &images); err != nil {
+		return nil, fmt.Errorf("failed to parse image list: %w", err)
+	}
+	return images, nil
+}
+
+func (c *CLIBackend) DeleteImage(fingerprintOrAlias string) error {
+	return IncusExecQuiet("image", "delete", fingerprintOrAlias)
+}
+
+func (c *CLIBackend) CreateImageAlias(alias, fingerprint string) error {
+	return IncusExec("image", "alias", "create", alias, fingerprint)
+}
+
+func (c *CLIBackend) DeleteImageAlias(alias string) error {
+	return IncusExec("image", "alias", "delete", alias)
+}
+
+func (c *CLIBackend) PublishInstance(name, alias string, properties map[string]string) (string, error) {
+	args := []string{"publish", name, "--alias", alias}
+	for _, key := range sortedKeys(properties) {
+		args = append(args, fmt.Sprintf("%s=%s", key, properties[key]))
+	}
+
+	output, err := IncusOutput(args...)
+	if err != nil {
+		return "", err
+	}
+
+	// Extract fingerprint from output
+	re := regexp.MustCompile(`fingerprint:\s*([a-f0-9]+)`)
+	matches := re.FindStringSubmatch(output)
+	if len(matches) < 2 {
+		return "", fmt.Errorf("could not extract fingerprint from output")
+	}
+
+	return matches[1], nil
+}
+
+func (c *CLIBackend) CreateSnapshot(name, snapshot string) error {
+	return IncusExec("snapshot", "create", name, snapshot)
+}
+
+func (c *CLIBackend) ListSnapshots(name string) ([]Snapshot, error) {
+	output, err := IncusOutput("snapshot", "list", name, "--format=json")
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	if err := json.Unmarshal([]byte(output), &snapshots); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot list: %w", err)
+	}
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+func (c *CLIBackend) RestoreSnapshot(name, snapshot string) error {
+	return IncusExec("snapshot", "restore", name, snapshot)
+}
+
+func (c *CLIBackend) DeleteSnapshot(name, snapshot string) error {
+	return IncusExec("snapshot", "delete", name, snapshot)
+}
+
+// sortedKeys returns map keys in a stable order for reproducible command lines
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
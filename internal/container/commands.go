@@ -2,7 +2,7 @@ package container
 
 import (
 	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -228,84 +228,60 @@ func ContainerExec(containerName, command string, opts ContainerExecOptions) (st
 
 // LaunchContainer launches an ephemeral container
 func LaunchContainer(imageAlias, containerName string) error {
-	args := []string{"launch", imageAlias, containerName, "--ephemeral"}
-	if err := IncusExec(args...); err != nil {
-		return err
-	}
-	return enableDockerSupport(containerName)
+	return GetBackend().CreateInstance(containerName, CreateOptions{
+		Image:     imageAlias,
+		Ephemeral: true,
+		Config:    dockerSupportConfig(),
+		Start:     true,
+	})
 }
 
 // LaunchContainerPersistent launches a non-ephemeral container
 func LaunchContainerPersistent(imageAlias, containerName string) error {
-	args := []string{"launch", imageAlias, containerName}
-	if err := IncusExec(args...); err != nil {
-		return err
-	}
-	return enableDockerSupport(containerName)
+	return GetBackend().CreateInstance(containerName, CreateOptions{
+		Image:  imageAlias,
+		Config: dockerSupportConfig(),
+		Start:  true,
+	})
 }
 
-// enableDockerSupport configures the container to support Docker/nested containers.
+// dockerSupportConfig returns the config that lets containers run Docker/nested containers.
 //
-// This function sets three security flags required for Docker to work properly:
+// Three security flags are required for Docker to work properly:
 // - security.nesting=true: Enables nested containerization
 // - security.syscalls.intercept.mknod=true: Safe device node creation
 // - security.syscalls.intercept.setxattr=true: Safe filesystem attribute handling
 //
-// Note: If an error occurs during configuration, the container may be left in a
-// partially configured state with some but not all flags set. Future troubleshooting
-// should verify all three flags are properly configured if Docker isn't working.
-func enableDockerSupport(containerName string) error {
-	// Enable container nesting for Docker support
-	if err := IncusExec("config", "set", containerName, "security.nesting=true"); err != nil {
-		return err
-	}
-
-	// Enable syscall interception for mknod (device node creation)
-	if err := IncusExec("config", "set", containerName, "security.syscalls.intercept.mknod=true"); err != nil {
-		return err
-	}
-
-	// Enable syscall interception for setxattr (filesystem attributes)
-	if err := IncusExec("config", "set", containerName, "security.syscalls.intercept.setxattr=true"); err != nil {
-		return err
+// They are applied when the container is created, so a container never ends up
+// with only some of the flags set.
+func dockerSupportConfig() map[string]string {
+	return map[string]string{
+		"security.nesting":                     "true",
+		"security.syscalls.intercept.mknod":    "true",
+		"security.syscalls.intercept.setxattr": "true",
 	}
-
-	return nil
 }
 
 // StopContainer stops a container
 func StopContainer(containerName string) error {
-	return IncusExec("stop", containerName, "--force")
+	return GetBackend().StopInstance(containerName, true)
 }
 
 // DeleteContainer deletes a container forcefully
 func DeleteContainer(containerName string) error {
-	return IncusExecQuiet("delete", containerName, "--force")
+	return GetBackend().DeleteInstance(containerName, true)
 }
 
 // ContainerRunning checks if a container is running
 func ContainerRunning(containerName string) (bool, error) {
-	output, err := IncusOutput("list", containerName, "--format=json")
+	instance, err := GetBackend().GetInstance(containerName)
 	if err != nil {
-		return false, err
-	}
-
-	var containers []struct {
-		Name   string `json:"name"`
-		Status string `json:"status"`
-	}
-
-	if err := json.Unmarshal([]byte(output), &containers); err != nil {
-		return false, err
-	}
-
-	for _, c := range containers {
-		if c.Name == containerName && c.Status == "Running" {
-			return true, nil
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
 		}
+		return false, err
 	}
-
-	return false, nil
+	return instance.Running(), nil
 }
 
 // PublishContainer publishes a stopped container as an image
@@ -318,27 +294,16 @@ func PublishContainer(containerName, aliasName, description string) (string, err
 		}
 	}
 
-	// Build publish command
-	args := []string{"publish", containerName, "--alias", aliasName}
+	properties := map[string]string{}
 	if description != "" {
-		args = append(args, fmt.Sprintf("description=%s", description))
+		properties["description"] = description
 	}
 
-	// Execute and capture output
-	output, err := IncusOutput(args...)
+	fingerprint, err := GetBackend().PublishInstance(containerName, aliasName, properties)
 	if err != nil {
 		return "", err
 	}
 
-	// Extract fingerprint from output
-	re := regexp.MustCompile(`fingerprint:\s*([a-f0-9]+)`)
-	matches := re.FindStringSubmatch(output)
-	if len(matches) < 2 {
-		return "", fmt.Errorf("could not extract fingerprint from output")
-	}
-
-	fingerprint := matches[1]
-
 	// Cleanup container after successful publish
 	if err := DeleteContainer(containerName); err != nil {
 		return fingerprint, err // Return fingerprint even if cleanup fails
@@ -349,31 +314,19 @@ func PublishContainer(containerName, aliasName, description string) (string, err
 
 // DeleteImage deletes an image by alias
 func DeleteImage(aliasName string) error {
-	return IncusExecQuiet("image", "delete", aliasName)
+	return GetBackend().DeleteImage(aliasName)
 }
 
 // ImageExists checks if an image with the given alias exists
 func ImageExists(aliasName string) (bool, error) {
-	output, err := IncusOutput("image", "list", "--format=json")
+	images, err := GetBackend().ListImages()
 	if err != nil {
 		return false, err
 	}
 
-	var images []struct {
-		Aliases []struct {
-			Name string `json:"name"`
-		} `json:"aliases"`
-	}
-
-	if err := json.Unmarshal([]byte(output), &images); err != nil {
-		return false, err
-	}
-
 	for _, img := range images {
-		for _, alias := range img.Aliases {
-			if alias.Name == aliasName {
-				return true, nil
-			}
+		if img.HasAlias(aliasName) {
+			return true, nil
 		}
 	}
 
@@ -382,21 +335,11 @@ func ImageExists(aliasName string) (bool, error) {
 
 // ListImagesByPrefix lists images by alias prefix
 func ListImagesByPrefix(prefix string) ([]string, error) {
-	output, err := IncusOutput("image", "list", "--format=json")
+	images, err := GetBackend().ListImages()
 	if err != nil {
 		return nil, err
 	}
 
-	var images []struct {
-		Aliases []struct {
-			Name string `json:"name"`
-		} `json:"aliases"`
-	}
-
-	if err := json.Unmarshal([]byte(output), &images); err != nil {
-		return nil, err
-	}
-
 	var matching []string
 	for _, img := range images {
 		for _, alias := range img.Aliases {
@@ -411,27 +354,19 @@ func ListImagesByPrefix(prefix string) ([]string, error) {
 
 // ListContainers lists all containers matching a name pattern
 func ListContainers(pattern string) ([]string, error) {
-	output, err := IncusOutput("list", "--format=json")
-	if err != nil {
-		return nil, err
-	}
-
-	var containers []struct {
-		Name string `json:"name"`
-	}
-
-	if err := json.Unmarshal([]byte(output), &containers); err != nil {
-		return nil, err
-	}
-
 	// Compile pattern as regex
 	re, err := regexp.Compile(pattern)
 	if err != nil {
 		return nil, fmt.Errorf("invalid pattern: %w", err)
 	}
 
+	instances, err := GetBackend().ListInstances()
+	if err != nil {
+		return nil, err
+	}
+
 	var matching []string
-	for _, c := range containers {
+	for _, c := range instances {
 		if re.MatchString(c.Name) {
 			matching = append(matching, c.Name)
 		}
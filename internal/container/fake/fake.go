@@ -0,0 +1,666 @@
+// Package fake provides an in-memory container.Backend for unit tests
+// It tracks instances, devices, files, images and exec calls without talking to Incus
+package fake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+// ExecCall records a single Exec invocation
+type ExecCall struct {
+	Instance string
+	Command  []string
+	Opts     container.ExecCommandOptions
+}
+
+// ExecHandler lets tests script exec results, returning output and error for a call
+type ExecHandler func(call ExecCall) (string, error)
+
+// Backend implements container.Backend in memory
+type Backend struct {
+	mu        sync.Mutex
+	instances map[string]*instance
+	images    map[string]*container.Image // fingerprint -> image
+	execCalls []ExecCall
+	handler   ExecHandler
+	imageSeq  int
+}
+
+type instance struct {
+	info      container.Instance
+	files     map[string][]byte // absolute path -> content
+	snapshots []snapshot        // Oldest first
+}
+
+type snapshot struct {
+	info     container.Snapshot
+	instance container.Instance // Config and devices at snapshot time
+	files    map[string][]byte
+}
+
+// New creates an empty fake backend
+func New() *Backend {
+	return &Backend{
+		instances: make(map[string]*instance),
+		images:    make(map[string]*container.Image),
+	}
+}
+
+// SetExecHandler scripts exec results (nil restores the default of succeeding with no output)
+func (b *Backend) SetExecHandler(handler ExecHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handler = handler
+}
+
+// AddImage registers a local image under the given alias and returns its fingerprint
+func (b *Backend) AddImage(alias string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.addImage(alias, nil)
+}
+
+// AddInstance registers an existing instance with the given status ("Running" or "Stopped")
+func (b *Backend) AddInstance(name, status string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.instances[name] = &instance{
+		info: container.Instance{
+			Name:      name,
+			Status:    status,
+			CreatedAt: time.Now(),
+			Config:    map[string]string{},
+			Devices:   map[string]map[string]string{},
+		},
+		files: make(map[string][]byte),
+	}
+}
+
+// SetStatus changes the status of an instance (e.g., to simulate 'sudo shutdown 0')
+func (b *Backend) SetStatus(name, status string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if inst, ok := b.instances[name]; ok {
+		inst.info.Status = status
+	}
+}
+
+// WriteFile stores a file in an instance
+func (b *Backend) WriteFile(name, containerPath string, content []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	inst.files[path.Clean(containerPath)] = content
+	return nil
+}
+
+// File returns the content of a file in an instance
+func (b *Backend) File(name, containerPath string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, ok := b.instances[name]
+	if !ok {
+		return nil, false
+	}
+	content, ok := inst.files[path.Clean(containerPath)]
+	return content, ok
+}
+
+// Instance returns a copy of an instance, or nil if it does not exist
+func (b *Backend) Instance(name string) *container.Instance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, ok := b.instances[name]
+	if !ok {
+		return nil
+	}
+	info := copyInstance(inst.info)
+	return &info
+}
+
+// ExecCalls returns all recorded exec calls in order
+func (b *Backend) ExecCalls() []ExecCall {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]ExecCall(nil), b.execCalls...)
+}
+
+// ExecCommands returns recorded exec calls joined into single strings (handy for substring checks)
+func (b *Backend) ExecCommands() []string {
+	calls := b.ExecCalls()
+	commands := make([]string, 0, len(calls))
+	for _, call := range calls {
+		commands = append(commands, strings.Join(call.Command, " "))
+	}
+	return commands
+}
+
+func (b *Backend) Name() string {
+	return "fake"
+}
+
+func (b *Backend) ListInstances() ([]container.Instance, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names := make([]string, 0, len(b.instances))
+	for name := range b.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	instances := make([]container.Instance, 0, len(names))
+	for _, name := range names {
+		instances = append(instances, copyInstance(b.instances[name].info))
+	}
+	return instances, nil
+}
+
+func (b *Backend) GetInstance(name string) (*container.Instance, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return nil, err
+	}
+	info := copyInstance(inst.info)
+	return &info, nil
+}
+
+func (b *Backend) CreateInstance(name string, opts container.CreateOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.instances[name]; exists {
+		return fmt.Errorf("instance %s already exists", name)
+	}
+	if b.findImage(opts.Image) == nil {
+		return fmt.Errorf("image %s: %w", opts.Image, container.ErrNotFound)
+	}
+
+	status := "Stopped"
+	if opts.Start {
+		status = "Running"
+	}
+
+	config := make(map[string]string, len(opts.Config))
+	for k, v := range opts.Config {
+		config[k] = v
+	}
+
+	b.instances[name] = &instance{
+		info: container.Instance{
+			Name:      name,
+			Status:    status,
+			Ephemeral: opts.Ephemeral,
+			CreatedAt: time.Now(),
+			Config:    config,
+			Devices:   map[string]map[string]string{},
+		},
+		files: make(map[string][]byte),
+	}
+	return nil
+}
+
+func (b *Backend) StartInstance(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	if inst.info.Running() {
+		return fmt.Errorf("instance %s is already running", name)
+	}
+	inst.info.Status = "Running"
+	return nil
+}
+
+func (b *Backend) StopInstance(name string, force bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	if !inst.info.Running() {
+		return fmt.Errorf("instance %s is already stopped", name)
+	}
+
+	// Ephemeral instances are deleted when they stop
+	if inst.info.Ephemeral {
+		delete(b.instances, name)
+		return nil
+	}
+	inst.info.Status = "Stopped"
+	return nil
+}
+
+func (b *Backend) DeleteInstance(name string, force bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	if inst.info.Running() && !force {
+		return fmt.Errorf("instance %s is running, stop it first", name)
+	}
+	delete(b.instances, name)
+	return nil
+}
+
+func (b *Backend) SetConfig(name, key, value string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	inst.info.Config[key] = value
+	return nil
+}
+
+func (b *Backend) AddDevice(name, device string, config map[string]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	if _, exists := inst.info.Devices[device]; exists {
+		return fmt.Errorf("device %s already exists on %s", device, name)
+	}
+	if config["type"] == "" {
+		return fmt.Errorf("device %s: missing type", device)
+	}
+
+	deviceConfig := make(map[string]string, len(config))
+	for k, v := range config {
+		deviceConfig[k] = v
+	}
+	inst.info.Devices[device] = deviceConfig
+	return nil
+}
+
+func (b *Backend) SetDeviceConfig(name, device string, config map[string]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	deviceConfig, exists := inst.info.Devices[device]
+	if !exists {
+		return fmt.Errorf("device %s does not exist on %s", device, name)
+	}
+	for k, v := range config {
+		deviceConfig[k] = v
+	}
+	return nil
+}
+
+func (b *Backend) Exec(name string, command []string, opts container.ExecCommandOptions) (string, error) {
+	b.mu.Lock()
+	call := ExecCall{Instance: name, Command: append([]string(nil), command...), Opts: opts}
+	b.execCalls = append(b.execCalls, call)
+
+	inst, err := b.get(name)
+	if err != nil {
+		b.mu.Unlock()
+		return "", err
+	}
+	running := inst.info.Running()
+	handler := b.handler
+	b.mu.Unlock()
+
+	if !running {
+		return "", fmt.Errorf("instance %s is not running", name)
+	}
+	if handler != nil {
+		return handler(call)
+	}
+	return "", nil
+}
+
+func (b *Backend) PushFile(name, localPath, containerPath string) error {
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	return b.WriteFile(name, containerPath, content)
+}
+
+func (b *Backend) PushDirectory(name, localPath, containerParent string) error {
+	files := make(map[string][]byte)
+	base := filepath.Base(localPath)
+	err := filepath.Walk(localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, p)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[path.Join(containerParent, base, filepath.ToSlash(rel))] = content
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	for p, content := range files {
+		inst.files[p] = content
+	}
+	return nil
+}
+
+func (b *Backend) PullDirectory(name, containerPath, localParent string) error {
+	b.mu.Lock()
+	inst, err := b.get(name)
+	if err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	prefix := path.Clean(containerPath) + "/"
+	files := make(map[string][]byte)
+	for p, content := range inst.files {
+		if strings.HasPrefix(p, prefix) {
+			files[strings.TrimPrefix(p, prefix)] = content
+		}
+	}
+	b.mu.Unlock()
+
+	if len(files) == 0 {
+		return fmt.Errorf("%s: %w", containerPath, container.ErrNotFound)
+	}
+
+	dest := filepath.Join(localParent, path.Base(containerPath))
+	for rel, content := range files {
+		localPath := filepath.Join(dest, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(localPath, content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backend) PullFile(name, containerPath, localPath string) error {
+	content, ok := b.File(name, containerPath)
+	if !ok {
+		return fmt.Errorf("%s: %w", containerPath, container.ErrNotFound)
+	}
+	return os.WriteFile(localPath, content, 0o644)
+}
+
+func (b *Backend) ListImages() ([]container.Image, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fingerprints := make([]string, 0, len(b.images))
+	for fingerprint := range b.images {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	sort.Strings(fingerprints)
+
+	images := make([]container.Image, 0, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		img := *b.images[fingerprint]
+		img.Aliases = append([]container.ImageAlias(nil), img.Aliases...)
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+func (b *Backend) DeleteImage(fingerprintOrAlias string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	img := b.findImage(fingerprintOrAlias)
+	if img == nil {
+		return fmt.Errorf("image %s: %w", fingerprintOrAlias, container.ErrNotFound)
+	}
+	delete(b.images, img.Fingerprint)
+	return nil
+}
+
+func (b *Backend) CreateImageAlias(alias, fingerprint string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	img, ok := b.images[fingerprint]
+	if !ok {
+		return fmt.Errorf("image %s: %w", fingerprint, container.ErrNotFound)
+	}
+	if b.findImage(alias) != nil {
+		return fmt.Errorf("alias %s already exists", alias)
+	}
+	img.Aliases = append(img.Aliases, container.ImageAlias{Name: alias})
+	return nil
+}
+
+func (b *Backend) DeleteImageAlias(alias string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, img := range b.images {
+		for i, a := range img.Aliases {
+			if a.Name == alias {
+				img.Aliases = append(img.Aliases[:i], img.Aliases[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("alias %s: %w", alias, container.ErrNotFound)
+}
+
+func (b *Backend) PublishInstance(name, alias string, properties map[string]string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return "", err
+	}
+	if inst.info.Running() {
+		return "", fmt.Errorf("instance %s must be stopped to publish", name)
+	}
+	if b.findImage(alias) != nil {
+		return "", fmt.Errorf("alias %s already exists", alias)
+	}
+	return b.addImage(alias, properties), nil
+}
+
+func (b *Backend) CreateSnapshot(name, snapshotName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	if inst.snapshot(snapshotName) != nil {
+		return fmt.Errorf("snapshot %s/%s already exists", name, snapshotName)
+	}
+	inst.snapshots = append(inst.snapshots, snapshot{
+		info:     container.Snapshot{Name: snapshotName, CreatedAt: time.Now(), Size: -1},
+		instance: copyInstance(inst.info),
+		files:    copyFiles(inst.files),
+	})
+	return nil
+}
+
+func (b *Backend) ListSnapshots(name string) ([]container.Snapshot, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]container.Snapshot, 0, len(inst.snapshots))
+	for _, snap := range inst.snapshots {
+		snapshots = append(snapshots, snap.info)
+	}
+	return snapshots, nil
+}
+
+func (b *Backend) RestoreSnapshot(name, snapshotName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	snap := inst.snapshot(snapshotName)
+	if snap == nil {
+		return fmt.Errorf("snapshot %s/%s: %w", name, snapshotName, container.ErrNotFound)
+	}
+	restored := copyInstance(snap.instance)
+	inst.info.Config = restored.Config
+	inst.info.Devices = restored.Devices
+	inst.files = copyFiles(snap.files)
+	return nil
+}
+
+func (b *Backend) DeleteSnapshot(name, snapshotName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, err := b.get(name)
+	if err != nil {
+		return err
+	}
+	for i, snap := range inst.snapshots {
+		if snap.info.Name == snapshotName {
+			inst.snapshots = append(inst.snapshots[:i], inst.snapshots[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("snapshot %s/%s: %w", name, snapshotName, container.ErrNotFound)
+}
+
+// snapshot returns a snapshot by name, or nil (caller holds the lock)
+func (inst *instance) snapshot(name string) *snapshot {
+	for i := range inst.snapshots {
+		if inst.snapshots[i].info.Name == name {
+			return &inst.snapshots[i]
+		}
+	}
+	return nil
+}
+
+// copyFiles copies file contents so snapshots do not share state with the instance
+func copyFiles(files map[string][]byte) map[string][]byte {
+	copied := make(map[string][]byte, len(files))
+	for p, content := range files {
+		copied[p] = append([]byte(nil), content...)
+	}
+	return copied
+}
+
+// get returns an instance or an error matching container.ErrNotFound (caller holds the lock)
+func (b *Backend) get(name string) (*instance, error) {
+	inst, ok := b.instances[name]
+	if !ok {
+		return nil, fmt.Errorf("instance %s: %w", name, container.ErrNotFound)
+	}
+	return inst, nil
+}
+
+// findImage looks up an image by fingerprint or alias (caller holds the lock)
+func (b *Backend) findImage(fingerprintOrAlias string) *container.Image {
+	if img, ok := b.images[fingerprintOrAlias]; ok {
+		return img
+	}
+	for _, img := range b.images {
+		if img.HasAlias(fingerprintOrAlias) {
+			return img
+		}
+	}
+	return nil
+}
+
+// addImage stores a new image with a deterministic fingerprint (caller holds the lock)
+func (b *Backend) addImage(alias string, properties map[string]string) string {
+	b.imageSeq++
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s-%d", alias, b.imageSeq)))
+	fingerprint := hex.EncodeToString(sum[:])
+
+	props := make(map[string]string, len(properties))
+	for k, v := range properties {
+		props[k] = v
+	}
+
+	now := time.Now()
+	b.images[fingerprint] = &container.Image{
+		Fingerprint: fingerprint,
+		Aliases:     []container.ImageAlias{{Name: alias}},
+		Properties:  props,
+		CreatedAt:   now,
+		UploadedAt:  now,
+	}
+	return fingerprint
+}
+
+// copyInstance deep-copies an instance so callers cannot mutate backend state
+func copyInstance(info container.Instance) container.Instance {
+	config := make(map[string]string, len(info.Config))
+	for k, v := range info.Config {
+		config[k] = v
+	}
+	devices := make(map[string]map[string]string, len(info.Devices))
+	for name, device := range info.Devices {
+		deviceConfig := make(map[string]string, len(device))
+		for k, v := range device {
+			deviceConfig[k] = v
+		}
+		devices[name] = deviceConfig
+	}
+	info.Config = config
+	info.Devices = devices
+
+	// Expanded views include the default profile, which provides the root disk
+	info.ExpandedConfig = make(map[string]string, len(config))
+	for k, v := range config {
+		info.ExpandedConfig[k] = v
+	}
+	info.ExpandedDevices = make(map[string]map[string]string, len(devices)+len(defaultProfileDevices))
+	for _, source := range []map[string]map[string]string{defaultProfileDevices, devices} {
+		for name, device := range source {
+			deviceConfig := make(map[string]string, len(device))
+			for k, v := range device {
+				deviceConfig[k] = v
+			}
+			info.ExpandedDevices[name] = deviceConfig
+		}
+	}
+	return info
+}
+
+// defaultProfileDevices are the devices every instance inherits, like Incus' default profile
+var defaultProfileDevices = map[string]map[string]string{
+	"root": {"type": "disk", "path": "/", "pool": "default"},
+}
+
+var _ container.Backend = (*Backend)(nil)
@@ -1,11 +1,13 @@
 package container
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -39,20 +41,30 @@ func (m *Manager) Launch(image string, ephemeral bool) error {
 	return LaunchContainerPersistent(image, m.ContainerName)
 }
 
+// Init creates the container like Launch, but without starting it
+// Limits can be set before the first Start()
+func (m *Manager) Init(image string, ephemeral bool) error {
+	return GetBackend().CreateInstance(m.ContainerName, CreateOptions{
+		Image:     image,
+		Ephemeral: ephemeral,
+		Config:    dockerSupportConfig(),
+	})
+}
+
+// Create creates the container from an image without starting it
+// Devices and config can be added before the first Start()
+func (m *Manager) Create(image string) error {
+	return GetBackend().CreateInstance(m.ContainerName, CreateOptions{Image: image})
+}
+
 // Stop stops the container
 func (m *Manager) Stop(force bool) error {
-	if force {
-		return StopContainer(m.ContainerName)
-	}
-	return IncusExec("stop", m.ContainerName)
+	return GetBackend().StopInstance(m.ContainerName, force)
 }
 
 // Delete deletes the container
 func (m *Manager) Delete(force bool) error {
-	if force {
-		return DeleteContainer(m.ContainerName)
-	}
-	return IncusExec("delete", m.ContainerName)
+	return GetBackend().DeleteInstance(m.ContainerName, force)
 }
 
 // Running checks if the container is running
@@ -62,109 +74,144 @@ func (m *Manager) Running() (bool, error) {
 
 // Exists checks if container exists (running or stopped)
 func (m *Manager) Exists() (bool, error) {
-	output, err := IncusOutput("list", "^"+m.ContainerName+"$", "--format=csv", "--columns=n")
+	_, err := GetBackend().GetInstance(m.ContainerName)
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
 		return false, err
 	}
-	return len(output) > 0 && output != "\n", nil
+	return true, nil
 }
 
 // Start starts a stopped container
 func (m *Manager) Start() error {
-	return IncusExec("start", m.ContainerName)
+	return GetBackend().StartInstance(m.ContainerName)
 }
 
-// MountDisk adds a disk device to the container
-func (m *Manager) MountDisk(name, source, path string, shift bool) error {
-	args := []string{
-		"config", "device", "add", m.ContainerName, name, "disk",
-		fmt.Sprintf("source=%s", source),
-		fmt.Sprintf("path=%s", path),
+// SetConfig sets a container config key (e.g., raw.idmap)
+func (m *Manager) SetConfig(key, value string) error {
+	return GetBackend().SetConfig(m.ContainerName, key, value)
+}
+
+// SetRootDiskSize sets the size of the container's root disk
+// A root disk inherited from a profile is copied to the container first (like `incus config device override`)
+func (m *Manager) SetRootDiskSize(size string) error {
+	instance, err := GetBackend().GetInstance(m.ContainerName)
+	if err != nil {
+		return err
 	}
-	if shift {
-		args = append(args, "shift=true")
+
+	name, root := instance.RootDisk()
+	if name == "" {
+		return fmt.Errorf("container %s has no root disk", m.ContainerName)
+	}
+	if _, local := instance.Devices[name]; local {
+		return GetBackend().SetDeviceConfig(m.ContainerName, name, map[string]string{"size": size})
 	}
 
-	return IncusExec(args...)
+	device := make(map[string]string, len(root)+1)
+	for k, v := range root {
+		device[k] = v
+	}
+	device["size"] = size
+	return GetBackend().AddDevice(m.ContainerName, name, device)
 }
 
-// Exec executes a command in the container (no output capture)
-func (m *Manager) Exec(args ...string) error {
-	cmdArgs := append([]string{"exec", m.ContainerName, "--"}, args...)
-	return IncusExec(cmdArgs...)
+// CreateSnapshot takes a snapshot of the container
+func (m *Manager) CreateSnapshot(name string) error {
+	return GetBackend().CreateSnapshot(m.ContainerName, name)
 }
 
-// ExecArgs executes command arguments in the container with options
-func (m *Manager) ExecArgs(commandArgs []string, opts ExecCommandOptions) error {
-	args := []string{"exec", m.ContainerName}
+// ListSnapshots returns the container's snapshots, oldest first
+func (m *Manager) ListSnapshots() ([]Snapshot, error) {
+	return GetBackend().ListSnapshots(m.ContainerName)
+}
 
-	// Add force-interactive flag for interactive sessions (required for tmux attach)
-	if opts.Interactive {
-		args = append(args, "--force-interactive")
-	}
+// RestoreSnapshot rolls the container back to a snapshot
+func (m *Manager) RestoreSnapshot(name string) error {
+	return GetBackend().RestoreSnapshot(m.ContainerName, name)
+}
 
-	// Add environment variables
-	for k, v := range opts.Env {
-		args = append(args, "--env", fmt.Sprintf("%s=%s", k, v))
-	}
+// DeleteSnapshot deletes a snapshot of the container
+func (m *Manager) DeleteSnapshot(name string) error {
+	return GetBackend().DeleteSnapshot(m.ContainerName, name)
+}
 
-	// Add working directory
-	if opts.Cwd != "" {
-		args = append(args, "--cwd", opts.Cwd)
+// MountDisk adds a disk device to the container
+func (m *Manager) MountDisk(name, source, path string, shift bool) error {
+	device := map[string]string{
+		"type":   "disk",
+		"source": source,
+		"path":   path,
 	}
-
-	// Add user/group
-	if opts.User != nil {
-		args = append(args, "--user", fmt.Sprintf("%d", *opts.User))
-		group := opts.User // default to same as user
-		if opts.Group != nil {
-			group = opts.Group
-		}
-		args = append(args, "--group", fmt.Sprintf("%d", *group))
+	if shift {
+		device["shift"] = "true"
 	}
 
-	// Add command arguments
-	args = append(args, "--")
-	args = append(args, commandArgs...)
+	return GetBackend().AddDevice(m.ContainerName, name, device)
+}
 
-	// Support interactive mode
-	if opts.Interactive {
-		return IncusExecInteractive(args...)
+// MountDiskReadOnly adds a read-only disk device to the container
+func (m *Manager) MountDiskReadOnly(name, source, path string, shift bool) error {
+	device := map[string]string{
+		"type":     "disk",
+		"source":   source,
+		"path":     path,
+		"readonly": "true",
+	}
+	if shift {
+		device["shift"] = "true"
 	}
 
-	return IncusExec(args...)
+	return GetBackend().AddDevice(m.ContainerName, name, device)
 }
 
-// ExecArgsCapture executes a command with raw arguments and captures output (no bash -c wrapping, preserves whitespace)
-func (m *Manager) ExecArgsCapture(commandArgs []string, opts ExecCommandOptions) (string, error) {
-	args := []string{"exec", m.ContainerName}
-
-	// Add environment variables
-	for k, v := range opts.Env {
-		args = append(args, "--env", fmt.Sprintf("%s=%s", k, v))
-	}
+// AddUnixSocketProxy exposes a host unix socket inside the container at containerSocket,
+// owned by uid with mode 0600
+func (m *Manager) AddUnixSocketProxy(name, hostSocket, containerSocket string, uid int) error {
+	device := map[string]string{
+		"type":    "proxy",
+		"connect": "unix:" + hostSocket,
+		"listen":  "unix:" + containerSocket,
+		"bind":    "container",
+		"uid":     strconv.Itoa(uid),
+		"gid":     strconv.Itoa(uid),
+		"mode":    "0600",
+	}
+	return GetBackend().AddDevice(m.ContainerName, name, device)
+}
 
-	// Add working directory
-	if opts.Cwd != "" {
-		args = append(args, "--cwd", opts.Cwd)
+// AddLoopbackProxy exposes a host unix socket inside the container as a TCP listener on
+// the container's loopback interface (containerAddress, e.g. 127.0.0.1:7431)
+func (m *Manager) AddLoopbackProxy(name, hostSocket, containerAddress string) error {
+	device := map[string]string{
+		"type":    "proxy",
+		"connect": "unix:" + hostSocket,
+		"listen":  "tcp:" + containerAddress,
+		"bind":    "container",
 	}
+	return GetBackend().AddDevice(m.ContainerName, name, device)
+}
 
-	// Add user/group
-	if opts.User != nil {
-		args = append(args, "--user", fmt.Sprintf("%d", *opts.User))
-		group := opts.User // default to same as user
-		if opts.Group != nil {
-			group = opts.Group
-		}
-		args = append(args, "--group", fmt.Sprintf("%d", *group))
-	}
+// Exec executes a command in the container (no output capture)
+func (m *Manager) Exec(args ...string) error {
+	_, err := GetBackend().Exec(m.ContainerName, args, ExecCommandOptions{})
+	return err
+}
 
-	// Add command arguments
-	args = append(args, "--")
-	args = append(args, commandArgs...)
+// ExecArgs executes command arguments in the container with options
+func (m *Manager) ExecArgs(commandArgs []string, opts ExecCommandOptions) error {
+	opts.Capture = false
+	_, err := GetBackend().Exec(m.ContainerName, commandArgs, opts)
+	return err
+}
 
-	// Use IncusOutputRaw to preserve whitespace
-	return IncusOutputRaw(args...)
+// ExecArgsCapture executes a command with raw arguments and captures output (no bash -c wrapping, preserves whitespace)
+func (m *Manager) ExecArgsCapture(commandArgs []string, opts ExecCommandOptions) (string, error) {
+	opts.Capture = true
+	opts.Interactive = false
+	return GetBackend().Exec(m.ContainerName, commandArgs, opts)
 }
 
 // ExecCommandOptions holds options for executing commands
@@ -179,45 +226,8 @@ type ExecCommandOptions struct {
 
 // ExecCommand executes a bash command in the container with user context
 func (m *Manager) ExecCommand(command string, opts ExecCommandOptions) (string, error) {
-	args := []string{"exec", m.ContainerName}
-
-	// Add force-interactive flag for interactive sessions (required for tmux attach)
-	if opts.Interactive {
-		args = append(args, "--force-interactive")
-	}
-
-	// Add environment variables
-	for k, v := range opts.Env {
-		args = append(args, "--env", fmt.Sprintf("%s=%s", k, v))
-	}
-
-	// Add working directory
-	if opts.Cwd != "" {
-		args = append(args, "--cwd", opts.Cwd)
-	}
-
-	// Add user/group
-	if opts.User != nil {
-		args = append(args, "--user", fmt.Sprintf("%d", *opts.User))
-		group := opts.User // default to same as user
-		if opts.Group != nil {
-			group = opts.Group
-		}
-		args = append(args, "--group", fmt.Sprintf("%d", *group))
-	}
-
-	// Add command
-	args = append(args, "--", "bash", "-c", command)
-
-	if opts.Capture {
-		return IncusOutput(args...)
-	}
-
-	if opts.Interactive {
-		return "", IncusExecInteractive(args...)
-	}
-
-	return "", IncusExec(args...)
+	output, err := GetBackend().Exec(m.ContainerName, []string{"bash", "-c", command}, opts)
+	return strings.TrimSpace(output), err
 }
 
 // PushFile pushes a file into the container
@@ -226,13 +236,12 @@ func (m *Manager) PushFile(source, destination string) error {
 	if destination[0] != '/' {
 		destination = "/" + destination
 	}
-	dest := m.ContainerName + destination
-	return IncusFilePush(source, dest)
+	return GetBackend().PushFile(m.ContainerName, source, destination)
 }
 
 // PullDirectory pulls a directory from the container recursively
 func (m *Manager) PullDirectory(containerPath, localPath string) error {
-	// Incus creates a subdirectory when pulling, so we pull to a temp location
+	// Pulling creates a subdirectory named after the source, so we pull to a temp location
 	// then move the contents to the desired location
 	tempDir, err := os.MkdirTemp("", "coi-pull-*")
 	if err != nil {
@@ -241,8 +250,7 @@ func (m *Manager) PullDirectory(containerPath, localPath string) error {
 	defer os.RemoveAll(tempDir)
 
 	// Pull to temp directory (creates tempDir/dirname/)
-	source := m.ContainerName + containerPath
-	if err := IncusExec("file", "pull", "-r", source, tempDir); err != nil {
+	if err := GetBackend().PullDirectory(m.ContainerName, containerPath, tempDir); err != nil {
 		return err
 	}
 
@@ -268,6 +276,11 @@ func (m *Manager) PullDirectory(containerPath, localPath string) error {
 	return os.Rename(pulledDir, localPath)
 }
 
+// PullFile pulls a file from the container
+func (m *Manager) PullFile(containerPath, localPath string) error {
+	return GetBackend().PullFile(m.ContainerName, containerPath, localPath)
+}
+
 // PushDirectory pushes a directory to the container recursively
 func (m *Manager) PushDirectory(localPath, containerPath string) error {
 	// Check if source directory exists
@@ -275,15 +288,14 @@ func (m *Manager) PushDirectory(localPath, containerPath string) error {
 		return nil // Skip if not a directory (intentional nilerr)
 	}
 
-	// Incus creates a subdirectory when pushing, so we push to the parent
+	// Pushing creates a subdirectory named after the source, so we push to the parent
 	// e.g., pushing /local/dir to container/remote/parent/ creates /remote/parent/dir
 	// To get /remote/dir, we need to push to container/remote/
 	parentPath := containerPath[:strings.LastIndex(containerPath, "/")+1]
 	if parentPath == "" {
 		parentPath = "/"
 	}
-	dest := m.ContainerName + parentPath
-	return IncusExec("file", "push", "-r", localPath, dest)
+	return GetBackend().PushDirectory(m.ContainerName, localPath, parentPath)
 }
 
 // Chown changes ownership of a path in the container
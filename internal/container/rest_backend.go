@@ -0,0 +1,634 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SocketPath returns the Incus unix socket path
+// INCUS_SOCKET takes precedence, then $INCUS_DIR/unix.socket, then /var/lib/incus/unix.socket
+func SocketPath() string {
+	if socket := os.Getenv("INCUS_SOCKET"); socket != "" {
+		return socket
+	}
+	dir := os.Getenv("INCUS_DIR")
+	if dir == "" {
+		dir = "/var/lib/incus"
+	}
+	return filepath.Join(dir, "unix.socket")
+}
+
+// RESTBackend implements Backend by talking to the Incus REST API over the unix socket
+// Operations that need a live terminal (interactive or streamed exec) and images from
+// remotes (e.g., "images:ubuntu/22.04") are delegated to the fallback backend
+type RESTBackend struct {
+	socketPath string
+	client     *http.Client
+	fallback   Backend
+}
+
+// NewRESTBackend creates a backend for the Incus socket at socketPath
+func NewRESTBackend(socketPath string, fallback Backend) *RESTBackend {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &RESTBackend{
+		socketPath: socketPath,
+		client:     &http.Client{Transport: transport},
+		fallback:   fallback,
+	}
+}
+
+func (r *RESTBackend) Name() string {
+	return "rest"
+}
+
+// apiResponse is the envelope of every Incus API response
+type apiResponse struct {
+	Type       string          `json:"type"` // "sync", "async" or "error"
+	StatusCode int             `json:"status_code"`
+	ErrorCode  int             `json:"error_code"`
+	Error      string          `json:"error"`
+	Metadata   json.RawMessage `json:"metadata"`
+	Operation  string          `json:"operation"`
+}
+
+// apiOperation is the metadata of a background operation
+type apiOperation struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	StatusCode int             `json:"status_code"`
+	Err        string          `json:"err"`
+	Metadata   json.RawMessage `json:"metadata"`
+}
+
+// Ping checks that the socket is reachable and we are trusted by the daemon
+func (r *RESTBackend) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var server struct {
+		Auth string `json:"auth"`
+	}
+	resp, err := r.rawRequest(ctx, http.MethodGet, "/1.0", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := decodeResponse(resp, &server); err != nil {
+		return err
+	}
+	if server.Auth != "trusted" {
+		return fmt.Errorf("not trusted by incus daemon at %s", r.socketPath)
+	}
+	return nil
+}
+
+func (r *RESTBackend) ListInstances() ([]Instance, error) {
+	var instances []Instance
+	if err := r.query(http.MethodGet, "/1.0/instances?recursion=2", nil, &instances); err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+	return instances, nil
+}
+
+func (r *RESTBackend) GetInstance(name string) (*Instance, error) {
+	var instance Instance
+	if err := r.query(http.MethodGet, instancePath(name), nil, &instance); err != nil {
+		return nil, fmt.Errorf("instance %s: %w", name, err)
+	}
+
+	var state InstanceState
+	if err := r.query(http.MethodGet, instancePath(name)+"/state", nil, &state); err != nil {
+		return nil, fmt.Errorf("instance %s state: %w", name, err)
+	}
+	instance.State = &state
+
+	return &instance, nil
+}
+
+func (r *RESTBackend) CreateInstance(name string, opts CreateOptions) error {
+	// Remote images need the remote's server URL and protocol, which only the client config knows
+	if strings.Contains(opts.Image, ":") {
+		return r.fallback.CreateInstance(name, opts)
+	}
+
+	req := map[string]interface{}{
+		"name":      name,
+		"ephemeral": opts.Ephemeral,
+		"config":    opts.Config,
+		"start":     opts.Start,
+		"source": map[string]string{
+			"type":  "image",
+			"alias": opts.Image,
+		},
+	}
+	return r.query(http.MethodPost, "/1.0/instances", req, nil)
+}
+
+func (r *RESTBackend) StartInstance(name string) error {
+	return r.changeState(name, "start", false)
+}
+
+func (r *RESTBackend) StopInstance(name string, force bool) error {
+	return r.changeState(name, "stop", force)
+}
+
+func (r *RESTBackend) DeleteInstance(name string, force bool) error {
+	if force {
+		instance, err := r.GetInstance(name)
+		if err != nil {
+			return err
+		}
+		if instance.Running() {
+			if err := r.StopInstance(name, true); err != nil {
+				return err
+			}
+		}
+	}
+	return r.query(http.MethodDelete, instancePath(name), nil, nil)
+}
+
+func (r *RESTBackend) SetConfig(name, key, value string) error {
+	req := map[string]interface{}{
+		"config": map[string]string{key: value},
+	}
+	return r.query(http.MethodPatch, instancePath(name), req, nil)
+}
+
+func (r *RESTBackend) AddDevice(name, device string, config map[string]string) error {
+	instance, err := r.GetInstance(name)
+	if err != nil {
+		return err
+	}
+	if _, exists := instance.Devices[device]; exists {
+		return fmt.Errorf("device %s already exists on %s", device, name)
+	}
+
+	// PATCH merges devices with the existing ones
+	req := map[string]interface{}{
+		"devices": map[string]map[string]string{device: config},
+	}
+	return r.query(http.MethodPatch, instancePath(name), req, nil)
+}
+
+func (r *RESTBackend) SetDeviceConfig(name, device string, config map[string]string) error {
+	instance, err := r.GetInstance(name)
+	if err != nil {
+		return err
+	}
+	existing, ok := instance.Devices[device]
+	if !ok {
+		return fmt.Errorf("device %s does not exist on %s", device, name)
+	}
+
+	// PATCH replaces whole devices, so send the merged config
+	merged := make(map[string]string, len(existing)+len(config))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range config {
+		merged[k] = v
+	}
+	req := map[string]interface{}{
+		"devices": map[string]map[string]string{device: merged},
+	}
+	return r.query(http.MethodPatch, instancePath(name), req, nil)
+}
+
+func (r *RESTBackend) Exec(name string, command []string, opts ExecCommandOptions) (string, error) {
+	// Streaming output and terminals need websockets - leave those to the CLI
+	if !opts.Capture || opts.Interactive {
+		return r.fallback.Exec(name, command, opts)
+	}
+
+	req := map[string]interface{}{
+		"command":            command,
+		"environment":        opts.Env,
+		"wait-for-websocket": false,
+		"interactive":        false,
+		"record-output":      true,
+	}
+	if opts.Cwd != "" {
+		req["cwd"] = opts.Cwd
+	}
+	if opts.User != nil {
+		group := opts.User // default to same as user
+		if opts.Group != nil {
+			group = opts.Group
+		}
+		req["user"] = *opts.User
+		req["group"] = *group
+	}
+
+	var result struct {
+		Return int               `json:"return"`
+		Output map[string]string `json:"output"`
+	}
+	if err := r.query(http.MethodPost, instancePath(name)+"/exec", req, &result); err != nil {
+		return "", fmt.Errorf("failed to exec in %s: %w", name, err)
+	}
+
+	// Output is recorded to log files on the server - fetch stdout, then remove both logs
+	var stdout []byte
+	if logPath, ok := result.Output["1"]; ok {
+		data, err := r.readAll(http.MethodGet, logPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read exec output: %w", err)
+		}
+		stdout = data
+	}
+	for _, logPath := range result.Output {
+		_ = r.query(http.MethodDelete, logPath, nil, nil) // Best effort
+	}
+
+	if result.Return != 0 {
+		return string(stdout), &ExitError{
+			ExitCode: result.Return,
+			Err:      fmt.Errorf("exit status %d", result.Return),
+		}
+	}
+	return string(stdout), nil
+}
+
+func (r *RESTBackend) PushFile(name, localPath, containerPath string) error {
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return err
+	}
+	return r.pushEntry(name, localPath, containerPath, info)
+}
+
+func (r *RESTBackend) PushDirectory(name, localPath, containerParent string) error {
+	root := path.Join(containerParent, filepath.Base(localPath))
+
+	return filepath.Walk(localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, p)
+		if err != nil {
+			return err
+		}
+		return r.pushEntry(name, p, path.Join(root, filepath.ToSlash(rel)), info)
+	})
+}
+
+// pushEntry pushes a single file, directory or symlink, keeping local ownership and mode
+func (r *RESTBackend) pushEntry(name, localPath, containerPath string, info os.FileInfo) error {
+	headers := map[string]string{
+		"X-Incus-mode":  fmt.Sprintf("%04o", info.Mode().Perm()),
+		"X-Incus-write": "overwrite",
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		headers["X-Incus-uid"] = strconv.FormatUint(uint64(stat.Uid), 10)
+		headers["X-Incus-gid"] = strconv.FormatUint(uint64(stat.Gid), 10)
+	}
+
+	var body io.Reader
+	switch {
+	case info.IsDir():
+		headers["X-Incus-type"] = "directory"
+		// Creating a directory that already exists is fine
+		if entryType, _ := r.entryType(name, containerPath); entryType == "directory" {
+			return nil
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(localPath)
+		if err != nil {
+			return err
+		}
+		headers["X-Incus-type"] = "symlink"
+		body = strings.NewReader(target)
+	default:
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		headers["X-Incus-type"] = "file"
+		body = f
+	}
+
+	resp, err := r.rawRequest(context.Background(), http.MethodPost, filesPath(name, containerPath), headers, body)
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %w", localPath, err)
+	}
+	defer resp.Body.Close()
+
+	if err := decodeResponse(resp, nil); err != nil {
+		return fmt.Errorf("failed to push %s: %w", localPath, err)
+	}
+	return nil
+}
+
+func (r *RESTBackend) PullDirectory(name, containerPath, localParent string) error {
+	return r.pullEntry(name, containerPath, filepath.Join(localParent, path.Base(containerPath)))
+}
+
+func (r *RESTBackend) PullFile(name, containerPath, localPath string) error {
+	return r.pullEntry(name, containerPath, localPath)
+}
+
+// pullEntry pulls a file, directory (recursively) or symlink to localPath
+func (r *RESTBackend) pullEntry(name, containerPath, localPath string) error {
+	resp, err := r.rawRequest(context.Background(), http.MethodGet, filesPath(name, containerPath), nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", containerPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to pull %s: %w", containerPath, decodeResponse(resp, nil))
+	}
+
+	mode := os.FileMode(0o644)
+	if m, err := strconv.ParseUint(resp.Header.Get("X-Incus-mode"), 8, 32); err == nil {
+		mode = os.FileMode(m)
+	}
+
+	switch resp.Header.Get("X-Incus-type") {
+	case "directory":
+		var entries []string
+		if err := decodeResponse(resp, &entries); err != nil {
+			return fmt.Errorf("failed to list %s: %w", containerPath, err)
+		}
+		if err := os.MkdirAll(localPath, mode|0o700); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := r.pullEntry(name, path.Join(containerPath, entry), filepath.Join(localPath, entry)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "symlink":
+		target, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		os.Remove(localPath)
+		return os.Symlink(string(target), localPath)
+	default:
+		f, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// entryType returns the type ("file", "directory", "symlink") of a path in the instance
+func (r *RESTBackend) entryType(name, containerPath string) (string, error) {
+	resp, err := r.rawRequest(context.Background(), http.MethodGet, filesPath(name, containerPath), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", decodeResponse(resp, nil)
+	}
+	return resp.Header.Get("X-Incus-type"), nil
+}
+
+func (r *RESTBackend) ListImages() ([]Image, error) {
+	var images []Image
+	if err := r.query(http.MethodGet, "/1.0/images?recursion=1", nil, &images); err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	return images, nil
+}
+
+func (r *RESTBackend) DeleteImage(fingerprintOrAlias string) error {
+	fingerprint := fingerprintOrAlias
+
+	// Resolve aliases to the fingerprint they point at
+	var alias struct {
+		Target string `json:"target"`
+	}
+	err := r.query(http.MethodGet, "/1.0/images/aliases/"+url.PathEscape(fingerprintOrAlias), nil, &alias)
+	if err == nil {
+		fingerprint = alias.Target
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return r.query(http.MethodDelete, "/1.0/images/"+url.PathEscape(fingerprint), nil, nil)
+}
+
+func (r *RESTBackend) CreateImageAlias(alias, fingerprint string) error {
+	req := map[string]string{
+		"name":   alias,
+		"target": fingerprint,
+	}
+	return r.query(http.MethodPost, "/1.0/images/aliases", req, nil)
+}
+
+func (r *RESTBackend) DeleteImageAlias(alias string) error {
+	return r.query(http.MethodDelete, "/1.0/images/aliases/"+url.PathEscape(alias), nil, nil)
+}
+
+func (r *RESTBackend) PublishInstance(name, alias string, properties map[string]string) (string, error) {
+	req := map[string]interface{}{
+		"source": map[string]string{
+			"type": "instance",
+			"name": name,
+		},
+		"properties": properties,
+		"aliases":    []map[string]string{{"name": alias}},
+	}
+
+	var result struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := r.query(http.MethodPost, "/1.0/images", req, &result); err != nil {
+		return "", fmt.Errorf("failed to publish %s: %w", name, err)
+	}
+	if result.Fingerprint == "" {
+		return "", fmt.Errorf("could not extract fingerprint from publish operation")
+	}
+	return result.Fingerprint, nil
+}
+
+func (r *RESTBackend) CreateSnapshot(name, snapshot string) error {
+	req := map[string]interface{}{"name": snapshot}
+	if err := r.query(http.MethodPost, instancePath(name)+"/snapshots", req, nil); err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w", name, err)
+	}
+	return nil
+}
+
+func (r *RESTBackend) ListSnapshots(name string) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	if err := r.query(http.MethodGet, instancePath(name)+"/snapshots?recursion=1", nil, &snapshots); err != nil {
+		return nil, fmt.Errorf("failed to list snapshots of %s: %w", name, err)
+	}
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+func (r *RESTBackend) RestoreSnapshot(name, snapshot string) error {
+	// PUT with only "restore" set restores the snapshot instead of replacing the config
+	req := map[string]string{"restore": snapshot}
+	if err := r.query(http.MethodPut, instancePath(name), req, nil); err != nil {
+		return fmt.Errorf("failed to restore %s/%s: %w", name, snapshot, err)
+	}
+	return nil
+}
+
+func (r *RESTBackend) DeleteSnapshot(name, snapshot string) error {
+	return r.query(http.MethodDelete, instancePath(name)+"/snapshots/"+url.PathEscape(snapshot), nil, nil)
+}
+
+// changeState starts or stops an instance and waits for it
+func (r *RESTBackend) changeState(name, action string, force bool) error {
+	req := map[string]interface{}{
+		"action":  action,
+		"timeout": -1,
+		"force":   force,
+	}
+	return r.query(http.MethodPut, instancePath(name)+"/state", req, nil)
+}
+
+// query sends a JSON request and decodes the response metadata into target
+// Background operations are waited for, and target receives the operation's metadata
+func (r *RESTBackend) query(method, apiPath string, body, target interface{}) error {
+	var reqBody io.Reader
+	headers := map[string]string{}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+		headers["Content-Type"] = "application/json"
+	}
+
+	resp, err := r.rawRequest(context.Background(), method, apiPath, headers, reqBody)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if envelope.Type == "error" || resp.StatusCode >= 400 {
+		return &APIError{StatusCode: resp.StatusCode, Message: envelope.Error}
+	}
+
+	metadata := envelope.Metadata
+	if envelope.Type == "async" {
+		op, err := r.wait(envelope.Operation)
+		if err != nil {
+			return err
+		}
+		metadata = op.Metadata
+	}
+
+	if target == nil || len(metadata) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(metadata, target); err != nil {
+		return fmt.Errorf("failed to decode response metadata: %w", err)
+	}
+	return nil
+}
+
+// wait blocks until a background operation finishes
+func (r *RESTBackend) wait(operationPath string) (*apiOperation, error) {
+	var op apiOperation
+	if err := r.query(http.MethodGet, operationPath+"/wait?timeout=-1", nil, &op); err != nil {
+		return nil, fmt.Errorf("failed to wait for operation: %w", err)
+	}
+	if op.StatusCode != http.StatusOK {
+		return nil, &APIError{StatusCode: op.StatusCode, Message: op.Err}
+	}
+	return &op, nil
+}
+
+// readAll fetches a raw (non-JSON) resource such as an exec log file
+func (r *RESTBackend) readAll(method, apiPath string) ([]byte, error) {
+	resp, err := r.rawRequest(context.Background(), method, apiPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponse(resp, nil)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// rawRequest sends a request scoped to the configured project
+func (r *RESTBackend) rawRequest(ctx context.Context, method, apiPath string, headers map[string]string, body io.Reader) (*http.Response, error) {
+	separator := "?"
+	if strings.Contains(apiPath, "?") {
+		separator = "&"
+	}
+	requestURL := "http://incus" + apiPath + separator + "project=" + url.QueryEscape(IncusProject)
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return r.client.Do(req)
+}
+
+// decodeResponse decodes a JSON envelope, returning an APIError for error responses
+func decodeResponse(resp *http.Response, target interface{}) error {
+	var envelope apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		if resp.StatusCode >= 400 {
+			return &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if envelope.Type == "error" || resp.StatusCode >= 400 {
+		return &APIError{StatusCode: resp.StatusCode, Message: envelope.Error}
+	}
+	if target == nil || len(envelope.Metadata) == 0 {
+		return nil
+	}
+	return json.Unmarshal(envelope.Metadata, target)
+}
+
+// instancePath returns the API path of an instance
+func instancePath(name string) string {
+	return "/1.0/instances/" + url.PathEscape(name)
+}
+
+// filesPath returns the API path for a file inside an instance
+func filesPath(name, containerPath string) string {
+	return instancePath(name) + "/files?path=" + url.QueryEscape(containerPath)
+}
@@ -0,0 +1,375 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeIncusAPI serves a minimal subset of the Incus REST API on a unix socket
+type fakeIncusAPI struct {
+	mu       sync.Mutex
+	requests []string
+	handlers map[string]http.HandlerFunc // "METHOD /path" -> handler
+}
+
+func newFakeIncusAPI(t *testing.T) (*fakeIncusAPI, *RESTBackend) {
+	t.Helper()
+
+	api := &fakeIncusAPI{handlers: make(map[string]http.HandlerFunc)}
+	socketPath := filepath.Join(t.TempDir(), "unix.socket")
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen on socket: %v", err)
+	}
+
+	server := &http.Server{Handler: api}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return api, NewRESTBackend(socketPath, nil)
+}
+
+func (f *fakeIncusAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Method + " " + r.URL.Path
+
+	f.mu.Lock()
+	f.requests = append(f.requests, key+"?"+r.URL.RawQuery)
+	handler, ok := f.handlers[key]
+	f.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"type": "error", "error_code": 404, "error": "Not found",
+		})
+		return
+	}
+	handler(w, r)
+}
+
+func (f *fakeIncusAPI) handle(key string, handler http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[key] = handler
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func syncResponse(metadata interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"type": "sync", "status_code": 200, "metadata": metadata,
+		})
+	}
+}
+
+func asyncResponse(operation string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"type": "async", "status_code": 100, "operation": operation,
+		})
+	}
+}
+
+func TestRESTBackendPing(t *testing.T) {
+	api, backend := newFakeIncusAPI(t)
+
+	api.handle("GET /1.0", syncResponse(map[string]string{"auth": "untrusted"}))
+	if err := backend.Ping(); err == nil {
+		t.Error("Expected error for untrusted client, got nil")
+	}
+
+	api.handle("GET /1.0", syncResponse(map[string]string{"auth": "trusted"}))
+	if err := backend.Ping(); err != nil {
+		t.Errorf("Expected ping to succeed, got: %v", err)
+	}
+}
+
+func TestRESTBackendPingNoSocket(t *testing.T) {
+	backend := NewRESTBackend(filepath.Join(t.TempDir(), "missing.socket"), nil)
+	if err := backend.Ping(); err == nil {
+		t.Error("Expected error for missing socket, got nil")
+	}
+}
+
+func TestRESTBackendListInstances(t *testing.T) {
+	api, backend := newFakeIncusAPI(t)
+
+	api.handle("GET /1.0/instances", syncResponse([]map[string]interface{}{
+		{
+			"name":       "coi-abc12345-1",
+			"status":     "Running",
+			"created_at": "2025-01-02T03:04:05Z",
+			"config":     map[string]string{"image.description": "coi image"},
+			"state": map[string]interface{}{
+				"network": map[string]interface{}{
+					"eth0": map[string]interface{}{
+						"addresses": []map[string]string{
+							{"family": "inet6", "address": "fe80::216:3eff:fe00:5", "scope": "link"},
+							{"family": "inet6", "address": "fd42::5", "scope": "global"},
+							{"family": "inet", "address": "10.0.0.5", "scope": "global"},
+						},
+					},
+				},
+			},
+		},
+		{"name": "coi-abc12345-2", "status": "Stopped", "state": map[string]interface{}{"network": nil}},
+	}))
+
+	instances, err := backend.ListInstances()
+	if err != nil {
+		t.Fatalf("ListInstances() failed: %v", err)
+	}
+
+	if len(instances) != 2 {
+		t.Fatalf("Expected 2 instances, got %d", len(instances))
+	}
+
+	if !instances[0].Running() || instances[0].IPv4() != "10.0.0.5" {
+		t.Errorf("Expected running instance with IPv4 10.0.0.5, got %s / %s", instances[0].Status, instances[0].IPv4())
+	}
+
+	if instances[0].IPv6() != "fd42::5" {
+		t.Errorf("Expected global IPv6 fd42::5 (not link-local), got %s", instances[0].IPv6())
+	}
+
+	if instances[0].Config["image.description"] != "coi image" {
+		t.Errorf("Expected image description, got: %v", instances[0].Config)
+	}
+
+	if instances[1].Running() || instances[1].IPv4() != "" || instances[1].IPv6() != "" {
+		t.Error("Expected stopped instance without IP addresses")
+	}
+
+	// Every request must be scoped to the project
+	if !strings.Contains(api.requests[0], "project="+IncusProject) || !strings.Contains(api.requests[0], "recursion=2") {
+		t.Errorf("Expected recursion and project query, got: %s", api.requests[0])
+	}
+}
+
+func TestRESTBackendGetInstanceNotFound(t *testing.T) {
+	_, backend := newFakeIncusAPI(t)
+
+	_, err := backend.GetInstance("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestRESTBackendOperationFailure(t *testing.T) {
+	api, backend := newFakeIncusAPI(t)
+
+	api.handle("PUT /1.0/instances/c1/state", asyncResponse("/1.0/operations/op1"))
+	api.handle("GET /1.0/operations/op1/wait", syncResponse(map[string]interface{}{
+		"id": "op1", "status": "Failure", "status_code": 400, "err": "Instance is already running",
+	}))
+
+	err := backend.StartInstance("c1")
+	if err == nil {
+		t.Fatal("Expected error from failed operation, got nil")
+	}
+	if !strings.Contains(err.Error(), "already running") {
+		t.Errorf("Expected operation error message, got: %v", err)
+	}
+}
+
+func TestRESTBackendExecCapture(t *testing.T) {
+	api, backend := newFakeIncusAPI(t)
+
+	var execRequest map[string]interface{}
+	api.handle("POST /1.0/instances/c1/exec", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&execRequest)
+		asyncResponse("/1.0/operations/exec1")(w, r)
+	})
+	api.handle("GET /1.0/operations/exec1/wait", syncResponse(map[string]interface{}{
+		"id": "exec1", "status": "Success", "status_code": 200,
+		"metadata": map[string]interface{}{
+			"return": 3,
+			"output": map[string]string{
+				"1": "/1.0/instances/c1/logs/exec-output/exec_1.stdout",
+				"2": "/1.0/instances/c1/logs/exec-output/exec_1.stderr",
+			},
+		},
+	}))
+	api.handle("GET /1.0/instances/c1/logs/exec-output/exec_1.stdout", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("  hello\n"))
+	})
+	api.handle("DELETE /1.0/instances/c1/logs/exec-output/exec_1.stdout", syncResponse(nil))
+	api.handle("DELETE /1.0/instances/c1/logs/exec-output/exec_1.stderr", syncResponse(nil))
+
+	user := 1000
+	output, err := backend.Exec("c1", []string{"echo", "hello"}, ExecCommandOptions{
+		Capture: true,
+		User:    &user,
+		Cwd:     "/workspace",
+	})
+
+	// Output is untrimmed and the exit code is surfaced
+	if output != "  hello\n" {
+		t.Errorf("Expected raw output, got %q", output)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 3 {
+		t.Errorf("Expected ExitError with code 3, got: %v", err)
+	}
+
+	if execRequest["record-output"] != true || execRequest["cwd"] != "/workspace" {
+		t.Errorf("Unexpected exec request: %v", execRequest)
+	}
+	if execRequest["user"] != float64(1000) || execRequest["group"] != float64(1000) {
+		t.Errorf("Expected user and group 1000, got: %v / %v", execRequest["user"], execRequest["group"])
+	}
+
+	// Both log files are cleaned up
+	deletes := 0
+	for _, req := range api.requests {
+		if strings.HasPrefix(req, "DELETE /1.0/instances/c1/logs/") {
+			deletes++
+		}
+	}
+	if deletes != 2 {
+		t.Errorf("Expected 2 log deletions, got %d", deletes)
+	}
+}
+
+func TestRESTBackendDeleteImageByAlias(t *testing.T) {
+	api, backend := newFakeIncusAPI(t)
+
+	api.handle("GET /1.0/images/aliases/coi", syncResponse(map[string]string{"name": "coi", "target": "abc123"}))
+	api.handle("DELETE /1.0/images/abc123", asyncResponse("/1.0/operations/del1"))
+	api.handle("GET /1.0/operations/del1/wait", syncResponse(map[string]interface{}{
+		"id": "del1", "status": "Success", "status_code": 200,
+	}))
+
+	if err := backend.DeleteImage("coi"); err != nil {
+		t.Fatalf("DeleteImage() failed: %v", err)
+	}
+
+	// Fingerprints that are not aliases are deleted directly
+	api.handle("DELETE /1.0/images/def456", syncResponse(nil))
+	if err := backend.DeleteImage("def456"); err != nil {
+		t.Errorf("DeleteImage() by fingerprint failed: %v", err)
+	}
+}
+
+func TestRESTBackendPublishInstance(t *testing.T) {
+	api, backend := newFakeIncusAPI(t)
+
+	var publishRequest map[string]interface{}
+	api.handle("POST /1.0/images", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&publishRequest)
+		asyncResponse("/1.0/operations/pub1")(w, r)
+	})
+	api.handle("GET /1.0/operations/pub1/wait", syncResponse(map[string]interface{}{
+		"id": "pub1", "status": "Success", "status_code": 200,
+		"metadata": map[string]string{"fingerprint": "feedface"},
+	}))
+
+	fingerprint, err := backend.PublishInstance("coi-build", "coi-20250101-000000", map[string]string{"description": "test"})
+	if err != nil {
+		t.Fatalf("PublishInstance() failed: %v", err)
+	}
+	if fingerprint != "feedface" {
+		t.Errorf("Expected fingerprint 'feedface', got '%s'", fingerprint)
+	}
+
+	source, _ := publishRequest["source"].(map[string]interface{})
+	if source["type"] != "instance" || source["name"] != "coi-build" {
+		t.Errorf("Unexpected publish source: %v", publishRequest["source"])
+	}
+}
+
+func TestRESTBackendSetDeviceConfig(t *testing.T) {
+	api, backend := newFakeIncusAPI(t)
+
+	api.handle("GET /1.0/instances/coi-abc12345-1", syncResponse(map[string]interface{}{
+		"name":    "coi-abc12345-1",
+		"devices": map[string]interface{}{"root": map[string]string{"type": "disk", "path": "/", "pool": "default"}},
+	}))
+	api.handle("GET /1.0/instances/coi-abc12345-1/state", syncResponse(map[string]interface{}{"status": "Running"}))
+
+	var patched map[string]map[string]map[string]string
+	api.handle("PATCH /1.0/instances/coi-abc12345-1", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&patched)
+		syncResponse(nil)(w, r)
+	})
+
+	if err := backend.SetDeviceConfig("coi-abc12345-1", "root", map[string]string{"size": "20GiB"}); err != nil {
+		t.Fatalf("SetDeviceConfig() failed: %v", err)
+	}
+
+	// PATCH replaces the device, so the existing keys must be sent along
+	root := patched["devices"]["root"]
+	if root["size"] != "20GiB" || root["pool"] != "default" || root["path"] != "/" {
+		t.Errorf("Expected merged root device, got: %v", root)
+	}
+
+	if err := backend.SetDeviceConfig("coi-abc12345-1", "data", map[string]string{"size": "1GiB"}); err == nil {
+		t.Error("Expected error for a device that does not exist")
+	}
+}
+
+func TestInstanceRootDisk(t *testing.T) {
+	instance := Instance{
+		Config:          map[string]string{"limits.cpu": "2"},
+		ExpandedConfig:  map[string]string{"limits.cpu": "2", "limits.memory": "4GiB"},
+		ExpandedDevices: map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "default"}},
+	}
+
+	name, root := instance.RootDisk()
+	if name != "root" || root["pool"] != "default" {
+		t.Errorf("RootDisk() = %s %v, want inherited root", name, root)
+	}
+	if got := instance.ConfigValue("limits.memory"); got != "4GiB" {
+		t.Errorf("ConfigValue() = %q, want %q", got, "4GiB")
+	}
+
+	instance.ExpandedDevices = nil
+	if name, _ := instance.RootDisk(); name != "" {
+		t.Errorf("RootDisk() = %s, want none", name)
+	}
+}
+
+func TestRESTBackendSnapshots(t *testing.T) {
+	api, backend := newFakeIncusAPI(t)
+
+	api.handle("GET /1.0/instances/coi-abc12345-1/snapshots", syncResponse([]map[string]interface{}{
+		{"name": "auto-20260102-150405", "created_at": "2026-01-02T15:04:05Z", "size": 2048},
+		{"name": "before-upgrade", "created_at": "2026-01-01T10:00:00Z", "size": -1},
+	}))
+
+	snapshots, err := backend.ListSnapshots("coi-abc12345-1")
+	if err != nil {
+		t.Fatalf("ListSnapshots() failed: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "before-upgrade" || snapshots[1].Size != 2048 {
+		t.Errorf("Expected snapshots sorted oldest first, got %+v", snapshots)
+	}
+
+	var restore map[string]string
+	api.handle("PUT /1.0/instances/coi-abc12345-1", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&restore)
+		syncResponse(nil)(w, r)
+	})
+	if err := backend.RestoreSnapshot("coi-abc12345-1", "before-upgrade"); err != nil {
+		t.Fatalf("RestoreSnapshot() failed: %v", err)
+	}
+	if len(restore) != 1 || restore["restore"] != "before-upgrade" {
+		t.Errorf("Expected restore-only PUT body, got %v", restore)
+	}
+
+	api.handle("DELETE /1.0/instances/coi-abc12345-1/snapshots/before-upgrade", syncResponse(nil))
+	if err := backend.DeleteSnapshot("coi-abc12345-1", "before-upgrade"); err != nil {
+		t.Errorf("DeleteSnapshot() failed: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package gitcred
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Verdicts recorded in the credential log
+const (
+	VerdictAllowed = "allowed"
+	VerdictDenied  = "denied"
+)
+
+// LogEntry is a single credential log record (one JSON object per line)
+type LogEntry struct {
+	Time      time.Time `json:"time"`
+	Container string    `json:"container"`
+	Remote    string    `json:"remote"`    // host/path of the repository
+	Operation string    `json:"operation"` // "fetch" or "push"
+	Verdict   string    `json:"verdict"`
+	Reason    string    `json:"reason,omitempty"` // Why a request was denied, or "approved" for approved pushes
+}
+
+// Log appends entries to the credential log file ([git_credentials] log)
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewLog returns a log writing to path, or nil (discarding entries) if path is empty
+func NewLog(path string) *Log {
+	if path == "" {
+		return nil
+	}
+	return &Log{path: path}
+}
+
+// Record appends an entry to the log; a nil log discards entries
+func (l *Log) Record(entry LogEntry) error {
+	if l == nil {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open credential log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write credential log: %w", err)
+	}
+	return nil
+}
+
+// ReadLog calls fn for each entry of the log file (of container, if set); invalid lines are skipped
+func ReadLog(path, container string, fn func(LogEntry)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry LogEntry
+			if json.Unmarshal(bytes.TrimSpace(line), &entry) == nil && (container == "" || entry.Container == container) {
+				fn(entry)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read credential log: %w", err)
+		}
+	}
+}
+
+// FormatLogEntry formats an entry as a single line for display, e.g.
+// "2026-01-02 15:04:05 coi-abc-1 allowed push   github.com/org/repo (approved)"
+func FormatLogEntry(entry LogEntry) string {
+	line := fmt.Sprintf("%s %s %-7s %-7s %s",
+		entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Container, entry.Verdict, entry.Operation, entry.Remote)
+	if entry.Reason != "" {
+		line += " (" + entry.Reason + ")"
+	}
+	return line
+}
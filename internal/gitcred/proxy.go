@@ -0,0 +1,368 @@
+package gitcred
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// ApprovalTimeout is how long a push waits for 'coi git approve' before it is denied
+var ApprovalTimeout = 2 * time.Minute
+
+// PendingRequest is a push waiting for approval
+type PendingRequest struct {
+	ID        int       `json:"id"`
+	Remote    string    `json:"remote"`
+	Operation string    `json:"operation"`
+	Since     time.Time `json:"since"`
+}
+
+// pendingRequest is a pending request with the channel its decision is sent on
+type pendingRequest struct {
+	PendingRequest
+	decision chan bool
+}
+
+// Proxy forwards git's smart HTTP requests from a container to the allowed https remotes and adds
+// the host's credentials on the way, so the credentials never reach the container
+// The git socket is exposed in the container (Incus proxy device); the control socket stays
+// on the host and is used by 'coi git approve' to decide pending pushes
+type Proxy struct {
+	container   string
+	allow       []string
+	approvePush bool
+	log         *Log
+
+	// Fill looks up credentials on the host (defaults to `git credential fill`)
+	Fill func(req Request) (username, password string, err error)
+	// Transport sends requests to the remotes (nil uses http.DefaultTransport)
+	Transport http.RoundTripper
+
+	mu      sync.Mutex
+	pending map[int]*pendingRequest
+	nextID  int
+
+	servers []*http.Server
+	wg      sync.WaitGroup
+}
+
+// NewProxy creates a credential proxy for a container
+func NewProxy(container string, cfg config.GitCredentialsConfig) *Proxy {
+	return &Proxy{
+		container:   container,
+		allow:       cfg.Allow,
+		approvePush: cfg.ApprovePush,
+		log:         NewLog(cfg.Log),
+		Fill:        hostFill,
+		pending:     make(map[int]*pendingRequest),
+	}
+}
+
+// Start listens on the git and control sockets (replacing stale socket files)
+func (p *Proxy) Start(socketPath, controlPath string) error {
+	// Not a ServeMux: it would redirect unclean paths instead of refusing them
+	credentials := http.HandlerFunc(p.serveGit)
+	control := http.NewServeMux()
+	control.HandleFunc("/pending", p.servePending)
+	control.HandleFunc("/decide", p.serveDecide)
+
+	for _, listener := range []struct {
+		path    string
+		handler http.Handler
+	}{{socketPath, credentials}, {controlPath, control}} {
+		if err := os.MkdirAll(filepath.Dir(listener.path), 0o700); err != nil {
+			p.Stop()
+			return fmt.Errorf("failed to create socket directory: %w", err)
+		}
+		_ = os.Remove(listener.path)
+		ln, err := net.Listen("unix", listener.path)
+		if err != nil {
+			p.Stop()
+			return fmt.Errorf("failed to listen on %s: %w", listener.path, err)
+		}
+		if err := os.Chmod(listener.path, 0o600); err != nil {
+			ln.Close()
+			p.Stop()
+			return fmt.Errorf("failed to restrict %s: %w", listener.path, err)
+		}
+
+		server := &http.Server{Handler: listener.handler, ReadHeaderTimeout: 10 * time.Second}
+		p.servers = append(p.servers, server)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			_ = server.Serve(ln)
+		}()
+	}
+	return nil
+}
+
+// Stop closes the sockets and denies pending requests
+func (p *Proxy) Stop() {
+	p.mu.Lock()
+	for id, req := range p.pending {
+		req.decision <- false
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+
+	for _, server := range p.servers {
+		_ = server.Close()
+	}
+	p.wg.Wait()
+	p.servers = nil
+}
+
+// serveGit forwards a git request from the container to its remote
+// Refusals are plain text responses, which git shows as messages from the remote
+func (p *Proxy) serveGit(w http.ResponseWriter, r *http.Request) {
+	req, err := ParseRequest(r)
+	if err != nil {
+		p.refuse(w, LogEntry{Container: p.container, Remote: strings.TrimPrefix(r.URL.Path, "/")}, http.StatusNotFound, err)
+		return
+	}
+
+	entry := LogEntry{Container: p.container, Remote: req.Remote(), Operation: req.Operation}
+	username, password, record, err := p.answer(r, req, &entry)
+	if err != nil {
+		p.refuse(w, entry, http.StatusForbidden, err)
+		return
+	}
+	if record {
+		entry.Verdict = VerdictAllowed
+		_ = p.log.Record(entry)
+	}
+	p.forward(w, r, req, username, password)
+}
+
+// answer checks a request against the allowed remotes, waits for approval of pushes if needed and
+// looks up the credentials
+// record reports whether the request is logged: the ref advertisement of a fetch and the upload of a
+// push, so that each git command is logged once
+func (p *Proxy) answer(r *http.Request, req Request, entry *LogEntry) (username, password string, record bool, err error) {
+	if !Allowed(p.allow, req.Remote()) {
+		return "", "", false, fmt.Errorf("%s is not an allowed remote ([git_credentials] allow)", req.Remote())
+	}
+
+	upload := req.Endpoint == endpointReceivePack && !isProbe(r)
+	if upload && p.approvePush {
+		if err := p.waitForApproval(r.Context(), req); err != nil {
+			return "", "", false, err
+		}
+		entry.Reason = "approved"
+	}
+
+	username, password, err = p.Fill(req)
+	if err != nil {
+		return "", "", false, fmt.Errorf("no host credentials for %s: %v", req.Remote(), err)
+	}
+	return username, password, upload || (req.Endpoint == endpointRefs && req.Operation == OperationFetch), nil
+}
+
+// isProbe reports whether a receive-pack request is git's probe before a large push: a flush
+// packet only, which updates nothing (the request body is kept for forwarding)
+func isProbe(r *http.Request) bool {
+	if r.ContentLength != 4 {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return err == nil && string(body) == "0000"
+}
+
+// refuse logs a refused request and answers it with the reason
+func (p *Proxy) refuse(w http.ResponseWriter, entry LogEntry, status int, err error) {
+	entry.Verdict = VerdictDenied
+	entry.Reason = err.Error()
+	_ = p.log.Record(entry)
+	http.Error(w, "coi: "+err.Error(), status)
+}
+
+// forward sends a request to the remote with the host's credentials and streams the response back
+// Cookies are dropped both ways, and redirects within the remote's host keep going through the proxy
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, req Request, username, password string) {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = &url.URL{Scheme: "https", Host: req.Host, Path: "/" + req.Path + req.Endpoint, RawQuery: pr.In.URL.RawQuery}
+			pr.Out.Host = req.Host
+			pr.Out.Header.Del("Cookie")
+			pr.Out.SetBasicAuth(username, password)
+		},
+		Transport: p.Transport,
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del("Set-Cookie")
+			if location, err := resp.Location(); err == nil && location.Scheme == "https" && location.Host == req.Host {
+				resp.Header.Set("Location", "http://"+r.Host+"/"+req.Host+location.RequestURI())
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			http.Error(w, fmt.Sprintf("coi: cannot reach %s: %v", req.Host, err), http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// waitForApproval holds a request until it is approved, denied or times out
+func (p *Proxy) waitForApproval(ctx context.Context, req Request) error {
+	p.mu.Lock()
+	p.nextID++
+	pending := &pendingRequest{
+		PendingRequest: PendingRequest{ID: p.nextID, Remote: req.Remote(), Operation: req.Operation, Since: time.Now()},
+		decision:       make(chan bool, 1),
+	}
+	p.pending[pending.ID] = pending
+	p.mu.Unlock()
+
+	timer := time.NewTimer(ApprovalTimeout)
+	defer timer.Stop()
+
+	select {
+	case approved := <-pending.decision:
+		if !approved {
+			return errors.New("denied on the host")
+		}
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	delete(p.pending, pending.ID)
+	p.mu.Unlock()
+	return fmt.Errorf("not approved within %s (run 'coi git approve' on the host)", ApprovalTimeout)
+}
+
+// Pending returns the requests waiting for approval, oldest first
+func (p *Proxy) Pending() []PendingRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := make([]PendingRequest, 0, len(p.pending))
+	for _, req := range p.pending {
+		list = append(list, req.PendingRequest)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Decide approves or denies a pending request (id 0 = all) and returns how many were decided
+func (p *Proxy) Decide(id int, approve bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	decided := 0
+	for pendingID, req := range p.pending {
+		if id != 0 && pendingID != id {
+			continue
+		}
+		req.decision <- approve
+		delete(p.pending, pendingID)
+		decided++
+	}
+	return decided
+}
+
+// servePending lists pending requests as JSON (control socket)
+func (p *Proxy) servePending(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.Pending())
+}
+
+// serveDecide approves or denies pending requests (control socket), e.g. POST /decide?id=3&approve=true
+func (p *Proxy) serveDecide(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	approve := r.URL.Query().Get("approve") == "true"
+	fmt.Fprintf(w, "%d\n", p.Decide(id, approve))
+}
+
+// hostFill looks up credentials with the host's git credential helpers, never prompting
+func hostFill(req Request) (string, string, error) {
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	cmd.Stdin = strings.NewReader(req.encode())
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", err
+	}
+
+	var username, password string
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "username":
+			username = value
+		case "password":
+			password = value
+		}
+	}
+	if password == "" {
+		return "", "", errors.New("git credential fill returned no credentials")
+	}
+	return username, password, nil
+}
+
+// controlClient returns an HTTP client talking to a proxy's control socket
+func controlClient(controlPath string) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", controlPath)
+			},
+		},
+	}
+}
+
+// ListPending asks a running proxy for its pending requests
+func ListPending(controlPath string) ([]PendingRequest, error) {
+	resp, err := controlClient(controlPath).Get("http://coi/pending")
+	if err != nil {
+		return nil, fmt.Errorf("no credential proxy running (is the session active?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	var pending []PendingRequest
+	if err := json.NewDecoder(resp.Body).Decode(&pending); err != nil {
+		return nil, fmt.Errorf("invalid response from credential proxy: %w", err)
+	}
+	return pending, nil
+}
+
+// DecidePending asks a running proxy to approve or deny pending requests (id 0 = all)
+func DecidePending(controlPath string, id int, approve bool) (int, error) {
+	url := fmt.Sprintf("http://coi/decide?id=%d&approve=%t", id, approve)
+	resp, err := controlClient(controlPath).Post(url, "text/plain", nil)
+	if err != nil {
+		return 0, fmt.Errorf("no credential proxy running (is the session active?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	var decided int
+	if _, err := fmt.Fscan(resp.Body, &decided); err != nil {
+		return 0, fmt.Errorf("invalid response from credential proxy: %w", err)
+	}
+	return decided, nil
+}
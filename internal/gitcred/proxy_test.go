@@ -0,0 +1,289 @@
+package gitcred
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// upstreamRequest is a request that reached the test remote
+type upstreamRequest struct {
+	Method, Path, Query, Body string
+	Username, Password        string
+	Cookie                    string
+}
+
+// testRemote is an https git remote recording the requests it gets
+type testRemote struct {
+	server *httptest.Server
+	host   string
+
+	mu       sync.Mutex
+	requests []upstreamRequest
+}
+
+// Requests returns the requests the remote got so far
+func (r *testRemote) Requests() []upstreamRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]upstreamRequest(nil), r.requests...)
+}
+
+// startTestProxy starts a proxy for an https test remote, adding fixed credentials, and returns
+// it with the remote, its socket and its log
+func startTestProxy(t *testing.T, cfg config.GitCredentialsConfig, allow ...string) (*Proxy, *testRemote, string, string) {
+	t.Helper()
+	remote := &testRemote{}
+	remote.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		username, password, _ := r.BasicAuth()
+		remote.mu.Lock()
+		remote.requests = append(remote.requests, upstreamRequest{
+			Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Body: string(body),
+			Username: username, Password: password, Cookie: r.Header.Get("Cookie"),
+		})
+		remote.mu.Unlock()
+
+		if strings.Contains(r.URL.Path, "/moved/") {
+			http.Redirect(w, r, "https://"+r.Host+"/org/repo.git/info/refs?service=git-upload-pack", http.StatusMovedPermanently)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(remote.server.Close)
+	remote.host = strings.TrimPrefix(remote.server.URL, "https://")
+
+	dir := t.TempDir()
+	cfg.Log = filepath.Join(dir, "git-credentials.log")
+	for _, pattern := range allow {
+		cfg.Allow = append(cfg.Allow, strings.ReplaceAll(pattern, "{remote}", remote.host))
+	}
+	proxy := NewProxy("coi-test-1", cfg)
+	proxy.Transport = remote.server.Client().Transport
+	proxy.Fill = func(req Request) (string, string, error) {
+		return "octocat", "ghp_test", nil
+	}
+
+	socket, control := filepath.Join(dir, "credential.sock"), filepath.Join(dir, "control.sock")
+	if err := proxy.Start(socket, control); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(proxy.Stop)
+	return proxy, remote, socket, cfg.Log
+}
+
+// gitResponse is the proxy's answer to a request
+type gitResponse struct {
+	Status   int
+	Body     string
+	Location string
+}
+
+// gitRequest sends a request to the proxy socket the way git in the container does
+func gitRequest(t *testing.T, socket, method, target, body string) gitResponse {
+	t.Helper()
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	req, err := http.NewRequest(method, "http://127.0.0.1:7431"+target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("agent", "guess")
+	req.Header.Set("Cookie", "session=container")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Errorf("request failed: %v", err)
+		return gitResponse{}
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if cookie := resp.Header.Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Expected cookies to be dropped, got %q", cookie)
+	}
+	return gitResponse{Status: resp.StatusCode, Body: string(data), Location: resp.Header.Get("Location")}
+}
+
+// readEntries returns all entries of a credential log
+func readEntries(t *testing.T, path string) []LogEntry {
+	t.Helper()
+	var entries []LogEntry
+	if err := ReadLog(path, "", func(entry LogEntry) { entries = append(entries, entry) }); err != nil {
+		t.Fatalf("ReadLog() failed: %v", err)
+	}
+	return entries
+}
+
+func TestProxyForwardsAllowedRemotes(t *testing.T) {
+	_, remote, socket, logPath := startTestProxy(t, config.GitCredentialsConfig{}, "{remote}/org/*")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+		body   string
+	}{
+		{"fetch", "GET", "/{remote}/org/repo.git/info/refs?service=git-upload-pack", http.StatusOK, "ok"},
+		{"fetch pack", "POST", "/{remote}/org/repo.git/git-upload-pack", http.StatusOK, "ok"},
+		{"not allowed", "GET", "/{remote}/evil/repo.git/info/refs?service=git-upload-pack", http.StatusForbidden, "is not an allowed remote"},
+		{"unclean path", "GET", "/{remote}/org/repo/../../evil/repo.git/info/refs?service=git-upload-pack", http.StatusNotFound, "invalid path"},
+		{"dumb http", "GET", "/{remote}/org/repo.git/HEAD", http.StatusNotFound, "only git smart HTTP requests"},
+		{"other service", "GET", "/{remote}/org/repo.git/info/refs?service=other", http.StatusNotFound, "only git smart HTTP requests"},
+		{"not a repository", "GET", "/{remote}/info/refs?service=git-upload-pack", http.StatusNotFound, "only git smart HTTP requests"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := gitRequest(t, socket, tt.method, strings.ReplaceAll(tt.target, "{remote}", remote.host), "")
+			if got.Status != tt.status || !strings.Contains(got.Body, tt.body) {
+				t.Errorf("response = %d %q, want %d containing %q", got.Status, got.Body, tt.status, tt.body)
+			}
+		})
+	}
+
+	// Only allowed requests reach the remote, with the host's credentials instead of the container's
+	requests := remote.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests at the remote, got %+v", requests)
+	}
+	want := upstreamRequest{Method: "GET", Path: "/org/repo.git/info/refs", Query: "service=git-upload-pack", Username: "octocat", Password: "ghp_test"}
+	if requests[0] != want {
+		t.Errorf("remote got %+v, want %+v", requests[0], want)
+	}
+
+	// One entry per fetch, and one per refusal
+	entries := readEntries(t, logPath)
+	if len(entries) != 6 {
+		t.Fatalf("Expected 6 log entries, got %+v", entries)
+	}
+	if entries[0].Verdict != VerdictAllowed || entries[0].Operation != OperationFetch || entries[0].Remote != remote.host+"/org/repo" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Verdict != VerdictDenied || entries[1].Reason == "" {
+		t.Errorf("Expected a denied entry with a reason, got %+v", entries[1])
+	}
+}
+
+func TestProxyMissingHostCredentials(t *testing.T) {
+	proxy, remote, socket, _ := startTestProxy(t, config.GitCredentialsConfig{}, "{remote}")
+	proxy.Fill = func(Request) (string, string, error) { return "", "", io.EOF }
+
+	got := gitRequest(t, socket, "GET", "/"+remote.host+"/repo/info/refs?service=git-upload-pack", "")
+	if got.Status != http.StatusForbidden || !strings.Contains(got.Body, "no host credentials for "+remote.host+"/repo") {
+		t.Errorf("Unexpected response: %+v", got)
+	}
+}
+
+func TestProxyRewritesRedirects(t *testing.T) {
+	_, remote, socket, _ := startTestProxy(t, config.GitCredentialsConfig{}, "{remote}")
+
+	got := gitRequest(t, socket, "GET", "/"+remote.host+"/org/moved/repo.git/info/refs?service=git-upload-pack", "")
+	want := "http://127.0.0.1:7431/" + remote.host + "/org/repo.git/info/refs?service=git-upload-pack"
+	if got.Status != http.StatusMovedPermanently || got.Location != want {
+		t.Errorf("Location = %q (%d), want %q", got.Location, got.Status, want)
+	}
+}
+
+func TestProxyPushApproval(t *testing.T) {
+	proxy, remote, socket, logPath := startTestProxy(t, config.GitCredentialsConfig{ApprovePush: true}, "{remote}")
+	control := filepath.Join(filepath.Dir(socket), "control.sock")
+	repo := "/" + remote.host + "/org/repo.git"
+
+	// Fetches, the ref advertisement of a push and git's probe update nothing and never wait
+	for _, req := range []struct{ method, target, body string }{
+		{"GET", repo + "/info/refs?service=git-upload-pack", ""},
+		{"POST", repo + "/git-upload-pack", "0032want"},
+		{"GET", repo + "/info/refs?service=git-receive-pack", ""},
+		{"POST", repo + "/git-receive-pack", "0000"},
+	} {
+		if got := gitRequest(t, socket, req.method, req.target, req.body); got.Status != http.StatusOK {
+			t.Fatalf("%s %s = %+v, want it forwarded", req.method, req.target, got)
+		}
+	}
+
+	responses := make(chan gitResponse, 2)
+	push := func() {
+		responses <- gitRequest(t, socket, "POST", repo+"/git-receive-pack", "PACK data")
+	}
+
+	// Approved through the control socket
+	go push()
+	waitForPending(t, proxy, 1)
+	pending, err := ListPending(control)
+	if err != nil || len(pending) != 1 || pending[0].Operation != OperationPush || pending[0].Remote != remote.host+"/org/repo" {
+		t.Fatalf("ListPending() = %+v (%v)", pending, err)
+	}
+	if decided, err := DecidePending(control, pending[0].ID, true); err != nil || decided != 1 {
+		t.Fatalf("DecidePending() = %d (%v), want 1", decided, err)
+	}
+	if got := <-responses; got.Status != http.StatusOK {
+		t.Errorf("Expected the push forwarded after approval, got %+v", got)
+	}
+	requests := remote.Requests()
+	if last := requests[len(requests)-1]; last.Body != "PACK data" || last.Password != "ghp_test" {
+		t.Errorf("Unexpected push at the remote: %+v", last)
+	}
+
+	// Denied
+	go push()
+	waitForPending(t, proxy, 1)
+	if decided := proxy.Decide(0, false); decided != 1 {
+		t.Fatalf("Decide() = %d, want 1", decided)
+	}
+	if got := <-responses; got.Status != http.StatusForbidden || !strings.Contains(got.Body, "denied on the host") {
+		t.Errorf("Unexpected response after denial: %+v", got)
+	}
+	if n := len(remote.Requests()); n != len(requests) {
+		t.Errorf("Expected the denied push not to reach the remote, got %d requests", n)
+	}
+
+	entries := readEntries(t, logPath)
+	if len(entries) != 3 || entries[0].Operation != OperationFetch || entries[1].Reason != "approved" || entries[2].Verdict != VerdictDenied {
+		t.Errorf("Unexpected log entries: %+v", entries)
+	}
+}
+
+func TestProxyApprovalTimeout(t *testing.T) {
+	original := ApprovalTimeout
+	ApprovalTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { ApprovalTimeout = original })
+
+	proxy, remote, socket, _ := startTestProxy(t, config.GitCredentialsConfig{ApprovePush: true}, "{remote}")
+
+	got := gitRequest(t, socket, "POST", "/"+remote.host+"/org/repo/git-receive-pack", "PACK data")
+	if got.Status != http.StatusForbidden || !strings.Contains(got.Body, "not approved within") {
+		t.Errorf("Unexpected response: %+v", got)
+	}
+	if pending := proxy.Pending(); len(pending) != 0 {
+		t.Errorf("Expected no pending requests after the timeout, got %+v", pending)
+	}
+}
+
+// waitForPending waits until the proxy holds n requests
+func waitForPending(t *testing.T, proxy *Proxy, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(proxy.Pending()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d pending requests", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,122 @@
+package gitcred
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Operations of proxied git requests, derived from the smart HTTP endpoint on the host
+const (
+	OperationFetch = "fetch"
+	OperationPush  = "push"
+)
+
+// Smart HTTP endpoints of a repository; nothing else is forwarded
+const (
+	endpointRefs        = "/info/refs"
+	endpointUploadPack  = "/git-upload-pack"
+	endpointReceivePack = "/git-receive-pack"
+)
+
+// Request is a git smart HTTP request from the container for an https repository
+type Request struct {
+	Protocol  string
+	Host      string
+	Path      string // Repository path on the host, e.g. "org/repo.git"
+	Operation string
+	Endpoint  string // endpointRefs, endpointUploadPack or endpointReceivePack
+}
+
+// ParseRequest parses a request the container's git sent to the proxy for
+// https://<host>/<repository>/<endpoint>, i.e. /<host>/<repository>/<endpoint>
+// Dumb HTTP and other paths (LFS, web pages, APIs) are refused
+func ParseRequest(r *http.Request) (Request, error) {
+	p := r.URL.Path
+	if !strings.HasPrefix(p, "/") || path.Clean(p) != p {
+		return Request{}, fmt.Errorf("invalid path %q", p)
+	}
+	host, rest, _ := strings.Cut(p[1:], "/")
+	if host == "" || strings.ContainsAny(host, "@\\") {
+		return Request{}, fmt.Errorf("invalid host %q", host)
+	}
+
+	req := Request{Protocol: "https", Host: host}
+	for _, endpoint := range []string{endpointRefs, endpointUploadPack, endpointReceivePack} {
+		if repo, ok := strings.CutSuffix("/"+rest, endpoint); ok && repo != "" {
+			req.Path, req.Endpoint = repo[1:], endpoint
+			break
+		}
+	}
+
+	switch {
+	case req.Endpoint == endpointRefs && r.Method == http.MethodGet && r.URL.Query().Get("service") == "git-upload-pack":
+		req.Operation = OperationFetch
+	case req.Endpoint == endpointRefs && r.Method == http.MethodGet && r.URL.Query().Get("service") == "git-receive-pack":
+		req.Operation = OperationPush
+	case req.Endpoint == endpointUploadPack && r.Method == http.MethodPost:
+		req.Operation = OperationFetch
+	case req.Endpoint == endpointReceivePack && r.Method == http.MethodPost:
+		req.Operation = OperationPush
+	default:
+		return Request{}, fmt.Errorf("only git smart HTTP requests are forwarded, not %s %s", r.Method, p)
+	}
+	return req, nil
+}
+
+// Remote returns the requested repository as host/path without a .git suffix, e.g. github.com/org/repo
+func (r Request) Remote() string {
+	p := strings.Trim(strings.TrimSuffix(strings.Trim(r.Path, "/"), ".git"), "/")
+	if p == "" {
+		return r.Host
+	}
+	return r.Host + "/" + p
+}
+
+// encode formats the request for `git credential fill`
+func (r Request) encode() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "protocol=%s\nhost=%s\n", r.Protocol, r.Host)
+	if r.Path != "" {
+		fmt.Fprintf(&b, "path=%s\n", r.Path)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Allowed reports whether a remote (host/path) matches one of the patterns
+// A pattern without a slash matches every repository of a host ("github.com");
+// otherwise it matches the whole remote, with * standing for one path segment ("github.com/org/*")
+func Allowed(patterns []string, remote string) bool {
+	host, _, _ := strings.Cut(remote, "/")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.Trim(pattern, "/"), ".git")
+		subject := remote
+		if !strings.Contains(pattern, "/") {
+			subject = host
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Hosts returns the hosts of the patterns, for which git in the container is pointed at the proxy
+// Host wildcards are refused, since git's url.<base>.insteadOf only rewrites fixed prefixes
+func Hosts(patterns []string) ([]string, error) {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		host, _, _ := strings.Cut(strings.Trim(pattern, "/"), "/")
+		if host == "" || strings.ContainsAny(host, "*?[") {
+			return nil, fmt.Errorf("invalid remote %q: the host must be given in full, e.g. github.com/org/*", pattern)
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
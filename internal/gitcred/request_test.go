@@ -0,0 +1,99 @@
+package gitcred
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		want   Request
+		ok     bool
+	}{
+		{"GET", "/github.com/org/repo.git/info/refs?service=git-upload-pack",
+			Request{Protocol: "https", Host: "github.com", Path: "org/repo.git", Operation: OperationFetch, Endpoint: endpointRefs}, true},
+		{"POST", "/github.com/org/repo.git/git-upload-pack",
+			Request{Protocol: "https", Host: "github.com", Path: "org/repo.git", Operation: OperationFetch, Endpoint: endpointUploadPack}, true},
+		{"GET", "/gitlab.example.com:8443/group/sub/repo/info/refs?service=git-receive-pack",
+			Request{Protocol: "https", Host: "gitlab.example.com:8443", Path: "group/sub/repo", Operation: OperationPush, Endpoint: endpointRefs}, true},
+		{"POST", "/github.com/org/repo/git-receive-pack",
+			Request{Protocol: "https", Host: "github.com", Path: "org/repo", Operation: OperationPush, Endpoint: endpointReceivePack}, true},
+		{"GET", "/github.com/org/repo/git-receive-pack", Request{}, false},
+		{"POST", "/github.com/org/repo/info/refs?service=git-upload-pack", Request{}, false},
+		{"GET", "/github.com/org/repo.git/info/refs", Request{}, false},
+		{"GET", "/github.com/org/repo.git/objects/info/packs", Request{}, false},
+		{"GET", "/github.com/info/refs?service=git-upload-pack", Request{}, false},
+		{"GET", "/github.com/org/../evil/repo/info/refs?service=git-upload-pack", Request{}, false},
+		{"GET", "/user@github.com/org/repo/info/refs?service=git-upload-pack", Request{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			got, err := ParseRequest(httptest.NewRequest(tt.method, tt.target, nil))
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("ParseRequest() = %+v, %v; want %+v, ok %v", got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRequestRemote(t *testing.T) {
+	tests := []struct {
+		req  Request
+		want string
+	}{
+		{Request{Host: "github.com", Path: "org/repo.git"}, "github.com/org/repo"},
+		{Request{Host: "github.com", Path: "/org/repo/"}, "github.com/org/repo"},
+		{Request{Host: "gitlab.example.com:8443", Path: "group/sub/repo"}, "gitlab.example.com:8443/group/sub/repo"},
+		{Request{Host: "github.com"}, "github.com"},
+	}
+	for _, tt := range tests {
+		if got := tt.req.Remote(); got != tt.want {
+			t.Errorf("Remote(%+v) = %q, want %q", tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		remote   string
+		want     bool
+	}{
+		{"exact repository", []string{"github.com/org/repo"}, "github.com/org/repo", true},
+		{"pattern with .git", []string{"github.com/org/repo.git"}, "github.com/org/repo", true},
+		{"other repository", []string{"github.com/org/repo"}, "github.com/org/other", false},
+		{"organization wildcard", []string{"github.com/org/*"}, "github.com/org/other", true},
+		{"wildcard is one segment", []string{"github.com/*"}, "github.com/org/repo", false},
+		{"other organization", []string{"github.com/org/*"}, "github.com/evil/repo", false},
+		{"whole host", []string{"github.com"}, "github.com/any/repo", true},
+		{"host wildcard", []string{"*.example.com"}, "git.example.com/team/repo", true},
+		{"host is not a prefix", []string{"github.com"}, "github.com.evil.io/org/repo", false},
+		{"no patterns", nil, "github.com/org/repo", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Allowed(tt.patterns, tt.remote); got != tt.want {
+				t.Errorf("Allowed(%v, %q) = %v, want %v", tt.patterns, tt.remote, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHosts(t *testing.T) {
+	hosts, err := Hosts([]string{"github.com/org/*", "github.com/other/repo", "gitlab.example.com:8443"})
+	if err != nil {
+		t.Fatalf("Hosts() failed: %v", err)
+	}
+	if want := []string{"github.com", "gitlab.example.com:8443"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("Hosts() = %v, want %v", hosts, want)
+	}
+	for _, patterns := range [][]string{{"*.example.com"}, {"git?.example.com/org/repo"}, {"/"}} {
+		if _, err := Hosts(patterns); err == nil {
+			t.Errorf("Hosts(%v) should fail", patterns)
+		}
+	}
+}
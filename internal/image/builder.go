@@ -1,7 +1,6 @@
 package image
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -404,21 +403,13 @@ func (b *Builder) createImage(versionAlias string) (string, error) {
 	b.opts.Logger(fmt.Sprintf("Creating image '%s'...", versionAlias))
 
 	// Publish container as image
-	_, err := container.IncusOutput(
-		"publish", BuildContainer,
-		"--alias", versionAlias,
-		fmt.Sprintf("description=%s", b.opts.Description),
-	)
+	fingerprint, err := container.GetBackend().PublishInstance(BuildContainer, versionAlias, map[string]string{
+		"description": b.opts.Description,
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create image: %w", err)
 	}
 
-	// Get fingerprint
-	fingerprint, err := getImageFingerprint(versionAlias)
-	if err != nil {
-		return "", err
-	}
-
 	return fingerprint, nil
 }
 
@@ -443,11 +434,11 @@ func (b *Builder) updateAlias(versionAlias, mainAlias string) error {
 
 	// Delete old alias if it exists
 	if exists, _ := container.ImageExists(mainAlias); exists {
-		_ = container.IncusExec("image", "alias", "delete", mainAlias) // Best effort
+		_ = container.GetBackend().DeleteImageAlias(mainAlias) // Best effort
 	}
 
 	// Create new alias
-	if err := container.IncusExec("image", "alias", "create", mainAlias, fingerprint); err != nil {
+	if err := container.GetBackend().CreateImageAlias(mainAlias, fingerprint); err != nil {
 		return fmt.Errorf("failed to create alias: %w", err)
 	}
 
@@ -456,27 +447,14 @@ func (b *Builder) updateAlias(versionAlias, mainAlias string) error {
 
 // getImageFingerprint gets the fingerprint of an image by alias
 func getImageFingerprint(alias string) (string, error) {
-	output, err := container.IncusOutput("image", "list", alias, "--project", "default", "--format=json")
+	images, err := container.GetBackend().ListImages()
 	if err != nil {
 		return "", err
 	}
 
-	var images []map[string]interface{}
-	if err := json.Unmarshal([]byte(output), &images); err != nil {
-		return "", err
-	}
-
 	for _, img := range images {
-		if aliases, ok := img["aliases"].([]interface{}); ok {
-			for _, a := range aliases {
-				if aliasMap, ok := a.(map[string]interface{}); ok {
-					if name, ok := aliasMap["name"].(string); ok && name == alias {
-						if fingerprint, ok := img["fingerprint"].(string); ok {
-							return fingerprint, nil
-						}
-					}
-				}
-			}
+		if img.HasAlias(alias) {
+			return img.Fingerprint, nil
 		}
 	}
 
@@ -1,7 +1,6 @@
 package image
 
 import (
-	"encoding/json"
 	"fmt"
 	"regexp"
 	"sort"
@@ -23,22 +22,11 @@ type ImageInfo struct {
 // Assumes aliases follow format: prefix-YYYYMMDD-HHMMSS
 func ListVersions(prefix string) ([]ImageInfo, error) {
 	// Get all images
-	output, err := container.IncusOutput("image", "list", "--format=json")
+	rawImages, err := container.GetBackend().ListImages()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list images: %w", err)
 	}
 
-	var rawImages []struct {
-		Fingerprint string                  `json:"fingerprint"`
-		Aliases     []struct{ Name string } `json:"aliases"`
-		Size        int64                   `json:"size"`
-		CreatedAt   time.Time               `json:"created_at"`
-	}
-
-	if err := json.Unmarshal([]byte(output), &rawImages); err != nil {
-		return nil, fmt.Errorf("failed to parse images: %w", err)
-	}
-
 	// Filter and convert to ImageInfo
 	var images []ImageInfo
 	for _, img := range rawImages {
@@ -173,25 +161,11 @@ func ValidateVersionedAlias(alias string) error {
 // ListAllImages returns all images with optional prefix filter
 func ListAllImages(prefix string) ([]ImageInfo, error) {
 	// Get all images
-	output, err := container.IncusOutput("image", "list", "--format=json")
+	rawImages, err := container.GetBackend().ListImages()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list images: %w", err)
 	}
 
-	var rawImages []struct {
-		Fingerprint string `json:"fingerprint"`
-		Aliases     []struct {
-			Name        string `json:"name"`
-			Description string `json:"description"`
-		} `json:"aliases"`
-		Size      int64     `json:"size"`
-		CreatedAt time.Time `json:"created_at"`
-	}
-
-	if err := json.Unmarshal([]byte(output), &rawImages); err != nil {
-		return nil, fmt.Errorf("failed to parse images: %w", err)
-	}
-
 	var images []ImageInfo
 	for _, img := range rawImages {
 		// Extract alias names
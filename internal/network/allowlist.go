@@ -0,0 +1,228 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AllowEntry is a parsed allowlist entry: a domain (or *.domain pattern), IP or CIDR,
+// optionally limited to a single port
+//
+// Accepted forms:
+//
+//	example.com, 8.8.8.8, 10.0.0.0/8, 2001:db8::1   all ports and protocols
+//	example.com:443                                 TCP port 443
+//	example.com:443/tcp, 10.0.5.12:5432/tcp         port/protocol
+//	10.0.5.0/24:tcp/22, [2001:db8::1]:udp/53        protocol/port (needed after a CIDR)
+type AllowEntry struct {
+	Host     string // Domain, wildcard pattern, IP or CIDR
+	Protocol string // "tcp" or "udp" ("" when Port is 0)
+	Port     int    // 0 allows all ports and protocols
+}
+
+// ParseAllowEntry parses an allowlist entry
+func ParseAllowEntry(entry string) (AllowEntry, error) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return AllowEntry{}, fmt.Errorf("empty allowlist entry")
+	}
+
+	// Bare addresses and networks (IPv6 contains colons, so check before splitting off a port)
+	if isAddress(entry) {
+		return AllowEntry{Host: entry}, nil
+	}
+	if bracketed := strings.TrimSuffix(strings.TrimPrefix(entry, "["), "]"); bracketed != entry && isAddress(bracketed) {
+		return AllowEntry{Host: bracketed}, nil
+	}
+
+	idx := strings.LastIndex(entry, ":")
+	if idx == -1 {
+		if strings.Contains(entry, "/") {
+			return AllowEntry{}, fmt.Errorf("invalid allowlist entry %q: not a valid CIDR", entry)
+		}
+		return AllowEntry{Host: entry}, nil
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(entry[:idx], "["), "]")
+	if host == "" {
+		return AllowEntry{}, fmt.Errorf("invalid allowlist entry %q: missing host", entry)
+	}
+	if strings.Contains(host, "/") && !isAddress(host) {
+		return AllowEntry{}, fmt.Errorf("invalid allowlist entry %q: not a valid CIDR", entry)
+	}
+
+	protocol, port, err := parsePortSpec(entry[idx+1:])
+	if err != nil {
+		return AllowEntry{}, fmt.Errorf("invalid allowlist entry %q: %w", entry, err)
+	}
+
+	return AllowEntry{Host: host, Protocol: protocol, Port: port}, nil
+}
+
+// ParseAllowEntries parses a list of allowlist entries
+func ParseAllowEntries(entries []string) ([]AllowEntry, error) {
+	parsed := make([]AllowEntry, 0, len(entries))
+	for _, entry := range entries {
+		e, err := ParseAllowEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, e)
+	}
+	return parsed, nil
+}
+
+// parsePortSpec parses "443", "443/tcp" or "tcp/443" (protocol defaults to tcp)
+func parsePortSpec(spec string) (string, int, error) {
+	protocol := "tcp"
+	portStr := spec
+
+	if first, second, ok := strings.Cut(spec, "/"); ok {
+		if _, err := strconv.Atoi(first); err == nil {
+			portStr, protocol = first, second
+		} else {
+			protocol, portStr = first, second
+		}
+	}
+
+	protocol = strings.ToLower(protocol)
+	if protocol != "tcp" && protocol != "udp" {
+		return "", 0, fmt.Errorf("unsupported protocol %q (use tcp or udp)", protocol)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+
+	return protocol, port, nil
+}
+
+// String formats the entry in allowlist syntax
+func (e AllowEntry) String() string {
+	if e.Port == 0 {
+		return e.Host
+	}
+	host := e.Host
+	if strings.Contains(host, ":") && !strings.Contains(host, "/") {
+		host = "[" + host + "]"
+	}
+	if strings.Contains(host, "/") {
+		return fmt.Sprintf("%s:%s/%d", host, e.Protocol, e.Port)
+	}
+	return fmt.Sprintf("%s:%d/%s", host, e.Port, e.Protocol)
+}
+
+// IsAddress returns true if the entry's host is an IP or CIDR (needs no resolution)
+func (e AllowEntry) IsAddress() bool {
+	return isAddress(e.Host)
+}
+
+// Allows returns true if the entry permits a connection to port over protocol
+func (e AllowEntry) Allows(protocol string, port int) bool {
+	return e.Port == 0 || (e.Port == port && e.Protocol == protocol)
+}
+
+// rule returns the firewall rule for an address entry at the given priority
+func (e AllowEntry) rule(priority int, action RuleAction) Rule {
+	return Rule{
+		Priority:    priority,
+		Destination: hostCIDR(e.Host),
+		Action:      action,
+		Protocol:    e.Protocol,
+		Port:        e.Port,
+	}
+}
+
+// isAddress returns true for IPs and CIDRs
+func isAddress(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(host)
+	return err == nil
+}
+
+// entryHosts returns the unique domain names of the entries that need resolving (no addresses or wildcards)
+func entryHosts(entries []AllowEntry) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, e := range entries {
+		if e.IsAddress() || IsWildcardDomain(e.Host) || seen[e.Host] {
+			continue
+		}
+		seen[e.Host] = true
+		hosts = append(hosts, e.Host)
+	}
+	return hosts
+}
+
+// entryPatterns returns the hosts of the entries (for name matching in the DNS and egress proxies)
+func entryPatterns(entries []AllowEntry) []string {
+	patterns := make([]string, 0, len(entries))
+	for _, e := range entries {
+		patterns = append(patterns, e.Host)
+	}
+	return patterns
+}
+
+// addressEntries returns the IP and CIDR entries, with IPs normalized
+func addressEntries(entries []AllowEntry) []AllowEntry {
+	var result []AllowEntry
+	for _, e := range entries {
+		if !e.IsAddress() {
+			continue
+		}
+		if ip := net.ParseIP(e.Host); ip != nil {
+			e.Host = normalizeIP(ip)
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// expandEntries replaces domain entries with one entry per resolved IP, keeping the port limits
+// Address entries are kept; wildcards and domains without IPs are dropped
+// The result is deduplicated and sorted for deterministic rules
+func expandEntries(entries []AllowEntry, domainIPs map[string][]string) []AllowEntry {
+	seen := make(map[AllowEntry]bool)
+	var result []AllowEntry
+
+	add := func(e AllowEntry) {
+		if !seen[e] {
+			seen[e] = true
+			result = append(result, e)
+		}
+	}
+
+	for _, e := range addressEntries(entries) {
+		add(e)
+	}
+	for _, e := range entries {
+		if e.IsAddress() {
+			continue
+		}
+		for _, ip := range domainIPs[e.Host] {
+			add(AllowEntry{Host: ip, Protocol: e.Protocol, Port: e.Port})
+		}
+	}
+
+	sortEntries(result)
+	return result
+}
+
+// sortEntries sorts entries by host, then protocol and port
+func sortEntries(entries []AllowEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Host != entries[j].Host {
+			return entries[i].Host < entries[j].Host
+		}
+		if entries[i].Protocol != entries[j].Protocol {
+			return entries[i].Protocol < entries[j].Protocol
+		}
+		return entries[i].Port < entries[j].Port
+	})
+}
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAllowEntry(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    AllowEntry
+		wantErr bool
+	}{
+		{entry: "api.anthropic.com", want: AllowEntry{Host: "api.anthropic.com"}},
+		{entry: " 8.8.8.8 ", want: AllowEntry{Host: "8.8.8.8"}},
+		{entry: "10.0.0.0/8", want: AllowEntry{Host: "10.0.0.0/8"}},
+		{entry: "2001:db8::1", want: AllowEntry{Host: "2001:db8::1"}},
+		{entry: "[2001:db8::1]", want: AllowEntry{Host: "2001:db8::1"}},
+		{entry: "*.example.com", want: AllowEntry{Host: "*.example.com"}},
+		{entry: "api.anthropic.com:443", want: AllowEntry{Host: "api.anthropic.com", Protocol: "tcp", Port: 443}},
+		{entry: "api.anthropic.com:443/tcp", want: AllowEntry{Host: "api.anthropic.com", Protocol: "tcp", Port: 443}},
+		{entry: "10.0.5.12:5432/tcp", want: AllowEntry{Host: "10.0.5.12", Protocol: "tcp", Port: 5432}},
+		{entry: "10.0.5.0/24:tcp/22", want: AllowEntry{Host: "10.0.5.0/24", Protocol: "tcp", Port: 22}},
+		{entry: "10.0.5.0/24:UDP/53", want: AllowEntry{Host: "10.0.5.0/24", Protocol: "udp", Port: 53}},
+		{entry: "[2001:db8::1]:udp/53", want: AllowEntry{Host: "2001:db8::1", Protocol: "udp", Port: 53}},
+		{entry: "2001:db8::/32:tcp/22", want: AllowEntry{Host: "2001:db8::/32", Protocol: "tcp", Port: 22}},
+		{entry: "", wantErr: true},
+		{entry: "example.com:0", wantErr: true},
+		{entry: "example.com:70000/tcp", wantErr: true},
+		{entry: "example.com:443/icmp", wantErr: true},
+		{entry: "example.com:https", wantErr: true},
+		{entry: "10.0.0.0/33:tcp/22", wantErr: true},
+		{entry: "example.com/path", wantErr: true},
+		{entry: ":443", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAllowEntry(tt.entry)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseAllowEntry(%q) = %v, want error", tt.entry, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAllowEntry(%q) failed: %v", tt.entry, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAllowEntry(%q) = %+v, want %+v", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestAllowEntryString(t *testing.T) {
+	for _, entry := range []string{"example.com", "example.com:443/tcp", "10.0.5.0/24:tcp/22", "[2001:db8::1]:53/udp"} {
+		parsed, err := ParseAllowEntry(entry)
+		if err != nil {
+			t.Fatalf("ParseAllowEntry(%q) failed: %v", entry, err)
+		}
+		if got := parsed.String(); got != entry {
+			t.Errorf("String() = %q, want %q", got, entry)
+		}
+	}
+}
+
+func TestAllowEntryAllows(t *testing.T) {
+	unlimited := AllowEntry{Host: "example.com"}
+	if !unlimited.Allows("udp", 53) || !unlimited.Allows("tcp", 443) {
+		t.Error("Expected an entry without port to allow everything")
+	}
+
+	https := AllowEntry{Host: "example.com", Protocol: "tcp", Port: 443}
+	if !https.Allows("tcp", 443) || https.Allows("tcp", 80) || https.Allows("udp", 443) {
+		t.Error("Expected a port-limited entry to allow only its port and protocol")
+	}
+}
+
+func TestEntryHelpers(t *testing.T) {
+	entries, err := ParseAllowEntries([]string{
+		"8.8.8.8", "*.githubusercontent.com", "api.anthropic.com", "api.anthropic.com:443/tcp",
+		"2001:4860:4860:0::8888", "10.0.5.0/24:tcp/22",
+	})
+	if err != nil {
+		t.Fatalf("ParseAllowEntries() failed: %v", err)
+	}
+
+	if got := entryHosts(entries); !reflect.DeepEqual(got, []string{"api.anthropic.com"}) {
+		t.Errorf("entryHosts() = %v, want [api.anthropic.com]", got)
+	}
+
+	wantAddresses := []AllowEntry{
+		{Host: "8.8.8.8"},
+		{Host: "2001:4860:4860::8888"},
+		{Host: "10.0.5.0/24", Protocol: "tcp", Port: 22},
+	}
+	if got := addressEntries(entries); !reflect.DeepEqual(got, wantAddresses) {
+		t.Errorf("addressEntries() = %v, want %v", got, wantAddresses)
+	}
+
+	if _, err := ParseAllowEntries([]string{"example.com", "example.com:99999"}); err == nil {
+		t.Error("Expected error for an invalid entry")
+	}
+}
+
+func TestExpandEntries(t *testing.T) {
+	entries := []AllowEntry{
+		{Host: "api.example.com", Protocol: "tcp", Port: 443},
+		{Host: "cdn.example.com"},
+		{Host: "*.example.org"},
+		{Host: "8.8.8.8"},
+		{Host: "missing.example.com"},
+	}
+	domainIPs := map[string][]string{
+		"api.example.com": {"1.1.1.1", "2606:4700::1111"},
+		"cdn.example.com": {"1.1.1.1"},
+	}
+
+	want := []AllowEntry{
+		{Host: "1.1.1.1"},
+		{Host: "1.1.1.1", Protocol: "tcp", Port: 443},
+		{Host: "2606:4700::1111", Protocol: "tcp", Port: 443},
+		{Host: "8.8.8.8"},
+	}
+	if got := expandEntries(entries, domainIPs); !reflect.DeepEqual(got, want) {
+		t.Errorf("expandEntries() = %v, want %v", got, want)
+	}
+}
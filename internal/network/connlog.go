@@ -0,0 +1,304 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Firewall log rules are prefixed with coi:<priority>:<A|R>: so the kernel log line identifies
+// the rule that decided the connection; the container is identified by the source address
+const (
+	logPrefixStart = "coi:"
+	logRateLimit   = "20" // Log lines per second and rule; connections above the limit are not logged
+	logRateBurst   = "40"
+
+	// reverseLookupTimeout bounds PTR lookups of logged destinations
+	reverseLookupTimeout = 2 * time.Second
+	// maxReverseNames bounds the cache of reverse-resolved destinations
+	maxReverseNames = 4096
+)
+
+// connectionRecord is a new connection logged by a firewall log rule
+type connectionRecord struct {
+	Priority    int
+	Action      RuleAction
+	Source      string
+	Destination string
+	Protocol    string // Lowercase ("tcp", "udp", "icmp", ...)
+	Port        int    // 0 for protocols without ports
+}
+
+// logPrefix returns the kernel log prefix of a rule's log rule (at most 29 characters for iptables)
+func logPrefix(rule Rule) string {
+	action := "A"
+	if rule.Action == RuleReject {
+		action = "R"
+	}
+	return fmt.Sprintf("%s%d:%s:", logPrefixStart, rule.Priority, action)
+}
+
+// loggedRule returns true if new connections matched by the rule are logged
+// Gateway traffic (the host, DNS via dnsmasq and the session's proxies) is not logged
+func loggedRule(rule Rule) bool {
+	return rule.Priority != priorityGateway || rule.Action != RuleAccept
+}
+
+// parseKernelLogLine parses a kernel log line written by a coi log rule, e.g.
+// "coi:99:R:IN=incusbr0 OUT=eth0 ... SRC=10.47.62.50 DST=1.2.3.4 ... PROTO=TCP SPT=40512 DPT=443 ..."
+func parseKernelLogLine(line string) (connectionRecord, bool) {
+	idx := strings.Index(line, logPrefixStart)
+	if idx == -1 {
+		return connectionRecord{}, false
+	}
+	parts := strings.SplitN(line[idx+len(logPrefixStart):], ":", 3)
+	if len(parts) != 3 {
+		return connectionRecord{}, false
+	}
+
+	priority, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return connectionRecord{}, false
+	}
+	record := connectionRecord{Priority: priority}
+	switch parts[1] {
+	case "A":
+		record.Action = RuleAccept
+	case "R":
+		record.Action = RuleReject
+	default:
+		return connectionRecord{}, false
+	}
+
+	for _, field := range strings.Fields(parts[2]) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "SRC":
+			record.Source = normalizeLogIP(value)
+		case "DST":
+			record.Destination = normalizeLogIP(value)
+		case "PROTO":
+			record.Protocol = strings.ToLower(value)
+		case "DPT":
+			record.Port, _ = strconv.Atoi(value)
+		}
+	}
+
+	if record.Source == "" || record.Destination == "" {
+		return connectionRecord{}, false
+	}
+	return record, true
+}
+
+// normalizeLogIP returns the canonical form of an address (the kernel logs IPv6 uncompressed)
+func normalizeLogIP(value string) string {
+	if ip := net.ParseIP(value); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// ruleName describes the rule that decided a connection:
+// "allow", "default-allow", "default-deny" or "block <network>"
+func ruleName(record connectionRecord) string {
+	switch {
+	case record.Action == RuleAccept && record.Priority == priorityAllow:
+		return "allow"
+	case record.Action == RuleAccept && record.Priority == priorityDefaultAllow:
+		return "default-allow"
+	case record.Action == RuleReject && record.Priority == priorityDefaultDeny:
+		return "default-deny"
+	case record.Action == RuleReject:
+		ip := net.ParseIP(record.Destination)
+		networks := append([]string{metadataIPv6}, privateNetworks...)
+		for _, cidr := range append(networks, linkLocalNetworks...) {
+			if _, n, err := net.ParseCIDR(cidr); err == nil && ip != nil && n.Contains(ip) {
+				return "block " + cidr
+			}
+		}
+	}
+	return fmt.Sprintf("%s priority %d", record.Action, record.Priority)
+}
+
+// enableConnectionLogging turns on firewall log rules when [network.logging] connections is set
+// Must be called before rules are applied
+func (m *Manager) enableConnectionLogging() {
+	if m.netLog == nil || !m.config.Logging.Connections {
+		return
+	}
+	if err := m.firewall.SetLogging(true); err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	m.connLogging = true
+}
+
+// kernelLogCommand returns a journalctl command reading the kernel log with the given arguments
+// The kernel log is only readable by root, so journalctl runs through passwordless sudo
+func kernelLogCommand(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sudo", append([]string{"-n", "journalctl", "-k"}, args...)...)
+}
+
+// startConnectionLog follows the kernel log and records the container's logged connections
+// in the network log
+// The log is followed by a journalctl child of this process, so connections are only recorded
+// while the session's 'coi shell' runs; failures are recorded in the network log so that it
+// does not just stay empty
+func (m *Manager) startConnectionLog(ctx context.Context) {
+	// sudo -n fails right away without passwordless sudo; check it before claiming to log
+	if output, err := kernelLogCommand(ctx, "-n", "0", "-q").CombinedOutput(); err != nil {
+		m.connectionLogFailed(commandError(err, output))
+		return
+	}
+
+	logCtx, cancel := context.WithCancel(ctx)
+
+	cmd := kernelLogCommand(logCtx, "-f", "-n", "0", "-o", "cat")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cancel()
+		m.connectionLogFailed(err)
+		return
+	}
+	m.connLogCancel = cancel
+	log.Println("  Logging new connections to the network log")
+
+	sources := map[string]bool{normalizeLogIP(m.addrs.IPv4): true}
+	if m.addrs.IPv6 != "" {
+		sources[normalizeLogIP(m.addrs.IPv6)] = true
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			record, ok := parseKernelLogLine(scanner.Text())
+			if ok && sources[record.Source] {
+				m.recordConnection(record)
+			}
+		}
+		err := cmd.Wait()
+		if logCtx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("journalctl exited")
+		}
+		m.connectionLogFailed(commandError(err, stderr.Bytes()))
+	}()
+}
+
+// commandError adds the output of a failed command to its error
+func commandError(err error, output []byte) error {
+	if msg := strings.TrimSpace(string(output)); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+// connectionLogFailed reports that the kernel log cannot be read, on stderr and in the network log
+func (m *Manager) connectionLogFailed(err error) {
+	log.Printf("Warning: connection logging unavailable, cannot read the kernel log (passwordless sudo for journalctl is required): %v", err)
+
+	err = m.netLog.Record(LogEntry{
+		Container: m.containerName,
+		Event:     "connection-log",
+		Verdict:   VerdictError,
+		Error:     "cannot read the kernel log: " + err.Error(),
+	})
+	if err != nil {
+		log.Printf("Warning: failed to write network log: %v", err)
+	}
+}
+
+// stopConnectionLog stops following the kernel log
+func (m *Manager) stopConnectionLog() {
+	if m.connLogCancel != nil {
+		m.connLogCancel()
+		m.connLogCancel = nil
+	}
+}
+
+// recordConnection writes a logged connection to the network log
+func (m *Manager) recordConnection(record connectionRecord) {
+	verdict := VerdictAllowed
+	if record.Action == RuleReject {
+		verdict = VerdictDenied
+	}
+
+	err := m.netLog.Record(LogEntry{
+		Container: m.containerName,
+		Event:     "connection",
+		Domain:    m.domainForIP(record.Destination),
+		IP:        record.Destination,
+		Protocol:  record.Protocol,
+		Port:      record.Port,
+		Rule:      ruleName(record),
+		Verdict:   verdict,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to write network log: %v", err)
+	}
+}
+
+// domainForIP returns a name for a logged destination: the allowed name whose DNS proxy answer
+// or resolution returned it, otherwise its PTR record ("" if there is none)
+// Only called from the connection log goroutine
+func (m *Manager) domainForIP(ip string) string {
+	m.dynamicMu.Lock()
+	name := m.dynamicNames[ip]
+	m.dynamicMu.Unlock()
+	if name != "" {
+		return name
+	}
+
+	m.applyMu.Lock()
+	if m.resolver != nil {
+		name = cachedDomainForIP(m.resolver.GetCache(), ip)
+	}
+	m.applyMu.Unlock()
+	if name != "" {
+		return name
+	}
+
+	if cached, ok := m.reverseNames[ip]; ok {
+		return cached
+	}
+	if m.reverseNames == nil || len(m.reverseNames) >= maxReverseNames {
+		m.reverseNames = make(map[string]string)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reverseLookupTimeout)
+	defer cancel()
+	if names, err := net.DefaultResolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	m.reverseNames[ip] = name
+	return name
+}
+
+// cachedDomainForIP returns the domain of the IP cache that resolved to ip ("" if none did)
+func cachedDomainForIP(cache *IPCache, ip string) string {
+	for domain, ips := range cache.Domains {
+		for _, cached := range ips {
+			if normalizeLogIP(cached) == ip {
+				return domain
+			}
+		}
+	}
+	return ""
+}
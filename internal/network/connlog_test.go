@@ -0,0 +1,178 @@
+package network
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+func TestParseKernelLogLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want connectionRecord
+		ok   bool
+	}{
+		{
+			name: "ipv4 tcp",
+			line: "coi:99:R:IN=incusbr0 OUT=eth0 MAC=00:16:3e:aa:bb:cc SRC=10.47.62.50 DST=93.184.216.34 LEN=60 TOS=0x00 PROTO=TCP SPT=40512 DPT=443 WINDOW=64240 SYN",
+			want: connectionRecord{Priority: 99, Action: RuleReject, Source: "10.47.62.50", Destination: "93.184.216.34", Protocol: "tcp", Port: 443},
+			ok:   true,
+		},
+		{
+			name: "ipv6 uncompressed with kernel timestamp",
+			line: "[12345.678901] coi:1:A:IN=incusbr0 OUT=eth0 SRC=fd42:0001:0000:0000:0000:0000:0000:0050 DST=2606:4700:0000:0000:0000:0000:0000:1111 PROTO=UDP SPT=5353 DPT=53",
+			want: connectionRecord{Priority: 1, Action: RuleAccept, Source: "fd42:1::50", Destination: "2606:4700::1111", Protocol: "udp", Port: 53},
+			ok:   true,
+		},
+		{
+			name: "icmp without ports",
+			line: "coi:50:A:IN=incusbr0 OUT=eth0 SRC=10.47.62.50 DST=1.1.1.1 PROTO=ICMP TYPE=8 CODE=0",
+			want: connectionRecord{Priority: 50, Action: RuleAccept, Source: "10.47.62.50", Destination: "1.1.1.1", Protocol: "icmp"},
+			ok:   true,
+		},
+		{name: "other kernel message", line: "IN=incusbr0 OUT=eth0 SRC=10.47.62.50 DST=1.1.1.1 PROTO=TCP"},
+		{name: "unknown action", line: "coi:99:X:SRC=10.47.62.50 DST=1.1.1.1"},
+		{name: "missing addresses", line: "coi:99:R:IN=incusbr0 OUT=eth0 PROTO=TCP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseKernelLogLine(tt.line)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("parseKernelLogLine() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLogPrefix(t *testing.T) {
+	if got := logPrefix(Rule{Priority: priorityDefaultDeny, Action: RuleReject}); got != "coi:99:R:" {
+		t.Errorf("logPrefix() = %q, want %q", got, "coi:99:R:")
+	}
+	if got := logPrefix(Rule{Priority: priorityAllow, Action: RuleAccept}); got != "coi:1:A:" {
+		t.Errorf("logPrefix() = %q, want %q", got, "coi:1:A:")
+	}
+}
+
+func TestLoggedRule(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want bool
+	}{
+		{Rule{Priority: priorityGateway, Action: RuleAccept}, false},
+		{Rule{Priority: priorityMetadataIPv6, Action: RuleReject}, true},
+		{Rule{Priority: priorityAllow, Action: RuleAccept}, true},
+		{Rule{Priority: priorityDefaultDeny, Action: RuleReject}, true},
+	}
+
+	for _, tt := range tests {
+		if got := loggedRule(tt.rule); got != tt.want {
+			t.Errorf("loggedRule(%+v) = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestRuleName(t *testing.T) {
+	tests := []struct {
+		record connectionRecord
+		want   string
+	}{
+		{connectionRecord{Priority: priorityAllow, Action: RuleAccept, Destination: "1.1.1.1"}, "allow"},
+		{connectionRecord{Priority: priorityDefaultAllow, Action: RuleAccept, Destination: "1.1.1.1"}, "default-allow"},
+		{connectionRecord{Priority: priorityDefaultDeny, Action: RuleReject, Destination: "1.1.1.1"}, "default-deny"},
+		{connectionRecord{Priority: priorityBlock, Action: RuleReject, Destination: "192.168.1.10"}, "block 192.168.0.0/16"},
+		{connectionRecord{Priority: priorityBlock, Action: RuleReject, Destination: "fd00::1"}, "block fc00::/7"},
+		{connectionRecord{Priority: 7, Action: RuleAccept, Destination: "1.1.1.1"}, "ACCEPT priority 7"},
+	}
+
+	for _, tt := range tests {
+		if got := ruleName(tt.record); got != tt.want {
+			t.Errorf("ruleName(%+v) = %q, want %q", tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestCachedDomainForIP(t *testing.T) {
+	cache := &IPCache{Domains: map[string][]string{
+		"registry.npmjs.org": {"104.16.0.35"},
+		"ipv6.example.com":   {"2606:4700:0:0:0:0:0:1111"},
+	}}
+
+	if got := cachedDomainForIP(cache, "104.16.0.35"); got != "registry.npmjs.org" {
+		t.Errorf("cachedDomainForIP() = %q, want %q", got, "registry.npmjs.org")
+	}
+	if got := cachedDomainForIP(cache, "2606:4700::1111"); got != "ipv6.example.com" {
+		t.Errorf("cachedDomainForIP() = %q, want %q", got, "ipv6.example.com")
+	}
+	if got := cachedDomainForIP(cache, "8.8.8.8"); got != "" {
+		t.Errorf("cachedDomainForIP() = %q, want empty", got)
+	}
+}
+
+// fakeSudo puts a sudo running script first in PATH
+func fakeSudo(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestStartConnectionLogRecordsFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			"no passwordless sudo",
+			"echo 'sudo: a password is required' >&2\nexit 1\n",
+			"sudo: a password is required",
+		},
+		{
+			"journalctl exits while following",
+			"case \"$*\" in *-f*) echo 'journal unavailable' >&2; exit 1;; esac\n",
+			"journal unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSudo(t, tt.script)
+			path := filepath.Join(t.TempDir(), "network.log")
+			m := &Manager{
+				containerName: "coi-abc-1",
+				addrs:         ContainerAddresses{IPv4: "10.47.62.50"},
+				netLog:        NewNetworkLog(config.NetworkLoggingConfig{Enabled: true, Path: path}),
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			m.startConnectionLog(ctx)
+			defer m.stopConnectionLog()
+
+			var entries []LogEntry
+			for deadline := time.Now().Add(5 * time.Second); len(entries) == 0 && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+				entries = nil
+				if _, err := ReadLog(path, LogFilter{}, func(entry LogEntry) { entries = append(entries, entry) }); err != nil && !os.IsNotExist(err) {
+					t.Fatal(err)
+				}
+			}
+			if len(entries) != 1 {
+				t.Fatalf("Expected one log entry, got %+v", entries)
+			}
+			entry := entries[0]
+			if entry.Event != "connection-log" || entry.Verdict != VerdictError || !strings.Contains(entry.Error, tt.want) {
+				t.Errorf("Unexpected entry %+v, want an error containing %q", entry, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNS wire format constants (RFC 1035, RFC 3596)
+const (
+	dnsHeaderSize  = 12
+	dnsTypeA       = 1
+	dnsTypeAAAA    = 28
+	dnsRcodeFail   = 2 // SERVFAIL
+	dnsRcodeRefuse = 5 // REFUSED
+	dnsMaxUDPSize  = 65535
+	dnsTimeout     = 5 * time.Second
+)
+
+var errDNSMalformed = errors.New("malformed DNS message")
+
+// DNSAnswer is an address record returned for an allowed query
+type DNSAnswer struct {
+	IP  string
+	TTL time.Duration
+}
+
+// DNSProxy is a forwarding DNS resolver that only answers for allowed names
+// Allowed queries are forwarded upstream and their A/AAAA answers are reported through
+// OnAllow before the response is sent, so firewall rules are open by the time the client connects
+type DNSProxy struct {
+	allowedMu sync.RWMutex
+	allowed   []string
+	upstream  string
+
+	// OnAllow is called with the answers of an allowed query (before the response is returned)
+	OnAllow func(name string, answers []DNSAnswer)
+	// OnDeny is called for queries of names that are not allowed
+	OnDeny func(name string, qtype uint16)
+	// Clients are the IPs allowed to query (the session container's addresses; nil allows any)
+	// Other clients, such as other containers on the bridge, are ignored
+	Clients []string
+
+	udp net.PacketConn
+	tcp net.Listener
+	wg  sync.WaitGroup
+}
+
+// NewDNSProxy creates a DNS proxy for the allowed domains (supports *.example.com patterns)
+// upstream is the resolver address queries are forwarded to (host:port)
+func NewDNSProxy(allowed []string, upstream string) *DNSProxy {
+	return &DNSProxy{
+		allowed:  allowed,
+		upstream: upstream,
+	}
+}
+
+// SetAllowed replaces the allowed domains (queries in flight finish with the old list)
+func (p *DNSProxy) SetAllowed(allowed []string) {
+	p.allowedMu.Lock()
+	defer p.allowedMu.Unlock()
+	p.allowed = allowed
+}
+
+// allows returns true if name matches an allowed domain
+func (p *DNSProxy) allows(name string) bool {
+	p.allowedMu.RLock()
+	defer p.allowedMu.RUnlock()
+	return MatchesDomain(p.allowed, name)
+}
+
+// Start listens on UDP and TCP at host:port (port 0 picks a free port) and returns the port
+func (p *DNSProxy) Start(listenAddr string) (int, error) {
+	udp, err := net.ListenPacket("udp", listenAddr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to listen on %s/udp: %w", listenAddr, err)
+	}
+
+	// TCP uses the same port so a single redirect covers both protocols
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	host, _, _ := net.SplitHostPort(listenAddr)
+	tcp, err := net.Listen("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+	if err != nil {
+		udp.Close()
+		return 0, fmt.Errorf("failed to listen on %s/tcp: %w", listenAddr, err)
+	}
+
+	p.udp = udp
+	p.tcp = tcp
+
+	p.wg.Add(2)
+	go p.serveUDP()
+	go p.serveTCP()
+
+	return port, nil
+}
+
+// Stop closes the listeners and waits for the serving goroutines to exit
+func (p *DNSProxy) Stop() {
+	if p.udp != nil {
+		p.udp.Close()
+	}
+	if p.tcp != nil {
+		p.tcp.Close()
+	}
+	p.wg.Wait()
+}
+
+// serveUDP answers UDP queries, one goroutine per query
+func (p *DNSProxy) serveUDP() {
+	defer p.wg.Done()
+
+	buf := make([]byte, dnsMaxUDPSize)
+	for {
+		n, addr, err := p.udp.ReadFrom(buf)
+		if err != nil {
+			return // Listener closed
+		}
+		if !isClient(p.Clients, addr) {
+			continue
+		}
+
+		query := make([]byte, n)
+		copy(query, buf[:n])
+
+		go func() {
+			if response := p.handle(query, "udp"); response != nil {
+				_, _ = p.udp.WriteTo(response, addr)
+			}
+		}()
+	}
+}
+
+// serveTCP answers TCP queries (length-prefixed messages, RFC 1035 4.2.2)
+func (p *DNSProxy) serveTCP() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.tcp.Accept()
+		if err != nil {
+			return // Listener closed
+		}
+		if !isClient(p.Clients, conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+			query, err := readTCPMessage(conn)
+			if err != nil {
+				return
+			}
+			if response := p.handle(query, "tcp"); response != nil {
+				_ = writeTCPMessage(conn, response)
+			}
+		}()
+	}
+}
+
+// isClient returns true if addr is one of the clients (any address if clients is nil)
+func isClient(clients []string, addr net.Addr) bool {
+	if clients == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, client := range clients {
+		if ip != nil && ip.Equal(net.ParseIP(client)) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle answers a single query: REFUSED for names that are not allowed, otherwise
+// the upstream response (SERVFAIL if the upstream fails)
+func (p *DNSProxy) handle(query []byte, network string) []byte {
+	name, qtype, questionEnd, err := parseDNSQuestion(query)
+	if err != nil {
+		return nil // Not a query we can answer, let the client time out
+	}
+
+	if !p.allows(name) {
+		if p.OnDeny != nil {
+			p.OnDeny(name, qtype)
+		}
+		return dnsErrorResponse(query, questionEnd, dnsRcodeRefuse)
+	}
+
+	response, err := p.exchange(query, network)
+	if err != nil {
+		log.Printf("Warning: DNS proxy failed to forward query for %s: %v", name, err)
+		return dnsErrorResponse(query, questionEnd, dnsRcodeFail)
+	}
+
+	answers, err := parseDNSAnswers(response)
+	if err != nil {
+		return dnsErrorResponse(query, questionEnd, dnsRcodeFail)
+	}
+	if len(answers) > 0 && p.OnAllow != nil {
+		p.OnAllow(name, answers)
+	}
+
+	return response
+}
+
+// exchange forwards a query to the upstream resolver over the given network
+func (p *DNSProxy) exchange(query []byte, network string) ([]byte, error) {
+	conn, err := net.DialTimeout(network, p.upstream, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, dnsMaxUDPSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// readTCPMessage reads a length-prefixed DNS message
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCPMessage writes a length-prefixed DNS message
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	framed := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(framed, uint16(len(msg)))
+	copy(framed[2:], msg)
+	_, err := w.Write(framed)
+	return err
+}
+
+// MatchesDomain returns true if name is allowed by the patterns
+// "example.com" matches only itself, "*.example.com" matches any subdomain (not example.com itself)
+// Matching is case-insensitive and ignores a trailing dot
+func MatchesDomain(patterns []string, name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(pattern), "."))
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(name, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWildcardDomain returns true for *.example.com patterns
+func IsWildcardDomain(domain string) bool {
+	return strings.HasPrefix(strings.TrimSpace(domain), "*.")
+}
+
+// parseDNSQuestion returns the name and type of a query's first question and the offset after it
+func parseDNSQuestion(msg []byte) (string, uint16, int, error) {
+	if len(msg) < dnsHeaderSize {
+		return "", 0, 0, errDNSMalformed
+	}
+	// Must be a query (QR=0) with at least one question
+	if msg[2]&0x80 != 0 || binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return "", 0, 0, errDNSMalformed
+	}
+
+	name, off, err := readDNSName(msg, dnsHeaderSize)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if off+4 > len(msg) {
+		return "", 0, 0, errDNSMalformed
+	}
+	qtype := binary.BigEndian.Uint16(msg[off : off+2])
+
+	return name, qtype, off + 4, nil
+}
+
+// parseDNSAnswers returns the A and AAAA records in a response's answer section
+func parseDNSAnswers(msg []byte) ([]DNSAnswer, error) {
+	if len(msg) < dnsHeaderSize {
+		return nil, errDNSMalformed
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:6]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:8]))
+
+	off := dnsHeaderSize
+	for i := 0; i < qdcount; i++ {
+		var err error
+		if _, off, err = readDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		off += 4 // QTYPE, QCLASS
+	}
+
+	var answers []DNSAnswer
+	for i := 0; i < ancount; i++ {
+		var err error
+		if _, off, err = readDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, errDNSMalformed
+		}
+		rrtype := binary.BigEndian.Uint16(msg[off : off+2])
+		ttl := binary.BigEndian.Uint32(msg[off+4 : off+8])
+		rdlength := int(binary.BigEndian.Uint16(msg[off+8 : off+10]))
+		off += 10
+		if off+rdlength > len(msg) {
+			return nil, errDNSMalformed
+		}
+		rdata := msg[off : off+rdlength]
+		off += rdlength
+
+		if (rrtype == dnsTypeA && rdlength == net.IPv4len) || (rrtype == dnsTypeAAAA && rdlength == net.IPv6len) {
+			answers = append(answers, DNSAnswer{
+				IP:  normalizeIP(net.IP(rdata)),
+				TTL: time.Duration(ttl) * time.Second,
+			})
+		}
+	}
+
+	return answers, nil
+}
+
+// readDNSName reads a (possibly compressed) domain name at off and returns it with the offset after it
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1 // Offset after the name in the original position (set at the first pointer)
+
+	for hops := 0; ; hops++ {
+		if off >= len(msg) || hops > 127 {
+			return "", 0, errDNSMalformed
+		}
+		length := int(msg[off])
+
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+
+		case length&0xC0 == 0xC0:
+			// Compression pointer (RFC 1035 4.1.4)
+			if off+1 >= len(msg) {
+				return "", 0, errDNSMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:off+2]) & 0x3FFF)
+
+		case length&0xC0 != 0:
+			return "", 0, errDNSMalformed
+
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errDNSMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// dnsErrorResponse builds a response with the given rcode that echoes the query's first question
+func dnsErrorResponse(query []byte, questionEnd int, rcode byte) []byte {
+	response := make([]byte, questionEnd)
+	copy(response, query[:questionEnd])
+
+	response[2] = 0x80 | (query[2] & 0x79) // QR=1, keep opcode and RD
+	response[3] = 0x80 | rcode             // RA=1
+	binary.BigEndian.PutUint16(response[4:6], 1)
+	binary.BigEndian.PutUint16(response[6:8], 0)
+	binary.BigEndian.PutUint16(response[8:10], 0)
+	binary.BigEndian.PutUint16(response[10:12], 0)
+
+	return response
+}
+
+// dnsTypeName returns the mnemonic of common query types (TYPE<n> otherwise)
+func dnsTypeName(qtype uint16) string {
+	switch qtype {
+	case dnsTypeA:
+		return "A"
+	case dnsTypeAAAA:
+		return "AAAA"
+	case 2:
+		return "NS"
+	case 5:
+		return "CNAME"
+	case 12:
+		return "PTR"
+	case 15:
+		return "MX"
+	case 16:
+		return "TXT"
+	case 33:
+		return "SRV"
+	case 65:
+		return "HTTPS"
+	default:
+		return fmt.Sprintf("TYPE%d", qtype)
+	}
+}
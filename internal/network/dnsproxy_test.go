@@ -0,0 +1,317 @@
+package network
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMatchesDomain(t *testing.T) {
+	patterns := []string{"api.anthropic.com", "*.githubusercontent.com", "8.8.8.8"}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"api.anthropic.com", true},
+		{"API.Anthropic.com.", true},
+		{"anthropic.com", false},
+		{"evil-api.anthropic.com", false},
+		{"raw.githubusercontent.com", true},
+		{"a.b.githubusercontent.com", true},
+		{"githubusercontent.com", false},
+		{"evilgithubusercontent.com", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchesDomain(patterns, tt.name); got != tt.want {
+			t.Errorf("MatchesDomain(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpiredIPs(t *testing.T) {
+	now := time.Now()
+	expiries := map[string]time.Time{
+		"1.1.1.1": now.Add(-time.Second),
+		"2.2.2.2": now,
+		"3.3.3.3": now.Add(time.Minute),
+	}
+
+	if got := expiredIPs(expiries, now); !reflect.DeepEqual(got, []string{"1.1.1.1", "2.2.2.2"}) {
+		t.Errorf("expiredIPs() = %v, want [1.1.1.1 2.2.2.2]", got)
+	}
+}
+
+func TestParseDNSQuestion(t *testing.T) {
+	query := buildDNSQuery(0x1234, "Example.com", dnsTypeAAAA)
+
+	name, qtype, end, err := parseDNSQuestion(query)
+	if err != nil {
+		t.Fatalf("parseDNSQuestion() failed: %v", err)
+	}
+	if name != "Example.com" || qtype != dnsTypeAAAA || end != len(query) {
+		t.Errorf("parseDNSQuestion() = %q, %d, %d, want Example.com, 28, %d", name, qtype, end, len(query))
+	}
+
+	// Responses and truncated messages are rejected
+	response := buildDNSResponse(query, nil)
+	if _, _, _, err := parseDNSQuestion(response); err == nil {
+		t.Error("Expected error for a response")
+	}
+	if _, _, _, err := parseDNSQuestion(query[:15]); err == nil {
+		t.Error("Expected error for a truncated query")
+	}
+}
+
+func TestParseDNSAnswers(t *testing.T) {
+	query := buildDNSQuery(1, "example.com", dnsTypeA)
+	response := buildDNSResponse(query, []testRecord{
+		{rrtype: 5, ttl: 300, rdata: []byte{0xC0, 12}}, // CNAME (pointer to the question name)
+		{rrtype: dnsTypeA, ttl: 60, rdata: net.ParseIP("93.184.216.34").To4()},
+		{rrtype: dnsTypeAAAA, ttl: 120, rdata: net.ParseIP("2606:2800:220:1::248")},
+	})
+
+	answers, err := parseDNSAnswers(response)
+	if err != nil {
+		t.Fatalf("parseDNSAnswers() failed: %v", err)
+	}
+
+	want := []DNSAnswer{
+		{IP: "93.184.216.34", TTL: 60 * time.Second},
+		{IP: "2606:2800:220:1::248", TTL: 120 * time.Second},
+	}
+	if !reflect.DeepEqual(answers, want) {
+		t.Errorf("parseDNSAnswers() = %v, want %v", answers, want)
+	}
+
+	if _, err := parseDNSAnswers(response[:len(response)-3]); err == nil {
+		t.Error("Expected error for a truncated response")
+	}
+}
+
+func TestReadDNSNamePointerLoop(t *testing.T) {
+	msg := make([]byte, dnsHeaderSize+2)
+	msg[dnsHeaderSize] = 0xC0
+	msg[dnsHeaderSize+1] = dnsHeaderSize // Points to itself
+
+	if _, _, err := readDNSName(msg, dnsHeaderSize); err == nil {
+		t.Error("Expected error for a compression pointer loop")
+	}
+}
+
+func TestDNSErrorResponse(t *testing.T) {
+	query := buildDNSQuery(0xBEEF, "blocked.example", dnsTypeA)
+
+	response := dnsErrorResponse(query, len(query), dnsRcodeRefuse)
+	if binary.BigEndian.Uint16(response[0:2]) != 0xBEEF {
+		t.Error("Expected the query ID to be kept")
+	}
+	if response[2]&0x80 == 0 || response[2]&0x01 == 0 {
+		t.Errorf("Expected QR and RD set, got flags %#x", response[2])
+	}
+	if response[3]&0x0F != dnsRcodeRefuse {
+		t.Errorf("Expected REFUSED, got rcode %d", response[3]&0x0F)
+	}
+	if binary.BigEndian.Uint16(response[6:8]) != 0 {
+		t.Error("Expected no answers")
+	}
+}
+
+func TestDNSProxy(t *testing.T) {
+	upstream := startTestUpstream(t, "93.184.216.34")
+
+	proxy := NewDNSProxy([]string{"*.example.com"}, upstream)
+
+	var mu sync.Mutex
+	var allowed []DNSAnswer
+	var denied []string
+	proxy.OnAllow = func(name string, answers []DNSAnswer) {
+		mu.Lock()
+		defer mu.Unlock()
+		allowed = append(allowed, answers...)
+	}
+	proxy.OnDeny = func(name string, qtype uint16) {
+		mu.Lock()
+		defer mu.Unlock()
+		denied = append(denied, name)
+	}
+
+	port, err := proxy.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(proxy.Stop)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	// Allowed name over UDP - forwarded, answers reported
+	response := exchangeUDP(t, addr, buildDNSQuery(1, "www.example.com", dnsTypeA))
+	if response[3]&0x0F != 0 {
+		t.Errorf("Expected NOERROR for allowed name, got rcode %d", response[3]&0x0F)
+	}
+
+	// Denied name over TCP - refused, not forwarded
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect over TCP: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := writeTCPMessage(conn, buildDNSQuery(2, "evil.com", dnsTypeA)); err != nil {
+		t.Fatalf("Failed to send TCP query: %v", err)
+	}
+	response, err = readTCPMessage(conn)
+	if err != nil {
+		t.Fatalf("Failed to read TCP response: %v", err)
+	}
+	if response[3]&0x0F != dnsRcodeRefuse {
+		t.Errorf("Expected REFUSED for denied name, got rcode %d", response[3]&0x0F)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(allowed, []DNSAnswer{{IP: "93.184.216.34", TTL: 30 * time.Second}}) {
+		t.Errorf("Expected allowed answer for www.example.com, got %v", allowed)
+	}
+	if !reflect.DeepEqual(denied, []string{"evil.com"}) {
+		t.Errorf("Expected denied lookup for evil.com, got %v", denied)
+	}
+}
+
+func TestDNSProxyIgnoresOtherClients(t *testing.T) {
+	upstream := startTestUpstream(t, "93.184.216.34")
+
+	proxy := NewDNSProxy([]string{"*.example.com"}, upstream)
+	proxy.Clients = []string{"10.47.62.50"}
+	port, err := proxy.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(proxy.Stop)
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect over TCP: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := writeTCPMessage(conn, buildDNSQuery(1, "www.example.com", dnsTypeA)); err != nil {
+		return // Already closed by the proxy
+	}
+	if response, err := readTCPMessage(conn); err == nil {
+		t.Errorf("Expected no answer for another client, got %v", response)
+	}
+}
+
+func TestIsClient(t *testing.T) {
+	clients := []string{"10.47.62.50", "fd42::50"}
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("10.47.62.50"), Port: 5353}, true},
+		{&net.TCPAddr{IP: net.ParseIP("fd42::50"), Port: 40000}, true},
+		{&net.UDPAddr{IP: net.ParseIP("10.47.62.51"), Port: 5353}, false},
+	}
+	for _, tt := range tests {
+		if got := isClient(clients, tt.addr); got != tt.want {
+			t.Errorf("isClient(%v) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if !isClient(nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}) {
+		t.Error("isClient() without clients should allow any address")
+	}
+}
+
+type testRecord struct {
+	rrtype uint16
+	ttl    uint32
+	rdata  []byte
+}
+
+// buildDNSQuery builds a recursive query with a single question
+func buildDNSQuery(id uint16, name string, qtype uint16) []byte {
+	msg := make([]byte, dnsHeaderSize)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	msg[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // IN
+	return msg
+}
+
+// buildDNSResponse answers a query, every record named by a pointer to the question name
+func buildDNSResponse(query []byte, records []testRecord) []byte {
+	msg := append([]byte{}, query...)
+	msg[2] |= 0x80
+	msg[3] = 0x80
+	binary.BigEndian.PutUint16(msg[6:8], uint16(len(records)))
+
+	for _, record := range records {
+		msg = append(msg, 0xC0, dnsHeaderSize)
+		msg = binary.BigEndian.AppendUint16(msg, record.rrtype)
+		msg = binary.BigEndian.AppendUint16(msg, 1)
+		msg = binary.BigEndian.AppendUint32(msg, record.ttl)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(record.rdata)))
+		msg = append(msg, record.rdata...)
+	}
+	return msg
+}
+
+// startTestUpstream starts a UDP resolver answering every query with an A record for ip
+func startTestUpstream(t *testing.T, ip string) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start upstream: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			response := buildDNSResponse(buf[:n], []testRecord{{rrtype: dnsTypeA, ttl: 30, rdata: net.ParseIP(ip).To4()}})
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func exchangeUDP(t *testing.T, addr string, query []byte) []byte {
+	t.Helper()
+
+	conn, err := net.DialTimeout("udp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write(query); err != nil {
+		t.Fatalf("Failed to send query: %v", err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return buf[:n]
+}
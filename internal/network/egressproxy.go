@@ -0,0 +1,529 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// egressDialTimeout bounds connecting to a destination
+	egressDialTimeout = 10 * time.Second
+	// egressHelloTimeout bounds reading the TLS ClientHello of a redirected connection
+	egressHelloTimeout = 10 * time.Second
+	// tlsMaxRecordSize is the largest TLS record (2^14 plus expansion room)
+	tlsMaxRecordSize = 16384 + 2048
+)
+
+var errNoSNI = errors.New("no server name in TLS ClientHello")
+
+// hopHeaders are connection-specific headers that must not be forwarded (RFC 9110 7.6.1)
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// EgressProxy is the proxy network mode's forward proxy, allowing connections by hostname
+// The HTTP listener serves explicit proxy requests (HTTP_PROXY, CONNECT) and transparently
+// redirected port 80 traffic; the TLS listener serves redirected port 443 traffic using the
+// ClientHello's SNI. Destinations are dialed by name from the host, so a client cannot pair
+// an allowed name with another IP
+type EgressProxy struct {
+	allowedMu    sync.RWMutex
+	allowed      []AllowEntry
+	blockPrivate bool
+
+	// OnRequest is called once per request or connection with its log entry
+	OnRequest func(entry LogEntry)
+	// Clients are the IPs allowed to connect (the session container's addresses; nil allows any)
+	// Connections from other clients, such as other containers on the bridge, are closed
+	Clients []string
+
+	httpServer *http.Server
+	httpLn     net.Listener
+	tlsLn      net.Listener
+	transport  *http.Transport
+	dialer     *net.Dialer
+	wg         sync.WaitGroup
+}
+
+// NewEgressProxy creates an egress proxy for the allowlist entries (supports *.example.com patterns
+// and port limits); with blockPrivate, allowed names resolving to private, link-local or loopback
+// addresses are refused
+func NewEgressProxy(allowed []AllowEntry, blockPrivate bool) *EgressProxy {
+	p := &EgressProxy{
+		allowed:      allowed,
+		blockPrivate: blockPrivate,
+	}
+
+	p.dialer = &net.Dialer{Timeout: egressDialTimeout, Control: p.checkDestination}
+	p.transport = &http.Transport{
+		Proxy:                 nil, // Never chain through the host's own proxy settings
+		DialContext:           p.dialer.DialContext,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	p.httpServer = &http.Server{
+		Handler:           p,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	return p
+}
+
+// Start listens on the host (port 0 each) and returns the HTTP and TLS listener ports
+func (p *EgressProxy) Start(host string) (int, int, error) {
+	httpLn, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to listen for HTTP: %w", err)
+	}
+	tlsLn, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		httpLn.Close()
+		return 0, 0, fmt.Errorf("failed to listen for TLS: %w", err)
+	}
+
+	p.httpLn = clientListener{Listener: httpLn, clients: p.Clients}
+	p.tlsLn = clientListener{Listener: tlsLn, clients: p.Clients}
+
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		_ = p.httpServer.Serve(p.httpLn)
+	}()
+	go p.serveTLS()
+
+	return httpLn.Addr().(*net.TCPAddr).Port, tlsLn.Addr().(*net.TCPAddr).Port, nil
+}
+
+// clientListener closes accepted connections that do not come from one of the clients
+type clientListener struct {
+	net.Listener
+	clients []string
+}
+
+func (l clientListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || isClient(l.clients, conn.RemoteAddr()) {
+			return conn, err
+		}
+		conn.Close()
+	}
+}
+
+// Stop closes the listeners and open connections
+func (p *EgressProxy) Stop() {
+	if p.httpLn != nil {
+		_ = p.httpServer.Close()
+	}
+	if p.tlsLn != nil {
+		p.tlsLn.Close()
+	}
+	p.transport.CloseIdleConnections()
+	p.wg.Wait()
+}
+
+// ServeHTTP handles CONNECT tunnels and plain HTTP requests (absolute-form or redirected)
+func (p *EgressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.serveConnect(w, r)
+		return
+	}
+
+	// Explicit proxy requests carry an absolute URL, redirected ones only the Host header
+	host := r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	name, port := splitHostPortDefault(host, 80)
+
+	entry := LogEntry{Event: "http", Domain: name, Port: port}
+	if !p.allows(name, port) {
+		entry.Verdict = VerdictDenied
+		p.record(entry)
+		http.Error(w, fmt.Sprintf("coi: %s is not in the network allowlist", name), http.StatusForbidden)
+		return
+	}
+
+	body := &countingReader{r: r.Body}
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.URL.Scheme = "http"
+	out.URL.Host = net.JoinHostPort(name, strconv.Itoa(port))
+	out.Host = host
+	out.Body = body
+	if r.ContentLength == 0 {
+		out.Body = nil
+	}
+	removeHopHeaders(out.Header)
+
+	entry.Verdict = VerdictAllowed
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		entry.Error = err.Error()
+		entry.BytesOut = body.n.Load()
+		p.record(entry)
+		http.Error(w, fmt.Sprintf("coi: failed to reach %s: %v", name, err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	removeHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	n, _ := io.Copy(w, resp.Body)
+
+	entry.BytesOut = body.n.Load()
+	entry.BytesIn = n
+	p.record(entry)
+}
+
+// serveConnect opens a tunnel for an allowed CONNECT request
+func (p *EgressProxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	name, port := splitHostPortDefault(r.Host, 443)
+
+	entry := LogEntry{Event: "connect", Domain: name, Port: port}
+	if !p.allows(name, port) {
+		entry.Verdict = VerdictDenied
+		p.record(entry)
+		http.Error(w, fmt.Sprintf("coi: %s is not in the network allowlist", name), http.StatusForbidden)
+		return
+	}
+
+	entry.Verdict = VerdictAllowed
+	upstream, err := p.dialer.DialContext(r.Context(), "tcp", net.JoinHostPort(name, strconv.Itoa(port)))
+	if err != nil {
+		entry.Error = err.Error()
+		p.record(entry)
+		http.Error(w, fmt.Sprintf("coi: failed to reach %s: %v", name, err), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "coi: tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	// Bytes the client sent right after the CONNECT request are already buffered
+	var pending []byte
+	if n := buffered.Reader.Buffered(); n > 0 {
+		pending, _ = buffered.Reader.Peek(n)
+	}
+
+	entry.BytesOut, entry.BytesIn = splice(client, upstream, pending)
+	p.record(entry)
+}
+
+// serveTLS accepts redirected TLS connections
+func (p *EgressProxy) serveTLS() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.tlsLn.Accept()
+		if err != nil {
+			return // Listener closed
+		}
+		go p.handleTLS(conn)
+	}
+}
+
+// handleTLS allows or denies a redirected TLS connection by the SNI of its ClientHello
+func (p *EgressProxy) handleTLS(client net.Conn) {
+	entry := LogEntry{Event: "tls", Port: 443}
+
+	_ = client.SetReadDeadline(time.Now().Add(egressHelloTimeout))
+	hello, name, err := readClientHello(client)
+	_ = client.SetReadDeadline(time.Time{})
+	if err != nil {
+		entry.Verdict = VerdictDenied
+		entry.Error = err.Error()
+		p.record(entry)
+		client.Close()
+		return
+	}
+
+	entry.Domain = name
+	if !p.allows(name, 443) {
+		entry.Verdict = VerdictDenied
+		p.record(entry)
+		client.Close()
+		return
+	}
+
+	entry.Verdict = VerdictAllowed
+	upstream, err := p.dialer.Dial("tcp", net.JoinHostPort(name, "443"))
+	if err != nil {
+		entry.Error = err.Error()
+		p.record(entry)
+		client.Close()
+		return
+	}
+
+	entry.BytesOut, entry.BytesIn = splice(client, upstream, hello)
+	p.record(entry)
+}
+
+// checkDestination refuses connections to private, link-local and loopback addresses
+// (dialer control hook, runs after DNS resolution for every address tried)
+func (p *EgressProxy) checkDestination(network, address string, _ syscall.RawConn) error {
+	if !p.blockPrivate {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid destination address: %s", address)
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return fmt.Errorf("destination %s is a local address", ip)
+	}
+	for _, cidr := range append(append([]string{}, privateNetworks...), linkLocalNetworks...) {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return fmt.Errorf("destination %s is in a private network (%s)", ip, cidr)
+		}
+	}
+	return nil
+}
+
+// SetAllowed replaces the allowlist entries (open tunnels are kept)
+func (p *EgressProxy) SetAllowed(allowed []AllowEntry) {
+	p.allowedMu.Lock()
+	defer p.allowedMu.Unlock()
+	p.allowed = allowed
+}
+
+// allows returns true if an entry matches the host name and TCP port
+func (p *EgressProxy) allows(name string, port int) bool {
+	p.allowedMu.RLock()
+	defer p.allowedMu.RUnlock()
+	for _, entry := range p.allowed {
+		if MatchesDomain([]string{entry.Host}, name) && entry.Allows("tcp", port) {
+			return true
+		}
+	}
+	return false
+}
+
+// record passes a log entry to OnRequest
+func (p *EgressProxy) record(entry LogEntry) {
+	if p.OnRequest != nil {
+		p.OnRequest(entry)
+	}
+}
+
+// splice copies data in both directions until either side is done, then closes both
+// pending is sent to upstream first; returns the bytes sent to and received from upstream
+func splice(client, upstream net.Conn, pending []byte) (int64, int64) {
+	defer client.Close()
+	defer upstream.Close()
+
+	var out, in int64
+	if len(pending) > 0 {
+		n, err := upstream.Write(pending)
+		out += int64(n)
+		if err != nil {
+			return out, in
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		n, _ := io.Copy(client, upstream)
+		atomic.AddInt64(&in, n)
+		// Unblock the other direction
+		client.Close()
+		close(done)
+	}()
+
+	n, _ := io.Copy(upstream, client)
+	out += n
+	if tcp, ok := upstream.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	}
+	<-done
+
+	return out, atomic.LoadInt64(&in)
+}
+
+// readClientHello reads the first TLS record and returns it with the SNI server name
+func readClientHello(r io.Reader) ([]byte, string, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, "", fmt.Errorf("failed to read TLS record: %w", err)
+	}
+	if header[0] != 22 { // Handshake
+		return nil, "", fmt.Errorf("not a TLS handshake")
+	}
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+	if length > tlsMaxRecordSize {
+		return nil, "", fmt.Errorf("TLS record too large")
+	}
+
+	record := make([]byte, 5+length)
+	copy(record, header)
+	if _, err := io.ReadFull(r, record[5:]); err != nil {
+		return nil, "", fmt.Errorf("failed to read TLS record: %w", err)
+	}
+
+	name, err := parseClientHelloSNI(record[5:])
+	if err != nil {
+		return nil, "", err
+	}
+	return record, name, nil
+}
+
+// parseClientHelloSNI extracts the server_name extension from a ClientHello handshake message
+// (RFC 8446 4.1.2, RFC 6066 3)
+func parseClientHelloSNI(msg []byte) (string, error) {
+	r := &byteReader{buf: msg}
+
+	if r.u8() != 1 { // ClientHello
+		return "", fmt.Errorf("not a TLS ClientHello")
+	}
+	r.skip(3)                   // Handshake length
+	r.skip(2 + 32)              // Legacy version, random
+	r.skip(int(r.u8()))         // Session ID
+	r.skip(int(r.u16()))        // Cipher suites
+	r.skip(int(r.u8()))         // Compression methods
+	end := r.off + int(r.u16()) // Extensions
+
+	for r.off+4 <= end && !r.failed {
+		extType := r.u16()
+		extLen := int(r.u16())
+		if extType != 0 { // server_name
+			r.skip(extLen)
+			continue
+		}
+
+		listEnd := r.off + int(r.u16())
+		for r.off+3 <= listEnd && !r.failed {
+			nameType := r.u8()
+			name := r.bytes(int(r.u16()))
+			if nameType == 0 && !r.failed { // host_name
+				return strings.ToLower(string(name)), nil
+			}
+		}
+		break
+	}
+
+	if r.failed {
+		return "", fmt.Errorf("malformed TLS ClientHello")
+	}
+	return "", errNoSNI
+}
+
+// byteReader reads big-endian fields, recording overruns instead of panicking
+type byteReader struct {
+	buf    []byte
+	off    int
+	failed bool
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	if r.failed || n < 0 || r.off+n > len(r.buf) {
+		r.failed = true
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *byteReader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *byteReader) u8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *byteReader) u16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+// countingReader counts bytes read from a request body
+type countingReader struct {
+	r io.ReadCloser
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.r == nil {
+		return 0, io.EOF
+	}
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	if c.r == nil {
+		return nil
+	}
+	return c.r.Close()
+}
+
+// removeHopHeaders drops hop-by-hop headers, including those named in Connection
+func removeHopHeaders(header http.Header) {
+	for _, field := range header.Values("Connection") {
+		for _, name := range strings.Split(field, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// splitHostPortDefault splits host[:port], using defaultPort when there is none
+func splitHostPortDefault(hostport string, defaultPort int) (string, int) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return strings.ToLower(strings.Trim(hostport, "[]")), defaultPort
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return strings.ToLower(host), defaultPort
+	}
+	return strings.ToLower(host), port
+}
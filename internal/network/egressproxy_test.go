@@ -0,0 +1,267 @@
+package network
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadClientHello(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		defer client.Close()
+		conn := tls.Client(client, &tls.Config{ServerName: "API.Example.com", InsecureSkipVerify: true})
+		_ = conn.Handshake() // Fails once the server side closes
+	}()
+
+	_ = server.SetDeadline(time.Now().Add(2 * time.Second))
+	record, name, err := readClientHello(server)
+	if err != nil {
+		t.Fatalf("readClientHello() failed: %v", err)
+	}
+	if name != "api.example.com" {
+		t.Errorf("readClientHello() name = %q, want api.example.com", name)
+	}
+	if record[0] != 22 {
+		t.Errorf("Expected the handshake record to be returned, got type %d", record[0])
+	}
+}
+
+func TestParseClientHelloSNIErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"not a ClientHello", []byte{2, 0, 0, 0}},
+		{"truncated", []byte{1, 0, 0, 40, 3, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseClientHelloSNI(tt.msg); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestSplitHostPortDefault(t *testing.T) {
+	tests := []struct {
+		hostport string
+		wantHost string
+		wantPort int
+	}{
+		{"Example.com", "example.com", 80},
+		{"example.com:8080", "example.com", 8080},
+		{"[2001:db8::1]:443", "2001:db8::1", 443},
+		{"[2001:db8::1]", "2001:db8::1", 80},
+		{"example.com:http", "example.com", 80},
+	}
+
+	for _, tt := range tests {
+		host, port := splitHostPortDefault(tt.hostport, 80)
+		if host != tt.wantHost || port != tt.wantPort {
+			t.Errorf("splitHostPortDefault(%q) = %s, %d, want %s, %d", tt.hostport, host, port, tt.wantHost, tt.wantPort)
+		}
+	}
+}
+
+func TestRemoveHopHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Connection", "keep-alive, X-Custom")
+	header.Set("X-Custom", "1")
+	header.Set("Proxy-Authorization", "secret")
+	header.Set("Accept", "*/*")
+
+	removeHopHeaders(header)
+
+	for _, name := range []string{"Connection", "X-Custom", "Proxy-Authorization"} {
+		if header.Get(name) != "" {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+	if header.Get("Accept") != "*/*" {
+		t.Error("Expected Accept to be kept")
+	}
+}
+
+func TestCheckDestination(t *testing.T) {
+	blocking := NewEgressProxy(nil, true)
+	for _, address := range []string{"10.1.2.3:80", "192.168.1.1:443", "127.0.0.1:80", "[fe80::1]:443", "[fd00::1]:80"} {
+		if err := blocking.checkDestination("tcp", address, nil); err == nil {
+			t.Errorf("checkDestination(%s) = nil, want error", address)
+		}
+	}
+	if err := blocking.checkDestination("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("checkDestination(public) = %v, want nil", err)
+	}
+
+	open := NewEgressProxy(nil, false)
+	if err := open.checkDestination("tcp", "10.1.2.3:80", nil); err != nil {
+		t.Errorf("checkDestination() without blocking = %v, want nil", err)
+	}
+}
+
+func TestEgressProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	// The test server is on loopback, so private destinations must not be blocked
+	proxy := NewEgressProxy([]AllowEntry{{Host: "127.0.0.1"}}, false)
+
+	var mu sync.Mutex
+	var entries []LogEntry
+	proxy.OnRequest = func(entry LogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		entries = append(entries, entry)
+	}
+
+	httpPort, _, err := proxy.Start("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(proxy.Stop)
+
+	proxyURL, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", httpPort))
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	// Allowed host - forwarded
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("GET through proxy failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("Expected 200 hello, got %d %q", resp.StatusCode, body)
+	}
+
+	// Denied host - rejected without connecting
+	resp, err = client.Get("http://denied.example:" + upstreamURL.Port())
+	if err != nil {
+		t.Fatalf("GET through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for denied host, got %d", resp.StatusCode)
+	}
+
+	// Allowed CONNECT tunnel carries raw bytes both ways
+	conn, err := net.DialTimeout("tcp", proxyURL.Host, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", upstreamURL.Host, upstreamURL.Host)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil || !strings.Contains(status, "200") {
+		t.Fatalf("Expected 200 for CONNECT, got %q (%v)", status, err)
+	}
+	_, _ = reader.ReadString('\n') // Blank line
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", upstreamURL.Host)
+	tunneled, _ := io.ReadAll(reader)
+	if !strings.HasSuffix(string(tunneled), "hello") {
+		t.Errorf("Expected tunneled response, got %q", tunneled)
+	}
+	conn.Close()
+
+	// Entries are recorded once each connection is done
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(entries)
+		mu.Unlock()
+		if n >= 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 log entries, got %d: %+v", len(entries), entries)
+	}
+	if e := entries[0]; e.Event != "http" || e.Verdict != VerdictAllowed || e.BytesIn != 5 {
+		t.Errorf("Unexpected entry for allowed request: %+v", e)
+	}
+	if e := entries[1]; e.Domain != "denied.example" || e.Verdict != VerdictDenied {
+		t.Errorf("Unexpected entry for denied request: %+v", e)
+	}
+	if e := entries[2]; e.Event != "connect" || e.Verdict != VerdictAllowed || e.BytesOut == 0 || e.BytesIn == 0 {
+		t.Errorf("Unexpected entry for CONNECT: %+v", e)
+	}
+}
+
+func TestEgressProxyClosesOtherClients(t *testing.T) {
+	proxy := NewEgressProxy([]AllowEntry{{Host: "127.0.0.1"}}, false)
+	proxy.Clients = []string{"10.47.62.50"}
+	httpPort, tlsPort, err := proxy.Start("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(proxy.Stop)
+
+	for _, port := range []int{httpPort, tlsPort} {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err != nil {
+			t.Fatalf("Failed to connect to proxy: %v", err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		fmt.Fprint(conn, "GET http://127.0.0.1/ HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n")
+		// Closed with unread data, the connection may be reset instead of ending cleanly
+		if reply, _ := io.ReadAll(conn); len(reply) != 0 {
+			t.Errorf("Expected port %d to close the connection, got %q", port, reply)
+		}
+		conn.Close()
+	}
+}
+
+func TestEgressProxyAllows(t *testing.T) {
+	proxy := NewEgressProxy([]AllowEntry{
+		{Host: "api.example.com"},
+		{Host: "*.example.org", Protocol: "tcp", Port: 443},
+		{Host: "dns.example.net", Protocol: "udp", Port: 443},
+	}, true)
+
+	tests := []struct {
+		name string
+		port int
+		want bool
+	}{
+		{"api.example.com", 80, true},
+		{"api.example.com", 8443, true},
+		{"www.example.org", 443, true},
+		{"www.example.org", 80, false},
+		{"example.org", 443, false},
+		{"dns.example.net", 443, false}, // Proxied connections are TCP
+		{"other.com", 443, false},
+	}
+
+	for _, tt := range tests {
+		if got := proxy.allows(tt.name, tt.port); got != tt.want {
+			t.Errorf("allows(%s, %d) = %v, want %v", tt.name, tt.port, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,7 @@
 package network
 
 import (
-	"encoding/json"
 	"fmt"
-	"log"
-	"os/exec"
-	"sort"
 	"strings"
 	"time"
 
@@ -13,263 +9,279 @@ import (
 	"github.com/mensfeld/code-on-incus/internal/container"
 )
 
-// FirewallManager manages firewalld direct rules for container network isolation
-type FirewallManager struct {
-	containerIP string
-	gatewayIP   string
-}
+// FirewallBackend enforces network isolation rules for a single container
+// All backends translate the same rule list (see restrictedRules and allowlistRules),
+// so restricted and allowlist modes behave identically regardless of the backend
+type FirewallBackend interface {
+	// Name returns the backend name ("firewalld", "nftables" or "incus-acl")
+	Name() string
 
-// NewFirewallManager creates a new firewall manager for a container
-func NewFirewallManager(containerIP, gatewayIP string) *FirewallManager {
-	return &FirewallManager{
-		containerIP: containerIP,
-		gatewayIP:   gatewayIP,
-	}
-}
+	// EnsureBaseRules adds host-wide rules needed before container rules (e.g., return traffic)
+	EnsureBaseRules() error
 
-// ApplyRestricted applies restricted mode rules (block RFC1918, allow internet)
-func (f *FirewallManager) ApplyRestricted(cfg *config.NetworkConfig) error {
-	// Ensure base rules for return traffic are in place
-	if err := EnsureBaseRules(); err != nil {
-		log.Printf("Warning: failed to ensure base rules: %v", err)
-	}
+	// ApplyOpen allows all traffic for the container (open mode)
+	ApplyOpen() error
 
-	// Priority 0: Allow gateway (for host communication)
-	if f.gatewayIP != "" {
-		if err := f.addRule(0, f.containerIP, f.gatewayIP+"/32", "ACCEPT"); err != nil {
-			return fmt.Errorf("failed to add gateway allow rule: %w", err)
-		}
-	}
+	// ApplyRestricted applies restricted mode rules (block RFC1918, allow internet)
+	// Exceptions (IP or CIDR entries) are allowed even inside blocked ranges
+	ApplyRestricted(cfg *config.NetworkConfig, exceptions []AllowEntry) error
 
-	// Handle local network access
-	if cfg.AllowLocalNetworkAccess {
-		// Allow all RFC1918 when local network access is enabled
-		if err := f.addRule(1, f.containerIP, "10.0.0.0/8", "ACCEPT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 allow rule: %w", err)
-		}
-		if err := f.addRule(1, f.containerIP, "172.16.0.0/12", "ACCEPT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 allow rule: %w", err)
-		}
-		if err := f.addRule(1, f.containerIP, "192.168.0.0/16", "ACCEPT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 allow rule: %w", err)
-		}
-	} else if cfg.BlockPrivateNetworks {
-		// Block RFC1918 ranges
-		if err := f.addRule(10, f.containerIP, "10.0.0.0/8", "REJECT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 block rule: %w", err)
+	// ApplyAllowlist applies allowlist mode rules (allow specific IPs, block all else)
+	// Entries must be IPs or CIDRs, optionally limited to a port
+	ApplyAllowlist(cfg *config.NetworkConfig, allowed []AllowEntry) error
+
+	// AllowDestination allows traffic to a single IP on top of the applied rules (DNS proxy answers)
+	// Backends that support it (nftables) drop the destination in the kernel after ttl, so it does
+	// not stay allowed if the session dies; allowing an allowed destination again renews its ttl
+	AllowDestination(ip string, ttl time.Duration) error
+
+	// RevokeDestination removes a destination added with AllowDestination
+	RevokeDestination(ip string) error
+
+	// Redirect sends the container's traffic for the given ports to local proxies on the IPv4 gateway
+	Redirect(redirects []PortRedirect) error
+
+	// RemoveRules removes all rules for the container (including dynamic destinations and redirects)
+	RemoveRules() error
+
+	// ListRules returns the container's active rules in the backend's own syntax (for `coi network status`)
+	ListRules() ([]string, error)
+
+	// SetLogging makes rules applied afterwards log new connections to the kernel log (see logPrefix)
+	SetLogging(enabled bool) error
+}
+
+// PortRedirect redirects a destination port (any destination address) to a port on the gateway
+type PortRedirect struct {
+	Protocols []string // "tcp", "udp"
+	Port      int      // Destination port of the container's traffic
+	ToPort    int      // Port of the local proxy on the gateway
+}
+
+// ResolveFirewallBackend turns the configured backend into a concrete one and checks it can be used
+// auto prefers firewalld (when running) and falls back to nftables
+func ResolveFirewallBackend(backend config.NetworkBackend) (config.NetworkBackend, error) {
+	switch backend {
+	case "", config.NetworkBackendAuto:
+		if FirewallAvailable() {
+			return config.NetworkBackendFirewalld, nil
 		}
-		if err := f.addRule(10, f.containerIP, "172.16.0.0/12", "REJECT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 block rule: %w", err)
+		if NFTablesAvailable() {
+			return config.NetworkBackendNFTables, nil
 		}
-		if err := f.addRule(10, f.containerIP, "192.168.0.0/16", "REJECT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 block rule: %w", err)
+		return "", fmt.Errorf("%s", errFirewallNotAvailable)
+
+	case config.NetworkBackendFirewalld:
+		if !FirewallAvailable() {
+			return "", fmt.Errorf("%s", errFirewallNotAvailable)
 		}
-	}
+		return backend, nil
 
-	// Block metadata endpoints
-	if cfg.BlockMetadataEndpoint {
-		if err := f.addRule(10, f.containerIP, "169.254.0.0/16", "REJECT"); err != nil {
-			return fmt.Errorf("failed to add metadata block rule: %w", err)
+	case config.NetworkBackendNFTables:
+		if !NFTablesAvailable() {
+			return "", fmt.Errorf("%s", errNFTablesNotAvailable)
 		}
-	}
+		return backend, nil
 
-	// Explicitly allow all other traffic (internet)
-	// Needed because FORWARD chain policy might be DROP with firewalld
-	if err := f.addRule(50, f.containerIP, "0.0.0.0/0", "ACCEPT"); err != nil {
-		return fmt.Errorf("failed to add default allow rule: %w", err)
+	case config.NetworkBackendIncusACL:
+		return backend, nil
+
+	default:
+		return "", fmt.Errorf("unknown network backend: %s (use auto, firewalld, nftables or incus-acl)", backend)
 	}
+}
 
-	return nil
+// ContainerAddresses holds a container's addresses and the gateways of its bridge
+type ContainerAddresses struct {
+	IPv4        string `json:"ipv4"`
+	IPv6        string `json:"ipv6,omitempty"` // Global IPv6 address, "" if the container has none (see pinContainerIPv6)
+	GatewayIPv4 string `json:"gateway_ipv4,omitempty"`
+	GatewayIPv6 string `json:"gateway_ipv6,omitempty"` // "" if the bridge has no IPv6
 }
 
-// ApplyAllowlist applies allowlist mode rules (allow specific IPs, block all else)
-func (f *FirewallManager) ApplyAllowlist(cfg *config.NetworkConfig, allowedIPs []string) error {
-	// Ensure base rules for return traffic are in place
-	if err := EnsureBaseRules(); err != nil {
-		log.Printf("Warning: failed to ensure base rules: %v", err)
+// Source returns the container address matching a rule's address family ("" if there is none)
+func (a ContainerAddresses) Source(rule Rule) string {
+	if rule.IPv6() {
+		return a.IPv6
 	}
+	return a.IPv4
+}
 
-	// Priority 0: Allow gateway (for host communication and DNS via dnsmasq)
-	// DNS works through the bridge's dnsmasq - no public DNS servers allowed
-	// to prevent DNS exfiltration attacks
-	if f.gatewayIP != "" {
-		if err := f.addRule(0, f.containerIP, f.gatewayIP+"/32", "ACCEPT"); err != nil {
-			return fmt.Errorf("failed to add gateway allow rule: %w", err)
-		}
+// IPs returns the container's addresses (IPv4, and IPv6 if it has one)
+func (a ContainerAddresses) IPs() []string {
+	if a.IPv6 == "" {
+		return []string{a.IPv4}
 	}
+	return []string{a.IPv4, a.IPv6}
+}
 
-	// Handle local network access
-	if cfg.AllowLocalNetworkAccess {
-		// Allow all RFC1918 when local network access is enabled
-		if err := f.addRule(1, f.containerIP, "10.0.0.0/8", "ACCEPT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 allow rule: %w", err)
-		}
-		if err := f.addRule(1, f.containerIP, "172.16.0.0/12", "ACCEPT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 allow rule: %w", err)
-		}
-		if err := f.addRule(1, f.containerIP, "192.168.0.0/16", "ACCEPT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 allow rule: %w", err)
-		}
+// NewFirewallBackend creates a firewall backend for a container
+// The backend must already be resolved (see ResolveFirewallBackend)
+func NewFirewallBackend(backend config.NetworkBackend, containerName string, addrs ContainerAddresses) (FirewallBackend, error) {
+	switch backend {
+	case config.NetworkBackendFirewalld:
+		return NewFirewalldBackend(addrs), nil
+	case config.NetworkBackendNFTables:
+		return NewNFTablesBackend(addrs), nil
+	case config.NetworkBackendIncusACL:
+		return NewIncusACLBackend(containerName, addrs), nil
+	default:
+		return nil, fmt.Errorf("unsupported network backend: %s", backend)
 	}
+}
 
-	// Priority 1: Allow specific IPs (from resolved domains)
-	// Sort for deterministic ordering
-	sortedIPs := make([]string, len(allowedIPs))
-	copy(sortedIPs, allowedIPs)
-	sort.Strings(sortedIPs)
+// RuleAction is the verdict of a firewall rule
+type RuleAction string
 
-	for _, ip := range sortedIPs {
-		dest := ip
-		if !strings.Contains(ip, "/") {
-			dest = ip + "/32"
-		}
-		if err := f.addRule(1, f.containerIP, dest, "ACCEPT"); err != nil {
-			return fmt.Errorf("failed to add allowlist rule for %s: %w", ip, err)
-		}
-	}
+const (
+	RuleAccept RuleAction = "ACCEPT"
+	RuleReject RuleAction = "REJECT"
+)
 
-	// Block RFC1918 and metadata (unless local network access is enabled)
-	if !cfg.AllowLocalNetworkAccess {
-		if err := f.addRule(10, f.containerIP, "10.0.0.0/8", "REJECT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 block rule: %w", err)
-		}
-		if err := f.addRule(10, f.containerIP, "172.16.0.0/12", "REJECT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 block rule: %w", err)
-		}
-		if err := f.addRule(10, f.containerIP, "192.168.0.0/16", "REJECT"); err != nil {
-			return fmt.Errorf("failed to add RFC1918 block rule: %w", err)
-		}
-		if err := f.addRule(10, f.containerIP, "169.254.0.0/16", "REJECT"); err != nil {
-			return fmt.Errorf("failed to add metadata block rule: %w", err)
-		}
-	}
+// Rule priorities, lower runs first (the first matching rule wins)
+const (
+	priorityGateway      = 0  // Host communication and DNS via the bridge's dnsmasq
+	priorityMetadataIPv6 = 0  // The IPv6 metadata endpoint lies inside fc00::/7, so it is blocked before local network allows
+	priorityAllow        = 1  // Allowlisted destinations and opted-in local networks
+	priorityBlock        = 10 // RFC1918 and metadata blocks
+	priorityDefaultAllow = 50 // Restricted mode: everything else is allowed
+	priorityDefaultDeny  = 99 // Allowlist mode: everything else is rejected
+)
 
-	// Priority 99: Default deny for allowlist mode
-	if err := f.addRule(99, f.containerIP, "0.0.0.0/0", "REJECT"); err != nil {
-		return fmt.Errorf("failed to add default deny rule: %w", err)
-	}
+// Rule is a backend-independent egress rule for a container
+type Rule struct {
+	Priority    int
+	Destination string // CIDR (IPv4 or IPv6)
+	Action      RuleAction
+	Protocol    string // "tcp" or "udp", "" for any protocol
+	Port        int    // Destination port, 0 for any port
+}
 
-	return nil
+// IPv6 returns true if the rule's destination is an IPv6 network
+func (r Rule) IPv6() bool {
+	return strings.Contains(r.Destination, ":")
 }
 
-// RemoveRules removes all firewall rules for this container's IP
-func (f *FirewallManager) RemoveRules() error {
-	if f.containerIP == "" {
-		return nil
-	}
+// PortLimited returns true if the rule only matches a single port
+func (r Rule) PortLimited() bool {
+	return r.Port != 0
+}
 
-	// List all direct rules
-	rules, err := f.listDirectRules()
-	if err != nil {
-		return fmt.Errorf("failed to list firewall rules: %w", err)
+var (
+	// Private networks: RFC1918 and IPv6 unique local addresses
+	privateNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+	// Link-local ranges, which include the IPv4 cloud metadata endpoint
+	linkLocalNetworks = []string{"169.254.0.0/16", "fe80::/10"}
+	// IPv6 cloud metadata endpoint (AWS)
+	metadataIPv6 = "fd00:ec2::254/128"
+	anyNetworks  = []string{"0.0.0.0/0", "::/0"}
+)
+
+// restrictedRules returns the rules for restricted mode
+// Exceptions are allowed before the private network and metadata blocks
+func restrictedRules(cfg *config.NetworkConfig, addrs ContainerAddresses, exceptions []AllowEntry) []Rule {
+	rules := gatewayRules(addrs)
+
+	if cfg.BlockMetadataEndpoint {
+		rules = append(rules, Rule{Priority: priorityMetadataIPv6, Destination: metadataIPv6, Action: RuleReject})
 	}
 
-	// Remove rules that match this container's IP
-	for _, rule := range rules {
-		if strings.Contains(rule, f.containerIP) {
-			if err := f.removeRule(rule); err != nil {
-				log.Printf("Warning: failed to remove firewall rule: %v", err)
+	if cfg.AllowLocalNetworkAccess {
+		// Allow all private networks when local network access is enabled
+		for _, cidr := range privateNetworks {
+			rules = append(rules, Rule{Priority: priorityAllow, Destination: cidr, Action: RuleAccept})
+		}
+	} else {
+		// Exceptions (e.g., a staging database) are matched before the blocks below
+		rules = append(rules, entryRules(exceptions)...)
+
+		if cfg.BlockPrivateNetworks {
+			for _, cidr := range privateNetworks {
+				rules = append(rules, Rule{Priority: priorityBlock, Destination: cidr, Action: RuleReject})
 			}
 		}
 	}
 
-	return nil
-}
-
-// EnsureBaseRules adds the base rules needed for container networking
-// These rules allow return traffic and must be in place before container-specific rules
-func EnsureBaseRules() error {
-	// Add conntrack rule for return traffic via firewalld direct rules
-	// Priority -1 ensures this runs before all other rules (including our container rules at 0+)
-	cmd := exec.Command("sudo", "-n", "firewall-cmd", "--direct", "--add-rule",
-		"ipv4", "filter", "FORWARD", "-1",
-		"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		// Rule might already exist, that's OK
-		if !strings.Contains(string(output), "ALREADY_ENABLED") {
-			log.Printf("Warning: failed to add conntrack rule via firewalld: %s", strings.TrimSpace(string(output)))
+	if cfg.BlockMetadataEndpoint {
+		for _, cidr := range linkLocalNetworks {
+			rules = append(rules, Rule{Priority: priorityBlock, Destination: cidr, Action: RuleReject})
 		}
 	}
 
-	return nil
+	// Explicitly allow all other traffic (internet)
+	// Needed because the FORWARD chain policy might be DROP
+	for _, cidr := range anyNetworks {
+		rules = append(rules, Rule{Priority: priorityDefaultAllow, Destination: cidr, Action: RuleAccept})
+	}
+
+	return rules
 }
 
-// EnsureOpenModeRules adds rules to allow all traffic for a container in open mode
-// This is needed because FORWARD chain policy may be DROP
-func EnsureOpenModeRules(containerIP string) error {
-	// Ensure base conntrack rule exists
-	if err := EnsureBaseRules(); err != nil {
-		log.Printf("Warning: failed to ensure base rules: %v", err)
-	}
+// allowlistRules returns the rules for allowlist mode
+func allowlistRules(cfg *config.NetworkConfig, addrs ContainerAddresses, allowed []AllowEntry) []Rule {
+	// DNS works through the bridge's dnsmasq - no public DNS servers allowed
+	// to prevent DNS exfiltration attacks
+	rules := gatewayRules(addrs)
 
-	// Add ACCEPT rule for all traffic from this container
-	cmd := exec.Command("sudo", "-n", "firewall-cmd", "--direct", "--add-rule",
-		"ipv4", "filter", "FORWARD", "0",
-		"-s", containerIP, "-j", "ACCEPT")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		if !strings.Contains(string(output), "ALREADY_ENABLED") {
-			return fmt.Errorf("failed to add open mode rule: %s: %w", strings.TrimSpace(string(output)), err)
+	if cfg.AllowLocalNetworkAccess {
+		for _, cidr := range privateNetworks {
+			rules = append(rules, Rule{Priority: priorityAllow, Destination: cidr, Action: RuleAccept})
 		}
 	}
 
-	return nil
-}
+	rules = append(rules, entryRules(allowed)...)
 
-// addRule adds a firewall direct rule using firewall-cmd
-func (f *FirewallManager) addRule(priority int, source, destination, action string) error {
-	// firewall-cmd --direct --add-rule ipv4 filter FORWARD <priority> -s <src> -d <dst> -j <action>
-	cmd := exec.Command("sudo", "-n", "firewall-cmd", "--direct", "--add-rule",
-		"ipv4", "filter", "FORWARD", fmt.Sprintf("%d", priority),
-		"-s", source, "-d", destination, "-j", action)
+	// Block private networks and metadata (unless local network access is enabled)
+	// The IPv6 metadata endpoint lies inside fc00::/7
+	if !cfg.AllowLocalNetworkAccess {
+		for _, cidr := range privateNetworks {
+			rules = append(rules, Rule{Priority: priorityBlock, Destination: cidr, Action: RuleReject})
+		}
+		for _, cidr := range linkLocalNetworks {
+			rules = append(rules, Rule{Priority: priorityBlock, Destination: cidr, Action: RuleReject})
+		}
+	}
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("firewall-cmd failed: %s: %w", strings.TrimSpace(string(output)), err)
+	for _, cidr := range anyNetworks {
+		rules = append(rules, Rule{Priority: priorityDefaultDeny, Destination: cidr, Action: RuleReject})
 	}
 
-	return nil
+	return rules
 }
 
-// listDirectRules lists all direct rules in the FORWARD chain
-func (f *FirewallManager) listDirectRules() ([]string, error) {
-	cmd := exec.Command("sudo", "-n", "firewall-cmd", "--direct", "--get-all-rules")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("failed to list rules: %w", err)
+// entryRules returns accept rules for address entries, sorted for deterministic ordering
+func entryRules(entries []AllowEntry) []Rule {
+	sorted := make([]AllowEntry, len(entries))
+	copy(sorted, entries)
+	sortEntries(sorted)
+
+	rules := make([]Rule, 0, len(sorted))
+	for _, e := range sorted {
+		rules = append(rules, e.rule(priorityAllow, RuleAccept))
 	}
+	return rules
+}
 
-	var rules []string
-	for _, line := range strings.Split(string(output), "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" && strings.Contains(line, "FORWARD") {
-			rules = append(rules, line)
+// gatewayRules allows the bridge gateways (host communication and DNS)
+func gatewayRules(addrs ContainerAddresses) []Rule {
+	var rules []Rule
+	for _, gateway := range []string{addrs.GatewayIPv4, addrs.GatewayIPv6} {
+		if gateway != "" {
+			rules = append(rules, Rule{Priority: priorityGateway, Destination: hostCIDR(gateway), Action: RuleAccept})
 		}
 	}
-
-	return rules, nil
+	return rules
 }
 
-// removeRule removes a specific firewall direct rule
-func (f *FirewallManager) removeRule(rule string) error {
-	// Parse rule: "ipv4 filter FORWARD 10 -s 10.47.62.50 -d 10.0.0.0/8 -j REJECT"
-	parts := strings.Fields(rule)
-	if len(parts) < 4 {
-		return fmt.Errorf("invalid rule format: %s", rule)
+// hostCIDR turns a bare IP into a single-host CIDR
+func hostCIDR(ip string) string {
+	if strings.Contains(ip, "/") {
+		return ip
 	}
-
-	// Build remove command
-	args := []string{"-n", "firewall-cmd", "--direct", "--remove-rule"}
-	args = append(args, parts...)
-
-	cmd := exec.Command("sudo", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to remove rule: %s: %w", strings.TrimSpace(string(output)), err)
+	if strings.Contains(ip, ":") {
+		return ip + "/128"
 	}
-
-	return nil
+	return ip + "/32"
 }
 
 // GetContainerIP retrieves the IPv4 address of a container from Incus
@@ -298,46 +310,49 @@ func GetContainerIP(containerName string) (string, error) {
 
 // getContainerIPOnce attempts to get the container IP once without retrying
 func getContainerIPOnce(containerName string) (string, error) {
-	output, err := container.IncusOutput("list", containerName, "--format=json")
+	instance, err := container.GetBackend().GetInstance(containerName)
 	if err != nil {
 		return "", fmt.Errorf("failed to get container info: %w", err)
 	}
 
-	var containers []struct {
-		Name  string `json:"name"`
-		State struct {
-			Network map[string]struct {
-				Addresses []struct {
-					Family  string `json:"family"`
-					Address string `json:"address"`
-				} `json:"addresses"`
-			} `json:"network"`
-		} `json:"state"`
+	// Look for eth0 IPv4 address
+	if ip := instance.IPv4(); ip != "" {
+		return ip, nil
 	}
 
-	if err := json.Unmarshal([]byte(output), &containers); err != nil {
-		return "", fmt.Errorf("failed to parse container info: %w", err)
+	return "", fmt.Errorf("no IPv4 address found for container %s", containerName)
+}
+
+// setNICConfig sets keys on the container's eth0 NIC
+// eth0 usually comes from the profile and needs an override; if the container already
+// has its own eth0 (e.g., a reused persistent container), the keys are set directly
+func setNICConfig(containerName string, keys ...string) error {
+	overrideArgs := append([]string{"config", "device", "override", containerName, "eth0"}, keys...)
+	if err := container.IncusExec(overrideArgs...); err != nil {
+		setArgs := append([]string{"config", "device", "set", containerName, "eth0"}, keys...)
+		return container.IncusExec(setArgs...)
 	}
+	return nil
+}
+
+// GetContainerIPv6 retrieves the global IPv6 address of a container, waiting briefly for SLAAC
+// Returns "" if the container has no IPv6 address
+func GetContainerIPv6(containerName string) string {
+	const maxRetries = 10
+	const retryDelay = time.Second
 
-	for _, c := range containers {
-		if c.Name == containerName {
-			// Look for eth0 IPv4 address
-			if eth0, ok := c.State.Network["eth0"]; ok {
-				for _, addr := range eth0.Addresses {
-					if addr.Family == "inet" {
-						return addr.Address, nil
-					}
-				}
+	for i := 0; i < maxRetries; i++ {
+		instance, err := container.GetBackend().GetInstance(containerName)
+		if err == nil {
+			if ip := instance.IPv6(); ip != "" {
+				return ip
 			}
 		}
-	}
 
-	return "", fmt.Errorf("no IPv4 address found for container %s", containerName)
-}
+		if i < maxRetries-1 {
+			time.Sleep(retryDelay)
+		}
+	}
 
-// FirewallAvailable checks if firewalld is available and running
-func FirewallAvailable() bool {
-	cmd := exec.Command("sudo", "-n", "firewall-cmd", "--state")
-	err := cmd.Run()
-	return err == nil
+	return ""
 }
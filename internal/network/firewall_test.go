@@ -0,0 +1,631 @@
+package network
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+func TestRestrictedRules(t *testing.T) {
+	cfg := &config.NetworkConfig{BlockPrivateNetworks: true, BlockMetadataEndpoint: true}
+
+	got := restrictedRules(cfg, ContainerAddresses{GatewayIPv4: "10.47.62.1"}, nil)
+	want := []Rule{
+		{Priority: priorityGateway, Destination: "10.47.62.1/32", Action: RuleAccept},
+		{Priority: priorityMetadataIPv6, Destination: "fd00:ec2::254/128", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "10.0.0.0/8", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "172.16.0.0/12", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "192.168.0.0/16", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "fc00::/7", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "169.254.0.0/16", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "fe80::/10", Action: RuleReject},
+		{Priority: priorityDefaultAllow, Destination: "0.0.0.0/0", Action: RuleAccept},
+		{Priority: priorityDefaultAllow, Destination: "::/0", Action: RuleAccept},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restrictedRules() = %v, want %v", got, want)
+	}
+
+	// Local network access replaces the private network blocks with allows, no gateway when unknown
+	cfg.AllowLocalNetworkAccess = true
+	cfg.BlockMetadataEndpoint = false
+	got = restrictedRules(cfg, ContainerAddresses{}, nil)
+	want = []Rule{
+		{Priority: priorityAllow, Destination: "10.0.0.0/8", Action: RuleAccept},
+		{Priority: priorityAllow, Destination: "172.16.0.0/12", Action: RuleAccept},
+		{Priority: priorityAllow, Destination: "192.168.0.0/16", Action: RuleAccept},
+		{Priority: priorityAllow, Destination: "fc00::/7", Action: RuleAccept},
+		{Priority: priorityDefaultAllow, Destination: "0.0.0.0/0", Action: RuleAccept},
+		{Priority: priorityDefaultAllow, Destination: "::/0", Action: RuleAccept},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restrictedRules() with local access = %v, want %v", got, want)
+	}
+}
+
+func TestRestrictedRulesExceptions(t *testing.T) {
+	cfg := &config.NetworkConfig{BlockPrivateNetworks: true}
+	exceptions := []AllowEntry{{Host: "10.0.5.12", Protocol: "tcp", Port: 5432}}
+
+	got := restrictedRules(cfg, ContainerAddresses{}, exceptions)
+	want := []Rule{
+		{Priority: priorityAllow, Destination: "10.0.5.12/32", Action: RuleAccept, Protocol: "tcp", Port: 5432},
+		{Priority: priorityBlock, Destination: "10.0.0.0/8", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "172.16.0.0/12", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "192.168.0.0/16", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "fc00::/7", Action: RuleReject},
+		{Priority: priorityDefaultAllow, Destination: "0.0.0.0/0", Action: RuleAccept},
+		{Priority: priorityDefaultAllow, Destination: "::/0", Action: RuleAccept},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restrictedRules() with exceptions = %v, want %v", got, want)
+	}
+
+	// Exceptions are redundant when all local networks are allowed
+	cfg.AllowLocalNetworkAccess = true
+	for _, rule := range restrictedRules(cfg, ContainerAddresses{}, exceptions) {
+		if rule.PortLimited() {
+			t.Errorf("Expected no exception rules with local network access, got %v", rule)
+		}
+	}
+}
+
+func TestAllowlistRules(t *testing.T) {
+	cfg := &config.NetworkConfig{}
+	addrs := ContainerAddresses{GatewayIPv4: "10.47.62.1", GatewayIPv6: "fd42:1::1"}
+
+	got := allowlistRules(cfg, addrs, hostEntries("8.8.8.8", "1.1.1.1", "140.82.112.0/20", "2606:4700::1111"))
+	want := []Rule{
+		{Priority: priorityGateway, Destination: "10.47.62.1/32", Action: RuleAccept},
+		{Priority: priorityGateway, Destination: "fd42:1::1/128", Action: RuleAccept},
+		{Priority: priorityAllow, Destination: "1.1.1.1/32", Action: RuleAccept},
+		{Priority: priorityAllow, Destination: "140.82.112.0/20", Action: RuleAccept},
+		{Priority: priorityAllow, Destination: "2606:4700::1111/128", Action: RuleAccept},
+		{Priority: priorityAllow, Destination: "8.8.8.8/32", Action: RuleAccept},
+		{Priority: priorityBlock, Destination: "10.0.0.0/8", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "172.16.0.0/12", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "192.168.0.0/16", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "fc00::/7", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "169.254.0.0/16", Action: RuleReject},
+		{Priority: priorityBlock, Destination: "fe80::/10", Action: RuleReject},
+		{Priority: priorityDefaultDeny, Destination: "0.0.0.0/0", Action: RuleReject},
+		{Priority: priorityDefaultDeny, Destination: "::/0", Action: RuleReject},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("allowlistRules() = %v, want %v", got, want)
+	}
+}
+
+func TestHostCIDR(t *testing.T) {
+	tests := map[string]string{
+		"8.8.8.8":         "8.8.8.8/32",
+		"2606:4700::1111": "2606:4700::1111/128",
+		"140.82.112.0/20": "140.82.112.0/20",
+		"2001:db8::/32":   "2001:db8::/32",
+	}
+	for ip, want := range tests {
+		if got := hostCIDR(ip); got != want {
+			t.Errorf("hostCIDR(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestContainerAddressesSource(t *testing.T) {
+	addrs := ContainerAddresses{IPv4: "10.47.62.50", IPv6: "fd42:1::50"}
+	if got := addrs.Source(Rule{Destination: "8.8.8.8/32"}); got != "10.47.62.50" {
+		t.Errorf("Source(IPv4 rule) = %q, want 10.47.62.50", got)
+	}
+	if got := addrs.Source(Rule{Destination: "::/0"}); got != "fd42:1::50" {
+		t.Errorf("Source(IPv6 rule) = %q, want fd42:1::50", got)
+	}
+
+	// IPv6 rules are skipped for IPv4-only containers
+	if got := (ContainerAddresses{IPv4: "10.47.62.50"}).Source(Rule{Destination: "::/0"}); got != "" {
+		t.Errorf("Source(IPv6 rule) without IPv6 = %q, want empty", got)
+	}
+}
+
+func TestRuleHasSource(t *testing.T) {
+	tests := []struct {
+		rule string
+		ip   string
+		want bool
+	}{
+		{"ipv4 filter FORWARD 10 -s 10.47.62.50 -d 10.0.0.0/8 -j REJECT", "10.47.62.50", true},
+		{"ipv4 filter FORWARD 10 -s 10.47.62.50/32 -d 10.0.0.0/8 -j REJECT", "10.47.62.50", true},
+		{"ipv4 filter FORWARD 10 -s 10.47.62.50 -d 10.0.0.0/8 -j REJECT", "10.47.62.5", false},
+		{"ipv6 filter FORWARD 10 -s fd42:1::50 -d fc00::/7 -j REJECT", "fd42:1::50", true},
+		{"ipv6 filter FORWARD 10 -s fd42:1::50/128 -d fc00::/7 -j REJECT", "fd42:1::50", true},
+		{"ipv6 filter FORWARD 10 -s fd42:1::50 -d fc00::/7 -j REJECT", "fd42:1::5", false},
+		{"ipv4 filter FORWARD -1 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT", "10.47.62.50", false},
+		{"ipv4 filter FORWARD 10 -s 10.47.62.50 -d 10.0.0.0/8 -j REJECT", "", false},
+	}
+	for _, tt := range tests {
+		if got := ruleHasSource(tt.rule, tt.ip); got != tt.want {
+			t.Errorf("ruleHasSource(%q, %q) = %v, want %v", tt.rule, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestParseNetworkGateways(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		wantIPv4 string
+		wantIPv6 string
+	}{
+		{
+			name: "dual stack",
+			output: `config:
+  ipv4.address: 10.47.62.1/24
+  ipv4.nat: "true"
+  ipv6.address: fd42:1:2:3::1/64
+  ipv6.nat: "true"
+name: incusbr0`,
+			wantIPv4: "10.47.62.1",
+			wantIPv6: "fd42:1:2:3::1",
+		},
+		{
+			name: "IPv6 disabled",
+			output: `config:
+  ipv4.address: 10.47.62.1/24
+  ipv6.address: none`,
+			wantIPv4: "10.47.62.1",
+			wantIPv6: "",
+		},
+		{
+			name: "IPv4 only",
+			output: `config:
+  ipv4.address: 10.47.62.1/24`,
+			wantIPv4: "10.47.62.1",
+			wantIPv6: "",
+		},
+		{
+			name:     "no addresses",
+			output:   "config: {}",
+			wantIPv4: "",
+			wantIPv6: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotIPv4, gotIPv6 := parseNetworkGateways(tt.output)
+			if gotIPv4 != tt.wantIPv4 || gotIPv6 != tt.wantIPv6 {
+				t.Errorf("parseNetworkGateways() = %q, %q, want %q, %q", gotIPv4, gotIPv6, tt.wantIPv4, tt.wantIPv6)
+			}
+		})
+	}
+}
+
+func TestNetworkConfigValue(t *testing.T) {
+	output := `config:
+  ipv6.address: fd42:1:2:3::1/64
+  ipv6.dhcp.stateful: "true"
+  ipv6.nat: "true"`
+	if got := networkConfigValue(output, "ipv6.address"); got != "fd42:1:2:3::1/64" {
+		t.Errorf("networkConfigValue(ipv6.address) = %q", got)
+	}
+	if got := networkConfigValue(output, "ipv6.dhcp.stateful"); got != "true" {
+		t.Errorf("networkConfigValue(ipv6.dhcp.stateful) = %q, want true", got)
+	}
+	if got := networkConfigValue(output, "ipv6.dhcp"); got != "" {
+		t.Errorf("networkConfigValue(ipv6.dhcp) = %q, want empty", got)
+	}
+}
+
+func TestSLAACAddress(t *testing.T) {
+	tests := []struct {
+		subnet  string
+		mac     string
+		want    string
+		wantErr bool
+	}{
+		{"fd42:1:2:3::1/64", "00:16:3e:12:34:56", "fd42:1:2:3:216:3eff:fe12:3456", false},
+		{"fd42:1:2:3::1/64", "02:16:3e:12:34:56", "fd42:1:2:3:16:3eff:fe12:3456", false},
+		{"fd42:1:2::1/48", "00:16:3e:12:34:56", "", true},
+		{"10.47.62.1/24", "00:16:3e:12:34:56", "", true},
+		{"", "00:16:3e:12:34:56", "", true},
+		{"fd42:1:2:3::1/64", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := slaacAddress(tt.subnet, tt.mac)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("slaacAddress(%q, %q) error = %v, wantErr %v", tt.subnet, tt.mac, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("slaacAddress(%q, %q) = %q, want %q", tt.subnet, tt.mac, got, tt.want)
+		}
+	}
+}
+
+func TestResolveFirewallBackendUnknown(t *testing.T) {
+	if _, err := ResolveFirewallBackend("iptables"); err == nil || !strings.Contains(err.Error(), "unknown network backend") {
+		t.Errorf("Expected unknown backend error, got: %v", err)
+	}
+
+	// incus-acl needs no host tooling
+	backend, err := ResolveFirewallBackend(config.NetworkBackendIncusACL)
+	if err != nil || backend != config.NetworkBackendIncusACL {
+		t.Errorf("ResolveFirewallBackend(incus-acl) = %s, %v", backend, err)
+	}
+}
+
+func TestNFTApplyScript(t *testing.T) {
+	cfg := &config.NetworkConfig{}
+	addrs := ContainerAddresses{IPv4: "10.47.62.50", GatewayIPv4: "10.47.62.1"}
+	rules := allowlistRules(cfg, addrs, hostEntries("8.8.8.8", "1.1.1.1"))
+
+	got := nftApplyScript(addrs, rules, false)
+	want := `add chain inet coi ctr_10_47_62_50
+add set inet coi ctr_10_47_62_50_allow { type ipv4_addr; flags interval; auto-merge; }
+add element inet coi ctr_10_47_62_50_allow { 1.1.1.1/32, 8.8.8.8/32 }
+add set inet coi ctr_10_47_62_50_allow6 { type ipv6_addr; flags interval; auto-merge; }
+add set inet coi ctr_10_47_62_50_dyn { type ipv4_addr; flags timeout; }
+add set inet coi ctr_10_47_62_50_dyn6 { type ipv6_addr; flags timeout; }
+add rule inet coi ctr_10_47_62_50 ip daddr 10.47.62.1/32 accept
+add rule inet coi ctr_10_47_62_50 ip daddr @ctr_10_47_62_50_allow accept
+add rule inet coi ctr_10_47_62_50 ip6 daddr @ctr_10_47_62_50_allow6 accept
+add rule inet coi ctr_10_47_62_50 ip daddr @ctr_10_47_62_50_dyn accept
+add rule inet coi ctr_10_47_62_50 ip6 daddr @ctr_10_47_62_50_dyn6 accept
+add rule inet coi ctr_10_47_62_50 ip daddr 10.0.0.0/8 reject
+add rule inet coi ctr_10_47_62_50 ip daddr 172.16.0.0/12 reject
+add rule inet coi ctr_10_47_62_50 ip daddr 192.168.0.0/16 reject
+add rule inet coi ctr_10_47_62_50 ip6 daddr fc00::/7 reject
+add rule inet coi ctr_10_47_62_50 ip daddr 169.254.0.0/16 reject
+add rule inet coi ctr_10_47_62_50 ip6 daddr fe80::/10 reject
+add rule inet coi ctr_10_47_62_50 ip daddr 0.0.0.0/0 reject
+add rule inet coi ctr_10_47_62_50 ip6 daddr ::/0 reject
+add rule inet coi forward ip saddr 10.47.62.50 jump ctr_10_47_62_50
+`
+	if got != want {
+		t.Errorf("nftApplyScript() =\n%s\nwant:\n%s", got, want)
+	}
+
+	// Restricted mode has no allowlisted destinations - the sets stay empty
+	got = nftApplyScript(addrs, restrictedRules(&config.NetworkConfig{BlockPrivateNetworks: true}, addrs, nil), false)
+	if strings.Contains(got, "add element") {
+		t.Errorf("Expected no set elements in restricted mode, got:\n%s", got)
+	}
+
+	// Set rules are matched before the blocks even without allowlisted destinations (DNS proxy)
+	got = nftApplyScript(addrs, allowlistRules(cfg, addrs, nil), false)
+	dynRule := strings.Index(got, "ip daddr @ctr_10_47_62_50_dyn accept")
+	blockRule := strings.Index(got, "ip daddr 10.0.0.0/8 reject")
+	if dynRule < 0 || blockRule < 0 || dynRule > blockRule {
+		t.Errorf("Expected dynamic set rule before the private network blocks, got:\n%s", got)
+	}
+}
+
+func TestNFTApplyScriptPorts(t *testing.T) {
+	addrs := ContainerAddresses{IPv4: "10.47.62.50", IPv6: "fd42:1::50"}
+	allowed := []AllowEntry{
+		{Host: "8.8.8.8"},
+		{Host: "10.0.5.12", Protocol: "tcp", Port: 5432},
+		{Host: "2606:4700::1111", Protocol: "udp", Port: 53},
+	}
+
+	got := nftApplyScript(addrs, allowlistRules(&config.NetworkConfig{}, addrs, allowed), false)
+	for _, line := range []string{
+		"add element inet coi ctr_10_47_62_50_allow { 8.8.8.8/32 }",
+		"add rule inet coi ctr_10_47_62_50 ip daddr 10.0.5.12/32 tcp dport 5432 accept",
+		"add rule inet coi ctr_10_47_62_50 ip6 daddr 2606:4700::1111/128 udp dport 53 accept",
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("Expected nftApplyScript() to contain %q, got:\n%s", line, got)
+		}
+	}
+
+	// Port-limited destinations are chain rules, never set elements
+	if strings.Contains(got, "10.0.5.12/32,") || strings.Contains(got, "{ 10.0.5.12/32") {
+		t.Errorf("Expected port-limited destination outside the sets, got:\n%s", got)
+	}
+	portRule := strings.Index(got, "tcp dport 5432 accept")
+	blockRule := strings.Index(got, "ip daddr 10.0.0.0/8 reject")
+	if portRule > blockRule {
+		t.Errorf("Expected port rule before the private network blocks, got:\n%s", got)
+	}
+}
+
+func TestDirectRuleArgs(t *testing.T) {
+	got := directRuleArgs("10.47.62.50", Rule{Priority: priorityAllow, Destination: "10.0.5.12/32", Action: RuleAccept, Protocol: "tcp", Port: 5432})
+	want := []string{"ipv4", "filter", "FORWARD", "1", "-s", "10.47.62.50", "-d", "10.0.5.12/32", "-p", "tcp", "--dport", "5432", "-j", "ACCEPT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("directRuleArgs() = %v, want %v", got, want)
+	}
+
+	got = directRuleArgs("fd42:1::50", Rule{Priority: priorityBlock, Destination: "fc00::/7", Action: RuleReject})
+	want = []string{"ipv6", "filter", "FORWARD", "10", "-s", "fd42:1::50", "-d", "fc00::/7", "-j", "REJECT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("directRuleArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestDirectLogRuleArgs(t *testing.T) {
+	got := directLogRuleArgs("10.47.62.50", Rule{Priority: priorityAllow, Destination: "10.0.5.12/32", Action: RuleAccept, Protocol: "tcp", Port: 5432})
+	want := []string{"ipv4", "filter", "FORWARD", "0", "-s", "10.47.62.50", "-d", "10.0.5.12/32", "-p", "tcp", "--dport", "5432",
+		"-m", "conntrack", "--ctstate", "NEW", "-m", "limit", "--limit", "20/sec", "--limit-burst", "40",
+		"-j", "LOG", "--log-prefix", "coi:1:A:"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("directLogRuleArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestNFTApplyScriptLogging(t *testing.T) {
+	addrs := ContainerAddresses{IPv4: "10.47.62.50", GatewayIPv4: "10.47.62.1"}
+	allowed := []AllowEntry{{Host: "8.8.8.8"}, {Host: "10.0.5.12", Protocol: "tcp", Port: 5432}}
+
+	got := nftApplyScript(addrs, allowlistRules(&config.NetworkConfig{}, addrs, allowed), true)
+	for _, line := range []string{
+		`add rule inet coi ctr_10_47_62_50 ip daddr @ctr_10_47_62_50_allow limit rate 20/second burst 40 packets log prefix "coi:1:A:"`,
+		`add rule inet coi ctr_10_47_62_50 ip daddr 10.0.5.12/32 tcp dport 5432 limit rate 20/second burst 40 packets log prefix "coi:1:A:"`,
+		`add rule inet coi ctr_10_47_62_50 ip daddr 0.0.0.0/0 limit rate 20/second burst 40 packets log prefix "coi:99:R:"`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("Expected nftApplyScript() to contain %q, got:\n%s", line, got)
+		}
+	}
+	if strings.Contains(got, "10.47.62.1/32 limit") {
+		t.Errorf("Expected gateway traffic not to be logged, got:\n%s", got)
+	}
+	if strings.Contains(nftApplyScript(addrs, allowlistRules(&config.NetworkConfig{}, addrs, allowed), false), " log ") {
+		t.Error("Expected no log rules without logging")
+	}
+}
+
+func TestNFTDynamicScripts(t *testing.T) {
+	if got := nftDynamicElementScript("add", "10.47.62.50", "8.8.8.8"); got != "add element inet coi ctr_10_47_62_50_dyn { 8.8.8.8 }\n" {
+		t.Errorf("nftDynamicElementScript(IPv4) = %q", got)
+	}
+	if got := nftDynamicElementScript("delete", "10.47.62.50", "2606:4700::1111"); got != "delete element inet coi ctr_10_47_62_50_dyn6 { 2606:4700::1111 }\n" {
+		t.Errorf("nftDynamicElementScript(IPv6) = %q", got)
+	}
+	wantAllow := `add element inet coi ctr_10_47_62_50_dyn { 8.8.8.8 timeout 91s }
+delete element inet coi ctr_10_47_62_50_dyn { 8.8.8.8 }
+add element inet coi ctr_10_47_62_50_dyn { 8.8.8.8 timeout 91s }
+`
+	if got := nftAllowElementScript("10.47.62.50", "8.8.8.8", 90*time.Second+time.Millisecond); got != wantAllow {
+		t.Errorf("nftAllowElementScript() = %q, want %q", got, wantAllow)
+	}
+
+	got := nftRedirectScript(ContainerAddresses{IPv4: "10.47.62.50", GatewayIPv4: "10.47.62.1"}, []PortRedirect{
+		{Protocols: []string{"udp", "tcp"}, Port: 53, ToPort: 40053},
+		{Protocols: []string{"tcp"}, Port: 443, ToPort: 40443},
+	})
+	want := `add chain inet coi prerouting { type nat hook prerouting priority dstnat; policy accept; }
+add chain inet coi ctr_10_47_62_50_nat
+add rule inet coi ctr_10_47_62_50_nat meta l4proto { udp, tcp } th dport 53 dnat ip to 10.47.62.1:40053
+add rule inet coi ctr_10_47_62_50_nat meta l4proto { tcp } th dport 443 dnat ip to 10.47.62.1:40443
+add rule inet coi prerouting ip saddr 10.47.62.50 jump ctr_10_47_62_50_nat
+`
+	if got != want {
+		t.Errorf("nftRedirectScript() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNFTApplyScriptIPv6(t *testing.T) {
+	addrs := ContainerAddresses{IPv4: "10.47.62.50", IPv6: "fd42:1::50", GatewayIPv4: "10.47.62.1", GatewayIPv6: "fd42:1::1"}
+	rules := allowlistRules(&config.NetworkConfig{}, addrs, hostEntries("8.8.8.8", "2606:4700::1111"))
+
+	got := nftApplyScript(addrs, rules, false)
+	for _, line := range []string{
+		"add element inet coi ctr_10_47_62_50_allow { 8.8.8.8/32 }",
+		"add element inet coi ctr_10_47_62_50_allow6 { 2606:4700::1111/128 }",
+		"add rule inet coi ctr_10_47_62_50 ip6 daddr fd42:1::1/128 accept",
+		"add rule inet coi forward ip saddr 10.47.62.50 jump ctr_10_47_62_50",
+		"add rule inet coi forward ip6 saddr fd42:1::50 jump ctr_10_47_62_50",
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("Expected nftApplyScript() to contain %q, got:\n%s", line, got)
+		}
+	}
+}
+
+func TestNFTJumpHandles(t *testing.T) {
+	listing := `table inet coi {
+	chain forward { # handle 1
+		type filter hook forward priority filter; policy accept;
+		ct state established,related accept # handle 2
+		ip saddr 10.47.62.50 jump ctr_10_47_62_50 # handle 7
+		ip saddr 10.47.62.5 jump ctr_10_47_62_5 # handle 9
+	}
+}`
+
+	got := nftJumpHandles(listing, "ctr_10_47_62_5")
+	if !reflect.DeepEqual(got, []string{"9"}) {
+		t.Errorf("nftJumpHandles() = %v, want [9]", got)
+	}
+
+	if got := nftJumpHandles(listing, "ctr_10_0_0_1"); len(got) != 0 {
+		t.Errorf("Expected no handles for unknown chain, got %v", got)
+	}
+}
+
+func TestFlattenRulesRestricted(t *testing.T) {
+	cfg := &config.NetworkConfig{BlockPrivateNetworks: true, BlockMetadataEndpoint: true}
+
+	accept, reject, _, defaultAction, err := flattenRules(restrictedRules(cfg, ContainerAddresses{GatewayIPv4: "10.47.62.1", GatewayIPv6: "fd42:1::1"}, nil))
+	if err != nil {
+		t.Fatalf("flattenRules() failed: %v", err)
+	}
+
+	if defaultAction != RuleAccept {
+		t.Errorf("Expected default accept, got %s", defaultAction)
+	}
+	if !reflect.DeepEqual(accept, []string{"10.47.62.1/32", "fd42:1::1/128"}) {
+		t.Errorf("Expected gateway accepts, got %v", accept)
+	}
+
+	// The gateways are carved out of the private networks so they are not rejected
+	rejected := parseCIDRs(t, reject)
+	if containsIP(rejected, "10.47.62.1") || containsIP(rejected, "fd42:1::1") {
+		t.Error("Gateways must not be rejected")
+	}
+	for _, ip := range []string{"10.0.0.1", "10.47.62.2", "10.255.255.255", "172.16.5.5", "192.168.1.1", "169.254.169.254",
+		"fd42:1::2", "fd00:ec2::254", "fe80::1"} {
+		if !containsIP(rejected, ip) {
+			t.Errorf("Expected %s to be rejected", ip)
+		}
+	}
+	if containsIP(rejected, "8.8.8.8") || containsIP(rejected, "2001:4860:4860::8888") {
+		t.Error("Public addresses must not be rejected")
+	}
+}
+
+func TestFlattenRulesAllowlist(t *testing.T) {
+	accept, reject, _, defaultAction, err := flattenRules(allowlistRules(&config.NetworkConfig{}, ContainerAddresses{}, hostEntries("8.8.8.8", "10.1.2.3", "2606:4700::1111")))
+	if err != nil {
+		t.Fatalf("flattenRules() failed: %v", err)
+	}
+
+	if defaultAction != RuleReject {
+		t.Errorf("Expected default reject, got %s", defaultAction)
+	}
+	if !reflect.DeepEqual(accept, []string{"10.1.2.3/32", "2606:4700::1111/128", "8.8.8.8/32"}) {
+		t.Errorf("Unexpected accepts: %v", accept)
+	}
+
+	// An allowlisted private address wins over the RFC1918 block, like with firewalld priorities
+	if containsIP(parseCIDRs(t, reject), "10.1.2.3") {
+		t.Error("Allowlisted address must not be rejected")
+	}
+}
+
+func TestFlattenRulesPorts(t *testing.T) {
+	allowed := []AllowEntry{{Host: "8.8.8.8"}, {Host: "10.0.5.12", Protocol: "tcp", Port: 5432}}
+
+	// Allowlist mode: the port-limited address is carved out of the RFC1918 reject and allowed on its port
+	accept, reject, ports, defaultAction, err := flattenRules(allowlistRules(&config.NetworkConfig{}, ContainerAddresses{}, allowed))
+	if err != nil {
+		t.Fatalf("flattenRules() failed: %v", err)
+	}
+	if defaultAction != RuleReject || !reflect.DeepEqual(accept, []string{"8.8.8.8/32"}) {
+		t.Errorf("flattenRules() = %v, %s, want [8.8.8.8/32], REJECT", accept, defaultAction)
+	}
+	wantPorts := []Rule{{Priority: priorityAllow, Destination: "10.0.5.12/32", Action: RuleAccept, Protocol: "tcp", Port: 5432}}
+	if !reflect.DeepEqual(ports, wantPorts) {
+		t.Errorf("flattenRules() ports = %v, want %v", ports, wantPorts)
+	}
+	if containsIP(parseCIDRs(t, reject), "10.0.5.12") {
+		t.Error("Port-limited address must not be rejected")
+	}
+
+	// Restricted mode: carving would open every port with the accept default
+	cfg := &config.NetworkConfig{BlockPrivateNetworks: true}
+	if _, _, _, _, err := flattenRules(restrictedRules(cfg, ContainerAddresses{}, allowed[1:])); err == nil {
+		t.Error("Expected error for a port-limited exception inside a blocked range")
+	}
+
+	// Outside blocked ranges the exception is redundant
+	_, _, ports, _, err = flattenRules(restrictedRules(cfg, ContainerAddresses{}, []AllowEntry{{Host: "8.8.4.4", Protocol: "tcp", Port: 443}}))
+	if err != nil || len(ports) != 0 {
+		t.Errorf("flattenRules() = %v, %v, want no port rules", ports, err)
+	}
+}
+
+func TestFlattenRulesMismatchedDefaults(t *testing.T) {
+	rules := []Rule{
+		{Priority: priorityDefaultAllow, Destination: "0.0.0.0/0", Action: RuleAccept},
+		{Priority: priorityDefaultDeny, Destination: "::/0", Action: RuleReject},
+	}
+	if _, _, _, _, err := flattenRules(rules); err == nil {
+		t.Error("Expected error for differing IPv4 and IPv6 default actions")
+	}
+
+	// A family's catch-all does not hide the other family's rules
+	rules = []Rule{
+		{Priority: priorityBlock, Destination: "0.0.0.0/0", Action: RuleReject},
+		{Priority: priorityDefaultDeny, Destination: "fc00::/7", Action: RuleReject},
+		{Priority: priorityDefaultDeny, Destination: "::/0", Action: RuleReject},
+	}
+	_, reject, _, defaultAction, err := flattenRules(rules)
+	if err != nil {
+		t.Fatalf("flattenRules() failed: %v", err)
+	}
+	if defaultAction != RuleReject || !reflect.DeepEqual(reject, []string{"fc00::/7"}) {
+		t.Errorf("flattenRules() = %v, %s, want [fc00::/7], REJECT", reject, defaultAction)
+	}
+}
+
+func TestSubtractCIDRs(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/30")
+	_, ex, _ := net.ParseCIDR("10.0.0.1/32")
+
+	var got []string
+	for _, part := range subtractCIDRs(n, []*net.IPNet{ex}) {
+		got = append(got, part.String())
+	}
+
+	want := []string{"10.0.0.0/32", "10.0.0.2/31"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("subtractCIDRs() = %v, want %v", got, want)
+	}
+
+	// Fully covered networks disappear, disjoint ones are unchanged
+	_, covering, _ := net.ParseCIDR("10.0.0.0/8")
+	if parts := subtractCIDRs(n, []*net.IPNet{covering}); len(parts) != 0 {
+		t.Errorf("Expected nothing left, got %v", parts)
+	}
+	_, other, _ := net.ParseCIDR("192.168.0.0/16")
+	if parts := subtractCIDRs(n, []*net.IPNet{other}); len(parts) != 1 || parts[0].String() != "10.0.0.0/30" {
+		t.Errorf("Expected network unchanged, got %v", parts)
+	}
+}
+
+func TestACLRuleArgs(t *testing.T) {
+	got := aclRuleArgs([]string{"1.1.1.1/32", "8.8.8.8/32"}, []string{"10.0.0.0/8"}, nil)
+	want := [][]string{
+		{"action=reject", "destination=10.0.0.0/8"},
+		{"action=allow", "destination=1.1.1.1/32,8.8.8.8/32"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aclRuleArgs() = %v, want %v", got, want)
+	}
+
+	got = aclRuleArgs(nil, nil, []Rule{{Destination: "10.0.5.12/32", Action: RuleAccept, Protocol: "tcp", Port: 5432}})
+	want = [][]string{{"action=allow", "destination=10.0.5.12/32", "protocol=tcp", "destination_port=5432"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aclRuleArgs() with ports = %v, want %v", got, want)
+	}
+
+	if got := aclRuleArgs(nil, nil, nil); len(got) != 0 {
+		t.Errorf("Expected no rules, got %v", got)
+	}
+}
+
+// hostEntries returns allowlist entries for addresses without port limits
+func hostEntries(hosts ...string) []AllowEntry {
+	entries := make([]AllowEntry, 0, len(hosts))
+	for _, host := range hosts {
+		entries = append(entries, AllowEntry{Host: host})
+	}
+	return entries
+}
+
+func parseCIDRs(t *testing.T, cidrs []string) []*net.IPNet {
+	t.Helper()
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("Invalid CIDR %s: %v", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,359 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// FirewalldBackend manages firewalld direct rules for container network isolation
+// IPv4 rules go to the ipv4 (iptables) table and IPv6 rules to the ipv6 (ip6tables) table
+type FirewalldBackend struct {
+	addrs   ContainerAddresses
+	logging bool
+}
+
+// NewFirewalldBackend creates a firewalld backend for a container
+func NewFirewalldBackend(addrs ContainerAddresses) *FirewalldBackend {
+	return &FirewalldBackend{addrs: addrs}
+}
+
+func (f *FirewalldBackend) Name() string {
+	return string(config.NetworkBackendFirewalld)
+}
+
+// EnsureBaseRules adds the conntrack rule for return traffic
+func (f *FirewalldBackend) EnsureBaseRules() error {
+	return EnsureBaseRules()
+}
+
+// ApplyOpen adds an ACCEPT rule for all traffic from the container
+func (f *FirewalldBackend) ApplyOpen() error {
+	for _, ip := range []string{f.addrs.IPv4, f.addrs.IPv6} {
+		if ip == "" {
+			continue
+		}
+		if err := EnsureOpenModeRules(ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyRestricted applies restricted mode rules (block private networks, allow internet)
+func (f *FirewalldBackend) ApplyRestricted(cfg *config.NetworkConfig, exceptions []AllowEntry) error {
+	return f.applyRules(restrictedRules(cfg, f.addrs, exceptions))
+}
+
+// ApplyAllowlist applies allowlist mode rules (allow specific IPs, block all else)
+func (f *FirewalldBackend) ApplyAllowlist(cfg *config.NetworkConfig, allowed []AllowEntry) error {
+	return f.applyRules(allowlistRules(cfg, f.addrs, allowed))
+}
+
+// SetLogging adds a LOG rule in front of each rule applied afterwards
+func (f *FirewalldBackend) SetLogging(enabled bool) error {
+	f.logging = enabled
+	return nil
+}
+
+// applyRules adds one direct rule per rule, using the rule priority as the firewalld priority
+func (f *FirewalldBackend) applyRules(rules []Rule) error {
+	// Ensure base rules for return traffic are in place
+	if err := EnsureBaseRules(); err != nil {
+		log.Printf("Warning: failed to ensure base rules: %v", err)
+	}
+
+	for _, rule := range rules {
+		// Rules for an address family the container has no address in are skipped
+		source := f.addrs.Source(rule)
+		if source == "" {
+			continue
+		}
+		if err := f.addLogRule(source, rule); err != nil {
+			return fmt.Errorf("failed to add LOG rule for %s: %w", rule.Destination, err)
+		}
+		if err := f.addRule(source, rule); err != nil {
+			return fmt.Errorf("failed to add %s rule for %s: %w", rule.Action, rule.Destination, err)
+		}
+	}
+
+	return nil
+}
+
+// AllowDestination adds an ACCEPT rule for a single destination at the allowlist priority
+// Direct rules cannot time out, so ttl is ignored: the rule stays until it is revoked or the
+// container's rules are removed
+func (f *FirewalldBackend) AllowDestination(ip string, ttl time.Duration) error {
+	rule := Rule{Priority: priorityAllow, Destination: hostCIDR(ip), Action: RuleAccept}
+	source := f.addrs.Source(rule)
+	if source == "" {
+		return nil
+	}
+	// Allowing again (renewing) finds the rules in place
+	if err := f.addLogRule(source, rule); err != nil && !strings.Contains(err.Error(), "ALREADY_ENABLED") {
+		return fmt.Errorf("failed to allow %s: %w", ip, err)
+	}
+	if err := f.addRule(source, rule); err != nil && !strings.Contains(err.Error(), "ALREADY_ENABLED") {
+		return fmt.Errorf("failed to allow %s: %w", ip, err)
+	}
+	return nil
+}
+
+// RevokeDestination removes the ACCEPT rule added by AllowDestination
+func (f *FirewalldBackend) RevokeDestination(ip string) error {
+	rule := Rule{Priority: priorityAllow, Destination: hostCIDR(ip), Action: RuleAccept}
+	source := f.addrs.Source(rule)
+	if source == "" {
+		return nil
+	}
+	if f.logging {
+		if err := f.removeRule(strings.Join(directLogRuleArgs(source, rule), " ")); err != nil {
+			log.Printf("Warning: failed to remove LOG rule for %s: %v", ip, err)
+		}
+	}
+	return f.removeRule(fmt.Sprintf("%s filter FORWARD %d -s %s -d %s -j %s",
+		firewalldFamily(source), rule.Priority, source, rule.Destination, rule.Action))
+}
+
+// Redirect adds nat PREROUTING rules sending the container's traffic to gateway ports
+func (f *FirewalldBackend) Redirect(redirects []PortRedirect) error {
+	if f.addrs.IPv4 == "" || f.addrs.GatewayIPv4 == "" {
+		return fmt.Errorf("redirects require the container and gateway IPv4 addresses")
+	}
+
+	for _, redirect := range redirects {
+		for _, proto := range redirect.Protocols {
+			cmd := exec.Command("sudo", "-n", "firewall-cmd", "--direct", "--add-rule",
+				"ipv4", "nat", "PREROUTING", "0",
+				"-s", f.addrs.IPv4, "-p", proto, "--dport", fmt.Sprintf("%d", redirect.Port),
+				"-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%d", f.addrs.GatewayIPv4, redirect.ToPort))
+			output, err := cmd.CombinedOutput()
+			if err != nil && !strings.Contains(string(output), "ALREADY_ENABLED") {
+				return fmt.Errorf("failed to add redirect rule for %s/%d: %s: %w", proto, redirect.Port, strings.TrimSpace(string(output)), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RemoveRules removes all firewall rules for this container's IPs
+func (f *FirewalldBackend) RemoveRules() error {
+	if f.addrs.IPv4 == "" && f.addrs.IPv6 == "" {
+		return nil
+	}
+
+	// List all direct rules
+	rules, err := f.listDirectRules()
+	if err != nil {
+		return fmt.Errorf("failed to list firewall rules: %w", err)
+	}
+
+	// Remove rules that match this container's IPs
+	for _, rule := range rules {
+		if ruleHasSource(rule, f.addrs.IPv4) || ruleHasSource(rule, f.addrs.IPv6) {
+			if err := f.removeRule(rule); err != nil {
+				log.Printf("Warning: failed to remove firewall rule: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ListRules returns the direct rules matching this container's IPs
+func (f *FirewalldBackend) ListRules() ([]string, error) {
+	rules, err := f.listDirectRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firewall rules: %w", err)
+	}
+
+	var result []string
+	for _, rule := range rules {
+		if ruleHasSource(rule, f.addrs.IPv4) || ruleHasSource(rule, f.addrs.IPv6) {
+			result = append(result, rule)
+		}
+	}
+	return result, nil
+}
+
+// EnsureBaseRules adds the base rules needed for container networking
+// These rules allow return traffic and must be in place before container-specific rules
+func EnsureBaseRules() error {
+	// Add conntrack rule for return traffic via firewalld direct rules (both address families)
+	// Priority -1 ensures this runs before all other rules (including our container rules at 0+)
+	for _, family := range []string{"ipv4", "ipv6"} {
+		cmd := exec.Command("sudo", "-n", "firewall-cmd", "--direct", "--add-rule",
+			family, "filter", "FORWARD", "-1",
+			"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			// Rule might already exist, that's OK
+			if !strings.Contains(string(output), "ALREADY_ENABLED") {
+				log.Printf("Warning: failed to add %s conntrack rule via firewalld: %s", family, strings.TrimSpace(string(output)))
+			}
+		}
+	}
+
+	return nil
+}
+
+// EnsureOpenModeRules adds rules to allow all traffic for a container in open mode
+// This is needed because FORWARD chain policy may be DROP
+func EnsureOpenModeRules(containerIP string) error {
+	// Ensure base conntrack rule exists
+	if err := EnsureBaseRules(); err != nil {
+		log.Printf("Warning: failed to ensure base rules: %v", err)
+	}
+
+	// Add ACCEPT rule for all traffic from this container
+	cmd := exec.Command("sudo", "-n", "firewall-cmd", "--direct", "--add-rule",
+		firewalldFamily(containerIP), "filter", "FORWARD", "0",
+		"-s", containerIP, "-j", "ACCEPT")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if !strings.Contains(string(output), "ALREADY_ENABLED") {
+			return fmt.Errorf("failed to add open mode rule: %s: %w", strings.TrimSpace(string(output)), err)
+		}
+	}
+
+	return nil
+}
+
+// addRule adds a firewall direct rule using firewall-cmd
+func (f *FirewalldBackend) addRule(source string, rule Rule) error {
+	cmd := exec.Command("sudo", append([]string{"-n", "firewall-cmd", "--direct", "--add-rule"}, directRuleArgs(source, rule)...)...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("firewall-cmd failed: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+
+	return nil
+}
+
+// addLogRule adds the LOG rule of a rule when logging is enabled
+func (f *FirewalldBackend) addLogRule(source string, rule Rule) error {
+	if !f.logging || !loggedRule(rule) {
+		return nil
+	}
+	cmd := exec.Command("sudo", append([]string{"-n", "firewall-cmd", "--direct", "--add-rule"}, directLogRuleArgs(source, rule)...)...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("firewall-cmd failed: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+
+	return nil
+}
+
+// directLogRuleArgs returns the LOG rule for a rule from source, at the priority before it
+// (direct rules of equal priority have no fixed order); only new connections are logged, rate limited
+func directLogRuleArgs(source string, rule Rule) []string {
+	args := directRuleArgs(source, rule)
+	args[3] = fmt.Sprintf("%d", rule.Priority-1)
+	args = args[:len(args)-2] // Drop -j <action>
+	return append(args, "-m", "conntrack", "--ctstate", "NEW",
+		"-m", "limit", "--limit", logRateLimit+"/sec", "--limit-burst", logRateBurst,
+		"-j", "LOG", "--log-prefix", logPrefix(rule))
+}
+
+// directRuleArgs returns the direct rule for a rule from source:
+// <family> filter FORWARD <priority> -s <src> -d <dst> [-p <proto> --dport <port>] -j <action>
+func directRuleArgs(source string, rule Rule) []string {
+	args := []string{firewalldFamily(source), "filter", "FORWARD", fmt.Sprintf("%d", rule.Priority),
+		"-s", source, "-d", rule.Destination}
+	if rule.PortLimited() {
+		args = append(args, "-p", rule.Protocol, "--dport", fmt.Sprintf("%d", rule.Port))
+	}
+	return append(args, "-j", string(rule.Action))
+}
+
+// listDirectRules lists all direct rules in the FORWARD chain and the nat PREROUTING chain (redirects)
+func (f *FirewalldBackend) listDirectRules() ([]string, error) {
+	cmd := exec.Command("sudo", "-n", "firewall-cmd", "--direct", "--get-all-rules")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	var rules []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && (strings.Contains(line, "FORWARD") || strings.Contains(line, "PREROUTING")) {
+			rules = append(rules, line)
+		}
+	}
+
+	return rules, nil
+}
+
+// removeRule removes a specific firewall direct rule
+func (f *FirewalldBackend) removeRule(rule string) error {
+	// Parse rule: "ipv4 filter FORWARD 10 -s 10.47.62.50 -d 10.0.0.0/8 -j REJECT"
+	parts := strings.Fields(rule)
+	if len(parts) < 4 {
+		return fmt.Errorf("invalid rule format: %s", rule)
+	}
+
+	// Build remove command
+	args := []string{"-n", "firewall-cmd", "--direct", "--remove-rule"}
+	args = append(args, parts...)
+
+	cmd := exec.Command("sudo", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remove rule: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+
+	return nil
+}
+
+// firewalldFamily returns the direct rule family for an address ("ipv4" or "ipv6")
+func firewalldFamily(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// ruleHasSource returns true if a direct rule matches traffic from ip (-s <ip>)
+// Compares whole fields so 10.0.0.5 does not match rules for 10.0.0.50
+func ruleHasSource(rule, ip string) bool {
+	if ip == "" {
+		return false
+	}
+	fields := strings.Fields(rule)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] != "-s" {
+			continue
+		}
+		source := strings.TrimSuffix(strings.TrimSuffix(fields[i+1], "/32"), "/128")
+		if source == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleSource returns the source address of a direct rule (-s <ip>), "" if it has none
+func ruleSource(rule string) string {
+	fields := strings.Fields(rule)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "-s" {
+			return strings.TrimSuffix(strings.TrimSuffix(fields[i+1], "/32"), "/128")
+		}
+	}
+	return ""
+}
+
+// FirewallAvailable checks if firewalld is available and running
+func FirewallAvailable() bool {
+	cmd := exec.Command("sudo", "-n", "firewall-cmd", "--state")
+	err := cmd.Run()
+	return err == nil
+}
@@ -0,0 +1,143 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+// ActiveInstances describes the instances that exist, so state left behind for anything else
+// (by sessions that crashed before Teardown) can be removed
+type ActiveInstances struct {
+	Names     map[string]bool // Names of all instances
+	Addresses map[string]bool // IPv4 and IPv6 addresses of running instances
+	ACLPrefix string          // Only ACLs whose name starts with this prefix belong to coi
+}
+
+// CollectGarbage removes firewall rules for container addresses that are no longer in use,
+// ACLs of deleted containers, and policies and IP caches whose session or container is gone
+// Returns a description of each removed (with dryRun, removable) item
+func CollectGarbage(cacheManager *CacheManager, active ActiveInstances, dryRun bool) ([]string, error) {
+	var removed []string
+	var errs []error
+
+	remove := func(description string, fn func() error) {
+		if !dryRun {
+			if err := fn(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", description, err))
+				return
+			}
+		}
+		removed = append(removed, description)
+	}
+
+	// firewalld direct rules from addresses no running instance has
+	if FirewallAvailable() {
+		firewalld := NewFirewalldBackend(ContainerAddresses{})
+		rules, err := firewalld.listDirectRules()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, rule := range orphanedDirectRules(rules, active.Addresses) {
+			remove("firewalld rule: "+rule, func() error { return firewalld.removeRule(rule) })
+		}
+	}
+
+	// nftables chains (named after the container's IPv4 address) in the coi table
+	if NFTablesAvailable() {
+		if listing, err := nftOutput("list", "chains", "inet", "coi"); err == nil {
+			for _, ip := range orphanedNFTChains(listing, active.Addresses) {
+				backend := NewNFTablesBackend(ContainerAddresses{IPv4: ip})
+				remove("nftables chain "+nftChainName(ip), backend.RemoveRules)
+			}
+		}
+	}
+
+	// Incus ACLs are named after their container
+	if active.ACLPrefix != "" {
+		if output, err := container.IncusOutput("network", "acl", "list", "--format", "csv"); err == nil {
+			for _, name := range csvFirstColumn(output) {
+				if !strings.HasPrefix(name, active.ACLPrefix) || active.Names[name] {
+					continue
+				}
+				backend := NewIncusACLBackend(name, ContainerAddresses{})
+				remove("network ACL "+name, backend.RemoveRules)
+			}
+		}
+	}
+
+	// Policies of sessions that are no longer running
+	policies, err := cacheManager.ListPolicies()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, policy := range policies {
+		if !policy.SessionRunning() {
+			name := policy.Container
+			remove("policy of "+name, func() error { return cacheManager.DeletePolicy(name) })
+		}
+	}
+
+	// IP caches of deleted containers
+	caches, err := filepath.Glob(filepath.Join(cacheManager.cacheDir, "*.json"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, path := range caches {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if strings.HasSuffix(name, ".policy") || strings.HasSuffix(name, ".update") || active.Names[name] {
+			continue
+		}
+		remove("IP cache of "+name, func() error { return os.Remove(path) })
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// orphanedDirectRules returns the direct rules whose source (-s) is not an active address
+// Rules without a source (the shared conntrack rules) are kept
+func orphanedDirectRules(rules []string, active map[string]bool) []string {
+	var orphaned []string
+	for _, rule := range rules {
+		if source := ruleSource(rule); source != "" && !active[source] {
+			orphaned = append(orphaned, rule)
+		}
+	}
+	return orphaned
+}
+
+// nftContainerChainPattern matches per-container chains in `nft list chains` output
+var nftContainerChainPattern = regexp.MustCompile(`^chain ctr_(\d+)_(\d+)_(\d+)_(\d+) \{`)
+
+// orphanedNFTChains returns the IPv4 addresses of container chains whose address is not active
+func orphanedNFTChains(listing string, active map[string]bool) []string {
+	var orphaned []string
+	for _, line := range strings.Split(listing, "\n") {
+		matches := nftContainerChainPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if len(matches) != 5 {
+			continue
+		}
+		ip := strings.Join(matches[1:], ".")
+		if !active[ip] {
+			orphaned = append(orphaned, ip)
+		}
+	}
+	return orphaned
+}
+
+// csvFirstColumn returns the first column of each line of CSV output
+func csvFirstColumn(output string) []string {
+	var values []string
+	for _, line := range strings.Split(output, "\n") {
+		value, _, _ := strings.Cut(line, ",")
+		if value = strings.Trim(strings.TrimSpace(value), `"`); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
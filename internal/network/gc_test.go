@@ -0,0 +1,98 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrphanedDirectRules(t *testing.T) {
+	rules := []string{
+		"ipv4 filter FORWARD -1 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		"ipv4 filter FORWARD 0 -s 10.47.62.50 -d 10.47.62.1/32 -j ACCEPT",
+		"ipv4 filter FORWARD 10 -s 10.47.62.51 -d 10.0.0.0/8 -j REJECT",
+		"ipv6 filter FORWARD 10 -s fd42::51 -d fc00::/7 -j REJECT",
+		"ipv4 nat PREROUTING 0 -s 10.47.62.51 -p tcp --dport 80 -j DNAT --to-destination 10.47.62.1:8080",
+	}
+	active := map[string]bool{"10.47.62.50": true}
+
+	want := rules[2:]
+	if got := orphanedDirectRules(rules, active); !reflect.DeepEqual(got, want) {
+		t.Errorf("orphanedDirectRules() = %v, want %v", got, want)
+	}
+}
+
+func TestRuleSource(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"ipv4 filter FORWARD 0 -s 10.0.0.5 -d 8.8.8.8/32 -j ACCEPT", "10.0.0.5"},
+		{"ipv4 filter FORWARD 0 -s 10.0.0.5/32 -j ACCEPT", "10.0.0.5"},
+		{"ipv6 filter FORWARD 0 -s fd42::5/128 -j ACCEPT", "fd42::5"},
+		{"ipv4 filter FORWARD -1 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ruleSource(tt.rule); got != tt.want {
+			t.Errorf("ruleSource(%q) = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestOrphanedNFTChains(t *testing.T) {
+	listing := `table inet coi {
+	chain forward {
+		type filter hook forward priority filter; policy accept;
+	}
+	chain ctr_10_47_62_50 {
+	}
+	chain ctr_10_47_62_51 {
+	}
+	chain ctr_10_47_62_51_nat {
+	}
+	chain prerouting {
+		type nat hook prerouting priority dstnat; policy accept;
+	}
+}
+`
+	active := map[string]bool{"10.47.62.50": true}
+
+	if got := orphanedNFTChains(listing, active); !reflect.DeepEqual(got, []string{"10.47.62.51"}) {
+		t.Errorf("orphanedNFTChains() = %v, want [10.47.62.51]", got)
+	}
+}
+
+func TestCSVFirstColumn(t *testing.T) {
+	output := "coi-abc-1,,1\n\"coi-abc-2\",\"desc, with comma\",0\n\n"
+	if got := csvFirstColumn(output); !reflect.DeepEqual(got, []string{"coi-abc-1", "coi-abc-2"}) {
+		t.Errorf("csvFirstColumn() = %v", got)
+	}
+}
+
+func TestListingLines(t *testing.T) {
+	nft := `table inet coi {
+	set ctr_10_0_0_5_allow {
+		type ipv4_addr
+		elements = { 1.2.3.4 }
+	}
+}
+`
+	want := []string{"set ctr_10_0_0_5_allow {", "type ipv4_addr", "elements = { 1.2.3.4 }"}
+	if got := nftListingLines(nft); !reflect.DeepEqual(got, want) {
+		t.Errorf("nftListingLines() = %q, want %q", got, want)
+	}
+
+	acl := `name: coi-abc-1
+description: ""
+egress:
+- action: reject
+  destination: 10.0.0.0/8
+  state: enabled
+ingress: []
+config: {}
+`
+	want = []string{"- action: reject", "  destination: 10.0.0.0/8", "  state: enabled"}
+	if got := aclEgressLines(acl); !reflect.DeepEqual(got, want) {
+		t.Errorf("aclEgressLines() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,322 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+// IncusACLBackend isolates a container with an Incus network ACL attached to its eth0 NIC
+// Incus evaluates ACL rules by action (reject before allow) rather than by order, so the
+// priority-ordered rule list is flattened into non-overlapping reject and allow ranges
+// plus a default egress action
+// Requires a managed bridge network using the nftables firewall driver
+// The ACL applies to the NIC, so it covers IPv6 even when no container address is known
+type IncusACLBackend struct {
+	containerName string
+	addrs         ContainerAddresses
+}
+
+// NewIncusACLBackend creates an Incus ACL backend for a container
+func NewIncusACLBackend(containerName string, addrs ContainerAddresses) *IncusACLBackend {
+	return &IncusACLBackend{
+		containerName: containerName,
+		addrs:         addrs,
+	}
+}
+
+func (a *IncusACLBackend) Name() string {
+	return string(config.NetworkBackendIncusACL)
+}
+
+// EnsureBaseRules is a no-op: ACLs are stateful, return traffic is always allowed
+func (a *IncusACLBackend) EnsureBaseRules() error {
+	return nil
+}
+
+// ApplyOpen is a no-op: without an ACL the NIC is unrestricted
+func (a *IncusACLBackend) ApplyOpen() error {
+	return nil
+}
+
+// ApplyRestricted applies restricted mode rules (block private networks, allow internet)
+func (a *IncusACLBackend) ApplyRestricted(cfg *config.NetworkConfig, exceptions []AllowEntry) error {
+	return a.applyRules(restrictedRules(cfg, a.addrs, exceptions))
+}
+
+// ApplyAllowlist applies allowlist mode rules (allow specific IPs, block all else)
+func (a *IncusACLBackend) ApplyAllowlist(cfg *config.NetworkConfig, allowed []AllowEntry) error {
+	return a.applyRules(allowlistRules(cfg, a.addrs, allowed))
+}
+
+// SetLogging is not supported: Incus only exposes ACL logs for OVN networks
+func (a *IncusACLBackend) SetLogging(enabled bool) error {
+	if enabled {
+		return fmt.Errorf("connection logging is not supported by the incus-acl backend")
+	}
+	return nil
+}
+
+// AllowDestination adds an allow rule for a single destination to the container's ACL
+// Allow rules are evaluated after reject rules, so addresses inside rejected ranges stay blocked
+// ttl is ignored: the DNS proxy, the only user, is not supported with ACLs
+func (a *IncusACLBackend) AllowDestination(ip string, ttl time.Duration) error {
+	if err := container.IncusExec("network", "acl", "rule", "add", a.aclName(), "egress",
+		"action=allow", "destination="+hostCIDR(ip)); err != nil {
+		return fmt.Errorf("failed to allow %s: %w", ip, err)
+	}
+	return nil
+}
+
+// RevokeDestination removes an allow rule added with AllowDestination
+func (a *IncusACLBackend) RevokeDestination(ip string) error {
+	if err := container.IncusExec("network", "acl", "rule", "remove", a.aclName(), "egress",
+		"action=allow", "destination="+hostCIDR(ip)); err != nil {
+		return fmt.Errorf("failed to revoke %s: %w", ip, err)
+	}
+	return nil
+}
+
+// Redirect is not supported: ACLs can only allow or reject traffic
+func (a *IncusACLBackend) Redirect(redirects []PortRedirect) error {
+	return fmt.Errorf("redirects are not supported by the incus-acl backend (use firewalld or nftables)")
+}
+
+// applyRules (re)creates the container's ACL and attaches it to eth0
+func (a *IncusACLBackend) applyRules(rules []Rule) error {
+	accept, reject, ports, defaultAction, err := flattenRules(rules)
+	if err != nil {
+		return err
+	}
+
+	// Drop leftovers from a previous run so rules are replaced, not appended
+	if err := a.RemoveRules(); err != nil {
+		log.Printf("Warning: failed to remove old network ACL: %v", err)
+	}
+
+	aclName := a.aclName()
+	if err := container.IncusExec("network", "acl", "create", aclName); err != nil {
+		return fmt.Errorf("failed to create network ACL %s: %w", aclName, err)
+	}
+
+	for _, ruleArgs := range aclRuleArgs(accept, reject, ports) {
+		args := append([]string{"network", "acl", "rule", "add", aclName, "egress"}, ruleArgs...)
+		if err := container.IncusExec(args...); err != nil {
+			return fmt.Errorf("failed to add network ACL rule: %w", err)
+		}
+	}
+
+	nicConfig := []string{
+		"security.acls=" + aclName,
+		"security.acls.default.egress.action=" + aclAction(defaultAction),
+		// Ingress is not filtered by the other backends either (host access to container services)
+		"security.acls.default.ingress.action=allow",
+	}
+
+	if err := setNICConfig(a.containerName, nicConfig...); err != nil {
+		return fmt.Errorf("failed to attach network ACL to %s: %w", a.containerName, err)
+	}
+
+	return nil
+}
+
+// RemoveRules detaches and deletes the container's ACL
+func (a *IncusACLBackend) RemoveRules() error {
+	aclName := a.aclName()
+
+	if _, err := container.IncusOutput("network", "acl", "show", aclName); err != nil {
+		return nil // No ACL for this container (intentional nilerr)
+	}
+
+	// Detach first - an ACL in use cannot be deleted
+	// Errors are expected when the container is already gone
+	for _, key := range []string{"security.acls", "security.acls.default.egress.action", "security.acls.default.ingress.action"} {
+		_ = container.IncusExecQuiet("config", "device", "unset", a.containerName, "eth0", key)
+	}
+
+	if err := container.IncusExec("network", "acl", "delete", aclName); err != nil {
+		return fmt.Errorf("failed to delete network ACL %s: %w", aclName, err)
+	}
+	return nil
+}
+
+// ListRules returns the egress rules of the container's ACL as shown by Incus
+func (a *IncusACLBackend) ListRules() ([]string, error) {
+	output, err := container.IncusOutput("network", "acl", "show", a.aclName())
+	if err != nil {
+		return nil, nil // No ACL for this container (intentional nilerr)
+	}
+	return aclEgressLines(output), nil
+}
+
+// aclEgressLines returns the egress section of `incus network acl show` output
+func aclEgressLines(output string) []string {
+	var lines []string
+	inEgress := false
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "-") {
+			inEgress = strings.HasPrefix(line, "egress:")
+			continue
+		}
+		if inEgress && strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimRight(line, " "))
+		}
+	}
+	return lines
+}
+
+// aclName returns the ACL name for the container
+func (a *IncusACLBackend) aclName() string {
+	return a.containerName
+}
+
+// aclAction maps a rule action to an Incus ACL action
+func aclAction(action RuleAction) string {
+	if action == RuleAccept {
+		return "allow"
+	}
+	return "reject"
+}
+
+// aclRuleArgs returns the `incus network acl rule add` arguments for the flattened ranges
+// and port-limited allows
+func aclRuleArgs(accept, reject []string, ports []Rule) [][]string {
+	var args [][]string
+	if len(reject) > 0 {
+		args = append(args, []string{"action=reject", "destination=" + strings.Join(reject, ",")})
+	}
+	if len(accept) > 0 {
+		args = append(args, []string{"action=allow", "destination=" + strings.Join(accept, ",")})
+	}
+	for _, rule := range ports {
+		args = append(args, []string{"action=allow", "destination=" + rule.Destination,
+			"protocol=" + rule.Protocol, fmt.Sprintf("destination_port=%d", rule.Port)})
+	}
+	return args
+}
+
+// flattenRules converts first-match rules into non-overlapping accept and reject ranges
+// A catch-all rule (0.0.0.0/0, ::/0) becomes the default action (accept if there is none);
+// the ACL has a single default, so both address families must agree on it
+// Port-limited accepts are returned separately and carved out of later reject ranges, so
+// other ports to those addresses fall through to the default action - which must be reject
+func flattenRules(rules []Rule) (accept, reject []string, ports []Rule, defaultAction RuleAction, err error) {
+	sorted := make([]Rule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	defaults := map[bool]RuleAction{} // IPv6 -> catch-all action
+	var matched []*net.IPNet
+	var carved []*net.IPNet // Destinations of port-limited accepts
+	carvedBlocked := false  // A port-limited accept lies inside a later reject range
+
+	for _, rule := range sorted {
+		_, dest, err := net.ParseCIDR(rule.Destination)
+		if err != nil {
+			return nil, nil, nil, "", fmt.Errorf("invalid rule destination %s: %w", rule.Destination, err)
+		}
+
+		// Rules after the family's catch-all are unreachable
+		if _, done := defaults[rule.IPv6()]; done {
+			continue
+		}
+
+		if rule.PortLimited() {
+			if rule.Action != RuleAccept {
+				return nil, nil, nil, "", fmt.Errorf("port-limited reject rules are not supported by the incus-acl backend")
+			}
+			for _, part := range subtractCIDRs(dest, matched) {
+				ports = append(ports, Rule{Priority: rule.Priority, Destination: part.String(), Action: rule.Action,
+					Protocol: rule.Protocol, Port: rule.Port})
+				carved = append(carved, part)
+			}
+			continue
+		}
+
+		// Everything not matched so far gets this rule's action
+		if ones, _ := dest.Mask.Size(); ones == 0 {
+			defaults[rule.IPv6()] = rule.Action
+			continue
+		}
+
+		// Only the part not already matched by an earlier rule is affected by this one
+		if rule.Action == RuleAccept {
+			for _, part := range subtractCIDRs(dest, matched) {
+				accept = append(accept, part.String())
+			}
+		} else {
+			for _, c := range carved {
+				if cidrContains(dest, c) || cidrContains(c, dest) {
+					carvedBlocked = true
+				}
+			}
+			for _, part := range subtractCIDRs(dest, append(append([]*net.IPNet{}, matched...), carved...)) {
+				reject = append(reject, part.String())
+			}
+		}
+		matched = append(matched, dest)
+	}
+
+	defaultAction = RuleAccept
+	if v4, ok := defaults[false]; ok {
+		defaultAction = v4
+	}
+	if v6, ok := defaults[true]; ok {
+		if _, hasV4 := defaults[false]; hasV4 && v6 != defaultAction {
+			return nil, nil, nil, "", fmt.Errorf("IPv4 and IPv6 default actions differ (%s vs %s)", defaultAction, v6)
+		}
+		defaultAction = v6
+	}
+
+	if defaultAction == RuleAccept {
+		// Carving would open every port; outside reject ranges the allow is redundant
+		if carvedBlocked {
+			return nil, nil, nil, "", fmt.Errorf("port-limited exceptions inside blocked ranges are not supported by the incus-acl backend in restricted mode (use firewalld or nftables)")
+		}
+		ports = nil
+	}
+
+	return accept, reject, ports, defaultAction, nil
+}
+
+// subtractCIDRs returns the parts of n not covered by any of the excluded networks
+func subtractCIDRs(n *net.IPNet, excluded []*net.IPNet) []*net.IPNet {
+	overlapping := false
+	for _, ex := range excluded {
+		if cidrContains(ex, n) {
+			return nil
+		}
+		if cidrContains(n, ex) {
+			overlapping = true
+		}
+	}
+	if !overlapping {
+		return []*net.IPNet{n}
+	}
+
+	// Split into halves and subtract from each
+	ones, bits := n.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+	low := &net.IPNet{IP: n.IP.Mask(mask), Mask: mask}
+
+	highIP := new(big.Int).SetBytes(low.IP)
+	highIP.SetBit(highIP, bits-ones-1, 1)
+	highBytes := highIP.FillBytes(make([]byte, len(low.IP)))
+	high := &net.IPNet{IP: net.IP(highBytes), Mask: mask}
+
+	return append(subtractCIDRs(low, excluded), subtractCIDRs(high, excluded)...)
+}
+
+// cidrContains returns true if inner lies entirely within outer
+func cidrContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
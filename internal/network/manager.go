@@ -6,40 +6,84 @@ import (
 	"log"
 	"net"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mensfeld/code-on-incus/internal/config"
 	"github.com/mensfeld/code-on-incus/internal/container"
 )
 
-// errFirewallNotAvailable is the user-facing error message when firewalld is not available
+// errFirewallNotAvailable is the user-facing error message when no firewall backend is available
 const errFirewallNotAvailable = `firewalld is not available or not running
 
-Network isolation in restricted/allowlist modes requires firewalld.
+Network isolation in restricted/allowlist modes requires firewalld, nftables
+or Incus network ACLs.
 
-To fix this:
+To fix this, either:
   1. Install firewalld: sudo apt install firewalld
   2. Start firewalld: sudo systemctl enable --now firewalld
   3. Configure passwordless sudo for firewall-cmd (see README)
 
+Or use plain nftables / Incus network ACLs in ~/.config/coi/config.toml:
+  [network]
+  backend = "nftables"   # or "incus-acl"
+
 Alternatively, run with unrestricted network access:
   coi shell --network=open`
 
 // Manager provides high-level network isolation management for containers
 type Manager struct {
 	config        *config.NetworkConfig
-	firewall      *FirewallManager
+	firewall      FirewallBackend
 	resolver      *Resolver
 	cacheManager  *CacheManager
 	containerName string
 	containerIP   string
-
-	// Refresher lifecycle (for allowlist mode)
+	containerIPv6 string
+	ipv6Pinned    bool // IPv6 source filtering was enabled on the container's NIC (see pinContainerIPv6)
+	addrs         ContainerAddresses
+	netLog        *NetworkLog
+	connLogging   bool // Firewall log rules are enabled ([network.logging] connections)
+	connLogCancel context.CancelFunc
+	reverseNames  map[string]string // Destination IP -> PTR name, for the connection log
+	allowEntries  []AllowEntry      // Parsed allowed_domains (allowlist and proxy modes)
+	redirects     []PortRedirect    // Active proxy redirects, restored when rules are reapplied
+
+	// Live policy state (see Policy); applyMu serializes rule changes of the refresher,
+	// policy updates and teardown
+	policy       *Policy
+	policyCancel context.CancelFunc
+	policyDone   chan struct{}
+	applyMu      sync.Mutex
+
+	// Refresher lifecycle (for allowlist mode, also drives DNS proxy expiry)
 	refreshCtx    context.Context
 	refreshCancel context.CancelFunc
+
+	// Egress proxy state (proxy mode)
+	egressProxy *EgressProxy
+	proxyURL    string
+
+	// DNS proxy state (allowlist mode with dns_proxy)
+	dnsProxy     *DNSProxy
+	dynamicMu    sync.Mutex
+	dynamicIPs   map[string]time.Time // IP -> expiry of the DNS answer that allowed it
+	dynamicNames map[string]string    // IP -> name of the DNS answer that allowed it
+	staticIPs    map[string]bool      // Raw IP entries of the allowlist (never expire)
 }
 
+const (
+	// dnsProxyMinTTL is the minimum time an IP from a DNS answer stays allowed
+	// Short TTLs would otherwise close rules before slow clients connect
+	dnsProxyMinTTL = time.Minute
+	// dnsExpiryInterval is how often expired DNS answers are revoked
+	dnsExpiryInterval = 30 * time.Second
+	// policyPollInterval is how often the session checks for `coi network` policy updates
+	policyPollInterval = 2 * time.Second
+)
+
 // NewManager creates a new network manager with the specified configuration
 func NewManager(cfg *config.NetworkConfig) *Manager {
 	homeDir, err := os.UserHomeDir()
@@ -50,30 +94,50 @@ func NewManager(cfg *config.NetworkConfig) *Manager {
 	return &Manager{
 		config:       cfg,
 		cacheManager: NewCacheManager(homeDir),
+		netLog:       NewNetworkLog(cfg.Logging),
 	}
 }
 
 // SetupForContainer configures network isolation for a container
+// The applied policy is recorded for `coi network`, and updates to it are applied until Teardown
 func (m *Manager) SetupForContainer(ctx context.Context, containerName string) error {
 	m.containerName = containerName
 
+	if err := m.setupMode(ctx, containerName); err != nil {
+		return err
+	}
+
+	m.recordPolicy(ctx)
+	if m.connLogging {
+		m.startConnectionLog(ctx)
+	}
+	return nil
+}
+
+// setupMode applies the rules of the configured network mode
+func (m *Manager) setupMode(ctx context.Context, containerName string) error {
 	// Handle different network modes
 	switch m.config.Mode {
 	case config.NetworkModeOpen:
 		log.Println("Network mode: open (no restrictions)")
 		// Still need to add ACCEPT rules if firewall FORWARD policy is DROP
-		if FirewallAvailable() {
-			containerIP, err := GetContainerIP(containerName)
-			if err != nil {
-				log.Printf("Warning: could not get container IP for open mode rules: %v", err)
-				return nil
-			}
-			if err := EnsureOpenModeRules(containerIP); err != nil {
-				log.Printf("Warning: could not add open mode rules: %v", err)
-			}
-		} else {
-			log.Println("Warning: firewalld not available - container has unrestricted network access")
-			log.Println("         Network isolation (restricted/allowlist modes) requires firewalld")
+		backend, err := ResolveFirewallBackend(m.config.Backend)
+		if err != nil {
+			log.Println("Warning: no firewall backend available - container has unrestricted network access")
+			log.Println("         Network isolation (restricted/allowlist modes) requires firewalld, nftables or Incus network ACLs")
+			return nil
+		}
+		addrs, err := m.containerAddresses(containerName, false)
+		if err != nil {
+			log.Printf("Warning: could not get container IP for open mode rules: %v", err)
+			return nil
+		}
+		m.firewall, err = NewFirewallBackend(backend, containerName, addrs)
+		if err != nil {
+			return err
+		}
+		if err := m.firewall.ApplyOpen(); err != nil {
+			log.Printf("Warning: could not add open mode rules: %v", err)
 		}
 		return nil
 
@@ -83,49 +147,59 @@ func (m *Manager) SetupForContainer(ctx context.Context, containerName string) e
 	case config.NetworkModeAllowlist:
 		return m.setupAllowlist(ctx, containerName)
 
+	case config.NetworkModeProxy:
+		return m.setupProxy(containerName)
+
 	default:
 		return fmt.Errorf("unknown network mode: %s", m.config.Mode)
 	}
 }
 
-// setupRestricted configures restricted mode using firewalld
+// setupRestricted configures restricted mode using the configured firewall backend
 func (m *Manager) setupRestricted(ctx context.Context, containerName string) error {
 	log.Println("Network mode: restricted (blocking local/internal networks)")
 
-	// Check if firewalld is available
-	if !FirewallAvailable() {
-		return fmt.Errorf("%s", errFirewallNotAvailable)
+	// Check if a firewall backend is available
+	backend, err := ResolveFirewallBackend(m.config.Backend)
+	if err != nil {
+		return err
 	}
 
-	// Get container IP
-	containerIP, err := GetContainerIP(containerName)
+	exceptions, err := ParseAllowEntries(m.config.Exceptions)
+	if err != nil {
+		return fmt.Errorf("invalid network exception: %w", err)
+	}
+
+	// Get container and gateway IPs
+	addrs, err := m.containerAddresses(containerName, backend != config.NetworkBackendIncusACL)
 	if err != nil {
 		return fmt.Errorf("failed to get container IP: %w", err)
 	}
-	m.containerIP = containerIP
-	log.Printf("Container IP: %s", containerIP)
 
-	// Get gateway IP
-	gatewayIP, err := getContainerGatewayIP(containerName)
+	// Create firewall backend
+	m.firewall, err = NewFirewallBackend(backend, containerName, addrs)
 	if err != nil {
-		log.Printf("Warning: Could not auto-detect gateway IP: %v", err)
-	} else {
-		log.Printf("Gateway IP: %s", gatewayIP)
+		return err
 	}
+	log.Printf("Firewall backend: %s", m.firewall.Name())
+	m.enableConnectionLogging()
 
-	// Create firewall manager
-	m.firewall = NewFirewallManager(containerIP, gatewayIP)
+	// Exceptions are resolved once; restricted mode has no refresher
+	exceptions = resolveExceptions(exceptions)
 
 	// Apply restricted mode rules
-	if err := m.firewall.ApplyRestricted(m.config); err != nil {
+	if err := m.firewall.ApplyRestricted(m.config, exceptions); err != nil {
 		return fmt.Errorf("failed to apply firewall rules: %w", err)
 	}
 
 	log.Printf("Firewall rules applied for container %s", containerName)
+	for _, exception := range exceptions {
+		log.Printf("  Allowing exception %s", exception)
+	}
 
 	// Log what is blocked
 	if m.config.BlockPrivateNetworks {
-		log.Println("  Blocking private networks (RFC1918)")
+		log.Println("  Blocking private networks (RFC1918, IPv6 ULA)")
 	}
 	if m.config.BlockMetadataEndpoint {
 		log.Println("  Blocking cloud metadata endpoints")
@@ -138,34 +212,54 @@ func (m *Manager) setupRestricted(ctx context.Context, containerName string) err
 func (m *Manager) setupAllowlist(ctx context.Context, containerName string) error {
 	log.Println("Network mode: allowlist (domain-based filtering)")
 
-	// Check if firewalld is available
-	if !FirewallAvailable() {
-		return fmt.Errorf("%s", errFirewallNotAvailable)
+	// Check if a firewall backend is available
+	backend, err := ResolveFirewallBackend(m.config.Backend)
+	if err != nil {
+		return err
 	}
 
 	// Validate configuration
 	if len(m.config.AllowedDomains) == 0 {
 		return fmt.Errorf("allowlist mode requires at least one allowed domain")
 	}
+	if m.config.DNSProxy && backend == config.NetworkBackendIncusACL {
+		return fmt.Errorf("dns_proxy requires the firewalld or nftables backend")
+	}
 
-	// Get container IP
-	containerIP, err := GetContainerIP(containerName)
+	m.allowEntries, err = ParseAllowEntries(m.config.AllowedDomains)
+	if err != nil {
+		return fmt.Errorf("invalid allowed domain: %w", err)
+	}
+
+	// Wildcard patterns can only be enforced by the DNS proxy
+	domains := entryHosts(m.allowEntries)
+	addresses := addressEntries(m.allowEntries)
+	if !m.config.DNSProxy {
+		if len(domains)+len(addresses) < len(m.allowEntries) {
+			log.Println("Warning: wildcard entries in allowed_domains require dns_proxy = true, ignoring them")
+		}
+		if len(domains)+len(addresses) == 0 {
+			return fmt.Errorf("allowlist mode requires at least one allowed domain (wildcard patterns require dns_proxy = true)")
+		}
+	}
+
+	// Get container and gateway IPs
+	addrs, err := m.containerAddresses(containerName, backend != config.NetworkBackendIncusACL)
 	if err != nil {
 		return fmt.Errorf("failed to get container IP: %w", err)
 	}
-	m.containerIP = containerIP
-	log.Printf("Container IP: %s", containerIP)
 
-	// Get gateway IP
-	gatewayIP, err := getContainerGatewayIP(containerName)
+	// Create firewall backend
+	m.firewall, err = NewFirewallBackend(backend, containerName, addrs)
 	if err != nil {
-		log.Printf("Warning: Could not auto-detect gateway IP: %v", err)
-	} else {
-		log.Printf("Gateway IP: %s", gatewayIP)
+		return err
 	}
+	log.Printf("Firewall backend: %s", m.firewall.Name())
+	m.enableConnectionLogging()
 
-	// Create firewall manager
-	m.firewall = NewFirewallManager(containerIP, gatewayIP)
+	if m.config.DNSProxy {
+		return m.setupDNSProxy(ctx, containerName, addrs)
+	}
 
 	// Load IP cache
 	cache, err := m.cacheManager.Load(containerName)
@@ -180,11 +274,14 @@ func (m *Manager) setupAllowlist(ctx context.Context, containerName string) erro
 	// Initialize resolver with cache
 	m.resolver = NewResolver(cache)
 
-	// Resolve domains
-	log.Printf("Resolving %d allowed domains...", len(m.config.AllowedDomains))
-	domainIPs, err := m.resolver.ResolveAll(m.config.AllowedDomains)
-	if err != nil && len(domainIPs) == 0 {
-		return fmt.Errorf("failed to resolve any allowed domains: %w", err)
+	// Resolve domains (IP and CIDR entries are used as-is)
+	domainIPs := make(map[string][]string)
+	if len(domains) > 0 {
+		log.Printf("Resolving %d allowed domains...", len(domains))
+		domainIPs, err = m.resolver.ResolveAll(domains)
+		if err != nil && len(domainIPs) == 0 && len(addresses) == 0 {
+			return fmt.Errorf("failed to resolve any allowed domains: %w", err)
+		}
 	}
 
 	// Log resolution results
@@ -200,39 +297,295 @@ func (m *Manager) setupAllowlist(ctx context.Context, containerName string) erro
 		log.Printf("Warning: Failed to save cache: %v", err)
 	}
 
-	// Collect all unique IPs from resolved domains
-	allowedIPs := collectUniqueIPs(domainIPs)
+	// One entry per resolved IP, keeping port limits
+	allowed := expandEntries(m.allowEntries, domainIPs)
 
 	// Apply allowlist mode rules
-	if err := m.firewall.ApplyAllowlist(m.config, allowedIPs); err != nil {
+	if err := m.firewall.ApplyAllowlist(m.config, allowed); err != nil {
 		return fmt.Errorf("failed to apply firewall rules: %w", err)
 	}
 
 	log.Printf("Firewall rules applied for container %s", containerName)
 	log.Println("  Allowing only specified domains")
-	log.Println("  Blocking all RFC1918 private networks")
+	log.Println("  Blocking all private networks (RFC1918, IPv6 ULA)")
 	log.Println("  Blocking cloud metadata endpoints")
 
-	// Start background refresher
-	m.startRefresher(ctx)
+	// Start background refresher (nothing to refresh with only IP and CIDR entries)
+	if len(domains) > 0 {
+		m.startRefresher(ctx)
+	}
 
 	return nil
 }
 
-// collectUniqueIPs extracts all unique IPs from domain resolution map
-func collectUniqueIPs(domainIPs map[string][]string) []string {
-	uniqueIPs := make(map[string]bool)
-	for _, ips := range domainIPs {
-		for _, ip := range ips {
-			uniqueIPs[ip] = true
+// setupDNSProxy starts the DNS proxy for allowlist mode
+// Only raw IP entries are allowed up front; domain IPs are allowed as the container resolves them
+// through the proxy and revoked once their TTL expires
+func (m *Manager) setupDNSProxy(ctx context.Context, containerName string, addrs ContainerAddresses) error {
+	if addrs.GatewayIPv4 == "" {
+		return fmt.Errorf("the DNS proxy requires the bridge gateway IP")
+	}
+
+	if err := checkDNSProxyEntries(m.allowEntries); err != nil {
+		return err
+	}
+
+	static := m.setStaticIPs()
+	m.dynamicIPs = make(map[string]time.Time)
+	m.dynamicNames = make(map[string]string)
+
+	if err := m.firewall.ApplyAllowlist(m.config, static); err != nil {
+		return fmt.Errorf("failed to apply firewall rules: %w", err)
+	}
+
+	upstream := m.config.DNSUpstream
+	if upstream == "" {
+		upstream = net.JoinHostPort(addrs.GatewayIPv4, "53")
+	}
+
+	m.dnsProxy = NewDNSProxy(entryPatterns(m.allowEntries), upstream)
+	m.dnsProxy.OnAllow = m.allowDNSAnswers
+	m.dnsProxy.OnDeny = m.logDeniedLookup
+	m.dnsProxy.Clients = addrs.IPs()
+
+	// A free port per session, so several containers on one bridge each get their own proxy
+	port, err := m.dnsProxy.Start(net.JoinHostPort(addrs.GatewayIPv4, "0"))
+	if err != nil {
+		return fmt.Errorf("failed to start DNS proxy: %w", err)
+	}
+	dnsRedirect := PortRedirect{Protocols: []string{"udp", "tcp"}, Port: 53, ToPort: port}
+	if err := m.firewall.Redirect([]PortRedirect{dnsRedirect}); err != nil {
+		m.dnsProxy.Stop()
+		m.dnsProxy = nil
+		return fmt.Errorf("failed to redirect container DNS: %w", err)
+	}
+	m.redirects = []PortRedirect{dnsRedirect}
+
+	log.Printf("Firewall rules applied for container %s", containerName)
+	log.Printf("  DNS proxy on %s:%d (upstream %s)", addrs.GatewayIPv4, port, upstream)
+	log.Println("  Allowing only IPs returned for allowed domains")
+	log.Println("  Blocking all private networks (RFC1918, IPv6 ULA)")
+	log.Println("  Blocking cloud metadata endpoints")
+
+	m.startDNSExpiry(ctx)
+
+	return nil
+}
+
+// setupProxy configures proxy mode: all traffic is rejected except the gateway and raw IP entries,
+// and HTTP(S) goes through the egress proxy (via HTTP(S)_PROXY and port 80/443 redirects)
+func (m *Manager) setupProxy(containerName string) error {
+	log.Println("Network mode: proxy (hostname-based HTTP(S) filtering)")
+
+	backend, err := ResolveFirewallBackend(m.config.Backend)
+	if err != nil {
+		return err
+	}
+
+	if len(m.config.AllowedDomains) == 0 {
+		return fmt.Errorf("proxy mode requires at least one allowed domain")
+	}
+	m.allowEntries, err = ParseAllowEntries(m.config.AllowedDomains)
+	if err != nil {
+		return fmt.Errorf("invalid allowed domain: %w", err)
+	}
+
+	addrs, err := m.containerAddresses(containerName, backend != config.NetworkBackendIncusACL)
+	if err != nil {
+		return fmt.Errorf("failed to get container IP: %w", err)
+	}
+	if addrs.GatewayIPv4 == "" {
+		return fmt.Errorf("proxy mode requires the bridge gateway IP")
+	}
+
+	m.firewall, err = NewFirewallBackend(backend, containerName, addrs)
+	if err != nil {
+		return err
+	}
+	log.Printf("Firewall backend: %s", m.firewall.Name())
+	m.enableConnectionLogging()
+
+	// Only the gateway (where the proxy listens) and raw IP entries are reachable directly
+	if err := m.firewall.ApplyAllowlist(m.config, addressEntries(m.allowEntries)); err != nil {
+		return fmt.Errorf("failed to apply firewall rules: %w", err)
+	}
+
+	m.egressProxy = NewEgressProxy(m.allowEntries, !m.config.AllowLocalNetworkAccess)
+	m.egressProxy.OnRequest = m.logProxyRequest
+	m.egressProxy.Clients = addrs.IPs()
+
+	httpPort, tlsPort, err := m.egressProxy.Start(addrs.GatewayIPv4)
+	if err != nil {
+		return fmt.Errorf("failed to start egress proxy: %w", err)
+	}
+	m.proxyURL = fmt.Sprintf("http://%s:%d", addrs.GatewayIPv4, httpPort)
+
+	// Redirects catch clients that ignore HTTP(S)_PROXY
+	redirects := []PortRedirect{
+		{Protocols: []string{"tcp"}, Port: 80, ToPort: httpPort},
+		{Protocols: []string{"tcp"}, Port: 443, ToPort: tlsPort},
+	}
+	if err := m.firewall.Redirect(redirects); err != nil {
+		log.Printf("Warning: transparent proxying unavailable, only proxy-aware clients can connect: %v", err)
+	} else {
+		m.redirects = redirects
+	}
+
+	log.Printf("Firewall rules applied for container %s", containerName)
+	log.Printf("  Egress proxy on %s (TLS port %d)", m.proxyURL, tlsPort)
+	log.Println("  Allowing only HTTP(S) requests to allowed domains")
+	log.Println("  Blocking all other traffic")
+
+	return nil
+}
+
+// ContainerEnv returns environment variables the container needs for the network mode
+// (HTTP(S)_PROXY in proxy mode)
+func (m *Manager) ContainerEnv() map[string]string {
+	if m.proxyURL == "" {
+		return nil
+	}
+	return map[string]string{
+		"HTTP_PROXY":  m.proxyURL,
+		"HTTPS_PROXY": m.proxyURL,
+		"http_proxy":  m.proxyURL,
+		"https_proxy": m.proxyURL,
+		"NO_PROXY":    "localhost,127.0.0.1,::1",
+		"no_proxy":    "localhost,127.0.0.1,::1",
+	}
+}
+
+// logProxyRequest records an egress proxy request in the network log
+func (m *Manager) logProxyRequest(entry LogEntry) {
+	entry.Container = m.containerName
+	if err := m.netLog.Record(entry); err != nil {
+		log.Printf("Warning: failed to write network log: %v", err)
+	}
+}
+
+// allowDNSAnswers opens the firewall for the IPs of an allowed DNS answer
+// Runs before the proxy returns the answer, so the client can connect right away
+func (m *Manager) allowDNSAnswers(name string, answers []DNSAnswer) {
+	m.dynamicMu.Lock()
+	defer m.dynamicMu.Unlock()
+
+	now := time.Now()
+	for _, answer := range answers {
+		if m.staticIPs[answer.IP] {
+			continue
+		}
+
+		ttl := answer.TTL
+		if ttl < dnsProxyMinTTL {
+			ttl = dnsProxyMinTTL
+		}
+		expiry := now.Add(ttl)
+
+		// Already allowed - just extend the expiry (renewing the kernel timeout)
+		if current, ok := m.dynamicIPs[answer.IP]; ok {
+			if expiry.After(current) {
+				if err := m.firewall.AllowDestination(answer.IP, kernelTTL(expiry, now)); err != nil {
+					log.Printf("Warning: DNS proxy could not renew %s (%s): %v", answer.IP, name, err)
+					continue
+				}
+				m.dynamicIPs[answer.IP] = expiry
+			}
+			continue
+		}
+
+		if err := m.firewall.AllowDestination(answer.IP, kernelTTL(expiry, now)); err != nil {
+			log.Printf("Warning: DNS proxy could not allow %s (%s): %v", answer.IP, name, err)
+			continue
+		}
+		m.dynamicIPs[answer.IP] = expiry
+		m.dynamicNames[answer.IP] = name
+	}
+}
+
+// kernelTTL returns the kernel timeout of an IP allowed until expiry
+// It runs past the expiry by one check interval, so the session normally revokes the IP itself
+// and the kernel timeout only matters once the session is gone
+func kernelTTL(expiry, now time.Time) time.Duration {
+	return expiry.Sub(now) + dnsExpiryInterval
+}
+
+// expireDNSAnswers revokes IPs whose DNS answers have expired
+// Established connections are kept by the conntrack rule
+func (m *Manager) expireDNSAnswers(now time.Time) {
+	m.dynamicMu.Lock()
+	defer m.dynamicMu.Unlock()
+
+	for _, ip := range expiredIPs(m.dynamicIPs, now) {
+		if err := m.firewall.RevokeDestination(ip); err != nil {
+			log.Printf("Warning: DNS proxy could not revoke %s: %v", ip, err)
+		}
+		delete(m.dynamicIPs, ip)
+		delete(m.dynamicNames, ip)
+	}
+}
+
+// logDeniedLookup records a lookup of a name that is not allowed in the network log
+func (m *Manager) logDeniedLookup(name string, qtype uint16) {
+	err := m.netLog.Record(LogEntry{
+		Container: m.containerName,
+		Event:     "dns",
+		Domain:    name,
+		QueryType: dnsTypeName(qtype),
+		Verdict:   VerdictDenied,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to write network log: %v", err)
+	}
+}
+
+// startDNSExpiry starts the background goroutine revoking expired DNS answers
+func (m *Manager) startDNSExpiry(ctx context.Context) {
+	m.refreshCtx, m.refreshCancel = context.WithCancel(ctx)
+	ticker := time.NewTicker(dnsExpiryInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.expireDNSAnswers(time.Now())
+
+			case <-m.refreshCtx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// expiredIPs returns the IPs whose expiry is not after now, sorted
+func expiredIPs(expiries map[string]time.Time, now time.Time) []string {
+	var expired []string
+	for ip, expiry := range expiries {
+		if !expiry.After(now) {
+			expired = append(expired, ip)
 		}
 	}
+	sort.Strings(expired)
+	return expired
+}
 
-	result := make([]string, 0, len(uniqueIPs))
-	for ip := range uniqueIPs {
-		result = append(result, ip)
+// resolveExceptions resolves the domain entries of restricted mode exceptions once
+// Domains that fail to resolve are skipped with a warning
+func resolveExceptions(exceptions []AllowEntry) []AllowEntry {
+	resolver := NewResolver(&IPCache{Domains: make(map[string][]string)})
+
+	domainIPs := make(map[string][]string)
+	for _, domain := range entryHosts(exceptions) {
+		ips, err := resolver.ResolveDomain(domain)
+		if err != nil {
+			log.Printf("Warning: skipping network exception %s: %v", domain, err)
+			continue
+		}
+		domainIPs[domain] = ips
 	}
-	return result
+
+	return expandEntries(exceptions, domainIPs)
 }
 
 // startRefresher starts the background IP refresh goroutine
@@ -278,8 +631,11 @@ func (m *Manager) stopRefresher() {
 
 // refreshAllowedIPs refreshes domain IPs and updates firewall rules if changed
 func (m *Manager) refreshAllowedIPs() error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
 	// Resolve all domains again
-	newIPs, err := m.resolver.ResolveAll(m.config.AllowedDomains)
+	newIPs, err := m.resolver.ResolveAll(entryHosts(m.allowEntries))
 	if err != nil && len(newIPs) == 0 {
 		return fmt.Errorf("failed to resolve any domains")
 	}
@@ -299,8 +655,7 @@ func (m *Manager) refreshAllowedIPs() error {
 		log.Printf("Warning: failed to remove old rules: %v", err)
 	}
 
-	allowedIPs := collectUniqueIPs(newIPs)
-	if err := m.firewall.ApplyAllowlist(m.config, allowedIPs); err != nil {
+	if err := m.firewall.ApplyAllowlist(m.config, expandEntries(m.allowEntries, newIPs)); err != nil {
 		return fmt.Errorf("failed to update firewall rules: %w", err)
 	}
 
@@ -323,10 +678,263 @@ func countIPs(domainIPs map[string][]string) int {
 	return count
 }
 
+// recordPolicy saves the applied policy for `coi network` and starts watching for updates
+func (m *Manager) recordPolicy(ctx context.Context) {
+	m.policy = &Policy{
+		Container:      m.containerName,
+		Mode:           m.config.Mode,
+		Addresses:      m.addrs,
+		DNSProxy:       m.config.Mode == config.NetworkModeAllowlist && m.config.DNSProxy,
+		AllowedDomains: m.config.AllowedDomains,
+		Exceptions:     m.config.Exceptions,
+		PID:            os.Getpid(),
+		AppliedAt:      time.Now().UTC(),
+	}
+	if m.firewall != nil {
+		m.policy.Backend = config.NetworkBackend(m.firewall.Name())
+	}
+
+	// Updates left over from an earlier session of the container no longer apply
+	if err := m.cacheManager.DeletePolicy(m.containerName); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if err := m.cacheManager.SavePolicy(m.policy); err != nil {
+		log.Printf("Warning: failed to save network policy: %v", err)
+		return
+	}
+
+	if m.policy.Editable() {
+		m.startPolicyWatcher(ctx)
+	}
+}
+
+// startPolicyWatcher starts the background goroutine applying policy updates
+func (m *Manager) startPolicyWatcher(ctx context.Context) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	m.policyCancel = cancel
+	m.policyDone = make(chan struct{})
+	ticker := time.NewTicker(policyPollInterval)
+
+	go func() {
+		defer close(m.policyDone)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.checkPolicyUpdate(watchCtx)
+
+			case <-watchCtx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stopPolicyWatcher stops the policy watcher goroutine and waits for an update in progress
+func (m *Manager) stopPolicyWatcher() {
+	if m.policyCancel != nil {
+		m.policyCancel()
+		<-m.policyDone
+		m.policyCancel = nil
+	}
+}
+
+// checkPolicyUpdate applies a pending policy update and records the result in the policy
+// If applying fails, the previous lists are restored so the container is never left without rules
+func (m *Manager) checkPolicyUpdate(ctx context.Context) {
+	update, err := m.cacheManager.LoadPolicyUpdate(m.containerName)
+	if err != nil {
+		log.Printf("Warning: failed to read network policy update: %v", err)
+		return
+	}
+	if update == nil || update.Revision <= m.policy.Revision {
+		return
+	}
+
+	log.Printf("Applying network policy update (revision %d)", update.Revision)
+	m.policy.Error = ""
+	if err := m.applyPolicyUpdate(ctx, update); err != nil {
+		log.Printf("Warning: network policy update failed: %v", err)
+		m.policy.Error = err.Error()
+
+		previous := &PolicyUpdate{AllowedDomains: m.policy.AllowedDomains, Exceptions: m.policy.Exceptions}
+		if err := m.applyPolicyUpdate(ctx, previous); err != nil {
+			log.Printf("Warning: failed to restore the previous network policy: %v", err)
+		}
+	} else {
+		m.policy.AllowedDomains = update.AllowedDomains
+		m.policy.Exceptions = update.Exceptions
+	}
+
+	m.policy.Revision = update.Revision
+	m.policy.AppliedAt = time.Now().UTC()
+	if err := m.cacheManager.SavePolicy(m.policy); err != nil {
+		log.Printf("Warning: failed to save network policy: %v", err)
+	}
+}
+
+// applyPolicyUpdate replaces the allowlist (or restricted mode exceptions) and reapplies the rules
+// Domains are resolved again, so an update with unchanged lists refreshes the rules
+func (m *Manager) applyPolicyUpdate(ctx context.Context, update *PolicyUpdate) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	if m.firewall == nil {
+		return fmt.Errorf("network isolation is not active")
+	}
+
+	// The config is copied so the caller's config is left untouched
+	cfg := *m.config
+
+	if m.config.Mode == config.NetworkModeRestricted {
+		exceptions, err := ParseAllowEntries(update.Exceptions)
+		if err != nil {
+			return fmt.Errorf("invalid network exception: %w", err)
+		}
+		cfg.Exceptions = update.Exceptions
+		m.config = &cfg
+
+		if err := m.firewall.RemoveRules(); err != nil {
+			log.Printf("Warning: failed to remove old rules: %v", err)
+		}
+		if err := m.firewall.ApplyRestricted(m.config, resolveExceptions(exceptions)); err != nil {
+			return fmt.Errorf("failed to apply firewall rules: %w", err)
+		}
+		return nil
+	}
+
+	if len(update.AllowedDomains) == 0 {
+		return fmt.Errorf("%s mode requires at least one allowed domain", m.config.Mode)
+	}
+	entries, err := ParseAllowEntries(update.AllowedDomains)
+	if err != nil {
+		return fmt.Errorf("invalid allowed domain: %w", err)
+	}
+	if m.dnsProxy != nil {
+		if err := checkDNSProxyEntries(entries); err != nil {
+			return err
+		}
+	}
+	cfg.AllowedDomains = update.AllowedDomains
+	m.config = &cfg
+	m.allowEntries = entries
+
+	switch {
+	case m.dnsProxy != nil:
+		return m.reapplyDNSProxy()
+	case m.egressProxy != nil:
+		m.egressProxy.SetAllowed(entries)
+		return m.reapplyRules(addressEntries(entries))
+	default:
+		return m.reapplyAllowlist(ctx)
+	}
+}
+
+// reapplyRules replaces the container's rules with allowlist rules for the entries and restores redirects
+func (m *Manager) reapplyRules(allowed []AllowEntry) error {
+	if err := m.firewall.RemoveRules(); err != nil {
+		log.Printf("Warning: failed to remove old rules: %v", err)
+	}
+	if err := m.firewall.ApplyAllowlist(m.config, allowed); err != nil {
+		return fmt.Errorf("failed to apply firewall rules: %w", err)
+	}
+	if len(m.redirects) > 0 {
+		if err := m.firewall.Redirect(m.redirects); err != nil {
+			return fmt.Errorf("failed to restore redirects: %w", err)
+		}
+	}
+	return nil
+}
+
+// reapplyAllowlist resolves the allowed domains again and reapplies allowlist mode rules
+func (m *Manager) reapplyAllowlist(ctx context.Context) error {
+	if m.resolver == nil {
+		m.resolver = NewResolver(&IPCache{Domains: make(map[string][]string)})
+	}
+
+	domains := entryHosts(m.allowEntries)
+	domainIPs := make(map[string][]string)
+	if len(domains) > 0 {
+		var err error
+		domainIPs, err = m.resolver.ResolveAll(domains)
+		if err != nil && len(domainIPs) == 0 && len(addressEntries(m.allowEntries)) == 0 {
+			return fmt.Errorf("failed to resolve any allowed domains: %w", err)
+		}
+	}
+
+	if err := m.reapplyRules(expandEntries(m.allowEntries, domainIPs)); err != nil {
+		return err
+	}
+
+	m.resolver.UpdateCache(domainIPs)
+	if err := m.cacheManager.Save(m.containerName, m.resolver.GetCache()); err != nil {
+		log.Printf("Warning: Failed to save cache: %v", err)
+	}
+
+	// The first domain entries of a session that started with only IPs need the refresher
+	if len(domains) > 0 && m.refreshCancel == nil {
+		m.startRefresher(ctx)
+	}
+	return nil
+}
+
+// reapplyDNSProxy updates the DNS proxy's names and reapplies the rules
+// IPs already allowed by DNS answers are kept if their name is still allowed
+func (m *Manager) reapplyDNSProxy() error {
+	m.dynamicMu.Lock()
+	defer m.dynamicMu.Unlock()
+
+	patterns := entryPatterns(m.allowEntries)
+	m.dnsProxy.SetAllowed(patterns)
+
+	if err := m.reapplyRules(m.setStaticIPs()); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for ip, name := range m.dynamicNames {
+		if m.staticIPs[ip] || !MatchesDomain(patterns, name) {
+			delete(m.dynamicIPs, ip)
+			delete(m.dynamicNames, ip)
+			continue
+		}
+		if err := m.firewall.AllowDestination(ip, kernelTTL(m.dynamicIPs[ip], now)); err != nil {
+			log.Printf("Warning: DNS proxy could not allow %s (%s): %v", ip, name, err)
+		}
+	}
+	return nil
+}
+
+// setStaticIPs records the raw IP entries of the allowlist and returns the address entries
+func (m *Manager) setStaticIPs() []AllowEntry {
+	static := addressEntries(m.allowEntries)
+	m.staticIPs = make(map[string]bool, len(static))
+	for _, entry := range static {
+		if entry.Port == 0 {
+			m.staticIPs[entry.Host] = true
+		}
+	}
+	return static
+}
+
+// checkDNSProxyEntries rejects entries the DNS proxy cannot enforce
+// Answers open all ports of the returned IPs, so port limits cannot be kept for domains
+func checkDNSProxyEntries(entries []AllowEntry) error {
+	for _, entry := range entries {
+		if entry.Port != 0 && !entry.IsAddress() {
+			return fmt.Errorf("dns_proxy does not support port-limited domain entries (%s)", entry)
+		}
+	}
+	return nil
+}
+
 // Teardown removes network isolation for a container
 func (m *Manager) Teardown(ctx context.Context, containerName string) error {
-	// Stop background refresher if running (for allowlist mode)
-	m.stopRefresher()
+	m.stopSession(containerName)
+
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
 
 	// Nothing to clean up in open mode
 	if m.config.Mode == config.NetworkModeOpen {
@@ -342,20 +950,195 @@ func (m *Manager) Teardown(ctx context.Context, containerName string) error {
 		}
 	}
 
+	// Errors are expected when the container is already gone
+	if m.ipv6Pinned {
+		_ = container.IncusExecQuiet("config", "device", "unset", containerName, "eth0", "security.ipv6_filtering")
+	}
+
+	return nil
+}
+
+// Detach ends the session's part in network isolation while the container keeps running
+// (a kept or persistent container): the proxies stop with the session process, so their
+// redirects and the IPs allowed by DNS answers are removed, and only the static rules stay
+// Traffic that went through the proxies is rejected instead of sent to a dead listener
+func (m *Manager) Detach(containerName string) error {
+	proxied := m.dnsProxy != nil || m.egressProxy != nil
+	m.stopSession(containerName)
+
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	if !proxied || m.firewall == nil {
+		return nil
+	}
+
+	m.dynamicMu.Lock()
+	m.dynamicIPs = make(map[string]time.Time)
+	m.dynamicNames = make(map[string]string)
+	m.dynamicMu.Unlock()
+
+	m.redirects = nil
+	if err := m.reapplyRules(addressEntries(m.allowEntries)); err != nil {
+		return fmt.Errorf("failed to remove proxy rules: %w", err)
+	}
+	log.Printf("Proxy redirects removed for container %s (only allowed IPs stay reachable)", containerName)
 	return nil
 }
 
+// stopSession stops what runs in the session process: the policy watcher, connection log,
+// refresher and proxies, and deletes the recorded policy
+func (m *Manager) stopSession(containerName string) {
+	m.stopPolicyWatcher()
+	m.stopConnectionLog()
+
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	// Stop background refresher if running (for allowlist mode)
+	m.stopRefresher()
+
+	if m.dnsProxy != nil {
+		m.dnsProxy.Stop()
+		m.dnsProxy = nil
+	}
+	if m.egressProxy != nil {
+		m.egressProxy.Stop()
+		m.egressProxy = nil
+	}
+
+	if m.policy != nil {
+		if err := m.cacheManager.DeletePolicy(containerName); err != nil {
+			log.Printf("Warning: failed to delete network policy: %v", err)
+		}
+		m.policy = nil
+	}
+}
+
+// UsesSessionProxies returns true if the network mode relies on proxies running in the session
+// process (proxy mode, allowlist mode with dns_proxy), which only enforce it while the session runs
+func UsesSessionProxies(cfg *config.NetworkConfig) bool {
+	return cfg.Mode == config.NetworkModeProxy || (cfg.Mode == config.NetworkModeAllowlist && cfg.DNSProxy)
+}
+
 // GetMode returns the current network mode
 func (m *Manager) GetMode() config.NetworkMode {
 	return m.config.Mode
 }
 
-// getContainerGatewayIP auto-detects the gateway IP for a container's network
-func getContainerGatewayIP(containerName string) (string, error) {
+// containerAddresses detects the container's IPv4 and IPv6 addresses and its bridge gateways
+// IPv6 is only looked up when the bridge has IPv6 enabled, so IPv4-only setups don't wait for it
+// With pinIPv6 (address-based backends enforcing a policy), the container's IPv6 traffic is pinned
+// to one source address (see pinContainerIPv6) and failing to do so is an error: rules matching
+// an unknown address would let all of the container's IPv6 traffic bypass them
+func (m *Manager) containerAddresses(containerName string, pinIPv6 bool) (ContainerAddresses, error) {
+	containerIP, err := GetContainerIP(containerName)
+	if err != nil {
+		return ContainerAddresses{}, err
+	}
+	m.containerIP = containerIP
+	log.Printf("Container IP: %s", containerIP)
+
+	addrs := ContainerAddresses{IPv4: containerIP}
+	defer func() { m.addrs = addrs }()
+
+	networkName, networkOutput, err := getContainerNetwork()
+	if err != nil {
+		if pinIPv6 {
+			return addrs, fmt.Errorf("could not detect the container's network: %w", err)
+		}
+		log.Printf("Warning: Could not auto-detect gateway IP: %v", err)
+		return addrs, nil
+	}
+
+	addrs.GatewayIPv4, addrs.GatewayIPv6 = parseNetworkGateways(networkOutput)
+	if addrs.GatewayIPv4 != "" {
+		log.Printf("Gateway IP: %s", addrs.GatewayIPv4)
+	} else {
+		log.Printf("Warning: Could not auto-detect gateway IP: could not find a valid ipv4.address in network %s", networkName)
+	}
+
+	if addrs.GatewayIPv6 == "" {
+		return addrs, nil
+	}
+	log.Printf("Gateway IPv6: %s", addrs.GatewayIPv6)
+
+	if pinIPv6 {
+		addrs.IPv6, err = pinContainerIPv6(containerName, networkOutput)
+		if err != nil {
+			return addrs, fmt.Errorf("cannot enforce IPv6 rules for %s: %w", containerName, err)
+		}
+		m.ipv6Pinned = true
+	} else {
+		addrs.IPv6 = GetContainerIPv6(containerName)
+	}
+	m.containerIPv6 = addrs.IPv6
+	if addrs.IPv6 != "" {
+		log.Printf("Container IPv6: %s", addrs.IPv6)
+	} else {
+		log.Println("Warning: bridge has IPv6 but the container has no global IPv6 address")
+	}
+
+	return addrs, nil
+}
+
+// pinContainerIPv6 enables Incus IPv6 source filtering on the container's eth0 and returns the
+// only global address it lets out: the NIC's static ipv6.address, or else the SLAAC (EUI-64)
+// address of its MAC; privacy and manually added addresses are dropped at the bridge, so rules
+// matching this address cover all of the container's IPv6 traffic
+func pinContainerIPv6(containerName, networkOutput string) (string, error) {
+	if networkConfigValue(networkOutput, "ipv6.dhcp.stateful") == "true" {
+		return "", fmt.Errorf("bridges with stateful DHCPv6 are not supported (use the incus-acl backend or disable IPv6 on the bridge)")
+	}
+
+	instance, err := container.GetBackend().GetInstance(containerName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get container info: %w", err)
+	}
+
+	address := instance.ExpandedDevices["eth0"]["ipv6.address"]
+	if address == "" {
+		address, err = slaacAddress(networkConfigValue(networkOutput, "ipv6.address"), instance.ConfigValue("volatile.eth0.hwaddr"))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := setNICConfig(containerName, "security.ipv6_filtering=true"); err != nil {
+		return "", fmt.Errorf("failed to enable IPv6 filtering: %w", err)
+	}
+	return address, nil
+}
+
+// slaacAddress returns the EUI-64 address SLAAC derives from a MAC in a /64 subnet
+func slaacAddress(subnet, mac string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil || ipNet.IP.To4() != nil {
+		return "", fmt.Errorf("invalid bridge IPv6 subnet %q", subnet)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones != 64 {
+		return "", fmt.Errorf("SLAAC requires a /64 bridge subnet, got /%d", ones)
+	}
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("invalid container MAC address %q", mac)
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, ipNet.IP.To16()[:8])
+	ip[8] = hw[0] ^ 0x02 // Universal/local bit
+	ip[9], ip[10] = hw[1], hw[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = hw[3], hw[4], hw[5]
+	return ip.String(), nil
+}
+
+// getContainerNetwork returns the name and `incus network show` output of the container's network
+func getContainerNetwork() (string, string, error) {
 	// Get container's network configuration from default profile
 	profileOutput, err := container.IncusOutput("profile", "device", "show", "default")
 	if err != nil {
-		return "", fmt.Errorf("failed to get default profile: %w", err)
+		return "", "", fmt.Errorf("failed to get default profile: %w", err)
 	}
 
 	// Parse network name from profile (eth0 device)
@@ -378,34 +1161,59 @@ func getContainerGatewayIP(containerName string) (string, error) {
 	}
 
 	if networkName == "" {
-		return "", fmt.Errorf("could not determine network name from profile")
+		return "", "", fmt.Errorf("could not determine network name from profile")
 	}
 
 	// Get network configuration
 	networkOutput, err := container.IncusOutput("network", "show", networkName)
 	if err != nil {
-		return "", fmt.Errorf("failed to get network info: %w", err)
+		return "", "", fmt.Errorf("failed to get network info: %w", err)
 	}
 
-	// Parse gateway IP (ipv4.address field)
+	return networkName, networkOutput, nil
+}
+
+// networkConfigValue returns a config key from `incus network show` output ("" if unset)
+func networkConfigValue(networkOutput, key string) string {
 	for _, line := range strings.Split(networkOutput, "\n") {
 		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "ipv4.address:") {
-			addressWithMask := strings.TrimSpace(strings.TrimPrefix(line, "ipv4.address:"))
-			// Remove CIDR suffix (e.g., "10.128.178.1/24" -> "10.128.178.1")
-			gatewayIP := addressWithMask
-			if idx := strings.Index(addressWithMask, "/"); idx != -1 {
-				gatewayIP = addressWithMask[:idx]
+		if value, ok := strings.CutPrefix(line, key+":"); ok {
+			return strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return ""
+}
+
+// parseNetworkGateways extracts the gateway IPs from `incus network show` output
+// (ipv4.address and ipv6.address fields); "none" or invalid addresses yield ""
+func parseNetworkGateways(networkOutput string) (string, string) {
+	var gatewayIPv4, gatewayIPv6 string
+
+	for _, line := range strings.Split(networkOutput, "\n") {
+		line = strings.TrimSpace(line)
+		for _, key := range []string{"ipv4.address:", "ipv6.address:"} {
+			if !strings.HasPrefix(line, key) {
+				continue
 			}
 
-			// Validate that we extracted a valid IPv4 address
-			if net.ParseIP(gatewayIP) == nil {
-				return "", fmt.Errorf("invalid IPv4 address extracted: %s", gatewayIP)
+			// Remove CIDR suffix (e.g., "10.128.178.1/24" -> "10.128.178.1")
+			address := strings.TrimSpace(strings.TrimPrefix(line, key))
+			address = strings.Trim(address, `"'`)
+			if idx := strings.Index(address, "/"); idx != -1 {
+				address = address[:idx]
 			}
 
-			return gatewayIP, nil
+			ip := net.ParseIP(address)
+			if ip == nil {
+				continue
+			}
+			if key == "ipv4.address:" && ip.To4() != nil {
+				gatewayIPv4 = ip.String()
+			} else if key == "ipv6.address:" && ip.To4() == nil {
+				gatewayIPv6 = ip.String()
+			}
 		}
 	}
 
-	return "", fmt.Errorf("could not find ipv4.address in network %s", networkName)
+	return gatewayIPv4, gatewayIPv6
 }
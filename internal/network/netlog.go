@@ -0,0 +1,232 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// Verdicts recorded in the network log
+const (
+	VerdictAllowed = "allowed"
+	VerdictDenied  = "denied"
+	VerdictError   = "error" // The connection log could not be read (connection-log events)
+)
+
+// LogEntry is a single network log record (one JSON object per line)
+type LogEntry struct {
+	Time      time.Time `json:"time"`
+	Container string    `json:"container"`
+	Event     string    `json:"event"`            // "dns", "http", "connect", "tls", "connection" or "connection-log"
+	Domain    string    `json:"domain,omitempty"` // Queried name, requested host or reverse-resolved destination
+	QueryType string    `json:"query_type,omitempty"`
+	IP        string    `json:"ip,omitempty"`       // Destination address (connection events)
+	Protocol  string    `json:"protocol,omitempty"` // "tcp", "udp", ... (connection events)
+	Port      int       `json:"port,omitempty"`
+	Rule      string    `json:"rule,omitempty"`      // Firewall rule that decided a connection (see ruleName)
+	BytesOut  int64     `json:"bytes_out,omitempty"` // Container to destination
+	BytesIn   int64     `json:"bytes_in,omitempty"`  // Destination to container
+	Verdict   string    `json:"verdict"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// NetworkLog appends entries to the network log file ([network.logging] path)
+type NetworkLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewNetworkLog returns a network log for the logging config, or nil if logging is disabled
+func NewNetworkLog(cfg config.NetworkLoggingConfig) *NetworkLog {
+	if !cfg.Enabled || cfg.Path == "" {
+		return nil
+	}
+	return &NetworkLog{path: cfg.Path}
+}
+
+// Record appends an entry to the log; a nil log discards entries
+func (l *NetworkLog) Record(entry LogEntry) error {
+	if l == nil {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open network log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write network log: %w", err)
+	}
+	return nil
+}
+
+// logFollowInterval is how often FollowLog checks the log for new entries
+const logFollowInterval = 500 * time.Millisecond
+
+// LogFilter selects network log entries (zero value matches all)
+type LogFilter struct {
+	Container  string
+	DeniedOnly bool
+}
+
+// Matches returns true if the entry passes the filter
+func (f LogFilter) Matches(entry LogEntry) bool {
+	if f.Container != "" && entry.Container != f.Container {
+		return false
+	}
+	return !f.DeniedOnly || entry.Verdict == VerdictDenied
+}
+
+// ReadLog calls fn for each matching entry of the log file and returns the offset after the
+// last complete line (to continue with FollowLog); lines that are not valid entries are skipped
+func ReadLog(path string, filter LogFilter, fn func(LogEntry)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return readLogFrom(f, filter, fn)
+}
+
+// FollowLog calls fn for each matching entry appended to the log file after offset, until ctx is done
+// Starts over if the file is truncated or replaced by a smaller one (log rotation)
+func FollowLog(ctx context.Context, path string, offset int64, filter LogFilter, fn func(LogEntry)) error {
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+
+	for {
+		info, err := os.Stat(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if info.Size() < offset {
+				offset = 0
+			}
+			if info.Size() > offset {
+				f, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				if _, err := f.Seek(offset, io.SeekStart); err == nil {
+					var n int64
+					n, err = readLogFrom(f, filter, fn)
+					offset += n
+				}
+				f.Close()
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// readLogFrom reads complete lines from r and returns the number of bytes they took
+// A trailing line without a newline is being written and is left for the next read
+func readLogFrom(r io.Reader, filter LogFilter, fn func(LogEntry)) (int64, error) {
+	reader := bufio.NewReader(r)
+	var consumed int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return consumed, nil
+		}
+		if err != nil {
+			return consumed, fmt.Errorf("failed to read network log: %w", err)
+		}
+		consumed += int64(len(line))
+
+		var entry LogEntry
+		if json.Unmarshal(bytes.TrimSpace(line), &entry) != nil {
+			continue
+		}
+		if filter.Matches(entry) {
+			fn(entry)
+		}
+	}
+}
+
+// FormatLogEntry formats an entry as a single line for display, e.g.
+// "2026-01-02 15:04:05 coi-abc-1 denied  connection tcp 1.2.3.4:443 registry.npmjs.org [default-deny]"
+func FormatLogEntry(entry LogEntry) string {
+	fields := []string{
+		entry.Time.Local().Format("2006-01-02 15:04:05"),
+		entry.Container,
+		fmt.Sprintf("%-7s", entry.Verdict),
+		entry.Event,
+	}
+
+	switch entry.Event {
+	case "connection":
+		destination := entry.IP
+		if strings.Contains(destination, ":") {
+			destination = "[" + destination + "]"
+		}
+		if entry.Port != 0 {
+			destination = fmt.Sprintf("%s:%d", destination, entry.Port)
+		}
+		fields = append(fields, entry.Protocol, destination)
+		if entry.Domain != "" {
+			fields = append(fields, entry.Domain)
+		}
+		if entry.Rule != "" {
+			fields = append(fields, "["+entry.Rule+"]")
+		}
+	case "connection-log":
+	case "dns":
+		fields = append(fields, entry.Domain)
+		if entry.QueryType != "" {
+			fields = append(fields, entry.QueryType)
+		}
+	default:
+		destination := entry.Domain
+		if entry.Port != 0 {
+			destination = fmt.Sprintf("%s:%d", destination, entry.Port)
+		}
+		fields = append(fields, destination)
+		if entry.BytesOut != 0 || entry.BytesIn != 0 {
+			fields = append(fields, fmt.Sprintf("out=%d in=%d", entry.BytesOut, entry.BytesIn))
+		}
+	}
+
+	if entry.Error != "" {
+		fields = append(fields, "error: "+entry.Error)
+	}
+	return strings.Join(fields, " ")
+}
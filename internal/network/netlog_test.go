@@ -0,0 +1,194 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+func TestNetworkLogRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "network.log")
+	netLog := NewNetworkLog(config.NetworkLoggingConfig{Enabled: true, Path: path})
+
+	for _, domain := range []string{"evil.com", "pastebin.com"} {
+		if err := netLog.Record(LogEntry{Container: "coi-abc-1", Event: "dns", Domain: domain, Verdict: VerdictDenied}); err != nil {
+			t.Fatalf("Record() failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d", len(lines))
+	}
+
+	var entry LogEntry
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("Invalid JSON line: %v", err)
+	}
+	if entry.Domain != "pastebin.com" || entry.Verdict != VerdictDenied || entry.Time.IsZero() {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+}
+
+func TestNetworkLogDisabled(t *testing.T) {
+	netLog := NewNetworkLog(config.NetworkLoggingConfig{Enabled: false, Path: "/nonexistent/network.log"})
+	if netLog != nil {
+		t.Fatal("Expected nil log when logging is disabled")
+	}
+	if err := netLog.Record(LogEntry{Domain: "evil.com"}); err != nil {
+		t.Errorf("Record() on nil log = %v, want nil", err)
+	}
+}
+
+func TestReadLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.log")
+	netLog := NewNetworkLog(config.NetworkLoggingConfig{Enabled: true, Path: path})
+	for _, entry := range []LogEntry{
+		{Container: "coi-abc-1", Event: "dns", Domain: "evil.com", Verdict: VerdictDenied},
+		{Container: "coi-abc-1", Event: "connection", IP: "1.2.3.4", Port: 443, Verdict: VerdictAllowed},
+		{Container: "coi-abc-2", Event: "connection", IP: "5.6.7.8", Port: 22, Verdict: VerdictDenied},
+	} {
+		if err := netLog.Record(entry); err != nil {
+			t.Fatalf("Record() failed: %v", err)
+		}
+	}
+
+	// A partially written line and invalid lines are not returned
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	info, _ := f.Stat()
+	f.WriteString("not json\n{\"container\":\"coi-abc-1\"")
+	f.Close()
+
+	tests := []struct {
+		filter LogFilter
+		want   []string
+	}{
+		{LogFilter{}, []string{"evil.com", "1.2.3.4", "5.6.7.8"}},
+		{LogFilter{Container: "coi-abc-1"}, []string{"evil.com", "1.2.3.4"}},
+		{LogFilter{DeniedOnly: true}, []string{"evil.com", "5.6.7.8"}},
+		{LogFilter{Container: "coi-abc-1", DeniedOnly: true}, []string{"evil.com"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		offset, err := ReadLog(path, tt.filter, func(entry LogEntry) {
+			got = append(got, entry.Domain+entry.IP)
+		})
+		if err != nil {
+			t.Fatalf("ReadLog() failed: %v", err)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("ReadLog(%+v) = %v, want %v", tt.filter, got, tt.want)
+		}
+		if want := info.Size() + int64(len("not json\n")); offset != want {
+			t.Errorf("ReadLog() offset = %d, want %d", offset, want)
+		}
+	}
+
+	if _, err := ReadLog(filepath.Join(t.TempDir(), "missing.log"), LogFilter{}, func(LogEntry) {}); !os.IsNotExist(err) {
+		t.Errorf("ReadLog() on missing file = %v, want not exist error", err)
+	}
+}
+
+func TestFollowLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.log")
+	netLog := NewNetworkLog(config.NetworkLoggingConfig{Enabled: true, Path: path})
+	if err := netLog.Record(LogEntry{Container: "coi-abc-1", Domain: "old.com", Verdict: VerdictDenied}); err != nil {
+		t.Fatalf("Record() failed: %v", err)
+	}
+	offset, err := ReadLog(path, LogFilter{}, func(LogEntry) {})
+	if err != nil {
+		t.Fatalf("ReadLog() failed: %v", err)
+	}
+
+	entries := make(chan LogEntry, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- FollowLog(ctx, path, offset, LogFilter{DeniedOnly: true}, func(entry LogEntry) { entries <- entry })
+	}()
+
+	next := func() string {
+		select {
+		case entry := <-entries:
+			return entry.Domain
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a followed entry")
+			return ""
+		}
+	}
+
+	netLog.Record(LogEntry{Container: "coi-abc-1", Domain: "allowed.com", Verdict: VerdictAllowed})
+	netLog.Record(LogEntry{Container: "coi-abc-1", Domain: "new.com", Verdict: VerdictDenied})
+	if got := next(); got != "new.com" {
+		t.Errorf("FollowLog() entry = %q, want %q", got, "new.com")
+	}
+
+	// After truncation (rotation), the log is read from the start
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("Failed to truncate log: %v", err)
+	}
+	time.Sleep(2 * logFollowInterval)
+	netLog.Record(LogEntry{Container: "coi-abc-1", Domain: "rotated.com", Verdict: VerdictDenied})
+	if got := next(); got != "rotated.com" {
+		t.Errorf("FollowLog() entry after truncation = %q, want %q", got, "rotated.com")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("FollowLog() = %v, want nil", err)
+	}
+}
+
+func TestFormatLogEntry(t *testing.T) {
+	ts := time.Date(2026, 1, 2, 15, 4, 5, 0, time.Local)
+	tests := []struct {
+		entry LogEntry
+		want  string
+	}{
+		{
+			LogEntry{Time: ts, Container: "coi-abc-1", Event: "connection", IP: "1.2.3.4", Protocol: "tcp", Port: 443,
+				Domain: "registry.npmjs.org", Rule: "default-deny", Verdict: VerdictDenied},
+			"2026-01-02 15:04:05 coi-abc-1 denied  connection tcp 1.2.3.4:443 registry.npmjs.org [default-deny]",
+		},
+		{
+			LogEntry{Time: ts, Container: "coi-abc-1", Event: "connection", IP: "2606:4700::1111", Protocol: "icmpv6", Rule: "allow", Verdict: VerdictAllowed},
+			"2026-01-02 15:04:05 coi-abc-1 allowed connection icmpv6 [2606:4700::1111] [allow]",
+		},
+		{
+			LogEntry{Time: ts, Container: "coi-abc-1", Event: "dns", Domain: "evil.com", QueryType: "A", Verdict: VerdictDenied},
+			"2026-01-02 15:04:05 coi-abc-1 denied  dns evil.com A",
+		},
+		{
+			LogEntry{Time: ts, Container: "coi-abc-1", Event: "connect", Domain: "github.com", Port: 443, BytesOut: 10, BytesIn: 20, Verdict: VerdictAllowed},
+			"2026-01-02 15:04:05 coi-abc-1 allowed connect github.com:443 out=10 in=20",
+		},
+		{
+			LogEntry{Time: ts, Container: "coi-abc-1", Event: "http", Domain: "evil.com", Port: 80, Verdict: VerdictDenied, Error: "not allowed"},
+			"2026-01-02 15:04:05 coi-abc-1 denied  http evil.com:80 error: not allowed",
+		},
+		{
+			LogEntry{Time: ts, Container: "coi-abc-1", Event: "connection-log", Verdict: VerdictError, Error: "sudo: a password is required"},
+			"2026-01-02 15:04:05 coi-abc-1 error   connection-log error: sudo: a password is required",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := FormatLogEntry(tt.entry); got != tt.want {
+			t.Errorf("FormatLogEntry() =\n%q\nwant\n%q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package network
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// errNFTablesNotAvailable is the user-facing error message when nft cannot be used
+const errNFTablesNotAvailable = `nftables is not available
+
+Network isolation with backend = "nftables" requires the nft command.
+
+To fix this:
+  1. Install nftables: sudo apt install nftables
+  2. Configure passwordless sudo for nft (see README)
+
+Alternatively, use another backend in ~/.config/coi/config.toml:
+  [network]
+  backend = "firewalld"   # or "incus-acl"`
+
+// nftTable is the dedicated table holding all coi rules
+// A separate table keeps coi rules away from the host's own ruleset; a reject here is final,
+// while an accept only ends evaluation of this table
+const nftTable = "inet coi"
+
+// NFTablesBackend isolates a container with a per-container chain in the coi nftables table
+// The forward chain jumps to the container's chain by source IP (IPv4 and IPv6), and allowlisted
+// destinations live in per-container sets (one per address family) so they can be updated in one go
+type NFTablesBackend struct {
+	addrs   ContainerAddresses
+	logging bool
+}
+
+// NewNFTablesBackend creates an nftables backend for a container
+func NewNFTablesBackend(addrs ContainerAddresses) *NFTablesBackend {
+	return &NFTablesBackend{addrs: addrs}
+}
+
+func (n *NFTablesBackend) Name() string {
+	return string(config.NetworkBackendNFTables)
+}
+
+// EnsureBaseRules creates the coi table and its forward chain with the conntrack rule
+func (n *NFTablesBackend) EnsureBaseRules() error {
+	// The table only needs to be created once - re-adding would duplicate the conntrack rule
+	if _, err := nftOutput("list", "table", "inet", "coi"); err == nil {
+		return nil
+	}
+
+	script := `table inet coi {
+	chain forward {
+		type filter hook forward priority filter; policy accept;
+		ct state established,related accept
+	}
+}
+`
+	if err := runNFT(script); err != nil {
+		return fmt.Errorf("failed to create nftables table: %w", err)
+	}
+	return nil
+}
+
+// ApplyOpen needs no rules: the coi forward chain accepts by default
+func (n *NFTablesBackend) ApplyOpen() error {
+	return n.EnsureBaseRules()
+}
+
+// ApplyRestricted applies restricted mode rules (block private networks, allow internet)
+func (n *NFTablesBackend) ApplyRestricted(cfg *config.NetworkConfig, exceptions []AllowEntry) error {
+	return n.applyRules(restrictedRules(cfg, n.addrs, exceptions))
+}
+
+// ApplyAllowlist applies allowlist mode rules (allow specific IPs, block all else)
+func (n *NFTablesBackend) ApplyAllowlist(cfg *config.NetworkConfig, allowed []AllowEntry) error {
+	return n.applyRules(allowlistRules(cfg, n.addrs, allowed))
+}
+
+// SetLogging adds a log rule in front of each rule applied afterwards
+func (n *NFTablesBackend) SetLogging(enabled bool) error {
+	n.logging = enabled
+	return nil
+}
+
+// applyRules replaces the container's chain and set with the given rules in one transaction
+func (n *NFTablesBackend) applyRules(rules []Rule) error {
+	if err := n.EnsureBaseRules(); err != nil {
+		return err
+	}
+
+	// Drop leftovers from a previous container that had the same IP
+	if err := n.RemoveRules(); err != nil {
+		return err
+	}
+
+	if err := runNFT(nftApplyScript(n.addrs, rules, n.logging)); err != nil {
+		return fmt.Errorf("failed to apply nftables rules: %w", err)
+	}
+	return nil
+}
+
+// AllowDestination adds a single address to the container's dynamic set, expiring after ttl
+func (n *NFTablesBackend) AllowDestination(ip string, ttl time.Duration) error {
+	if err := runNFT(nftAllowElementScript(n.addrs.IPv4, ip, ttl)); err != nil {
+		return fmt.Errorf("failed to allow %s: %w", ip, err)
+	}
+	return nil
+}
+
+// RevokeDestination removes an address added with AllowDestination
+func (n *NFTablesBackend) RevokeDestination(ip string) error {
+	if err := runNFT(nftDynamicElementScript("delete", n.addrs.IPv4, ip)); err != nil {
+		return fmt.Errorf("failed to revoke %s: %w", ip, err)
+	}
+	return nil
+}
+
+// Redirect sends the container's traffic to gateway ports via a per-container nat chain
+func (n *NFTablesBackend) Redirect(redirects []PortRedirect) error {
+	if n.addrs.IPv4 == "" || n.addrs.GatewayIPv4 == "" {
+		return fmt.Errorf("redirects require the container and gateway IPv4 addresses")
+	}
+	if err := runNFT(nftRedirectScript(n.addrs, redirects)); err != nil {
+		return fmt.Errorf("failed to add redirect: %w", err)
+	}
+	return nil
+}
+
+// RemoveRules deletes the container's chains, sets and the jumps to them
+func (n *NFTablesBackend) RemoveRules() error {
+	if n.addrs.IPv4 == "" {
+		return nil
+	}
+
+	chain := nftChainName(n.addrs.IPv4)
+
+	// The redirect chain only exists when a proxy was used
+	natChain := chain + "_nat"
+	if _, err := nftOutput("list", "chain", "inet", "coi", natChain); err == nil {
+		prerouting, err := nftOutput("-a", "list", "chain", "inet", "coi", "prerouting")
+		if err != nil {
+			return fmt.Errorf("failed to list nftables rules: %w", err)
+		}
+		if err := runNFT(nftRemoveNATScript(natChain, nftJumpHandles(prerouting, natChain))); err != nil {
+			return fmt.Errorf("failed to remove nftables redirects: %w", err)
+		}
+	}
+
+	// Nothing to do if the table or chain was never created
+	if _, err := nftOutput("list", "chain", "inet", "coi", chain); err != nil {
+		return nil
+	}
+
+	forward, err := nftOutput("-a", "list", "chain", "inet", "coi", "forward")
+	if err != nil {
+		return fmt.Errorf("failed to list nftables rules: %w", err)
+	}
+
+	if err := runNFT(nftRemoveScript(chain, nftJumpHandles(forward, chain))); err != nil {
+		return fmt.Errorf("failed to remove nftables rules: %w", err)
+	}
+	return nil
+}
+
+// ListRules returns the container's chains and sets as listed by nft
+func (n *NFTablesBackend) ListRules() ([]string, error) {
+	if n.addrs.IPv4 == "" {
+		return nil, nil
+	}
+
+	chain := nftChainName(n.addrs.IPv4)
+	objects := [][]string{{"chain", chain}, {"chain", chain + "_nat"}}
+	for _, family := range nftFamilies {
+		objects = append(objects, []string{"set", chain + family.setSuffix}, []string{"set", chain + family.dynSetSuffix})
+	}
+
+	var lines []string
+	for _, object := range objects {
+		// Objects that were never created (e.g. no redirects) are skipped
+		listing, err := nftOutput("list", object[0], "inet", "coi", object[1])
+		if err != nil {
+			continue
+		}
+		lines = append(lines, nftListingLines(listing)...)
+	}
+	return lines, nil
+}
+
+// nftListingLines returns the lines of `nft list` output inside the table block
+func nftListingLines(listing string) []string {
+	var lines []string
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "}" || strings.HasPrefix(line, "table ") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// nftChainName returns the per-container chain name, derived from the container's IPv4 address
+// like firewalld rules, so rules can be removed knowing only the IP
+func nftChainName(containerIP string) string {
+	return "ctr_" + strings.NewReplacer(".", "_", ":", "_").Replace(containerIP)
+}
+
+// nftFamilies maps each address family to its nft match keyword, set type and set name suffixes
+var nftFamilies = []struct {
+	ipv6         bool
+	match        string
+	setType      string
+	setSuffix    string
+	dynSetSuffix string
+}{
+	{ipv6: false, match: "ip", setType: "ipv4_addr", setSuffix: "_allow", dynSetSuffix: "_dyn"},
+	{ipv6: true, match: "ip6", setType: "ipv6_addr", setSuffix: "_allow6", dynSetSuffix: "_dyn6"},
+}
+
+// nftApplyScript builds the nft script creating a container's chain, sets and jump rules
+// Priority-ordered rules become chain rules in the same order; priorityAllow accepts (without
+// a port) go into the <chain>_allow (IPv4) and <chain>_allow6 (IPv6) sets, each matched by a single rule
+// The <chain>_dyn and <chain>_dyn6 sets hold destinations added later (AllowDestination)
+// and are matched at the same position
+// Both families share the chain: ip rules never match IPv6 packets and vice versa
+// With logging, each rule (and each set match) is preceded by a rate-limited log rule with the same match
+func nftApplyScript(addrs ContainerAddresses, rules []Rule, logging bool) string {
+	chain := nftChainName(addrs.IPv4)
+
+	sorted := make([]Rule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "add chain %s %s\n", nftTable, chain)
+	for _, family := range nftFamilies {
+		set := chain + family.setSuffix
+		fmt.Fprintf(&b, "add set %s %s { type %s; flags interval; auto-merge; }\n", nftTable, set, family.setType)
+
+		var allowed []string
+		for _, rule := range sorted {
+			if isSetRule(rule) && rule.IPv6() == family.ipv6 {
+				allowed = append(allowed, rule.Destination)
+			}
+		}
+		if len(allowed) > 0 {
+			fmt.Fprintf(&b, "add element %s %s { %s }\n", nftTable, set, strings.Join(allowed, ", "))
+		}
+	}
+	for _, family := range nftFamilies {
+		// Plain sets (no intervals) so single addresses can be deleted again; elements time out
+		// in the kernel, so they expire even when the session is gone
+		fmt.Fprintf(&b, "add set %s %s { type %s; flags timeout; }\n", nftTable, chain+family.dynSetSuffix, family.setType)
+	}
+
+	addRule := func(match string, rule Rule, verdict string) {
+		if logging && loggedRule(rule) {
+			fmt.Fprintf(&b, "add rule %s %s %s limit rate %s/second burst %s packets log prefix \"%s\"\n",
+				nftTable, chain, match, logRateLimit, logRateBurst, logPrefix(rule))
+		}
+		fmt.Fprintf(&b, "add rule %s %s %s %s\n", nftTable, chain, match, verdict)
+	}
+
+	setRulesAdded := false
+	addSetRules := func() {
+		setRule := Rule{Priority: priorityAllow, Action: RuleAccept}
+		for _, family := range nftFamilies {
+			addRule(fmt.Sprintf("%s daddr @%s", family.match, chain+family.setSuffix), setRule, "accept")
+		}
+		for _, family := range nftFamilies {
+			addRule(fmt.Sprintf("%s daddr @%s", family.match, chain+family.dynSetSuffix), setRule, "accept")
+		}
+		setRulesAdded = true
+	}
+
+	for _, rule := range sorted {
+		if !setRulesAdded && rule.Priority >= priorityAllow {
+			addSetRules()
+		}
+		if isSetRule(rule) {
+			continue
+		}
+
+		match := "ip"
+		if rule.IPv6() {
+			match = "ip6"
+		}
+		verdict := "accept"
+		if rule.Action == RuleReject {
+			verdict = "reject"
+		}
+		ports := ""
+		if rule.PortLimited() {
+			ports = fmt.Sprintf(" %s dport %d", rule.Protocol, rule.Port)
+		}
+		addRule(fmt.Sprintf("%s daddr %s%s", match, rule.Destination, ports), rule, verdict)
+	}
+	if !setRulesAdded {
+		addSetRules()
+	}
+
+	fmt.Fprintf(&b, "add rule %s forward ip saddr %s jump %s\n", nftTable, addrs.IPv4, chain)
+	if addrs.IPv6 != "" {
+		fmt.Fprintf(&b, "add rule %s forward ip6 saddr %s jump %s\n", nftTable, addrs.IPv6, chain)
+	}
+
+	return b.String()
+}
+
+// nftDynamicElementScript builds the nft command adding or deleting an address in a dynamic set
+func nftDynamicElementScript(op, containerIP, ip string) string {
+	return fmt.Sprintf("%s element %s %s { %s }\n", op, nftTable, nftDynamicSet(containerIP, ip), ip)
+}
+
+// nftAllowElementScript builds the nft script adding an address to a dynamic set with a timeout
+// Adding an existing element keeps its old timeout, so the element is replaced (add first, so the
+// delete cannot fail); the script is one transaction, so the address is never missing
+func nftAllowElementScript(containerIP, ip string, ttl time.Duration) string {
+	set := nftDynamicSet(containerIP, ip)
+	seconds := max(1, int(math.Ceil(ttl.Seconds())))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "add element %s %s { %s timeout %ds }\n", nftTable, set, ip, seconds)
+	fmt.Fprintf(&b, "delete element %s %s { %s }\n", nftTable, set, ip)
+	fmt.Fprintf(&b, "add element %s %s { %s timeout %ds }\n", nftTable, set, ip, seconds)
+	return b.String()
+}
+
+// nftDynamicSet returns the dynamic set of a container for an address's family
+func nftDynamicSet(containerIP, ip string) string {
+	if strings.Contains(ip, ":") {
+		return nftChainName(containerIP) + nftFamilies[1].dynSetSuffix
+	}
+	return nftChainName(containerIP) + nftFamilies[0].dynSetSuffix
+}
+
+// nftRedirectScript builds the nft script redirecting a container's traffic to gateway ports
+// The prerouting nat chain is created on first use so hosts without redirects never get it
+func nftRedirectScript(addrs ContainerAddresses, redirects []PortRedirect) string {
+	natChain := nftChainName(addrs.IPv4) + "_nat"
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "add chain %s prerouting { type nat hook prerouting priority dstnat; policy accept; }\n", nftTable)
+	fmt.Fprintf(&b, "add chain %s %s\n", nftTable, natChain)
+	for _, redirect := range redirects {
+		fmt.Fprintf(&b, "add rule %s %s meta l4proto { %s } th dport %d dnat ip to %s:%d\n",
+			nftTable, natChain, strings.Join(redirect.Protocols, ", "), redirect.Port, addrs.GatewayIPv4, redirect.ToPort)
+	}
+	fmt.Fprintf(&b, "add rule %s prerouting ip saddr %s jump %s\n", nftTable, addrs.IPv4, natChain)
+	return b.String()
+}
+
+// isSetRule returns true if the rule's destination goes into a per-container allow set
+// Port-limited rules stay chain rules since the sets only hold addresses
+func isSetRule(rule Rule) bool {
+	return rule.Priority == priorityAllow && rule.Action == RuleAccept && !rule.PortLimited()
+}
+
+// nftRemoveScript builds the nft script deleting a container's jump rules, chain and sets (static and dynamic)
+func nftRemoveScript(chain string, jumpHandles []string) string {
+	var b strings.Builder
+	for _, handle := range jumpHandles {
+		fmt.Fprintf(&b, "delete rule %s forward handle %s\n", nftTable, handle)
+	}
+	fmt.Fprintf(&b, "flush chain %s %s\n", nftTable, chain)
+	fmt.Fprintf(&b, "delete chain %s %s\n", nftTable, chain)
+	for _, family := range nftFamilies {
+		fmt.Fprintf(&b, "delete set %s %s%s\n", nftTable, chain, family.setSuffix)
+		fmt.Fprintf(&b, "delete set %s %s%s\n", nftTable, chain, family.dynSetSuffix)
+	}
+	return b.String()
+}
+
+// nftRemoveNATScript builds the nft script deleting a container's redirect chain and its jumps
+func nftRemoveNATScript(natChain string, jumpHandles []string) string {
+	var b strings.Builder
+	for _, handle := range jumpHandles {
+		fmt.Fprintf(&b, "delete rule %s prerouting handle %s\n", nftTable, handle)
+	}
+	fmt.Fprintf(&b, "flush chain %s %s\n", nftTable, natChain)
+	fmt.Fprintf(&b, "delete chain %s %s\n", nftTable, natChain)
+	return b.String()
+}
+
+// nftHandlePattern matches the handle annotation of `nft -a list` output
+var nftHandlePattern = regexp.MustCompile(`# handle (\d+)\s*$`)
+
+// nftJumpHandles returns the handles of rules jumping to a chain from `nft -a list chain` output
+func nftJumpHandles(listing, chain string) []string {
+	var handles []string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		isJump := false
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "jump" && fields[i+1] == chain {
+				isJump = true
+				break
+			}
+		}
+		if !isJump {
+			continue
+		}
+		if matches := nftHandlePattern.FindStringSubmatch(line); len(matches) == 2 {
+			handles = append(handles, matches[1])
+		}
+	}
+	return handles
+}
+
+// runNFT feeds a script to nft -f -
+func runNFT(script string) error {
+	cmd := exec.Command("sudo", "-n", "nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nft failed: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// nftOutput runs an nft command and returns its output
+func nftOutput(args ...string) (string, error) {
+	cmd := exec.Command("sudo", append([]string{"-n", "nft"}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("nft %s failed: %s: %w", strings.Join(args, " "), strings.TrimSpace(string(output)), err)
+	}
+	return string(output), nil
+}
+
+// NFTablesAvailable checks if nft can be run (via passwordless sudo)
+func NFTablesAvailable() bool {
+	cmd := exec.Command("sudo", "-n", "nft", "list", "tables")
+	return cmd.Run() == nil
+}
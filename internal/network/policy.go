@@ -0,0 +1,256 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// Policy is the network policy a session applied to its container
+// It is written only by the session (see Manager) so `coi network` can inspect it; changes
+// are requested through a PolicyUpdate, which the session applies and acknowledges here
+type Policy struct {
+	Container string                `json:"container"`
+	Mode      config.NetworkMode    `json:"mode"`
+	Backend   config.NetworkBackend `json:"backend,omitempty"` // Resolved backend ("" in open mode)
+	Addresses ContainerAddresses    `json:"addresses"`
+	DNSProxy  bool                  `json:"dns_proxy,omitempty"`
+
+	AllowedDomains []string `json:"allowed_domains,omitempty"` // Allowlist and proxy modes
+	Exceptions     []string `json:"exceptions,omitempty"`      // Restricted mode
+
+	PID       int       `json:"pid"`      // Session process enforcing the policy
+	Revision  int       `json:"revision"` // Last applied PolicyUpdate revision
+	AppliedAt time.Time `json:"applied_at"`
+	Error     string    `json:"error,omitempty"` // Error of the last update, if it failed
+}
+
+// PolicyUpdate is a change requested by `coi network allow|deny|refresh`
+// The lists replace the policy's lists; a new revision with unchanged lists re-resolves domains
+type PolicyUpdate struct {
+	Revision       int       `json:"revision"`
+	AllowedDomains []string  `json:"allowed_domains"`
+	Exceptions     []string  `json:"exceptions"`
+	RequestedAt    time.Time `json:"requested_at"`
+}
+
+// Editable returns true if the policy's mode has a list that can be edited
+func (p *Policy) Editable() bool {
+	return p.Mode != config.NetworkModeOpen
+}
+
+// Entries returns the editable list of the policy's mode (exceptions in restricted mode)
+func (p *Policy) Entries() []string {
+	if p.Mode == config.NetworkModeRestricted {
+		return p.Exceptions
+	}
+	return p.AllowedDomains
+}
+
+// SessionRunning returns true if the session process that applied the policy is still alive
+func (p *Policy) SessionRunning() bool {
+	if p.PID <= 0 {
+		return false
+	}
+	err := syscall.Kill(p.PID, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// NewPolicyUpdate builds the next update for a policy, starting from a pending update if there is one
+// edit receives the current list (exceptions in restricted mode) and returns the new one
+func NewPolicyUpdate(policy *Policy, pending *PolicyUpdate, edit func([]string) ([]string, error)) (*PolicyUpdate, error) {
+	update := &PolicyUpdate{
+		Revision:       policy.Revision + 1,
+		AllowedDomains: policy.AllowedDomains,
+		Exceptions:     policy.Exceptions,
+	}
+	if pending != nil && pending.Revision > policy.Revision {
+		update.Revision = pending.Revision + 1
+		update.AllowedDomains = pending.AllowedDomains
+		update.Exceptions = pending.Exceptions
+	}
+
+	if edit != nil {
+		var err error
+		if policy.Mode == config.NetworkModeRestricted {
+			update.Exceptions, err = edit(update.Exceptions)
+		} else {
+			update.AllowedDomains, err = edit(update.AllowedDomains)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	update.RequestedAt = time.Now().UTC()
+	return update, nil
+}
+
+// AddEntry adds an allowlist entry (validated) unless it is already listed
+func AddEntry(entries []string, entry string) ([]string, error) {
+	parsed, err := ParseAllowEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range entries {
+		if e, err := ParseAllowEntry(existing); err == nil && e == parsed {
+			return nil, fmt.Errorf("%s is already allowed", parsed)
+		}
+	}
+	return append(append([]string{}, entries...), parsed.String()), nil
+}
+
+// RemoveEntry removes the entries for a host (all ports), or a single entry if it has a port
+func RemoveEntry(entries []string, entry string) ([]string, error) {
+	parsed, err := ParseAllowEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, existing := range entries {
+		e, err := ParseAllowEntry(existing)
+		if err == nil && strings.EqualFold(e.Host, parsed.Host) && (parsed.Port == 0 || e == parsed) {
+			continue
+		}
+		result = append(result, existing)
+	}
+	if len(result) == len(entries) {
+		return nil, fmt.Errorf("%s is not in the list", parsed)
+	}
+	return result, nil
+}
+
+// policyPath returns the path of a container's policy file
+func (c *CacheManager) policyPath(containerName string) string {
+	return filepath.Join(c.cacheDir, containerName+".policy.json")
+}
+
+// updatePath returns the path of a container's pending policy update
+func (c *CacheManager) updatePath(containerName string) string {
+	return filepath.Join(c.cacheDir, containerName+".update.json")
+}
+
+// LoadPolicy reads the policy of a container (an error matching os.ErrNotExist if there is none)
+func (c *CacheManager) LoadPolicy(containerName string) (*Policy, error) {
+	var policy Policy
+	if err := readJSON(c.policyPath(containerName), &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SavePolicy writes the policy of a container
+func (c *CacheManager) SavePolicy(policy *Policy) error {
+	return c.writeJSON(c.policyPath(policy.Container), policy)
+}
+
+// ListPolicies returns all saved policies, sorted by container name
+func (c *CacheManager) ListPolicies() ([]*Policy, error) {
+	paths, err := filepath.Glob(filepath.Join(c.cacheDir, "*.policy.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var policies []*Policy
+	for _, path := range paths {
+		var policy Policy
+		if err := readJSON(path, &policy); err != nil {
+			continue // Being written or corrupt - skip
+		}
+		policies = append(policies, &policy)
+	}
+	return policies, nil
+}
+
+// LoadPolicyUpdate reads the pending update of a container (nil if there is none)
+func (c *CacheManager) LoadPolicyUpdate(containerName string) (*PolicyUpdate, error) {
+	var update PolicyUpdate
+	if err := readJSON(c.updatePath(containerName), &update); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &update, nil
+}
+
+// SavePolicyUpdate writes a pending update for a container
+func (c *CacheManager) SavePolicyUpdate(containerName string, update *PolicyUpdate) error {
+	return c.writeJSON(c.updatePath(containerName), update)
+}
+
+// DeletePolicy removes the policy and pending update of a container
+func (c *CacheManager) DeletePolicy(containerName string) error {
+	for _, path := range []string{c.policyPath(containerName), c.updatePath(containerName)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// WaitForRevision waits until the session has applied an update revision
+// Fails if the session exits or does not apply the update within the timeout
+func (c *CacheManager) WaitForRevision(containerName string, revision int, timeout time.Duration) (*Policy, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		policy, err := c.LoadPolicy(containerName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy: %w", err)
+		}
+		if policy.Revision >= revision {
+			return policy, nil
+		}
+		if !policy.SessionRunning() {
+			return nil, fmt.Errorf("the session for %s is no longer running", containerName)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the session to apply the update (is its 'coi shell' process still running?)")
+		}
+		time.Sleep(policyPollInterval / 4)
+	}
+}
+
+// readJSON decodes a JSON file
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSON writes a JSON file atomically (temp file and rename), so readers never see partial files
+func (c *CacheManager) writeJSON(path string, v any) error {
+	if err := os.MkdirAll(c.cacheDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,467 @@
+package network
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// recordingFirewall is a FirewallBackend recording the calls made to it
+type recordingFirewall struct {
+	calls   []string
+	allowed []AllowEntry
+}
+
+func (f *recordingFirewall) Name() string                  { return "recording" }
+func (f *recordingFirewall) EnsureBaseRules() error        { return nil }
+func (f *recordingFirewall) ApplyOpen() error              { return nil }
+func (f *recordingFirewall) ListRules() ([]string, error)  { return nil, nil }
+func (f *recordingFirewall) SetLogging(enabled bool) error { return nil }
+
+func (f *recordingFirewall) ApplyRestricted(cfg *config.NetworkConfig, exceptions []AllowEntry) error {
+	f.calls = append(f.calls, "restricted")
+	f.allowed = exceptions
+	return nil
+}
+
+func (f *recordingFirewall) ApplyAllowlist(cfg *config.NetworkConfig, allowed []AllowEntry) error {
+	f.calls = append(f.calls, "allowlist")
+	f.allowed = allowed
+	return nil
+}
+
+func (f *recordingFirewall) AllowDestination(ip string, ttl time.Duration) error {
+	f.calls = append(f.calls, "allow "+ip)
+	return nil
+}
+
+func (f *recordingFirewall) RevokeDestination(ip string) error {
+	f.calls = append(f.calls, "revoke "+ip)
+	return nil
+}
+
+func (f *recordingFirewall) Redirect(redirects []PortRedirect) error {
+	f.calls = append(f.calls, "redirect")
+	return nil
+}
+
+func (f *recordingFirewall) RemoveRules() error {
+	f.calls = append(f.calls, "remove")
+	return nil
+}
+
+func TestPolicyStore(t *testing.T) {
+	cacheManager := NewCacheManager(t.TempDir())
+
+	if _, err := cacheManager.LoadPolicy("coi-test-1"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadPolicy() of missing policy = %v, want os.ErrNotExist", err)
+	}
+	if update, err := cacheManager.LoadPolicyUpdate("coi-test-1"); update != nil || err != nil {
+		t.Errorf("LoadPolicyUpdate() of missing update = %v, %v, want nil, nil", update, err)
+	}
+
+	policy := &Policy{
+		Container:      "coi-test-1",
+		Mode:           config.NetworkModeAllowlist,
+		Backend:        config.NetworkBackendNFTables,
+		Addresses:      ContainerAddresses{IPv4: "10.0.0.5", GatewayIPv4: "10.0.0.1"},
+		AllowedDomains: []string{"api.example.com"},
+		PID:            os.Getpid(),
+		Revision:       2,
+		AppliedAt:      time.Now().UTC().Truncate(time.Second),
+	}
+	if err := cacheManager.SavePolicy(policy); err != nil {
+		t.Fatalf("SavePolicy() failed: %v", err)
+	}
+	if err := cacheManager.SavePolicy(&Policy{Container: "coi-test-2", Mode: config.NetworkModeOpen}); err != nil {
+		t.Fatalf("SavePolicy() failed: %v", err)
+	}
+
+	loaded, err := cacheManager.LoadPolicy("coi-test-1")
+	if err != nil {
+		t.Fatalf("LoadPolicy() failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, policy) {
+		t.Errorf("LoadPolicy() = %+v, want %+v", loaded, policy)
+	}
+	if !loaded.SessionRunning() {
+		t.Error("Expected the session of the current process to be running")
+	}
+
+	policies, err := cacheManager.ListPolicies()
+	if err != nil || len(policies) != 2 || policies[0].Container != "coi-test-1" {
+		t.Errorf("ListPolicies() = %v, %v, want 2 policies sorted by container", policies, err)
+	}
+
+	update := &PolicyUpdate{Revision: 3, AllowedDomains: []string{"api.example.com", "example.org"}}
+	if err := cacheManager.SavePolicyUpdate("coi-test-1", update); err != nil {
+		t.Fatalf("SavePolicyUpdate() failed: %v", err)
+	}
+	if loaded, err := cacheManager.LoadPolicyUpdate("coi-test-1"); err != nil || loaded.Revision != 3 {
+		t.Errorf("LoadPolicyUpdate() = %+v, %v, want revision 3", loaded, err)
+	}
+
+	if err := cacheManager.DeletePolicy("coi-test-1"); err != nil {
+		t.Fatalf("DeletePolicy() failed: %v", err)
+	}
+	if _, err := cacheManager.LoadPolicy("coi-test-1"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the policy to be deleted, got %v", err)
+	}
+	if update, _ := cacheManager.LoadPolicyUpdate("coi-test-1"); update != nil {
+		t.Error("Expected the pending update to be deleted")
+	}
+}
+
+func TestSessionRunning(t *testing.T) {
+	if (&Policy{}).SessionRunning() {
+		t.Error("Expected a policy without PID not to be running")
+	}
+	if !(&Policy{PID: os.Getpid()}).SessionRunning() {
+		t.Error("Expected the current process to be running")
+	}
+}
+
+func TestNewPolicyUpdate(t *testing.T) {
+	policy := &Policy{Mode: config.NetworkModeAllowlist, AllowedDomains: []string{"a.com"}, Revision: 4}
+	add := func(entry string) func([]string) ([]string, error) {
+		return func(entries []string) ([]string, error) { return AddEntry(entries, entry) }
+	}
+
+	update, err := NewPolicyUpdate(policy, nil, add("b.com"))
+	if err != nil {
+		t.Fatalf("NewPolicyUpdate() failed: %v", err)
+	}
+	if update.Revision != 5 || !reflect.DeepEqual(update.AllowedDomains, []string{"a.com", "b.com"}) {
+		t.Errorf("NewPolicyUpdate() = %+v, want revision 5 with a.com, b.com", update)
+	}
+	if !reflect.DeepEqual(policy.AllowedDomains, []string{"a.com"}) {
+		t.Errorf("Expected the policy to be left unchanged, got %v", policy.AllowedDomains)
+	}
+
+	// A pending update is built upon so concurrent edits are not lost
+	next, err := NewPolicyUpdate(policy, update, add("c.com"))
+	if err != nil {
+		t.Fatalf("NewPolicyUpdate() failed: %v", err)
+	}
+	if next.Revision != 6 || !reflect.DeepEqual(next.AllowedDomains, []string{"a.com", "b.com", "c.com"}) {
+		t.Errorf("NewPolicyUpdate() with pending update = %+v", next)
+	}
+
+	// An already applied update is ignored
+	stale := &PolicyUpdate{Revision: 3, AllowedDomains: []string{"old.com"}}
+	refresh, err := NewPolicyUpdate(policy, stale, nil)
+	if err != nil || refresh.Revision != 5 || !reflect.DeepEqual(refresh.AllowedDomains, []string{"a.com"}) {
+		t.Errorf("NewPolicyUpdate() refresh = %+v, %v", refresh, err)
+	}
+
+	// Restricted mode edits the exceptions
+	restricted := &Policy{Mode: config.NetworkModeRestricted, AllowedDomains: []string{"a.com"}}
+	update, err = NewPolicyUpdate(restricted, nil, add("10.0.5.12:5432"))
+	if err != nil || !reflect.DeepEqual(update.Exceptions, []string{"10.0.5.12:5432/tcp"}) || len(update.AllowedDomains) != 1 {
+		t.Errorf("NewPolicyUpdate() in restricted mode = %+v, %v", update, err)
+	}
+
+	if _, err := NewPolicyUpdate(policy, nil, add("a.com")); err == nil {
+		t.Error("Expected error when the edit fails")
+	}
+}
+
+func TestAddEntry(t *testing.T) {
+	entries, err := AddEntry([]string{"a.com"}, "B.com:443")
+	if err != nil || !reflect.DeepEqual(entries, []string{"a.com", "B.com:443/tcp"}) {
+		t.Errorf("AddEntry() = %v, %v", entries, err)
+	}
+
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{"duplicate", "a.com"},
+		{"duplicate in another form", "c.com:443"},
+		{"invalid", "a.com:99999"},
+		{"empty", " "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := AddEntry([]string{"a.com", "c.com:443/tcp"}, tt.entry); err == nil {
+				t.Errorf("AddEntry(%q) expected error", tt.entry)
+			}
+		})
+	}
+}
+
+func TestRemoveEntry(t *testing.T) {
+	entries := []string{"a.com", "b.com:443", "b.com:8443/tcp", "10.0.0.0/8"}
+
+	tests := []struct {
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{"A.com", []string{"b.com:443", "b.com:8443/tcp", "10.0.0.0/8"}, false},
+		{"b.com", []string{"a.com", "10.0.0.0/8"}, false},
+		{"b.com:443", []string{"a.com", "b.com:8443/tcp", "10.0.0.0/8"}, false},
+		{"10.0.0.0/8", []string{"a.com", "b.com:443", "b.com:8443/tcp"}, false},
+		{"b.com:80", nil, true},
+		{"c.com", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := RemoveEntry(entries, tt.entry)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("RemoveEntry(%q) error = %v, wantErr %v", tt.entry, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RemoveEntry(%q) = %v, want %v", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPolicyUpdate(t *testing.T) {
+	cacheManager := NewCacheManager(t.TempDir())
+	firewall := &recordingFirewall{}
+
+	cfg := &config.NetworkConfig{Mode: config.NetworkModeRestricted, Exceptions: []string{"10.0.5.12:5432"}}
+	m := &Manager{
+		config:        cfg,
+		firewall:      firewall,
+		cacheManager:  cacheManager,
+		containerName: "coi-test-1",
+		policy: &Policy{
+			Container:  "coi-test-1",
+			Mode:       config.NetworkModeRestricted,
+			Exceptions: cfg.Exceptions,
+			PID:        os.Getpid(),
+		},
+	}
+
+	// Nothing pending
+	m.checkPolicyUpdate(t.Context())
+	if len(firewall.calls) != 0 {
+		t.Fatalf("Expected no changes without an update, got %v", firewall.calls)
+	}
+
+	update := &PolicyUpdate{Revision: 1, Exceptions: []string{"10.0.5.12:5432", "10.0.7.0/24"}}
+	if err := cacheManager.SavePolicyUpdate("coi-test-1", update); err != nil {
+		t.Fatalf("SavePolicyUpdate() failed: %v", err)
+	}
+	m.checkPolicyUpdate(t.Context())
+
+	if !reflect.DeepEqual(firewall.calls, []string{"remove", "restricted"}) {
+		t.Errorf("Expected rules to be replaced, got %v", firewall.calls)
+	}
+	wantAllowed := []AllowEntry{{Host: "10.0.5.12", Protocol: "tcp", Port: 5432}, {Host: "10.0.7.0/24"}}
+	if !reflect.DeepEqual(firewall.allowed, wantAllowed) {
+		t.Errorf("Applied exceptions = %v, want %v", firewall.allowed, wantAllowed)
+	}
+
+	applied, err := cacheManager.LoadPolicy("coi-test-1")
+	if err != nil {
+		t.Fatalf("LoadPolicy() failed: %v", err)
+	}
+	if applied.Revision != 1 || applied.Error != "" || !reflect.DeepEqual(applied.Exceptions, update.Exceptions) {
+		t.Errorf("Unexpected applied policy: %+v", applied)
+	}
+	if !reflect.DeepEqual(cfg.Exceptions, []string{"10.0.5.12:5432"}) {
+		t.Errorf("Expected the caller's config to be left untouched, got %v", cfg.Exceptions)
+	}
+
+	// The same revision is only applied once
+	firewall.calls = nil
+	m.checkPolicyUpdate(t.Context())
+	if len(firewall.calls) != 0 {
+		t.Errorf("Expected an applied update to be skipped, got %v", firewall.calls)
+	}
+
+	// An invalid update is reported and the previous exceptions are kept
+	if err := cacheManager.SavePolicyUpdate("coi-test-1", &PolicyUpdate{Revision: 2, Exceptions: []string{"10.0.0.1:99999"}}); err != nil {
+		t.Fatalf("SavePolicyUpdate() failed: %v", err)
+	}
+	m.checkPolicyUpdate(t.Context())
+
+	failed, err := cacheManager.LoadPolicy("coi-test-1")
+	if err != nil {
+		t.Fatalf("LoadPolicy() failed: %v", err)
+	}
+	if failed.Revision != 2 || failed.Error == "" || !reflect.DeepEqual(failed.Exceptions, update.Exceptions) {
+		t.Errorf("Expected the failed update to be reported with the previous exceptions, got %+v", failed)
+	}
+	if !reflect.DeepEqual(firewall.allowed, wantAllowed) {
+		t.Errorf("Expected the previous exceptions to stay applied, got %v", firewall.allowed)
+	}
+}
+
+func TestApplyPolicyUpdateProxy(t *testing.T) {
+	firewall := &recordingFirewall{}
+	proxy := NewEgressProxy([]AllowEntry{{Host: "a.com"}}, true)
+	m := &Manager{
+		config:      &config.NetworkConfig{Mode: config.NetworkModeProxy, AllowedDomains: []string{"a.com"}},
+		firewall:    firewall,
+		egressProxy: proxy,
+		redirects:   []PortRedirect{{Protocols: []string{"tcp"}, Port: 80, ToPort: 8080}},
+	}
+
+	update := &PolicyUpdate{Revision: 1, AllowedDomains: []string{"b.com:443", "1.2.3.4"}}
+	if err := m.applyPolicyUpdate(t.Context(), update); err != nil {
+		t.Fatalf("applyPolicyUpdate() failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(firewall.calls, []string{"remove", "allowlist", "redirect"}) {
+		t.Errorf("Expected rules and redirects to be replaced, got %v", firewall.calls)
+	}
+	if !reflect.DeepEqual(firewall.allowed, []AllowEntry{{Host: "1.2.3.4"}}) {
+		t.Errorf("Expected only address entries in the firewall, got %v", firewall.allowed)
+	}
+	if proxy.allows("a.com", 443) || !proxy.allows("b.com", 443) || proxy.allows("b.com", 80) {
+		t.Error("Expected the egress proxy to use the new entries")
+	}
+
+	if err := m.applyPolicyUpdate(t.Context(), &PolicyUpdate{Revision: 2}); err == nil {
+		t.Error("Expected error for an empty allowlist")
+	}
+}
+
+func TestReapplyDNSProxy(t *testing.T) {
+	firewall := &recordingFirewall{}
+	dnsProxy := NewDNSProxy([]string{"a.com", "*.b.com"}, "127.0.0.1:53")
+	m := &Manager{
+		config:   &config.NetworkConfig{Mode: config.NetworkModeAllowlist, DNSProxy: true, AllowedDomains: []string{"a.com", "*.b.com"}},
+		firewall: firewall,
+		dnsProxy: dnsProxy,
+		dynamicIPs: map[string]time.Time{
+			"1.1.1.1": time.Now().Add(time.Minute),
+			"2.2.2.2": time.Now().Add(time.Minute),
+		},
+		dynamicNames: map[string]string{"1.1.1.1": "a.com", "2.2.2.2": "www.b.com"},
+	}
+
+	// Denying *.b.com drops the IPs its answers allowed
+	update := &PolicyUpdate{Revision: 1, AllowedDomains: []string{"a.com"}}
+	if err := m.applyPolicyUpdate(t.Context(), update); err != nil {
+		t.Fatalf("applyPolicyUpdate() failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(firewall.calls, []string{"remove", "allowlist", "allow 1.1.1.1"}) {
+		t.Errorf("Expected only a.com's IP to be allowed again, got %v", firewall.calls)
+	}
+	if _, ok := m.dynamicIPs["2.2.2.2"]; ok {
+		t.Error("Expected the denied name's IP to be forgotten")
+	}
+	if dnsProxy.allows("www.b.com") || !dnsProxy.allows("a.com") {
+		t.Error("Expected the DNS proxy to use the new names")
+	}
+
+	if err := m.applyPolicyUpdate(t.Context(), &PolicyUpdate{Revision: 2, AllowedDomains: []string{"a.com:443"}}); err == nil {
+		t.Error("Expected error for a port-limited domain with the DNS proxy")
+	}
+}
+
+func TestDetachRemovesProxyRules(t *testing.T) {
+	firewall := &recordingFirewall{}
+	m := &Manager{
+		config:       &config.NetworkConfig{Mode: config.NetworkModeAllowlist, DNSProxy: true, AllowedDomains: []string{"a.com", "1.2.3.4"}},
+		cacheManager: NewCacheManager(t.TempDir()),
+		firewall:     firewall,
+		dnsProxy:     NewDNSProxy([]string{"a.com"}, "127.0.0.1:53"),
+		allowEntries: []AllowEntry{{Host: "a.com"}, {Host: "1.2.3.4"}},
+		redirects:    []PortRedirect{{Protocols: []string{"udp", "tcp"}, Port: 53, ToPort: 40053}},
+		dynamicIPs:   map[string]time.Time{"5.5.5.5": time.Now().Add(time.Minute)},
+		dynamicNames: map[string]string{"5.5.5.5": "a.com"},
+	}
+
+	if err := m.Detach("coi-test-1"); err != nil {
+		t.Fatalf("Detach() failed: %v", err)
+	}
+
+	// Rules are replaced without the redirect and the IPs allowed by DNS answers
+	if !reflect.DeepEqual(firewall.calls, []string{"remove", "allowlist"}) {
+		t.Errorf("Expected the static rules without redirects, got %v", firewall.calls)
+	}
+	if !reflect.DeepEqual(firewall.allowed, []AllowEntry{{Host: "1.2.3.4"}}) {
+		t.Errorf("Expected only address entries to stay allowed, got %v", firewall.allowed)
+	}
+	if m.dnsProxy != nil || len(m.redirects) > 0 || len(m.dynamicIPs) > 0 {
+		t.Error("Expected the DNS proxy state to be cleared")
+	}
+}
+
+func TestDetachWithoutProxies(t *testing.T) {
+	firewall := &recordingFirewall{}
+	m := &Manager{
+		config:       &config.NetworkConfig{Mode: config.NetworkModeRestricted},
+		cacheManager: NewCacheManager(t.TempDir()),
+		firewall:     firewall,
+	}
+	if err := m.Detach("coi-test-1"); err != nil {
+		t.Fatalf("Detach() failed: %v", err)
+	}
+	if len(firewall.calls) > 0 {
+		t.Errorf("Expected the rules of a mode without proxies to stay, got %v", firewall.calls)
+	}
+}
+
+func TestAllowDNSAnswersRenews(t *testing.T) {
+	firewall := &recordingFirewall{}
+	m := &Manager{
+		firewall:     firewall,
+		dynamicIPs:   make(map[string]time.Time),
+		dynamicNames: make(map[string]string),
+	}
+
+	m.allowDNSAnswers("a.com", []DNSAnswer{{IP: "1.1.1.1", TTL: 5 * time.Minute}})
+	first := m.dynamicIPs["1.1.1.1"]
+	m.allowDNSAnswers("a.com", []DNSAnswer{{IP: "1.1.1.1", TTL: time.Minute}})
+	m.allowDNSAnswers("a.com", []DNSAnswer{{IP: "1.1.1.1", TTL: 10 * time.Minute}})
+
+	// A shorter answer keeps the expiry, a longer one renews the kernel timeout
+	if !reflect.DeepEqual(firewall.calls, []string{"allow 1.1.1.1", "allow 1.1.1.1"}) {
+		t.Errorf("Expected the IP allowed and renewed once, got %v", firewall.calls)
+	}
+	if !m.dynamicIPs["1.1.1.1"].After(first) {
+		t.Error("Expected the expiry to be extended")
+	}
+}
+
+func TestUsesSessionProxies(t *testing.T) {
+	tests := []struct {
+		cfg  config.NetworkConfig
+		want bool
+	}{
+		{config.NetworkConfig{Mode: config.NetworkModeProxy}, true},
+		{config.NetworkConfig{Mode: config.NetworkModeAllowlist, DNSProxy: true}, true},
+		{config.NetworkConfig{Mode: config.NetworkModeAllowlist}, false},
+		{config.NetworkConfig{Mode: config.NetworkModeRestricted, DNSProxy: true}, false},
+	}
+	for _, tt := range tests {
+		if got := UsesSessionProxies(&tt.cfg); got != tt.want {
+			t.Errorf("UsesSessionProxies(%+v) = %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestDetachRemovesEgressProxyRedirects(t *testing.T) {
+	firewall := &recordingFirewall{}
+	m := &Manager{
+		config:       &config.NetworkConfig{Mode: config.NetworkModeProxy, AllowedDomains: []string{"a.com", "1.2.3.4"}},
+		cacheManager: NewCacheManager(t.TempDir()),
+		firewall:     firewall,
+		egressProxy:  NewEgressProxy([]AllowEntry{{Host: "a.com"}}, true),
+		allowEntries: []AllowEntry{{Host: "a.com"}, {Host: "1.2.3.4"}},
+		redirects:    []PortRedirect{{Protocols: []string{"tcp"}, Port: 443, ToPort: 40443}},
+	}
+
+	if err := m.Detach("coi-test-1"); err != nil {
+		t.Fatalf("Detach() failed: %v", err)
+	}
+	if !reflect.DeepEqual(firewall.calls, []string{"remove", "allowlist"}) {
+		t.Errorf("Expected the rules to be reapplied without redirects, got %v", firewall.calls)
+	}
+	if m.egressProxy != nil || len(m.redirects) > 0 {
+		t.Error("Expected the egress proxy and its redirects to be gone")
+	}
+}
@@ -20,40 +20,43 @@ func NewResolver(cache *IPCache) *Resolver {
 	return &Resolver{cache: cache}
 }
 
-// ResolveDomain resolves a single domain to IPv4 addresses
-// If the input is already an IPv4 address, it returns it directly
+// ResolveDomain resolves a single domain to IPv4 and IPv6 addresses (A and AAAA records)
+// If the input is already an IP address, it returns it directly
 func (r *Resolver) ResolveDomain(domain string) ([]string, error) {
 	// Check if input is already an IP address
 	if ip := net.ParseIP(domain); ip != nil {
-		if ipv4 := ip.To4(); ipv4 != nil {
-			return []string{ipv4.String()}, nil
-		}
-		return nil, fmt.Errorf("%s is not a valid IPv4 address", domain)
+		return []string{normalizeIP(ip)}, nil
 	}
 
 	// Resolve domain name to IPs
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	addrs, err := net.DefaultResolver.LookupIP(ctx, "ip4", domain)
+	addrs, err := net.DefaultResolver.LookupIP(ctx, "ip", domain)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve %s: %w", domain, err)
 	}
 
 	ips := make([]string, 0, len(addrs))
 	for _, addr := range addrs {
-		if ipv4 := addr.To4(); ipv4 != nil {
-			ips = append(ips, ipv4.String())
-		}
+		ips = append(ips, normalizeIP(addr))
 	}
 
 	if len(ips) == 0 {
-		return nil, fmt.Errorf("no IPv4 addresses found for %s", domain)
+		return nil, fmt.Errorf("no IP addresses found for %s", domain)
 	}
 
 	return ips, nil
 }
 
+// normalizeIP formats an IP, using the dotted form for IPv4 (including IPv4-mapped IPv6)
+func normalizeIP(ip net.IP) string {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.String()
+	}
+	return ip.String()
+}
+
 // ResolveAll resolves all domains to IPs with caching fallback
 func (r *Resolver) ResolveAll(domains []string) (map[string][]string, error) {
 	results := make(map[string][]string)
@@ -4,7 +4,7 @@ import (
 	"testing"
 )
 
-func TestResolveDomain_RawIPv4(t *testing.T) {
+func TestResolveDomain_RawIP(t *testing.T) {
 	resolver := NewResolver(&IPCache{Domains: make(map[string][]string)})
 
 	tests := []struct {
@@ -32,10 +32,22 @@ func TestResolveDomain_RawIPv4(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name:    "IPv6 address should fail",
+			name:    "valid IPv6 address",
 			input:   "2001:4860:4860::8888",
-			want:    "",
-			wantErr: true,
+			want:    "2001:4860:4860::8888",
+			wantErr: false,
+		},
+		{
+			name:    "IPv6 address is normalized",
+			input:   "2001:4860:4860:0:0:0:0:8888",
+			want:    "2001:4860:4860::8888",
+			wantErr: false,
+		},
+		{
+			name:    "IPv4-mapped IPv6 address",
+			input:   "::ffff:8.8.8.8",
+			want:    "8.8.8.8",
+			wantErr: false,
 		},
 	}
 
@@ -75,7 +87,7 @@ func TestResolveDomain_DomainName(t *testing.T) {
 		t.Error("ResolveDomain(\"example.com\") returned no IPs")
 	}
 
-	// Verify all returned values are valid IPv4 addresses
+	// Verify all returned values are non-empty (A and AAAA records)
 	for _, ip := range ips {
 		if ip == "" {
 			t.Error("ResolveDomain returned empty IP string")
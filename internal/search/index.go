@@ -0,0 +1,201 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/transcript"
+)
+
+// IndexVersion is the version of the index file format (a different version is rebuilt)
+const IndexVersion = 1
+
+// Index is the full-text index of saved sessions, stored as a JSON file
+type Index struct {
+	Version  int                  `json:"version"`
+	Sessions map[string]*Document `json:"sessions"` // By Key(tool, session ID)
+}
+
+// Document is an indexed session
+type Document struct {
+	SessionID   string         `json:"session_id"`
+	Tool        string         `json:"tool"`
+	Workspace   string         `json:"workspace"`
+	SavedAt     time.Time      `json:"saved_at"`
+	Fingerprint string         `json:"fingerprint"` // State the session was indexed from, see Fingerprint
+	Terms       map[string]int `json:"terms"`       // Term frequencies
+	Length      int            `json:"length"`      // Number of terms
+	Passages    []Passage      `json:"passages"`    // Searched text, for snippets
+}
+
+// Passage is a prompt or an assistant message of a session
+type Passage struct {
+	Kind string    `json:"kind"` // transcript.KindUser or transcript.KindAssistant
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// Source is a saved session to index
+type Source struct {
+	SessionID   string
+	Tool        string
+	Workspace   string
+	SavedAt     time.Time
+	Fingerprint string
+	Load        func() ([]transcript.Conversation, error) // Reads the session's conversations
+}
+
+// Key returns the index key of a session
+func Key(tool, sessionID string) string {
+	return tool + "/" + sessionID
+}
+
+// LoadIndex reads the index at path; a missing or outdated index is returned empty
+func LoadIndex(path string) (*Index, error) {
+	idx := &Index{Version: IndexVersion, Sessions: make(map[string]*Document)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, fmt.Errorf("failed to read search index: %w", err)
+	}
+
+	var stored Index
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != IndexVersion || stored.Sessions == nil {
+		return idx, nil // Rebuilt by the next Update
+	}
+	return &stored, nil
+}
+
+// Save writes the index to path, replacing it atomically
+func (idx *Index) Save(path string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to encode search index: %w", err)
+	}
+	// The index holds conversation text, so it is only readable by the user
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create search index directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return fmt.Errorf("failed to write search index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write search index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write search index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write search index: %w", err)
+	}
+	return nil
+}
+
+// Update brings the index in line with the saved sessions: new and changed sessions are
+// (re)indexed, unchanged ones are kept and sessions that are gone are dropped
+// Returns the number of sessions indexed, whether anything changed and the errors of sessions
+// that could not be read; those are skipped (keeping a previous document) and the rest is indexed
+func (idx *Index) Update(sources []Source) (int, bool, []error) {
+	indexed, changed := 0, false
+	var failed []error
+	current := make(map[string]bool, len(sources))
+
+	for _, src := range sources {
+		key := Key(src.Tool, src.SessionID)
+		current[key] = true
+
+		if doc, ok := idx.Sessions[key]; ok && doc.Fingerprint == src.Fingerprint {
+			// Metadata may change without the conversations changing
+			if doc.Workspace != src.Workspace || !doc.SavedAt.Equal(src.SavedAt) {
+				doc.Workspace, doc.SavedAt, changed = src.Workspace, src.SavedAt, true
+			}
+			continue
+		}
+
+		conversations, err := src.Load()
+		if err != nil {
+			failed = append(failed, fmt.Errorf("failed to index session %s: %w", src.SessionID, err))
+			continue
+		}
+		idx.Sessions[key] = newDocument(src, conversations)
+		indexed++
+		changed = true
+	}
+
+	for key := range idx.Sessions {
+		if !current[key] {
+			delete(idx.Sessions, key)
+			changed = true
+		}
+	}
+	return indexed, changed, failed
+}
+
+// newDocument indexes the prompts and assistant messages of a session
+func newDocument(src Source, conversations []transcript.Conversation) *Document {
+	doc := &Document{
+		SessionID:   src.SessionID,
+		Tool:        src.Tool,
+		Workspace:   src.Workspace,
+		SavedAt:     src.SavedAt,
+		Fingerprint: src.Fingerprint,
+		Terms:       make(map[string]int),
+	}
+	for _, conv := range conversations {
+		for _, entry := range conv.Entries {
+			if entry.Kind != transcript.KindUser && entry.Kind != transcript.KindAssistant {
+				continue
+			}
+			doc.Passages = append(doc.Passages, Passage{Kind: entry.Kind, Time: entry.Time, Text: entry.Text})
+			for _, token := range tokenize(entry.Text) {
+				doc.Terms[token.term]++
+				doc.Length++
+			}
+		}
+	}
+	return doc
+}
+
+// Fingerprint summarizes the files under dir (count, total size and latest modification),
+// so changed sessions can be found without reading them
+func Fingerprint(dir string) (string, error) {
+	var count int
+	var size int64
+	var latest time.Time
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		count++
+		size += info.Size()
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to scan session state: %w", err)
+	}
+	return fmt.Sprintf("%d:%d:%d", count, size, latest.UnixNano()), nil
+}
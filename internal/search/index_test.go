@@ -0,0 +1,157 @@
+package search
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/transcript"
+)
+
+// source returns a session source whose conversation is a prompt and a reply,
+// counting how often it is loaded
+func source(id, workspace, fingerprint, prompt, reply string, loads *int) Source {
+	return Source{
+		SessionID:   id,
+		Tool:        "claude",
+		Workspace:   workspace,
+		SavedAt:     time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC),
+		Fingerprint: fingerprint,
+		Load: func() ([]transcript.Conversation, error) {
+			*loads++
+			return []transcript.Conversation{{ID: id, Entries: []transcript.Entry{
+				{Kind: transcript.KindUser, Text: prompt},
+				{Kind: transcript.KindToolCall, Tool: "Bash"},
+				{Kind: transcript.KindToolResult, Text: "tool output is not indexed"},
+				{Kind: transcript.KindAssistant, Text: reply},
+			}}}, nil
+		},
+	}
+}
+
+func TestIndexUpdate(t *testing.T) {
+	idx, err := LoadIndex(filepath.Join(t.TempDir(), "index.json"))
+	if err != nil {
+		t.Fatalf("LoadIndex() failed: %v", err)
+	}
+
+	loads := 0
+	sources := []Source{
+		source("s1", "/src/app", "v1", "Fix the consumer", "Fixed it", &loads),
+		source("s2", "/src/app", "v1", "Add a test", "Added", &loads),
+	}
+	if indexed, changed, err := idx.Update(sources); err != nil || indexed != 2 || !changed {
+		t.Fatalf("Update() = %d, %v, %v; want 2 sessions indexed", indexed, changed, err)
+	}
+	doc := idx.Sessions[Key("claude", "s1")]
+	if doc == nil || doc.Terms["consumer"] != 1 || doc.Terms["output"] != 0 || len(doc.Passages) != 2 {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+
+	// Unchanged sessions are not read again
+	if indexed, changed, _ := idx.Update(sources); indexed != 0 || changed || loads != 2 {
+		t.Errorf("second Update() indexed %d (changed %v, %d loads), want nothing", indexed, changed, loads)
+	}
+
+	// A changed session is reindexed, a moved one updated and a removed one dropped
+	sources = []Source{source("s1", "/src/moved", "v2", "Fix the producer", "Fixed it", &loads)}
+	if indexed, changed, _ := idx.Update(sources); indexed != 1 || !changed {
+		t.Errorf("third Update() indexed %d (changed %v), want 1", indexed, changed)
+	}
+	if len(idx.Sessions) != 1 {
+		t.Errorf("expected the removed session to be dropped, got %d sessions", len(idx.Sessions))
+	}
+	doc = idx.Sessions[Key("claude", "s1")]
+	if doc.Terms["producer"] != 1 || doc.Terms["consumer"] != 0 || doc.Workspace != "/src/moved" {
+		t.Errorf("session not reindexed: %+v", doc)
+	}
+}
+
+func TestIndexUpdateSkipsUnreadableSessions(t *testing.T) {
+	idx, _ := LoadIndex(filepath.Join(t.TempDir(), "index.json"))
+	loads := 0
+	broken := Source{SessionID: "s2", Tool: "claude", Fingerprint: "v1", Load: func() ([]transcript.Conversation, error) {
+		return nil, errors.New("permission denied")
+	}}
+	sources := []Source{broken, source("s1", "/src/app", "v1", "Fix the consumer", "Fixed it", &loads)}
+
+	indexed, changed, failed := idx.Update(sources)
+	if indexed != 1 || !changed || len(failed) != 1 {
+		t.Fatalf("Update() = %d, %v, %v; want 1 session indexed and 1 failure", indexed, changed, failed)
+	}
+	if idx.Sessions[Key("claude", "s1")] == nil || idx.Sessions[Key("claude", "s2")] != nil {
+		t.Errorf("Expected only the readable session indexed, got %v", idx.Sessions)
+	}
+
+	// A session that was indexed before keeps its document while it cannot be read
+	sources[1].Fingerprint = "v2"
+	sources[1].Load = broken.Load
+	if _, _, failed := idx.Update(sources); len(failed) != 2 {
+		t.Errorf("Expected 2 failures, got %v", failed)
+	}
+	if doc := idx.Sessions[Key("claude", "s1")]; doc == nil || doc.Fingerprint != "v1" {
+		t.Errorf("Expected the previous document kept, got %+v", doc)
+	}
+}
+
+func TestIndexSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search", "index.json")
+	idx, _ := LoadIndex(path)
+	loads := 0
+	if _, _, err := idx.Update([]Source{source("s1", "/src/app", "v1", "Kafka", "ok", &loads)}); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if err := idx.Save(path); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("index not written: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("index mode = %o, want 600", info.Mode().Perm())
+	}
+
+	loaded, err := LoadIndex(path)
+	if err != nil {
+		t.Fatalf("LoadIndex() failed: %v", err)
+	}
+	if doc := loaded.Sessions[Key("claude", "s1")]; doc == nil || doc.Terms["kafka"] != 1 {
+		t.Errorf("index not round-tripped: %+v", loaded.Sessions)
+	}
+
+	// An index of another version is rebuilt from scratch
+	if err := os.WriteFile(path, []byte(`{"version":999,"sessions":{"claude/s1":{}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := LoadIndex(path); len(loaded.Sessions) != 0 || loaded.Version != IndexVersion {
+		t.Errorf("expected an empty index for another version, got %+v", loaded)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "conv.jsonl")
+	if err := os.WriteFile(file, []byte("one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := Fingerprint(dir)
+	if err != nil {
+		t.Fatalf("Fingerprint() failed: %v", err)
+	}
+	if err := os.WriteFile(file, []byte("one\ntwo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := Fingerprint(dir)
+	if before == after {
+		t.Error("fingerprint should change when a file changes")
+	}
+
+	if _, err := Fingerprint(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("missing directory should not be an error: %v", err)
+	}
+}
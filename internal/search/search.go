@@ -0,0 +1,235 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Query selects and ranks sessions
+type Query struct {
+	Text      string
+	Workspace string    // Only sessions of this workspace (or below it)
+	Since     time.Time // Only sessions saved at or after this time
+	Tool      string    // Only sessions of this tool
+	Limit     int       // Maximum number of results (0 = all)
+}
+
+// Result is a session matching a query
+type Result struct {
+	SessionID string    `json:"session_id"`
+	Tool      string    `json:"tool"`
+	Workspace string    `json:"workspace"`
+	SavedAt   time.Time `json:"saved_at"`
+	Score     float64   `json:"score"`
+	Matched   int       `json:"matched_terms"` // Query terms found in the session
+	Snippet   string    `json:"snippet"`
+}
+
+// Search returns the sessions matching any query term, best first
+// Sessions are ranked by BM25; sessions matching more of the terms always rank higher
+func (idx *Index) Search(q Query) []Result {
+	terms := queryTerms(q.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var docs []*Document
+	totalLength := 0
+	for _, doc := range idx.Sessions {
+		if q.Tool != "" && doc.Tool != q.Tool {
+			continue
+		}
+		if q.Workspace != "" && doc.Workspace != q.Workspace && !strings.HasPrefix(doc.Workspace, q.Workspace+"/") {
+			continue
+		}
+		if !q.Since.IsZero() && doc.SavedAt.Before(q.Since) {
+			continue
+		}
+		docs = append(docs, doc)
+		totalLength += doc.Length
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	avgLength := float64(totalLength) / float64(len(docs))
+
+	// Document frequencies, within the filtered sessions
+	df := make(map[string]int, len(terms))
+	for _, doc := range docs {
+		for _, term := range terms {
+			if doc.Terms[term] > 0 {
+				df[term]++
+			}
+		}
+	}
+
+	n := float64(len(docs))
+	var results []Result
+	for _, doc := range docs {
+		score, matched := 0.0, 0
+		for _, term := range terms {
+			tf := float64(doc.Terms[term])
+			if tf == 0 {
+				continue
+			}
+			matched++
+			idf := math.Log(1 + (n-float64(df[term])+0.5)/(float64(df[term])+0.5))
+			norm := 1 - bm25B + bm25B*float64(doc.Length)/math.Max(avgLength, 1)
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+		if matched == 0 {
+			continue
+		}
+		results = append(results, Result{
+			SessionID: doc.SessionID,
+			Tool:      doc.Tool,
+			Workspace: doc.Workspace,
+			SavedAt:   doc.SavedAt,
+			Score:     score,
+			Matched:   matched,
+			Snippet:   snippet(doc.Passages, terms),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Matched != b.Matched {
+			return a.Matched > b.Matched
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.SavedAt.After(b.SavedAt)
+	})
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results
+}
+
+// token is a term and where it starts and ends in the text
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lowercase words (letters and digits), leaving out single characters
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			tokens = appendToken(tokens, text, start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = appendToken(tokens, text, start, len(text))
+	}
+	return tokens
+}
+
+func appendToken(tokens []token, text string, start, end int) []token {
+	if utf8.RuneCountInString(text[start:end]) < 2 {
+		return tokens
+	}
+	return append(tokens, token{term: strings.ToLower(text[start:end]), start: start, end: end})
+}
+
+// queryTerms returns the distinct terms of a query
+func queryTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, token := range tokenize(query) {
+		if !seen[token.term] {
+			seen[token.term] = true
+			terms = append(terms, token.term)
+		}
+	}
+	return terms
+}
+
+// Snippet lengths, in bytes around the first match
+const (
+	snippetBefore = 60
+	snippetAfter  = 140
+)
+
+// snippet returns an excerpt of the passage matching the most query terms
+func snippet(passages []Passage, terms []string) string {
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+
+	best, bestMatched, bestStart := -1, 0, 0
+	for i, passage := range passages {
+		found := make(map[string]bool)
+		first := -1
+		for _, token := range tokenize(passage.Text) {
+			if wanted[token.term] {
+				found[token.term] = true
+				if first < 0 {
+					first = token.start
+				}
+			}
+		}
+		if len(found) > bestMatched {
+			best, bestMatched, bestStart = i, len(found), first
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+
+	text := passages[best].Text
+	start := wordBoundary(text, max(0, bestStart-snippetBefore), false)
+	end := wordBoundary(text, min(len(text), bestStart+snippetAfter), true)
+
+	excerpt := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		excerpt = "…" + excerpt
+	}
+	if end < len(text) {
+		excerpt += "…"
+	}
+	return excerpt
+}
+
+// wordBoundary moves i to the nearest space (backward, or forward if forward is set),
+// so excerpts do not cut words or characters
+func wordBoundary(text string, i int, forward bool) int {
+	if i <= 0 || i >= len(text) {
+		return i
+	}
+	if forward {
+		if j := strings.IndexAny(text[i:], " \n\t"); j >= 0 && j < 20 {
+			return i + j
+		}
+		for i < len(text) && !utf8.RuneStart(text[i]) {
+			i++
+		}
+		return i
+	}
+	if j := strings.LastIndexAny(text[:i], " \n\t"); j >= 0 && i-j < 20 {
+		return j + 1
+	}
+	for i > 0 && !utf8.RuneStart(text[i]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,106 @@
+package search
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testIndex() *Index {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 10, 0, 0, 0, time.UTC) }
+	docs := []*Document{
+		testDocument("s1", "/src/app", day(1), "Please fix the Kafka consumer lag", "I raised the rebalance timeout so the Kafka consumer keeps up with the topic."),
+		testDocument("s2", "/src/app/service", day(2), "Add a kafka producer", "Added the producer with retries."),
+		testDocument("s3", "/src/other", day(3), "Kafka kafka kafka, everything about kafka", "Sure."),
+		testDocument("s4", "/src/app", day(4), "Update the README", "Done."),
+	}
+	idx := &Index{Version: IndexVersion, Sessions: make(map[string]*Document)}
+	for _, doc := range docs {
+		idx.Sessions[Key(doc.Tool, doc.SessionID)] = doc
+	}
+	return idx
+}
+
+func testDocument(id, workspace string, savedAt time.Time, prompt, reply string) *Document {
+	doc := &Document{SessionID: id, Tool: "claude", Workspace: workspace, SavedAt: savedAt, Terms: make(map[string]int)}
+	for _, text := range []string{prompt, reply} {
+		doc.Passages = append(doc.Passages, Passage{Text: text})
+		for _, token := range tokenize(text) {
+			doc.Terms[token.term]++
+			doc.Length++
+		}
+	}
+	return doc
+}
+
+func resultIDs(results []Result) string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.SessionID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"all terms rank first", Query{Text: "kafka consumer"}, "s1,s3,s2"},
+		{"case insensitive", Query{Text: "KAFKA Consumer"}, "s1,s3,s2"},
+		{"term frequency", Query{Text: "kafka"}, "s3,s1,s2"},
+		{"workspace and below", Query{Text: "kafka", Workspace: "/src/app"}, "s1,s2"},
+		{"workspace prefix is not a parent", Query{Text: "kafka", Workspace: "/src/ap"}, ""},
+		{"since", Query{Text: "kafka", Since: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)}, "s3,s2"},
+		{"tool", Query{Text: "kafka", Tool: "aider"}, ""},
+		{"limit", Query{Text: "kafka", Limit: 1}, "s3"},
+		{"no match", Query{Text: "zookeeper"}, ""},
+		{"no terms", Query{Text: "?!"}, ""},
+	}
+
+	idx := testIndex()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resultIDs(idx.Search(tt.query)); got != tt.want {
+				t.Errorf("Search(%+v) = %s, want %s", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	results := testIndex().Search(Query{Text: "rebalance kafka"})
+	if len(results) == 0 || results[0].SessionID != "s1" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	// The reply mentions both terms, the prompt only one
+	if !strings.HasPrefix(results[0].Snippet, "I raised the rebalance timeout") {
+		t.Errorf("snippet = %q, want the reply", results[0].Snippet)
+	}
+}
+
+func TestSnippetLongPassage(t *testing.T) {
+	text := strings.Repeat("filler words here ", 20) + "the needle is here " + strings.Repeat("more filler text ", 20)
+	got := snippet([]Passage{{Text: text}}, []string{"needle"})
+
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("snippet of a long passage should be elided on both sides: %q", got)
+	}
+	if !strings.Contains(got, "the needle is here") {
+		t.Errorf("snippet should show the match: %q", got)
+	}
+	if len(got) > snippetBefore+snippetAfter+40 {
+		t.Errorf("snippet too long (%d bytes): %q", len(got), got)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	var terms []string
+	for _, token := range tokenize("Fix the Kafka-consumer (v2), a über_test!") {
+		terms = append(terms, token.term)
+	}
+	if got := strings.Join(terms, " "); got != "fix the kafka consumer v2 über test" {
+		t.Errorf("tokenize() = %q", got)
+	}
+}
@@ -0,0 +1,447 @@
+package session
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/tool"
+)
+
+// ArchiveFormatVersion is the session archive format written by ExportSession
+// Imports refuse archives with a newer version
+const ArchiveFormatVersion = 1
+
+const (
+	// archiveManifest is the first entry of an archive
+	archiveManifest = "manifest.json"
+
+	// archiveSessionDir holds the session directory (metadata.json and the tool's config directory)
+	archiveSessionDir = "session"
+)
+
+// strippedCredentialFiles returns the paths in a tool's session directory that are left out of exports:
+// the config files copied from the host (credentials, API keys, settings that may hold secrets)
+// Fresh ones are injected from the importing machine when the session is resumed
+func strippedCredentialFiles(t tool.Tool) map[string]bool {
+	stripped := make(map[string]bool)
+	for _, name := range essentialConfigFiles(t) {
+		stripped[path.Join(t.ConfigDirName(), name)] = true
+	}
+	if ft, ok := t.(tool.ConfigFileTool); ok {
+		for _, name := range ft.HomeConfigFiles() {
+			stripped[name] = true
+		}
+	}
+	return stripped
+}
+
+// Compression magic numbers used to detect an archive's format on import
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ArchiveManifest describes an exported session
+type ArchiveManifest struct {
+	FormatVersion int               `json:"format_version"`
+	SessionID     string            `json:"session_id"`
+	Tool          string            `json:"tool"`
+	Workspace     string            `json:"workspace"`      // Workspace path on the exporting machine
+	WorkspaceHash string            `json:"workspace_hash"` // Hash in the session's container name
+	CoiVersion    string            `json:"coi_version"`
+	ExportedAt    string            `json:"exported_at"`
+	Stripped      []string          `json:"stripped,omitempty"` // Credential files left out
+	Files         map[string]string `json:"files"`              // Path in the session directory -> SHA-256
+}
+
+// ImportOptions controls ImportSession
+type ImportOptions struct {
+	Workspace string // Workspace path on this machine ("" = keep the original path)
+	Force     bool   // Replace an existing session with the same ID
+}
+
+// ExportSession writes a saved session as a tar archive (uncompressed; see CompressArchive)
+// Only regular files are exported: symlinks and credential files are left out
+func ExportSession(sessionsDir, sessionID string, t tool.Tool, coiVersion string, w io.Writer) (*ArchiveManifest, error) {
+	sessionDir := filepath.Join(sessionsDir, sessionID)
+	metadata, err := LoadSessionMetadata(filepath.Join(sessionDir, "metadata.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session metadata: %w", err)
+	}
+
+	manifest := &ArchiveManifest{
+		FormatVersion: ArchiveFormatVersion,
+		SessionID:     sessionID,
+		Tool:          t.Name(),
+		Workspace:     metadata.Workspace,
+		CoiVersion:    coiVersion,
+		ExportedAt:    getCurrentTime(),
+		Files:         make(map[string]string),
+	}
+	if hash, _, err := ParseContainerName(metadata.ContainerName); err == nil {
+		manifest.WorkspaceHash = hash
+	} else if metadata.Workspace != "" {
+		manifest.WorkspaceHash = WorkspaceHash(metadata.Workspace)
+	}
+
+	// First pass: checksums for the manifest, which is written first
+	stripped := strippedCredentialFiles(t)
+	var files []string
+	err = filepath.WalkDir(sessionDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(sessionDir, p)
+		rel = filepath.ToSlash(rel)
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if stripped[rel] {
+			manifest.Stripped = append(manifest.Stripped, rel)
+			return nil
+		}
+		sum, err := fileChecksum(p)
+		if err != nil {
+			return err
+		}
+		manifest.Files[rel] = sum
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+	sort.Strings(files)
+
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := writeArchiveFile(tw, archiveManifest, 0o644, time.Now(), bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	for _, rel := range files {
+		if err := addArchiveFile(tw, filepath.Join(sessionDir, filepath.FromSlash(rel)), path.Join(archiveSessionDir, rel)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return manifest, nil
+}
+
+// addArchiveFile adds a host file to the archive
+func addArchiveFile(tw *tar.Writer, hostPath, name string) error {
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", hostPath, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", hostPath, err)
+	}
+	return writeArchiveFile(tw, name, int64(info.Mode().Perm()), info.ModTime(), f, info.Size())
+}
+
+// writeArchiveFile writes a regular file entry
+func writeArchiveFile(tw *tar.Writer, name string, mode int64, modTime time.Time, r io.Reader, size int64) error {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: mode, ModTime: modTime, Size: size}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := io.CopyN(tw, r, size); err != nil {
+		return fmt.Errorf("failed to write %s to archive: %w", name, err)
+	}
+	return nil
+}
+
+// fileChecksum returns the hex SHA-256 of a file
+func fileChecksum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ImportSession extracts a session archive (uncompressed; see DecompressArchive) into the
+// sessions directory of its tool, verifying every file against the manifest
+// The session is moved to opts.Workspace (if set): its metadata and container name are rewritten,
+// and its worktree, which only existed on the exporting machine, is dropped
+func ImportSession(r io.Reader, baseDir string, opts ImportOptions) (*ArchiveManifest, error) {
+	tr := tar.NewReader(r)
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := tool.Get(manifest.Tool)
+	if err != nil {
+		return nil, fmt.Errorf("session was exported from unknown tool '%s': %w", manifest.Tool, err)
+	}
+	sessionsDir := GetSessionsDir(baseDir, t)
+	sessionDir := filepath.Join(sessionsDir, manifest.SessionID)
+	if _, err := os.Stat(sessionDir); err == nil && !opts.Force {
+		return nil, fmt.Errorf("session %s already exists (use --force to replace it)", manifest.SessionID)
+	}
+
+	// Extract next to the sessions, so a failed import leaves nothing behind
+	if err := os.MkdirAll(sessionsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(sessionsDir, ".import-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create import directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extractSession(tr, tmpDir, manifest); err != nil {
+		return nil, err
+	}
+	if err := remapImportedMetadata(filepath.Join(tmpDir, "metadata.json"), manifest, opts.Workspace); err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(tmpDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to import session: %w", err)
+	}
+	if err := os.RemoveAll(sessionDir); err != nil {
+		return nil, fmt.Errorf("failed to replace session %s: %w", manifest.SessionID, err)
+	}
+	if err := os.Rename(tmpDir, sessionDir); err != nil {
+		return nil, fmt.Errorf("failed to import session: %w", err)
+	}
+	return manifest, nil
+}
+
+// readManifest reads and checks the manifest, which must be the archive's first entry
+func readManifest(tr *tar.Reader) (*ArchiveManifest, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("not a session archive: %w", err)
+	}
+	if header.Name != archiveManifest {
+		return nil, fmt.Errorf("not a session archive: missing %s", archiveManifest)
+	}
+
+	var manifest ArchiveManifest
+	if err := json.NewDecoder(io.LimitReader(tr, 64<<20)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid archive manifest: %w", err)
+	}
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > ArchiveFormatVersion {
+		return nil, fmt.Errorf("unsupported archive format version %d (this coi supports up to %d - upgrade coi)",
+			manifest.FormatVersion, ArchiveFormatVersion)
+	}
+	if manifest.SessionID == "" || manifest.SessionID != filepath.Base(manifest.SessionID) || strings.HasPrefix(manifest.SessionID, ".") {
+		return nil, fmt.Errorf("invalid session ID in archive: %q", manifest.SessionID)
+	}
+	return &manifest, nil
+}
+
+// extractSession writes the archive's session files to dir, checking them against the manifest
+func extractSession(tr *tar.Reader, dir string, manifest *ArchiveManifest) error {
+	seen := make(map[string]bool)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		rel, ok := strings.CutPrefix(header.Name, archiveSessionDir+"/")
+		if !ok || header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected archive entry %s", header.Name)
+		}
+		want, listed := manifest.Files[rel]
+		if !listed || rel != path.Clean(rel) || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+			return fmt.Errorf("unexpected archive entry %s", header.Name)
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+		}
+		sum, err := writeFileChecksum(target, tr, os.FileMode(header.Mode).Perm()|0o600)
+		if err != nil {
+			return err
+		}
+		if sum != want {
+			return fmt.Errorf("checksum mismatch for %s - the archive is corrupted", rel)
+		}
+		seen[rel] = true
+	}
+
+	for rel := range manifest.Files {
+		if !seen[rel] {
+			return fmt.Errorf("archive is missing %s - it may be truncated", rel)
+		}
+	}
+	if !seen["metadata.json"] {
+		return fmt.Errorf("archive has no session metadata")
+	}
+	return nil
+}
+
+// writeFileChecksum writes r to a new file and returns its hex SHA-256
+func writeFileChecksum(target string, r io.Reader, mode os.FileMode) (string, error) {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", target, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", target, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), f.Close()
+}
+
+// remapImportedMetadata points an imported session at its workspace on this machine
+func remapImportedMetadata(metadataPath string, manifest *ArchiveManifest, workspace string) error {
+	metadata, err := LoadSessionMetadata(metadataPath)
+	if err != nil {
+		return fmt.Errorf("invalid session metadata in archive: %w", err)
+	}
+
+	if workspace == "" {
+		workspace = metadata.Workspace
+	}
+	slot := 1
+	if _, s, err := ParseContainerName(metadata.ContainerName); err == nil {
+		slot = s
+	}
+
+	metadata.SessionID = manifest.SessionID
+	metadata.Workspace = workspace
+	metadata.ContainerName = ContainerName(workspace, slot)
+	metadata.Worktree = ""
+	metadata.Branch = ""
+	return saveMetadata(metadataPath, *metadata)
+}
+
+// CompressArchive wraps w to compress by the archive name: .tar.zst/.tzst (zstd, needs the
+// zstd command), .tar.gz/.tgz (gzip) or .tar (none); Close must be called to finish the archive
+func CompressArchive(name string, w io.Writer) (io.WriteCloser, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return zstdWriter(w)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return gzip.NewWriter(w), nil
+	case strings.HasSuffix(name, ".tar"):
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unknown archive type '%s': use .tar.zst, .tar.gz or .tar", name)
+}
+
+// DecompressArchive detects an archive's compression (zstd, gzip or none) and decompresses it
+func DecompressArchive(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstdReader(br)
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	}
+	return io.NopCloser(br), nil
+}
+
+// DefaultArchiveExtension returns .tar.zst if the zstd command is available, .tar.gz otherwise
+func DefaultArchiveExtension() string {
+	if _, err := exec.LookPath("zstd"); err == nil {
+		return ".tar.zst"
+	}
+	return ".tar.gz"
+}
+
+// nopWriteCloser adds a no-op Close to a writer
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// commandStream is a zstd process compressing or decompressing a stream
+type commandStream struct {
+	cmd  *exec.Cmd
+	pipe io.Closer
+	io.Reader
+	io.Writer
+}
+
+// Close waits for zstd to finish: when writing, its input is closed first; when reading,
+// the rest of its output (e.g., tar padding) is drained so it can exit
+func (c *commandStream) Close() error {
+	if c.Writer != nil {
+		c.pipe.Close()
+	} else {
+		_, _ = io.Copy(io.Discard, c.Reader)
+	}
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd failed: %w", err)
+	}
+	return nil
+}
+
+// zstdWriter compresses to w with the zstd command
+func zstdWriter(w io.Writer) (io.WriteCloser, error) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		return nil, errors.New("zstd is not installed - install it or export as .tar.gz")
+	}
+	cmd := exec.Command("zstd", "-q", "-c")
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start zstd: %w", err)
+	}
+	return &commandStream{cmd: cmd, pipe: stdin, Writer: stdin}, nil
+}
+
+// zstdReader decompresses r with the zstd command
+func zstdReader(r io.Reader) (io.ReadCloser, error) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		return nil, errors.New("archive is zstd-compressed but zstd is not installed")
+	}
+	cmd := exec.Command("zstd", "-d", "-q", "-c")
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start zstd: %w", err)
+	}
+	return &commandStream{cmd: cmd, pipe: stdout, Reader: stdout}, nil
+}
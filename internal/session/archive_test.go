@@ -0,0 +1,240 @@
+package session
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mensfeld/code-on-incus/internal/tool"
+)
+
+// savedSession creates a saved claude session in baseDir and returns its sessions directory
+func savedSession(t *testing.T, baseDir, sessionID, workspace string) string {
+	t.Helper()
+	sessionsDir := GetSessionsDir(baseDir, tool.NewClaude())
+	sessionDir := filepath.Join(sessionsDir, sessionID)
+	writeHostFile(t, sessionDir, ".claude/projects/-workspace/conv-1.jsonl", `{"type":"user"}`+"\n")
+	writeHostFile(t, sessionDir, ".claude/settings.json", "{}")
+	writeHostFile(t, sessionDir, ".claude/.credentials.json", `{"token":"secret"}`)
+	if err := saveMetadata(filepath.Join(sessionDir, "metadata.json"), SessionMetadata{
+		SessionID:     sessionID,
+		ContainerName: ContainerName(workspace, 2),
+		Workspace:     workspace,
+		SavedAt:       "2026-01-02T15:04:05Z",
+		Worktree:      "/home/alice/.coi/worktrees/abc/slot-2",
+		Branch:        "coi/2-session",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return sessionsDir
+}
+
+// exportArchive exports a session to an in-memory archive compressed by name
+func exportArchive(t *testing.T, sessionsDir, sessionID, name string) (*bytes.Buffer, *ArchiveManifest) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := CompressArchive(name, &buf)
+	if err != nil {
+		t.Fatalf("CompressArchive() failed: %v", err)
+	}
+	manifest, err := ExportSession(sessionsDir, sessionID, tool.NewClaude(), "1.2.3", w)
+	if err != nil {
+		t.Fatalf("ExportSession() failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf, manifest
+}
+
+// importArchive decompresses and imports an archive
+func importArchive(t *testing.T, archive []byte, baseDir string, opts ImportOptions) (*ArchiveManifest, error) {
+	t.Helper()
+	r, err := DecompressArchive(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("DecompressArchive() failed: %v", err)
+	}
+	defer r.Close()
+	return ImportSession(r, baseDir, opts)
+}
+
+func TestExportImportSession(t *testing.T) {
+	for _, name := range []string{"s.tar.gz", "s.tar", "s.tar.zst"} {
+		t.Run(name, func(t *testing.T) {
+			if strings.HasSuffix(name, ".zst") {
+				if _, err := exec.LookPath("zstd"); err != nil {
+					t.Skip("zstd not installed")
+				}
+			}
+			sessionsDir := savedSession(t, t.TempDir(), "session-1", "/home/alice/src/app")
+			archive, manifest := exportArchive(t, sessionsDir, "session-1", name)
+
+			if manifest.FormatVersion != ArchiveFormatVersion || manifest.Tool != "claude" || manifest.CoiVersion != "1.2.3" {
+				t.Errorf("Unexpected manifest: %+v", manifest)
+			}
+			if manifest.Workspace != "/home/alice/src/app" || manifest.WorkspaceHash != WorkspaceHash("/home/alice/src/app") {
+				t.Errorf("Unexpected workspace in manifest: %s (%s)", manifest.Workspace, manifest.WorkspaceHash)
+			}
+			if want := []string{".claude/.credentials.json", ".claude/settings.json"}; !reflect.DeepEqual(manifest.Stripped, want) {
+				t.Errorf("Expected the host config files stripped, got %v, want %v", manifest.Stripped, want)
+			}
+			if _, ok := manifest.Files[".claude/projects/-workspace/conv-1.jsonl"]; !ok || len(manifest.Files) != 2 {
+				t.Errorf("Unexpected files: %v", manifest.Files)
+			}
+
+			baseDir := t.TempDir()
+			workspace := "/home/bob/work/app"
+			if _, err := importArchive(t, archive.Bytes(), baseDir, ImportOptions{Workspace: workspace}); err != nil {
+				t.Fatalf("ImportSession() failed: %v", err)
+			}
+
+			sessionDir := filepath.Join(GetSessionsDir(baseDir, tool.NewClaude()), "session-1")
+			if content, err := os.ReadFile(filepath.Join(sessionDir, ".claude/projects/-workspace/conv-1.jsonl")); err != nil || string(content) != `{"type":"user"}`+"\n" {
+				t.Errorf("Conversation not imported: %q (%v)", content, err)
+			}
+			if _, err := os.Stat(filepath.Join(sessionDir, ".claude/.credentials.json")); !os.IsNotExist(err) {
+				t.Error("Expected no credentials in the imported session")
+			}
+
+			metadata, err := LoadSessionMetadata(filepath.Join(sessionDir, "metadata.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metadata.Workspace != workspace || metadata.ContainerName != ContainerName(workspace, 2) {
+				t.Errorf("Expected the session remapped to %s, got %+v", workspace, metadata)
+			}
+			if metadata.Worktree != "" || metadata.Branch != "" {
+				t.Errorf("Expected the worktree dropped, got %+v", metadata)
+			}
+			if latest, err := GetLatestSessionForWorkspace(GetSessionsDir(baseDir, tool.NewClaude()), workspace, ".claude"); err != nil || latest != "session-1" {
+				t.Errorf("Expected the session found for its new workspace, got %q (%v)", latest, err)
+			}
+		})
+	}
+}
+
+func TestStrippedCredentialFiles(t *testing.T) {
+	got := strippedCredentialFiles(tool.NewAider())
+	want := map[string]bool{
+		".aider/oauth-keys.env":      true,
+		".aider.conf.yml":            true,
+		".aider.model.settings.yml":  true,
+		".aider.model.metadata.json": true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("strippedCredentialFiles(aider) = %v, want %v", got, want)
+	}
+}
+
+func TestImportSessionExisting(t *testing.T) {
+	sessionsDir := savedSession(t, t.TempDir(), "session-1", "/src/app")
+	archive, _ := exportArchive(t, sessionsDir, "session-1", "s.tar.gz")
+
+	baseDir := t.TempDir()
+	if _, err := importArchive(t, archive.Bytes(), baseDir, ImportOptions{}); err != nil {
+		t.Fatalf("ImportSession() failed: %v", err)
+	}
+	if _, err := importArchive(t, archive.Bytes(), baseDir, ImportOptions{}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an error for an existing session, got %v", err)
+	}
+	if _, err := importArchive(t, archive.Bytes(), baseDir, ImportOptions{Force: true}); err != nil {
+		t.Errorf("Expected --force to replace the session, got %v", err)
+	}
+
+	metadata, err := LoadSessionMetadata(filepath.Join(GetSessionsDir(baseDir, tool.NewClaude()), "session-1", "metadata.json"))
+	if err != nil || metadata.Workspace != "/src/app" {
+		t.Errorf("Expected the original workspace kept, got %+v (%v)", metadata, err)
+	}
+}
+
+// rawArchive builds an uncompressed archive from a manifest and session entries
+func rawArchive(t *testing.T, manifest ArchiveManifest, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	data, _ := json.Marshal(manifest)
+	for _, entry := range append([][2]string{{archiveManifest, string(data)}}, sortedEntries(entries)...) {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: entry[0], Mode: 0o644, Size: int64(len(entry[1]))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(entry[1]))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+// sortedEntries returns archive entries (name, content) in name order
+func sortedEntries(entries map[string]string) [][2]string {
+	var list [][2]string
+	for name, content := range entries {
+		list = append(list, [2]string{name, content})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i][0] < list[j][0] })
+	return list
+}
+
+// sha256Hex returns the hex SHA-256 of s
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestImportSessionRejectsInvalidArchives(t *testing.T) {
+	metadata := "{\n  \"session_id\": \"s1\",\n  \"workspace\": \"/src/app\"\n}\n"
+	valid := ArchiveManifest{FormatVersion: 1, SessionID: "s1", Tool: "claude", Files: map[string]string{
+		"metadata.json": sha256Hex(metadata),
+	}}
+
+	newer := valid
+	newer.FormatVersion = ArchiveFormatVersion + 1
+	badID := valid
+	badID.SessionID = "../evil"
+	traversal := valid
+	traversal.Files = map[string]string{"metadata.json": sha256Hex(metadata), "../evil": sha256Hex("x")}
+	missing := valid
+	missing.Files = map[string]string{"metadata.json": sha256Hex(metadata), ".claude/a": sha256Hex("x")}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		wantErr string
+	}{
+		{"not an archive", []byte("hello"), "not a session archive"},
+		{"newer format", rawArchive(t, newer, map[string]string{"session/metadata.json": metadata}), "unsupported archive format version"},
+		{"invalid session ID", rawArchive(t, badID, map[string]string{"session/metadata.json": metadata}), "invalid session ID"},
+		{"corrupted file", rawArchive(t, valid, map[string]string{"session/metadata.json": metadata + " "}), "checksum mismatch"},
+		{"unlisted file", rawArchive(t, valid, map[string]string{"session/metadata.json": metadata, "session/extra": "x"}), "unexpected archive entry"},
+		{"path traversal", rawArchive(t, traversal, map[string]string{"session/metadata.json": metadata, "session/../evil": "x"}), "unexpected archive entry"},
+		{"missing file", rawArchive(t, missing, map[string]string{"session/metadata.json": metadata}), "missing .claude/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+			_, err := importArchive(t, tt.archive, baseDir, ImportOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ImportSession() = %v, want error containing %q", err, tt.wantErr)
+			}
+			if entries, _ := os.ReadDir(GetSessionsDir(baseDir, tool.NewClaude())); len(entries) != 0 {
+				t.Errorf("Expected nothing left behind, got %v", entries)
+			}
+			if _, err := os.Stat(filepath.Join(baseDir, "evil")); err == nil {
+				t.Error("File written outside the sessions directory")
+			}
+		})
+	}
+}
+
+func TestCompressArchiveUnknownType(t *testing.T) {
+	if _, err := CompressArchive("session.zip", &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error for an unknown archive type")
+	}
+}
@@ -2,6 +2,7 @@ package session
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,10 +10,15 @@ import (
 	"time"
 
 	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/gitcred"
 	"github.com/mensfeld/code-on-incus/internal/network"
+	"github.com/mensfeld/code-on-incus/internal/sshagent"
 	"github.com/mensfeld/code-on-incus/internal/tool"
 )
 
+// stopPollInterval is how often Cleanup checks whether a container finished shutting down
+var stopPollInterval = 500 * time.Millisecond
+
 // CleanupOptions contains options for cleaning up a session
 type CleanupOptions struct {
 	ContainerName  string
@@ -23,6 +29,8 @@ type CleanupOptions struct {
 	Workspace      string    // Workspace directory path
 	Tool           tool.Tool // AI coding tool being used
 	NetworkManager *network.Manager
+	GitCredentials *gitcred.Proxy  // Stopped first, so the container gets no credentials after the session
+	SSHAgent       *sshagent.Proxy // Stopped first, so the container cannot sign after the session
 	Logger         func(string)
 }
 
@@ -40,6 +48,13 @@ func Cleanup(opts CleanupOptions) error {
 		return nil
 	}
 
+	if opts.GitCredentials != nil {
+		opts.GitCredentials.Stop()
+	}
+	if opts.SSHAgent != nil {
+		opts.SSHAgent.Stop()
+	}
+
 	mgr := container.NewManager(opts.ContainerName)
 
 	// Check if container exists
@@ -49,6 +64,15 @@ func Cleanup(opts CleanupOptions) error {
 		opts.Logger(fmt.Sprintf("Warning: Could not check container existence: %v", err))
 	}
 
+	// Remove delivered secrets first, so they are not saved with the session or left in a kept container
+	if exists {
+		if running, _ := mgr.Running(); running {
+			if err := RemoveSecrets(mgr); err != nil {
+				opts.Logger(fmt.Sprintf("Warning: %v", err))
+			}
+		}
+	}
+
 	// Always save session data if container exists (works even from stopped containers)
 	// This ensures --resume works regardless of how the user exited (including sudo shutdown 0)
 	// Skip if tool uses ENV-based auth (no config directory to save)
@@ -58,8 +82,23 @@ func Cleanup(opts CleanupOptions) error {
 		}
 	}
 
+	// The session's proxies stop with this process; a kept container must not be left redirected to them
+	detachNetwork := func() {
+		if opts.NetworkManager == nil {
+			return
+		}
+		if err := opts.NetworkManager.Detach(opts.ContainerName); err != nil {
+			opts.Logger(fmt.Sprintf("Warning: %v - stopping the container so it is not left without network isolation", err))
+			if err := mgr.Stop(true); err != nil {
+				opts.Logger(fmt.Sprintf("Warning: Failed to stop container: %v", err))
+			}
+		}
+	}
+
 	// Handle container based on persistence mode
 	if opts.Persistent {
+		detachNetwork()
+
 		// Persistent mode: keep container for reuse (with all its data/modifications)
 		if exists {
 			opts.Logger("Container kept running - use 'coi attach' to reconnect, 'coi shutdown' to stop, or 'coi kill' to force stop")
@@ -75,7 +114,7 @@ func Cleanup(opts CleanupOptions) error {
 			// Poweroff/shutdown can take several seconds to complete
 			running := true
 			for i := 0; i < 10; i++ {
-				time.Sleep(500 * time.Millisecond)
+				time.Sleep(stopPollInterval)
 				running, _ = mgr.Running()
 				if !running {
 					break
@@ -84,7 +123,12 @@ func Cleanup(opts CleanupOptions) error {
 
 			if running {
 				// Container still running - user exited normally, keep it for potential re-attach
+				detachNetwork()
 				opts.Logger("Container kept running - use 'coi attach' to reconnect, 'coi shutdown' to stop, or 'coi kill' to force stop")
+			} else if info, err := GetWorkspaceInfo(mgr); err == nil && info.Mode != WorkspaceModeDirect {
+				// The container holds its own workspace, deleting it would lose unapplied changes
+				detachNetwork()
+				opts.Logger(fmt.Sprintf("Container was stopped but kept: its %s workspace may hold unapplied changes - review with 'coi diff', then 'coi apply' or 'coi discard', and remove it with 'coi kill %s'", info.Mode, opts.ContainerName))
 			} else {
 				// Container stopped (user did 'sudo shutdown 0') - delete it
 				opts.Logger("Container was stopped, removing...")
@@ -92,6 +136,7 @@ func Cleanup(opts CleanupOptions) error {
 				// Delete container first (this detaches any ACLs from its devices)
 				if err := mgr.Delete(true); err != nil {
 					opts.Logger(fmt.Sprintf("Warning: Failed to delete container: %v", err))
+					detachNetwork()
 				} else {
 					opts.Logger("Container removed (session data saved for --resume)")
 
@@ -144,23 +189,27 @@ func saveSessionData(mgr *container.Manager, sessionID string, persistent bool,
 	// If config dir doesn't exist, PullDirectory will fail and we handle it gracefully
 	if err := mgr.PullDirectory(stateDir, localConfigDir); err != nil {
 		// Check if it's a "not found" error - this is expected if config dir doesn't exist
-		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "No such file") {
+		if errors.Is(err, container.ErrNotFound) || strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "No such file") {
 			logger(fmt.Sprintf("No %s directory found in container", configDirName))
 			return nil
 		}
 		return fmt.Errorf("failed to pull %s directory: %w", configDirName, err)
 	}
 
-	// Save metadata
-	metadata := SessionMetadata{
-		SessionID:     sessionID,
-		ContainerName: mgr.ContainerName,
-		Persistent:    persistent,
-		Workspace:     workspace,
-		SavedAt:       getCurrentTime(),
+	// Save metadata, keeping what was recorded at session start (name, labels, worktree, ...)
+	metadataPath := filepath.Join(localSessionDir, "metadata.json")
+	metadata := SessionMetadata{}
+	if previous, err := LoadSessionMetadata(metadataPath); err == nil {
+		metadata = *previous
 	}
+	metadata.SessionID = sessionID
+	metadata.ContainerName = mgr.ContainerName
+	metadata.Persistent = persistent
+	metadata.Workspace = workspace
+	metadata.SavedAt = getCurrentTime()
+	metadata.GitEnd = checkoutGitState(mgr, &metadata)
+	metadata.ExitStatus = readExitStatus(mgr)
 
-	metadataPath := filepath.Join(localSessionDir, "metadata.json")
 	if err := saveMetadata(metadataPath, metadata); err != nil {
 		// Non-fatal - session data is already saved
 		logger(fmt.Sprintf("Warning: Failed to save metadata: %v", err))
@@ -170,64 +219,14 @@ func saveSessionData(mgr *container.Manager, sessionID string, persistent bool,
 	return nil
 }
 
-// SessionMetadata contains information about a saved session
-type SessionMetadata struct {
-	SessionID     string `json:"session_id"`
-	ContainerName string `json:"container_name"`
-	Persistent    bool   `json:"persistent"`
-	Workspace     string `json:"workspace"`
-	SavedAt       string `json:"saved_at"`
-}
-
-// saveMetadata saves session metadata to a JSON file
-func saveMetadata(path string, metadata SessionMetadata) error {
-	// Simple JSON marshaling
-	content := fmt.Sprintf(`{
-  "session_id": "%s",
-  "container_name": "%s",
-  "persistent": %t,
-  "workspace": "%s",
-  "saved_at": "%s"
-}
-`, metadata.SessionID, metadata.ContainerName, metadata.Persistent, metadata.Workspace, metadata.SavedAt)
-
-	return os.WriteFile(path, []byte(content), 0o644)
-}
-
-// getCurrentTime returns current time in RFC3339 format
-func getCurrentTime() string {
-	return time.Now().Format(time.RFC3339)
-}
-
-// SaveMetadataEarly saves session metadata at session start so coi list can show correct status
-func SaveMetadataEarly(sessionsDir, sessionID, containerName, workspace string, persistent bool) error {
-	// Create session directory if it doesn't exist
-	sessionDir := filepath.Join(sessionsDir, sessionID)
-	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
-		return fmt.Errorf("failed to create session directory: %w", err)
-	}
-
-	metadata := SessionMetadata{
-		SessionID:     sessionID,
-		ContainerName: containerName,
-		Persistent:    persistent,
-		Workspace:     workspace,
-		SavedAt:       getCurrentTime(),
-	}
-
-	metadataPath := filepath.Join(sessionDir, "metadata.json")
-	return saveMetadata(metadataPath, metadata)
-}
-
 // SessionExists checks if a session with the given ID exists and is valid
-func SessionExists(sessionsDir, sessionID string) bool {
-	statePath := filepath.Join(sessionsDir, sessionID, ".claude")
-	info, err := os.Stat(statePath)
-	return err == nil && info.IsDir()
+// configDirName is the tool's config directory (e.g., ".claude", ".aider")
+func SessionExists(sessionsDir, sessionID, configDirName string) bool {
+	return hasSessionState(filepath.Join(sessionsDir, sessionID), configDirName)
 }
 
 // ListSavedSessions lists all saved sessions in the sessions directory
-func ListSavedSessions(sessionsDir string) ([]string, error) {
+func ListSavedSessions(sessionsDir, configDirName string) ([]string, error) {
 	entries, err := os.ReadDir(sessionsDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -238,21 +237,29 @@ func ListSavedSessions(sessionsDir string) ([]string, error) {
 
 	var sessions []string
 	for _, entry := range entries {
-		if entry.IsDir() {
-			// Check if it contains a .claude directory
-			statePath := filepath.Join(sessionsDir, entry.Name(), ".claude")
-			if info, err := os.Stat(statePath); err == nil && info.IsDir() {
-				sessions = append(sessions, entry.Name())
-			}
+		if entry.IsDir() && hasSessionState(filepath.Join(sessionsDir, entry.Name()), configDirName) {
+			sessions = append(sessions, entry.Name())
 		}
 	}
 
 	return sessions, nil
 }
 
+// hasSessionState checks if a session directory contains saved tool state
+// For ENV-based tools (no config directory), metadata.json is enough
+func hasSessionState(sessionDir, configDirName string) bool {
+	if configDirName == "" {
+		_, err := os.Stat(filepath.Join(sessionDir, "metadata.json"))
+		return err == nil
+	}
+
+	info, err := os.Stat(filepath.Join(sessionDir, configDirName))
+	return err == nil && info.IsDir()
+}
+
 // GetLatestSession returns the most recently saved session ID
-func GetLatestSession(sessionsDir string) (string, error) {
-	sessions, err := ListSavedSessions(sessionsDir)
+func GetLatestSession(sessionsDir, configDirName string) (string, error) {
+	sessions, err := ListSavedSessions(sessionsDir, configDirName)
 	if err != nil {
 		return "", err
 	}
@@ -291,8 +298,8 @@ func GetLatestSession(sessionsDir string) (string, error) {
 }
 
 // GetLatestSessionForWorkspace returns the most recent session ID for a specific workspace
-func GetLatestSessionForWorkspace(sessionsDir, workspacePath string) (string, error) {
-	sessions, err := ListSavedSessions(sessionsDir)
+func GetLatestSessionForWorkspace(sessionsDir, workspacePath, configDirName string) (string, error) {
+	sessions, err := ListSavedSessions(sessionsDir, configDirName)
 	if err != nil {
 		return "", err
 	}
@@ -344,51 +351,6 @@ func GetLatestSessionForWorkspace(sessionsDir, workspacePath string) (string, er
 	return latestSession, nil
 }
 
-// LoadSessionMetadata loads session metadata from a JSON file
-func LoadSessionMetadata(path string) (*SessionMetadata, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	var metadata SessionMetadata
-	// Simple JSON parsing
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, "\"session_id\"") {
-			metadata.SessionID = extractJSONValue(line)
-		} else if strings.Contains(line, "\"container_name\"") {
-			metadata.ContainerName = extractJSONValue(line)
-		} else if strings.Contains(line, "\"persistent\"") {
-			metadata.Persistent = strings.Contains(line, "true")
-		} else if strings.Contains(line, "\"workspace\"") {
-			metadata.Workspace = extractJSONValue(line)
-		} else if strings.Contains(line, "\"saved_at\"") {
-			metadata.SavedAt = extractJSONValue(line)
-		}
-	}
-
-	if metadata.SessionID == "" {
-		return nil, fmt.Errorf("invalid metadata: missing session_id")
-	}
-
-	return &metadata, nil
-}
-
-// extractJSONValue extracts the value from a JSON line like `"key": "value",`
-func extractJSONValue(line string) string {
-	// Find the value between quotes after the colon
-	parts := strings.SplitN(line, ":", 2)
-	if len(parts) != 2 {
-		return ""
-	}
-
-	value := strings.TrimSpace(parts[1])
-	value = strings.Trim(value, `",`)
-	return value
-}
-
 // GetCLISessionID extracts the CLI tool's session ID from a saved coi session.
 // CLI tools store sessions in .claude/projects/-workspace/<session-id>.jsonl
 // Returns empty string if no session found.
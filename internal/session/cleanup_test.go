@@ -0,0 +1,141 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/tool"
+)
+
+// fastStopPolling shortens the shutdown polling in Cleanup for the test
+func fastStopPolling(t *testing.T) {
+	t.Helper()
+	original := stopPollInterval
+	stopPollInterval = time.Millisecond
+	t.Cleanup(func() { stopPollInterval = original })
+}
+
+func TestCleanupSavesSessionData(t *testing.T) {
+	backend := useFakeBackend(t)
+	fastStopPolling(t)
+
+	backend.AddInstance("coi-test-1", "Stopped")
+	if err := backend.WriteFile("coi-test-1", "/home/code/.claude/projects/history.jsonl", []byte("{}\n")); err != nil {
+		t.Fatalf("Failed to write container file: %v", err)
+	}
+
+	sessionsDir := t.TempDir()
+	err := Cleanup(CleanupOptions{
+		ContainerName: "coi-test-1",
+		SessionID:     "session-1",
+		SessionsDir:   sessionsDir,
+		SaveSession:   true,
+		Workspace:     "/home/user/project",
+		Tool:          tool.NewClaude(),
+		Logger:        func(string) {},
+	})
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(sessionsDir, "session-1", ".claude", "projects", "history.jsonl"))
+	if err != nil || string(content) != "{}\n" {
+		t.Errorf("Expected session history saved, got %q (%v)", content, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(sessionsDir, "session-1", "metadata.json"))
+	if err != nil {
+		t.Fatalf("Expected metadata.json: %v", err)
+	}
+	var metadata SessionMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("Invalid metadata.json: %v", err)
+	}
+	if metadata.ContainerName != "coi-test-1" || metadata.Workspace != "/home/user/project" || metadata.Persistent {
+		t.Errorf("Unexpected metadata: %+v", metadata)
+	}
+
+	if !SessionExists(sessionsDir, "session-1", ".claude") {
+		t.Error("Expected saved session to be resumable")
+	}
+}
+
+func TestCleanupWithoutConfigDir(t *testing.T) {
+	backend := useFakeBackend(t)
+	fastStopPolling(t)
+
+	// Tool never wrote its config directory - nothing to save, but not an error
+	backend.AddInstance("coi-test-1", "Stopped")
+
+	sessionsDir := t.TempDir()
+	err := Cleanup(CleanupOptions{
+		ContainerName: "coi-test-1",
+		SessionID:     "session-1",
+		SessionsDir:   sessionsDir,
+		SaveSession:   true,
+		Tool:          tool.NewClaude(),
+		Logger:        func(string) {},
+	})
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+
+	if SessionExists(sessionsDir, "session-1", ".claude") {
+		t.Error("Expected no saved session")
+	}
+}
+
+func TestCleanupContainerLifecycle(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		persistent bool
+		wantKept   bool
+	}{
+		{name: "stopped container is deleted", status: "Stopped", persistent: false, wantKept: false},
+		{name: "running container is kept for attach", status: "Running", persistent: false, wantKept: true},
+		{name: "persistent stopped container is kept", status: "Stopped", persistent: true, wantKept: true},
+		{name: "persistent running container is kept", status: "Running", persistent: true, wantKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := useFakeBackend(t)
+			fastStopPolling(t)
+			backend.AddInstance("coi-test-1", tt.status)
+
+			err := Cleanup(CleanupOptions{
+				ContainerName: "coi-test-1",
+				Persistent:    tt.persistent,
+				Logger:        func(string) {},
+			})
+			if err != nil {
+				t.Fatalf("Cleanup() failed: %v", err)
+			}
+
+			kept := backend.Instance("coi-test-1") != nil
+			if kept != tt.wantKept {
+				t.Errorf("Container kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestCleanupMissingContainer(t *testing.T) {
+	useFakeBackend(t)
+
+	err := Cleanup(CleanupOptions{
+		ContainerName: "coi-gone-1",
+		SessionID:     "session-1",
+		SessionsDir:   t.TempDir(),
+		SaveSession:   true,
+		Tool:          tool.NewClaude(),
+		Logger:        func(string) {},
+	})
+	if err != nil {
+		t.Errorf("Cleanup() of a missing container should not fail, got: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package session
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/gitcred"
+)
+
+const (
+	// gitCredentialDevice is the proxy device exposing the host's git proxy in the container
+	gitCredentialDevice = "git-credentials"
+
+	// containerGitProxyAddress is where git in the container reaches the host's git proxy
+	containerGitProxyAddress = "127.0.0.1:7431"
+)
+
+// GitCredentialSockets returns the host credential and control sockets of a container's proxy,
+// e.g. ~/.coi/run/coi-abc12345-1/git-credential.sock
+func GitCredentialSockets(runDir, containerName string) (string, string) {
+	dir := filepath.Join(runDir, containerName)
+	return filepath.Join(dir, "git-credential.sock"), filepath.Join(dir, "git-control.sock")
+}
+
+// StartGitCredentialProxy starts the host git proxy for a running container, exposes it in the
+// container and points git at it for the allowed hosts (in ~/.gitconfig of homeDir)
+func StartGitCredentialProxy(mgr *container.Manager, cfg config.GitCredentialsConfig, runDir string, uid int, homeDir string, logger func(string)) (*gitcred.Proxy, error) {
+	if len(cfg.Allow) == 0 {
+		return nil, fmt.Errorf("[git_credentials] is enabled but allows no remotes - add them to allow")
+	}
+	hosts, err := gitcred.Hosts(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("[git_credentials] allow: %w", err)
+	}
+
+	socket, control := GitCredentialSockets(runDir, mgr.ContainerName)
+	proxy := gitcred.NewProxy(mgr.ContainerName, cfg)
+	if err := proxy.Start(socket, control); err != nil {
+		return nil, err
+	}
+
+	if err := configureGitProxy(mgr, socket, hosts, uid, homeDir); err != nil {
+		proxy.Stop()
+		return nil, err
+	}
+
+	logger(fmt.Sprintf("Git credentials available for: %s", strings.Join(cfg.Allow, ", ")))
+	if cfg.ApprovePush {
+		logger("Pushes wait for approval with 'coi git approve'")
+	}
+	return proxy, nil
+}
+
+// configureGitProxy adds the proxy device (kept by persistent containers) and rewrites https remotes
+// of the allowed hosts to the proxy in the git config
+func configureGitProxy(mgr *container.Manager, socket string, hosts []string, uid int, homeDir string) error {
+	instance, err := container.GetBackend().GetInstance(mgr.ContainerName)
+	if err != nil {
+		return err
+	}
+	if _, exists := instance.Devices[gitCredentialDevice]; !exists {
+		if err := mgr.AddLoopbackProxy(gitCredentialDevice, socket, containerGitProxyAddress); err != nil {
+			return fmt.Errorf("failed to expose git proxy: %w", err)
+		}
+	}
+
+	script := `file=$1 uid=$2 base=$3
+shift 3
+for host; do
+  git config --file "$file" --replace-all "url.$base$host/.insteadOf" "https://$host/" || exit 1
+done
+chown "$uid:$uid" "$file"`
+	gitconfig := path.Join(homeDir, ".gitconfig")
+	args := append([]string{"sh", "-c", script, "sh", gitconfig, fmt.Sprintf("%d", uid), "http://" + containerGitProxyAddress + "/"}, hosts...)
+	if _, err := mgr.ExecArgsCapture(args, container.ExecCommandOptions{}); err != nil {
+		return fmt.Errorf("failed to configure git proxy: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+func TestGitCredentialSockets(t *testing.T) {
+	socket, control := GitCredentialSockets("/home/user/.coi/run", "coi-abc12345-1")
+	if socket != "/home/user/.coi/run/coi-abc12345-1/git-credential.sock" {
+		t.Errorf("Unexpected credential socket: %s", socket)
+	}
+	if control != "/home/user/.coi/run/coi-abc12345-1/git-control.sock" {
+		t.Errorf("Unexpected control socket: %s", control)
+	}
+}
+
+func TestSetupStartsGitCredentialProxy(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	runDir, err := os.MkdirTemp("", "coi-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(runDir) })
+	opts.RunDir = runDir
+	opts.GitCredentials = &config.GitCredentialsConfig{Enabled: true, Allow: []string{"github.com/org/*"}, ApprovePush: true}
+
+	result, err := Setup(opts)
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	if result.GitCredentials == nil {
+		t.Fatal("Expected a running credential proxy")
+	}
+	defer result.GitCredentials.Stop()
+
+	socket, control := GitCredentialSockets(runDir, result.ContainerName)
+	for _, path := range []string{socket, control} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Expected socket %s: %v", path, err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("Expected %s to be private, got %v", filepath.Base(path), info.Mode().Perm())
+		}
+	}
+
+	device, ok := backend.Instance(result.ContainerName).Devices[gitCredentialDevice]
+	if !ok {
+		t.Fatal("Expected the git proxy device")
+	}
+	if device["connect"] != "unix:"+socket || device["listen"] != "tcp:"+containerGitProxyAddress || device["bind"] != "container" {
+		t.Errorf("Unexpected device: %v", device)
+	}
+
+	cmds := strings.Join(backend.ExecCommands(), "\n")
+	if !strings.Contains(cmds, "http://"+containerGitProxyAddress+"/ github.com") {
+		t.Errorf("Expected https remotes of github.com to go through the proxy, got:\n%s", cmds)
+	}
+	if strings.Contains(cmds, "credential.helper") {
+		t.Error("Expected no credential helper in the container")
+	}
+}
+
+func TestSetupGitCredentialsRequireAllow(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.RunDir = t.TempDir()
+	opts.GitCredentials = &config.GitCredentialsConfig{Enabled: true}
+
+	if _, err := Setup(opts); err == nil || !strings.Contains(err.Error(), "allows no remotes") {
+		t.Errorf("Expected an error without allowed remotes, got %v", err)
+	}
+}
+
+func TestSetupGitCredentialsRefuseHostWildcards(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.RunDir = t.TempDir()
+	opts.GitCredentials = &config.GitCredentialsConfig{Enabled: true, Allow: []string{"*.example.com"}}
+
+	if _, err := Setup(opts); err == nil || !strings.Contains(err.Error(), "host must be given in full") {
+		t.Errorf("Expected host wildcards to be refused, got %v", err)
+	}
+}
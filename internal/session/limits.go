@@ -0,0 +1,102 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+var (
+	// cpuLimitPattern matches a CPU count ("2") or a CPU set ("0-3", "0,2,4-5")
+	cpuLimitPattern = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+	// sizePattern matches an Incus size ("512MiB", "4GiB", "20GB", "1073741824")
+	sizePattern = regexp.MustCompile(`^\d+(\.\d+)?(B|kB|MB|GB|TB|PB|EB|KiB|MiB|GiB|TiB|PiB|EiB)?$`)
+	// percentPattern matches a share of host memory ("50%")
+	percentPattern = regexp.MustCompile(`^\d+%$`)
+)
+
+// ValidateLimits checks that resource limits are in a format Incus accepts
+func ValidateLimits(limits config.LimitsConfig) error {
+	if limits.CPU != "" {
+		if !cpuLimitPattern.MatchString(limits.CPU) || limits.CPU == "0" {
+			return fmt.Errorf("invalid cpu limit '%s': must be a number of CPUs (e.g., 2) or a CPU set (e.g., 0-3)", limits.CPU)
+		}
+	}
+	if limits.Memory != "" && !sizePattern.MatchString(limits.Memory) && !percentPattern.MatchString(limits.Memory) {
+		return fmt.Errorf("invalid memory limit '%s': must be a size (e.g., 4GiB) or a percentage (e.g., 50%%)", limits.Memory)
+	}
+	if limits.Disk != "" && !sizePattern.MatchString(limits.Disk) {
+		return fmt.Errorf("invalid disk limit '%s': must be a size (e.g., 20GiB)", limits.Disk)
+	}
+	if limits.MaxProcesses < 0 {
+		return fmt.Errorf("invalid process limit %d: must be positive", limits.MaxProcesses)
+	}
+	return nil
+}
+
+// limitsConfigKeys returns the Incus config keys for resource limits (the disk size is a device setting)
+func limitsConfigKeys(limits config.LimitsConfig) map[string]string {
+	keys := make(map[string]string)
+	if limits.CPU != "" {
+		keys["limits.cpu"] = limits.CPU
+	}
+	if limits.Memory != "" {
+		keys["limits.memory"] = limits.Memory
+	}
+	if limits.MaxProcesses > 0 {
+		keys["limits.processes"] = strconv.Itoa(limits.MaxProcesses)
+	}
+	return keys
+}
+
+// ApplyLimits sets resource limits on a container, either before its first start or live
+// CPU, memory and process limits apply immediately; growing the root disk of a running
+// container depends on the storage driver
+func ApplyLimits(mgr *container.Manager, limits config.LimitsConfig) error {
+	if err := ValidateLimits(limits); err != nil {
+		return err
+	}
+
+	keys := limitsConfigKeys(limits)
+	for _, key := range []string{"limits.cpu", "limits.memory", "limits.processes"} {
+		value, ok := keys[key]
+		if !ok {
+			continue
+		}
+		if err := mgr.SetConfig(key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+
+	if limits.Disk != "" {
+		if err := mgr.SetRootDiskSize(limits.Disk); err != nil {
+			return fmt.Errorf("failed to set root disk size: %w", err)
+		}
+	}
+	return nil
+}
+
+// FormatLimits describes the set limits, e.g. "cpu=2 memory=4GiB disk=20GiB processes=500"
+func FormatLimits(limits config.LimitsConfig) string {
+	var parts []string
+	if limits.CPU != "" {
+		parts = append(parts, "cpu="+limits.CPU)
+	}
+	if limits.Memory != "" {
+		parts = append(parts, "memory="+limits.Memory)
+	}
+	if limits.Disk != "" {
+		parts = append(parts, "disk="+limits.Disk)
+	}
+	if limits.MaxProcesses > 0 {
+		parts = append(parts, fmt.Sprintf("processes=%d", limits.MaxProcesses))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
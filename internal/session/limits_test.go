@@ -0,0 +1,104 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+func TestValidateLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  config.LimitsConfig
+		wantErr bool
+	}{
+		{"No limits", config.LimitsConfig{}, false},
+		{"CPU count", config.LimitsConfig{CPU: "2"}, false},
+		{"CPU set", config.LimitsConfig{CPU: "0-3,6"}, false},
+		{"Zero CPUs", config.LimitsConfig{CPU: "0"}, true},
+		{"Invalid CPU", config.LimitsConfig{CPU: "two"}, true},
+		{"Memory size", config.LimitsConfig{Memory: "4GiB"}, false},
+		{"Memory percentage", config.LimitsConfig{Memory: "50%"}, false},
+		{"Invalid memory", config.LimitsConfig{Memory: "4 gigs"}, true},
+		{"Disk size", config.LimitsConfig{Disk: "20GiB"}, false},
+		{"Disk percentage", config.LimitsConfig{Disk: "50%"}, true},
+		{"Processes", config.LimitsConfig{MaxProcesses: 500}, false},
+		{"Negative processes", config.LimitsConfig{MaxProcesses: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLimits(tt.limits)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatLimits(t *testing.T) {
+	if got := FormatLimits(config.LimitsConfig{}); got != "none" {
+		t.Errorf("FormatLimits() = %q, want %q", got, "none")
+	}
+	got := FormatLimits(config.LimitsConfig{CPU: "2", Memory: "4GiB", Disk: "20GiB", MaxProcesses: 500})
+	if want := "cpu=2 memory=4GiB disk=20GiB processes=500"; got != want {
+		t.Errorf("FormatLimits() = %q, want %q", got, want)
+	}
+}
+
+func TestApplyLimits(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.AddInstance("coi-test-1", "Running")
+	mgr := container.NewManager("coi-test-1")
+
+	limits := config.LimitsConfig{CPU: "2", Memory: "4GiB", Disk: "20GiB", MaxProcesses: 500}
+	if err := ApplyLimits(mgr, limits); err != nil {
+		t.Fatalf("ApplyLimits() failed: %v", err)
+	}
+
+	inst := backend.Instance("coi-test-1")
+	for key, want := range map[string]string{"limits.cpu": "2", "limits.memory": "4GiB", "limits.processes": "500"} {
+		if got := inst.Config[key]; got != want {
+			t.Errorf("Config[%s] = %q, want %q", key, got, want)
+		}
+	}
+
+	// The profile's root disk is overridden on the instance with the size
+	root := inst.Devices["root"]
+	if root["size"] != "20GiB" || root["pool"] != "default" || root["path"] != "/" {
+		t.Errorf("Unexpected root device: %v", root)
+	}
+
+	// Changing limits live updates the overridden root disk
+	if err := ApplyLimits(mgr, config.LimitsConfig{Memory: "8GiB", Disk: "40GiB"}); err != nil {
+		t.Fatalf("ApplyLimits() live change failed: %v", err)
+	}
+	inst = backend.Instance("coi-test-1")
+	if inst.Config["limits.memory"] != "8GiB" || inst.Config["limits.cpu"] != "2" || inst.Devices["root"]["size"] != "40GiB" {
+		t.Errorf("Unexpected limits after live change: config=%v root=%v", inst.Config, inst.Devices["root"])
+	}
+
+	if err := ApplyLimits(mgr, config.LimitsConfig{Memory: "lots"}); err == nil {
+		t.Error("Expected error for invalid limits")
+	}
+}
+
+func TestSetupAppliesLimits(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.Limits = config.LimitsConfig{CPU: "2", MaxProcesses: 1000}
+
+	result, err := Setup(opts)
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+
+	inst := backend.Instance(result.ContainerName)
+	if inst.Config["limits.cpu"] != "2" || inst.Config["limits.processes"] != "1000" {
+		t.Errorf("Expected limits on the container, got: %v", inst.Config)
+	}
+	if _, ok := inst.Devices["root"]; ok {
+		t.Error("Expected root disk to be inherited without a disk limit")
+	}
+}
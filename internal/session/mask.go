@@ -0,0 +1,213 @@
+package session
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+// workspaceMasksKey records the masked workspace paths of a container (newline-separated)
+const workspaceMasksKey = "user.coi.masks"
+
+// MaskedPath is a workspace path hidden from the agent
+type MaskedPath struct {
+	Path string // Slash-separated path relative to the workspace
+	Dir  bool
+}
+
+// ValidateMaskPatterns checks that mask patterns are valid globs
+func ValidateMaskPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		p := strings.Trim(pattern, "/")
+		if p == "" || p == "." || strings.HasPrefix(p, "../") || p == ".." {
+			return fmt.Errorf("invalid mask pattern '%s': must name a path inside the workspace", pattern)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid mask pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// MatchMasks returns the workspace paths matching mask patterns, gitignore-style:
+// "name" or "*.pem" matches at any depth, "dir/" matches directories only, and a pattern
+// with a slash inside ("config/*.key") is relative to the workspace root
+// Matched directories are not searched further; symlinks are skipped since mounting over them would follow the link
+func MatchMasks(root string, patterns []string) ([]MaskedPath, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	if err := ValidateMaskPatterns(patterns); err != nil {
+		return nil, err
+	}
+
+	var masked []MaskedPath
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, pattern := range patterns {
+			if matchMask(pattern, rel, d.IsDir()) {
+				masked = append(masked, MaskedPath{Path: rel, Dir: d.IsDir()})
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		return nil
+	})
+	return masked, err
+}
+
+// matchMask reports whether a workspace path matches one mask pattern
+func matchMask(pattern, rel string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	if strings.Contains(strings.TrimPrefix(pattern, "/"), "/") || strings.HasPrefix(pattern, "/") {
+		ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), rel)
+		return ok
+	}
+	ok, _ := path.Match(pattern, path.Base(rel))
+	return ok
+}
+
+// MaskWorkspace hides workspace paths matching patterns from the agent
+// Direct mode mounts an empty read-only directory or file over each path; these mounts are set up
+// by Incus, so they cannot be removed from inside the container (not even with sudo).
+// Copy mode deletes the paths from the copy (see prepareWorkspace). Overlay mode is not supported:
+// the read-only lower layer would still expose the files.
+// The masked paths are recorded on the container, and workspace diffs never touch them.
+func MaskWorkspace(mgr *container.Manager, mode, hostPath string, patterns []string, masksDir string, useShift bool, logger func(string)) error {
+	masked, err := MatchMasks(hostPath, patterns)
+	if err != nil {
+		return fmt.Errorf("failed to match mask patterns: %w", err)
+	}
+	if len(masked) == 0 {
+		if len(patterns) > 0 {
+			logger("No workspace paths match the mask patterns")
+		}
+		return nil
+	}
+	if mode == WorkspaceModeOverlay {
+		return fmt.Errorf("masking is not supported in overlay workspace mode - use direct or copy")
+	}
+
+	paths := make([]string, len(masked))
+	for i, m := range masked {
+		paths[i] = m.Path
+	}
+	if err := mgr.SetConfig(workspaceMasksKey, strings.Join(paths, "\n")); err != nil {
+		return fmt.Errorf("failed to record masked paths: %w", err)
+	}
+	logger(fmt.Sprintf("Masking %d workspace path(s): %s", len(paths), strings.Join(paths, ", ")))
+
+	if mode == WorkspaceModeCopy {
+		return nil // Left out of the copy when it is made
+	}
+
+	emptyDir, emptyFile, err := maskSources(masksDir)
+	if err != nil {
+		return err
+	}
+	for i, m := range masked {
+		source := emptyFile
+		if m.Dir {
+			source = emptyDir
+		}
+		if err := mgr.MountDiskReadOnly(fmt.Sprintf("mask-%d", i), source, path.Join(ContainerWorkspace, m.Path), useShift); err != nil {
+			return fmt.Errorf("failed to mask %s: %w", m.Path, err)
+		}
+	}
+	return nil
+}
+
+// checkReusedMasks compares the paths mask patterns match now with those masked when a reused
+// container was created; masks are only applied at creation, so paths the container does not
+// hide are an error, and paths it still hides without being asked to are a warning
+func checkReusedMasks(mgr *container.Manager, hostPath string, patterns []string, logger func(string)) error {
+	info, err := GetWorkspaceInfo(mgr)
+	if err != nil {
+		return fmt.Errorf("failed to read masked paths: %w", err)
+	}
+	wanted, err := MatchMasks(hostPath, patterns)
+	if err != nil {
+		return fmt.Errorf("failed to match mask patterns: %w", err)
+	}
+
+	extra := make(map[string]bool, len(info.Masked))
+	for _, p := range info.Masked {
+		extra[p] = true
+	}
+	var missing []string
+	for _, m := range wanted {
+		if !extra[m.Path] {
+			missing = append(missing, m.Path)
+		}
+		delete(extra, m.Path)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("container %s does not mask %s: masks are only applied when a container is created - remove it with 'coi kill %s' to apply them",
+			mgr.ContainerName, strings.Join(missing, ", "), mgr.ContainerName)
+	}
+	if len(extra) > 0 {
+		unrequested := make([]string, 0, len(extra))
+		for p := range extra {
+			unrequested = append(unrequested, p)
+		}
+		sort.Strings(unrequested)
+		logger(fmt.Sprintf("Warning: container %s still masks %s (masks only change when the container is recreated)", mgr.ContainerName, strings.Join(unrequested, ", ")))
+	}
+	return nil
+}
+
+// maskSources creates the empty directory and file mounted over masked paths
+// They live in masksDir (not a temp directory) so persistent containers can start again after a reboot
+func maskSources(masksDir string) (string, string, error) {
+	emptyDir := filepath.Join(masksDir, "empty")
+	emptyFile := filepath.Join(masksDir, "empty-file")
+	if err := os.MkdirAll(emptyDir, 0o755); err != nil {
+		return "", "", fmt.Errorf("failed to create mask directory: %w", err)
+	}
+	if _, err := os.Stat(emptyFile); os.IsNotExist(err) {
+		if err := os.WriteFile(emptyFile, nil, 0o444); err != nil {
+			return "", "", fmt.Errorf("failed to create mask file: %w", err)
+		}
+	}
+	return emptyDir, emptyFile, nil
+}
+
+// touchesMasked reports whether applying or discarding a change would touch a masked path
+func touchesMasked(change WorkspaceChange, masked []string) bool {
+	for _, m := range masked {
+		if change.Path == m || strings.HasPrefix(change.Path, m+"/") {
+			return true
+		}
+		// Replacing or removing a directory would also remove the masked paths inside it
+		if change.Dir && strings.HasPrefix(m, change.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{pattern: ".env", rel: ".env", want: true},
+		{pattern: ".env", rel: "services/api/.env", want: true},
+		{pattern: ".env", rel: ".env.example", want: false},
+		{pattern: "*.pem", rel: "certs/server.pem", want: true},
+		{pattern: "secrets/", rel: "secrets", isDir: true, want: true},
+		{pattern: "secrets/", rel: "secrets", isDir: false, want: false},
+		{pattern: "config/*.key", rel: "config/app.key", want: true},
+		{pattern: "config/*.key", rel: "other/config/app.key", want: false},
+		{pattern: "/.env", rel: "sub/.env", want: false},
+		{pattern: "/.env", rel: ".env", want: true},
+	}
+	for _, tt := range tests {
+		if got := matchMask(tt.pattern, tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("matchMask(%q, %q, %v) = %v, want %v", tt.pattern, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestValidateMaskPatterns(t *testing.T) {
+	if err := ValidateMaskPatterns([]string{".env", "secrets/", "*.pem", "/config/*.key"}); err != nil {
+		t.Errorf("ValidateMaskPatterns() = %v, want nil", err)
+	}
+	for _, pattern := range []string{"", "/", ".", "..", "../secrets", "[bad"} {
+		if err := ValidateMaskPatterns([]string{pattern}); err == nil {
+			t.Errorf("ValidateMaskPatterns(%q) = nil, want error", pattern)
+		}
+	}
+}
+
+func TestMatchMasks(t *testing.T) {
+	root := t.TempDir()
+	writeHostFile(t, root, ".env", "TOKEN=1\n")
+	writeHostFile(t, root, "api/.env", "TOKEN=2\n")
+	writeHostFile(t, root, "secrets/prod/key.pem", "key\n")
+	writeHostFile(t, root, "certs/server.pem", "cert\n")
+	writeHostFile(t, root, "main.go", "package main\n")
+	if err := os.Symlink("main.go", filepath.Join(root, "link.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	masked, err := MatchMasks(root, []string{".env", "secrets/", "*.pem"})
+	if err != nil {
+		t.Fatalf("MatchMasks() failed: %v", err)
+	}
+	want := []MaskedPath{
+		{Path: ".env"},
+		{Path: "api/.env"},
+		{Path: "certs/server.pem"},
+		{Path: "secrets", Dir: true},
+	}
+	if !reflect.DeepEqual(masked, want) {
+		t.Errorf("MatchMasks() = %+v, want %+v", masked, want)
+	}
+}
+
+func TestSetupMasksDirectWorkspace(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.Masks = []string{".env", "secrets/"}
+	opts.MasksDir = t.TempDir()
+	writeHostFile(t, opts.WorkspacePath, ".env", "TOKEN=1\n")
+	writeHostFile(t, opts.WorkspacePath, "secrets/key", "key\n")
+
+	result, err := Setup(opts)
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+
+	devices := backend.Instance(result.ContainerName).Devices
+	wantDevices := map[string]map[string]string{
+		"mask-0": {"path": "/workspace/.env", "source": filepath.Join(opts.MasksDir, "empty-file")},
+		"mask-1": {"path": "/workspace/secrets", "source": filepath.Join(opts.MasksDir, "empty")},
+	}
+	for name, want := range wantDevices {
+		device := devices[name]
+		if device["path"] != want["path"] || device["source"] != want["source"] || device["readonly"] != "true" {
+			t.Errorf("Unexpected %s device: %v", name, device)
+		}
+	}
+
+	info, err := GetWorkspaceInfo(result.Manager)
+	if err != nil {
+		t.Fatalf("GetWorkspaceInfo() failed: %v", err)
+	}
+	if !reflect.DeepEqual(info.Masked, []string{".env", "secrets"}) {
+		t.Errorf("GetWorkspaceInfo().Masked = %v, want [.env secrets]", info.Masked)
+	}
+}
+
+func TestSetupMasksCopyWorkspace(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.WorkspaceMode = WorkspaceModeCopy
+	opts.Masks = []string{"*.pem", ".env"}
+	opts.MasksDir = t.TempDir()
+	writeHostFile(t, opts.WorkspacePath, "certs/server.pem", "cert\n")
+	writeHostFile(t, opts.WorkspacePath, "certs/README", "certs\n")
+	writeHostFile(t, opts.WorkspacePath, ".env", "TOKEN=secret\n")
+	writeHostFile(t, opts.WorkspacePath, "src/main.go", "package main\n")
+
+	result, err := Setup(opts)
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+
+	if _, ok := backend.Instance(result.ContainerName).Devices["mask-0"]; ok {
+		t.Error("Expected no mask devices in copy mode")
+	}
+
+	// Masked paths are never pushed, the rest of the tree is
+	staged := "/var/lib/coi/import/" + filepath.Base(opts.WorkspacePath)
+	for _, p := range []string{"certs/server.pem", ".env"} {
+		if _, pushed := backend.File(result.ContainerName, staged+"/"+p); pushed {
+			t.Errorf("Expected masked %s left out of the copy", p)
+		}
+	}
+	for _, p := range []string{"certs/README", "src/main.go"} {
+		if _, pushed := backend.File(result.ContainerName, staged+"/"+p); !pushed {
+			t.Errorf("Expected %s copied", p)
+		}
+	}
+}
+
+func TestSetupMasksOverlayWorkspace(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.WorkspaceMode = WorkspaceModeOverlay
+	opts.Masks = []string{".env"}
+	opts.MasksDir = t.TempDir()
+	writeHostFile(t, opts.WorkspacePath, ".env", "TOKEN=1\n")
+
+	if _, err := Setup(opts); err == nil {
+		t.Error("Expected error masking an overlay workspace")
+	}
+}
+
+func TestSetupChecksMasksOfReusedContainer(t *testing.T) {
+	tests := []struct {
+		name    string
+		masks   []string
+		wantErr bool
+	}{
+		{name: "same masks", masks: []string{".env"}},
+		{name: "fewer masks only warn", masks: nil},
+		{name: "new mask is an error", masks: []string{".env", "secrets/"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := useFakeBackend(t)
+			opts := fakeSetupOptions(t, backend)
+			opts.Persistent = true
+			opts.Masks = tt.masks
+			opts.MasksDir = t.TempDir()
+			writeHostFile(t, opts.WorkspacePath, ".env", "TOKEN=1\n")
+			writeHostFile(t, opts.WorkspacePath, "secrets/key", "key\n")
+
+			// Created with only .env masked
+			containerName := ContainerName(opts.WorkspacePath, opts.Slot)
+			backend.AddInstance(containerName, "Running")
+			if err := backend.SetConfig(containerName, workspaceMasksKey, ".env"); err != nil {
+				t.Fatal(err)
+			}
+
+			var logs []string
+			opts.Logger = func(msg string) { logs = append(logs, msg) }
+			_, err := Setup(opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Setup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "secrets") {
+				t.Errorf("Expected the unmasked path in the error, got: %v", err)
+			}
+			warned := strings.Contains(strings.Join(logs, "\n"), "still masks .env")
+			if warned != (tt.masks == nil) {
+				t.Errorf("Expected warning=%v about the extra mask, logs:\n%s", tt.masks == nil, strings.Join(logs, "\n"))
+			}
+		})
+	}
+}
+
+func TestTouchesMasked(t *testing.T) {
+	masked := []string{".env", "config/secrets"}
+	tests := []struct {
+		change WorkspaceChange
+		want   bool
+	}{
+		{change: WorkspaceChange{Path: ".env"}, want: true},
+		{change: WorkspaceChange{Path: "config/secrets/key"}, want: true},
+		{change: WorkspaceChange{Path: "config", Dir: true}, want: true},
+		{change: WorkspaceChange{Path: "config/app.toml"}, want: false},
+		{change: WorkspaceChange{Path: ".env.example"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := touchesMasked(tt.change, masked); got != tt.want {
+			t.Errorf("touchesMasked(%q) = %v, want %v", tt.change.Path, got, tt.want)
+		}
+	}
+}
+
+func TestSetupReadOnlyMount(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.MountConfig = &MountConfig{Mounts: []MountEntry{
+		{HostPath: t.TempDir(), ContainerPath: "/home/code/.aws", DeviceName: "mount-0", ReadOnly: true},
+		{HostPath: t.TempDir(), ContainerPath: "/data", DeviceName: "mount-1"},
+	}}
+
+	result, err := Setup(opts)
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+
+	devices := backend.Instance(result.ContainerName).Devices
+	if devices["mount-0"]["readonly"] != "true" {
+		t.Errorf("Expected read-only mount, got %v", devices["mount-0"])
+	}
+	if devices["mount-1"]["readonly"] == "true" {
+		t.Errorf("Expected writable mount, got %v", devices["mount-1"])
+	}
+}
@@ -0,0 +1,319 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/tool"
+)
+
+const (
+	// exitStatusDir is owned by root, so other users in the container cannot plant or replace the status file
+	exitStatusDir = "/var/lib/coi/session"
+
+	// ExitStatusFile is where the session command writes the tool's exit status in the container
+	ExitStatusFile = exitStatusDir + "/exit-status"
+)
+
+// NotesFileName is the notes file of a session, in its session directory
+const NotesFileName = "notes.md"
+
+// SessionMetadata contains information about a saved session
+type SessionMetadata struct {
+	SessionID        string            `json:"session_id"`
+	ContainerName    string            `json:"container_name"`
+	Persistent       bool              `json:"persistent"`
+	Workspace        string            `json:"workspace"`
+	SavedAt          string            `json:"saved_at"`
+	Worktree         string            `json:"worktree"`                    // Slot worktree used as /workspace, if any
+	Branch           string            `json:"branch"`                      // Branch of the worktree
+	Name             string            `json:"name,omitempty"`              // Set with shell --name
+	Labels           map[string]string `json:"labels,omitempty"`            // Set with shell --label and 'coi session label'
+	NotesFile        string            `json:"notes_file,omitempty"`        // Relative to the session directory, see 'coi session notes'
+	GitStart         *GitState         `json:"git_start,omitempty"`         // Checkout when the session first started
+	GitEnd           *GitState         `json:"git_end,omitempty"`           // Checkout when the session was last saved
+	ImageFingerprint string            `json:"image_fingerprint,omitempty"` // Image the container was created from
+	ToolVersion      string            `json:"tool_version,omitempty"`
+	NetworkMode      string            `json:"network_mode,omitempty"`
+	ExitStatus       *int              `json:"exit_status,omitempty"` // Tool's exit status, if it exited before the session was saved
+}
+
+// GitState is the branch and HEAD commit of a git checkout
+type GitState struct {
+	Branch string `json:"branch"` // "HEAD" when detached
+	Commit string `json:"commit"`
+}
+
+// SessionStart is what the user sets on a session when starting it
+type SessionStart struct {
+	Name        string
+	Labels      map[string]string // Added to the session's labels (replacing the same keys)
+	NetworkMode string
+}
+
+// saveMetadata saves session metadata to a JSON file
+func saveMetadata(path string, metadata SessionMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// LoadSessionMetadata loads session metadata from a JSON file
+func LoadSessionMetadata(path string) (*SessionMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata SessionMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	if metadata.SessionID == "" {
+		return nil, fmt.Errorf("invalid metadata: missing session_id")
+	}
+
+	return &metadata, nil
+}
+
+// UpdateMetadata loads the metadata file at path, changes it with update and saves it
+func UpdateMetadata(path string, update func(*SessionMetadata)) error {
+	metadata, err := LoadSessionMetadata(path)
+	if err != nil {
+		return err
+	}
+	update(metadata)
+	return saveMetadata(path, *metadata)
+}
+
+// getCurrentTime returns current time in RFC3339 format
+func getCurrentTime() string {
+	return time.Now().Format(time.RFC3339)
+}
+
+// SaveMetadataEarly saves session metadata at session start so coi list can show correct status
+// What the user set on a resumed session (name, labels, notes) is kept
+func SaveMetadataEarly(sessionsDir, sessionID, containerName, workspace string, persistent bool) error {
+	// Create session directory if it doesn't exist
+	sessionDir := filepath.Join(sessionsDir, sessionID)
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	metadataPath := filepath.Join(sessionDir, "metadata.json")
+	metadata := SessionMetadata{}
+	if previous, err := LoadSessionMetadata(metadataPath); err == nil {
+		metadata = *previous
+	}
+	metadata.SessionID = sessionID
+	metadata.ContainerName = containerName
+	metadata.Persistent = persistent
+	metadata.Workspace = workspace
+	metadata.SavedAt = getCurrentTime()
+
+	return saveMetadata(metadataPath, metadata)
+}
+
+// RecordWorktree adds a session's worktree to its metadata
+func RecordWorktree(sessionsDir, sessionID string, wt *Worktree) error {
+	return UpdateMetadata(filepath.Join(sessionsDir, sessionID, "metadata.json"), func(metadata *SessionMetadata) {
+		metadata.Worktree = wt.Path
+		metadata.Branch = wt.Branch
+	})
+}
+
+// RecordStart adds what is known when a session starts to its metadata: the user's name and
+// labels, the git state of the checkout, the image fingerprint, the tool version and network mode
+func RecordStart(sessionsDir, sessionID string, mgr *container.Manager, t tool.Tool, start SessionStart) error {
+	var fingerprint string
+	if instance, err := container.GetBackend().GetInstance(mgr.ContainerName); err == nil {
+		fingerprint = instance.ConfigValue("volatile.base_image")
+	}
+	toolVersion := toolVersion(mgr, t)
+
+	return UpdateMetadata(filepath.Join(sessionsDir, sessionID, "metadata.json"), func(metadata *SessionMetadata) {
+		if start.Name != "" {
+			metadata.Name = start.Name
+		}
+		for key, value := range start.Labels {
+			if metadata.Labels == nil {
+				metadata.Labels = make(map[string]string)
+			}
+			metadata.Labels[key] = value
+		}
+		// A resumed session keeps its first start, so start and end span the whole session
+		if metadata.GitStart == nil {
+			metadata.GitStart = checkoutGitState(mgr, metadata)
+		}
+		metadata.ImageFingerprint = fingerprint
+		metadata.ToolVersion = toolVersion
+		metadata.NetworkMode = start.NetworkMode
+	})
+}
+
+// checkout returns the directory the agent works on: the worktree, or the workspace
+func (m *SessionMetadata) checkout() string {
+	if m.Worktree != "" {
+		return m.Worktree
+	}
+	return m.Workspace
+}
+
+// checkoutGitState returns the git state of the checkout the agent works on
+// Overlay and copy workspaces are read in the container, where the agent's commits are
+// (nil if the container is not running)
+func checkoutGitState(mgr *container.Manager, metadata *SessionMetadata) *GitState {
+	if info, err := GetWorkspaceInfo(mgr); err == nil && info.Mode != WorkspaceModeDirect {
+		return containerGitState(mgr)
+	}
+	return gitState(metadata.checkout())
+}
+
+// containerGitState returns the branch and HEAD commit of the container's /workspace
+// (nil if it is not a git repository or the container is not running)
+func containerGitState(mgr *container.Manager) *GitState {
+	if running, err := mgr.Running(); err != nil || !running {
+		return nil
+	}
+	user := container.CodeUID
+	out, err := mgr.ExecCommand("git -C "+ContainerWorkspace+" rev-parse HEAD --abbrev-ref HEAD", container.ExecCommandOptions{Capture: true, User: &user})
+	if err != nil {
+		return nil
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return nil
+	}
+	return &GitState{Branch: fields[1], Commit: fields[0]}
+}
+
+// gitState returns the branch and HEAD commit of the checkout at dir (nil if it is not a git repository)
+func gitState(dir string) *GitState {
+	if dir == "" {
+		return nil
+	}
+	commit, err := git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil
+	}
+	branch, err := git(dir, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return nil
+	}
+	return &GitState{Branch: branch, Commit: commit}
+}
+
+// toolVersion returns the first line of the tool's --version output in the container ("" if unknown)
+func toolVersion(mgr *container.Manager, t tool.Tool) string {
+	if t == nil || t.Binary() == "" {
+		return ""
+	}
+	user := container.CodeUID
+	out, err := mgr.ExecCommand(t.Binary()+" --version", container.ExecCommandOptions{Capture: true, User: &user})
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	return strings.TrimSpace(line)
+}
+
+// resetExitStatus creates an empty ExitStatusFile that only uid can write, replacing a previous run's
+// (persistent containers), so it is not taken for this one's
+func resetExitStatus(mgr *container.Manager, uid int) error {
+	script := `mkdir -p "$1" && chown root:root "$1" && chmod 0755 "$1" && rm -f "$2" && : > "$2" && chown "$3:$3" "$2" && chmod 0600 "$2"`
+	if _, err := mgr.ExecArgsCapture([]string{"sh", "-c", script, "sh", exitStatusDir, ExitStatusFile, strconv.Itoa(uid)}, container.ExecCommandOptions{}); err != nil {
+		return fmt.Errorf("failed to prepare exit status file: %w", err)
+	}
+	return nil
+}
+
+// readExitStatus returns the tool's exit status written to ExitStatusFile (nil if it has not exited)
+// Works on stopped containers too
+func readExitStatus(mgr *container.Manager) *int {
+	tmp, err := os.MkdirTemp("", "coi-exit-status-*")
+	if err != nil {
+		return nil
+	}
+	defer os.RemoveAll(tmp)
+
+	local := filepath.Join(tmp, "status")
+	if err := mgr.PullFile(ExitStatusFile, local); err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(local)
+	if err != nil {
+		return nil
+	}
+	status, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil
+	}
+	return &status
+}
+
+// labelKeyPattern matches label keys, e.g. "ticket" or "team.area"
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// ParseLabels parses labels given as key=value
+func ParseLabels(args []string) (map[string]string, error) {
+	labels := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid label '%s': must be key=value", arg)
+		}
+		if !labelKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid label key '%s': use letters, digits, '.', '_', '/' and '-'", key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// ParseLabelFilter parses label filters given as key=value (that value) or key (any value)
+func ParseLabelFilter(args []string) (map[string]string, error) {
+	filter := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, "=")
+		if !labelKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid label filter '%s': must be key or key=value", arg)
+		}
+		filter[key] = value
+	}
+	return filter, nil
+}
+
+// Matches reports whether a session's name contains name (ignoring case) and it has all
+// labels in filter (an empty value matches any value)
+func (m *SessionMetadata) Matches(name string, filter map[string]string) bool {
+	if name != "" && !strings.Contains(strings.ToLower(m.Name), strings.ToLower(name)) {
+		return false
+	}
+	for key, value := range filter {
+		actual, ok := m.Labels[key]
+		if !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatLabels formats labels as sorted key=value pairs, e.g. "team=infra ticket=ENG-123"
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
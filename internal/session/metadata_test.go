@@ -0,0 +1,342 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/container/fake"
+	"github.com/mensfeld/code-on-incus/internal/tool"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	status := 3
+	want := SessionMetadata{
+		SessionID:     "session-1",
+		ContainerName: "coi-test-1",
+		Workspace:     `/home/user/my "quoted", project`,
+		SavedAt:       "2025-01-02T03:04:05Z",
+		Name:          "Fix the flaky test",
+		Labels:        map[string]string{"ticket": "ENG-123"},
+		GitStart:      &GitState{Branch: "main", Commit: "abc123"},
+		ExitStatus:    &status,
+	}
+	if err := saveMetadata(path, want); err != nil {
+		t.Fatalf("saveMetadata() failed: %v", err)
+	}
+
+	got, err := LoadSessionMetadata(path)
+	if err != nil {
+		t.Fatalf("LoadSessionMetadata() failed: %v", err)
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("LoadSessionMetadata() = %+v, want %+v", *got, want)
+	}
+}
+
+func TestLoadSessionMetadataInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"broken.json":     `{"session_id": `,
+		"no-session.json": `{"workspace": "/home/user/project"}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSessionMetadata(path); err == nil {
+			t.Errorf("LoadSessionMetadata(%s) should fail", name)
+		}
+	}
+}
+
+func TestSaveMetadataEarlyKeepsUserFields(t *testing.T) {
+	sessionsDir := t.TempDir()
+	path := filepath.Join(sessionsDir, "session-1", "metadata.json")
+	if err := SaveMetadataEarly(sessionsDir, "session-1", "coi-test-1", "/home/user/project", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateMetadata(path, func(m *SessionMetadata) {
+		m.Name = "Refactor"
+		m.Labels = map[string]string{"team": "infra"}
+		m.NotesFile = NotesFileName
+	}); err != nil {
+		t.Fatalf("UpdateMetadata() failed: %v", err)
+	}
+
+	// Resumed in another container
+	if err := SaveMetadataEarly(sessionsDir, "session-1", "coi-test-2", "/home/user/project", true); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := LoadSessionMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.ContainerName != "coi-test-2" || !metadata.Persistent {
+		t.Errorf("Expected container fields updated, got %+v", metadata)
+	}
+	if metadata.Name != "Refactor" || metadata.Labels["team"] != "infra" || metadata.NotesFile != NotesFileName {
+		t.Errorf("Expected name, labels and notes kept, got %+v", metadata)
+	}
+}
+
+func TestUpdateMetadataMissing(t *testing.T) {
+	if err := UpdateMetadata(filepath.Join(t.TempDir(), "metadata.json"), func(*SessionMetadata) {}); err == nil {
+		t.Error("UpdateMetadata() should fail without metadata")
+	}
+}
+
+func TestRecordStart(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.AddInstance("coi-test-1", "Running")
+	if err := backend.SetConfig("coi-test-1", "volatile.base_image", "f00dcafe"); err != nil {
+		t.Fatal(err)
+	}
+	backend.SetExecHandler(func(call fake.ExecCall) (string, error) {
+		if strings.Contains(strings.Join(call.Command, " "), "claude --version") {
+			return "1.0.42 (Claude Code)\n", nil
+		}
+		return "", nil
+	})
+
+	repo := initGitRepo(t)
+	commit, err := git(repo, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionsDir := t.TempDir()
+	path := filepath.Join(sessionsDir, "session-1", "metadata.json")
+	if err := SaveMetadataEarly(sessionsDir, "session-1", "coi-test-1", repo, false); err != nil {
+		t.Fatal(err)
+	}
+	start := SessionStart{Name: "Upgrade deps", Labels: map[string]string{"ticket": "ENG-1"}, NetworkMode: "restricted"}
+	if err := RecordStart(sessionsDir, "session-1", container.NewManager("coi-test-1"), tool.NewClaude(), start); err != nil {
+		t.Fatalf("RecordStart() failed: %v", err)
+	}
+
+	metadata, err := LoadSessionMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Name != "Upgrade deps" || metadata.Labels["ticket"] != "ENG-1" || metadata.NetworkMode != "restricted" {
+		t.Errorf("Expected name, labels and network mode, got %+v", metadata)
+	}
+	if metadata.ImageFingerprint != "f00dcafe" {
+		t.Errorf("ImageFingerprint = %q, want f00dcafe", metadata.ImageFingerprint)
+	}
+	if metadata.ToolVersion != "1.0.42 (Claude Code)" {
+		t.Errorf("ToolVersion = %q", metadata.ToolVersion)
+	}
+	if want := (&GitState{Branch: "main", Commit: commit}); !reflect.DeepEqual(metadata.GitStart, want) {
+		t.Errorf("GitStart = %+v, want %+v", metadata.GitStart, want)
+	}
+
+	// Resuming adds labels and keeps the first git state
+	writeHostFile(t, repo, "CHANGES.md", "more\n")
+	for _, args := range [][]string{{"add", "."}, {"commit", "-q", "-m", "second"}} {
+		if _, err := git(repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start = SessionStart{Labels: map[string]string{"team": "infra"}, NetworkMode: "open"}
+	if err := RecordStart(sessionsDir, "session-1", container.NewManager("coi-test-1"), tool.NewClaude(), start); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err = LoadSessionMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Name != "Upgrade deps" || len(metadata.Labels) != 2 || metadata.NetworkMode != "open" {
+		t.Errorf("Unexpected metadata after resume: %+v", metadata)
+	}
+	if metadata.GitStart == nil || metadata.GitStart.Commit != commit {
+		t.Errorf("Expected first GitStart kept, got %+v", metadata.GitStart)
+	}
+}
+
+func TestCleanupRecordsExitStatusAndGitEnd(t *testing.T) {
+	backend := useFakeBackend(t)
+	fastStopPolling(t)
+
+	backend.AddInstance("coi-test-1", "Stopped")
+	if err := backend.WriteFile("coi-test-1", "/home/code/.claude/projects/history.jsonl", []byte("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.WriteFile("coi-test-1", ExitStatusFile, []byte("2\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := initGitRepo(t)
+	sessionsDir := t.TempDir()
+	err := Cleanup(CleanupOptions{
+		ContainerName: "coi-test-1",
+		SessionID:     "session-1",
+		SessionsDir:   sessionsDir,
+		SaveSession:   true,
+		Workspace:     repo,
+		Tool:          tool.NewClaude(),
+		Logger:        func(string) {},
+	})
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+
+	metadata, err := LoadSessionMetadata(filepath.Join(sessionsDir, "session-1", "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.ExitStatus == nil || *metadata.ExitStatus != 2 {
+		t.Errorf("ExitStatus = %v, want 2", metadata.ExitStatus)
+	}
+	if metadata.GitEnd == nil || metadata.GitEnd.Branch != "main" {
+		t.Errorf("GitEnd = %+v, want main branch", metadata.GitEnd)
+	}
+}
+
+func TestSetupResetsExitStatus(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+
+	if _, err := Setup(opts); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+
+	// Recreated empty in a root-owned directory, writable only by the user the tool runs as
+	var reset []string
+	for _, call := range backend.ExecCalls() {
+		if len(call.Command) > 3 && call.Command[len(call.Command)-2] == ExitStatusFile {
+			reset = call.Command[len(call.Command)-3:]
+		}
+	}
+	if want := []string{"/var/lib/coi/session", ExitStatusFile, "1000"}; !reflect.DeepEqual(reset, want) {
+		t.Errorf("Expected exit status reset with %v, got %v", want, reset)
+	}
+}
+
+func TestCleanupReadsGitEndInContainerWorkspace(t *testing.T) {
+	backend := useFakeBackend(t)
+	fastStopPolling(t)
+
+	backend.AddInstance("coi-test-1", "Running")
+	if err := backend.SetConfig("coi-test-1", workspaceModeKey, WorkspaceModeCopy); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.WriteFile("coi-test-1", "/home/code/.claude/projects/history.jsonl", []byte("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+	// The agent's commit exists only in the container's copy
+	backend.SetExecHandler(func(call fake.ExecCall) (string, error) {
+		if strings.Contains(strings.Join(call.Command, " "), "git -C /workspace rev-parse") {
+			return "0123abcd\nagent-work\n", nil
+		}
+		return "", nil
+	})
+
+	repo := initGitRepo(t)
+	sessionsDir := t.TempDir()
+	err := Cleanup(CleanupOptions{
+		ContainerName: "coi-test-1",
+		SessionID:     "session-1",
+		SessionsDir:   sessionsDir,
+		SaveSession:   true,
+		Persistent:    true,
+		Workspace:     repo,
+		Tool:          tool.NewClaude(),
+		Logger:        func(string) {},
+	})
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+
+	metadata, err := LoadSessionMetadata(filepath.Join(sessionsDir, "session-1", "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&GitState{Branch: "agent-work", Commit: "0123abcd"}); !reflect.DeepEqual(metadata.GitEnd, want) {
+		t.Errorf("GitEnd = %+v, want %+v", metadata.GitEnd, want)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{[]string{"ticket=ENG-123", "team.area=infra"}, map[string]string{"ticket": "ENG-123", "team.area": "infra"}, false},
+		{[]string{"note=a=b"}, map[string]string{"note": "a=b"}, false},
+		{[]string{"ticket"}, nil, true},
+		{[]string{"ticket="}, nil, true},
+		{[]string{"=value"}, nil, true},
+		{[]string{"bad key=value"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLabels(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLabels(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLabels(%v) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestParseLabelFilter(t *testing.T) {
+	got, err := ParseLabelFilter([]string{"ticket=ENG-123", "team"})
+	if err != nil {
+		t.Fatalf("ParseLabelFilter() failed: %v", err)
+	}
+	if want := map[string]string{"ticket": "ENG-123", "team": ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLabelFilter() = %v, want %v", got, want)
+	}
+	if _, err := ParseLabelFilter([]string{"=ENG-123"}); err == nil {
+		t.Error("ParseLabelFilter() should reject an empty key")
+	}
+}
+
+func TestMetadataMatches(t *testing.T) {
+	metadata := SessionMetadata{Name: "Fix Flaky Test", Labels: map[string]string{"ticket": "ENG-123", "team": "infra"}}
+	tests := []struct {
+		name   string
+		filter map[string]string
+		want   bool
+	}{
+		{"", nil, true},
+		{"flaky", nil, true},
+		{"deploy", nil, false},
+		{"", map[string]string{"ticket": "ENG-123"}, true},
+		{"", map[string]string{"ticket": "ENG-999"}, false},
+		{"", map[string]string{"team": ""}, true},
+		{"", map[string]string{"owner": ""}, false},
+		{"fix", map[string]string{"team": "infra", "ticket": ""}, true},
+	}
+	for _, tt := range tests {
+		if got := metadata.Matches(tt.name, tt.filter); got != tt.want {
+			t.Errorf("Matches(%q, %v) = %v, want %v", tt.name, tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	if got := FormatLabels(map[string]string{"ticket": "ENG-123", "team": "infra"}); got != "team=infra ticket=ENG-123" {
+		t.Errorf("FormatLabels() = %q", got)
+	}
+	if got := FormatLabels(nil); got != "" {
+		t.Errorf("FormatLabels(nil) = %q, want empty", got)
+	}
+}
+
+func TestGitStateNotRepository(t *testing.T) {
+	if state := gitState(t.TempDir()); state != nil {
+		t.Errorf("gitState() = %+v, want nil outside a repository", state)
+	}
+	if state := gitState(""); state != nil {
+		t.Errorf("gitState(\"\") = %+v, want nil", state)
+	}
+}
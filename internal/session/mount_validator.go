@@ -3,9 +3,36 @@ package session
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
 )
 
+// DefaultSensitivePaths are host paths that are never mounted into a container
+// Mounting a directory that contains one of them (such as $HOME, /home or /) is refused too
+// Home entries use ~ only, so projects under /root stay mountable when COI runs as root
+var DefaultSensitivePaths = []string{
+	"~/.ssh",
+	"~/.gnupg",
+	"~/.aws",
+	"~/.azure",
+	"~/.config/gcloud",
+	"~/.config/gh",
+	"~/.kube",
+	"~/.docker",
+	"~/.password-store",
+	"~/.netrc",
+	"~/.git-credentials",
+	"~/.vault-token",
+	"/etc/shadow",
+	"/etc/sudoers",
+	"/etc/ssh",
+	"/var/run/docker.sock",
+	"/run/docker.sock",
+	"/var/lib/incus",
+}
+
 // ValidateMounts checks for nested container paths
 func ValidateMounts(config *MountConfig) error {
 	if config == nil || len(config.Mounts) == 0 {
@@ -48,3 +75,84 @@ func isNestedPath(pathA, pathB string) bool {
 
 	return strings.HasPrefix(pathA, pathB) || strings.HasPrefix(pathB, pathA)
 }
+
+// SensitivePaths returns the built-in sensitive paths plus extra ones (e.g., [mounts] deny), with ~ expanded
+func SensitivePaths(extra []string) []string {
+	paths := make([]string, 0, len(DefaultSensitivePaths)+len(extra))
+	for _, p := range append(append([]string{}, DefaultSensitivePaths...), extra...) {
+		paths = append(paths, config.ExpandPath(p))
+	}
+	return paths
+}
+
+// CheckSensitivePath returns an error if mounting hostPath would expose a denied path,
+// i.e. hostPath is a denied path, lies inside one, or contains one
+// Symlinks are resolved first, so a link to ~/.ssh is caught as well
+func CheckSensitivePath(hostPath string, deny []string) error {
+	resolved := resolvePath(hostPath)
+	for _, d := range deny {
+		denied := resolvePath(d)
+		switch {
+		case resolved == denied:
+			return fmt.Errorf("refusing to mount %s: it is a sensitive path", hostPath)
+		case pathWithin(resolved, denied):
+			return fmt.Errorf("refusing to mount %s: it is inside sensitive path %s", hostPath, denied)
+		case pathWithin(denied, resolved):
+			return fmt.Errorf("refusing to mount %s: it contains sensitive path %s", hostPath, denied)
+		}
+	}
+	return nil
+}
+
+// resolvePath cleans a host path and resolves symlinks in its longest existing prefix
+// Mount directories may not exist yet (they are created on first use)
+func resolvePath(p string) string {
+	p = filepath.Clean(p)
+	rest := ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(p, rest)
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+// pathWithin reports whether p is inside dir (not dir itself); works for dir = "/"
+func pathWithin(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// CheckToolDefinitions refuses [tools.<name>] tables that would copy a sensitive host path into
+// the container: config_dir, its config_files and the home_config_files are checked like mounts
+func CheckToolDefinitions(defs map[string]config.ToolDefinition, homeDir string, deny []string) error {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		def := defs[name]
+		var paths []string
+		if def.ConfigDir != "" {
+			paths = append(paths, def.ConfigDir)
+		}
+		for _, f := range def.ConfigFiles {
+			paths = append(paths, filepath.Join(def.ConfigDir, f))
+		}
+		paths = append(paths, def.HomeConfigFiles...)
+
+		for _, p := range paths {
+			if err := CheckSensitivePath(filepath.Join(homeDir, p), deny); err != nil {
+				return fmt.Errorf("tool '%s' copies ~/%s from the host: %w", name, p, err)
+			}
+		}
+	}
+	return nil
+}
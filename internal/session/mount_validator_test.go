@@ -1,6 +1,12 @@
 package session
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
 
 func TestValidateMounts_NoNesting(t *testing.T) {
 	config := &MountConfig{
@@ -56,3 +62,92 @@ func TestValidateMounts_SimilarNamesOK(t *testing.T) {
 		t.Errorf("Expected no error for similar names, got: %v", err)
 	}
 }
+
+func TestCheckSensitivePath(t *testing.T) {
+	home, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssh := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(ssh, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, "project"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(ssh, filepath.Join(home, "keys")); err != nil {
+		t.Fatal(err)
+	}
+	deny := []string{ssh, filepath.Join(home, ".aws")}
+
+	tests := []struct {
+		name     string
+		hostPath string
+		wantErr  bool
+	}{
+		{"denied path", ssh, true},
+		{"inside denied path", filepath.Join(ssh, "id_ed25519"), true},
+		{"denied path that does not exist", filepath.Join(home, ".aws", "credentials"), true},
+		{"home directory", home, true},
+		{"root", "/", true},
+		{"symlink to denied path", filepath.Join(home, "keys"), true},
+		{"unresolved path", filepath.Join(home, "project", "..", ".ssh"), true},
+		{"project", filepath.Join(home, "project"), false},
+		{"similar name", filepath.Join(home, ".ssh-backup"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckSensitivePath(tt.hostPath, deny)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckSensitivePath(%s) = %v, wantErr %v", tt.hostPath, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSensitivePaths(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+
+	paths := SensitivePaths([]string{"~/private"})
+	if len(paths) != len(DefaultSensitivePaths)+1 {
+		t.Fatalf("SensitivePaths() returned %d paths, want %d", len(paths), len(DefaultSensitivePaths)+1)
+	}
+	if paths[0] != filepath.Join(home, ".ssh") || paths[len(paths)-1] != filepath.Join(home, "private") {
+		t.Errorf("Expected ~ expanded, got %v", paths)
+	}
+	if err := CheckSensitivePath(home, paths); err == nil {
+		t.Error("Expected the home directory to be refused")
+	}
+	if err := CheckSensitivePath(filepath.Join(home, "project"), paths); err != nil {
+		t.Errorf("Expected a project in the home directory to be allowed, got: %v", err)
+	}
+}
+
+func TestCheckToolDefinitions(t *testing.T) {
+	home, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny := []string{filepath.Join(home, ".ssh"), filepath.Join(home, ".aws")}
+
+	tests := []struct {
+		name    string
+		def     config.ToolDefinition
+		wantErr bool
+	}{
+		{"own config", config.ToolDefinition{ConfigDir: ".agent", ConfigFiles: []string{"auth.json"}, HomeConfigFiles: []string{".agent.yml"}}, false},
+		{"sensitive config dir", config.ToolDefinition{ConfigDir: ".ssh", ConfigFiles: []string{"id_rsa"}}, true},
+		{"sensitive home config file", config.ToolDefinition{ConfigDir: ".agent", HomeConfigFiles: []string{".aws/credentials"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckToolDefinitions(map[string]config.ToolDefinition{"agent": tt.def}, home, deny)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckToolDefinitions() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package session
 
 import (
 	"crypto/sha256"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -56,45 +55,14 @@ func AllocateSlot(workspacePath string, maxSlots int) (int, error) {
 	prefix := fmt.Sprintf("%s%s-", GetContainerPrefix(), hash)
 
 	// Get all containers matching our workspace
-	output, err := container.IncusOutput("list", "--format=json")
+	usedSlots, err := listSlots(prefix)
 	if err != nil {
 		return 0, err
 	}
 
-	// Parse running containers using proper JSON parsing
-	runningSlots := make(map[int]bool)
-	re := regexp.MustCompile(fmt.Sprintf(`^%s(\d+)$`, regexp.QuoteMeta(prefix)))
-
-	// Parse JSON array of containers
-	var containers []struct {
-		Name string `json:"name"`
-	}
-	if err := json.Unmarshal([]byte(output), &containers); err != nil {
-		// Fallback: if JSON parsing fails, try regex on raw output
-		nameMatches := regexp.MustCompile(`"name"\s*:\s*"([^"]+)"`).FindAllStringSubmatch(output, -1)
-		for _, match := range nameMatches {
-			if len(match) > 1 {
-				containerName := match[1]
-				if matches := re.FindStringSubmatch(containerName); len(matches) > 1 {
-					if slotNum, err := strconv.Atoi(matches[1]); err == nil {
-						runningSlots[slotNum] = true
-					}
-				}
-			}
-		}
-	} else {
-		for _, c := range containers {
-			if matches := re.FindStringSubmatch(c.Name); len(matches) > 1 {
-				if slotNum, err := strconv.Atoi(matches[1]); err == nil {
-					runningSlots[slotNum] = true
-				}
-			}
-		}
-	}
-
 	// Find first available slot
 	for slot := 1; slot <= maxSlots; slot++ {
-		if !runningSlots[slot] {
+		if _, used := usedSlots[slot]; !used {
 			return slot, nil
 		}
 	}
@@ -113,45 +81,14 @@ func AllocateSlotFrom(workspacePath string, startSlot, maxSlots int) (int, error
 	prefix := fmt.Sprintf("%s%s-", GetContainerPrefix(), hash)
 
 	// Get all containers matching our workspace
-	output, err := container.IncusOutput("list", "--format=json")
+	usedSlots, err := listSlots(prefix)
 	if err != nil {
 		return 0, err
 	}
 
-	// Parse running containers using proper JSON parsing
-	runningSlots := make(map[int]bool)
-	re := regexp.MustCompile(fmt.Sprintf(`^%s(\d+)$`, regexp.QuoteMeta(prefix)))
-
-	// Parse JSON array of containers
-	var containers []struct {
-		Name string `json:"name"`
-	}
-	if err := json.Unmarshal([]byte(output), &containers); err != nil {
-		// Fallback: if JSON parsing fails, try regex on raw output
-		nameMatches := regexp.MustCompile(`"name"\s*:\s*"([^"]+)"`).FindAllStringSubmatch(output, -1)
-		for _, match := range nameMatches {
-			if len(match) > 1 {
-				containerName := match[1]
-				if matches := re.FindStringSubmatch(containerName); len(matches) > 1 {
-					if slotNum, err := strconv.Atoi(matches[1]); err == nil {
-						runningSlots[slotNum] = true
-					}
-				}
-			}
-		}
-	} else {
-		for _, c := range containers {
-			if matches := re.FindStringSubmatch(c.Name); len(matches) > 1 {
-				if slotNum, err := strconv.Atoi(matches[1]); err == nil {
-					runningSlots[slotNum] = true
-				}
-			}
-		}
-	}
-
 	// Find first available slot starting from startSlot
 	for slot := startSlot; slot <= maxSlots; slot++ {
-		if !runningSlots[slot] {
+		if _, used := usedSlots[slot]; !used {
 			return slot, nil
 		}
 	}
@@ -194,37 +131,23 @@ func ListWorkspaceSessions(workspacePath string) (map[int]string, error) {
 	hash := WorkspaceHash(workspacePath)
 	prefix := fmt.Sprintf("%s%s-", GetContainerPrefix(), hash)
 
-	output, err := container.IncusOutput("list", "--format=json")
+	return listSlots(prefix)
+}
+
+// listSlots returns existing containers named <prefix><slot> as a map of slot -> container name
+func listSlots(prefix string) (map[int]string, error) {
+	re := regexp.MustCompile(fmt.Sprintf(`^%s(\d+)$`, regexp.QuoteMeta(prefix)))
+
+	names, err := container.ListContainers(re.String())
 	if err != nil {
 		return nil, err
 	}
 
 	sessions := make(map[int]string)
-	re := regexp.MustCompile(fmt.Sprintf(`^%s(\d+)$`, regexp.QuoteMeta(prefix)))
-
-	// Parse JSON array of containers
-	var containers []struct {
-		Name string `json:"name"`
-	}
-	if err := json.Unmarshal([]byte(output), &containers); err != nil {
-		// Fallback: if JSON parsing fails, try regex on raw output
-		nameMatches := regexp.MustCompile(`"name"\s*:\s*"([^"]+)"`).FindAllStringSubmatch(output, -1)
-		for _, match := range nameMatches {
-			if len(match) > 1 {
-				containerName := match[1]
-				if matches := re.FindStringSubmatch(containerName); len(matches) > 1 {
-					if slotNum, err := strconv.Atoi(matches[1]); err == nil {
-						sessions[slotNum] = containerName
-					}
-				}
-			}
-		}
-	} else {
-		for _, c := range containers {
-			if matches := re.FindStringSubmatch(c.Name); len(matches) > 1 {
-				if slotNum, err := strconv.Atoi(matches[1]); err == nil {
-					sessions[slotNum] = c.Name
-				}
+	for _, name := range names {
+		if matches := re.FindStringSubmatch(name); len(matches) > 1 {
+			if slotNum, err := strconv.Atoi(matches[1]); err == nil {
+				sessions[slotNum] = name
 			}
 		}
 	}
@@ -0,0 +1,244 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+const (
+	// secretsDir is a tmpfs in the container, so secrets never reach its disk or its snapshots
+	secretsDir = "/run/coi-secrets"
+
+	// SecretsEnvFile exports the environment variable secrets; it is sourced before the tool starts
+	SecretsEnvFile = secretsDir + "/env"
+
+	// secretsKey records what InjectSecrets created in the container (newline-separated), for RemoveSecrets
+	secretsKey = "user.coi.secrets"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateSecrets checks that each secret has exactly one source and a valid target
+func ValidateSecrets(secrets map[string]config.SecretConfig) error {
+	for _, name := range secretNames(secrets) {
+		s := secrets[name]
+		sources := 0
+		for _, set := range []bool{s.File != "", s.Env != "", s.Command != "", len(s.SecretService) > 0} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("secret '%s' needs exactly one of file, env, command or secret_service", name)
+		}
+
+		if s.Path == "" {
+			if !envNamePattern.MatchString(name) {
+				return fmt.Errorf("secret '%s' is not a valid environment variable name - set path to deliver it as a file", name)
+			}
+			continue
+		}
+		if !path.IsAbs(s.Path) {
+			return fmt.Errorf("secret '%s': path must be absolute: %s", name, s.Path)
+		}
+		if name == "." || name == ".." || name == "env" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid secret name '%s'", name)
+		}
+	}
+	return nil
+}
+
+// secretNames returns the secret names in a stable order
+func secretNames(secrets map[string]config.SecretConfig) []string {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readSecret reads a secret's value from its host source
+func readSecret(s config.SecretConfig) ([]byte, error) {
+	switch {
+	case s.File != "":
+		return os.ReadFile(config.ExpandPath(s.File))
+	case s.Env != "":
+		value, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return nil, fmt.Errorf("host environment variable %s is not set", s.Env)
+		}
+		return []byte(value), nil
+	case s.Command != "":
+		return runSecretCommand(exec.Command("sh", "-c", s.Command))
+	default:
+		// secret-tool (libsecret) looks the item up over D-Bus
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, fmt.Errorf("secret_service needs secret-tool (libsecret-tools) on the host")
+		}
+		keys := make([]string, 0, len(s.SecretService))
+		for k := range s.SecretService {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		args := []string{"lookup"}
+		for _, k := range keys {
+			args = append(args, k, s.SecretService[k])
+		}
+		return runSecretCommand(exec.Command("secret-tool", args...))
+	}
+}
+
+// runSecretCommand runs a secret store command and returns its output without the trailing newline
+// The terminal stays attached so stores can prompt for an unlock
+func runSecretCommand(cmd *exec.Cmd) ([]byte, error) {
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", cmd.Args[0], err)
+	}
+	return bytes.TrimRight(out, "\r\n"), nil
+}
+
+// InjectSecrets delivers secrets, and the tool's API keys forwarded from the host (toolEnv), into a
+// running container
+// Values are written to a tmpfs readable only by uid (mode 0400); file secrets are linked from their path.
+// Returns the file to source for environment variables ("" if there are none).
+func InjectSecrets(mgr *container.Manager, secrets map[string]config.SecretConfig, toolEnv map[string]string, uid int, logger func(string)) (string, error) {
+	if len(secrets) == 0 && len(toolEnv) == 0 {
+		return "", nil
+	}
+	if err := ValidateSecrets(secrets); err != nil {
+		return "", err
+	}
+
+	// Read everything before touching the container, so a locked store fails early
+	values := make(map[string][]byte, len(secrets))
+	names := secretNames(secrets)
+	for _, name := range names {
+		value, err := readSecret(secrets[name])
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret '%s': %w", name, err)
+		}
+		values[name] = value
+	}
+
+	script := `mountpoint -q "$1" || { mkdir -p "$1" && mount -t tmpfs -o size=4m,mode=0700 coi-secrets "$1"; } || exit 1
+mkdir -p "$1/files" && chown -R "$2:$2" "$1"`
+	if _, err := mgr.ExecArgsCapture([]string{"sh", "-c", script, "sh", secretsDir, strconv.Itoa(uid)}, container.ExecCommandOptions{}); err != nil {
+		return "", fmt.Errorf("failed to mount secrets tmpfs: %w", err)
+	}
+
+	created := []string{secretsDir}
+	var env strings.Builder
+	toolEnvNames := make([]string, 0, len(toolEnv))
+	for name := range toolEnv {
+		toolEnvNames = append(toolEnvNames, name)
+	}
+	sort.Strings(toolEnvNames)
+	for _, name := range toolEnvNames {
+		// Secrets come later, so a secret of the same name wins
+		fmt.Fprintf(&env, "export %s=%s\n", name, shellQuote(toolEnv[name]))
+	}
+	for _, name := range names {
+		s := secrets[name]
+		if s.Path == "" {
+			fmt.Fprintf(&env, "export %s=%s\n", name, shellQuote(string(values[name])))
+			continue
+		}
+
+		file := path.Join(secretsDir, "files", name)
+		if err := pushSecret(mgr, values[name], file, uid); err != nil {
+			return "", fmt.Errorf("failed to deliver secret '%s': %w", name, err)
+		}
+		link := `mkdir -p "$(dirname "$2")" && ln -sfn "$1" "$2"`
+		if _, err := mgr.ExecArgsCapture([]string{"sh", "-c", link, "sh", file, s.Path}, container.ExecCommandOptions{}); err != nil {
+			return "", fmt.Errorf("failed to link secret '%s' to %s: %w", name, s.Path, err)
+		}
+		created = append(created, s.Path)
+	}
+
+	envFile := ""
+	if env.Len() > 0 {
+		if err := pushSecret(mgr, []byte(env.String()), SecretsEnvFile, uid); err != nil {
+			return "", fmt.Errorf("failed to deliver environment secrets: %w", err)
+		}
+		envFile = SecretsEnvFile
+	}
+
+	if err := mgr.SetConfig(secretsKey, strings.Join(created, "\n")); err != nil {
+		return "", fmt.Errorf("failed to record secrets: %w", err)
+	}
+	if len(names) > 0 {
+		logger(fmt.Sprintf("Delivered %d secret(s): %s", len(names), strings.Join(names, ", ")))
+	}
+	return envFile, nil
+}
+
+// pushSecret writes a secret to the container, owned by uid with mode 0400
+// The host copy is a private temporary file, removed as soon as it is pushed
+func pushSecret(mgr *container.Manager, value []byte, dest string, uid int) error {
+	tmp, err := os.CreateTemp("", "coi-secret-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(value)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := mgr.PushFile(tmp.Name(), dest); err != nil {
+		return err
+	}
+	owner := fmt.Sprintf("%d:%d", uid, uid)
+	if _, err := mgr.ExecArgsCapture([]string{"sh", "-c", `chown "$1" "$2" && chmod 0400 "$2"`, "sh", owner, dest}, container.ExecCommandOptions{}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveSecrets removes the secrets delivered by InjectSecrets from a running container
+func RemoveSecrets(mgr *container.Manager) error {
+	instance, err := container.GetBackend().GetInstance(mgr.ContainerName)
+	if err != nil {
+		return err
+	}
+	recorded := instance.Config[secretsKey]
+	if recorded == "" {
+		return nil
+	}
+
+	// Links are only removed while they still point at a secret
+	script := `dir="$1"; shift
+for p in "$@"; do
+  case "$(readlink "$p")" in "$dir"/*) rm -f "$p" ;; esac
+done
+if mountpoint -q "$dir"; then umount "$dir" || exit 1; fi
+rm -rf "$dir"`
+	created := strings.Split(recorded, "\n")
+	if _, err := mgr.ExecArgsCapture(append([]string{"sh", "-c", script, "sh"}, created...), container.ExecCommandOptions{}); err != nil {
+		return fmt.Errorf("failed to remove secrets: %w", err)
+	}
+	return mgr.SetConfig(secretsKey, "")
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
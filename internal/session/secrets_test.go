@@ -0,0 +1,213 @@
+package session
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/tool"
+)
+
+func TestValidateSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets map[string]config.SecretConfig
+		wantErr bool
+	}{
+		{name: "env var", secrets: map[string]config.SecretConfig{"GITHUB_TOKEN": {Command: "pass show github"}}},
+		{name: "file", secrets: map[string]config.SecretConfig{"npmrc": {File: "~/.npmrc", Path: "/home/code/.npmrc"}}},
+		{name: "secret service", secrets: map[string]config.SecretConfig{"DB": {SecretService: map[string]string{"service": "db"}}}},
+		{name: "no source", secrets: map[string]config.SecretConfig{"TOKEN": {}}, wantErr: true},
+		{name: "two sources", secrets: map[string]config.SecretConfig{"TOKEN": {Env: "A", Command: "b"}}, wantErr: true},
+		{name: "invalid env name", secrets: map[string]config.SecretConfig{"my-token": {Env: "A"}}, wantErr: true},
+		{name: "relative path", secrets: map[string]config.SecretConfig{"npmrc": {Env: "A", Path: ".npmrc"}}, wantErr: true},
+		{name: "name with slash", secrets: map[string]config.SecretConfig{"a/b": {Env: "A", Path: "/tmp/x"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateSecrets(tt.secrets); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSecrets() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	dir := t.TempDir()
+	writeHostFile(t, dir, "token", "from-file\n")
+	t.Setenv("COI_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		source config.SecretConfig
+		want   string
+	}{
+		{source: config.SecretConfig{File: filepath.Join(dir, "token")}, want: "from-file\n"},
+		{source: config.SecretConfig{Env: "COI_TEST_SECRET"}, want: "from-env"},
+		{source: config.SecretConfig{Command: "printf 'from-command\\n'"}, want: "from-command"},
+	}
+	for _, tt := range tests {
+		got, err := readSecret(tt.source)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("readSecret(%+v) = %q (%v), want %q", tt.source, got, err, tt.want)
+		}
+	}
+
+	if _, err := readSecret(config.SecretConfig{Env: "COI_TEST_SECRET_UNSET"}); err == nil {
+		t.Error("Expected error for an unset environment variable")
+	}
+	if _, err := readSecret(config.SecretConfig{Command: "exit 1"}); err == nil {
+		t.Error("Expected error for a failing command")
+	}
+}
+
+func TestInjectAndRemoveSecrets(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.AddInstance("coi-test-1", "Running")
+	mgr := container.NewManager("coi-test-1")
+	t.Setenv("COI_TEST_SECRET", "it's secret")
+
+	secrets := map[string]config.SecretConfig{
+		"API_KEY": {Env: "COI_TEST_SECRET"},
+		"npmrc":   {Command: "echo //registry:_authToken=abc", Path: "/home/code/.npmrc"},
+	}
+	envFile, err := InjectSecrets(mgr, secrets, nil, container.CodeUID, func(string) {})
+	if err != nil {
+		t.Fatalf("InjectSecrets() failed: %v", err)
+	}
+	if envFile != SecretsEnvFile {
+		t.Errorf("InjectSecrets() = %q, want %q", envFile, SecretsEnvFile)
+	}
+
+	if content, _ := backend.File("coi-test-1", SecretsEnvFile); string(content) != "export API_KEY='it'\\''s secret'\n" {
+		t.Errorf("Unexpected env file: %q", content)
+	}
+	if content, _ := backend.File("coi-test-1", "/run/coi-secrets/files/npmrc"); string(content) != "//registry:_authToken=abc" {
+		t.Errorf("Unexpected secret file: %q", content)
+	}
+
+	commands := strings.Join(backend.ExecCommands(), "\n")
+	for _, want := range []string{"mount -t tmpfs", "chmod 0400", "ln -sfn", "/run/coi-secrets/files/npmrc /home/code/.npmrc"} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("Expected %q in commands:\n%s", want, commands)
+		}
+	}
+	// Secret values never appear on a command line
+	if strings.Contains(commands, "it's secret") || strings.Contains(commands, "_authToken") {
+		t.Errorf("Secret value leaked into commands:\n%s", commands)
+	}
+	if recorded := backend.Instance("coi-test-1").Config[secretsKey]; recorded != "/run/coi-secrets\n/home/code/.npmrc" {
+		t.Errorf("Unexpected recorded secrets: %q", recorded)
+	}
+
+	if err := RemoveSecrets(mgr); err != nil {
+		t.Fatalf("RemoveSecrets() failed: %v", err)
+	}
+	calls := backend.ExecCalls()
+	last := strings.Join(calls[len(calls)-1].Command, " ")
+	if !strings.Contains(last, "umount") || !strings.HasSuffix(last, "/run/coi-secrets /home/code/.npmrc") {
+		t.Errorf("Unexpected remove command: %s", last)
+	}
+	if recorded := backend.Instance("coi-test-1").Config[secretsKey]; recorded != "" {
+		t.Errorf("Expected recorded secrets cleared, got %q", recorded)
+	}
+}
+
+func TestInjectSecretsFilesOnly(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.AddInstance("coi-test-1", "Running")
+	mgr := container.NewManager("coi-test-1")
+
+	secrets := map[string]config.SecretConfig{"npmrc": {Command: "echo x", Path: "/home/code/.npmrc"}}
+	envFile, err := InjectSecrets(mgr, secrets, nil, container.CodeUID, func(string) {})
+	if err != nil || envFile != "" {
+		t.Errorf("InjectSecrets() = %q (%v), want no env file", envFile, err)
+	}
+	if _, ok := backend.File("coi-test-1", SecretsEnvFile); ok {
+		t.Error("Expected no env file without environment variable secrets")
+	}
+}
+
+func TestInjectSecretsToolEnv(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.AddInstance("coi-test-1", "Running")
+	mgr := container.NewManager("coi-test-1")
+	t.Setenv("COI_TEST_SECRET", "from-secret")
+
+	secrets := map[string]config.SecretConfig{"OPENAI_API_KEY": {Env: "COI_TEST_SECRET"}}
+	toolEnv := map[string]string{"ANTHROPIC_API_KEY": "sk-ant-test", "OPENAI_API_KEY": "from-tool"}
+	envFile, err := InjectSecrets(mgr, secrets, toolEnv, container.CodeUID, func(string) {})
+	if err != nil || envFile != SecretsEnvFile {
+		t.Fatalf("InjectSecrets() = %q (%v), want %q", envFile, err, SecretsEnvFile)
+	}
+
+	want := "export ANTHROPIC_API_KEY='sk-ant-test'\nexport OPENAI_API_KEY='from-tool'\nexport OPENAI_API_KEY='from-secret'\n"
+	if content, _ := backend.File("coi-test-1", SecretsEnvFile); string(content) != want {
+		t.Errorf("Unexpected env file: %q, want %q", content, want)
+	}
+	if commands := strings.Join(backend.ExecCommands(), "\n"); strings.Contains(commands, "sk-ant-test") {
+		t.Errorf("API key leaked into commands:\n%s", commands)
+	}
+
+	// API keys alone are delivered the same way
+	backend.AddInstance("coi-test-2", "Running")
+	envFile, err = InjectSecrets(container.NewManager("coi-test-2"), nil, toolEnv, container.CodeUID, func(string) {})
+	if err != nil || envFile != SecretsEnvFile {
+		t.Errorf("InjectSecrets() = %q (%v), want %q", envFile, err, SecretsEnvFile)
+	}
+}
+
+func TestCleanupRemovesSecrets(t *testing.T) {
+	backend := useFakeBackend(t)
+	fastStopPolling(t)
+
+	backend.AddInstance("coi-test-1", "Running")
+	mgr := container.NewManager("coi-test-1")
+	if err := mgr.SetConfig(secretsKey, "/run/coi-secrets"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Cleanup(CleanupOptions{ContainerName: "coi-test-1", Tool: tool.NewClaude(), Logger: func(string) {}})
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+	if !strings.Contains(strings.Join(backend.ExecCommands(), "\n"), "umount") {
+		t.Error("Expected secrets removed at cleanup")
+	}
+}
+
+func TestSetupDeliversSecrets(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.Secrets = map[string]config.SecretConfig{"GITHUB_TOKEN": {Command: "echo ghp_test"}}
+
+	result, err := Setup(opts)
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	if result.SecretsEnvFile != SecretsEnvFile {
+		t.Errorf("SecretsEnvFile = %q, want %q", result.SecretsEnvFile, SecretsEnvFile)
+	}
+	if content, _ := backend.File(result.ContainerName, SecretsEnvFile); string(content) != "export GITHUB_TOKEN='ghp_test'\n" {
+		t.Errorf("Unexpected env file: %q", content)
+	}
+}
+
+func TestSetupRemovesSecretsOnFailure(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.Secrets = map[string]config.SecretConfig{"GITHUB_TOKEN": {Command: "echo ghp_test"}}
+	opts.SSH = &config.SSHConfig{ForwardAgent: true} // No keys listed: the SSH agent proxy fails
+
+	if _, err := Setup(opts); err == nil {
+		t.Fatal("Setup() should fail without SSH keys")
+	}
+	instances, err := backend.ListInstances()
+	if err != nil || len(instances) != 1 {
+		t.Fatalf("Expected the session container, got %v (%v)", instances, err)
+	}
+	if recorded := backend.Instance(instances[0].Name).Config[secretsKey]; recorded != "" {
+		t.Errorf("Expected secrets removed, still recorded: %q", recorded)
+	}
+}
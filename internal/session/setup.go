@@ -11,7 +11,9 @@ import (
 
 	"github.com/mensfeld/code-on-incus/internal/config"
 	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/gitcred"
 	"github.com/mensfeld/code-on-incus/internal/network"
+	"github.com/mensfeld/code-on-incus/internal/sshagent"
 	"github.com/mensfeld/code-on-incus/internal/tool"
 )
 
@@ -67,10 +69,16 @@ func setupMounts(mgr *container.Manager, mountConfig *MountConfig, useShift bool
 			return fmt.Errorf("failed to create mount directory '%s': %w", mount.HostPath, err)
 		}
 
-		logger(fmt.Sprintf("Adding mount: %s -> %s", mount.HostPath, mount.ContainerPath))
-
 		// Apply shift setting (all mounts use same shift for now)
-		if err := mgr.MountDisk(mount.DeviceName, mount.HostPath, mount.ContainerPath, useShift); err != nil {
+		var err error
+		if mount.ReadOnly {
+			logger(fmt.Sprintf("Adding read-only mount: %s -> %s", mount.HostPath, mount.ContainerPath))
+			err = mgr.MountDiskReadOnly(mount.DeviceName, mount.HostPath, mount.ContainerPath, useShift)
+		} else {
+			logger(fmt.Sprintf("Adding mount: %s -> %s", mount.HostPath, mount.ContainerPath))
+			err = mgr.MountDisk(mount.DeviceName, mount.HostPath, mount.ContainerPath, useShift)
+		}
+		if err != nil {
 			return fmt.Errorf("failed to add mount '%s': %w", mount.DeviceName, err)
 		}
 	}
@@ -78,20 +86,55 @@ func setupMounts(mgr *container.Manager, mountConfig *MountConfig, useShift bool
 	return nil
 }
 
+// applyLimits applies the session's resource limits to its container, if any are set
+func applyLimits(mgr *container.Manager, limits config.LimitsConfig, logger func(string)) error {
+	if limits.IsZero() {
+		return nil
+	}
+	logger(fmt.Sprintf("Applying resource limits: %s", FormatLimits(limits)))
+	if err := ApplyLimits(mgr, limits); err != nil {
+		return fmt.Errorf("failed to apply resource limits: %w", err)
+	}
+	return nil
+}
+
+// autoSnapshot takes an automatic snapshot of a reused persistent container, if enabled
+// A failed snapshot does not stop the session
+func autoSnapshot(mgr *container.Manager, opts SetupOptions) {
+	if !opts.AutoSnapshot {
+		return
+	}
+	if err := TakeAutoSnapshot(mgr, opts.Logger); err != nil {
+		opts.Logger(fmt.Sprintf("Warning: %v", err))
+	}
+}
+
 // SetupOptions contains options for setting up a session
 type SetupOptions struct {
-	WorkspacePath string
-	Image         string
-	Persistent    bool // Keep container between sessions (don't delete on cleanup)
-	ResumeFromID  string
-	Slot          int
-	MountConfig   *MountConfig // Multi-mount support
-	SessionsDir   string       // e.g., ~/.coi/sessions-claude
-	CLIConfigPath string       // e.g., ~/.claude (host CLI config to copy credentials from)
-	Tool          tool.Tool    // AI coding tool being used
-	NetworkConfig *config.NetworkConfig
-	DisableShift  bool // Disable UID shifting (for Colima/Lima environments)
-	Logger        func(string)
+	WorkspacePath  string
+	SessionID      string // COI session ID (recorded in the SSH agent signing log)
+	Image          string
+	Persistent     bool      // Keep container between sessions (don't delete on cleanup)
+	AutoSnapshot   bool      // Snapshot an existing persistent container before reusing it
+	WorkspaceMode  string    // direct (default), overlay or copy - fixed when the container is created
+	Worktree       *Worktree // Slot worktree mounted as /workspace instead of the workspace itself
+	Masks          []string  // Workspace path patterns hidden from the agent (e.g., ".env", "secrets/", "*.pem")
+	MasksDir       string    // e.g., ~/.coi/masks (empty sources mounted over masked paths)
+	ResumeFromID   string
+	Slot           int
+	MountConfig    *MountConfig // Multi-mount support
+	SessionsDir    string       // e.g., ~/.coi/sessions-claude
+	CLIConfigPath  string       // e.g., ~/.claude (host CLI config to copy credentials from)
+	Tool           tool.Tool    // AI coding tool being used
+	NetworkConfig  *config.NetworkConfig
+	Limits         config.LimitsConfig            // Resource limits (zero value = unlimited)
+	Secrets        map[string]config.SecretConfig // Secrets delivered from host secret stores
+	GitCredentials *config.GitCredentialsConfig   // Git credential proxy (nil or disabled = none)
+	SSH            *config.SSHConfig              // SSH agent forwarding (nil or forward_agent off = none)
+	RunDir         string                         // e.g., ~/.coi/run (host sockets of session services)
+	DisableShift   bool                           // Disable UID shifting (for Colima/Lima environments)
+	UserEnv        []string                       // KEY=VALUE pairs from -e/--env (take precedence over forwarded API keys)
+	Logger         func(string)
 }
 
 // SetupResult contains the result of setup
@@ -102,6 +145,10 @@ type SetupResult struct {
 	HomeDir        string
 	RunAsRoot      bool
 	Image          string
+	NetworkEnv     map[string]string // Variables required by the network mode (e.g., HTTP(S)_PROXY)
+	SecretsEnvFile string            // File to source for environment variable secrets ("" if none)
+	GitCredentials *gitcred.Proxy    // Running git credential proxy (nil if disabled), stopped by Cleanup
+	SSHAgent       *sshagent.Proxy   // Running SSH agent proxy (nil if not forwarded), stopped by Cleanup
 }
 
 // Setup initializes a container for a Claude session
@@ -149,6 +196,12 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 		result.HomeDir = "/home/" + container.CodeUser
 	}
 
+	// The worktree replaces the workspace at /workspace
+	workspaceSource := opts.WorkspacePath
+	if opts.Worktree != nil {
+		workspaceSource = opts.Worktree.Path
+	}
+
 	// 4. Check if container already exists
 	var skipLaunch bool
 	exists, err = result.Manager.Exists()
@@ -167,6 +220,13 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 			// Container is running - this is an active session!
 			if opts.Persistent {
 				opts.Logger("Container already running, reusing...")
+				if err := checkReusedMasks(result.Manager, workspaceSource, opts.Masks, opts.Logger); err != nil {
+					return nil, err
+				}
+				autoSnapshot(result.Manager, opts)
+				if err := applyLimits(result.Manager, opts.Limits, opts.Logger); err != nil {
+					return nil, err
+				}
 				skipLaunch = true
 			} else {
 				// ERROR: A running container exists for this slot, but we're not in persistent mode
@@ -178,11 +238,23 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 			if opts.Persistent {
 				// Restart the stopped persistent container
 				opts.Logger("Restarting existing persistent container...")
+				if err := checkReusedMasks(result.Manager, workspaceSource, opts.Masks, opts.Logger); err != nil {
+					return nil, err
+				}
+				autoSnapshot(result.Manager, opts)
+				if err := applyLimits(result.Manager, opts.Limits, opts.Logger); err != nil {
+					return nil, err
+				}
 				if err := result.Manager.Start(); err != nil {
 					return nil, fmt.Errorf("failed to start container: %w", err)
 				}
 				skipLaunch = true
 			} else {
+				// A leftover with its own workspace copy may hold changes that were never applied
+				if info, err := GetWorkspaceInfo(result.Manager); err == nil && info.Mode != WorkspaceModeDirect {
+					return nil, fmt.Errorf("stopped container %s has a %s workspace that may hold unapplied changes - review with 'coi diff --slot %d', then 'coi apply' or 'coi discard', and remove it with 'coi kill %s'", containerName, info.Mode, opts.Slot, containerName)
+				}
+
 				// Delete the stopped leftover container
 				opts.Logger("Found stopped leftover container from previous session, deleting...")
 				if err := result.Manager.Delete(true); err != nil {
@@ -200,10 +272,14 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 	if !skipLaunch {
 		opts.Logger(fmt.Sprintf("Creating container from %s...", image))
 		// Create container without starting it (init)
-		if err := container.IncusExec("init", image, result.ContainerName); err != nil {
+		if err := result.Manager.Create(image); err != nil {
 			return nil, fmt.Errorf("failed to create container: %w", err)
 		}
 
+		if err := applyLimits(result.Manager, opts.Limits, opts.Logger); err != nil {
+			return nil, err
+		}
+
 		// Configure UID/GID mapping for bind mounts based on environment
 		// Local: Use shift=true (kernel idmap support)
 		// CI: Use raw.idmap (kernel lacks idmap support, runner UID 1001 → container UID 1000)
@@ -221,7 +297,7 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 
 		if isCI {
 			opts.Logger("Configuring UID/GID mapping for CI environment...")
-			if err := container.IncusExec("config", "set", result.ContainerName, "raw.idmap", "both 1001 1000"); err != nil {
+			if err := result.Manager.SetConfig("raw.idmap", "both 1001 1000"); err != nil {
 				opts.Logger(fmt.Sprintf("Warning: Failed to set raw.idmap: %v", err))
 			}
 			useShift = false // Don't use shift=true with raw.idmap
@@ -235,9 +311,27 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 		}
 
 		// Add disk devices BEFORE starting container
-		opts.Logger(fmt.Sprintf("Adding workspace mount: %s", opts.WorkspacePath))
-		if err := result.Manager.MountDisk("workspace", opts.WorkspacePath, "/workspace", useShift); err != nil {
-			return nil, fmt.Errorf("failed to add workspace device: %w", err)
+		if err := addWorkspace(result.Manager, opts.WorkspaceMode, workspaceSource, useShift, opts.Logger); err != nil {
+			return nil, err
+		}
+		if err := MaskWorkspace(result.Manager, opts.WorkspaceMode, workspaceSource, opts.Masks, opts.MasksDir, useShift, opts.Logger); err != nil {
+			return nil, err
+		}
+
+		// The worktree's .git file points to the repository's .git directory by its host path
+		// It is shared with the host checkout, so overlay and copy modes (which keep the agent's
+		// writes in the container) mount it read-only
+		if opts.Worktree != nil {
+			mount := result.Manager.MountDisk
+			if opts.WorkspaceMode != "" && opts.WorkspaceMode != WorkspaceModeDirect {
+				mount = result.Manager.MountDiskReadOnly
+				opts.Logger(fmt.Sprintf("Adding read-only git directory mount for worktree %s: %s", opts.Worktree.Branch, opts.Worktree.GitDir))
+			} else {
+				opts.Logger(fmt.Sprintf("Adding git directory mount for worktree %s: %s", opts.Worktree.Branch, opts.Worktree.GitDir))
+			}
+			if err := mount("worktree-git", opts.Worktree.GitDir, opts.Worktree.GitDir, useShift); err != nil {
+				return nil, fmt.Errorf("failed to add git directory device: %w", err)
+			}
 		}
 
 		// Mount all configured directories
@@ -258,12 +352,30 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 		return nil, err
 	}
 
+	// Mount the workspace overlay or copy the workspace (the mode of a reused container does not change)
+	if skipLaunch && opts.WorkspaceMode != "" {
+		if info, err := GetWorkspaceInfo(result.Manager); err == nil && info.Mode != opts.WorkspaceMode {
+			opts.Logger(fmt.Sprintf("Keeping workspace mode %s of the existing container", info.Mode))
+		}
+	}
+	workspaceUID := container.CodeUID
+	if result.RunAsRoot {
+		workspaceUID = 0
+	}
+	if err := prepareWorkspace(result.Manager, !skipLaunch, workspaceUID, opts.Logger); err != nil {
+		return nil, err
+	}
+	if err := resetExitStatus(result.Manager, workspaceUID); err != nil {
+		return nil, err
+	}
+
 	// 7. Setup network isolation (after container is running and has IP)
 	if opts.NetworkConfig != nil {
 		result.NetworkManager = network.NewManager(opts.NetworkConfig)
 		if err := result.NetworkManager.SetupForContainer(context.Background(), result.ContainerName); err != nil {
 			return nil, fmt.Errorf("failed to setup network isolation: %w", err)
 		}
+		result.NetworkEnv = result.NetworkManager.ContainerEnv()
 	}
 
 	// 8. When resuming: restore session data if container was recreated, then inject credentials
@@ -294,7 +406,13 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 	if opts.Tool != nil && opts.Tool.ConfigDirName() != "" {
 		if opts.CLIConfigPath != "" && opts.ResumeFromID == "" {
 			// Check if host config directory exists
-			if _, err := os.Stat(opts.CLIConfigPath); err == nil {
+			// Tools with home directory config files (e.g., .aider.conf.yml) are set up even without it
+			_, statErr := os.Stat(opts.CLIConfigPath)
+			_, hasHomeConfig := opts.Tool.(tool.ConfigFileTool)
+			if statErr != nil && !os.IsNotExist(statErr) {
+				return nil, fmt.Errorf("failed to check %s config directory: %w", opts.Tool.Name(), statErr)
+			}
+			if statErr == nil || hasHomeConfig {
 				// Copy and inject settings (but only if NOT resuming)
 				// Only run on first launch, not when restarting persistent container
 				if !skipLaunch {
@@ -305,8 +423,6 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 				} else {
 					opts.Logger(fmt.Sprintf("Reusing existing %s config (persistent container)", opts.Tool.Name()))
 				}
-			} else if !os.IsNotExist(err) {
-				return nil, fmt.Errorf("failed to check %s config directory: %w", opts.Tool.Name(), err)
 			}
 		} else if opts.ResumeFromID != "" {
 			opts.Logger(fmt.Sprintf("Resuming session - using restored %s config", opts.Tool.Name()))
@@ -315,6 +431,48 @@ func Setup(opts SetupOptions) (*SetupResult, error) {
 		opts.Logger(fmt.Sprintf("Tool %s uses ENV-based auth, skipping config setup", opts.Tool.Name()))
 	}
 
+	// 11. Forward API keys for tools that authenticate via environment variables (delivered with the secrets)
+	var toolEnv map[string]string
+	if envTool, ok := opts.Tool.(tool.EnvAuthTool); ok {
+		toolEnv = collectToolEnv(envTool, opts.UserEnv, opts.Logger)
+	}
+
+	// From here on, a failed setup must not leave secrets in the container or proxies running
+	abort := func(err error) (*SetupResult, error) {
+		if result.GitCredentials != nil {
+			result.GitCredentials.Stop()
+		}
+		if removeErr := RemoveSecrets(result.Manager); removeErr != nil {
+			opts.Logger(fmt.Sprintf("Warning: Failed to remove secrets: %v", removeErr))
+		}
+		return nil, err
+	}
+
+	// 12. Deliver secrets and API keys (to a tmpfs, so again on every start)
+	secretsEnvFile, err := InjectSecrets(result.Manager, opts.Secrets, toolEnv, workspaceUID, opts.Logger)
+	if err != nil {
+		return abort(fmt.Errorf("failed to deliver secrets: %w", err))
+	}
+	result.SecretsEnvFile = secretsEnvFile
+
+	// 13. Serve git credentials for the allowed remotes
+	if opts.GitCredentials != nil && opts.GitCredentials.Enabled {
+		proxy, err := StartGitCredentialProxy(result.Manager, *opts.GitCredentials, opts.RunDir, workspaceUID, result.HomeDir, opts.Logger)
+		if err != nil {
+			return abort(fmt.Errorf("failed to set up git credential proxy: %w", err))
+		}
+		result.GitCredentials = proxy
+	}
+
+	// 14. Forward the allowed SSH agent keys
+	if opts.SSH != nil && opts.SSH.ForwardAgent {
+		proxy, err := StartSSHAgentProxy(result.Manager, *opts.SSH, opts.RunDir, opts.SessionID, workspaceUID, opts.Logger)
+		if err != nil {
+			return abort(fmt.Errorf("failed to set up SSH agent forwarding: %w", err))
+		}
+		result.SSHAgent = proxy
+	}
+
 	opts.Logger("Container setup complete!")
 	return result, nil
 }
@@ -384,6 +542,23 @@ func injectCredentials(mgr *container.Manager, hostCLIConfigPath, homeDir string
 
 	configDirName := t.ConfigDirName()
 
+	// Tools with their own config files: re-push them, history stays from the restored session
+	if ft, ok := t.(tool.ConfigFileTool); ok {
+		stateDir := filepath.Join(homeDir, configDirName)
+		copyConfigFiles(mgr, hostCLIConfigPath, stateDir, ft.ConfigFiles(), logger)
+		copyConfigFiles(mgr, filepath.Dir(hostCLIConfigPath), homeDir, ft.HomeConfigFiles(), logger)
+
+		// Fix ownership if running as non-root user
+		if homeDir != "/root" {
+			if err := mgr.Chown(stateDir, container.CodeUID, container.CodeUID); err != nil {
+				return fmt.Errorf("failed to set %s directory ownership: %w", configDirName, err)
+			}
+		}
+
+		logger("Credentials and config injected successfully")
+		return nil
+	}
+
 	// Copy .credentials.json from host to container
 	credentialsPath := filepath.Join(hostCLIConfigPath, ".credentials.json")
 	if _, err := os.Stat(credentialsPath); err != nil {
@@ -460,23 +635,27 @@ func setupCLIConfig(mgr *container.Manager, hostCLIConfigPath, homeDir string, t
 	}
 
 	// Copy only essential files from config directory (skip debug logs with permission issues)
-	essentialFiles := []string{
-		".credentials.json",
-		"config.yml",
-		"settings.json",
-	}
-
 	logger(fmt.Sprintf("Copying essential CLI config files from %s", hostCLIConfigPath))
-	for _, filename := range essentialFiles {
-		srcPath := filepath.Join(hostCLIConfigPath, filename)
-		if _, err := os.Stat(srcPath); err == nil {
-			destPath := filepath.Join(stateDir, filename)
-			logger(fmt.Sprintf("  - Copying %s", filename))
-			if err := mgr.PushFile(srcPath, destPath); err != nil {
-				logger(fmt.Sprintf("  - Warning: Failed to copy %s: %v", filename, err))
+	copyConfigFiles(mgr, hostCLIConfigPath, stateDir, essentialConfigFiles(t), logger)
+
+	// Copy config files that live directly in the home directory (e.g., .aider.conf.yml)
+	if ft, ok := t.(tool.ConfigFileTool); ok {
+		hostHomeDir := filepath.Dir(hostCLIConfigPath)
+		logger(fmt.Sprintf("Copying %s home config files from %s", t.Name(), hostHomeDir))
+		copyConfigFiles(mgr, hostHomeDir, homeDir, ft.HomeConfigFiles(), logger)
+
+		// The tool writes into its config directory at runtime (e.g., chat history)
+		if homeDir != "/root" {
+			if err := mgr.Chown(stateDir, container.CodeUID, container.CodeUID); err != nil {
+				return fmt.Errorf("failed to set %s directory ownership: %w", configDirName, err)
+			}
+			for _, filename := range ft.HomeConfigFiles() {
+				if _, err := os.Stat(filepath.Join(hostHomeDir, filename)); err == nil {
+					if err := mgr.Chown(filepath.Join(homeDir, filename), container.CodeUID, container.CodeUID); err != nil {
+						logger(fmt.Sprintf("Warning: Failed to set %s ownership: %v", filename, err))
+					}
+				}
 			}
-		} else {
-			logger(fmt.Sprintf("  - Skipping %s (not found)", filename))
 		}
 	}
 
@@ -589,3 +768,55 @@ func setupCLIConfig(mgr *container.Manager, hostCLIConfigPath, homeDir string, t
 
 	return nil
 }
+
+// essentialConfigFiles returns the files to copy from the host config directory
+func essentialConfigFiles(t tool.Tool) []string {
+	if ft, ok := t.(tool.ConfigFileTool); ok {
+		return ft.ConfigFiles()
+	}
+	return []string{
+		".credentials.json",
+		"config.yml",
+		"settings.json",
+	}
+}
+
+// copyConfigFiles pushes the named files from a host directory into a container directory,
+// skipping files that don't exist on the host
+func copyConfigFiles(mgr *container.Manager, srcDir, destDir string, files []string, logger func(string)) {
+	for _, filename := range files {
+		srcPath := filepath.Join(srcDir, filename)
+		if _, err := os.Stat(srcPath); err == nil {
+			destPath := filepath.Join(destDir, filename)
+			logger(fmt.Sprintf("  - Copying %s", filename))
+			if err := mgr.PushFile(srcPath, destPath); err != nil {
+				logger(fmt.Sprintf("  - Warning: Failed to copy %s: %v", filename, err))
+			}
+		} else {
+			logger(fmt.Sprintf("  - Skipping %s (not found)", filename))
+		}
+	}
+}
+
+// collectToolEnv reads the tool's API key environment variables from the host
+// Variables the user sets with -e/--env are left out, so their values win
+func collectToolEnv(t tool.EnvAuthTool, userEnv []string, logger func(string)) map[string]string {
+	explicit := make(map[string]bool)
+	for _, e := range userEnv {
+		if name, _, ok := strings.Cut(e, "="); ok {
+			explicit[name] = true
+		}
+	}
+
+	env := make(map[string]string)
+	for _, name := range t.AuthEnvVars() {
+		if explicit[name] {
+			continue
+		}
+		if value := os.Getenv(name); value != "" {
+			env[name] = value
+			logger(fmt.Sprintf("Forwarding %s from host environment", name))
+		}
+	}
+	return env
+}
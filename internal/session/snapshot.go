@@ -0,0 +1,92 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+const (
+	// AutoSnapshotPrefix starts the names of snapshots taken before attaching to a persistent container
+	AutoSnapshotPrefix = "auto-"
+	// autoSnapshotKeep is how many automatic snapshots are kept per container (older ones are deleted)
+	autoSnapshotKeep = 5
+	// snapshotNameAttempts is how many suffixed names are tried when the time-based name is taken
+	snapshotNameAttempts = 10
+)
+
+// SnapshotName returns a snapshot name from a prefix and a time, e.g. "auto-20260102-150405"
+func SnapshotName(prefix string, t time.Time) string {
+	return prefix + t.Format("20060102-150405")
+}
+
+// TakeAutoSnapshot snapshots a container before a session attaches to it, then deletes
+// the oldest automatic snapshots beyond autoSnapshotKeep (manual snapshots are never deleted)
+func TakeAutoSnapshot(mgr *container.Manager, logger func(string)) error {
+	if err := createUniqueSnapshot(mgr, SnapshotName(AutoSnapshotPrefix, time.Now()), logger); err != nil {
+		return err
+	}
+
+	snapshots, err := mgr.ListSnapshots()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots of %s: %w", mgr.ContainerName, err)
+	}
+	var auto []string
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.Name, AutoSnapshotPrefix) {
+			auto = append(auto, snapshot.Name)
+		}
+	}
+	for len(auto) > autoSnapshotKeep {
+		if err := mgr.DeleteSnapshot(auto[0]); err != nil {
+			return fmt.Errorf("failed to delete old snapshot %s: %w", auto[0], err)
+		}
+		logger(fmt.Sprintf("Deleted old snapshot %s", auto[0]))
+		auto = auto[1:]
+	}
+	return nil
+}
+
+// createUniqueSnapshot creates a snapshot named base, or base-2, base-3, ... when the name is taken
+// (e.g., by another session ending in the same second)
+func createUniqueSnapshot(mgr *container.Manager, base string, logger func(string)) error {
+	taken, err := snapshotNames(mgr)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots of %s: %w", mgr.ContainerName, err)
+	}
+	for i := 1; i <= snapshotNameAttempts; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		if taken[name] {
+			continue
+		}
+
+		logger(fmt.Sprintf("Taking snapshot %s (auto_snapshot)...", name))
+		createErr := mgr.CreateSnapshot(name)
+		if createErr == nil {
+			return nil
+		}
+		// Only retry if a concurrent session took the name in the meantime
+		if taken, err = snapshotNames(mgr); err != nil || !taken[name] {
+			return fmt.Errorf("failed to snapshot %s: %w", mgr.ContainerName, createErr)
+		}
+	}
+	return fmt.Errorf("failed to snapshot %s: names %s to %s-%d are taken", mgr.ContainerName, base, base, snapshotNameAttempts)
+}
+
+// snapshotNames returns the names of a container's snapshots
+func snapshotNames(mgr *container.Manager) (map[string]bool, error) {
+	snapshots, err := mgr.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		names[snapshot.Name] = true
+	}
+	return names, nil
+}
@@ -0,0 +1,124 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+func TestSnapshotName(t *testing.T) {
+	got := SnapshotName(AutoSnapshotPrefix, time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC))
+	if want := "auto-20260102-150405"; got != want {
+		t.Errorf("SnapshotName() = %q, want %q", got, want)
+	}
+}
+
+func TestTakeAutoSnapshot(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.AddInstance("coi-test-1", "Running")
+	mgr := container.NewManager("coi-test-1")
+
+	if err := mgr.CreateSnapshot("before-upgrade"); err != nil {
+		t.Fatalf("CreateSnapshot() failed: %v", err)
+	}
+	for i := 0; i < autoSnapshotKeep; i++ {
+		if err := mgr.CreateSnapshot(fmt.Sprintf("%s2026010%d-000000", AutoSnapshotPrefix, i)); err != nil {
+			t.Fatalf("CreateSnapshot() failed: %v", err)
+		}
+	}
+
+	if err := TakeAutoSnapshot(mgr, func(string) {}); err != nil {
+		t.Fatalf("TakeAutoSnapshot() failed: %v", err)
+	}
+
+	snapshots, err := mgr.ListSnapshots()
+	if err != nil {
+		t.Fatalf("ListSnapshots() failed: %v", err)
+	}
+	var names []string
+	auto := 0
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+		if strings.HasPrefix(snapshot.Name, AutoSnapshotPrefix) {
+			auto++
+		}
+	}
+
+	// The oldest automatic snapshot is deleted, manual snapshots are kept
+	if auto != autoSnapshotKeep {
+		t.Errorf("Expected %d automatic snapshots, got %v", autoSnapshotKeep, names)
+	}
+	if names[0] != "before-upgrade" || names[1] != AutoSnapshotPrefix+"20260101-000000" {
+		t.Errorf("Expected manual snapshot kept and oldest automatic snapshot deleted, got %v", names)
+	}
+}
+
+func TestCreateUniqueSnapshot(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.AddInstance("coi-test-1", "Running")
+	mgr := container.NewManager("coi-test-1")
+
+	// Sessions ending in the same second get distinct snapshots
+	base := AutoSnapshotPrefix + "20260102-150405"
+	for i := 0; i < 3; i++ {
+		if err := createUniqueSnapshot(mgr, base, func(string) {}); err != nil {
+			t.Fatalf("createUniqueSnapshot() failed: %v", err)
+		}
+	}
+
+	snapshots, err := mgr.ListSnapshots()
+	if err != nil {
+		t.Fatalf("ListSnapshots() failed: %v", err)
+	}
+	var names []string
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+	}
+	if want := []string{base, base + "-2", base + "-3"}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("Snapshots = %v, want %v", names, want)
+	}
+}
+
+func TestSetupAutoSnapshot(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		persistent   bool
+		autoSnapshot bool
+		want         int
+	}{
+		{name: "running persistent container", status: "Running", persistent: true, autoSnapshot: true, want: 1},
+		{name: "stopped persistent container", status: "Stopped", persistent: true, autoSnapshot: true, want: 1},
+		{name: "disabled", status: "Running", persistent: true, autoSnapshot: false, want: 0},
+		{name: "new container", persistent: true, autoSnapshot: true, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := useFakeBackend(t)
+			opts := fakeSetupOptions(t, backend)
+			opts.Persistent = tt.persistent
+			opts.AutoSnapshot = tt.autoSnapshot
+
+			containerName := ContainerName(opts.WorkspacePath, opts.Slot)
+			if tt.status != "" {
+				backend.AddInstance(containerName, tt.status)
+			}
+
+			if _, err := Setup(opts); err != nil {
+				t.Fatalf("Setup() failed: %v", err)
+			}
+
+			snapshots, err := container.NewManager(containerName).ListSnapshots()
+			if err != nil {
+				t.Fatalf("ListSnapshots() failed: %v", err)
+			}
+			if len(snapshots) != tt.want {
+				t.Errorf("Expected %d snapshots, got %d", tt.want, len(snapshots))
+			}
+		})
+	}
+}
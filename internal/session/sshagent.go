@@ -0,0 +1,56 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/sshagent"
+)
+
+const (
+	// sshAgentDevice is the proxy device exposing the agent socket in the container
+	sshAgentDevice = "ssh-agent"
+
+	// ContainerSSHAgentSocket is the agent socket in the container (SSH_AUTH_SOCK of the tool)
+	ContainerSSHAgentSocket = "/run/coi-ssh-agent.sock"
+)
+
+// SSHAgentSocket returns the host socket of a container's agent proxy, e.g. ~/.coi/run/coi-abc12345-1/ssh-agent.sock
+func SSHAgentSocket(runDir, containerName string) string {
+	return filepath.Join(runDir, containerName, "ssh-agent.sock")
+}
+
+// StartSSHAgentProxy starts the filtering agent proxy of the host's SSH agent for a running
+// container and exposes it in the container at ContainerSSHAgentSocket
+func StartSSHAgentProxy(mgr *container.Manager, cfg config.SSHConfig, runDir, sessionID string, uid int, logger func(string)) (*sshagent.Proxy, error) {
+	proxy, err := sshagent.NewProxy(cfg, os.Getenv("SSH_AUTH_SOCK"), sessionID, mgr.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+
+	socket := SSHAgentSocket(runDir, mgr.ContainerName)
+	if err := proxy.Start(socket); err != nil {
+		return nil, err
+	}
+
+	instance, err := container.GetBackend().GetInstance(mgr.ContainerName)
+	if err != nil {
+		proxy.Stop()
+		return nil, err
+	}
+	if _, exists := instance.Devices[sshAgentDevice]; !exists {
+		if err := mgr.AddUnixSocketProxy(sshAgentDevice, socket, ContainerSSHAgentSocket, uid); err != nil {
+			proxy.Stop()
+			return nil, fmt.Errorf("failed to expose SSH agent socket: %w", err)
+		}
+	}
+
+	logger(fmt.Sprintf("SSH agent forwarded with %d allowed key(s)", len(cfg.Keys)))
+	if cfg.RestrictHosts {
+		logger(fmt.Sprintf("SSH signing restricted to hosts in %s", cfg.KnownHosts))
+	}
+	return proxy, nil
+}
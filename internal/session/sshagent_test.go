@@ -0,0 +1,56 @@
+package session
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+func TestSetupForwardsSSHAgent(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	runDir, err := os.MkdirTemp("", "coi-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(runDir) })
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/host-agent.sock")
+	opts.RunDir = runDir
+	opts.SessionID = "session-1"
+	opts.SSH = &config.SSHConfig{ForwardAgent: true, Keys: []string{"SHA256:u6fLN4cArdOfGb0dY7P5h+yjGCFCTmd7i0sGabmftJA"}}
+
+	result, err := Setup(opts)
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	if result.SSHAgent == nil {
+		t.Fatal("Expected a running SSH agent proxy")
+	}
+	defer result.SSHAgent.Stop()
+
+	socket := SSHAgentSocket(runDir, result.ContainerName)
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("Expected a private agent socket at %s: %v", socket, err)
+	}
+	device, ok := backend.Instance(result.ContainerName).Devices[sshAgentDevice]
+	if !ok {
+		t.Fatal("Expected the agent socket device")
+	}
+	if device["connect"] != "unix:"+socket || device["listen"] != "unix:"+ContainerSSHAgentSocket {
+		t.Errorf("Unexpected device: %v", device)
+	}
+}
+
+func TestSetupSSHAgentRequiresKeys(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.RunDir = t.TempDir()
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/host-agent.sock")
+	opts.SSH = &config.SSHConfig{ForwardAgent: true}
+
+	if _, err := Setup(opts); err == nil || !strings.Contains(err.Error(), "no keys are listed") {
+		t.Errorf("Expected an error without allowed keys, got %v", err)
+	}
+}
@@ -0,0 +1,701 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+)
+
+// Workspace modes: how the host workspace is exposed at /workspace
+const (
+	WorkspaceModeDirect  = "direct"  // Bind mount, the agent edits host files
+	WorkspaceModeOverlay = "overlay" // Host tree mounted read-only under a writable overlay in the container
+	WorkspaceModeCopy    = "copy"    // Copy of the host tree in the container
+)
+
+const (
+	// ContainerWorkspace is where the workspace is mounted in the container
+	ContainerWorkspace = "/workspace"
+
+	// Container config keys recording how the workspace was set up
+	workspaceModeKey = "user.coi.workspace_mode"
+	workspacePathKey = "user.coi.workspace"
+
+	// overlayLowerDir is the read-only host workspace in overlay mode
+	overlayLowerDir = "/mnt/coi-workspace"
+	// overlayDir holds the overlay's upper (changed files) and work directories
+	overlayDir = "/var/lib/coi/overlay"
+	// workspaceImportDir is where the host tree is pushed before it is moved to /workspace in copy mode
+	workspaceImportDir = "/var/lib/coi/import"
+)
+
+// ValidateWorkspaceMode checks that a workspace mode is known ("" means direct)
+func ValidateWorkspaceMode(mode string) error {
+	switch mode {
+	case "", WorkspaceModeDirect, WorkspaceModeOverlay, WorkspaceModeCopy:
+		return nil
+	default:
+		return fmt.Errorf("invalid workspace mode '%s': must be direct, overlay or copy", mode)
+	}
+}
+
+// WorkspaceInfo describes how a container's workspace was set up
+type WorkspaceInfo struct {
+	Mode     string   // Workspace mode (containers created before modes existed are direct)
+	HostPath string   // Host workspace directory
+	Masked   []string // Workspace paths hidden from the agent
+}
+
+// GetWorkspaceInfo returns how the workspace of a container was set up
+func GetWorkspaceInfo(mgr *container.Manager) (WorkspaceInfo, error) {
+	instance, err := container.GetBackend().GetInstance(mgr.ContainerName)
+	if err != nil {
+		return WorkspaceInfo{}, err
+	}
+	info := WorkspaceInfo{
+		Mode:     instance.Config[workspaceModeKey],
+		HostPath: instance.Config[workspacePathKey],
+	}
+	if info.Mode == "" {
+		info.Mode = WorkspaceModeDirect
+	}
+	for _, p := range strings.Split(instance.Config[workspaceMasksKey], "\n") {
+		if p != "" {
+			info.Masked = append(info.Masked, p)
+		}
+	}
+	return info, nil
+}
+
+// addWorkspace records the workspace mode and adds the workspace device to a container that is not started yet
+// In copy mode there is no device: the host tree is copied once the container runs (see prepareWorkspace)
+func addWorkspace(mgr *container.Manager, mode, hostPath string, useShift bool, logger func(string)) error {
+	if mode == "" {
+		mode = WorkspaceModeDirect
+	}
+	if err := mgr.SetConfig(workspaceModeKey, mode); err != nil {
+		return fmt.Errorf("failed to record workspace mode: %w", err)
+	}
+	if err := mgr.SetConfig(workspacePathKey, hostPath); err != nil {
+		return fmt.Errorf("failed to record workspace path: %w", err)
+	}
+
+	switch mode {
+	case WorkspaceModeOverlay:
+		logger(fmt.Sprintf("Adding read-only workspace mount for overlay: %s", hostPath))
+		if err := mgr.MountDiskReadOnly("workspace", hostPath, overlayLowerDir, useShift); err != nil {
+			return fmt.Errorf("failed to add workspace device: %w", err)
+		}
+	case WorkspaceModeCopy:
+		logger(fmt.Sprintf("Workspace will be copied into the container: %s", hostPath))
+	default:
+		logger(fmt.Sprintf("Adding workspace mount: %s", hostPath))
+		if err := mgr.MountDisk("workspace", hostPath, ContainerWorkspace, useShift); err != nil {
+			return fmt.Errorf("failed to add workspace device: %w", err)
+		}
+	}
+	return nil
+}
+
+// prepareWorkspace makes /workspace usable in a running container
+// Overlay mode mounts the overlay (again after every start), copy mode copies the host tree
+// into a new container, owned by uid (the user the tool runs as)
+func prepareWorkspace(mgr *container.Manager, created bool, uid int, logger func(string)) error {
+	info, err := GetWorkspaceInfo(mgr)
+	if err != nil {
+		return fmt.Errorf("failed to read workspace mode: %w", err)
+	}
+
+	switch info.Mode {
+	case WorkspaceModeOverlay:
+		return mountOverlay(mgr)
+	case WorkspaceModeCopy:
+		if !created {
+			return nil
+		}
+		logger(fmt.Sprintf("Copying workspace into the container: %s", info.HostPath))
+		return copyWorkspace(mgr, info.HostPath, info.Masked, uid)
+	default:
+		return nil
+	}
+}
+
+// mountOverlay mounts the workspace overlay unless it is already mounted
+// The upper directory takes the owner of the host workspace, so the overlay root is writable by the same user
+func mountOverlay(mgr *container.Manager) error {
+	script := `mountpoint -q "$1" && exit 0
+mkdir -p "$1" "$3/upper" "$3/work" || exit 1
+chown --reference="$2" "$3/upper" || exit 1
+mount -t overlay overlay -o "lowerdir=$2,upperdir=$3/upper,workdir=$3/work" "$1"`
+	if _, err := mgr.ExecArgsCapture([]string{"sh", "-c", script, "sh", ContainerWorkspace, overlayLowerDir, overlayDir}, container.ExecCommandOptions{}); err != nil {
+		return fmt.Errorf("failed to mount workspace overlay (requires overlayfs in unprivileged containers, Linux 5.11+; use --workspace-mode=copy otherwise): %w", err)
+	}
+	return nil
+}
+
+// copyWorkspace copies the host workspace to /workspace in the container, leaving out the masked paths
+// Pushing a directory creates <parent>/<base name>, so the tree is pushed to a staging directory and moved
+func copyWorkspace(mgr *container.Manager, hostPath string, masked []string, uid int) error {
+	if _, err := mgr.ExecArgsCapture([]string{"mkdir", "-p", workspaceImportDir}, container.ExecCommandOptions{}); err != nil {
+		return fmt.Errorf("failed to prepare workspace copy: %w", err)
+	}
+	staged := path.Join(workspaceImportDir, filepath.Base(hostPath))
+	if err := pushUnmasked(mgr, hostPath, staged, "", masked); err != nil {
+		return fmt.Errorf("failed to copy workspace: %w", err)
+	}
+
+	script := `rm -rf "$1" && mv "$2" "$1" && chown -R "$3:$3" "$1" && rmdir "$4"`
+	if _, err := mgr.ExecArgsCapture([]string{"sh", "-c", script, "sh", ContainerWorkspace, staged, strconv.Itoa(uid), workspaceImportDir}, container.ExecCommandOptions{}); err != nil {
+		return fmt.Errorf("failed to move workspace copy into place: %w", err)
+	}
+	return nil
+}
+
+// pushUnmasked pushes the host directory hostDir (rel in the workspace) to containerDir, leaving out masked paths,
+// so they never reach the container's storage. Directories with no masked paths inside are pushed whole
+func pushUnmasked(mgr *container.Manager, hostDir, containerDir, rel string, masked []string) error {
+	if !hasMaskedInside(rel, masked) {
+		return mgr.PushDirectory(hostDir, containerDir)
+	}
+
+	info, err := os.Stat(hostDir)
+	if err != nil {
+		return err
+	}
+	mode := strconv.FormatUint(uint64(info.Mode().Perm()), 8)
+	if _, err := mgr.ExecArgsCapture([]string{"mkdir", "-p", "-m", mode, containerDir}, container.ExecCommandOptions{}); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(hostDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryRel := path.Join(rel, entry.Name())
+		hostEntry := filepath.Join(hostDir, entry.Name())
+		containerEntry := path.Join(containerDir, entry.Name())
+		switch {
+		case slices.Contains(masked, entryRel):
+			continue
+		case entry.IsDir():
+			err = pushUnmasked(mgr, hostEntry, containerEntry, entryRel, masked)
+		case entry.Type()&os.ModeSymlink != 0:
+			var target string
+			if target, err = os.Readlink(hostEntry); err == nil {
+				_, err = mgr.ExecArgsCapture([]string{"ln", "-s", "--", target, containerEntry}, container.ExecCommandOptions{})
+			}
+		case entry.Type().IsRegular():
+			err = mgr.PushFile(hostEntry, containerEntry)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", entryRel, err)
+		}
+	}
+	return nil
+}
+
+// hasMaskedInside reports whether a masked path lies inside the workspace directory rel ("" = the root)
+func hasMaskedInside(rel string, masked []string) bool {
+	for _, m := range masked {
+		if rel == "" || strings.HasPrefix(m, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenWorkspace starts a stopped container if needed and makes its workspace copy or overlay readable,
+// for reviewing changes outside a session
+func OpenWorkspace(mgr *container.Manager) (WorkspaceInfo, error) {
+	info, err := GetWorkspaceInfo(mgr)
+	if err != nil {
+		return WorkspaceInfo{}, err
+	}
+	if info.Mode == WorkspaceModeDirect {
+		return info, fmt.Errorf("container %s mounts the workspace directly - changes are already on the host", mgr.ContainerName)
+	}
+
+	running, err := mgr.Running()
+	if err != nil {
+		return info, err
+	}
+	if !running {
+		if err := mgr.Start(); err != nil {
+			return info, fmt.Errorf("failed to start container: %w", err)
+		}
+		if err := waitForReady(mgr, 30, func(string) {}); err != nil {
+			return info, err
+		}
+	}
+	if info.Mode == WorkspaceModeOverlay {
+		if err := mountOverlay(mgr); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
+}
+
+// Change statuses of workspace entries, as shown by 'coi diff'
+const (
+	ChangeAdded    = "A"
+	ChangeModified = "M"
+	ChangeDeleted  = "D"
+)
+
+// WorkspaceChange is a difference between the container's workspace and the host tree
+type WorkspaceChange struct {
+	Status string `json:"status"`        // ChangeAdded, ChangeModified or ChangeDeleted (relative to the host)
+	Path   string `json:"path"`          // Slash-separated path relative to the workspace
+	Dir    bool   `json:"dir,omitempty"` // A whole directory was added or deleted
+
+	container workspaceEntry // Entry in the container (zero when deleted)
+	host      workspaceEntry // Entry on the host (zero when added)
+}
+
+// workspaceEntry is a file, directory or symlink in a workspace tree
+type workspaceEntry struct {
+	Type   byte        // 'f' file, 'd' directory, 'l' symlink (0 = missing)
+	Mode   os.FileMode // Permission bits
+	Hash   string      // SHA-256 of a file's content
+	Target string      // Symlink target
+}
+
+// workspaceManifest maps slash-separated relative paths to entries
+type workspaceManifest map[string]workspaceEntry
+
+// WorkspaceChanges compares the container's /workspace with the host tree
+// Changes touching masked paths are left out, so they are never applied to (or discarded from) the host
+func WorkspaceChanges(mgr *container.Manager, hostPath string) ([]WorkspaceChange, error) {
+	info, err := GetWorkspaceInfo(mgr)
+	if err != nil {
+		return nil, err
+	}
+	hostFiles, err := hostManifest(hostPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host workspace: %w", err)
+	}
+	containerFiles, err := containerManifest(mgr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container workspace: %w", err)
+	}
+
+	var changes []WorkspaceChange
+	for _, change := range diffManifests(hostFiles, containerFiles) {
+		if !touchesMasked(change, info.Masked) {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// hostManifest lists the host workspace, hashing file contents
+func hostManifest(root string) (workspaceManifest, error) {
+	manifest := make(workspaceManifest)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := workspaceEntry{Mode: info.Mode().Perm()}
+		switch {
+		case d.IsDir():
+			entry.Type = 'd'
+		case info.Mode()&os.ModeSymlink != 0:
+			entry.Type = 'l'
+			if entry.Target, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			entry.Type = 'f'
+			if entry.Hash, err = hashFile(p); err != nil {
+				return err
+			}
+		default:
+			return nil // Sockets, pipes and devices are not part of the workspace
+		}
+		manifest[filepath.ToSlash(rel)] = entry
+		return nil
+	})
+	return manifest, err
+}
+
+// hashFile returns the hex SHA-256 of a file
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// containerManifest lists /workspace in the container
+// find prints NUL-separated type, path, mode and symlink target; sha256sum -z hashes the files
+func containerManifest(mgr *container.Manager) (workspaceManifest, error) {
+	entries, err := mgr.ExecArgsCapture([]string{
+		"find", ContainerWorkspace, "-mindepth", "1", "-printf", `%y\0%P\0%m\0%l\0`,
+	}, container.ExecCommandOptions{})
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := mgr.ExecArgsCapture([]string{
+		"sh", "-c", `cd "$1" && find . -type f -print0 | xargs -0 -r sha256sum -z`, "sh", ContainerWorkspace,
+	}, container.ExecCommandOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return parseContainerManifest(entries, hashes)
+}
+
+// parseContainerManifest parses the output of the find and sha256sum commands of containerManifest
+func parseContainerManifest(entries, hashes string) (workspaceManifest, error) {
+	manifest := make(workspaceManifest)
+
+	fields := strings.Split(entries, "\x00")
+	for i := 0; i+3 < len(fields); i += 4 {
+		kind, rel, mode, target := fields[i], fields[i+1], fields[i+2], fields[i+3]
+		if len(kind) != 1 || !strings.Contains("fdl", kind) {
+			continue
+		}
+		// The container's find is not trusted: a path like ../x would be applied outside the workspace
+		if !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("invalid path %q in the container workspace", rel)
+		}
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode %q of %s", mode, rel)
+		}
+		entry := workspaceEntry{Type: kind[0], Mode: os.FileMode(perm).Perm()}
+		if entry.Type == 'l' {
+			entry.Target = target
+		}
+		manifest[rel] = entry
+	}
+
+	// Each record is "<64 hex digits>  ./<path>"
+	for _, record := range strings.Split(hashes, "\x00") {
+		if len(record) < 68 || record[64:68] != "  ./" {
+			continue
+		}
+		rel := record[68:]
+		if entry, ok := manifest[rel]; ok && entry.Type == 'f' {
+			entry.Hash = record[:64]
+			manifest[rel] = entry
+		}
+	}
+	return manifest, nil
+}
+
+// diffManifests returns the changes that turn the host tree into the container tree, sorted by path
+// Added and deleted directories are reported once, without their contents
+func diffManifests(host, ctr workspaceManifest) []WorkspaceChange {
+	paths := make(map[string]bool, len(host)+len(ctr))
+	for p := range host {
+		paths[p] = true
+	}
+	for p := range ctr {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var changes []WorkspaceChange
+	covered := make(map[string]bool) // Directories whose contents are covered by a change
+	for _, p := range sorted {
+		// Siblings such as "cmd.go" sort between "cmd" and "cmd/...", so check every ancestor
+		if coveredByChange(covered, p) {
+			continue
+		}
+
+		h, inHost := host[p]
+		c, inContainer := ctr[p]
+		change := WorkspaceChange{Path: p, container: c, host: h}
+		switch {
+		case !inHost:
+			change.Status = ChangeAdded
+			change.Dir = c.Type == 'd'
+		case !inContainer:
+			change.Status = ChangeDeleted
+			change.Dir = h.Type == 'd'
+		case entryChanged(h, c):
+			change.Status = ChangeModified
+			change.Dir = h.Type == 'd' || c.Type == 'd'
+		default:
+			continue
+		}
+		if change.Dir {
+			covered[p] = true
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// coveredByChange returns true if a parent directory of p is in covered
+func coveredByChange(covered map[string]bool, p string) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if covered[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// entryChanged reports whether a host and container entry differ
+// Only the executable bits of file modes are compared, since copies do not keep other bits reliably
+func entryChanged(h, c workspaceEntry) bool {
+	if h.Type != c.Type {
+		return true
+	}
+	switch h.Type {
+	case 'f':
+		return h.Hash != c.Hash || h.Mode&0o111 != c.Mode&0o111
+	case 'l':
+		return h.Target != c.Target
+	default:
+		return false
+	}
+}
+
+// FilterWorkspaceChanges returns the changes at or below the given paths (all changes when none are given)
+func FilterWorkspaceChanges(changes []WorkspaceChange, paths []string) []WorkspaceChange {
+	if len(paths) == 0 {
+		return changes
+	}
+	var filtered []WorkspaceChange
+	for _, change := range changes {
+		for _, p := range paths {
+			p = strings.Trim(path.Clean(filepath.ToSlash(p)), "/")
+			if p == "." || change.Path == p || strings.HasPrefix(change.Path, p+"/") {
+				filtered = append(filtered, change)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// WriteWorkspacePatch writes a unified diff (host to container) of changed files using the host's diff command
+// Directories and symlinks are described in a line instead
+func WriteWorkspacePatch(mgr *container.Manager, hostPath string, changes []WorkspaceChange, w io.Writer) error {
+	tmpDir, err := os.MkdirTemp("", "coi-diff-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for i, change := range changes {
+		if change.Dir {
+			fmt.Fprintf(w, "%s directory %s/\n", map[string]string{ChangeAdded: "Added", ChangeDeleted: "Deleted", ChangeModified: "Replaced"}[change.Status], change.Path)
+			continue
+		}
+		if change.host.Type == 'l' || change.container.Type == 'l' {
+			fmt.Fprintf(w, "Symlink %s: %s -> %s\n", change.Path, describeEntry(change.host), describeEntry(change.container))
+			continue
+		}
+
+		oldPath, newPath := os.DevNull, os.DevNull
+		if change.host.Type == 'f' {
+			oldPath = filepath.Join(hostPath, filepath.FromSlash(change.Path))
+		}
+		if change.container.Type == 'f' {
+			newPath = filepath.Join(tmpDir, strconv.Itoa(i))
+			if err := mgr.PullFile(path.Join(ContainerWorkspace, change.Path), newPath); err != nil {
+				return fmt.Errorf("failed to pull %s: %w", change.Path, err)
+			}
+		}
+
+		cmd := exec.Command("diff", "-u", "--label", "a/"+change.Path, "--label", "b/"+change.Path, oldPath, newPath)
+		cmd.Stdout = w
+		cmd.Stderr = w
+		// diff exits with 1 when the files differ
+		var exitErr *exec.ExitError
+		if err := cmd.Run(); err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+			return fmt.Errorf("failed to diff %s: %w", change.Path, err)
+		}
+		if change.host.Type == 'f' && change.container.Type == 'f' && change.host.Mode&0o111 != change.container.Mode&0o111 {
+			fmt.Fprintf(w, "Mode of %s: %o -> %o\n", change.Path, change.host.Mode, change.container.Mode)
+		}
+	}
+	return nil
+}
+
+// describeEntry describes a workspace entry for WriteWorkspacePatch
+func describeEntry(entry workspaceEntry) string {
+	switch entry.Type {
+	case 'l':
+		return "link to " + entry.Target
+	case 'f':
+		return "file"
+	case 'd':
+		return "directory"
+	default:
+		return "(none)"
+	}
+}
+
+// ApplyWorkspaceChanges copies changes from the container's workspace to the host tree
+func ApplyWorkspaceChanges(mgr *container.Manager, hostPath string, changes []WorkspaceChange) error {
+	for _, change := range changes {
+		if err := applyChange(mgr, hostPath, change); err != nil {
+			return fmt.Errorf("failed to apply %s: %w", change.Path, err)
+		}
+	}
+	return nil
+}
+
+// applyChange makes one host path match the container
+// Paths leaving the workspace, directly or through a symlinked directory on the host, are refused
+func applyChange(mgr *container.Manager, hostPath string, change WorkspaceChange) error {
+	if !filepath.IsLocal(change.Path) {
+		return fmt.Errorf("path is outside the workspace")
+	}
+	if err := checkSymlinkParents(hostPath, change.Path); err != nil {
+		return err
+	}
+	local := filepath.Join(hostPath, filepath.FromSlash(change.Path))
+	remote := path.Join(ContainerWorkspace, change.Path)
+	entry := change.container
+
+	if entry.Type != 'f' || change.host.Type == 'd' {
+		if err := os.RemoveAll(local); err != nil {
+			return err
+		}
+	}
+	if entry.Type != 0 {
+		if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+			return err
+		}
+	}
+
+	switch entry.Type {
+	case 'd':
+		return mgr.PullDirectory(remote, local)
+	case 'l':
+		return os.Symlink(entry.Target, local)
+	case 'f':
+		// Pull next to the destination and rename, so the host file is replaced atomically
+		tmp := filepath.Join(filepath.Dir(local), ".coi-apply-"+filepath.Base(local))
+		if err := mgr.PullFile(remote, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Chmod(tmp, entry.Mode); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return os.Rename(tmp, local)
+	}
+	return nil
+}
+
+// checkSymlinkParents returns an error if a host directory between root and rel is a symlink
+func checkSymlinkParents(root, rel string) error {
+	dir := root
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // Created by applyChange
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write through %s: it is a symlink on the host", dir)
+		}
+	}
+	return nil
+}
+
+// DiscardWorkspaceChanges resets changed paths in the container's workspace to the host tree
+func DiscardWorkspaceChanges(mgr *container.Manager, hostPath string, changes []WorkspaceChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	// Restored entries get the owner of the workspace root
+	owner, err := mgr.ExecArgsCapture([]string{"stat", "-c", "%u:%g", ContainerWorkspace}, container.ExecCommandOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read workspace owner: %w", err)
+	}
+	owner = strings.TrimSpace(owner)
+
+	for _, change := range changes {
+		if err := discardChange(mgr, hostPath, owner, change); err != nil {
+			return fmt.Errorf("failed to discard %s: %w", change.Path, err)
+		}
+	}
+	return nil
+}
+
+// discardChange makes one container path match the host
+func discardChange(mgr *container.Manager, hostPath, owner string, change WorkspaceChange) error {
+	local := filepath.Join(hostPath, filepath.FromSlash(change.Path))
+	remote := path.Join(ContainerWorkspace, change.Path)
+	entry := change.host
+
+	// Remove the container entry and recreate its parent directory (if the host has one)
+	script := `rm -rf -- "$1" && if [ -n "$2" ]; then mkdir -p -- "$(dirname -- "$1")"; fi`
+	restore := ""
+	if entry.Type != 0 {
+		restore = "yes"
+	}
+	if _, err := mgr.ExecArgsCapture([]string{"sh", "-c", script, "sh", remote, restore}, container.ExecCommandOptions{}); err != nil {
+		return err
+	}
+
+	switch entry.Type {
+	case 'd':
+		if err := mgr.PushDirectory(local, remote); err != nil {
+			return err
+		}
+		_, err := mgr.ExecArgsCapture([]string{"chown", "-R", owner, remote}, container.ExecCommandOptions{})
+		return err
+	case 'l':
+		_, err := mgr.ExecArgsCapture([]string{"sh", "-c", `ln -s -- "$2" "$1" && chown -h "$3" "$1"`, "sh", remote, entry.Target, owner}, container.ExecCommandOptions{})
+		return err
+	case 'f':
+		if err := mgr.PushFile(local, remote); err != nil {
+			return err
+		}
+		mode := fmt.Sprintf("%o", entry.Mode)
+		_, err := mgr.ExecArgsCapture([]string{"sh", "-c", `chown "$2" "$1" && chmod "$3" "$1"`, "sh", remote, owner, mode}, container.ExecCommandOptions{})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mensfeld/code-on-incus/internal/container"
+	"github.com/mensfeld/code-on-incus/internal/tool"
+)
+
+func TestValidateWorkspaceMode(t *testing.T) {
+	for _, mode := range []string{"", "direct", "overlay", "copy"} {
+		if err := ValidateWorkspaceMode(mode); err != nil {
+			t.Errorf("ValidateWorkspaceMode(%q) = %v, want nil", mode, err)
+		}
+	}
+	if err := ValidateWorkspaceMode("snapshot"); err == nil {
+		t.Error("ValidateWorkspaceMode(\"snapshot\") = nil, want error")
+	}
+}
+
+func TestParseContainerManifest(t *testing.T) {
+	hash := strings.Repeat("a", 64)
+	entries := "d\x00src\x00755\x00\x00" +
+		"f\x00src/main.go\x00644\x00\x00" +
+		"f\x00run.sh\x00755\x00\x00" +
+		"l\x00latest\x00777\x00src/main.go\x00" +
+		"p\x00fifo\x00644\x00\x00"
+	hashes := hash + "  ./src/main.go\x00" + strings.Repeat("b", 64) + "  ./run.sh\x00"
+
+	manifest, err := parseContainerManifest(entries, hashes)
+	if err != nil {
+		t.Fatalf("parseContainerManifest() failed: %v", err)
+	}
+
+	want := workspaceManifest{
+		"src":         {Type: 'd', Mode: 0o755},
+		"src/main.go": {Type: 'f', Mode: 0o644, Hash: hash},
+		"run.sh":      {Type: 'f', Mode: 0o755, Hash: strings.Repeat("b", 64)},
+		"latest":      {Type: 'l', Mode: 0o777, Target: "src/main.go"},
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("parseContainerManifest() = %+v, want %+v", manifest, want)
+	}
+}
+
+func TestParseContainerManifestRejectsNonLocalPaths(t *testing.T) {
+	for _, rel := range []string{"../outside", "src/../../outside", "/etc/passwd", ""} {
+		entries := "f\x00" + rel + "\x00644\x00\x00"
+		if _, err := parseContainerManifest(entries, ""); err == nil {
+			t.Errorf("parseContainerManifest() should reject %q", rel)
+		}
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	host := workspaceManifest{
+		"README.md":    {Type: 'f', Mode: 0o644, Hash: "1"},
+		"run.sh":       {Type: 'f', Mode: 0o644, Hash: "2"},
+		"old":          {Type: 'd', Mode: 0o755},
+		"old/a.txt":    {Type: 'f', Mode: 0o644, Hash: "3"},
+		"src":          {Type: 'd', Mode: 0o755},
+		"src/main.go":  {Type: 'f', Mode: 0o644, Hash: "4"},
+		"src/util.go":  {Type: 'f', Mode: 0o644, Hash: "5"},
+		"current":      {Type: 'l', Target: "v1"},
+		"unchanged.md": {Type: 'f', Mode: 0o600, Hash: "6"},
+	}
+	ctr := workspaceManifest{
+		"README.md":    {Type: 'f', Mode: 0o644, Hash: "1"},
+		"run.sh":       {Type: 'f', Mode: 0o755, Hash: "2"},
+		"src":          {Type: 'd', Mode: 0o755},
+		"src/main.go":  {Type: 'f', Mode: 0o644, Hash: "changed"},
+		"new":          {Type: 'd', Mode: 0o755},
+		"new/b.txt":    {Type: 'f', Mode: 0o644, Hash: "7"},
+		"current":      {Type: 'l', Target: "v2"},
+		"unchanged.md": {Type: 'f', Mode: 0o644, Hash: "6"}, // Only non-executable bits differ
+	}
+
+	var got []string
+	for _, change := range diffManifests(host, ctr) {
+		got = append(got, change.Status+" "+change.Path+map[bool]string{true: "/"}[change.Dir])
+	}
+	want := []string{"M current", "A new/", "D old/", "M run.sh", "M src/main.go", "D src/util.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffManifests() = %v, want %v", got, want)
+	}
+}
+
+func TestDiffManifestsSiblingsOfDirectories(t *testing.T) {
+	// "dir.go" and "dir.d" sort between "dir" and "dir/a"
+	ctr := workspaceManifest{
+		"dir":       {Type: 'd', Mode: 0o755},
+		"dir.go":    {Type: 'f', Mode: 0o644, Hash: "1"},
+		"dir.d":     {Type: 'd', Mode: 0o755},
+		"dir.d/x":   {Type: 'f', Mode: 0o644, Hash: "2"},
+		"dir/a":     {Type: 'f', Mode: 0o644, Hash: "3"},
+		"dir/sub":   {Type: 'd', Mode: 0o755},
+		"dir/sub/b": {Type: 'f', Mode: 0o644, Hash: "4"},
+	}
+
+	var got []string
+	for _, change := range diffManifests(workspaceManifest{}, ctr) {
+		got = append(got, change.Status+" "+change.Path+map[bool]string{true: "/"}[change.Dir])
+	}
+	want := []string{"A dir/", "A dir.d/", "A dir.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffManifests() = %v, want %v", got, want)
+	}
+}
+
+func TestFilterWorkspaceChanges(t *testing.T) {
+	changes := []WorkspaceChange{{Path: "docs/a.md"}, {Path: "src"}, {Path: "src/main.go"}, {Path: "srcs/x"}}
+
+	tests := []struct {
+		paths []string
+		want  []string
+	}{
+		{paths: nil, want: []string{"docs/a.md", "src", "src/main.go", "srcs/x"}},
+		{paths: []string{"src"}, want: []string{"src", "src/main.go"}},
+		{paths: []string{"./src/main.go", "docs/"}, want: []string{"docs/a.md", "src/main.go"}},
+		{paths: []string{"."}, want: []string{"docs/a.md", "src", "src/main.go", "srcs/x"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, change := range FilterWorkspaceChanges(changes, tt.paths) {
+			got = append(got, change.Path)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FilterWorkspaceChanges(%v) = %v, want %v", tt.paths, got, tt.want)
+		}
+	}
+}
+
+func TestApplyWorkspaceChanges(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.AddInstance("coi-test-1", "Running")
+	mgr := container.NewManager("coi-test-1")
+
+	host := t.TempDir()
+	writeHostFile(t, host, "main.go", "package old\n")
+	writeHostFile(t, host, "stale/a.txt", "gone\n")
+	if err := backend.WriteFile("coi-test-1", "/workspace/main.go", []byte("package main\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.WriteFile("coi-test-1", "/workspace/pkg/new.go", []byte("package pkg\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := []WorkspaceChange{
+		{Status: ChangeModified, Path: "main.go", container: workspaceEntry{Type: 'f', Mode: 0o755}, host: workspaceEntry{Type: 'f'}},
+		{Status: ChangeAdded, Path: "pkg", Dir: true, container: workspaceEntry{Type: 'd', Mode: 0o755}},
+		{Status: ChangeAdded, Path: "link", container: workspaceEntry{Type: 'l', Target: "main.go"}},
+		{Status: ChangeDeleted, Path: "stale", Dir: true, host: workspaceEntry{Type: 'd'}},
+	}
+	if err := ApplyWorkspaceChanges(mgr, host, changes); err != nil {
+		t.Fatalf("ApplyWorkspaceChanges() failed: %v", err)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(host, "main.go")); string(content) != "package main\n" {
+		t.Errorf("Expected main.go updated, got %q", content)
+	}
+	if info, err := os.Stat(filepath.Join(host, "main.go")); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("Expected main.go mode 0755, got %v (%v)", info.Mode(), err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(host, "pkg", "new.go")); string(content) != "package pkg\n" {
+		t.Errorf("Expected pkg/new.go added, got %q", content)
+	}
+	if target, err := os.Readlink(filepath.Join(host, "link")); err != nil || target != "main.go" {
+		t.Errorf("Expected link -> main.go, got %q (%v)", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(host, "stale")); !os.IsNotExist(err) {
+		t.Errorf("Expected stale/ removed, got %v", err)
+	}
+
+	// No temporary files are left next to applied files
+	entries, _ := os.ReadDir(host)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".coi-apply-") {
+			t.Errorf("Leftover temporary file %s", entry.Name())
+		}
+	}
+}
+
+func TestApplyWorkspaceChangesRefusesEscapes(t *testing.T) {
+	backend := useFakeBackend(t)
+	backend.AddInstance("coi-test-1", "Running")
+	mgr := container.NewManager("coi-test-1")
+	if err := backend.WriteFile("coi-test-1", "/workspace/docs/x.txt", []byte("x\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	host := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(host, "docs")); err != nil {
+		t.Fatal(err)
+	}
+
+	file := workspaceEntry{Type: 'f', Mode: 0o644}
+	for _, change := range []WorkspaceChange{
+		{Status: ChangeAdded, Path: "docs/x.txt", container: file},
+		{Status: ChangeAdded, Path: "../x.txt", container: file},
+	} {
+		if err := ApplyWorkspaceChanges(mgr, host, []WorkspaceChange{change}); err == nil {
+			t.Errorf("ApplyWorkspaceChanges(%s) should fail", change.Path)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("Expected nothing written outside the workspace, got %v", entries)
+	}
+}
+
+func TestHostManifest(t *testing.T) {
+	host := t.TempDir()
+	writeHostFile(t, host, "src/main.go", "package main\n")
+	if err := os.Symlink("src/main.go", filepath.Join(host, "latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := hostManifest(host)
+	if err != nil {
+		t.Fatalf("hostManifest() failed: %v", err)
+	}
+	if manifest["src"].Type != 'd' || manifest["latest"].Type != 'l' || manifest["latest"].Target != "src/main.go" {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+	// sha256 of "package main\n"
+	if got, want := manifest["src/main.go"].Hash, "df1d036cbbf3df46e2045071e082245ece204c7f53ecf0a4e022bff9bb228f47"; got != want {
+		t.Errorf("hostManifest() hash = %q, want %q", got, want)
+	}
+}
+
+func TestSetupWorkspaceModes(t *testing.T) {
+	tests := []struct {
+		mode       string
+		devicePath string // Path of the workspace device ("" = none)
+		readOnly   bool
+	}{
+		{mode: "", devicePath: "/workspace"},
+		{mode: WorkspaceModeOverlay, devicePath: "/mnt/coi-workspace", readOnly: true},
+		{mode: WorkspaceModeCopy},
+	}
+
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			backend := useFakeBackend(t)
+			opts := fakeSetupOptions(t, backend)
+			opts.WorkspaceMode = tt.mode
+			writeHostFile(t, opts.WorkspacePath, "main.go", "package main\n")
+
+			result, err := Setup(opts)
+			if err != nil {
+				t.Fatalf("Setup() failed: %v", err)
+			}
+
+			instance := backend.Instance(result.ContainerName)
+			device, ok := instance.Devices["workspace"]
+			if tt.devicePath == "" {
+				if ok {
+					t.Errorf("Expected no workspace device, got %v", device)
+				}
+			} else if device["path"] != tt.devicePath || (device["readonly"] == "true") != tt.readOnly {
+				t.Errorf("Unexpected workspace device: %v", device)
+			}
+
+			info, err := GetWorkspaceInfo(result.Manager)
+			if err != nil {
+				t.Fatalf("GetWorkspaceInfo() failed: %v", err)
+			}
+			wantMode := tt.mode
+			if wantMode == "" {
+				wantMode = WorkspaceModeDirect
+			}
+			if info.Mode != wantMode || info.HostPath != opts.WorkspacePath {
+				t.Errorf("GetWorkspaceInfo() = %+v, want mode %s for %s", info, wantMode, opts.WorkspacePath)
+			}
+
+			// Copy mode pushes the host tree into the container
+			staged := "/var/lib/coi/import/" + filepath.Base(opts.WorkspacePath) + "/main.go"
+			if _, copied := backend.File(result.ContainerName, staged); copied != (tt.mode == WorkspaceModeCopy) {
+				t.Errorf("Expected workspace copied = %v", tt.mode == WorkspaceModeCopy)
+			}
+
+			commands := strings.Join(backend.ExecCommands(), "\n")
+			if mounted := strings.Contains(commands, "mount -t overlay"); mounted != (tt.mode == WorkspaceModeOverlay) {
+				t.Errorf("Expected overlay mounted = %v, commands:\n%s", tt.mode == WorkspaceModeOverlay, commands)
+			}
+		})
+	}
+}
+
+func TestCleanupKeepsWorkspaceCopy(t *testing.T) {
+	backend := useFakeBackend(t)
+	fastStopPolling(t)
+
+	backend.AddInstance("coi-test-1", "Stopped")
+	mgr := container.NewManager("coi-test-1")
+	if err := mgr.SetConfig(workspaceModeKey, WorkspaceModeOverlay); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Cleanup(CleanupOptions{ContainerName: "coi-test-1", Tool: tool.NewClaude(), Logger: func(string) {}})
+	if err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+	if backend.Instance("coi-test-1") == nil {
+		t.Error("Expected container with unapplied workspace changes to be kept")
+	}
+}
+
+// writeHostFile writes a file below dir, creating parent directories
+func writeHostFile(t *testing.T, dir, rel, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,198 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WorktreeBranchPrefix prefixes the branches of slot worktrees (coi/<slot>-<session>)
+const WorktreeBranchPrefix = "coi/"
+
+// Worktree is a git worktree giving one slot its own checkout of the workspace
+type Worktree struct {
+	Path   string // Worktree directory on the host
+	Branch string // Branch checked out in the worktree
+	Slot   int    // Slot the worktree belongs to (0 if the branch name has none)
+	GitDir string // Repository's common .git directory, mounted at the same path so git works in the container
+}
+
+// git runs a git command in dir and returns its trimmed stdout
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitCommonDir returns the absolute common .git directory of the repository at workspace
+// The workspace must be the top level of the repository, since the worktree replaces it as /workspace
+func gitCommonDir(workspace string) (string, error) {
+	top, err := git(workspace, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("%s is not a git repository: %w", workspace, err)
+	}
+	if resolved, err := filepath.EvalSymlinks(workspace); err == nil && filepath.Clean(top) != resolved {
+		return "", fmt.Errorf("worktrees need the workspace to be the top level of its repository (%s)", top)
+	}
+
+	dir, err := git(workspace, "rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(workspace, dir)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// WorktreePath returns the directory of a slot's worktree, e.g. ~/.coi/worktrees/<hash>/slot-1
+func WorktreePath(worktreesDir, workspace string, slot int) string {
+	return filepath.Join(worktreesDir, WorkspaceHash(workspace), fmt.Sprintf("slot-%d", slot))
+}
+
+// WorktreeBranch returns the branch name for a new slot worktree, e.g. coi/1-1a2b3c4d
+func WorktreeBranch(slot int, sessionID string) string {
+	if len(sessionID) > 8 {
+		sessionID = sessionID[:8]
+	}
+	return fmt.Sprintf("%s%d-%s", WorktreeBranchPrefix, slot, sessionID)
+}
+
+// PrepareWorktree returns the slot's worktree, creating it from the workspace's HEAD on a new branch if needed
+// An existing worktree (e.g., of a persistent container) is reused with whatever it has checked out
+func PrepareWorktree(worktreesDir, workspace string, slot int, sessionID string) (*Worktree, error) {
+	gitDir, err := gitCommonDir(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := WorktreePath(worktreesDir, workspace, slot)
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		branch, err := git(dir, "rev-parse", "--abbrev-ref", "HEAD")
+		if err != nil {
+			return nil, fmt.Errorf("existing worktree %s is broken (remove it with 'coi worktree prune --force'): %w", dir, err)
+		}
+		return &Worktree{Path: dir, Branch: branch, Slot: slot, GitDir: gitDir}, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create worktrees directory: %w", err)
+	}
+	// Forget registrations of worktree directories that were deleted by hand
+	_, _ = git(workspace, "worktree", "prune")
+
+	branch := WorktreeBranch(slot, sessionID)
+	if _, err := git(workspace, "worktree", "add", "-b", branch, dir, "HEAD"); err != nil {
+		return nil, fmt.Errorf("failed to create worktree: %w", err)
+	}
+	return &Worktree{Path: dir, Branch: branch, Slot: slot, GitDir: gitDir}, nil
+}
+
+// ListWorktrees returns the coi slot worktrees of the repository at workspace
+func ListWorktrees(workspace string) ([]Worktree, error) {
+	out, err := git(workspace, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	return parseWorktreeList(out), nil
+}
+
+// parseWorktreeList parses `git worktree list --porcelain`, keeping worktrees on coi/ branches
+func parseWorktreeList(out string) []Worktree {
+	var worktrees []Worktree
+	for _, block := range strings.Split(out, "\n\n") {
+		var wt Worktree
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "worktree "):
+				wt.Path = strings.TrimPrefix(line, "worktree ")
+			case strings.HasPrefix(line, "branch refs/heads/"):
+				wt.Branch = strings.TrimPrefix(line, "branch refs/heads/")
+			}
+		}
+		if wt.Path == "" || !strings.HasPrefix(wt.Branch, WorktreeBranchPrefix) {
+			continue
+		}
+		// coi/<slot>-<session>
+		slot, _, _ := strings.Cut(strings.TrimPrefix(wt.Branch, WorktreeBranchPrefix), "-")
+		wt.Slot, _ = strconv.Atoi(slot)
+		worktrees = append(worktrees, wt)
+	}
+	return worktrees
+}
+
+// FindWorktree returns the worktree of the workspace with the given slot number or branch
+func FindWorktree(workspace, slotOrBranch string) (*Worktree, error) {
+	worktrees, err := ListWorktrees(workspace)
+	if err != nil {
+		return nil, err
+	}
+	for i, wt := range worktrees {
+		if wt.Branch == slotOrBranch || strconv.Itoa(wt.Slot) == slotOrBranch {
+			return &worktrees[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no coi worktree for '%s' - see 'coi worktree list'", slotOrBranch)
+}
+
+// WorktreeStatus summarizes a worktree against the workspace's current branch
+type WorktreeStatus struct {
+	Ahead int  // Commits on the worktree branch not in the workspace's HEAD
+	Dirty bool // Uncommitted changes in the worktree
+}
+
+// GetWorktreeStatus returns how far a worktree is ahead of the workspace and whether it has uncommitted changes
+func GetWorktreeStatus(workspace string, wt Worktree) (WorktreeStatus, error) {
+	var status WorktreeStatus
+	count, err := git(workspace, "rev-list", "--count", "HEAD.."+wt.Branch)
+	if err != nil {
+		return status, err
+	}
+	status.Ahead, _ = strconv.Atoi(count)
+
+	changes, err := git(wt.Path, "status", "--porcelain")
+	if err != nil {
+		return status, err
+	}
+	status.Dirty = changes != ""
+	return status, nil
+}
+
+// MergeWorktree merges a worktree's branch into the workspace's current branch
+// Only committed work is merged; git's output is returned for display
+func MergeWorktree(workspace string, wt Worktree) (string, error) {
+	return git(workspace, "merge", "--no-edit", wt.Branch)
+}
+
+// RemoveWorktree removes a worktree and deletes its branch
+// Without force, git refuses to remove a worktree with uncommitted changes or delete an unmerged branch
+func RemoveWorktree(workspace string, wt Worktree, force bool) error {
+	args := []string{"worktree", "remove", wt.Path}
+	if force {
+		args = []string{"worktree", "remove", "--force", wt.Path}
+	}
+	if _, err := git(workspace, args...); err != nil {
+		return err
+	}
+
+	deleteFlag := "-d"
+	if force {
+		deleteFlag = "-D"
+	}
+	if _, err := git(workspace, "branch", deleteFlag, wt.Branch); err != nil {
+		return fmt.Errorf("worktree removed but branch %s kept: %w", wt.Branch, err)
+	}
+	return nil
+}
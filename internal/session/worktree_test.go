@@ -0,0 +1,184 @@
+package session
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// initGitRepo creates a git repository with one commit and returns its path
+func initGitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	repo, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeHostFile(t, repo, "README.md", "hello\n")
+	for _, args := range [][]string{{"init", "-q", "-b", "main"}, {"add", "."}, {"commit", "-q", "-m", "initial"}} {
+		if _, err := git(repo, args...); err != nil {
+			t.Fatalf("git %v failed: %v", args, err)
+		}
+	}
+	return repo
+}
+
+func TestWorktreeBranch(t *testing.T) {
+	if got := WorktreeBranch(2, "1a2b3c4d-5e6f-7a8b"); got != "coi/2-1a2b3c4d" {
+		t.Errorf("WorktreeBranch() = %q, want %q", got, "coi/2-1a2b3c4d")
+	}
+}
+
+func TestParseWorktreeList(t *testing.T) {
+	out := `worktree /home/user/project
+HEAD 1111111111111111111111111111111111111111
+branch refs/heads/main
+
+worktree /home/user/.coi/worktrees/abc12345/slot-2
+HEAD 2222222222222222222222222222222222222222
+branch refs/heads/coi/2-1a2b3c4d
+
+worktree /tmp/other
+HEAD 3333333333333333333333333333333333333333
+detached
+`
+	want := []Worktree{{Path: "/home/user/.coi/worktrees/abc12345/slot-2", Branch: "coi/2-1a2b3c4d", Slot: 2}}
+	if got := parseWorktreeList(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseWorktreeList() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWorktreeLifecycle(t *testing.T) {
+	repo := initGitRepo(t)
+	worktreesDir := t.TempDir()
+
+	wt, err := PrepareWorktree(worktreesDir, repo, 1, "1a2b3c4d-session")
+	if err != nil {
+		t.Fatalf("PrepareWorktree() failed: %v", err)
+	}
+	if wt.Branch != "coi/1-1a2b3c4d" || wt.Path != WorktreePath(worktreesDir, repo, 1) || wt.GitDir != filepath.Join(repo, ".git") {
+		t.Errorf("Unexpected worktree: %+v", wt)
+	}
+
+	// A second session on the same slot reuses the worktree
+	again, err := PrepareWorktree(worktreesDir, repo, 1, "99999999-other")
+	if err != nil || again.Branch != wt.Branch {
+		t.Errorf("Expected worktree reused, got %+v (%v)", again, err)
+	}
+
+	// Commit in the worktree, then merge it into the workspace
+	writeHostFile(t, wt.Path, "feature.txt", "done\n")
+	if _, err := git(wt.Path, "add", "."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git(wt.Path, "commit", "-q", "-m", "feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := FindWorktree(repo, "1")
+	if err != nil {
+		t.Fatalf("FindWorktree() failed: %v", err)
+	}
+	status, err := GetWorktreeStatus(repo, *found)
+	if err != nil || status.Ahead != 1 || status.Dirty {
+		t.Errorf("GetWorktreeStatus() = %+v (%v), want 1 ahead and clean", status, err)
+	}
+
+	if _, err := MergeWorktree(repo, *found); err != nil {
+		t.Fatalf("MergeWorktree() failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "feature.txt")); err != nil {
+		t.Errorf("Expected merged file in workspace: %v", err)
+	}
+
+	if err := RemoveWorktree(repo, *found, false); err != nil {
+		t.Fatalf("RemoveWorktree() failed: %v", err)
+	}
+	if worktrees, _ := ListWorktrees(repo); len(worktrees) != 0 {
+		t.Errorf("Expected no worktrees after removal, got %+v", worktrees)
+	}
+	if _, err := git(repo, "rev-parse", "--verify", "coi/1-1a2b3c4d"); err == nil {
+		t.Error("Expected merged branch deleted")
+	}
+}
+
+func TestPrepareWorktreeRequiresRepositoryRoot(t *testing.T) {
+	repo := initGitRepo(t)
+	sub := filepath.Join(repo, "sub")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := PrepareWorktree(t.TempDir(), sub, 1, "session"); err == nil {
+		t.Error("Expected error for a workspace below the repository root")
+	}
+	if _, err := PrepareWorktree(t.TempDir(), t.TempDir(), 1, "session"); err == nil {
+		t.Error("Expected error for a workspace that is not a repository")
+	}
+}
+
+func TestSetupMountsWorktree(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.Worktree = &Worktree{Path: "/home/user/.coi/worktrees/abc/slot-1", Branch: "coi/1-abc", Slot: 1, GitDir: "/home/user/project/.git"}
+
+	result, err := Setup(opts)
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+
+	devices := backend.Instance(result.ContainerName).Devices
+	if devices["workspace"]["source"] != opts.Worktree.Path || devices["workspace"]["path"] != "/workspace" {
+		t.Errorf("Expected worktree mounted as /workspace, got %v", devices["workspace"])
+	}
+	if devices["worktree-git"]["source"] != opts.Worktree.GitDir || devices["worktree-git"]["path"] != opts.Worktree.GitDir {
+		t.Errorf("Expected git directory mounted at its host path, got %v", devices["worktree-git"])
+	}
+	if devices["worktree-git"]["readonly"] == "true" {
+		t.Errorf("Expected a writable git directory in direct mode, got %v", devices["worktree-git"])
+	}
+}
+
+func TestSetupMountsWorktreeGitReadOnly(t *testing.T) {
+	backend := useFakeBackend(t)
+	opts := fakeSetupOptions(t, backend)
+	opts.WorkspaceMode = WorkspaceModeOverlay
+	opts.Worktree = &Worktree{Path: "/home/user/.coi/worktrees/abc/slot-1", Branch: "coi/1-abc", Slot: 1, GitDir: "/home/user/project/.git"}
+
+	result, err := Setup(opts)
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+
+	// The shared .git directory must not take writes the overlay keeps from the worktree
+	if device := backend.Instance(result.ContainerName).Devices["worktree-git"]; device["readonly"] != "true" {
+		t.Errorf("Expected a read-only git directory in overlay mode, got %v", device)
+	}
+}
+
+func TestRecordWorktree(t *testing.T) {
+	sessionsDir := t.TempDir()
+	if err := SaveMetadataEarly(sessionsDir, "session-1", "coi-test-1", "/home/user/project", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordWorktree(sessionsDir, "session-1", &Worktree{Path: "/home/user/.coi/worktrees/abc/slot-1", Branch: "coi/1-session"}); err != nil {
+		t.Fatalf("RecordWorktree() failed: %v", err)
+	}
+
+	metadata, err := LoadSessionMetadata(filepath.Join(sessionsDir, "session-1", "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Worktree != "/home/user/.coi/worktrees/abc/slot-1" || metadata.Branch != "coi/1-session" || metadata.Workspace != "/home/user/project" {
+		t.Errorf("Unexpected metadata: %+v", metadata)
+	}
+}
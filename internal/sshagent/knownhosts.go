@@ -0,0 +1,82 @@
+package sshagent
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KnownHosts maps host keys from a known_hosts file to the hosts they belong to
+type KnownHosts struct {
+	hosts   map[string]string // Key blob -> first host name of its line
+	revoked map[string]bool   // Key blobs marked @revoked
+}
+
+// LoadKnownHosts reads a known_hosts file
+func LoadKnownHosts(path string) (*KnownHosts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	defer f.Close()
+	return ParseKnownHosts(f)
+}
+
+// ParseKnownHosts parses known_hosts lines ([marker] hosts keytype key [comment])
+// @cert-authority lines are skipped: host certificates are not supported
+func ParseKnownHosts(r io.Reader) (*KnownHosts, error) {
+	k := &KnownHosts{hosts: make(map[string]string), revoked: make(map[string]bool)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		marker := ""
+		if strings.HasPrefix(fields[0], "@") {
+			marker, fields = fields[0], fields[1:]
+		}
+		if len(fields) < 3 || marker == "@cert-authority" {
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+
+		if marker == "@revoked" {
+			k.revoked[string(blob)] = true
+			continue
+		}
+		if _, seen := k.hosts[string(blob)]; !seen {
+			k.hosts[string(blob)] = hostName(fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	return k, nil
+}
+
+// Lookup returns the host a host key belongs to, if it is known and not revoked
+func (k *KnownHosts) Lookup(hostKey []byte) (string, bool) {
+	if k.revoked[string(hostKey)] {
+		return "", false
+	}
+	host, ok := k.hosts[string(hostKey)]
+	return host, ok
+}
+
+// hostName returns the first host of a known_hosts hosts field, for display
+func hostName(hosts string) string {
+	first, _, _ := strings.Cut(hosts, ",")
+	if strings.HasPrefix(first, "|1|") {
+		return "(hashed host)"
+	}
+	return first
+}
@@ -0,0 +1,44 @@
+package sshagent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKnownHosts(t *testing.T) {
+	input := strings.Join([]string{
+		"# comment",
+		"github.com,140.82.112.3 ssh-ed25519 " + b64("github-key"),
+		"|1|c2FsdA==|aGFzaA== ssh-ed25519 " + b64("hashed-key"),
+		"[git.example.com]:2222 ssh-rsa " + b64("example-key") + " some comment",
+		"@cert-authority *.corp.example.com ssh-ed25519 " + b64("ca-key"),
+		"@revoked * ssh-ed25519 " + b64("revoked-key"),
+		"old.example.com ssh-ed25519 " + b64("revoked-key"),
+		"broken line",
+		"",
+	}, "\n")
+
+	knownHosts, err := ParseKnownHosts(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseKnownHosts() failed: %v", err)
+	}
+
+	tests := []struct {
+		key      string
+		wantHost string
+		wantOK   bool
+	}{
+		{"github-key", "github.com", true},
+		{"hashed-key", "(hashed host)", true},
+		{"example-key", "[git.example.com]:2222", true},
+		{"ca-key", "", false},
+		{"revoked-key", "", false},
+		{"unknown-key", "", false},
+	}
+	for _, tt := range tests {
+		host, ok := knownHosts.Lookup([]byte(tt.key))
+		if host != tt.wantHost || ok != tt.wantOK {
+			t.Errorf("Lookup(%q) = %q, %v, want %q, %v", tt.key, host, ok, tt.wantHost, tt.wantOK)
+		}
+	}
+}
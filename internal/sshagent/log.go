@@ -0,0 +1,73 @@
+package sshagent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Verdicts recorded in the signing log
+const (
+	VerdictAllowed = "allowed"
+	VerdictDenied  = "denied"
+)
+
+// LogEntry is a single signing log record (one JSON object per line)
+type LogEntry struct {
+	Time      time.Time `json:"time"`
+	Session   string    `json:"session"`
+	Container string    `json:"container"`
+	Key       string    `json:"key"`            // Fingerprint of the key asked to sign
+	Host      string    `json:"host,omitempty"` // Host the container's ssh connected to, if it bound the session
+	Verdict   string    `json:"verdict"`
+	Reason    string    `json:"reason,omitempty"` // Why the request was denied
+}
+
+// Log appends entries to the signing log file ([ssh] log)
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewLog returns a log writing to path, or nil (discarding entries) if path is empty
+func NewLog(path string) *Log {
+	if path == "" {
+		return nil
+	}
+	return &Log{path: path}
+}
+
+// Record appends an entry to the log; a nil log discards entries
+func (l *Log) Record(entry LogEntry) error {
+	if l == nil {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open signing log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write signing log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package sshagent
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SSH agent protocol messages (draft-miller-ssh-agent) used by the proxy
+const (
+	msgFailure            = 5
+	msgSuccess            = 6
+	msgRequestIdentities  = 11
+	msgIdentitiesAnswer   = 12
+	msgSignRequest        = 13
+	msgSignResponse       = 14
+	msgExtension          = 27
+	msgUserauthRequest    = 50 // SSH_MSG_USERAUTH_REQUEST (RFC 4252), the start of what ssh signs to authenticate
+	sessionBindExtension  = "session-bind@openssh.com"
+	maxMessageSize        = 256 * 1024 // Same limit as OpenSSH's ssh-agent
+	fingerprintHashPrefix = "SHA256:"
+)
+
+// failure is the reply to requests the proxy refuses
+var failure = []byte{msgFailure}
+
+// errShortMessage is returned for truncated messages
+var errShortMessage = errors.New("truncated agent message")
+
+// Fingerprint returns the SHA256 fingerprint of a public key blob, as shown by ssh-add -l
+func Fingerprint(keyBlob []byte) string {
+	sum := sha256.Sum256(keyBlob)
+	return fingerprintHashPrefix + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// readMessage reads a length-prefixed agent message
+func readMessage(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length == 0 || length > maxMessageSize {
+		return nil, fmt.Errorf("invalid agent message length %d", length)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeMessage writes a length-prefixed agent message
+func writeMessage(w io.Writer, msg []byte) error {
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(msg)), uint32(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// decoder reads the fields of an agent message; the first error sticks
+type decoder struct {
+	data []byte
+	err  error
+}
+
+// uint32 reads a big-endian uint32
+func (d *decoder) uint32() uint32 {
+	if d.err != nil || len(d.data) < 4 {
+		d.err = errShortMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.data)
+	d.data = d.data[4:]
+	return v
+}
+
+// byte reads a single byte
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.data) < 1 {
+		d.err = errShortMessage
+		return 0
+	}
+	v := d.data[0]
+	d.data = d.data[1:]
+	return v
+}
+
+// string reads a length-prefixed byte string
+func (d *decoder) string() []byte {
+	n := d.uint32()
+	if d.err != nil || uint32(len(d.data)) < n {
+		d.err = errShortMessage
+		return nil
+	}
+	v := d.data[:n]
+	d.data = d.data[n:]
+	return v
+}
+
+// appendString appends a length-prefixed byte string
+func appendString(b, s []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(s))), s...)
+}
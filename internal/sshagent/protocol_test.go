@@ -0,0 +1,47 @@
+package sshagent
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+// testKey is an ed25519 public key blob; ssh-keygen -l shows testKeyFingerprint for it
+var testKey, _ = base64.StdEncoding.DecodeString("AAAAC3NzaC1lZDI1NTE5AAAAIK4EX7E88Is7QH/PAKsMUifYrFCkHCtish+nM0SJ/3b8")
+
+const testKeyFingerprint = "SHA256:u6fLN4cArdOfGb0dY7P5h+yjGCFCTmd7i0sGabmftJA"
+
+func TestFingerprint(t *testing.T) {
+	if got := Fingerprint(testKey); got != testKeyFingerprint {
+		t.Errorf("Fingerprint() = %q, want %q", got, testKeyFingerprint)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := appendString(appendString([]byte{msgSignRequest}, []byte("key")), []byte("data"))
+
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, msg); err != nil {
+		t.Fatalf("writeMessage() failed: %v", err)
+	}
+	got, err := readMessage(&buf)
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("readMessage() = %v (%v), want %v", got, err, msg)
+	}
+
+	d := &decoder{data: got[1:]}
+	if key, data := d.string(), d.string(); d.err != nil || string(key) != "key" || string(data) != "data" {
+		t.Errorf("decoded %q, %q (%v)", key, data, d.err)
+	}
+	if d.string(); d.err == nil {
+		t.Error("Expected an error reading past the end of the message")
+	}
+}
+
+func TestReadMessageRejectsInvalidLength(t *testing.T) {
+	for _, header := range [][]byte{{0, 0, 0, 0}, {0, 0x10, 0, 0}} {
+		if _, err := readMessage(bytes.NewReader(header)); err == nil {
+			t.Errorf("Expected an error for length header %v", header)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package sshagent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// Proxy is a filtering SSH agent between a container and the host's agent (SSH_AUTH_SOCK)
+// It lists and signs with the allowed keys only, optionally only for hosts in known_hosts,
+// and refuses everything else (adding or removing keys, locking, other extensions)
+type Proxy struct {
+	session    string
+	container  string
+	upstream   string
+	keys       map[string]bool
+	knownHosts *KnownHosts // nil = sign for any host
+	log        *Log
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+}
+
+// NewProxy creates an agent proxy for a session, forwarding to the agent at upstream
+func NewProxy(cfg config.SSHConfig, upstream, sessionID, container string) (*Proxy, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("[ssh] forward_agent is enabled but no keys are listed - add their fingerprints (ssh-add -l) to keys")
+	}
+	keys := make(map[string]bool, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		if !strings.HasPrefix(key, fingerprintHashPrefix) {
+			return nil, fmt.Errorf("invalid key fingerprint '%s': expected SHA256:... as shown by ssh-add -l", key)
+		}
+		keys[key] = true
+	}
+	if upstream == "" {
+		return nil, fmt.Errorf("no SSH agent running on the host (SSH_AUTH_SOCK is not set)")
+	}
+
+	p := &Proxy{
+		session:   sessionID,
+		container: container,
+		upstream:  upstream,
+		keys:      keys,
+		log:       NewLog(cfg.Log),
+		conns:     make(map[net.Conn]bool),
+	}
+	if cfg.RestrictHosts {
+		knownHosts, err := LoadKnownHosts(cfg.KnownHosts)
+		if err != nil {
+			return nil, err
+		}
+		p.knownHosts = knownHosts
+	}
+	return p, nil
+}
+
+// Start listens on socketPath (replacing a stale socket file)
+func (p *Proxy) Start(socketPath string) error {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	_ = os.Remove(socketPath)
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to restrict %s: %w", socketPath, err)
+	}
+	p.listener = ln
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if !p.track(conn, true) {
+				conn.Close()
+				return
+			}
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				defer p.track(conn, false)
+				p.serve(conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+// Stop closes the socket and all open connections
+func (p *Proxy) Stop() {
+	p.mu.Lock()
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// track adds or removes an open connection; it returns false once the proxy is stopped
+func (p *Proxy) track(conn net.Conn, open bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns == nil {
+		return false
+	}
+	if open {
+		p.conns[conn] = true
+	} else {
+		delete(p.conns, conn)
+	}
+	return true
+}
+
+// connection is one client of the proxy, with its own connection to the host agent
+type connection struct {
+	proxy     *Proxy
+	upstream  net.Conn
+	hostKey   []byte // Host key of the last session bound by the client (verified by the host agent)
+	sessionID []byte // Identifier of that session
+}
+
+// serve answers a client's requests until it disconnects
+func (p *Proxy) serve(client net.Conn) {
+	defer client.Close()
+	c := &connection{proxy: p}
+	defer func() {
+		if c.upstream != nil {
+			c.upstream.Close()
+		}
+	}()
+
+	for {
+		msg, err := readMessage(client)
+		if err != nil {
+			return
+		}
+		if err := writeMessage(client, c.handle(msg)); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a single request
+func (c *connection) handle(msg []byte) []byte {
+	switch msg[0] {
+	case msgRequestIdentities:
+		return c.identities(msg)
+	case msgSignRequest:
+		return c.sign(msg)
+	case msgExtension:
+		return c.extension(msg)
+	}
+	return failure
+}
+
+// forward sends a request to the host agent and returns its reply
+func (c *connection) forward(msg []byte) ([]byte, error) {
+	if c.upstream == nil {
+		conn, err := net.Dial("unix", c.proxy.upstream)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the host agent: %w", err)
+		}
+		c.upstream = conn
+	}
+	if err := writeMessage(c.upstream, msg); err != nil {
+		return nil, err
+	}
+	return readMessage(c.upstream)
+}
+
+// identities lists the host agent's keys, leaving out those not allowed
+func (c *connection) identities(msg []byte) []byte {
+	reply, err := c.forward(msg)
+	if err != nil || reply[0] != msgIdentitiesAnswer {
+		return failure
+	}
+
+	d := &decoder{data: reply[1:]}
+	count := d.uint32()
+	var keys [][2][]byte
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		blob, comment := d.string(), d.string()
+		if c.proxy.keys[Fingerprint(blob)] {
+			keys = append(keys, [2][]byte{blob, comment})
+		}
+	}
+	if d.err != nil {
+		return failure
+	}
+
+	answer := binary.BigEndian.AppendUint32([]byte{msgIdentitiesAnswer}, uint32(len(keys)))
+	for _, key := range keys {
+		answer = appendString(appendString(answer, key[0]), key[1])
+	}
+	return answer
+}
+
+// sign forwards a signing request for an allowed key (and host) and logs the verdict
+// With restrict_hosts, only user authentication to the bound session is signed: other data
+// could be a signature for another server, replayed by the container
+func (c *connection) sign(msg []byte) []byte {
+	d := &decoder{data: msg[1:]}
+	blob := d.string()
+	data := d.string()
+	if d.err != nil {
+		return failure
+	}
+
+	p := c.proxy
+	entry := LogEntry{Session: p.session, Container: p.container, Key: Fingerprint(blob), Verdict: VerdictDenied}
+	host, hostKnown := c.host()
+	entry.Host = host
+
+	reply := failure
+	switch {
+	case !p.keys[entry.Key]:
+		entry.Reason = "key not allowed ([ssh] keys)"
+	case p.knownHosts != nil && c.hostKey == nil:
+		entry.Reason = "unknown host: the container's ssh did not bind the session (needs OpenSSH 8.9+)"
+	case p.knownHosts != nil && !hostKnown:
+		entry.Reason = "host key not in known_hosts"
+	case p.knownHosts != nil && !c.authenticatesBoundSession(data):
+		entry.Reason = "not a user authentication for the bound session"
+	default:
+		signed, err := c.forward(msg)
+		switch {
+		case err != nil:
+			entry.Reason = err.Error()
+		case signed[0] != msgSignResponse:
+			entry.Reason = "refused by the host agent"
+		default:
+			entry.Verdict = VerdictAllowed
+			reply = signed
+		}
+	}
+
+	_ = p.log.Record(entry)
+	return reply
+}
+
+// authenticatesBoundSession returns true if signing request data is a user authentication
+// to the bound session
+func (c *connection) authenticatesBoundSession(data []byte) bool {
+	sessionID := userauthSession(data)
+	return len(sessionID) > 0 && bytes.Equal(sessionID, c.sessionID)
+}
+
+// userauthSession returns the session identifier of signing request data that is a publickey
+// SSH_MSG_USERAUTH_REQUEST (what ssh signs to log in), or nil for any other data
+func userauthSession(data []byte) []byte {
+	d := &decoder{data: data}
+	sessionID := d.string()
+	if d.byte() != msgUserauthRequest {
+		return nil
+	}
+	d.string() // User
+	d.string() // Service
+	if string(d.string()) != "publickey" || d.err != nil {
+		return nil
+	}
+	return sessionID
+}
+
+// host returns the bound host for the log (its known_hosts name or key fingerprint)
+// and whether it is in known_hosts
+func (c *connection) host() (string, bool) {
+	if c.hostKey == nil {
+		return "", false
+	}
+	if c.proxy.knownHosts != nil {
+		if name, ok := c.proxy.knownHosts.Lookup(c.hostKey); ok {
+			return name, true
+		}
+	}
+	return Fingerprint(c.hostKey), false
+}
+
+// extension passes session binding (which ssh sends with the server's host key) to the host agent,
+// which verifies it; other extensions are refused
+// With restrict_hosts, bindings for agent forwarding are refused: signatures requested through
+// the forwarded agent would be for hosts the proxy never sees
+func (c *connection) extension(msg []byte) []byte {
+	d := &decoder{data: msg[1:]}
+	name := string(d.string())
+	if d.err != nil || name != sessionBindExtension {
+		return failure
+	}
+	hostKey := d.string()
+	sessionID := d.string()
+	d.string() // Signature of the session by the host key, verified by the host agent
+	forwarding := d.byte() != 0
+	if d.err != nil || (forwarding && c.proxy.knownHosts != nil) {
+		return failure
+	}
+
+	reply, err := c.forward(msg)
+	if err != nil {
+		return failure
+	}
+	if reply[0] == msgSuccess {
+		c.hostKey = append([]byte(nil), hostKey...)
+		c.sessionID = append([]byte(nil), sessionID...)
+	}
+	return reply
+}
@@ -0,0 +1,335 @@
+package sshagent
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// b64 returns the base64 encoding of s (a stand-in for a key blob in known_hosts)
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// fakeAgent is a host SSH agent holding testKey and "other-key"
+// It signs anything and accepts session bindings whose signature is "valid"
+type fakeAgent struct {
+	mu       sync.Mutex
+	received []byte // Types of the messages that reached the agent
+}
+
+// start serves the agent on a socket in dir and returns its path
+func (a *fakeAgent) start(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "agent.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to start fake agent: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go a.serve(conn)
+		}
+	}()
+	return path
+}
+
+func (a *fakeAgent) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		a.mu.Lock()
+		a.received = append(a.received, msg[0])
+		a.mu.Unlock()
+
+		reply := failure
+		d := &decoder{data: msg[1:]}
+		switch msg[0] {
+		case msgRequestIdentities:
+			reply = binary.BigEndian.AppendUint32([]byte{msgIdentitiesAnswer}, 2)
+			reply = appendString(appendString(reply, testKey), []byte("allowed"))
+			reply = appendString(appendString(reply, []byte("other-key")), []byte("other"))
+		case msgSignRequest:
+			reply = appendString([]byte{msgSignResponse}, append([]byte("signature-by-"), d.string()...))
+		case msgExtension:
+			d.string()
+			d.string()
+			d.string()
+			if string(d.string()) == "valid" {
+				reply = []byte{msgSuccess}
+			}
+		}
+		if writeMessage(conn, reply) != nil {
+			return
+		}
+	}
+}
+
+func (a *fakeAgent) receivedTypes() []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]byte(nil), a.received...)
+}
+
+// agentClient is a client of the proxy, like ssh in the container
+type agentClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func dialProxy(t *testing.T, socket string) *agentClient {
+	t.Helper()
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &agentClient{t: t, conn: conn}
+}
+
+func (c *agentClient) request(msg []byte) []byte {
+	c.t.Helper()
+	if err := writeMessage(c.conn, msg); err != nil {
+		c.t.Fatalf("request failed: %v", err)
+	}
+	reply, err := readMessage(c.conn)
+	if err != nil {
+		c.t.Fatalf("reading reply failed: %v", err)
+	}
+	return reply
+}
+
+func (c *agentClient) sign(key []byte) []byte {
+	msg := appendString(appendString([]byte{msgSignRequest}, key), []byte("data"))
+	return c.request(binary.BigEndian.AppendUint32(msg, 0))
+}
+
+// signAuth requests a signature of a publickey user authentication for a session
+func (c *agentClient) signAuth(key []byte, sessionID string) []byte {
+	data := append(appendString(nil, []byte(sessionID)), msgUserauthRequest)
+	for _, field := range []string{"git", "ssh-connection", "publickey"} {
+		data = appendString(data, []byte(field))
+	}
+	data = appendString(append(appendString(data, []byte("ssh-ed25519")), 1), key)
+	msg := appendString(appendString([]byte{msgSignRequest}, key), data)
+	return c.request(binary.BigEndian.AppendUint32(msg, 0))
+}
+
+func (c *agentClient) bind(hostKey []byte, signature string) []byte {
+	return c.bindSession(hostKey, "session-id", signature, false)
+}
+
+func (c *agentClient) bindSession(hostKey []byte, sessionID, signature string, forwarding bool) []byte {
+	msg := appendString([]byte{msgExtension}, []byte(sessionBindExtension))
+	msg = appendString(msg, hostKey)
+	msg = appendString(msg, []byte(sessionID))
+	msg = appendString(msg, []byte(signature))
+	if forwarding {
+		return c.request(append(msg, 1))
+	}
+	return c.request(append(msg, 0))
+}
+
+// startTestProxy starts a proxy allowing testKey in front of a fake agent
+func startTestProxy(t *testing.T, cfg config.SSHConfig) (*fakeAgent, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	agent := &fakeAgent{}
+	upstream := agent.start(t, dir)
+
+	cfg.Keys = []string{testKeyFingerprint}
+	cfg.Log = filepath.Join(dir, "ssh-agent.log")
+	proxy, err := NewProxy(cfg, upstream, "session-1", "coi-test-1")
+	if err != nil {
+		t.Fatalf("NewProxy() failed: %v", err)
+	}
+	socket := filepath.Join(dir, "proxy.sock")
+	if err := proxy.Start(socket); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(proxy.Stop)
+	return agent, socket, cfg.Log
+}
+
+// readEntries returns all entries of a signing log
+func readEntries(t *testing.T, path string) []LogEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	defer f.Close()
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestNewProxyValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.SSHConfig
+		upstream string
+		wantErr  string
+	}{
+		{"no keys", config.SSHConfig{}, "/tmp/agent.sock", "no keys are listed"},
+		{"invalid fingerprint", config.SSHConfig{Keys: []string{"MD5:aa:bb"}}, "/tmp/agent.sock", "invalid key fingerprint"},
+		{"no agent", config.SSHConfig{Keys: []string{testKeyFingerprint}}, "", "SSH_AUTH_SOCK is not set"},
+		{"missing known_hosts", config.SSHConfig{Keys: []string{testKeyFingerprint}, RestrictHosts: true, KnownHosts: "/nonexistent/known_hosts"}, "/tmp/agent.sock", "known_hosts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProxy(tt.cfg, tt.upstream, "session-1", "coi-test-1")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewProxy() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxyFiltersKeys(t *testing.T) {
+	agent, socket, logPath := startTestProxy(t, config.SSHConfig{})
+	client := dialProxy(t, socket)
+
+	reply := client.request([]byte{msgRequestIdentities})
+	d := &decoder{data: reply[1:]}
+	if reply[0] != msgIdentitiesAnswer || d.uint32() != 1 {
+		t.Fatalf("Expected one identity, got %v", reply)
+	}
+	if blob, comment := d.string(), d.string(); string(blob) != string(testKey) || string(comment) != "allowed" {
+		t.Errorf("Unexpected identity %q (%s)", blob, comment)
+	}
+
+	if reply := client.sign(testKey); reply[0] != msgSignResponse {
+		t.Errorf("Expected a signature for the allowed key, got %v", reply)
+	}
+	if reply := client.sign([]byte("other-key")); reply[0] != msgFailure {
+		t.Errorf("Expected failure for a key not allowed, got %v", reply)
+	}
+
+	// Adding keys, locking and other extensions never reach the host agent
+	for _, msg := range [][]byte{{17}, {22}, appendString([]byte{msgExtension}, []byte("query"))} {
+		if reply := client.request(msg); reply[0] != msgFailure {
+			t.Errorf("Expected failure for message %v, got %v", msg, reply)
+		}
+	}
+	if got := agent.receivedTypes(); string(got) != string([]byte{msgRequestIdentities, msgSignRequest}) {
+		t.Errorf("Unexpected messages reached the host agent: %v", got)
+	}
+
+	entries := readEntries(t, logPath)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 log entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Verdict != VerdictAllowed || e.Key != testKeyFingerprint || e.Session != "session-1" || e.Container != "coi-test-1" {
+		t.Errorf("Unexpected first entry: %+v", e)
+	}
+	if e := entries[1]; e.Verdict != VerdictDenied || e.Key != Fingerprint([]byte("other-key")) || e.Reason == "" {
+		t.Errorf("Unexpected second entry: %+v", e)
+	}
+}
+
+func TestProxyRestrictsHosts(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte("github.com ssh-ed25519 "+b64("github-key")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, socket, logPath := startTestProxy(t, config.SSHConfig{RestrictHosts: true, KnownHosts: knownHosts})
+
+	// Without a bound session the host is unknown
+	unbound := dialProxy(t, socket)
+	if reply := unbound.sign(testKey); reply[0] != msgFailure {
+		t.Errorf("Expected failure without a session binding, got %v", reply)
+	}
+
+	// A binding the host agent rejects is not trusted
+	forged := dialProxy(t, socket)
+	if reply := forged.bind([]byte("github-key"), "forged"); reply[0] != msgFailure {
+		t.Errorf("Expected the forged binding to fail, got %v", reply)
+	}
+	if reply := forged.sign(testKey); reply[0] != msgFailure {
+		t.Errorf("Expected failure after a rejected binding, got %v", reply)
+	}
+
+	known := dialProxy(t, socket)
+	if reply := known.bind([]byte("github-key"), "valid"); reply[0] != msgSuccess {
+		t.Fatalf("Expected the binding to succeed, got %v", reply)
+	}
+	if reply := known.signAuth(testKey, "session-id"); reply[0] != msgSignResponse {
+		t.Errorf("Expected a signature for a known host, got %v", reply)
+	}
+
+	// Only user authentication to the bound session is signed, not data for another server
+	if reply := known.signAuth(testKey, "other-session"); reply[0] != msgFailure {
+		t.Errorf("Expected failure for another session, got %v", reply)
+	}
+	if reply := known.sign(testKey); reply[0] != msgFailure {
+		t.Errorf("Expected failure for data that is not a user authentication, got %v", reply)
+	}
+
+	unknown := dialProxy(t, socket)
+	unknown.bind([]byte("evil-key"), "valid")
+	if reply := unknown.signAuth(testKey, "session-id"); reply[0] != msgFailure {
+		t.Errorf("Expected failure for a host not in known_hosts, got %v", reply)
+	}
+
+	entries := readEntries(t, logPath)
+	if len(entries) != 6 {
+		t.Fatalf("Expected 6 log entries, got %d", len(entries))
+	}
+	if e := entries[2]; e.Verdict != VerdictAllowed || e.Host != "github.com" {
+		t.Errorf("Unexpected entry for the known host: %+v", e)
+	}
+	for _, e := range entries[3:5] {
+		if e.Verdict != VerdictDenied || e.Host != "github.com" || e.Reason == "" {
+			t.Errorf("Unexpected entry for a signature outside the session: %+v", e)
+		}
+	}
+	if e := entries[5]; e.Verdict != VerdictDenied || e.Host != Fingerprint([]byte("evil-key")) {
+		t.Errorf("Unexpected entry for the unknown host: %+v", e)
+	}
+}
+
+func TestProxyRefusesForwardingBinds(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte("github.com ssh-ed25519 "+b64("github-key")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	agent, socket, _ := startTestProxy(t, config.SSHConfig{RestrictHosts: true, KnownHosts: knownHosts})
+
+	client := dialProxy(t, socket)
+	if reply := client.bindSession([]byte("github-key"), "session-id", "valid", true); reply[0] != msgFailure {
+		t.Errorf("Expected a forwarding binding to fail, got %v", reply)
+	}
+	if reply := client.signAuth(testKey, "session-id"); reply[0] != msgFailure {
+		t.Errorf("Expected failure after a refused binding, got %v", reply)
+	}
+	if got := agent.receivedTypes(); len(got) != 0 {
+		t.Errorf("Expected nothing to reach the host agent, got %v", got)
+	}
+}
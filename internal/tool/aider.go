@@ -0,0 +1,124 @@
+package tool
+
+import (
+	"os"
+	"strings"
+)
+
+// Aider chat history file suffixes. History files live directly in the
+// config directory so they are saved and restored with the rest of the session.
+const (
+	aiderChatHistorySuffix  = ".chat.history.md"
+	aiderInputHistorySuffix = ".input.history"
+)
+
+// AiderTool implements Tool for Aider (https://aider.chat)
+type AiderTool struct{}
+
+// NewAider creates a new Aider tool instance
+func NewAider() Tool {
+	return &AiderTool{}
+}
+
+func (a *AiderTool) Name() string {
+	return "aider"
+}
+
+func (a *AiderTool) Binary() string {
+	return "aider"
+}
+
+func (a *AiderTool) ConfigDirName() string {
+	return ".aider"
+}
+
+func (a *AiderTool) SessionsDirName() string {
+	return "sessions-aider"
+}
+
+func (a *AiderTool) BuildCommand(sessionID string, resume bool, resumeSessionID string) []string {
+	// --yes-always is Aider's equivalent of bypassing permission prompts
+	cmd := []string{"aider", "--yes-always"}
+
+	// Aider has no session IDs of its own - a session is identified by its history files.
+	// When resuming a discovered session, keep writing to the same files.
+	historyID := sessionID
+	if resume && resumeSessionID != "" {
+		historyID = resumeSessionID
+	}
+
+	historyBase := "~/" + a.ConfigDirName() + "/" + historyID
+	cmd = append(cmd,
+		"--chat-history-file", historyBase+aiderChatHistorySuffix,
+		"--input-history-file", historyBase+aiderInputHistorySuffix,
+	)
+
+	if resume {
+		cmd = append(cmd, "--restore-chat-history")
+	}
+
+	return cmd
+}
+
+func (a *AiderTool) DiscoverSessionID(stateDir string) string {
+	// Aider sessions are the <id>.chat.history.md files written by BuildCommand
+	entries, err := os.ReadDir(stateDir)
+	if err != nil {
+		return ""
+	}
+
+	// Pick the most recently written history file
+	var latestID string
+	var latestMod int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), aiderChatHistorySuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if latestID == "" || info.ModTime().UnixNano() > latestMod {
+			latestID = strings.TrimSuffix(entry.Name(), aiderChatHistorySuffix)
+			latestMod = info.ModTime().UnixNano()
+		}
+	}
+
+	return latestID
+}
+
+func (a *AiderTool) GetSandboxSettings() map[string]interface{} {
+	// Aider has no settings file to patch - --yes-always on the command line is enough
+	return map[string]interface{}{}
+}
+
+func (a *AiderTool) ConfigFiles() []string {
+	// OAuth keys obtained via `aider --login` (e.g. OpenRouter)
+	return []string{"oauth-keys.env"}
+}
+
+func (a *AiderTool) HomeConfigFiles() []string {
+	return []string{
+		".aider.conf.yml",
+		".aider.model.settings.yml",
+		".aider.model.metadata.json",
+	}
+}
+
+func (a *AiderTool) AuthEnvVars() []string {
+	return []string{
+		"ANTHROPIC_API_KEY",
+		"OPENAI_API_KEY",
+		"OPENAI_API_BASE",
+		"OPENROUTER_API_KEY",
+		"GEMINI_API_KEY",
+		"DEEPSEEK_API_KEY",
+		"GROQ_API_KEY",
+		"MISTRAL_API_KEY",
+		"AZURE_API_KEY",
+		"AZURE_API_BASE",
+		"AZURE_API_VERSION",
+		"OLLAMA_API_BASE",
+		"AIDER_MODEL",
+	}
+}
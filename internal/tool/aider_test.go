@@ -0,0 +1,148 @@
+package tool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAiderToolBasics(t *testing.T) {
+	tool := NewAider()
+
+	if tool.Name() != "aider" {
+		t.Errorf("Expected name 'aider', got '%s'", tool.Name())
+	}
+
+	if tool.Binary() != "aider" {
+		t.Errorf("Expected binary 'aider', got '%s'", tool.Binary())
+	}
+
+	if tool.ConfigDirName() != ".aider" {
+		t.Errorf("Expected config dir '.aider', got '%s'", tool.ConfigDirName())
+	}
+
+	if tool.SessionsDirName() != "sessions-aider" {
+		t.Errorf("Expected sessions dir 'sessions-aider', got '%s'", tool.SessionsDirName())
+	}
+}
+
+func TestAiderBuildCommand_NewSession(t *testing.T) {
+	tool := NewAider()
+
+	cmd := tool.BuildCommand("test-session-123", false, "")
+
+	expected := []string{
+		"aider", "--yes-always",
+		"--chat-history-file", "~/.aider/test-session-123.chat.history.md",
+		"--input-history-file", "~/.aider/test-session-123.input.history",
+	}
+
+	if len(cmd) != len(expected) {
+		t.Fatalf("Expected %d args, got %d: %v", len(expected), len(cmd), cmd)
+	}
+
+	for i, arg := range expected {
+		if cmd[i] != arg {
+			t.Errorf("Arg[%d]: expected '%s', got '%s'", i, arg, cmd[i])
+		}
+	}
+}
+
+func TestAiderBuildCommand_ResumeWithID(t *testing.T) {
+	tool := NewAider()
+
+	cmd := tool.BuildCommand("new-session", true, "old-session")
+
+	if !contains(cmd, "--restore-chat-history") {
+		t.Errorf("Expected command to contain '--restore-chat-history', got: %v", cmd)
+	}
+
+	// Should keep writing to the resumed session's history file
+	if !contains(cmd, "~/.aider/old-session.chat.history.md") {
+		t.Errorf("Expected command to use resumed history file, got: %v", cmd)
+	}
+
+	if contains(cmd, "~/.aider/new-session.chat.history.md") {
+		t.Errorf("Expected command not to use new session history file, got: %v", cmd)
+	}
+}
+
+func TestAiderBuildCommand_ResumeWithoutID(t *testing.T) {
+	tool := NewAider()
+
+	cmd := tool.BuildCommand("test-session-123", true, "")
+
+	if !contains(cmd, "--restore-chat-history") {
+		t.Errorf("Expected command to contain '--restore-chat-history', got: %v", cmd)
+	}
+
+	if !contains(cmd, "~/.aider/test-session-123.chat.history.md") {
+		t.Errorf("Expected command to fall back to session ID history file, got: %v", cmd)
+	}
+}
+
+func TestAiderDiscoverSessionID_LatestSession(t *testing.T) {
+	tool := NewAider()
+	tmpDir := t.TempDir()
+
+	older := filepath.Join(tmpDir, "older"+aiderChatHistorySuffix)
+	newer := filepath.Join(tmpDir, "newer"+aiderChatHistorySuffix)
+	for _, path := range []string{older, newer, filepath.Join(tmpDir, "newer"+aiderInputHistorySuffix)} {
+		if err := os.WriteFile(path, []byte("# aider chat"), 0o644); err != nil {
+			t.Fatalf("Failed to create history file: %v", err)
+		}
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(older, past, past); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+
+	discovered := tool.DiscoverSessionID(tmpDir)
+	if discovered != "newer" {
+		t.Errorf("Expected session ID 'newer', got '%s'", discovered)
+	}
+}
+
+func TestAiderDiscoverSessionID_NoSession(t *testing.T) {
+	tool := NewAider()
+
+	discovered := tool.DiscoverSessionID(t.TempDir())
+	if discovered != "" {
+		t.Errorf("Expected empty session ID, got '%s'", discovered)
+	}
+
+	discovered = tool.DiscoverSessionID("/nonexistent/path")
+	if discovered != "" {
+		t.Errorf("Expected empty session ID for non-existent path, got '%s'", discovered)
+	}
+}
+
+func TestAiderOptionalInterfaces(t *testing.T) {
+	tool := NewAider()
+
+	if _, ok := tool.(ConfigFileTool); !ok {
+		t.Error("Expected aider to implement ConfigFileTool")
+	}
+
+	envTool, ok := tool.(EnvAuthTool)
+	if !ok {
+		t.Fatal("Expected aider to implement EnvAuthTool")
+	}
+
+	if !contains(envTool.AuthEnvVars(), "ANTHROPIC_API_KEY") {
+		t.Errorf("Expected ANTHROPIC_API_KEY in auth env vars, got: %v", envTool.AuthEnvVars())
+	}
+}
+
+func TestRegistryGet_Aider(t *testing.T) {
+	tool, err := Get("aider")
+	if err != nil {
+		t.Fatalf("Expected to get aider tool, got error: %v", err)
+	}
+
+	if tool.Name() != "aider" {
+		t.Errorf("Expected tool name 'aider', got '%s'", tool.Name())
+	}
+}
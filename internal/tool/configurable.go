@@ -0,0 +1,173 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// Placeholders supported in [tools.<name>] argument templates
+const (
+	sessionIDPlaceholder       = "{session_id}"
+	resumeSessionIDPlaceholder = "{resume_session_id}"
+)
+
+// toolNamePattern restricts tool names to ones safe for directory names (sessions-<name>)
+var toolNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// envVarPattern matches environment variable names
+var envVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ConfigurableTool implements Tool from a [tools.<name>] config table
+type ConfigurableTool struct {
+	name            string
+	def             config.ToolDefinition
+	sandboxSettings map[string]interface{}
+}
+
+// NewConfigurable creates a tool from a user-defined config table
+func NewConfigurable(name string, def config.ToolDefinition) (*ConfigurableTool, error) {
+	if !toolNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid tool name '%s' (use lowercase letters, digits, '-' and '_')", name)
+	}
+
+	// Config files are copied from the host home directory, so they must stay inside it
+	if def.ConfigDir != "" && (!filepath.IsLocal(def.ConfigDir) || filepath.Clean(def.ConfigDir) == ".") {
+		return nil, fmt.Errorf("tool '%s': config_dir must be a directory inside the home directory, not '%s'", name, def.ConfigDir)
+	}
+	for _, files := range []struct {
+		key   string
+		paths []string
+	}{{"config_files", def.ConfigFiles}, {"home_config_files", def.HomeConfigFiles}} {
+		if len(files.paths) > 0 && def.ConfigDir == "" {
+			return nil, fmt.Errorf("tool '%s': %s requires config_dir", name, files.key)
+		}
+		for _, p := range files.paths {
+			if !filepath.IsLocal(p) {
+				return nil, fmt.Errorf("tool '%s': %s entry '%s' must be a relative path without '..'", name, files.key, p)
+			}
+		}
+	}
+	for _, env := range def.EnvVars {
+		if !envVarPattern.MatchString(env) {
+			return nil, fmt.Errorf("tool '%s': invalid env_vars entry '%s'", name, env)
+		}
+	}
+
+	if def.SessionGlob != "" {
+		if def.ConfigDir == "" {
+			return nil, fmt.Errorf("tool '%s': session_glob requires config_dir", name)
+		}
+		if _, err := filepath.Match(def.SessionGlob, ""); err != nil {
+			return nil, fmt.Errorf("tool '%s': invalid session_glob: %w", name, err)
+		}
+	}
+
+	sandboxSettings := map[string]interface{}{}
+	if def.SandboxSettings != "" {
+		if def.ConfigDir == "" {
+			return nil, fmt.Errorf("tool '%s': sandbox_settings requires config_dir", name)
+		}
+		if err := json.Unmarshal([]byte(def.SandboxSettings), &sandboxSettings); err != nil {
+			return nil, fmt.Errorf("tool '%s': sandbox_settings must be a JSON object: %w", name, err)
+		}
+	}
+
+	return &ConfigurableTool{
+		name:            name,
+		def:             def,
+		sandboxSettings: sandboxSettings,
+	}, nil
+}
+
+func (c *ConfigurableTool) Name() string {
+	return c.name
+}
+
+func (c *ConfigurableTool) Binary() string {
+	if c.def.Binary != "" {
+		return c.def.Binary
+	}
+	return c.name
+}
+
+func (c *ConfigurableTool) ConfigDirName() string {
+	return c.def.ConfigDir
+}
+
+func (c *ConfigurableTool) SessionsDirName() string {
+	return "sessions-" + c.name
+}
+
+func (c *ConfigurableTool) BuildCommand(sessionID string, resume bool, resumeSessionID string) []string {
+	// Without a discovered session there is nothing to resume - start fresh with args
+	args := c.def.Args
+	if resume && resumeSessionID != "" && len(c.def.ResumeArgs) > 0 {
+		args = c.def.ResumeArgs
+	}
+
+	replacer := strings.NewReplacer(
+		sessionIDPlaceholder, sessionID,
+		resumeSessionIDPlaceholder, resumeSessionID,
+	)
+
+	cmd := []string{c.Binary()}
+	for _, arg := range args {
+		cmd = append(cmd, replacer.Replace(arg))
+	}
+
+	return cmd
+}
+
+func (c *ConfigurableTool) DiscoverSessionID(stateDir string) string {
+	if c.def.SessionGlob == "" {
+		return ""
+	}
+
+	matches, err := filepath.Glob(filepath.Join(stateDir, c.def.SessionGlob))
+	if err != nil {
+		return ""
+	}
+
+	// Pick the most recently written session file
+	var latestPath string
+	var latestMod int64
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if latestPath == "" || info.ModTime().UnixNano() > latestMod {
+			latestPath = match
+			latestMod = info.ModTime().UnixNano()
+		}
+	}
+
+	if latestPath == "" {
+		return ""
+	}
+
+	base := filepath.Base(latestPath)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+func (c *ConfigurableTool) GetSandboxSettings() map[string]interface{} {
+	return c.sandboxSettings
+}
+
+func (c *ConfigurableTool) ConfigFiles() []string {
+	return c.def.ConfigFiles
+}
+
+func (c *ConfigurableTool) HomeConfigFiles() []string {
+	return c.def.HomeConfigFiles
+}
+
+func (c *ConfigurableTool) AuthEnvVars() []string {
+	return c.def.EnvVars
+}
@@ -0,0 +1,261 @@
+package tool
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+func TestConfigurableToolBasics(t *testing.T) {
+	tool, err := NewConfigurable("codex", config.ToolDefinition{ConfigDir: ".codex"})
+	if err != nil {
+		t.Fatalf("NewConfigurable() failed: %v", err)
+	}
+
+	if tool.Name() != "codex" {
+		t.Errorf("Expected name 'codex', got '%s'", tool.Name())
+	}
+
+	// Binary defaults to the tool name
+	if tool.Binary() != "codex" {
+		t.Errorf("Expected binary 'codex', got '%s'", tool.Binary())
+	}
+
+	if tool.ConfigDirName() != ".codex" {
+		t.Errorf("Expected config dir '.codex', got '%s'", tool.ConfigDirName())
+	}
+
+	if tool.SessionsDirName() != "sessions-codex" {
+		t.Errorf("Expected sessions dir 'sessions-codex', got '%s'", tool.SessionsDirName())
+	}
+
+	if len(tool.GetSandboxSettings()) != 0 {
+		t.Errorf("Expected no sandbox settings, got: %v", tool.GetSandboxSettings())
+	}
+}
+
+func TestConfigurableBuildCommand(t *testing.T) {
+	tool, err := NewConfigurable("codex", config.ToolDefinition{
+		Binary:     "codex-cli",
+		Args:       []string{"--full-auto", "--tag", "{session_id}"},
+		ResumeArgs: []string{"resume", "{resume_session_id}"},
+	})
+	if err != nil {
+		t.Fatalf("NewConfigurable() failed: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		resume          bool
+		resumeSessionID string
+		expected        []string
+	}{
+		{
+			name:     "new session",
+			expected: []string{"codex-cli", "--full-auto", "--tag", "sess-1"},
+		},
+		{
+			name:            "resume with discovered session",
+			resume:          true,
+			resumeSessionID: "tool-42",
+			expected:        []string{"codex-cli", "resume", "tool-42"},
+		},
+		{
+			name:     "resume without discovered session starts fresh",
+			resume:   true,
+			expected: []string{"codex-cli", "--full-auto", "--tag", "sess-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := tool.BuildCommand("sess-1", tt.resume, tt.resumeSessionID)
+			if strings.Join(cmd, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("Expected %v, got %v", tt.expected, cmd)
+			}
+		})
+	}
+}
+
+func TestConfigurableDiscoverSessionID(t *testing.T) {
+	tool, err := NewConfigurable("codex", config.ToolDefinition{
+		ConfigDir:   ".codex",
+		SessionGlob: "sessions/*.jsonl",
+	})
+	if err != nil {
+		t.Fatalf("NewConfigurable() failed: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	sessionsDir := filepath.Join(tmpDir, "sessions")
+	if err := os.MkdirAll(sessionsDir, 0o755); err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	if discovered := tool.DiscoverSessionID(tmpDir); discovered != "" {
+		t.Errorf("Expected empty session ID, got '%s'", discovered)
+	}
+
+	older := filepath.Join(sessionsDir, "older.jsonl")
+	newer := filepath.Join(sessionsDir, "newer.jsonl")
+	for _, path := range []string{older, newer, filepath.Join(sessionsDir, "ignored.txt")} {
+		if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+			t.Fatalf("Failed to create session file: %v", err)
+		}
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(older, past, past); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+
+	if discovered := tool.DiscoverSessionID(tmpDir); discovered != "newer" {
+		t.Errorf("Expected session ID 'newer', got '%s'", discovered)
+	}
+}
+
+func TestConfigurableSandboxSettings(t *testing.T) {
+	tool, err := NewConfigurable("agent", config.ToolDefinition{
+		ConfigDir:       ".agent",
+		SandboxSettings: `{"approval": "never", "sandbox": {"enabled": false}}`,
+	})
+	if err != nil {
+		t.Fatalf("NewConfigurable() failed: %v", err)
+	}
+
+	settings := tool.GetSandboxSettings()
+	if settings["approval"] != "never" {
+		t.Errorf("Expected approval 'never', got '%v'", settings["approval"])
+	}
+
+	sandbox, ok := settings["sandbox"].(map[string]interface{})
+	if !ok || sandbox["enabled"] != false {
+		t.Errorf("Expected nested sandbox settings, got: %v", settings["sandbox"])
+	}
+}
+
+func TestNewConfigurableInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		toolID  string
+		def     config.ToolDefinition
+		wantErr string
+	}{
+		{
+			name:    "invalid name",
+			toolID:  "../evil",
+			wantErr: "invalid tool name",
+		},
+		{
+			name:    "invalid sandbox settings JSON",
+			toolID:  "agent",
+			def:     config.ToolDefinition{ConfigDir: ".agent", SandboxSettings: "{broken"},
+			wantErr: "sandbox_settings must be a JSON object",
+		},
+		{
+			name:    "sandbox settings without config dir",
+			toolID:  "agent",
+			def:     config.ToolDefinition{SandboxSettings: "{}"},
+			wantErr: "sandbox_settings requires config_dir",
+		},
+		{
+			name:    "invalid session glob",
+			toolID:  "agent",
+			def:     config.ToolDefinition{ConfigDir: ".agent", SessionGlob: "[bad"},
+			wantErr: "invalid session_glob",
+		},
+		{
+			name:    "config dir outside home",
+			toolID:  "agent",
+			def:     config.ToolDefinition{ConfigDir: "../other"},
+			wantErr: "config_dir must be a directory inside the home directory",
+		},
+		{
+			name:    "home as config dir",
+			toolID:  "agent",
+			def:     config.ToolDefinition{ConfigDir: "./"},
+			wantErr: "config_dir must be a directory inside the home directory",
+		},
+		{
+			name:    "config file outside config dir",
+			toolID:  "agent",
+			def:     config.ToolDefinition{ConfigDir: ".agent", ConfigFiles: []string{"../.ssh/id_rsa"}},
+			wantErr: "config_files entry '../.ssh/id_rsa' must be a relative path",
+		},
+		{
+			name:    "absolute home config file",
+			toolID:  "agent",
+			def:     config.ToolDefinition{ConfigDir: ".agent", HomeConfigFiles: []string{"/etc/shadow"}},
+			wantErr: "home_config_files entry '/etc/shadow' must be a relative path",
+		},
+		{
+			name:    "home config files without config dir",
+			toolID:  "agent",
+			def:     config.ToolDefinition{HomeConfigFiles: []string{".agent.yml"}},
+			wantErr: "home_config_files requires config_dir",
+		},
+		{
+			name:    "invalid env var",
+			toolID:  "agent",
+			def:     config.ToolDefinition{EnvVars: []string{"API_KEY=x"}},
+			wantErr: "invalid env_vars entry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewConfigurable(tt.toolID, tt.def)
+			if err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing '%s', got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRegisterDefinitions(t *testing.T) {
+	defs := map[string]config.ToolDefinition{
+		"opencode": {Args: []string{"--yolo"}},
+	}
+	if err := RegisterDefinitions(defs); err != nil {
+		t.Fatalf("RegisterDefinitions() failed: %v", err)
+	}
+	defer delete(registry, "opencode")
+
+	tool, err := Get("opencode")
+	if err != nil {
+		t.Fatalf("Expected to get opencode tool, got error: %v", err)
+	}
+
+	if _, ok := tool.(*ConfigurableTool); !ok {
+		t.Errorf("Expected *ConfigurableTool, got %T", tool)
+	}
+
+	if !contains(ListSupported(), "opencode") {
+		t.Errorf("Expected opencode in supported tools, got: %v", ListSupported())
+	}
+
+	// Registering again (e.g., config reloaded) replaces the definition
+	if err := RegisterDefinitions(defs); err != nil {
+		t.Errorf("Expected re-registration to succeed, got: %v", err)
+	}
+}
+
+func TestRegisterDefinitions_BuiltinConflict(t *testing.T) {
+	err := RegisterDefinitions(map[string]config.ToolDefinition{
+		"claude": {Binary: "not-claude"},
+	})
+	if err == nil {
+		t.Fatal("Expected error when redefining built-in tool, got nil")
+	}
+
+	tool, _ := Get("claude")
+	if tool.Binary() != "claude" {
+		t.Errorf("Expected built-in claude to be unchanged, got binary '%s'", tool.Binary())
+	}
+}
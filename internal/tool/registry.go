@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
 )
 
 // registry maps tool names to their factory functions
 var registry = map[string]func() Tool{
 	"claude": NewClaude,
+	"aider":  NewAider,
 }
 
 // Get returns a tool by name
@@ -20,6 +23,25 @@ func Get(name string) (Tool, error) {
 	return factory(), nil
 }
 
+// RegisterDefinitions registers user-defined tools from [tools.<name>] config tables
+// Built-in tools cannot be redefined
+func RegisterDefinitions(defs map[string]config.ToolDefinition) error {
+	for name, def := range defs {
+		if factory, ok := registry[name]; ok {
+			if _, configured := factory().(*ConfigurableTool); !configured {
+				return fmt.Errorf("tool '%s' is built in and cannot be redefined in [tools.%s]", name, name)
+			}
+		}
+
+		t, err := NewConfigurable(name, def)
+		if err != nil {
+			return err
+		}
+		registry[name] = func() Tool { return t }
+	}
+	return nil
+}
+
 // GetDefault returns the default tool (Claude)
 func GetDefault() Tool {
 	return NewClaude()
@@ -1,9 +1,14 @@
 package tool
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/mensfeld/code-on-incus/internal/transcript"
+	"github.com/mensfeld/code-on-incus/internal/usage"
 )
 
 // Tool represents an AI coding tool that can be run in COI containers
@@ -38,6 +43,36 @@ type Tool interface {
 	GetSandboxSettings() map[string]interface{}
 }
 
+// ConfigFileTool is an optional interface for tools whose config files differ
+// from Claude's (.credentials.json, config.yml, settings.json)
+type ConfigFileTool interface {
+	// ConfigFiles returns files inside ConfigDirName() to copy from the host
+	ConfigFiles() []string
+
+	// HomeConfigFiles returns files in the user's home directory to copy from the host
+	// (e.g., ".aider.conf.yml")
+	HomeConfigFiles() []string
+}
+
+// EnvAuthTool is an optional interface for tools that read API keys from the environment
+type EnvAuthTool interface {
+	// AuthEnvVars returns names of host environment variables to forward into the container
+	AuthEnvVars() []string
+}
+
+// TranscriptTool is an optional interface for tools whose conversations can be rendered
+// with 'coi session transcript'
+type TranscriptTool interface {
+	// ReadTranscript reads the conversations saved in stateDir, oldest first
+	ReadTranscript(stateDir string) ([]transcript.Conversation, error)
+}
+
+// UsageTool is an optional interface for tools that record token usage in their saved state
+type UsageTool interface {
+	// ReadUsage reads the usage of every API response saved in stateDir, once per response
+	ReadUsage(stateDir string) ([]usage.Record, error)
+}
+
 // ClaudeTool implements Tool for Claude Code
 type ClaudeTool struct{}
 
@@ -111,3 +146,71 @@ func (c *ClaudeTool) GetSandboxSettings() map[string]interface{} {
 		},
 	}
 }
+
+func (c *ClaudeTool) ReadTranscript(stateDir string) ([]transcript.Conversation, error) {
+	// Each conversation (one per /clear) is a .jsonl file in projects/-workspace/
+	projectsDir := filepath.Join(stateDir, "projects", "-workspace")
+
+	entries, err := os.ReadDir(projectsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read conversations: %w", err)
+	}
+
+	var conversations []transcript.Conversation
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jsonl") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(projectsDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open conversation: %w", err)
+		}
+		conv, err := transcript.ParseClaude(strings.TrimSuffix(entry.Name(), ".jsonl"), f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(conv.Entries) > 0 {
+			conversations = append(conversations, conv)
+		}
+	}
+
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].Start().Before(conversations[j].Start())
+	})
+	return conversations, nil
+}
+
+func (c *ClaudeTool) ReadUsage(stateDir string) ([]usage.Record, error) {
+	// Every conversation file records the model and usage of each response,
+	// including subagent conversations stored next to the main ones
+	projectsDir := filepath.Join(stateDir, "projects")
+
+	var records []usage.Record
+	err := filepath.WalkDir(projectsDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == projectsDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".jsonl") {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fileRecords, err := usage.ParseClaude(f)
+		records = append(records, fileRecords...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	return usage.Dedupe(records), nil
+}
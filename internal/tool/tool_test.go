@@ -147,6 +147,92 @@ func TestClaudeDiscoverSessionID_NonExistentDir(t *testing.T) {
 	}
 }
 
+func TestClaudeReadTranscript(t *testing.T) {
+	tool := NewClaude()
+
+	tmpDir := t.TempDir()
+	projectsDir := filepath.Join(tmpDir, "projects", "-workspace")
+	if err := os.MkdirAll(projectsDir, 0o755); err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	// Two conversations (e.g., before and after /clear), named out of order
+	files := map[string]string{
+		"later.jsonl":   `{"type":"user","timestamp":"2025-01-02T11:00:00Z","message":{"role":"user","content":"second"}}`,
+		"earlier.jsonl": `{"type":"user","timestamp":"2025-01-02T10:00:00Z","message":{"role":"user","content":"first"}}`,
+		"empty.jsonl":   `{"type":"summary","summary":"nothing"}`,
+		"notes.txt":     "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(projectsDir, name), []byte(content+"\n"), 0o644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	reader, ok := tool.(TranscriptTool)
+	if !ok {
+		t.Fatal("Claude should implement TranscriptTool")
+	}
+	conversations, err := reader.ReadTranscript(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadTranscript failed: %v", err)
+	}
+	if len(conversations) != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", len(conversations))
+	}
+	if conversations[0].ID != "earlier" || conversations[1].ID != "later" {
+		t.Errorf("Expected conversations oldest first, got %s, %s", conversations[0].ID, conversations[1].ID)
+	}
+
+	// No saved conversations is not an error
+	conversations, err = reader.ReadTranscript("/nonexistent/path")
+	if err != nil || len(conversations) != 0 {
+		t.Errorf("Expected no conversations for non-existent path, got %v (err %v)", conversations, err)
+	}
+}
+
+func TestClaudeReadUsage(t *testing.T) {
+	tool := NewClaude()
+
+	tmpDir := t.TempDir()
+	projectsDir := filepath.Join(tmpDir, "projects", "-workspace")
+	subagentsDir := filepath.Join(projectsDir, "conv", "subagents")
+	if err := os.MkdirAll(subagentsDir, 0o755); err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	// The same response recorded in two conversation files is counted once
+	response := `{"type":"assistant","timestamp":"2025-01-02T10:00:00Z","message":{"id":"msg_1","model":"claude-sonnet-4-5","usage":{"input_tokens":10,"output_tokens":5}}}`
+	subagent := `{"type":"assistant","timestamp":"2025-01-02T10:00:01Z","message":{"id":"msg_2","model":"claude-haiku-4-5","usage":{"input_tokens":3,"output_tokens":1}}}`
+	files := map[string]string{
+		filepath.Join(projectsDir, "conv.jsonl"):    response,
+		filepath.Join(projectsDir, "resumed.jsonl"): response,
+		filepath.Join(subagentsDir, "agent.jsonl"):  subagent,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content+"\n"), 0o644); err != nil {
+			t.Fatalf("Failed to create %s: %v", path, err)
+		}
+	}
+
+	reader, ok := tool.(UsageTool)
+	if !ok {
+		t.Fatal("Claude should implement UsageTool")
+	}
+	records, err := reader.ReadUsage(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadUsage failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 responses, got %d: %+v", len(records), records)
+	}
+
+	records, err = reader.ReadUsage("/nonexistent/path")
+	if err != nil || len(records) != 0 {
+		t.Errorf("Expected no usage for non-existent path, got %v (err %v)", records, err)
+	}
+}
+
 func TestClaudeGetSandboxSettings(t *testing.T) {
 	tool := NewClaude()
 
@@ -0,0 +1,167 @@
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// claudeRecord is a line of a Claude Code conversation file (projects/<project>/<id>.jsonl)
+type claudeRecord struct {
+	Type        string    `json:"type"` // "user", "assistant", "summary", ...
+	IsMeta      bool      `json:"isMeta"`
+	IsSidechain bool      `json:"isSidechain"` // Subagent (Task tool) messages
+	Timestamp   time.Time `json:"timestamp"`
+	Message     struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"` // A string or a list of content blocks
+	} `json:"message"`
+}
+
+// claudeBlock is a content block of a Claude message
+type claudeBlock struct {
+	Type      string          `json:"type"` // "text", "thinking", "tool_use", "tool_result", "image"
+	Text      string          `json:"text"`
+	Thinking  string          `json:"thinking"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"` // Tool result: a string or a list of blocks
+	IsError   bool            `json:"is_error"`
+}
+
+// ParseClaude reads a Claude Code conversation file
+// Meta messages (e.g., command caveats) and subagent messages are left out; unreadable lines are skipped
+func ParseClaude(id string, r io.Reader) (Conversation, error) {
+	conv := Conversation{ID: id}
+	toolNames := make(map[string]string) // Tool call ID -> tool name, to label results
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var record claudeRecord
+			if json.Unmarshal(line, &record) == nil && !record.IsMeta && !record.IsSidechain {
+				conv.Entries = append(conv.Entries, claudeEntries(record, toolNames)...)
+			}
+		}
+		if err == io.EOF {
+			return conv, nil
+		}
+		if err != nil {
+			return conv, fmt.Errorf("failed to read conversation: %w", err)
+		}
+	}
+}
+
+// claudeEntries converts a record's message to entries
+func claudeEntries(record claudeRecord, toolNames map[string]string) []Entry {
+	if record.Type != KindUser && record.Type != KindAssistant {
+		return nil
+	}
+
+	// Plain string content is a typed prompt (or, rarely, an assistant message)
+	var text string
+	if json.Unmarshal(record.Message.Content, &text) == nil {
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		return []Entry{{Kind: record.Type, Time: record.Timestamp, Text: text}}
+	}
+
+	var blocks []claudeBlock
+	if json.Unmarshal(record.Message.Content, &blocks) != nil {
+		return nil
+	}
+
+	var entries []Entry
+	for _, block := range blocks {
+		entry := Entry{Time: record.Timestamp}
+		switch block.Type {
+		case "text":
+			if strings.TrimSpace(block.Text) == "" {
+				continue
+			}
+			entry.Kind, entry.Text = record.Type, block.Text
+		case "thinking":
+			entry.Kind, entry.Text = KindThinking, block.Thinking
+		case "tool_use":
+			toolNames[block.ID] = block.Name
+			entry.Kind, entry.Tool, entry.ToolID, entry.Input = KindToolCall, block.Name, block.ID, block.Input
+			entry.Edit = claudeFileEdit(block.Name, block.Input)
+		case "tool_result":
+			entry.Kind, entry.ToolID, entry.IsError = KindToolResult, block.ToolUseID, block.IsError
+			entry.Tool = toolNames[block.ToolUseID]
+			entry.Text = claudeResultText(block.Content)
+		case "image":
+			entry.Kind, entry.Text = record.Type, "[image]"
+		default:
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// claudeResultText flattens a tool result's content (a string or a list of text and image blocks)
+func claudeResultText(content json.RawMessage) string {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return text
+	}
+
+	var blocks []claudeBlock
+	if json.Unmarshal(content, &blocks) != nil {
+		return ""
+	}
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, block.Text)
+		case "image":
+			parts = append(parts, "[image]")
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// claudeFileEdit returns the file change of an Edit, MultiEdit or Write call (nil for other tools)
+func claudeFileEdit(name string, input json.RawMessage) *FileEdit {
+	var args struct {
+		FilePath  string  `json:"file_path"`
+		OldString string  `json:"old_string"`
+		NewString string  `json:"new_string"`
+		Content   *string `json:"content"`
+		Edits     []struct {
+			OldString string `json:"old_string"`
+			NewString string `json:"new_string"`
+		} `json:"edits"`
+	}
+	if json.Unmarshal(input, &args) != nil || args.FilePath == "" {
+		return nil
+	}
+
+	edit := &FileEdit{Path: args.FilePath}
+	switch name {
+	case "Edit":
+		edit.Replacements = []Replacement{{Old: args.OldString, New: args.NewString}}
+	case "MultiEdit":
+		for _, e := range args.Edits {
+			edit.Replacements = append(edit.Replacements, Replacement{Old: e.OldString, New: e.NewString})
+		}
+	case "Write":
+		if args.Content == nil {
+			return nil
+		}
+		edit.Content = args.Content
+	default:
+		return nil
+	}
+	return edit
+}
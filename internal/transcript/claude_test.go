@@ -0,0 +1,97 @@
+package transcript
+
+import (
+	"strings"
+	"testing"
+)
+
+const claudeConversation = `{"type":"summary","summary":"Fix the tests"}
+{"type":"user","isMeta":true,"timestamp":"2025-01-02T10:00:00Z","message":{"role":"user","content":"Caveat: local commands"}}
+{"type":"user","timestamp":"2025-01-02T10:00:01Z","message":{"role":"user","content":"Fix the failing test"}}
+{"type":"assistant","timestamp":"2025-01-02T10:00:02Z","message":{"role":"assistant","content":[{"type":"thinking","thinking":"Run the tests first"},{"type":"text","text":"Running the tests."},{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"go test ./..."}}]}}
+{"type":"user","timestamp":"2025-01-02T10:00:03Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"FAIL"}],"is_error":true}]}}
+{"type":"assistant","isSidechain":true,"timestamp":"2025-01-02T10:00:04Z","message":{"role":"assistant","content":[{"type":"text","text":"Subagent work"}]}}
+not json
+{"type":"assistant","timestamp":"2025-01-02T10:00:05Z","message":{"role":"assistant","content":[{"type":"tool_use","id":"t2","name":"Edit","input":{"file_path":"/workspace/a.go","old_string":"x := 1","new_string":"x := 2"}}]}}
+{"type":"user","timestamp":"2025-01-02T10:00:06Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t2","content":"ok"}]}}
+`
+
+func TestParseClaude(t *testing.T) {
+	conv, err := ParseClaude("abc", strings.NewReader(claudeConversation))
+	if err != nil {
+		t.Fatalf("ParseClaude() failed: %v", err)
+	}
+	if conv.ID != "abc" {
+		t.Errorf("ID = %q, want abc", conv.ID)
+	}
+
+	want := []struct {
+		kind, tool, text string
+	}{
+		{KindUser, "", "Fix the failing test"},
+		{KindThinking, "", "Run the tests first"},
+		{KindAssistant, "", "Running the tests."},
+		{KindToolCall, "Bash", ""},
+		{KindToolResult, "Bash", "FAIL"},
+		{KindToolCall, "Edit", ""},
+		{KindToolResult, "Edit", "ok"},
+	}
+	if len(conv.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(conv.Entries), len(want), conv.Entries)
+	}
+	for i, w := range want {
+		got := conv.Entries[i]
+		if got.Kind != w.kind || got.Tool != w.tool || got.Text != w.text {
+			t.Errorf("entry %d = {%s %s %q}, want {%s %s %q}", i, got.Kind, got.Tool, got.Text, w.kind, w.tool, w.text)
+		}
+	}
+
+	if !conv.Entries[4].IsError {
+		t.Error("failed tool result should be marked as an error")
+	}
+	edit := conv.Entries[5].Edit
+	if edit == nil || edit.Path != "/workspace/a.go" || len(edit.Replacements) != 1 || edit.Replacements[0].New != "x := 2" {
+		t.Errorf("Edit call parsed as %+v", edit)
+	}
+	if start := conv.Start().UTC().Format("15:04:05"); start != "10:00:01" {
+		t.Errorf("Start() = %s, want 10:00:01", start)
+	}
+}
+
+func TestClaudeFileEdit(t *testing.T) {
+	tests := []struct {
+		name         string
+		tool         string
+		input        string
+		wantNil      bool
+		replacements int
+		content      string
+	}{
+		{"edit", "Edit", `{"file_path":"a","old_string":"1","new_string":"2"}`, false, 1, ""},
+		{"multi edit", "MultiEdit", `{"file_path":"a","edits":[{"old_string":"1","new_string":"2"},{"old_string":"3","new_string":"4"}]}`, false, 2, ""},
+		{"write", "Write", `{"file_path":"a","content":"hello\n"}`, false, 0, "hello\n"},
+		{"read", "Read", `{"file_path":"a"}`, true, 0, ""},
+		{"no path", "Edit", `{"old_string":"1","new_string":"2"}`, true, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edit := claudeFileEdit(tt.tool, []byte(tt.input))
+			if tt.wantNil {
+				if edit != nil {
+					t.Errorf("expected no edit, got %+v", edit)
+				}
+				return
+			}
+			if edit == nil {
+				t.Fatal("expected an edit")
+			}
+			if len(edit.Replacements) != tt.replacements {
+				t.Errorf("got %d replacements, want %d", len(edit.Replacements), tt.replacements)
+			}
+			if tt.content != "" && (edit.Content == nil || *edit.Content != tt.content) {
+				t.Errorf("content = %v, want %q", edit.Content, tt.content)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// Output formats
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatJSON     = "json"
+)
+
+// Formats lists the supported output formats
+var Formats = []string{FormatMarkdown, FormatHTML, FormatJSON}
+
+// Outputs (and file contents) longer than this many lines are collapsed
+var CollapseLines = 20
+
+// section is an entry prepared for display
+type section struct {
+	Heading   string
+	Time      time.Time
+	Prose     string // Text shown as written (prompts and messages)
+	Code      string // Text shown verbatim (commands, inputs, outputs, diffs)
+	Lang      string // Code language hint, e.g. "bash", "diff", "json"
+	Collapsed bool   // Hidden behind Summary until expanded
+	Summary   string
+	Error     bool
+}
+
+// Render writes a transcript in the given format
+func Render(w io.Writer, t *Transcript, format string) error {
+	switch format {
+	case FormatMarkdown:
+		return renderMarkdown(w, t)
+	case FormatHTML:
+		return renderHTML(w, t)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(t)
+	}
+	return fmt.Errorf("unknown format '%s': must be one of %s", format, strings.Join(Formats, ", "))
+}
+
+// sections prepares a conversation's entries for display
+func sections(entries []Entry) []section {
+	list := make([]section, 0, len(entries))
+	for _, entry := range entries {
+		s := section{Time: entry.Time}
+		switch entry.Kind {
+		case KindUser:
+			s.Heading, s.Prose = "User", entry.Text
+		case KindAssistant:
+			s.Heading, s.Prose = "Assistant", entry.Text
+		case KindThinking:
+			s.Heading, s.Prose = "Thinking", entry.Text
+			s.Collapsed, s.Summary = true, fmt.Sprintf("Thinking (%s)", lineCount(entry.Text))
+		case KindToolCall:
+			s = toolCallSection(entry)
+		case KindToolResult:
+			s.Heading, s.Code, s.Error = "Output", entry.Text, entry.IsError
+			if entry.Tool != "" {
+				s.Heading = "Output of " + entry.Tool
+			}
+			if entry.IsError {
+				s.Heading += " (error)"
+			}
+			collapse(&s, "Output")
+		default:
+			continue
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+// toolCallSection shows a tool call: commands and file edits readably, other inputs as JSON
+func toolCallSection(entry Entry) section {
+	s := section{Time: entry.Time, Heading: "Tool call: " + entry.Tool}
+
+	if edit := entry.Edit; edit != nil {
+		if edit.Content != nil {
+			s.Heading, s.Code = "Wrote "+edit.Path, *edit.Content
+			collapse(&s, "File")
+			return s
+		}
+		s.Heading, s.Code, s.Lang = "Edited "+edit.Path, editDiff(edit), "diff"
+		collapse(&s, "Diff")
+		return s
+	}
+
+	var bash struct {
+		Command     string `json:"command"`
+		Description string `json:"description"`
+	}
+	if entry.Tool == "Bash" && json.Unmarshal(entry.Input, &bash) == nil && bash.Command != "" {
+		s.Heading, s.Prose, s.Code, s.Lang = "Ran command", bash.Description, bash.Command, "bash"
+		return s
+	}
+
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, entry.Input, "", "  ") == nil {
+		s.Code, s.Lang = pretty.String(), "json"
+		collapse(&s, "Input")
+	}
+	return s
+}
+
+// editDiff shows replacements as removed and added lines
+func editDiff(edit *FileEdit) string {
+	var b strings.Builder
+	for i, r := range edit.Replacements {
+		if i > 0 {
+			b.WriteString("...\n")
+		}
+		for _, line := range splitLines(r.Old) {
+			b.WriteString("-" + line + "\n")
+		}
+		for _, line := range splitLines(r.New) {
+			b.WriteString("+" + line + "\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// collapse hides a section's code if it is longer than CollapseLines
+func collapse(s *section, what string) {
+	if len(splitLines(s.Code)) > CollapseLines {
+		s.Collapsed, s.Summary = true, fmt.Sprintf("%s (%s)", what, lineCount(s.Code))
+	}
+}
+
+// splitLines splits text into lines, ignoring a trailing newline
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// lineCount describes the length of a text, e.g. "42 lines"
+func lineCount(text string) string {
+	if n := len(splitLines(text)); n != 1 {
+		return fmt.Sprintf("%d lines", n)
+	}
+	return "1 line"
+}
+
+// conversationTitle names a conversation by its ID and start time
+func conversationTitle(conv Conversation) string {
+	if start := conv.Start(); !start.IsZero() {
+		return fmt.Sprintf("Conversation %s (%s)", conv.ID, start.Local().Format("2006-01-02 15:04"))
+	}
+	return "Conversation " + conv.ID
+}
+
+// renderMarkdown writes a transcript as Markdown, with collapsed parts in <details> blocks
+func renderMarkdown(w io.Writer, t *Transcript) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Session %s (%s)\n\n", t.SessionID, t.Tool)
+
+	for _, conv := range t.Conversations {
+		fmt.Fprintf(&b, "## %s\n\n", conversationTitle(conv))
+		for _, s := range sections(conv.Entries) {
+			b.WriteString("### " + s.Heading)
+			if !s.Time.IsZero() {
+				b.WriteString(" · " + s.Time.Local().Format("15:04:05"))
+			}
+			b.WriteString("\n\n")
+
+			if s.Collapsed {
+				fmt.Fprintf(&b, "<details><summary>%s</summary>\n\n", template.HTMLEscapeString(s.Summary))
+			}
+			if s.Prose != "" {
+				b.WriteString(strings.TrimRight(s.Prose, "\n") + "\n\n")
+			}
+			if s.Code != "" {
+				fence := codeFence(s.Code)
+				fmt.Fprintf(&b, "%s%s\n%s\n%s\n\n", fence, s.Lang, strings.TrimRight(s.Code, "\n"), fence)
+			}
+			if s.Collapsed {
+				b.WriteString("</details>\n\n")
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, strings.TrimRight(b.String(), "\n")+"\n")
+	return err
+}
+
+// codeFence returns a backtick fence longer than any backtick run in the code
+func codeFence(code string) string {
+	longest, run := 0, 0
+	for _, r := range code {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// htmlTemplate renders a transcript as a standalone page
+var htmlTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"sections": sections,
+	"title":    conversationTitle,
+	"clock":    func(t time.Time) string { return t.Local().Format("15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Session {{.SessionID}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 60rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; }
+h3 { font-size: 0.95rem; margin: 1.5rem 0 0.5rem; color: #57606a; }
+h3 time { font-weight: normal; margin-left: 0.5rem; }
+.prose { white-space: pre-wrap; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; border-radius: 6px; }
+.error pre { background: #ffebe9; }
+summary { cursor: pointer; color: #0969da; }
+</style>
+</head>
+<body>
+<h1>Session {{.SessionID}} ({{.Tool}})</h1>
+{{- range .Conversations}}
+<h2>{{title .}}</h2>
+{{- range sections .Entries}}
+<section{{if .Error}} class="error"{{end}}>
+<h3>{{.Heading}}{{if not .Time.IsZero}}<time>{{clock .Time}}</time>{{end}}</h3>
+{{- if .Collapsed}}<details><summary>{{.Summary}}</summary>{{end}}
+{{- if .Prose}}<div class="prose">{{.Prose}}</div>{{end}}
+{{- if .Code}}<pre><code{{if .Lang}} class="language-{{.Lang}}"{{end}}>{{.Code}}</code></pre>{{end}}
+{{- if .Collapsed}}</details>{{end}}
+</section>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// renderHTML writes a transcript as a standalone HTML page
+func renderHTML(w io.Writer, t *Transcript) error {
+	return htmlTemplate.Execute(w, t)
+}
@@ -0,0 +1,100 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func sampleTranscript() *Transcript {
+	var long strings.Builder
+	for i := 0; i < CollapseLines+5; i++ {
+		fmt.Fprintf(&long, "line %d\n", i)
+	}
+	content := "package main\n"
+	return &Transcript{
+		SessionID: "s1",
+		Tool:      "claude",
+		Conversations: []Conversation{{
+			ID: "c1",
+			Entries: []Entry{
+				{Kind: KindUser, Text: "Show <b>the</b> logs"},
+				{Kind: KindToolCall, Tool: "Bash", ToolID: "t1", Input: json.RawMessage(`{"command":"cat log"}`)},
+				{Kind: KindToolResult, Tool: "Bash", ToolID: "t1", Text: long.String()},
+				{Kind: KindToolCall, Tool: "Edit", ToolID: "t2", Input: json.RawMessage(`{}`), Edit: &FileEdit{Path: "a.go", Replacements: []Replacement{{Old: "x := 1", New: "x := 2"}}}},
+				{Kind: KindToolCall, Tool: "Write", ToolID: "t3", Input: json.RawMessage(`{}`), Edit: &FileEdit{Path: "b.go", Content: &content}},
+				{Kind: KindToolCall, Tool: "Grep", ToolID: "t4", Input: json.RawMessage(`{"pattern":"TODO"}`)},
+				{Kind: KindToolResult, Tool: "Grep", ToolID: "t4", Text: "no ``` matches", IsError: true},
+			},
+		}},
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Render(&buf, sampleTranscript(), FormatMarkdown); err != nil {
+		t.Fatalf("Render() failed: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# Session s1 (claude)",
+		"### User\n\nShow <b>the</b> logs",
+		"```bash\ncat log\n```",
+		fmt.Sprintf("<details><summary>Output (%d lines)</summary>", CollapseLines+5),
+		"### Edited a.go\n\n```diff\n-x := 1\n+x := 2\n```",
+		"### Wrote b.go\n\n```\npackage main\n```",
+		"```json\n{\n  \"pattern\": \"TODO\"\n}\n```",
+		"### Output of Grep (error)\n\n````\nno ``` matches\n````",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("markdown is missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "<details>") != 1 {
+		t.Errorf("expected only the long output to be collapsed:\n%s", out)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Render(&buf, sampleTranscript(), FormatHTML); err != nil {
+		t.Fatalf("Render() failed: %v", err)
+	}
+	out := buf.String()
+
+	if strings.Contains(out, "<b>the</b>") {
+		t.Error("prompt text should be escaped")
+	}
+	for _, want := range []string{"Show &lt;b&gt;the&lt;/b&gt; logs", "<details><summary>Output (25 lines)</summary>", `<section class="error">`, `class="language-diff"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("html is missing %q", want)
+		}
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Render(&buf, sampleTranscript(), FormatJSON); err != nil {
+		t.Fatalf("Render() failed: %v", err)
+	}
+
+	var got Transcript
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	if got.SessionID != "s1" || len(got.Conversations) != 1 || len(got.Conversations[0].Entries) != 7 {
+		t.Errorf("unexpected round trip: %+v", got)
+	}
+	if edit := got.Conversations[0].Entries[3].Edit; edit == nil || edit.Path != "a.go" {
+		t.Errorf("edit lost in round trip: %+v", edit)
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	if err := Render(&bytes.Buffer{}, sampleTranscript(), "pdf"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,63 @@
+package transcript
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Entry kinds
+const (
+	KindUser       = "user"        // Prompt typed by the user
+	KindAssistant  = "assistant"   // Text written by the agent
+	KindThinking   = "thinking"    // The agent's extended thinking
+	KindToolCall   = "tool_call"   // Tool invoked by the agent
+	KindToolResult = "tool_result" // Output of a tool call
+)
+
+// Transcript is the readable history of a saved session
+type Transcript struct {
+	SessionID     string         `json:"session_id"` // COI session ID
+	Tool          string         `json:"tool"`
+	Conversations []Conversation `json:"conversations"` // Oldest first
+}
+
+// Conversation is one conversation of the tool (a session can hold several, e.g. after /clear)
+type Conversation struct {
+	ID      string  `json:"id"` // The tool's conversation ID
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is a single step of a conversation
+type Entry struct {
+	Kind    string          `json:"kind"`
+	Time    time.Time       `json:"time"`
+	Text    string          `json:"text,omitempty"`     // Prompt, message, thinking or tool output
+	Tool    string          `json:"tool,omitempty"`     // Tool name (calls and results)
+	ToolID  string          `json:"tool_id,omitempty"`  // Links a result to its call
+	Input   json.RawMessage `json:"input,omitempty"`    // Tool call input
+	IsError bool            `json:"is_error,omitempty"` // Tool result reported an error
+	Edit    *FileEdit       `json:"edit,omitempty"`     // File change made by the tool call
+}
+
+// FileEdit is a file change made by a tool call
+type FileEdit struct {
+	Path         string        `json:"path"`
+	Replacements []Replacement `json:"replacements,omitempty"` // Edited parts of the file
+	Content      *string       `json:"content,omitempty"`      // Whole file written
+}
+
+// Replacement replaces Old with New in a file
+type Replacement struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Start returns the time of a conversation's first entry (zero if unknown)
+func (c Conversation) Start() time.Time {
+	for _, entry := range c.Entries {
+		if !entry.Time.IsZero() {
+			return entry.Time
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,63 @@
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// claudeRecord is the part of a Claude Code conversation line that holds usage
+type claudeRecord struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   struct {
+		ID    string `json:"id"`
+		Model string `json:"model"`
+		Usage *struct {
+			InputTokens              int64 `json:"input_tokens"`
+			OutputTokens             int64 `json:"output_tokens"`
+			CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+			CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+}
+
+// ParseClaude reads the usage of the assistant messages in a Claude Code conversation file
+// Subagent messages are included (they are billed too); unreadable lines are skipped.
+// The records are not deduplicated, see Dedupe.
+func ParseClaude(r io.Reader) ([]Record, error) {
+	var records []Record
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var record claudeRecord
+			// Responses made up by Claude Code itself (e.g., errors) use the "<synthetic>" model
+			if json.Unmarshal(line, &record) == nil && record.Type == "assistant" &&
+				record.Message.Usage != nil && record.Message.Model != "<synthetic>" {
+				u := record.Message.Usage
+				records = append(records, Record{
+					ID:    record.Message.ID,
+					Time:  record.Timestamp,
+					Model: record.Message.Model,
+					Tokens: Tokens{
+						Input:      u.InputTokens,
+						Output:     u.OutputTokens,
+						CacheWrite: u.CacheCreationInputTokens,
+						CacheRead:  u.CacheReadInputTokens,
+					},
+				})
+			}
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("failed to read conversation: %w", err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package usage
+
+import (
+	"strings"
+	"testing"
+)
+
+// A streamed response is saved once per content block, with the same message ID
+const claudeConversation = `{"type":"user","timestamp":"2025-01-02T10:00:00Z","message":{"role":"user","content":"hi"}}
+{"type":"assistant","timestamp":"2025-01-02T10:00:01Z","message":{"id":"msg_1","model":"claude-sonnet-4-5-20250929","usage":{"input_tokens":10,"output_tokens":5,"cache_creation_input_tokens":100,"cache_read_input_tokens":1000}}}
+{"type":"assistant","timestamp":"2025-01-02T10:00:02Z","message":{"id":"msg_1","model":"claude-sonnet-4-5-20250929","usage":{"input_tokens":10,"output_tokens":20,"cache_creation_input_tokens":100,"cache_read_input_tokens":1000}}}
+{"type":"assistant","isSidechain":true,"timestamp":"2025-01-02T10:00:03Z","message":{"id":"msg_2","model":"claude-haiku-4-5","usage":{"input_tokens":7,"output_tokens":3}}}
+{"type":"assistant","timestamp":"2025-01-02T10:00:04Z","message":{"id":"msg_3","model":"<synthetic>","usage":{"input_tokens":0,"output_tokens":0}}}
+{"type":"summary","summary":"Greeting"}
+not json
+`
+
+func TestParseClaude(t *testing.T) {
+	records, err := ParseClaude(strings.NewReader(claudeConversation))
+	if err != nil {
+		t.Fatalf("ParseClaude() failed: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3 (synthetic responses skipped): %+v", len(records), records)
+	}
+
+	records = Dedupe(records)
+	if len(records) != 2 {
+		t.Fatalf("got %d records after Dedupe, want 2: %+v", len(records), records)
+	}
+	want := Tokens{Input: 10, Output: 20, CacheWrite: 100, CacheRead: 1000}
+	if records[0].ID != "msg_1" || records[0].Tokens != want {
+		t.Errorf("streamed response = %+v, want the last block's usage %+v", records[0], want)
+	}
+	if records[1].Model != "claude-haiku-4-5" || records[1].Tokens.Input != 7 {
+		t.Errorf("subagent response = %+v", records[1])
+	}
+}
@@ -0,0 +1,179 @@
+package usage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+// Tokens counts the tokens of one or more API responses
+type Tokens struct {
+	Input      int64 `json:"input"`
+	Output     int64 `json:"output"`
+	CacheWrite int64 `json:"cache_write"` // Prompt cache writes
+	CacheRead  int64 `json:"cache_read"`  // Prompt cache hits
+}
+
+// Add adds other's counts
+func (t *Tokens) Add(other Tokens) {
+	t.Input += other.Input
+	t.Output += other.Output
+	t.CacheWrite += other.CacheWrite
+	t.CacheRead += other.CacheRead
+}
+
+// Total returns the sum of all counts
+func (t Tokens) Total() int64 {
+	return t.Input + t.Output + t.CacheWrite + t.CacheRead
+}
+
+// Record is the usage of a single API response
+type Record struct {
+	ID     string    // Message ID (empty if unknown)
+	Time   time.Time // When the response was received
+	Model  string
+	Tokens Tokens
+}
+
+// Totals sums the usage of a set of records
+type Totals struct {
+	Tokens
+	Messages int      `json:"messages"`
+	Cost     float64  `json:"cost_usd"`                  // Estimated from the price table
+	Unpriced []string `json:"unpriced_models,omitempty"` // Models without a price, left out of Cost
+}
+
+// Dedupe keeps the last record of each message ID, in the order of first appearance
+// A streamed response is saved once per content block, each with the usage of the whole response
+func Dedupe(records []Record) []Record {
+	index := make(map[string]int)
+	result := make([]Record, 0, len(records))
+	for _, record := range records {
+		if record.ID == "" {
+			result = append(result, record)
+			continue
+		}
+		if i, ok := index[record.ID]; ok {
+			result[i] = record
+			continue
+		}
+		index[record.ID] = len(result)
+		result = append(result, record)
+	}
+	return result
+}
+
+// Since returns the records at or after t (all records if t is zero)
+func Since(records []Record, t time.Time) []Record {
+	if t.IsZero() {
+		return records
+	}
+	var result []Record
+	for _, record := range records {
+		if !record.Time.Before(t) {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// Summarize totals records and estimates their cost
+func Summarize(records []Record, prices map[string]config.PriceConfig) Totals {
+	var totals Totals
+	unpriced := make(map[string]bool)
+	for _, record := range records {
+		totals.Add(record.Tokens)
+		totals.Messages++
+
+		price, ok := PriceFor(record.Model, prices)
+		if !ok {
+			unpriced[record.Model] = true
+			continue
+		}
+		totals.Cost += Cost(record.Tokens, price)
+	}
+	totals.Cost = math.Round(totals.Cost*1e6) / 1e6 // Whole micro-dollars, without float noise
+
+	for model := range unpriced {
+		totals.Unpriced = append(totals.Unpriced, model)
+	}
+	sort.Strings(totals.Unpriced)
+	return totals
+}
+
+// PriceFor returns the price of a model, looked up by its ID and then by its family
+// A family without a minor version ("claude-opus-4") is also looked up as version 0 ("claude-opus-4-0");
+// prices are not shared between families, so a new model version is unpriced until it is added
+func PriceFor(model string, prices map[string]config.PriceConfig) (config.PriceConfig, bool) {
+	family := modelFamily(model)
+	for _, key := range []string{model, family, family + "-0"} {
+		if price, ok := prices[key]; ok {
+			return price, true
+		}
+	}
+	return config.PriceConfig{}, false
+}
+
+// modelFamily returns a model ID without its snapshot date or alias suffix, e.g.
+// "claude-opus-4-1-20250805", "claude-opus-4-1@20250805" and "claude-opus-4-1-latest" -> "claude-opus-4-1"
+func modelFamily(model string) string {
+	if i := strings.Index(model, "@"); i != -1 {
+		model = model[:i]
+	}
+	model = strings.TrimSuffix(model, "-latest")
+	if i := strings.LastIndex(model, "-"); i != -1 && len(model)-i-1 == 8 {
+		if _, err := strconv.Atoi(model[i+1:]); err == nil {
+			model = model[:i]
+		}
+	}
+	return model
+}
+
+// Cost returns the cost of tokens in USD
+func Cost(tokens Tokens, price config.PriceConfig) float64 {
+	return (float64(tokens.Input)*price.Input +
+		float64(tokens.Output)*price.Output +
+		float64(tokens.CacheWrite)*price.CacheWrite +
+		float64(tokens.CacheRead)*price.CacheRead) / 1e6
+}
+
+// ParseSince parses a period ("7d", "2w", "12h", "30m") or a date ("2025-01-02") into the time it starts
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+
+	if len(value) >= 2 {
+		n, err := strconv.Atoi(value[:len(value)-1])
+		if err == nil && n >= 0 {
+			switch value[len(value)-1] {
+			case 'd':
+				return now.AddDate(0, 0, -n), nil
+			case 'w':
+				return now.AddDate(0, 0, -7*n), nil
+			}
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid period '%s': use e.g. 7d, 2w, 12h or a date (2025-01-02)", value)
+}
+
+// FormatTokens formats a token count briefly, e.g. "1.2M"
+func FormatTokens(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fK", float64(n)/1e3)
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,121 @@
+package usage
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mensfeld/code-on-incus/internal/config"
+)
+
+var testPrices = map[string]config.PriceConfig{
+	"claude-opus-4-0":   {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-opus-4-1":   {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-opus-4-5":   {Input: 5, Output: 25, CacheWrite: 6.25, CacheRead: 0.50},
+	"claude-sonnet-4-5": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+}
+
+func TestPriceFor(t *testing.T) {
+	tests := []struct {
+		model string
+		input float64
+		found bool
+	}{
+		{"claude-sonnet-4-5", 3, true},
+		{"claude-sonnet-4-5-20250929", 3, true},
+		{"claude-opus-4-1-20250805", 15, true},
+		{"claude-opus-4-1@20250805", 15, true},
+		{"claude-opus-4-5-20251101", 5, true},
+		{"claude-opus-4-20250514", 15, true}, // claude-opus-4-0
+		{"claude-3-7-sonnet-latest", 3, true},
+		{"claude-opus-4-6", 0, false}, // Newer versions are not priced as an older one
+		{"claude-opus-4-6-20260205", 0, false},
+		{"claude-sonnet-4-20250514", 0, false},
+		{"gpt-4o", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			price, found := PriceFor(tt.model, testPrices)
+			if found != tt.found || price.Input != tt.input {
+				t.Errorf("PriceFor(%q) = %v, %v; want input %v, %v", tt.model, price, found, tt.input, tt.found)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	records := []Record{
+		{Model: "claude-sonnet-4-5", Tokens: Tokens{Input: 1_000_000, Output: 1_000_000}},
+		{Model: "claude-sonnet-4-5", Tokens: Tokens{CacheWrite: 1_000_000, CacheRead: 1_000_000}},
+		{Model: "local-model", Tokens: Tokens{Input: 500}},
+	}
+
+	totals := Summarize(records, testPrices)
+	if totals.Messages != 3 || totals.Input != 1_000_500 || totals.Total() != 4_000_500 {
+		t.Errorf("unexpected totals: %+v", totals)
+	}
+	if want := 3 + 15 + 3.75 + 0.30; math.Abs(totals.Cost-want) > 1e-9 {
+		t.Errorf("Cost = %v, want %v", totals.Cost, want)
+	}
+	if len(totals.Unpriced) != 1 || totals.Unpriced[0] != "local-model" {
+		t.Errorf("Unpriced = %v, want [local-model]", totals.Unpriced)
+	}
+}
+
+func TestSince(t *testing.T) {
+	day := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	records := []Record{{ID: "old", Time: day.Add(-time.Hour)}, {ID: "new", Time: day}}
+
+	if got := Since(records, time.Time{}); len(got) != 2 {
+		t.Errorf("zero time should keep all records, got %d", len(got))
+	}
+	if got := Since(records, day); len(got) != 1 || got[0].ID != "new" {
+		t.Errorf("Since() = %+v, want only the new record", got)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"7d", now.AddDate(0, 0, -7), false},
+		{"2w", now.AddDate(0, 0, -14), false},
+		{"12h", now.Add(-12 * time.Hour), false},
+		{"2025-03-01", time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local), false},
+		{"-3d", time.Time{}, true},
+		{"soon", time.Time{}, true},
+		{"d", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSince(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSince(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseSince(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatTokens(t *testing.T) {
+	tests := map[int64]string{
+		0:             "0",
+		999:           "999",
+		1_500:         "1.5K",
+		2_340_000:     "2.3M",
+		7_000_000_000: "7.0B",
+	}
+	for n, want := range tests {
+		if got := FormatTokens(n); got != want {
+			t.Errorf("FormatTokens(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -69,7 +69,7 @@ install_base_dependencies() {
     apt-get update -qq
 
     DEBIAN_FRONTEND=noninteractive apt-get install -y -qq \
-        curl wget git ca-certificates gnupg jq unzip sudo \
+        curl wget git ca-certificates gnupg jq unzip sudo openssh-client \
         tmux \
         dnsutils \
         build-essential libssl-dev libreadline-dev zlib1g-dev \